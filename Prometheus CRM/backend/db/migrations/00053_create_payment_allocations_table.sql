@@ -0,0 +1,23 @@
+-- +goose Up
+-- Распределение поступлений по строкам графика платежей
+CREATE TABLE IF NOT EXISTS public.payment_allocations (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    planned_payment_id INTEGER NOT NULL REFERENCES public.planned_payments(id) ON DELETE CASCADE,
+    source_type VARCHAR(50) NOT NULL, -- 'payment_fact' или 'contract_payment'
+    source_id INTEGER NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    allocated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+COMMENT ON TABLE public.payment_allocations IS 'Какое поступление закрыло какую строку графика платежей';
+
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_contract_id ON public.payment_allocations(contract_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_planned_payment_id ON public.payment_allocations(planned_payment_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_source ON public.payment_allocations(source_type, source_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_deleted_at ON public.payment_allocations(deleted_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS public.payment_allocations;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- Журнал входящих вебхуков (1С и другие внешние системы)
+CREATE TABLE IF NOT EXISTS public.inbound_webhooks (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    service_name VARCHAR(100) NOT NULL,
+    external_id VARCHAR(255),
+    signature VARCHAR(255),
+    payload TEXT,
+    status VARCHAR(50) NOT NULL, -- received, processed, duplicate, failed, rejected
+    response_code INTEGER,
+    response JSONB,
+    error TEXT,
+    replay_of_id INTEGER REFERENCES public.inbound_webhooks(id) ON DELETE SET NULL,
+    processed_at TIMESTAMPTZ
+);
+COMMENT ON TABLE public.inbound_webhooks IS 'Каждая доставка входящего вебхука с телом запроса и результатом обработки';
+
+CREATE INDEX IF NOT EXISTS idx_inbound_webhooks_service_name ON public.inbound_webhooks(service_name);
+CREATE INDEX IF NOT EXISTS idx_inbound_webhooks_external_id ON public.inbound_webhooks(external_id);
+CREATE INDEX IF NOT EXISTS idx_inbound_webhooks_status ON public.inbound_webhooks(status);
+CREATE INDEX IF NOT EXISTS idx_inbound_webhooks_deleted_at ON public.inbound_webhooks(deleted_at);
+
+-- ID транзакции из 1С у фактического платежа: повторная доставка не должна зачислить деньги дважды
+ALTER TABLE public.payment_facts ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_facts_external_id ON public.payment_facts(external_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payment_facts_external_id;
+ALTER TABLE public.payment_facts DROP COLUMN IF EXISTS external_id;
+DROP TABLE IF EXISTS public.inbound_webhooks;
@@ -0,0 +1,185 @@
+-- +goose Up
+-- Единый журнал расчетов по договорам. Проводки неизменяемы: исправления делаются сторно.
+-- Сумма со знаком: "+" увеличивает долг (начисление, возврат), "-" уменьшает (оплата, скидка).
+-- Проводка-оплата - единственная запись о поступлении: она хранит реквизиты платежа,
+-- распределение по графику ссылается на нее, payment_facts и contract_payments остаются только для истории.
+CREATE TABLE IF NOT EXISTS public.ledger_entries (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN ('charge', 'payment', 'discount', 'refund', 'adjustment', 'reversal')),
+    amount NUMERIC(12, 2) NOT NULL,
+    entry_date DATE NOT NULL,
+    description TEXT,
+    source_type VARCHAR(50),
+    source_id INTEGER,
+    commission NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    payment_method VARCHAR(255),
+    payment_form_id INTEGER REFERENCES public.payment_forms(id),
+    academic_year VARCHAR(100),
+    external_id VARCHAR(255),
+    reverses_id INTEGER UNIQUE REFERENCES public.ledger_entries(id),
+    created_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+COMMENT ON TABLE public.ledger_entries IS 'Журнал расчетов по договорам: начисления, оплаты, скидки, возвраты, корректировки и сторно';
+COMMENT ON COLUMN public.ledger_entries.external_id IS 'ID транзакции во внешней системе (1С), защищает от повторного зачисления';
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_contract_id ON public.ledger_entries(contract_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_source ON public.ledger_entries(source_type, source_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_reverses_id ON public.ledger_entries(reverses_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_external_id ON public.ledger_entries(external_id);
+
+-- 1. Начисления и скидки по действующим договорам
+INSERT INTO public.ledger_entries (contract_id, entry_type, amount, entry_date, description, source_type, source_id)
+SELECT c.id, 'charge', c.total_amount, COALESCE(c.start_date, c.created_at, NOW())::date,
+       'Начисление по договору ' || c.contract_number, 'contract', c.id
+FROM public.contracts c
+WHERE c.deleted_at IS NULL AND c.total_amount <> 0;
+
+INSERT INTO public.ledger_entries (contract_id, entry_type, amount, entry_date, description, source_type, source_id)
+SELECT c.id, 'discount', -(c.total_amount - c.discounted_amount), COALESCE(c.start_date, c.created_at, NOW())::date,
+       'Скидка ' || c.discount_percentage || '%', 'contract', c.id
+FROM public.contracts c
+WHERE c.deleted_at IS NULL AND c.total_amount - c.discounted_amount <> 0;
+
+-- 2. Фактические платежи (payment_facts) вместе с реквизитами
+INSERT INTO public.ledger_entries (contract_id, entry_type, amount, entry_date, description, source_type, source_id,
+                                   commission, payment_method, academic_year, external_id)
+SELECT pf.contract_id, 'payment', -pf.amount, pf.payment_date, pf.payment_name, 'payment_fact', pf.id,
+       COALESCE(pf.commission, 0), pf.payment_method, pf.academic_year, pf.external_id
+FROM public.payment_facts pf
+JOIN public.contracts c ON c.id = pf.contract_id AND c.deleted_at IS NULL
+WHERE pf.deleted_at IS NULL AND pf.amount <> 0;
+
+-- 3. Оплаты по договору (contract_payments) с формой оплаты
+INSERT INTO public.ledger_entries (contract_id, entry_type, amount, entry_date, description, source_type, source_id,
+                                   payment_form_id, payment_method)
+SELECT cp.contract_id, 'payment', -cp.amount, cp.payment_date::date, cp.comment, 'contract_payment', cp.id,
+       cp.payment_form_id, f.name
+FROM public.contract_payments cp
+JOIN public.contracts c ON c.id = cp.contract_id AND c.deleted_at IS NULL
+LEFT JOIN public.payment_forms f ON f.id = cp.payment_form_id
+WHERE cp.deleted_at IS NULL AND cp.amount <> 0;
+
+-- 4. Распределение по графику ссылается на проводку-оплату
+UPDATE public.payment_allocations pa
+SET source_type = 'ledger_entry', source_id = le.id
+FROM public.ledger_entries le
+WHERE le.entry_type = 'payment' AND le.source_type = pa.source_type AND le.source_id = pa.source_id;
+
+-- 5. Оплаты, отмеченные в графике без записи распределения (до появления payment_allocations
+-- или вручную), распределяются на оплаты договора в порядке FIFO: строки графика по дате,
+-- оплаты по дате поступления, каждая оплата - не больше своего нераспределенного остатка.
+-- Доля строки на оплате - пересечение их накопительных интервалов.
+WITH allocated AS (
+    SELECT planned_payment_id, SUM(amount) AS amount
+    FROM public.payment_allocations
+    WHERE deleted_at IS NULL
+    GROUP BY planned_payment_id
+), used AS (
+    SELECT source_id, SUM(amount) AS amount
+    FROM public.payment_allocations
+    WHERE deleted_at IS NULL AND source_type = 'ledger_entry'
+    GROUP BY source_id
+), gaps AS (
+    SELECT pp.id, pp.contract_id, pp.paid_amount - COALESCE(a.amount, 0) AS gap,
+           SUM(pp.paid_amount - COALESCE(a.amount, 0)) OVER (PARTITION BY pp.contract_id ORDER BY pp.payment_date, pp.id) AS gap_end
+    FROM public.planned_payments pp
+    JOIN public.contracts c ON c.id = pp.contract_id AND c.deleted_at IS NULL
+    LEFT JOIN allocated a ON a.planned_payment_id = pp.id
+    WHERE pp.deleted_at IS NULL AND pp.paid_amount - COALESCE(a.amount, 0) > 0
+), free AS (
+    SELECT le.id, le.contract_id, -le.amount - COALESCE(u.amount, 0) AS free,
+           SUM(-le.amount - COALESCE(u.amount, 0)) OVER (PARTITION BY le.contract_id ORDER BY le.entry_date, le.id) AS free_end
+    FROM public.ledger_entries le
+    LEFT JOIN used u ON u.source_id = le.id
+    WHERE le.entry_type = 'payment' AND -le.amount - COALESCE(u.amount, 0) > 0
+)
+INSERT INTO public.payment_allocations (created_at, updated_at, contract_id, planned_payment_id, source_type, source_id, amount, allocated_at)
+SELECT NOW(), NOW(), g.contract_id, g.id, 'ledger_entry', f.id,
+       LEAST(g.gap_end, f.free_end) - GREATEST(g.gap_end - g.gap, f.free_end - f.free), NOW()
+FROM gaps g
+JOIN free f ON f.contract_id = g.contract_id
+WHERE LEAST(g.gap_end, f.free_end) > GREATEST(g.gap_end - g.gap, f.free_end - f.free);
+
+-- 6. Оплата в графике, которую не покрыли поступления, не сбрасывается: она переносится
+-- в журнал отдельной проводкой-оплатой ("planned_payment") и распределяется на свою строку.
+WITH allocated AS (
+    SELECT planned_payment_id, SUM(amount) AS amount
+    FROM public.payment_allocations
+    WHERE deleted_at IS NULL
+    GROUP BY planned_payment_id
+), transferred AS (
+    INSERT INTO public.ledger_entries (contract_id, entry_type, amount, entry_date, description, source_type, source_id)
+    SELECT pp.contract_id, 'payment', -(pp.paid_amount - COALESCE(a.amount, 0)), pp.payment_date,
+           'Перенос оплаты из графика: ' || COALESCE(pp.payment_name, ''), 'planned_payment', pp.id
+    FROM public.planned_payments pp
+    JOIN public.contracts c ON c.id = pp.contract_id AND c.deleted_at IS NULL
+    LEFT JOIN allocated a ON a.planned_payment_id = pp.id
+    WHERE pp.deleted_at IS NULL AND pp.paid_amount - COALESCE(a.amount, 0) > 0
+    RETURNING id, contract_id, source_id, -amount AS amount
+)
+INSERT INTO public.payment_allocations (created_at, updated_at, contract_id, planned_payment_id, source_type, source_id, amount, allocated_at)
+SELECT NOW(), NOW(), t.contract_id, t.source_id, 'ledger_entry', t.id, t.amount, NOW()
+FROM transferred t;
+
+-- 7. planned_payments.paid_amount - сумма распределений строки. После шагов 5-6 расходятся
+-- только строки, на которые распределено больше отмеченного; оплаченные строки не сбрасываются.
+UPDATE public.planned_payments pp
+SET paid_amount = t.paid,
+    status = CASE
+        WHEN pp.status = 'Скорректирован (скидка)' THEN pp.status
+        WHEN t.paid >= pp.planned_amount THEN 'Оплачен'
+        ELSE 'Частично оплачен'
+    END
+FROM (
+    SELECT planned_payment_id AS id, SUM(amount) AS paid
+    FROM public.payment_allocations
+    WHERE deleted_at IS NULL
+    GROUP BY planned_payment_id
+) t
+WHERE pp.id = t.id AND pp.deleted_at IS NULL AND pp.paid_amount < t.paid;
+
+-- 8. contracts.paid_amount становится производным от журнала
+UPDATE public.contracts c
+SET paid_amount = COALESCE((
+    SELECT -SUM(le.amount)
+    FROM public.ledger_entries le
+    LEFT JOIN public.ledger_entries o ON le.reverses_id = o.id
+    WHERE le.contract_id = c.id AND COALESCE(o.entry_type, le.entry_type) IN ('payment', 'refund')
+), 0);
+
+-- 9. Прежние таблицы платежей сохраняются только для истории
+ALTER TABLE public.payment_facts RENAME TO payment_facts_legacy;
+ALTER TABLE public.contract_payments RENAME TO contract_payments_legacy;
+COMMENT ON TABLE public.payment_facts_legacy IS 'Платежи до перехода на журнал расчетов, только для истории (см. ledger_entries)';
+COMMENT ON TABLE public.contract_payments_legacy IS 'Оплаты по договору до перехода на журнал расчетов, только для истории (см. ledger_entries)';
+
+-- Права на ручные проводки
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('ledger_manage', 'Ручные проводки и сторно в журнале расчетов', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'ledger_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+-- Распределения перенесенных платежей возвращаются на прежние записи. Распределения платежей,
+-- проведенных после миграции, и перенесенных из графика удаляются вместе с журналом;
+-- planned_payments.paid_amount и contracts.paid_amount не пересчитываются.
+ALTER TABLE public.contract_payments_legacy RENAME TO contract_payments;
+ALTER TABLE public.payment_facts_legacy RENAME TO payment_facts;
+
+UPDATE public.payment_allocations pa
+SET source_type = le.source_type, source_id = le.source_id
+FROM public.ledger_entries le
+WHERE pa.source_type = 'ledger_entry' AND le.id = pa.source_id
+  AND le.source_type IN ('payment_fact', 'contract_payment') AND le.source_id IS NOT NULL;
+
+DELETE FROM public.payment_allocations WHERE source_type = 'ledger_entry';
+
+DELETE FROM public.permissions WHERE name = 'ledger_manage';
+DROP TABLE IF EXISTS public.ledger_entries;
@@ -0,0 +1,70 @@
+-- +goose Up
+-- Загруженные банковские выписки (1CClientBankExchange, CAMT.053)
+CREATE TABLE IF NOT EXISTS public.bank_statements (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    file_name VARCHAR(255),
+    format VARCHAR(20) NOT NULL, -- 1c, camt053
+    file_hash VARCHAR(64) NOT NULL,
+    account_number VARCHAR(64),
+    period_from DATE,
+    period_to DATE,
+    total_lines INTEGER NOT NULL DEFAULT 0,
+    matched_lines INTEGER NOT NULL DEFAULT 0,
+    unmatched_lines INTEGER NOT NULL DEFAULT 0,
+    total_amount NUMERIC(14,2) NOT NULL DEFAULT 0,
+    imported_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+COMMENT ON TABLE public.bank_statements IS 'Импортированные банковские выписки; повторная загрузка файла распознается по хэшу';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bank_statements_file_hash ON public.bank_statements(file_hash);
+CREATE INDEX IF NOT EXISTS idx_bank_statements_deleted_at ON public.bank_statements(deleted_at);
+
+-- Поступления из выписок и результат сопоставления с договорами
+CREATE TABLE IF NOT EXISTS public.bank_statement_lines (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    statement_id INTEGER NOT NULL REFERENCES public.bank_statements(id) ON DELETE CASCADE,
+    line_hash VARCHAR(64) NOT NULL,
+    document_number VARCHAR(100),
+    operation_date DATE,
+    amount NUMERIC(12,2) NOT NULL,
+    currency VARCHAR(10),
+    payer_name VARCHAR(255),
+    payer_iin VARCHAR(20),
+    payer_account VARCHAR(64),
+    purpose TEXT,
+    status VARCHAR(20) NOT NULL, -- matched, unmatched, resolved, ignored, duplicate
+    matched_by VARCHAR(50),
+    match_note VARCHAR(255),
+    contract_id INTEGER REFERENCES public.contracts(id) ON DELETE SET NULL,
+    payment_entry_id INTEGER REFERENCES public.ledger_entries(id) ON DELETE SET NULL,
+    resolved_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bank_statement_lines_line_hash ON public.bank_statement_lines(line_hash);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_statement_id ON public.bank_statement_lines(statement_id);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_status ON public.bank_statement_lines(status);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_contract_id ON public.bank_statement_lines(contract_id);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_deleted_at ON public.bank_statement_lines(deleted_at);
+
+-- Права на импорт выписок
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('bank_statements_view', 'Просмотр банковских выписок и очереди несопоставленных платежей', 'Договора и оплаты'),
+    ('bank_statements_import', 'Импорт банковских выписок и ручное сопоставление платежей', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name IN ('bank_statements_view', 'bank_statements_import')
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name IN ('bank_statements_view', 'bank_statements_import');
+DROP TABLE IF EXISTS public.bank_statement_lines;
+DROP TABLE IF EXISTS public.bank_statements;
@@ -0,0 +1,81 @@
+-- +goose Up
+-- Шаги сценария напоминаний об оплате
+CREATE TABLE IF NOT EXISTS public.dunning_steps (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    name VARCHAR(255) NOT NULL,
+    offset_days INTEGER NOT NULL, -- относительно даты платежа: <0 до срока, 0 в день оплаты, >0 просрочка
+    channels VARCHAR(50) NOT NULL DEFAULT 'email',
+    subject_template VARCHAR(255),
+    body_template TEXT NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE
+);
+CREATE INDEX IF NOT EXISTS idx_dunning_steps_deleted_at ON public.dunning_steps(deleted_at);
+
+-- Журнал отправленных напоминаний
+CREATE TABLE IF NOT EXISTS public.dunning_reminders (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    planned_payment_id INTEGER NOT NULL REFERENCES public.planned_payments(id) ON DELETE CASCADE,
+    step_id INTEGER NOT NULL REFERENCES public.dunning_steps(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL,
+    recipient VARCHAR(255),
+    subject VARCHAR(255),
+    body TEXT,
+    status VARCHAR(20) NOT NULL, -- sent, failed, suppressed, no_contact
+    error TEXT,
+    sent_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_dunning_reminders_contract_id ON public.dunning_reminders(contract_id);
+CREATE INDEX IF NOT EXISTS idx_dunning_reminders_planned_payment_id ON public.dunning_reminders(planned_payment_id);
+CREATE INDEX IF NOT EXISTS idx_dunning_reminders_step_id ON public.dunning_reminders(step_id);
+CREATE INDEX IF NOT EXISTS idx_dunning_reminders_status ON public.dunning_reminders(status);
+CREATE INDEX IF NOT EXISTS idx_dunning_reminders_deleted_at ON public.dunning_reminders(deleted_at);
+
+-- Обещания оплаты (подавляют напоминания до указанной даты)
+CREATE TABLE IF NOT EXISTS public.payment_promises (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    promised_date DATE NOT NULL,
+    amount NUMERIC(12,2),
+    comment VARCHAR(255),
+    created_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_payment_promises_contract_id ON public.payment_promises(contract_id);
+CREATE INDEX IF NOT EXISTS idx_payment_promises_deleted_at ON public.payment_promises(deleted_at);
+
+-- Сценарий по умолчанию
+INSERT INTO public.dunning_steps (created_at, updated_at, name, offset_days, channels, subject_template, body_template) VALUES
+    (NOW(), NOW(), 'За 3 дня до срока', -3, 'email,sms', 'Напоминание об оплате по договору {{.ContractNumber}}',
+     'Уважаемый(ая) {{.ParentName}}! Напоминаем, что {{.DueDate}} наступает срок оплаты «{{.PaymentName}}» за {{.StudentName}} в размере {{.Amount}} тг по договору {{.ContractNumber}}.'),
+    (NOW(), NOW(), 'В день оплаты', 0, 'email,sms', 'Сегодня срок оплаты по договору {{.ContractNumber}}',
+     'Уважаемый(ая) {{.ParentName}}! Сегодня, {{.DueDate}}, срок оплаты «{{.PaymentName}}» за {{.StudentName}}. К оплате: {{.Amount}} тг.'),
+    (NOW(), NOW(), '7 дней просрочки', 7, 'email,sms', 'Просрочена оплата по договору {{.ContractNumber}}',
+     'Уважаемый(ая) {{.ParentName}}! Оплата «{{.PaymentName}}» за {{.StudentName}} со сроком {{.DueDate}} просрочена на {{.DaysOverdue}} дн. Задолженность: {{.Amount}} тг.'),
+    (NOW(), NOW(), '30 дней просрочки', 30, 'email,sms', 'Задолженность по договору {{.ContractNumber}}',
+     'Уважаемый(ая) {{.ParentName}}! Задолженность по договору {{.ContractNumber}} за {{.StudentName}} составляет {{.Amount}} тг, просрочка {{.DaysOverdue}} дн. Пожалуйста, свяжитесь с бухгалтерией школы.');
+
+-- Права на управление напоминаниями
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('dunning_manage', 'Настройка и запуск напоминаний об оплате', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'dunning_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'dunning_manage';
+DROP TABLE IF EXISTS public.payment_promises;
+DROP TABLE IF EXISTS public.dunning_reminders;
+DROP TABLE IF EXISTS public.dunning_steps;
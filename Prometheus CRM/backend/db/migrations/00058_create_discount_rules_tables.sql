@@ -0,0 +1,92 @@
+-- +goose Up
+-- Правила скидок (вместо захардкоженных процентов в коде)
+CREATE TABLE IF NOT EXISTS public.discount_rules (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    code VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(50) NOT NULL, -- family_order, early_payment, staff_child, scholarship, manual
+    percent NUMERIC(5,2) NOT NULL DEFAULT 0,
+    fixed_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    max_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    params JSONB NOT NULL DEFAULT '{}',
+    priority INTEGER NOT NULL DEFAULT 100,
+    stackable BOOLEAN NOT NULL DEFAULT FALSE,
+    valid_from DATE,
+    valid_to DATE,
+    is_active BOOLEAN DEFAULT TRUE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_discount_rules_code ON public.discount_rules(code);
+CREATE INDEX IF NOT EXISTS idx_discount_rules_kind ON public.discount_rules(kind);
+CREATE INDEX IF NOT EXISTS idx_discount_rules_deleted_at ON public.discount_rules(deleted_at);
+
+-- Скидки, предоставленные конкретным ученикам
+CREATE TABLE IF NOT EXISTS public.student_discounts (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    student_id INTEGER NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    rule_id INTEGER NOT NULL REFERENCES public.discount_rules(id) ON DELETE CASCADE,
+    contract_id INTEGER REFERENCES public.contracts(id) ON DELETE CASCADE,
+    percent NUMERIC(5,2),
+    fixed_amount NUMERIC(12,2),
+    valid_from DATE,
+    valid_to DATE,
+    comment VARCHAR(255),
+    granted_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_student_discounts_student_id ON public.student_discounts(student_id);
+CREATE INDEX IF NOT EXISTS idx_student_discounts_rule_id ON public.student_discounts(rule_id);
+CREATE INDEX IF NOT EXISTS idx_student_discounts_contract_id ON public.student_discounts(contract_id);
+CREATE INDEX IF NOT EXISTS idx_student_discounts_deleted_at ON public.student_discounts(deleted_at);
+
+-- Примененные к договору скидки (расшифровка discounted_amount)
+CREATE TABLE IF NOT EXISTS public.contract_discount_lines (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    rule_id INTEGER REFERENCES public.discount_rules(id) ON DELETE SET NULL,
+    kind VARCHAR(50) NOT NULL,
+    name VARCHAR(255),
+    percent NUMERIC(5,2) NOT NULL DEFAULT 0,
+    amount NUMERIC(12,2) NOT NULL,
+    explanation VARCHAR(255)
+);
+CREATE INDEX IF NOT EXISTS idx_contract_discount_lines_contract_id ON public.contract_discount_lines(contract_id);
+
+-- Правила, повторяющие прежнюю логику: семейная скидка 5/10%, 5% за полную оплату до 1 сентября.
+-- Оба правила нестыкуемые, как и раньше: скидка за раннюю оплату не суммируется с семейной.
+INSERT INTO public.discount_rules (created_at, updated_at, code, name, kind, percent, params, priority, stackable) VALUES
+    (NOW(), NOW(), 'family_order', 'Скидка для второго и последующих детей', 'family_order', 0, '{"tiers": {"1": 5, "2": 10}}', 10, FALSE),
+    (NOW(), NOW(), 'early_full_payment', 'Полная оплата до 1 сентября', 'early_payment', 5, '{"deadline": "09-01"}', 20, FALSE),
+    (NOW(), NOW(), 'staff_child', 'Ребенок сотрудника', 'staff_child', 0, '{}', 5, FALSE),
+    (NOW(), NOW(), 'scholarship', 'Стипендия', 'scholarship', 0, '{}', 30, TRUE),
+    (NOW(), NOW(), 'manual', 'Ручная скидка', 'manual', 0, '{}', 100, TRUE)
+ON CONFLICT (code) DO NOTHING;
+
+-- Существующие скидки переносим в расшифровку, чтобы они оставались объяснимыми
+INSERT INTO public.contract_discount_lines (created_at, contract_id, kind, name, percent, amount, explanation)
+SELECT NOW(), c.id, 'manual', 'Скидка (до перехода на правила)', c.discount_percentage,
+       ROUND((c.total_amount - c.discounted_amount)::numeric, 2), 'Перенесено из договора'
+FROM public.contracts c
+WHERE c.deleted_at IS NULL AND c.total_amount > c.discounted_amount;
+
+-- Права на настройку скидок
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('discounts_manage', 'Настройка правил скидок и предоставление скидок ученикам', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'discounts_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'discounts_manage';
+DROP TABLE IF EXISTS public.contract_discount_lines;
+DROP TABLE IF EXISTS public.student_discounts;
+DROP TABLE IF EXISTS public.discount_rules;
@@ -0,0 +1,102 @@
+-- +goose Up
+-- Учебные годы: сроки обучения, от которых считаются даты договоров и графиков
+CREATE TABLE IF NOT EXISTS public.academic_years (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    name VARCHAR(20) NOT NULL UNIQUE, -- например, '2025-2026'
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    CHECK (end_date > start_date)
+);
+CREATE INDEX IF NOT EXISTS idx_academic_years_deleted_at ON public.academic_years(deleted_at);
+
+-- Четверти учебного года
+CREATE TABLE IF NOT EXISTS public.academic_quarters (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    academic_year_id INTEGER NOT NULL REFERENCES public.academic_years(id) ON DELETE CASCADE,
+    number INTEGER NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_academic_quarters_academic_year_id ON public.academic_quarters(academic_year_id);
+
+-- Каникулы учебного года
+CREATE TABLE IF NOT EXISTS public.academic_holidays (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    academic_year_id INTEGER NOT NULL REFERENCES public.academic_years(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_academic_holidays_academic_year_id ON public.academic_holidays(academic_year_id);
+
+-- Учебный год договора
+ALTER TABLE public.contracts ADD COLUMN IF NOT EXISTS academic_year_id INTEGER REFERENCES public.academic_years(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_contracts_academic_year_id ON public.contracts(academic_year_id);
+
+-- Текущий учебный год (даты, которые раньше были зашиты в код)
+INSERT INTO public.academic_years (created_at, updated_at, name, start_date, end_date)
+VALUES (NOW(), NOW(), '2025-2026', '2025-09-01', '2026-05-25')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.academic_quarters (created_at, updated_at, academic_year_id, number, start_date, end_date)
+SELECT NOW(), NOW(), y.id, q.number, q.start_date, q.end_date
+FROM public.academic_years y,
+     (VALUES (1, DATE '2025-09-01', DATE '2025-10-26'),
+             (2, DATE '2025-11-03', DATE '2025-12-28'),
+             (3, DATE '2026-01-08', DATE '2026-03-18'),
+             (4, DATE '2026-03-30', DATE '2026-05-25')) AS q(number, start_date, end_date)
+WHERE y.name = '2025-2026'
+  AND NOT EXISTS (SELECT 1 FROM public.academic_quarters aq WHERE aq.academic_year_id = y.id);
+
+INSERT INTO public.academic_holidays (created_at, updated_at, academic_year_id, name, start_date, end_date)
+SELECT NOW(), NOW(), y.id, h.name, h.start_date, h.end_date
+FROM public.academic_years y,
+     (VALUES ('Осенние каникулы', DATE '2025-10-27', DATE '2025-11-02'),
+             ('Зимние каникулы', DATE '2025-12-29', DATE '2026-01-07'),
+             ('Весенние каникулы', DATE '2026-03-19', DATE '2026-03-29')) AS h(name, start_date, end_date)
+WHERE y.name = '2025-2026'
+  AND NOT EXISTS (SELECT 1 FROM public.academic_holidays ah WHERE ah.academic_year_id = y.id);
+
+INSERT INTO public.integration_settings (created_at, updated_at, service_name, is_enabled, settings)
+SELECT NOW(), NOW(), 'academic_year', TRUE, jsonb_build_object('currentYearId', y.id)
+FROM public.academic_years y
+WHERE y.name = '2025-2026'
+ON CONFLICT (service_name) DO NOTHING;
+
+-- Существующие договоры привязываем к году по дате начала (летние договоры - к наступающему году)
+UPDATE public.contracts c
+SET academic_year_id = (
+    SELECT y.id FROM public.academic_years y
+    WHERE y.end_date >= c.start_date::date AND y.start_date <= (c.start_date + INTERVAL '4 months')::date
+    ORDER BY y.start_date
+    LIMIT 1
+)
+WHERE c.academic_year_id IS NULL AND c.start_date IS NOT NULL;
+
+-- Права на ведение справочника учебных лет
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('academic_years_manage', 'Ведение учебных лет, четвертей и каникул', 'Справочники')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'academic_years_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'academic_years_manage';
+DELETE FROM public.integration_settings WHERE service_name = 'academic_year';
+ALTER TABLE public.contracts DROP COLUMN IF EXISTS academic_year_id;
+DROP TABLE IF EXISTS public.academic_holidays;
+DROP TABLE IF EXISTS public.academic_quarters;
+DROP TABLE IF EXISTS public.academic_years;
@@ -0,0 +1,59 @@
+-- +goose Up
+-- Счетчики номеров квитанций по годам (номер выделяется в транзакции платежа, без пропусков)
+CREATE TABLE IF NOT EXISTS public.receipt_counters (
+    year INTEGER PRIMARY KEY,
+    last_number INTEGER NOT NULL DEFAULT 0
+);
+
+-- Квитанции об оплате
+CREATE TABLE IF NOT EXISTS public.receipts (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    number VARCHAR(30) NOT NULL UNIQUE,
+    year INTEGER NOT NULL,
+    sequence INTEGER NOT NULL,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    source_type VARCHAR(50) NOT NULL, -- ledger_entry (проводка-оплата журнала)
+    source_id INTEGER NOT NULL,
+    amount NUMERIC(12,2) NOT NULL,
+    payment_date DATE NOT NULL,
+    payment_name VARCHAR(255),
+    payment_method VARCHAR(255),
+    remaining_balance NUMERIC(12,2) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'issued', -- issued, annulled
+    pdf_path TEXT,
+    replaces_id INTEGER REFERENCES public.receipts(id) ON DELETE SET NULL,
+    annulled_at TIMESTAMPTZ,
+    annul_reason VARCHAR(255),
+    issued_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL,
+    CONSTRAINT idx_receipts_year_sequence UNIQUE (year, sequence)
+);
+CREATE INDEX IF NOT EXISTS idx_receipts_contract_id ON public.receipts(contract_id);
+CREATE INDEX IF NOT EXISTS idx_receipts_source ON public.receipts(source_type, source_id);
+CREATE INDEX IF NOT EXISTS idx_receipts_status ON public.receipts(status);
+CREATE INDEX IF NOT EXISTS idx_receipts_replaces_id ON public.receipts(replaces_id);
+CREATE INDEX IF NOT EXISTS idx_receipts_deleted_at ON public.receipts(deleted_at);
+
+-- Реквизиты школы для квитанций
+INSERT INTO public.integration_settings (created_at, updated_at, service_name, is_enabled, settings)
+VALUES (NOW(), NOW(), 'receipts', TRUE, '{}')
+ON CONFLICT (service_name) DO NOTHING;
+
+-- Права на переоформление квитанций и настройку реквизитов
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('receipts_manage', 'Переоформление квитанций и реквизиты школы', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'receipts_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'receipts_manage';
+DELETE FROM public.integration_settings WHERE service_name = 'receipts';
+DROP TABLE IF EXISTS public.receipts;
+DROP TABLE IF EXISTS public.receipt_counters;
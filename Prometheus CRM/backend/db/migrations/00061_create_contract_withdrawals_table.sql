@@ -0,0 +1,51 @@
+-- +goose Up
+-- Расторжение договоров при выбытии ученика в течение учебного года
+CREATE TABLE IF NOT EXISTS public.contract_withdrawals (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL UNIQUE REFERENCES public.contracts(id) ON DELETE CASCADE,
+    withdrawal_date DATE NOT NULL,
+    reason TEXT,
+    method VARCHAR(20) NOT NULL, -- month, quarter, day
+    periods_total INTEGER NOT NULL DEFAULT 0,
+    periods_charged INTEGER NOT NULL DEFAULT 0,
+    contract_amount NUMERIC(12,2) NOT NULL,
+    deposit_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    chargeable_amount NUMERIC(12,2) NOT NULL,
+    paid_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    refund_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    debt_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    cancelled_payments INTEGER NOT NULL DEFAULT 0,
+    cancelled_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    refunded_at DATE,
+    document_path TEXT,
+    created_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_contract_withdrawals_deleted_at ON public.contract_withdrawals(deleted_at);
+
+-- Дата расторжения договора
+ALTER TABLE public.contracts ADD COLUMN IF NOT EXISTS terminated_at DATE;
+
+-- Правила пересчета при выбытии; взнос также подставляется в договор как {contributionOfMoney}
+INSERT INTO public.integration_settings (created_at, updated_at, service_name, is_enabled, settings)
+VALUES (NOW(), NOW(), 'withdrawal', TRUE, '{"method": "month", "depositAmount": 300000}')
+ON CONFLICT (service_name) DO NOTHING;
+
+-- Права на оформление выбытия и выплату возврата
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('contracts_withdraw', 'Расторжение договора при выбытии ученика и возврат денег', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'contracts_withdraw'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'contracts_withdraw';
+DELETE FROM public.integration_settings WHERE service_name = 'withdrawal';
+ALTER TABLE public.contracts DROP COLUMN IF EXISTS terminated_at;
+DROP TABLE IF EXISTS public.contract_withdrawals;
@@ -0,0 +1,55 @@
+-- +goose Up
+-- Дополнительные соглашения к договорам
+CREATE TABLE IF NOT EXISTS public.contract_amendments (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    number INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    effective_date DATE NOT NULL,
+    reason TEXT,
+    changes JSONB NOT NULL DEFAULT '[]',
+    author_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL,
+    pdf_path TEXT,
+    CONSTRAINT idx_contract_amendments_contract_number UNIQUE (contract_id, number)
+);
+CREATE INDEX IF NOT EXISTS idx_contract_amendments_deleted_at ON public.contract_amendments(deleted_at);
+
+-- Версии условий договора (версия 1 - подписанный договор)
+CREATE TABLE IF NOT EXISTS public.contract_versions (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    amendment_id INTEGER REFERENCES public.contract_amendments(id) ON DELETE SET NULL,
+    effective_date DATE NOT NULL,
+    start_date DATE,
+    end_date DATE,
+    total_amount NUMERIC(12,2),
+    discount_percentage NUMERIC(5,2),
+    discounted_amount NUMERIC(12,2),
+    payment_form_id INTEGER,
+    academic_year_id INTEGER,
+    CONSTRAINT idx_contract_versions_contract_version UNIQUE (contract_id, version)
+);
+CREATE INDEX IF NOT EXISTS idx_contract_versions_deleted_at ON public.contract_versions(deleted_at);
+
+ALTER TABLE public.contracts ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- Исходные условия существующих договоров считаем версией 1
+INSERT INTO public.contract_versions (created_at, updated_at, contract_id, version, effective_date, start_date, end_date,
+                                      total_amount, discount_percentage, discounted_amount, payment_form_id, academic_year_id)
+SELECT NOW(), NOW(), c.id, 1, COALESCE(c.start_date::date, c.created_at::date), c.start_date::date, c.end_date::date,
+       c.total_amount, c.discount_percentage, c.discounted_amount, c.payment_form_id, c.academic_year_id
+FROM public.contracts c
+WHERE c.deleted_at IS NULL
+ON CONFLICT (contract_id, version) DO NOTHING;
+
+-- +goose Down
+ALTER TABLE public.contracts DROP COLUMN IF EXISTS version;
+DROP TABLE IF EXISTS public.contract_versions;
+DROP TABLE IF EXISTS public.contract_amendments;
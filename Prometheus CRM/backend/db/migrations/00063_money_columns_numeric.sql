@@ -0,0 +1,46 @@
+-- +goose Up
+-- Все денежные колонки хранятся как NUMERIC с двумя знаками (в коде - models.Money, тиыны).
+
+-- Суммы договора были NUMERIC(10,2) - приводим к той же точности, что и остальные суммы
+ALTER TABLE public.contracts
+    ALTER COLUMN total_amount TYPE NUMERIC(12,2),
+    ALTER COLUMN discounted_amount TYPE NUMERIC(12,2);
+
+-- Бюджет статьи реестра хранился целыми тенге в BIGINT
+ALTER TABLE public.registry_entries
+    ALTER COLUMN amount TYPE NUMERIC(14,2) USING amount::NUMERIC(14,2);
+
+-- Копеечные расхождения графика с суммой договора, накопленные округлением float64,
+-- относим на последний неоплаченный платеж. Большие расхождения (частичное пересоздание
+-- плана, выбытие) не трогаем.
+WITH sums AS (
+    SELECT pp.contract_id, c.discounted_amount - SUM(pp.planned_amount) AS diff, COUNT(*) AS cnt
+    FROM public.planned_payments pp
+    JOIN public.contracts c ON c.id = pp.contract_id
+    WHERE pp.deleted_at IS NULL AND c.deleted_at IS NULL AND c.terminated_at IS NULL
+    GROUP BY pp.contract_id, c.discounted_amount
+),
+targets AS (
+    SELECT DISTINCT ON (pp.contract_id) pp.id, s.diff
+    FROM public.planned_payments pp
+    JOIN sums s ON s.contract_id = pp.contract_id
+    WHERE pp.deleted_at IS NULL
+      AND s.diff <> 0 AND ABS(s.diff) <= s.cnt * 0.01
+      AND COALESCE(pp.paid_amount, 0) = 0
+      AND pp.planned_amount + s.diff > 0
+    ORDER BY pp.contract_id, pp.payment_date DESC, pp.id DESC
+)
+UPDATE public.planned_payments pp
+SET planned_amount = pp.planned_amount + t.diff, updated_at = NOW()
+FROM targets t
+WHERE pp.id = t.id;
+
+-- +goose Down
+-- Перенос копеечных расхождений графика на последний платеж не откатывается:
+-- прежние значения planned_amount не сохранены.
+ALTER TABLE public.contracts
+    ALTER COLUMN total_amount TYPE NUMERIC(10,2),
+    ALTER COLUMN discounted_amount TYPE NUMERIC(10,2);
+
+ALTER TABLE public.registry_entries
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT;
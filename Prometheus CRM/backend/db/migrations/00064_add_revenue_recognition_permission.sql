@@ -0,0 +1,14 @@
+-- +goose Up
+-- Право на отчет о признании выручки и доходах будущих периодов
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('revenue_recognition_view', 'Просмотр отчета о признании выручки', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'revenue_recognition_view'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'revenue_recognition_view';
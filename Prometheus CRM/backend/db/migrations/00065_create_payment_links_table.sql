@@ -0,0 +1,41 @@
+-- +goose Up
+-- Ссылки на онлайн-оплату через платежный шлюз
+CREATE TABLE IF NOT EXISTS public.payment_links (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    gateway VARCHAR(30) NOT NULL, -- halyk, fake
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    planned_payment_id INTEGER REFERENCES public.planned_payments(id) ON DELETE SET NULL,
+    amount NUMERIC(12,2) NOT NULL,
+    description VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, paid, cancelled, expired
+    payment_url TEXT,
+    external_id VARCHAR(100),
+    last_error TEXT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    paid_at TIMESTAMPTZ,
+    payment_entry_id INTEGER REFERENCES public.ledger_entries(id) ON DELETE SET NULL,
+    created_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_payment_links_contract_id ON public.payment_links(contract_id);
+CREATE INDEX IF NOT EXISTS idx_payment_links_planned_payment_id ON public.payment_links(planned_payment_id);
+CREATE INDEX IF NOT EXISTS idx_payment_links_status ON public.payment_links(status);
+CREATE INDEX IF NOT EXISTS idx_payment_links_external_id ON public.payment_links(external_id);
+CREATE INDEX IF NOT EXISTS idx_payment_links_deleted_at ON public.payment_links(deleted_at);
+
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('payment_links_manage', 'Выдача ссылок на онлайн-оплату', 'Договора и оплаты')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'payment_links_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'payment_links_manage';
+DROP TABLE IF EXISTS public.payment_links;
@@ -0,0 +1,60 @@
+-- +goose Up
+-- Личный кабинет родителя: отдельные учетные записи без ролей и прав сотрудников
+CREATE TABLE IF NOT EXISTS public.parent_accounts (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    full_name VARCHAR(255),
+    iin VARCHAR(12),
+    phone VARCHAR(20), -- только цифры, 7XXXXXXXXXX
+    email VARCHAR(255),
+    password TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, blocked
+    last_login_at TIMESTAMPTZ,
+    created_by_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL,
+    CONSTRAINT chk_parent_accounts_login CHECK (COALESCE(iin, '') <> '' OR COALESCE(phone, '') <> '')
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_parent_accounts_iin ON public.parent_accounts(iin) WHERE deleted_at IS NULL AND iin <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS uq_parent_accounts_phone ON public.parent_accounts(phone) WHERE deleted_at IS NULL AND phone <> '';
+CREATE INDEX IF NOT EXISTS idx_parent_accounts_deleted_at ON public.parent_accounts(deleted_at);
+
+-- Дети, к данным которых у родителя есть доступ
+CREATE TABLE IF NOT EXISTS public.parent_account_students (
+    parent_account_id INTEGER NOT NULL REFERENCES public.parent_accounts(id) ON DELETE CASCADE,
+    student_id INTEGER NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    PRIMARY KEY (parent_account_id, student_id)
+);
+CREATE INDEX IF NOT EXISTS idx_parent_account_students_student_id ON public.parent_account_students(student_id);
+
+-- Посты и опросы, адресованные родителям
+ALTER TABLE public.news_posts
+    ADD COLUMN IF NOT EXISTS audience VARCHAR(20) NOT NULL DEFAULT 'staff';
+
+-- Голосовать в опросах могут и родители: голос принадлежит либо сотруднику, либо родителю
+ALTER TABLE public.poll_votes
+    ALTER COLUMN user_id DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS parent_account_id INTEGER REFERENCES public.parent_accounts(id) ON DELETE CASCADE,
+    ADD CONSTRAINT chk_poll_votes_voter CHECK ((user_id IS NULL) <> (parent_account_id IS NULL));
+CREATE UNIQUE INDEX IF NOT EXISTS uq_parent_vote_on_poll ON public.poll_votes(poll_option_id, parent_account_id) WHERE parent_account_id IS NOT NULL;
+
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('parent_accounts_manage', 'Управление доступом родителей в личный кабинет', 'Ученики')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'parent_accounts_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'parent_accounts_manage';
+DELETE FROM public.poll_votes WHERE parent_account_id IS NOT NULL;
+ALTER TABLE public.poll_votes
+    DROP CONSTRAINT IF EXISTS chk_poll_votes_voter,
+    DROP COLUMN IF EXISTS parent_account_id,
+    ALTER COLUMN user_id SET NOT NULL;
+ALTER TABLE public.news_posts DROP COLUMN IF EXISTS audience;
+DROP TABLE IF EXISTS public.parent_account_students;
+DROP TABLE IF EXISTS public.parent_accounts;
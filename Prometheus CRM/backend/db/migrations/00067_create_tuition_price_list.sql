@@ -0,0 +1,95 @@
+-- +goose Up
+-- Прайс-лист: стоимость обучения на учебный год по параллели, языку и форме обучения класса.
+-- Заменяет tuition_fees, где были только "цена для поступивших в 2023" и "актуальная цена".
+CREATE TABLE IF NOT EXISTS public.tuition_prices (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    academic_year_id INTEGER NOT NULL REFERENCES public.academic_years(id) ON DELETE CASCADE,
+    grade INTEGER NOT NULL CHECK (grade BETWEEN 0 AND 11),
+    language VARCHAR(50) NOT NULL DEFAULT '',   -- '' - любой язык обучения
+    study_type VARCHAR(50) NOT NULL DEFAULT '', -- '' - любая форма обучения
+    amount NUMERIC(12,2) NOT NULL CHECK (amount >= 0),
+    comment TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_tuition_prices_key
+    ON public.tuition_prices(academic_year_id, grade, language, study_type) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_tuition_prices_deleted_at ON public.tuition_prices(deleted_at);
+
+-- Сохранение цены для поступивших раньше
+CREATE TABLE IF NOT EXISTS public.tuition_grandfather_rules (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    academic_year_id INTEGER NOT NULL REFERENCES public.academic_years(id) ON DELETE CASCADE,
+    admitted_before DATE NOT NULL,
+    price_year_id INTEGER NOT NULL REFERENCES public.academic_years(id) ON DELETE CASCADE,
+    index_percent NUMERIC(6,2) NOT NULL DEFAULT 0,
+    comment TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_tuition_grandfather_rules_academic_year_id ON public.tuition_grandfather_rules(academic_year_id);
+CREATE INDEX IF NOT EXISTS idx_tuition_grandfather_rules_deleted_at ON public.tuition_grandfather_rules(deleted_at);
+
+-- Переносим цены: актуальная - на текущий учебный год, цена 2023 - на 2023-2024 (если он заведен)
+-- и правило "поступившие до 2024 года платят по ценам 2023-2024", как считал код раньше.
+CREATE TEMP TABLE tuition_current_year ON COMMIT DROP AS
+SELECT id FROM public.academic_years y
+WHERE y.deleted_at IS NULL
+ORDER BY (y.id::text = (SELECT settings->>'currentYearId' FROM public.integration_settings
+                        WHERE service_name = 'academic_year' LIMIT 1)) DESC,
+         (CURRENT_DATE BETWEEN y.start_date AND y.end_date) DESC,
+         y.start_date DESC
+LIMIT 1;
+
+INSERT INTO public.tuition_prices (created_at, updated_at, academic_year_id, grade, amount, comment)
+SELECT NOW(), NOW(), cy.id, tf.grade, tf.current_cost, 'Перенесено из "Стоимость обучения"'
+FROM public.tuition_fees tf, tuition_current_year cy
+WHERE tf.deleted_at IS NULL AND COALESCE(tf.current_cost, 0) > 0
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.tuition_prices (created_at, updated_at, academic_year_id, grade, amount, comment)
+SELECT NOW(), NOW(), y.id, tf.grade, tf.cost_for2023, 'Перенесено из "Стоимость обучения"'
+FROM public.tuition_fees tf
+JOIN public.academic_years y ON y.name = '2023-2024' AND y.deleted_at IS NULL
+WHERE tf.deleted_at IS NULL AND COALESCE(tf.cost_for2023, 0) > 0
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.tuition_grandfather_rules (created_at, updated_at, academic_year_id, admitted_before, price_year_id, comment)
+SELECT NOW(), NOW(), cy.id, DATE '2024-01-01', y.id, 'Поступившие в 2023 году и ранее'
+FROM public.academic_years y, tuition_current_year cy
+WHERE y.name = '2023-2024' AND y.deleted_at IS NULL AND y.id <> cy.id
+  AND EXISTS (SELECT 1 FROM public.tuition_prices tp WHERE tp.academic_year_id = y.id);
+
+DROP TABLE IF EXISTS public.tuition_fees;
+
+-- +goose Down
+CREATE TABLE IF NOT EXISTS public.tuition_fees (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    grade INT UNIQUE NOT NULL,
+    cost_for2023 NUMERIC(12, 2) DEFAULT 0.00,
+    current_cost NUMERIC(12, 2) DEFAULT 0.00
+);
+INSERT INTO public.tuition_fees (grade)
+SELECT s.g FROM generate_series(0,11) AS s(g)
+ON CONFLICT (grade) DO NOTHING;
+
+UPDATE public.tuition_fees tf
+SET current_cost = tp.amount
+FROM public.tuition_prices tp
+WHERE tp.grade = tf.grade AND tp.language = '' AND tp.study_type = '' AND tp.deleted_at IS NULL
+  AND tp.academic_year_id = (SELECT id FROM public.academic_years WHERE deleted_at IS NULL
+                             ORDER BY (CURRENT_DATE BETWEEN start_date AND end_date) DESC, start_date DESC LIMIT 1);
+
+UPDATE public.tuition_fees tf
+SET cost_for2023 = tp.amount
+FROM public.tuition_prices tp
+JOIN public.academic_years y ON y.id = tp.academic_year_id AND y.name = '2023-2024'
+WHERE tp.grade = tf.grade AND tp.language = '' AND tp.study_type = '' AND tp.deleted_at IS NULL;
+
+DROP TABLE IF EXISTS public.tuition_grandfather_rules;
+DROP TABLE IF EXISTS public.tuition_prices;
@@ -0,0 +1,39 @@
+-- +goose Up
+-- Несколько плательщиков по договору: родители, работодатель, благотворительный фонд
+CREATE TABLE IF NOT EXISTS public.contract_payers (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    contract_id INTEGER NOT NULL REFERENCES public.contracts(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,              -- parent, employer, charity, other
+    name TEXT NOT NULL,
+    iin VARCHAR(12),                        -- ИИН или БИН
+    phone VARCHAR(50),
+    email VARCHAR(255),
+    address TEXT,
+    share_type VARCHAR(20) NOT NULL,        -- percent, fixed, remainder
+    share_percent NUMERIC(5,2) NOT NULL DEFAULT 0,
+    fixed_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    comment TEXT,
+    CONSTRAINT chk_contract_payers_share CHECK (share_percent BETWEEN 0 AND 100 AND fixed_amount >= 0)
+);
+CREATE INDEX IF NOT EXISTS idx_contract_payers_contract_id ON public.contract_payers(contract_id);
+CREATE INDEX IF NOT EXISTS idx_contract_payers_deleted_at ON public.contract_payers(deleted_at);
+-- Остаток договора достается не более чем одному плательщику
+CREATE UNIQUE INDEX IF NOT EXISTS uq_contract_payers_remainder
+    ON public.contract_payers(contract_id) WHERE share_type = 'remainder' AND deleted_at IS NULL;
+
+-- Ссылка на плательщика в графике, журнале расчетов и квитанциях (NULL - не указан)
+ALTER TABLE public.planned_payments ADD COLUMN IF NOT EXISTS payer_id INTEGER REFERENCES public.contract_payers(id);
+ALTER TABLE public.ledger_entries ADD COLUMN IF NOT EXISTS payer_id INTEGER REFERENCES public.contract_payers(id);
+ALTER TABLE public.receipts ADD COLUMN IF NOT EXISTS payer_id INTEGER REFERENCES public.contract_payers(id);
+CREATE INDEX IF NOT EXISTS idx_planned_payments_payer_id ON public.planned_payments(payer_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_payer_id ON public.ledger_entries(payer_id);
+CREATE INDEX IF NOT EXISTS idx_receipts_payer_id ON public.receipts(payer_id);
+
+-- +goose Down
+ALTER TABLE public.receipts DROP COLUMN IF EXISTS payer_id;
+ALTER TABLE public.ledger_entries DROP COLUMN IF EXISTS payer_id;
+ALTER TABLE public.planned_payments DROP COLUMN IF EXISTS payer_id;
+DROP TABLE IF EXISTS public.contract_payers;
@@ -0,0 +1,82 @@
+-- +goose Up
+-- Счетчики номеров документов по видам и областям нумерации (номер выделяется в транзакции документа, без пропусков)
+CREATE TABLE IF NOT EXISTS public.document_sequences (
+    document_type VARCHAR(30) NOT NULL,     -- contract, amendment, receipt, termination
+    scope_key VARCHAR(50) NOT NULL DEFAULT '', -- '', academic_year:ID, year:YYYY, student:ID, contract:ID
+    last_number INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ,
+    PRIMARY KEY (document_type, scope_key)
+);
+
+-- Счетчики продолжают прежнюю нумерацию: договоры "N {ученик}-{n}", соглашения по договору, квитанции по годам
+INSERT INTO public.document_sequences (document_type, scope_key, last_number, updated_at)
+SELECT 'contract', 'student:' || student_id,
+       MAX(COALESCE(substring(contract_number from '-(\d+)$')::INTEGER, 0)), NOW()
+FROM public.contracts
+GROUP BY student_id
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.document_sequences (document_type, scope_key, last_number, updated_at)
+SELECT 'amendment', 'contract:' || contract_id, MAX(number), NOW()
+FROM public.contract_amendments
+GROUP BY contract_id
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.document_sequences (document_type, scope_key, last_number, updated_at)
+SELECT 'receipt', 'year:' || year, last_number, NOW()
+FROM public.receipt_counters
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS public.receipt_counters;
+
+-- Номер квитанции уникален сам по себе; при нумерации по учебным годам пара (год, номер) может повторяться
+ALTER TABLE public.receipts DROP CONSTRAINT IF EXISTS idx_receipts_year_sequence;
+CREATE INDEX IF NOT EXISTS idx_receipts_year_sequence ON public.receipts(year, sequence);
+ALTER TABLE public.receipts ALTER COLUMN number TYPE VARCHAR(100);
+
+-- Печатные номера соглашений
+ALTER TABLE public.contract_amendments ADD COLUMN IF NOT EXISTS document_number VARCHAR(100);
+UPDATE public.contract_amendments SET document_number = number::TEXT WHERE document_number IS NULL;
+ALTER TABLE public.contract_withdrawals ADD COLUMN IF NOT EXISTS document_number VARCHAR(100);
+
+-- Схемы нумерации по умолчанию совпадают с прежними форматами номеров
+INSERT INTO public.integration_settings (created_at, updated_at, service_name, is_enabled, settings)
+VALUES (NOW(), NOW(), 'document_numbering', true, '{
+    "schemes": {
+        "contract": {"pattern": "N {student}-{seq}", "scope": "student"},
+        "amendment": {"pattern": "{seq}", "scope": "contract"},
+        "receipt": {"pattern": "{year}-{seq:00000}", "scope": "calendar_year"},
+        "termination": {"pattern": "Р-{year}-{seq:000}", "scope": "calendar_year"}
+    }
+}'::jsonb)
+ON CONFLICT (service_name) DO NOTHING;
+
+INSERT INTO public.permissions (name, description, category) VALUES
+    ('numbering_manage', 'Настройка нумерации документов и сброс счетчиков', 'Администрирование')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'numbering_manage'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
+
+-- +goose Down
+DELETE FROM public.permissions WHERE name = 'numbering_manage';
+DELETE FROM public.integration_settings WHERE service_name = 'document_numbering';
+
+ALTER TABLE public.contract_withdrawals DROP COLUMN IF EXISTS document_number;
+ALTER TABLE public.contract_amendments DROP COLUMN IF EXISTS document_number;
+
+CREATE TABLE IF NOT EXISTS public.receipt_counters (
+    year INTEGER PRIMARY KEY,
+    last_number INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO public.receipt_counters (year, last_number)
+SELECT year, MAX(sequence) FROM public.receipts GROUP BY year
+ON CONFLICT DO NOTHING;
+
+DROP INDEX IF EXISTS public.idx_receipts_year_sequence;
+ALTER TABLE public.receipts ADD CONSTRAINT idx_receipts_year_sequence UNIQUE (year, sequence);
+
+DROP TABLE IF EXISTS public.document_sequences;
@@ -0,0 +1,36 @@
+-- +goose Up
+-- Неизменяемые версии файлов шаблонов: каждая загрузка файла создает новую версию
+CREATE TABLE IF NOT EXISTS public.contract_template_versions (
+    id SERIAL PRIMARY KEY,
+    template_id INTEGER NOT NULL REFERENCES public.contract_templates(id),
+    version INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    original_file_name TEXT,
+    file_size BIGINT,
+    checksum VARCHAR(64),            -- SHA-256 файла, пусто для перенесенных версий
+    scan JSONB,                      -- плейсхолдеры, найденные при загрузке
+    uploaded_by_id INTEGER REFERENCES public.users(id),
+    created_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_template_versions_number ON public.contract_template_versions(template_id, version);
+
+-- Текущий файл каждого шаблона становится его версией 1
+INSERT INTO public.contract_template_versions (template_id, version, file_path, original_file_name, file_size, created_at)
+SELECT id, 1, file_path, original_file_name, file_size, COALESCE(updated_at, created_at, NOW())
+FROM public.contract_templates
+WHERE file_path IS NOT NULL AND file_path <> ''
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE public.contract_templates ADD COLUMN IF NOT EXISTS current_version_id INTEGER REFERENCES public.contract_template_versions(id);
+UPDATE public.contract_templates t SET current_version_id = v.id
+FROM public.contract_template_versions v
+WHERE v.template_id = t.id AND v.version = 1 AND t.current_version_id IS NULL;
+
+-- Версия шаблона, по которой сформирован договор (для прежних договоров неизвестна)
+ALTER TABLE public.contracts ADD COLUMN IF NOT EXISTS template_version_id INTEGER REFERENCES public.contract_template_versions(id);
+
+-- +goose Down
+ALTER TABLE public.contracts DROP COLUMN IF EXISTS template_version_id;
+ALTER TABLE public.contract_templates DROP COLUMN IF EXISTS current_version_id;
+DROP TABLE IF EXISTS public.contract_template_versions;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Фоновое формирование PDF договора: договор создается сразу, PDF прикладывается после конвертации
+ALTER TABLE public.contracts
+    ADD COLUMN IF NOT EXISTS pdf_status VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS docx_path TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS pdf_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS pdf_error TEXT NOT NULL DEFAULT '';
+
+-- У прежних договоров PDF формировался при создании
+UPDATE public.contracts SET pdf_status = 'ready' WHERE pdf_path IS NOT NULL AND pdf_path <> '';
+
+CREATE INDEX IF NOT EXISTS idx_contracts_pdf_queue ON public.contracts(pdf_status) WHERE pdf_status IN ('pending', 'failed');
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_contracts_pdf_queue;
+ALTER TABLE public.contracts
+    DROP COLUMN IF EXISTS pdf_error,
+    DROP COLUMN IF EXISTS pdf_attempts,
+    DROP COLUMN IF EXISTS docx_path,
+    DROP COLUMN IF EXISTS pdf_status;
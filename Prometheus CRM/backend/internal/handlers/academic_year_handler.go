@@ -0,0 +1,381 @@
+// prometheus-crm/internal/handlers/academic_year_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AcademicYearService - запись integration_settings с текущим учебным годом.
+const AcademicYearService = "academic_year"
+
+// academicYearLeadMonths - за сколько месяцев до начала занятий даты относятся к наступающему
+// учебному году (летние договоры и предоплаты).
+const academicYearLeadMonths = 4
+
+var errAcademicYearNotConfigured = errors.New("учебный год не настроен: добавьте его в справочник учебных лет")
+
+// AcademicYearSettings - настройки учебного года
+type AcademicYearSettings struct {
+	CurrentYearID uint `json:"currentYearId"`
+}
+
+var russianMonthsGenitive = [...]string{
+	"января", "февраля", "марта", "апреля", "мая", "июня",
+	"июля", "августа", "сентября", "октября", "ноября", "декабря",
+}
+
+// formatRussianDate форматирует дату для текста договора: "01 сентября 2025 года".
+func formatRussianDate(d time.Time) string {
+	return fmt.Sprintf("%02d %s %d года", d.Day(), russianMonthsGenitive[d.Month()-1], d.Year())
+}
+
+// loadAcademicYear загружает учебный год с четвертями и каникулами.
+func loadAcademicYear(tx *gorm.DB, id uint) (*models.AcademicYear, error) {
+	var year models.AcademicYear
+	err := tx.Preload("Quarters", func(db *gorm.DB) *gorm.DB { return db.Order("number ASC") }).
+		Preload("Holidays", func(db *gorm.DB) *gorm.DB { return db.Order("start_date ASC") }).
+		First(&year, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &year, nil
+}
+
+// academicYearOn возвращает учебный год, к которому относится дата: год, который еще не закончился
+// и начинается не позже чем через academicYearLeadMonths месяцев.
+func academicYearOn(tx *gorm.DB, d time.Time) (*models.AcademicYear, error) {
+	var year models.AcademicYear
+	day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	err := tx.Where("end_date >= ? AND start_date <= ?", day, day.AddDate(0, academicYearLeadMonths, 0)).
+		Order("start_date ASC").
+		First(&year).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errAcademicYearNotConfigured
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadAcademicYear(tx, year.ID)
+}
+
+// currentAcademicYear возвращает год, выбранный текущим в настройках,
+// а если он не выбран - год, к которому относится сегодняшняя дата.
+func currentAcademicYear(tx *gorm.DB) (*models.AcademicYear, error) {
+	var setting models.IntegrationSetting
+	if err := tx.Where("service_name = ?", AcademicYearService).First(&setting).Error; err == nil {
+		if id, ok := setting.Settings["currentYearId"].(float64); ok && id > 0 {
+			if year, err := loadAcademicYear(tx, uint(id)); err == nil {
+				return year, nil
+			}
+		}
+	}
+	return academicYearOn(tx, time.Now())
+}
+
+// academicYearForContract возвращает учебный год договора; для договоров без привязки
+// год определяется по дате начала.
+func academicYearForContract(tx *gorm.DB, contract *models.Contract) (*models.AcademicYear, error) {
+	if contract.AcademicYearID != nil {
+		year, err := loadAcademicYear(tx, *contract.AcademicYearID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errAcademicYearNotConfigured
+		}
+		return year, err
+	}
+	if contract.StartDate != nil {
+		return academicYearOn(tx, *contract.StartDate)
+	}
+	return currentAcademicYear(tx)
+}
+
+// academicQuarterByName находит учебный год по названию ("2025-2026") и его четверть по номеру.
+// Если четверти года не заданы, допускаются номера 1-4 без дат (quarter = nil).
+func academicQuarterByName(tx *gorm.DB, yearName string, number int) (*models.AcademicYear, *models.AcademicQuarter, error) {
+	var year models.AcademicYear
+	if err := tx.Where("name = ?", yearName).First(&year).Error; err != nil {
+		return nil, nil, fmt.Errorf("учебный год %s не найден в справочнике", yearName)
+	}
+	loaded, err := loadAcademicYear(tx, year.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(loaded.Quarters) == 0 {
+		if number < 1 || number > 4 {
+			return nil, nil, fmt.Errorf("неверный номер четверти: %d", number)
+		}
+		return loaded, nil, nil
+	}
+	for i := range loaded.Quarters {
+		if loaded.Quarters[i].Number == number {
+			return loaded, &loaded.Quarters[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("в учебном году %s нет %d-й четверти", yearName, number)
+}
+
+// AcademicYearInput - учебный год в запросе; даты в формате YYYY-MM-DD.
+type AcademicYearInput struct {
+	Name      string `json:"name" binding:"required"`
+	StartDate string `json:"startDate" binding:"required"`
+	EndDate   string `json:"endDate" binding:"required"`
+	Quarters  []struct {
+		Number    int    `json:"number"`
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	} `json:"quarters"`
+	Holidays []struct {
+		Name      string `json:"name"`
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	} `json:"holidays"`
+}
+
+// toModel разбирает даты и собирает модель учебного года.
+func (in AcademicYearInput) toModel() (models.AcademicYear, error) {
+	var year models.AcademicYear
+	parse := func(field, value string) (time.Time, error) {
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return t, fmt.Errorf("неверный формат даты (%s): ожидается YYYY-MM-DD", field)
+		}
+		return t, nil
+	}
+	var err error
+	year.Name = in.Name
+	if year.StartDate, err = parse("начало года", in.StartDate); err != nil {
+		return year, err
+	}
+	if year.EndDate, err = parse("окончание года", in.EndDate); err != nil {
+		return year, err
+	}
+	for _, q := range in.Quarters {
+		quarter := models.AcademicQuarter{Number: q.Number}
+		if quarter.StartDate, err = parse(fmt.Sprintf("начало %d-й четверти", q.Number), q.StartDate); err != nil {
+			return year, err
+		}
+		if quarter.EndDate, err = parse(fmt.Sprintf("окончание %d-й четверти", q.Number), q.EndDate); err != nil {
+			return year, err
+		}
+		year.Quarters = append(year.Quarters, quarter)
+	}
+	for _, h := range in.Holidays {
+		holiday := models.AcademicHoliday{Name: strings.TrimSpace(h.Name)}
+		if holiday.StartDate, err = parse("начало каникул", h.StartDate); err != nil {
+			return year, err
+		}
+		if holiday.EndDate, err = parse("окончание каникул", h.EndDate); err != nil {
+			return year, err
+		}
+		year.Holidays = append(year.Holidays, holiday)
+	}
+	return year, nil
+}
+
+// bindAcademicYear читает и проверяет учебный год из тела запроса.
+func bindAcademicYear(c *gin.Context) (models.AcademicYear, bool) {
+	var input AcademicYearInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указаны название и даты учебного года"})
+		return models.AcademicYear{}, false
+	}
+	year, err := input.toModel()
+	if err == nil {
+		err = validateAcademicYear(&year)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return year, false
+	}
+	return year, true
+}
+
+func validateAcademicYear(year *models.AcademicYear) error {
+	year.Name = strings.TrimSpace(year.Name)
+	if year.Name == "" {
+		return errors.New("не указано название учебного года")
+	}
+	if !year.EndDate.After(year.StartDate) {
+		return errors.New("дата окончания учебного года должна быть позже даты начала")
+	}
+	inYear := func(from, to time.Time) bool {
+		return !from.Before(year.StartDate) && !to.After(year.EndDate) && !to.Before(from)
+	}
+
+	sort.Slice(year.Quarters, func(i, j int) bool { return year.Quarters[i].Number < year.Quarters[j].Number })
+	for i, q := range year.Quarters {
+		if q.Number != i+1 {
+			return errors.New("четверти должны быть пронумерованы по порядку, начиная с 1")
+		}
+		if !inYear(q.StartDate, q.EndDate) {
+			return fmt.Errorf("даты %d-й четверти выходят за пределы учебного года", q.Number)
+		}
+		if i > 0 && !q.StartDate.After(year.Quarters[i-1].EndDate) {
+			return fmt.Errorf("%d-я четверть пересекается с предыдущей", q.Number)
+		}
+	}
+	for _, h := range year.Holidays {
+		if strings.TrimSpace(h.Name) == "" {
+			return errors.New("не указано название каникул")
+		}
+		if !inYear(h.StartDate, h.EndDate) {
+			return fmt.Errorf("даты каникул \"%s\" выходят за пределы учебного года", h.Name)
+		}
+	}
+	return nil
+}
+
+// ListAcademicYearsHandler возвращает учебные годы и ID текущего года.
+func ListAcademicYearsHandler(c *gin.Context) {
+	var years []models.AcademicYear
+	if err := config.DB.
+		Preload("Quarters", func(db *gorm.DB) *gorm.DB { return db.Order("number ASC") }).
+		Preload("Holidays", func(db *gorm.DB) *gorm.DB { return db.Order("start_date ASC") }).
+		Order("start_date DESC").Find(&years).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить учебные годы"})
+		return
+	}
+	if years == nil {
+		years = make([]models.AcademicYear, 0)
+	}
+	var currentID uint
+	if current, err := currentAcademicYear(config.DB); err == nil {
+		currentID = current.ID
+	}
+	c.JSON(http.StatusOK, gin.H{"data": years, "currentYearId": currentID})
+}
+
+// GetAcademicYearHandler возвращает учебный год.
+func GetAcademicYearHandler(c *gin.Context) {
+	var year models.AcademicYear
+	if err := config.DB.Select("id").First(&year, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учебный год не найден"})
+		return
+	}
+	loaded, err := loadAcademicYear(config.DB, year.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить учебный год"})
+		return
+	}
+	c.JSON(http.StatusOK, loaded)
+}
+
+// GetCurrentAcademicYearHandler возвращает текущий учебный год.
+func GetCurrentAcademicYearHandler(c *gin.Context) {
+	year, err := currentAcademicYear(config.DB)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, year)
+}
+
+// CreateAcademicYearHandler создает учебный год вместе с четвертями и каникулами.
+func CreateAcademicYearHandler(c *gin.Context) {
+	year, ok := bindAcademicYear(c)
+	if !ok {
+		return
+	}
+	if err := config.DB.Create(&year).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить учебный год: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, year)
+}
+
+// UpdateAcademicYearHandler изменяет учебный год; четверти и каникулы заменяются переданными.
+// Даты уже созданных договоров и графиков не пересчитываются.
+func UpdateAcademicYearHandler(c *gin.Context) {
+	var year models.AcademicYear
+	if err := config.DB.First(&year, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учебный год не найден"})
+		return
+	}
+	input, ok := bindAcademicYear(c)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&year).Updates(map[string]interface{}{
+			"name":       input.Name,
+			"start_date": input.StartDate,
+			"end_date":   input.EndDate,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("academic_year_id = ?", year.ID).Delete(&models.AcademicQuarter{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("academic_year_id = ?", year.ID).Delete(&models.AcademicHoliday{}).Error; err != nil {
+			return err
+		}
+		for i := range input.Quarters {
+			input.Quarters[i].AcademicYearID = year.ID
+		}
+		for i := range input.Holidays {
+			input.Holidays[i].AcademicYearID = year.ID
+		}
+		if len(input.Quarters) > 0 {
+			if err := tx.Create(&input.Quarters).Error; err != nil {
+				return err
+			}
+		}
+		if len(input.Holidays) > 0 {
+			if err := tx.Create(&input.Holidays).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить учебный год: " + err.Error()})
+		return
+	}
+
+	updated, err := loadAcademicYear(config.DB, year.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить учебный год"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteAcademicYearHandler удаляет учебный год, если к нему не привязаны договоры.
+func DeleteAcademicYearHandler(c *gin.Context) {
+	var year models.AcademicYear
+	if err := config.DB.First(&year, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учебный год не найден"})
+		return
+	}
+	var contracts int64
+	config.DB.Model(&models.Contract{}).Where("academic_year_id = ?", year.ID).Count(&contracts)
+	if contracts > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "К учебному году привязаны договоры, удалить его нельзя"})
+		return
+	}
+	if err := config.DB.Unscoped().Delete(&year).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить учебный год"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Учебный год удален"})
+}
+
+// SetCurrentAcademicYearHandler делает учебный год текущим: новые договоры создаются на него.
+func SetCurrentAcademicYearHandler(c *gin.Context) {
+	var year models.AcademicYear
+	if err := config.DB.First(&year, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учебный год не найден"})
+		return
+	}
+	saveIntegrationSettings(c, AcademicYearService, true, AcademicYearSettings{CurrentYearID: year.ID})
+}
@@ -0,0 +1,584 @@
+// prometheus-crm/internal/handlers/bank_statement_handler.go
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Признаки, по которым строка выписки сопоставлена с договором.
+const (
+	MatchedByContractNumber = "contract_number"
+	MatchedByStudentIIN     = "student_iin"
+	MatchedByParentIIN      = "parent_iin"
+	MatchedByManual         = "manual"
+
+	// BankStatementPaymentMethod - способ оплаты у платежей, созданных из выписки.
+	BankStatementPaymentMethod = "Банковская выписка"
+
+	maxStatementFileSize = 20 << 20 // 20 MB
+)
+
+var iinPattern = regexp.MustCompile(`\b\d{12}\b`)
+
+// contractNumberKeyword - чем в назначении платежа обозначают номер договора: "№123-1", "N 123-1", "дог. 123-1".
+const contractNumberKeyword = `(?:№|\bN|\bNo\.?|договор[а-я]*|дог\.?)\s*№?\s*`
+
+// contractNumberValues - что может стоять на месте подстановок шаблона номера договора.
+var contractNumberValues = map[string]string{
+	"seq":     `\d{1,9}`,
+	"year":    `\d{4}`,
+	"ayear":   `\d{4}\s*-\s*\d{4}`,
+	"month":   `\d{1,2}`,
+	"grade":   `\d{1,2}`,
+	"student": `\d{1,9}`,
+}
+
+// contractNumberMatcher находит в назначении платежа номера договоров, построенные по шаблону
+// схемы нумерации, и восстанавливает их в том виде, в котором они хранятся в contract_number.
+type contractNumberMatcher struct {
+	re      *regexp.Regexp
+	pattern string
+}
+
+// newContractNumberMatcher строит поиск по шаблону номера. Префикс шаблона ("N ") в назначении
+// часто заменяют на "№" или "дог.", а пробелы вокруг разделителей ставят как придется, поэтому
+// сравниваются только подстановки и разделители между ними. Шаблоны, по которым номер нельзя
+// восстановить из текста ({contract}, без подстановок), не ищутся.
+func newContractNumberMatcher(pattern string) *contractNumberMatcher {
+	locs := numberPlaceholderRe.FindAllStringSubmatchIndex(pattern, -1)
+	if len(locs) == 0 {
+		return nil
+	}
+	var core strings.Builder
+	for i, loc := range locs {
+		if i > 0 {
+			core.WriteString(looseLiteralPattern(pattern[locs[i-1][1]:loc[0]]))
+		}
+		value, ok := contractNumberValues[pattern[loc[2]:loc[3]]]
+		if !ok {
+			return nil
+		}
+		core.WriteString("(" + value + ")")
+	}
+
+	prefix := strings.TrimSpace(pattern[:locs[0][0]])
+	var expr string
+	switch {
+	case prefix != "":
+		literal := looseLiteralPattern(prefix)
+		if r := []rune(prefix)[0]; r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			literal = `\b` + literal
+		}
+		expr = `(?:` + literal + `|` + contractNumberKeyword + `)\s*`
+	case len(locs) < 2:
+		// одиночное число без обозначения договора - это может быть что угодно
+		expr = contractNumberKeyword
+	default:
+		expr = `\b`
+	}
+	expr += core.String() + `\b`
+	return &contractNumberMatcher{re: regexp.MustCompile(`(?i)` + expr), pattern: pattern}
+}
+
+// looseLiteralPattern - текст шаблона, в котором допускаются любые пробелы.
+func looseLiteralPattern(literal string) string {
+	var parts []string
+	for _, r := range literal {
+		if !unicode.IsSpace(r) {
+			parts = append(parts, regexp.QuoteMeta(string(r)))
+		}
+	}
+	return `\s*` + strings.Join(parts, `\s*`) + `\s*`
+}
+
+// Find возвращает номера договоров из текста в форме, в которой их формирует схема нумерации.
+func (m *contractNumberMatcher) Find(text string) []string {
+	var numbers []string
+	for _, match := range m.re.FindAllStringSubmatch(text, -1) {
+		i := 0
+		number := numberPlaceholderRe.ReplaceAllStringFunc(m.pattern, func(token string) string {
+			ph := numberPlaceholderRe.FindStringSubmatch(token)
+			i++
+			value := match[i]
+			switch ph[1] {
+			case "ayear":
+				return strings.Join(strings.Fields(value), "")
+			case "month":
+				n, _ := strconv.Atoi(value)
+				return fmt.Sprintf("%02d", n)
+			default:
+				n, _ := strconv.Atoi(value)
+				return fmt.Sprintf("%0*d", len(ph[2]), n)
+			}
+		})
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+// loadContractNumberMatchers строит поиск номеров по текущей схеме нумерации договоров и по схеме
+// по умолчанию, чтобы находились и договоры, заключенные до смены схемы.
+func loadContractNumberMatchers(tx *gorm.DB) []*contractNumberMatcher {
+	patterns := []string{
+		loadNumberingSettings(tx).Schemes[models.DocumentContract].Pattern,
+		defaultNumberingSchemes[models.DocumentContract].Pattern,
+	}
+	var matchers []*contractNumberMatcher
+	for i, pattern := range patterns {
+		if i > 0 && pattern == patterns[0] {
+			continue
+		}
+		if m := newContractNumberMatcher(pattern); m != nil {
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers
+}
+
+// statementMatch - результат поиска договора для строки выписки.
+type statementMatch struct {
+	ContractID uint
+	MatchedBy  string
+	Note       string
+}
+
+// ImportBankStatementHandler загружает выписку (1CClientBankExchange или CAMT.053),
+// сопоставляет поступления с договорами и создает фактические платежи для уверенных совпадений.
+// Повторная загрузка того же файла возвращает ранее импортированную выписку без изменений.
+func ImportBankStatementHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxStatementFileSize+512)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл выписки не предоставлен или слишком большой"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось открыть файл выписки"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось прочитать файл выписки"})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	fileHash := hex.EncodeToString(sum[:])
+
+	var existing models.BankStatement
+	if err := config.DB.Where("file_hash = ?", fileHash).First(&existing).Error; err == nil {
+		c.JSON(http.StatusOK, gin.H{"statement": existing, "alreadyImported": true})
+		return
+	}
+
+	parsed, err := parseBankStatement(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	statement := models.BankStatement{
+		FileName:      fileHeader.Filename,
+		Format:        parsed.Format,
+		FileHash:      fileHash,
+		AccountNumber: parsed.AccountNumber,
+		PeriodFrom:    parsed.PeriodFrom,
+		PeriodTo:      parsed.PeriodTo,
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		statement.ImportedByID = &userID
+	}
+
+	// Квитанции автоматически зачисленных строк печатаются после фиксации транзакции.
+	var postedEntryIDs []uint
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&statement).Error; err != nil {
+			return fmt.Errorf("не удалось сохранить выписку: %w", err)
+		}
+		matchers := loadContractNumberMatchers(tx)
+		for _, pl := range parsed.Lines {
+			line, err := importStatementLine(tx, &statement, pl, matchers)
+			if err != nil {
+				return err
+			}
+			statement.TotalLines++
+			if line.Status == models.StatementLineDuplicate {
+				// Строка уже учтена в другой выписке и повторно не импортирована.
+				continue
+			}
+			statement.TotalAmount += line.Amount
+			if line.PaymentEntryID != nil {
+				postedEntryIDs = append(postedEntryIDs, *line.PaymentEntryID)
+			}
+			switch line.Status {
+			case models.StatementLineMatched:
+				statement.MatchedLines++
+			case models.StatementLineUnmatched:
+				statement.UnmatchedLines++
+			}
+		}
+		return tx.Save(&statement).Error
+	})
+	if err != nil {
+		slog.Error("Bank statement import failed", "file", fileHeader.Filename, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось импортировать выписку: " + err.Error()})
+		return
+	}
+	for _, id := range postedEntryIDs {
+		go renderSourceReceipts(models.AllocationSourceLedgerEntry, id)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"statement": statement, "alreadyImported": false})
+}
+
+// importStatementLine сохраняет одну строку выписки. Строка, уже загруженная из другой выписки
+// (пересекающиеся периоды), помечается как дубликат и повторно не зачисляется.
+func importStatementLine(tx *gorm.DB, statement *models.BankStatement, pl parsedStatementLine, matchers []*contractNumberMatcher) (models.BankStatementLine, error) {
+	line := models.BankStatementLine{
+		StatementID:    statement.ID,
+		LineHash:       pl.Hash(statement.AccountNumber),
+		DocumentNumber: pl.DocumentNumber,
+		OperationDate:  pl.OperationDate,
+		Amount:         pl.Amount,
+		Currency:       pl.Currency,
+		PayerName:      pl.PayerName,
+		PayerIIN:       pl.PayerIIN,
+		PayerAccount:   pl.PayerAccount,
+		Purpose:        pl.Purpose,
+		Status:         models.StatementLineUnmatched,
+	}
+
+	var count int64
+	if err := tx.Model(&models.BankStatementLine{}).Where("line_hash = ?", line.LineHash).Count(&count).Error; err != nil {
+		return line, err
+	}
+	if count > 0 {
+		// Уникальный индекс на line_hash не даст сохранить повтор, поэтому дубликат только учитываем в счетчиках.
+		line.Status = models.StatementLineDuplicate
+		return line, nil
+	}
+
+	match, err := matchStatementLine(tx, pl, matchers)
+	if err != nil {
+		return line, err
+	}
+	line.MatchNote = match.Note
+
+	if match.ContractID != 0 {
+		line.ContractID = &match.ContractID
+		line.MatchedBy = match.MatchedBy
+		line.Status = models.StatementLineMatched
+	}
+	if err := tx.Create(&line).Error; err != nil {
+		return line, fmt.Errorf("не удалось сохранить строку выписки: %w", err)
+	}
+
+	if line.Status == models.StatementLineMatched {
+		if err := createPaymentFromStatementLine(tx, &line); err != nil {
+			return line, err
+		}
+	}
+	return line, nil
+}
+
+// matchStatementLine ищет договор по номеру договора, ИИН ученика или ИИН родителя-подписанта.
+// Совпадение считается уверенным, только если все найденные признаки указывают на один договор.
+func matchStatementLine(tx *gorm.DB, pl parsedStatementLine, matchers []*contractNumberMatcher) (statementMatch, error) {
+	text := pl.Purpose + " " + pl.PayerName
+
+	// 1. Номер договора в назначении платежа.
+	var numbers []string
+	for _, m := range matchers {
+		numbers = append(numbers, m.Find(text)...)
+	}
+	if len(numbers) > 0 {
+		var ids []uint
+		if err := tx.Model(&models.Contract{}).Where("contract_number IN ?", numbers).Distinct().Pluck("id", &ids).Error; err != nil {
+			return statementMatch{}, err
+		}
+		switch len(ids) {
+		case 1:
+			return statementMatch{ContractID: ids[0], MatchedBy: MatchedByContractNumber}, nil
+		case 0:
+			// номер указан, но договора нет - пробуем ИИН
+		default:
+			return statementMatch{Note: "В назначении указано несколько договоров"}, nil
+		}
+	}
+
+	// 2. ИИН в назначении и ИИН плательщика.
+	iins := iinPattern.FindAllString(text, -1)
+	if pl.PayerIIN != "" {
+		iins = append(iins, pl.PayerIIN)
+	}
+	if len(iins) == 0 {
+		return statementMatch{Note: "Не найден номер договора или ИИН"}, nil
+	}
+
+	var studentIDs []uint
+	if err := tx.Model(&models.Student{}).Where("iin IN ?", iins).Pluck("id", &studentIDs).Error; err != nil {
+		return statementMatch{}, err
+	}
+	matchedBy := MatchedByStudentIIN
+	if len(studentIDs) == 0 {
+		if err := tx.Model(&models.Student{}).Where("contract_parent_iin IN ?", iins).Pluck("id", &studentIDs).Error; err != nil {
+			return statementMatch{}, err
+		}
+		matchedBy = MatchedByParentIIN
+	}
+
+	switch {
+	case len(studentIDs) == 0:
+		return statementMatch{Note: "ИИН не найден среди учеников и родителей"}, nil
+	case len(studentIDs) > 1:
+		return statementMatch{Note: fmt.Sprintf("ИИН соответствует нескольким ученикам (%d)", len(studentIDs))}, nil
+	}
+
+	// У ученика может быть несколько договоров (по годам) - берем самый свежий.
+	var contract models.Contract
+	err := tx.Where("student_id = ?", studentIDs[0]).Order("start_date DESC NULLS LAST, id DESC").First(&contract).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return statementMatch{Note: "У ученика нет договора"}, nil
+	}
+	if err != nil {
+		return statementMatch{}, err
+	}
+	return statementMatch{ContractID: contract.ID, MatchedBy: matchedBy}, nil
+}
+
+// createPaymentFromStatementLine зачисляет строку выписки как фактический платеж по договору.
+// ExternalID "bank:<hash>" защищает от двойного зачисления той же операции.
+func createPaymentFromStatementLine(tx *gorm.DB, line *models.BankStatementLine) error {
+	var contract models.Contract
+	if err := tx.First(&contract, *line.ContractID).Error; err != nil {
+		return fmt.Errorf("договор %d не найден: %w", *line.ContractID, err)
+	}
+
+	externalID := "bank:" + line.LineHash
+	payment := models.LedgerEntry{
+		ContractID:    contract.ID,
+		EntryDate:     line.OperationDate,
+		Description:   statementPaymentName(line),
+		SourceType:    models.LedgerSourcePaymentFact,
+		PaymentMethod: BankStatementPaymentMethod,
+		AcademicYear:  contractAcademicYearName(tx, &contract, line.OperationDate),
+		ExternalID:    &externalID,
+	}
+	if _, _, err := recordPayment(tx, &payment, line.Amount, nil, nil); err != nil {
+		return fmt.Errorf("не удалось создать платеж по строке выписки: %w", err)
+	}
+	line.PaymentEntryID = &payment.ID
+	return tx.Model(line).Update("payment_entry_id", payment.ID).Error
+}
+
+func statementPaymentName(line *models.BankStatementLine) string {
+	if line.DocumentNumber != "" {
+		return "Оплата по выписке, п/п №" + line.DocumentNumber
+	}
+	return "Оплата по выписке"
+}
+
+// contractAcademicYearName возвращает название учебного года договора ("2025-2026"),
+// а если год не настроен - учебный год, начинающийся в сентябре, по дате платежа.
+func contractAcademicYearName(tx *gorm.DB, contract *models.Contract, paidAt time.Time) string {
+	if year, err := academicYearForContract(tx, contract); err == nil {
+		return year.Name
+	}
+	start := paidAt.Year()
+	if paidAt.Month() < time.September {
+		start--
+	}
+	return fmt.Sprintf("%d-%d", start, start+1)
+}
+
+// refreshStatementCounters пересчитывает счетчики выписки после ручной обработки строк.
+func refreshStatementCounters(tx *gorm.DB, statementID uint) error {
+	var matched, unmatched int64
+	if err := tx.Model(&models.BankStatementLine{}).
+		Where("statement_id = ? AND status IN ?", statementID, []string{models.StatementLineMatched, models.StatementLineResolved}).
+		Count(&matched).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.BankStatementLine{}).
+		Where("statement_id = ? AND status = ?", statementID, models.StatementLineUnmatched).
+		Count(&unmatched).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.BankStatement{}).Where("id = ?", statementID).Updates(map[string]interface{}{
+		"matched_lines":   matched,
+		"unmatched_lines": unmatched,
+	}).Error
+}
+
+// ListBankStatementsHandler возвращает список загруженных выписок.
+func ListBankStatementsHandler(c *gin.Context) {
+	var statements []models.BankStatement
+	var totalRows int64
+
+	query := config.DB.Model(&models.BankStatement{})
+	if err := query.Count(&totalRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать выписки"})
+		return
+	}
+	if err := query.Scopes(Paginate(c)).Order("id DESC").Find(&statements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить выписки"})
+		return
+	}
+	if statements == nil {
+		statements = make([]models.BankStatement, 0)
+	}
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, statements, totalRows))
+}
+
+// GetBankStatementHandler возвращает выписку со всеми строками.
+func GetBankStatementHandler(c *gin.Context) {
+	var statement models.BankStatement
+	err := config.DB.Preload("Lines", func(db *gorm.DB) *gorm.DB {
+		return db.Order("operation_date ASC, id ASC")
+	}).First(&statement, c.Param("id")).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Выписка не найдена"})
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
+
+// ListUnmatchedStatementLinesHandler - очередь поступлений, ожидающих ручного сопоставления.
+func ListUnmatchedStatementLinesHandler(c *gin.Context) {
+	var lines []models.BankStatementLine
+	var totalRows int64
+
+	query := config.DB.Model(&models.BankStatementLine{}).Where("status = ?", models.StatementLineUnmatched)
+	if search := c.Query("search"); search != "" {
+		searchPattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(purpose) LIKE ? OR LOWER(payer_name) LIKE ? OR payer_iin LIKE ?", searchPattern, searchPattern, searchPattern)
+	}
+
+	if err := query.Count(&totalRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать несопоставленные платежи"})
+		return
+	}
+	if err := query.Scopes(Paginate(c)).Order("operation_date ASC, id ASC").Find(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить несопоставленные платежи"})
+		return
+	}
+	if lines == nil {
+		lines = make([]models.BankStatementLine, 0)
+	}
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, lines, totalRows))
+}
+
+// ResolveStatementLineInput - ручное сопоставление строки выписки с договором.
+type ResolveStatementLineInput struct {
+	ContractID uint `json:"contractId" binding:"required"`
+}
+
+// ResolveStatementLineHandler вручную привязывает несопоставленную строку к договору и создает платеж.
+func ResolveStatementLineHandler(c *gin.Context) {
+	var input ResolveStatementLineInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан договор (contractId)"})
+		return
+	}
+	lineID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID строки"})
+		return
+	}
+
+	var line models.BankStatementLine
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&line, lineID).Error; err != nil {
+			return err
+		}
+		if line.Status != models.StatementLineUnmatched {
+			return errStatementLineProcessed
+		}
+		line.ContractID = &input.ContractID
+		line.MatchedBy = MatchedByManual
+		line.Status = models.StatementLineResolved
+		if userID, err := getUserIDFromContext(c); err == nil {
+			line.ResolvedByID = &userID
+		}
+		if err := tx.Save(&line).Error; err != nil {
+			return err
+		}
+		if err := createPaymentFromStatementLine(tx, &line); err != nil {
+			return err
+		}
+		return refreshStatementCounters(tx, line.StatementID)
+	})
+	if err != nil {
+		respondStatementLineError(c, err)
+		return
+	}
+	if line.PaymentEntryID != nil {
+		go renderSourceReceipts(models.AllocationSourceLedgerEntry, *line.PaymentEntryID)
+	}
+	c.JSON(http.StatusOK, line)
+}
+
+// IgnoreStatementLineHandler убирает строку из очереди (например, поступление не за обучение).
+func IgnoreStatementLineHandler(c *gin.Context) {
+	lineID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID строки"})
+		return
+	}
+
+	var line models.BankStatementLine
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&line, lineID).Error; err != nil {
+			return err
+		}
+		if line.Status != models.StatementLineUnmatched {
+			return errStatementLineProcessed
+		}
+		line.Status = models.StatementLineIgnored
+		if userID, err := getUserIDFromContext(c); err == nil {
+			line.ResolvedByID = &userID
+		}
+		if err := tx.Save(&line).Error; err != nil {
+			return err
+		}
+		return refreshStatementCounters(tx, line.StatementID)
+	})
+	if err != nil {
+		respondStatementLineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, line)
+}
+
+var errStatementLineProcessed = errors.New("строка выписки уже обработана")
+
+func respondStatementLineError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Строка выписки или договор не найдены"})
+	case errors.Is(err, errStatementLineProcessed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось обработать строку выписки: " + err.Error()})
+	}
+}
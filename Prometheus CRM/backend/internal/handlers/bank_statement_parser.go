@@ -0,0 +1,339 @@
+// prometheus-crm/internal/handlers/bank_statement_parser.go
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"prometheus-crm/models"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Форматы банковских выписок.
+const (
+	StatementFormat1C     = "1c"
+	StatementFormatCamt53 = "camt053"
+)
+
+// parsedStatement - результат разбора файла выписки, независимый от формата.
+type parsedStatement struct {
+	Format        string
+	AccountNumber string
+	PeriodFrom    *time.Time
+	PeriodTo      *time.Time
+	Lines         []parsedStatementLine
+}
+
+// parsedStatementLine - одно поступление (кредитовая операция) из выписки.
+type parsedStatementLine struct {
+	DocumentNumber string
+	OperationDate  time.Time
+	Amount         models.Money
+	Currency       string
+	PayerName      string
+	PayerIIN       string
+	PayerAccount   string
+	Purpose        string
+	BankReference  string
+}
+
+// Hash возвращает стабильный отпечаток операции, по которому повторный импорт распознается как дубликат.
+func (l parsedStatementLine) Hash(account string) string {
+	ref := l.BankReference
+	if ref == "" {
+		ref = l.DocumentNumber
+	}
+	raw := strings.Join([]string{
+		account,
+		ref,
+		l.OperationDate.Format("2006-01-02"),
+		l.Amount.String(),
+		l.PayerAccount,
+		strings.TrimSpace(l.Purpose),
+	}, "|")
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseBankStatement определяет формат файла и разбирает его.
+func parseBankStatement(data []byte) (*parsedStatement, error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		return parseCamt053(trimmed)
+	}
+	return parse1CClientBankExchange(trimmed)
+}
+
+// decodeStatementText возвращает текст в UTF-8. Выгрузки 1С обычно в windows-1251.
+func decodeStatementText(data []byte) (string, error) {
+	if utf8.Valid(data) {
+		return string(data), nil
+	}
+	decoded, err := charmap.Windows1251.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("не удалось перекодировать выписку из windows-1251: %w", err)
+	}
+	return string(decoded), nil
+}
+
+// parse1CClientBankExchange разбирает текстовый формат обмена 1С с клиент-банком.
+// Файл состоит из строк "Ключ=Значение"; документы ограничены "СекцияДокумент=" и "КонецДокумента".
+// В выписку попадают только поступления на наш счет (РасчСчет из заголовка).
+func parse1CClientBankExchange(data []byte) (*parsedStatement, error) {
+	text, err := decodeStatementText(data)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "1CClientBankExchange" {
+		return nil, errors.New("файл не является выпиской в формате 1CClientBankExchange")
+	}
+
+	stmt := &parsedStatement{Format: StatementFormat1C}
+	var doc map[string]string
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "КонецДокумента" {
+			if doc != nil {
+				if l, ok := oneCDocumentToLine(doc, stmt.AccountNumber); ok {
+					stmt.Lines = append(stmt.Lines, l)
+				}
+			}
+			doc = nil
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if key == "СекцияДокумент" {
+			doc = map[string]string{}
+			continue
+		}
+		if doc != nil {
+			doc[key] = value
+			continue
+		}
+
+		switch key {
+		case "РасчСчет":
+			stmt.AccountNumber = value
+		case "ДатаНачала":
+			if t, err := parse1CDate(value); err == nil {
+				stmt.PeriodFrom = &t
+			}
+		case "ДатаКонца":
+			if t, err := parse1CDate(value); err == nil {
+				stmt.PeriodTo = &t
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения выписки: %w", err)
+	}
+	return stmt, nil
+}
+
+// oneCDocumentToLine превращает секцию документа 1С в строку выписки, если это поступление.
+func oneCDocumentToLine(doc map[string]string, account string) (parsedStatementLine, bool) {
+	recipient := doc["ПолучательСчет"]
+	if recipient == "" {
+		recipient = doc["ПолучательИИК"]
+	}
+	isCredit := doc["ДатаПоступило"] != ""
+	if account != "" && recipient != "" {
+		isCredit = recipient == account
+	}
+	if !isCredit {
+		return parsedStatementLine{}, false
+	}
+
+	amount, err := models.ParseMoney(doc["Сумма"])
+	if err != nil || amount <= 0 {
+		return parsedStatementLine{}, false
+	}
+
+	dateStr := doc["ДатаПоступило"]
+	if dateStr == "" {
+		dateStr = doc["Дата"]
+	}
+	date, err := parse1CDate(dateStr)
+	if err != nil {
+		return parsedStatementLine{}, false
+	}
+
+	payerAccount := doc["ПлательщикСчет"]
+	if payerAccount == "" {
+		payerAccount = doc["ПлательщикИИК"]
+	}
+
+	return parsedStatementLine{
+		DocumentNumber: doc["Номер"],
+		OperationDate:  date,
+		Amount:         amount,
+		Currency:       "KZT",
+		PayerName:      firstNonEmpty(doc["Плательщик1"], doc["Плательщик"]),
+		PayerIIN:       firstNonEmpty(doc["ПлательщикБИН_ИИН"], doc["ПлательщикИИН"], doc["ПлательщикИНН"]),
+		PayerAccount:   payerAccount,
+		Purpose:        firstNonEmpty(doc["НазначениеПлатежа"], doc["НазначениеПлатежа1"]),
+	}, true
+}
+
+func parse1CDate(s string) (time.Time, error) {
+	return time.Parse("02.01.2006", strings.TrimSpace(s))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// --- ISO 20022 camt.053 ---
+
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	ID      string      `xml:"Id"`
+	IBAN    string      `xml:"Acct>Id>IBAN"`
+	OtherID string      `xml:"Acct>Id>Othr>Id"`
+	FromDt  string      `xml:"FrToDt>FrDtTm"`
+	ToDt    string      `xml:"FrToDt>ToDtTm"`
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtEntry struct {
+	Amount      camtAmount  `xml:"Amt"`
+	CdtDbtInd   string      `xml:"CdtDbtInd"`
+	BookingDate string      `xml:"BookgDt>Dt"`
+	ValueDate   string      `xml:"ValDt>Dt"`
+	AcctSvcrRef string      `xml:"AcctSvcrRef"`
+	Details     []camtTxDtl `xml:"NtryDtls>TxDtls"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtTxDtl struct {
+	Amount       *camtAmount `xml:"Amt"`
+	EndToEndID   string      `xml:"Refs>EndToEndId"`
+	TxID         string      `xml:"Refs>TxId"`
+	AcctSvcrRef  string      `xml:"Refs>AcctSvcrRef"`
+	DebtorName   string      `xml:"RltdPties>Dbtr>Nm"`
+	DebtorPrvtID string      `xml:"RltdPties>Dbtr>Id>PrvtId>Othr>Id"`
+	DebtorOrgID  string      `xml:"RltdPties>Dbtr>Id>OrgId>Othr>Id"`
+	DebtorIBAN   string      `xml:"RltdPties>DbtrAcct>Id>IBAN"`
+	Unstructured []string    `xml:"RmtInf>Ustrd"`
+}
+
+// parseCamt053 разбирает выписку ISO 20022 camt.053. Учитываются только кредитовые записи (CRDT);
+// если запись содержит несколько транзакций, каждая становится отдельной строкой.
+func parseCamt053(data []byte) (*parsedStatement, error) {
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("ошибка разбора camt.053: %w", err)
+	}
+	if len(doc.Statements) == 0 {
+		return nil, errors.New("в файле нет выписки camt.053 (BkToCstmrStmt/Stmt)")
+	}
+
+	stmt := &parsedStatement{Format: StatementFormatCamt53}
+	for _, s := range doc.Statements {
+		if stmt.AccountNumber == "" {
+			stmt.AccountNumber = firstNonEmpty(s.IBAN, s.OtherID)
+		}
+		if t, err := parseCamtDate(s.FromDt); err == nil && stmt.PeriodFrom == nil {
+			stmt.PeriodFrom = &t
+		}
+		if t, err := parseCamtDate(s.ToDt); err == nil {
+			stmt.PeriodTo = &t
+		}
+
+		for _, e := range s.Entries {
+			if e.CdtDbtInd != "CRDT" {
+				continue
+			}
+			date, err := parseCamtDate(firstNonEmpty(e.BookingDate, e.ValueDate))
+			if err != nil {
+				continue
+			}
+
+			details := e.Details
+			if len(details) == 0 {
+				details = []camtTxDtl{{}}
+			}
+			for i, d := range details {
+				amt := e.Amount
+				if d.Amount != nil && len(e.Details) > 1 {
+					amt = *d.Amount
+				}
+				amount, err := models.ParseMoney(amt.Value)
+				if err != nil || amount <= 0 {
+					continue
+				}
+				stmt.Lines = append(stmt.Lines, parsedStatementLine{
+					DocumentNumber: firstNonEmpty(d.EndToEndID, d.TxID),
+					OperationDate:  date,
+					Amount:         amount,
+					Currency:       amt.Currency,
+					PayerName:      d.DebtorName,
+					PayerIIN:       firstNonEmpty(d.DebtorPrvtID, d.DebtorOrgID),
+					PayerAccount:   d.DebtorIBAN,
+					Purpose:        strings.Join(d.Unstructured, " "),
+					BankReference:  camtTxReference(e, d, i),
+				})
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// camtTxReference - ссылка банка на отдельную транзакцию записи. AcctSvcrRef записи общий
+// для всех ее транзакций (пакетное зачисление), поэтому сначала берутся ссылки самой транзакции,
+// а ссылка записи дополняется номером транзакции в ней.
+func camtTxReference(e camtEntry, d camtTxDtl, index int) string {
+	endToEnd := d.EndToEndID
+	if strings.EqualFold(endToEnd, "NOTPROVIDED") {
+		endToEnd = ""
+	}
+	if ref := firstNonEmpty(d.TxID, endToEnd, d.AcctSvcrRef); ref != "" {
+		return ref
+	}
+	if e.AcctSvcrRef == "" || len(e.Details) <= 1 {
+		return e.AcctSvcrRef
+	}
+	return fmt.Sprintf("%s/%d", e.AcctSvcrRef, index+1)
+}
+
+// parseCamtDate принимает как дату (2006-01-02), так и дату-время ISO 8601.
+func parseCamtDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"prometheus-crm/models"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const oneCStatement = `1CClientBankExchange
+ВерсияФормата=1.03
+ДатаНачала=01.09.2025
+ДатаКонца=30.09.2025
+РасчСчет=KZ111
+СекцияДокумент=Платежное поручение
+Номер=15
+Дата=01.09.2025
+ДатаПоступило=02.09.2025
+Сумма=150 000,50
+ПлательщикСчет=KZ999
+Плательщик1=Иванов Иван
+ПлательщикИИН=900101300123
+ПолучательСчет=KZ111
+НазначениеПлатежа=Оплата по договору N 12-2025
+КонецДокумента
+СекцияДокумент=Платежное поручение
+Номер=16
+Дата=03.09.2025
+Сумма=5000
+ПлательщикСчет=KZ111
+ПолучательСчет=KZ555
+КонецДокумента
+СекцияДокумент=Платежное поручение
+Номер=17
+Дата=04.09.2025
+Сумма=0
+ПолучательСчет=KZ111
+КонецДокумента
+КонецФайла
+`
+
+func TestParse1CClientBankExchange(t *testing.T) {
+	cp1251, err := charmap.Windows1251.NewEncoder().String(oneCStatement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"utf-8", oneCStatement},
+		{"windows-1251", cp1251},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := parseBankStatement([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseBankStatement: %v", err)
+			}
+			if stmt.Format != StatementFormat1C || stmt.AccountNumber != "KZ111" {
+				t.Fatalf("format %q, account %q", stmt.Format, stmt.AccountNumber)
+			}
+			if stmt.PeriodFrom == nil || !stmt.PeriodFrom.Equal(time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Fatalf("period from %v", stmt.PeriodFrom)
+			}
+			// Списание со счета и нулевая сумма в выписку не попадают.
+			if len(stmt.Lines) != 1 {
+				t.Fatalf("lines = %d, want 1", len(stmt.Lines))
+			}
+			l := stmt.Lines[0]
+			want := parsedStatementLine{
+				DocumentNumber: "15",
+				OperationDate:  time.Date(2025, 9, 2, 0, 0, 0, 0, time.UTC),
+				Amount:         15000050,
+				Currency:       "KZT",
+				PayerName:      "Иванов Иван",
+				PayerIIN:       "900101300123",
+				PayerAccount:   "KZ999",
+				Purpose:        "Оплата по договору N 12-2025",
+			}
+			if l != want {
+				t.Fatalf("line = %+v, want %+v", l, want)
+			}
+		})
+	}
+}
+
+func TestParse1CClientBankExchangeRejectsOtherFiles(t *testing.T) {
+	if _, err := parseBankStatement([]byte("Дата;Сумма\n01.09.2025;100")); err == nil {
+		t.Fatal("ожидалась ошибка формата")
+	}
+}
+
+const camtStatementXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+ <BkToCstmrStmt>
+  <Stmt>
+   <Id>S1</Id>
+   <Acct><Id><IBAN>KZ111</IBAN></Id></Acct>
+   <FrToDt><FrDtTm>2025-09-01T00:00:00+05:00</FrDtTm><ToDtTm>2025-09-30T23:59:59+05:00</ToDtTm></FrToDt>
+   <Ntry>
+    <Amt Ccy="KZT">100000.00</Amt>
+    <CdtDbtInd>CRDT</CdtDbtInd>
+    <BookgDt><Dt>2025-09-05</Dt></BookgDt>
+    <AcctSvcrRef>E1</AcctSvcrRef>
+    <NtryDtls><TxDtls>
+     <Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs>
+     <RltdPties><Dbtr><Nm>Петров</Nm><Id><PrvtId><Othr><Id>900101300123</Id></Othr></PrvtId></Id></Dbtr>
+      <DbtrAcct><Id><IBAN>KZ999</IBAN></Id></DbtrAcct></RltdPties>
+     <RmtInf><Ustrd>Оплата</Ustrd><Ustrd>обучения</Ustrd></RmtInf>
+    </TxDtls></NtryDtls>
+   </Ntry>
+   <Ntry>
+    <Amt Ccy="KZT">30000.00</Amt>
+    <CdtDbtInd>CRDT</CdtDbtInd>
+    <BookgDt><Dt>2025-09-06</Dt></BookgDt>
+    <AcctSvcrRef>BATCH</AcctSvcrRef>
+    <NtryDtls>
+     <TxDtls><Amt Ccy="KZT">10000.00</Amt></TxDtls>
+     <TxDtls><Amt Ccy="KZT">20000.00</Amt><Refs><TxId>T2</TxId></Refs></TxDtls>
+    </NtryDtls>
+   </Ntry>
+   <Ntry>
+    <Amt Ccy="KZT">5000.00</Amt>
+    <CdtDbtInd>DBIT</CdtDbtInd>
+    <BookgDt><Dt>2025-09-07</Dt></BookgDt>
+   </Ntry>
+  </Stmt>
+ </BkToCstmrStmt>
+</Document>`
+
+func TestParseCamt053(t *testing.T) {
+	stmt, err := parseBankStatement([]byte(camtStatementXML))
+	if err != nil {
+		t.Fatalf("parseBankStatement: %v", err)
+	}
+	if stmt.Format != StatementFormatCamt53 || stmt.AccountNumber != "KZ111" {
+		t.Fatalf("format %q, account %q", stmt.Format, stmt.AccountNumber)
+	}
+	tests := []struct {
+		amount    models.Money
+		reference string
+		payerIIN  string
+		purpose   string
+	}{
+		{models.Tenge(100000), "E1", "900101300123", "Оплата обучения"},
+		{models.Tenge(10000), "BATCH/1", "", ""},
+		{models.Tenge(20000), "T2", "", ""},
+	}
+	if len(stmt.Lines) != len(tests) {
+		t.Fatalf("lines = %d, want %d", len(stmt.Lines), len(tests))
+	}
+	for i, tt := range tests {
+		l := stmt.Lines[i]
+		if l.Amount != tt.amount || l.BankReference != tt.reference || l.PayerIIN != tt.payerIIN || l.Purpose != tt.purpose {
+			t.Errorf("line %d = %+v, want amount %v, reference %q", i, l, tt.amount, tt.reference)
+		}
+	}
+}
+
+func TestStatementLineHash(t *testing.T) {
+	base := parsedStatementLine{
+		DocumentNumber: "15",
+		OperationDate:  time.Date(2025, 9, 2, 0, 0, 0, 0, time.UTC),
+		Amount:         models.Tenge(1000),
+		PayerAccount:   "KZ999",
+		Purpose:        "Оплата",
+	}
+	tests := []struct {
+		name  string
+		other func(parsedStatementLine) parsedStatementLine
+		equal bool
+	}{
+		{"та же операция", func(l parsedStatementLine) parsedStatementLine { return l }, true},
+		{"пробелы в назначении не важны", func(l parsedStatementLine) parsedStatementLine { l.Purpose = " Оплата "; return l }, true},
+		{"ссылка банка важнее номера документа", func(l parsedStatementLine) parsedStatementLine { l.BankReference = "R1"; return l }, false},
+		{"другая сумма", func(l parsedStatementLine) parsedStatementLine { l.Amount = 100001; return l }, false},
+		{"другая дата", func(l parsedStatementLine) parsedStatementLine {
+			l.OperationDate = l.OperationDate.AddDate(0, 0, 1)
+			return l
+		}, false},
+		{"другой плательщик", func(l parsedStatementLine) parsedStatementLine { l.PayerAccount = "KZ000"; return l }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.other(base).Hash("KZ111") == base.Hash("KZ111")
+			if got != tt.equal {
+				t.Fatalf("совпадение хэшей = %v, want %v", got, tt.equal)
+			}
+		})
+	}
+	if base.Hash("KZ111") == base.Hash("KZ222") {
+		t.Fatal("хэш должен зависеть от счета выписки")
+	}
+}
@@ -12,12 +12,12 @@ import (
 
 // ИЗМЕНЕНИЕ: Структура теперь включает заложенный бюджет и остаток
 type budgetTableRow struct {
-	DepartmentName    string  `json:"departmentName"`
-	BudgetItemName    string  `json:"budgetItemName"`
-	RegistryEntryName string  `json:"registryEntryName"`
-	DeclaredBudget    int64   `json:"declaredBudget"`
-	RemainingBudget   float64 `json:"remainingBudget"` // Новое поле для остатка
-	RegistryEntryID   uint    `json:"registryEntryId"`
+	DepartmentName    string       `json:"departmentName"`
+	BudgetItemName    string       `json:"budgetItemName"`
+	RegistryEntryName string       `json:"registryEntryName"`
+	DeclaredBudget    models.Money `json:"declaredBudget"`
+	RemainingBudget   models.Money `json:"remainingBudget"` // Новое поле для остатка
+	RegistryEntryID   uint         `json:"registryEntryId"`
 }
 
 // ИЗМЕНЕНИЕ: Функция полностью переписана для расчета остатков
@@ -31,10 +31,10 @@ func GetBudgetDataHandler(c *gin.Context) {
 	}
 
 	// Создаем карту для хранения потраченных сумм по каждой статье реестра
-	spentAmounts := make(map[uint]float64)
+	spentAmounts := make(map[uint]models.Money)
 	type TransactionSum struct {
 		RegistryEntryID uint
-		Total           float64
+		Total           models.Money
 	}
 	var transactionSums []TransactionSum
 	config.DB.Model(&models.Transaction{}).
@@ -58,7 +58,7 @@ func GetBudgetDataHandler(c *gin.Context) {
 		spent := spentAmounts[entry.ID]
 
 		// Рассчитываем остаток
-		remaining := float64(entry.Amount) - spent
+		remaining := entry.Amount - spent
 
 		row := budgetTableRow{
 			DepartmentName:    entry.BudgetItem.Department.Name,
@@ -404,9 +404,9 @@ func UpdateRegistryEntryHandler(c *gin.Context) {
 	}
 
 	var input struct {
-		Name         string `json:"name" binding:"required"`
-		Amount       int64  `json:"budget_amount" binding:"required"`
-		BudgetItemID uint   `json:"budget_item_id" binding:"required"`
+		Name         string       `json:"name" binding:"required"`
+		Amount       models.Money `json:"budget_amount" binding:"required"`
+		BudgetItemID uint         `json:"budget_item_id" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -36,6 +36,8 @@ type CombinedEvent struct {
 	DaysOfWeek []int  `json:"daysOfWeek,omitempty"` // [1] for Monday, [2] for Tuesday etc.
 	StartTime  string `json:"startTime,omitempty"`  // "HH:MM:SS"
 	EndTime    string `json:"endTime,omitempty"`    // "HH:MM:SS"
+	StartRecur string `json:"startRecur,omitempty"` // "YYYY-MM-DD", начало четверти
+	EndRecur   string `json:"endRecur,omitempty"`   // "YYYY-MM-DD", день после окончания четверти
 }
 
 // EventRequest - структура для получения данных при создании/обновлении события.
@@ -332,7 +334,19 @@ func fetchScheduleEvents(userID uint) ([]CombinedEvent, error) {
 		SubjectName  string `json:"subject_name"`
 	}
 
+	// Уроки повторяются только в пределах своей четверти учебного года.
+	quarters := make(map[string]*models.AcademicQuarter)
 	for _, schedule := range schedules {
+		key := fmt.Sprintf("%s/%d", schedule.AcademicYear, schedule.Quarter)
+		if _, ok := quarters[key]; !ok {
+			_, quarters[key], _ = academicQuarterByName(config.DB, schedule.AcademicYear, schedule.Quarter)
+		}
+		var startRecur, endRecur string
+		if q := quarters[key]; q != nil {
+			startRecur = q.StartDate.Format("2006-01-02")
+			endRecur = q.EndDate.AddDate(0, 0, 1).Format("2006-01-02")
+		}
+
 		var scheduleData map[string][]Lesson
 		// Десериализуем JSON-строку в нашу структуру
 		if err := json.Unmarshal([]byte(schedule.ScheduleData), &scheduleData); err != nil {
@@ -359,6 +373,8 @@ func fetchScheduleEvents(userID uint) ([]CombinedEvent, error) {
 					DaysOfWeek: []int{dayOfWeek},
 					StartTime:  startTime,
 					EndTime:    endTime,
+					StartRecur: startRecur,
+					EndRecur:   endRecur,
 					Editable:   false,
 					Color:      "#28a745", // Зеленый цвет для уроков
 				})
@@ -0,0 +1,368 @@
+// prometheus-crm/internal/handlers/cash_flow_forecast_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// cashFlowRateLookbackMonths - за сколько прошедших месяцев считается собираемость платежей.
+const cashFlowRateLookbackMonths = 12
+
+// CashFlowForecastMonth - строка прогноза движения денег за месяц.
+// Для прошедших месяцев Expected равен фактическим поступлениям, для текущего и будущих -
+// поступлениям месяца плюс неоплаченный остаток плана, умноженный на собираемость формы оплаты.
+type CashFlowForecastMonth struct {
+	Month    string       `json:"month"` // "2025-09"
+	Label    string       `json:"label"` // "Сентябрь 2025"
+	Planned  models.Money `json:"planned"`
+	Received models.Money `json:"received"`
+	Expected models.Money `json:"expected"`
+	Gap      models.Money `json:"gap"` // план минус ожидаемое; положительный - недобор
+	Forecast bool         `json:"forecast"`
+}
+
+// CashFlowCollectionRate - историческая собираемость по форме оплаты договора:
+// доля оплаченной части плановых платежей со сроком в прошедших месяцах.
+type CashFlowCollectionRate struct {
+	PaymentFormID   uint         `json:"paymentFormId"`
+	PaymentFormName string       `json:"paymentFormName"`
+	Planned         models.Money `json:"planned"`
+	Collected       models.Money `json:"collected"`
+	Rate            float64      `json:"rate"`
+	// HasHistory - false, если прошедших платежей нет и применена общая собираемость.
+	HasHistory bool `json:"hasHistory"`
+}
+
+// CashFlowForecast - помесячный прогноз поступлений за учебный год.
+type CashFlowForecast struct {
+	AcademicYearID uint                     `json:"academicYearId"`
+	AcademicYear   string                   `json:"academicYear"`
+	PeriodStart    time.Time                `json:"periodStart"`
+	PeriodEnd      time.Time                `json:"periodEnd"`
+	Months         []CashFlowForecastMonth  `json:"months"`
+	Totals         CashFlowForecastMonth    `json:"totals"`
+	Rates          []CashFlowCollectionRate `json:"rates"`
+}
+
+// forecastPeriod возвращает границы финансового года [from, to): двенадцать месяцев, заканчивающихся
+// месяцем окончания обучения, как в AcademicYear.DateInYear - летние предоплаты попадают в свой год.
+func forecastPeriod(year *models.AcademicYear) (time.Time, time.Time) {
+	to := time.Date(year.EndDate.Year(), year.EndDate.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(-1, 0, 0)
+	if start := time.Date(year.StartDate.Year(), year.StartDate.Month(), 1, 0, 0, 0, 0, time.UTC); start.Before(from) {
+		from = start
+	}
+	return from, to
+}
+
+// collectionRates считает собираемость по формам оплаты за cashFlowRateLookbackMonths до monthStart.
+// Переплата по строке не увеличивает собираемость: оплаченная часть ограничена плановой суммой.
+func collectionRates(tx *gorm.DB, monthStart time.Time) (map[uint]*CashFlowCollectionRate, float64, error) {
+	var rows []struct {
+		PaymentFormID   uint
+		PaymentFormName string
+		Planned         models.Money
+		Collected       models.Money
+	}
+	err := tx.Table("planned_payments pp").
+		Select(`COALESCE(c.payment_form_id, 0) AS payment_form_id,
+			COALESCE(MAX(pf.name), '') AS payment_form_name,
+			SUM(pp.planned_amount) AS planned,
+			SUM(LEAST(COALESCE(pp.paid_amount, 0), pp.planned_amount)) AS collected`).
+		Joins("JOIN contracts c ON c.id = pp.contract_id AND c.deleted_at IS NULL").
+		Joins("LEFT JOIN payment_forms pf ON pf.id = c.payment_form_id").
+		Where("pp.deleted_at IS NULL AND pp.planned_amount > 0").
+		Where("pp.payment_date >= ? AND pp.payment_date < ?", monthStart.AddDate(0, -cashFlowRateLookbackMonths, 0), monthStart).
+		Group("COALESCE(c.payment_form_id, 0)").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rates := make(map[uint]*CashFlowCollectionRate, len(rows))
+	var planned, collected models.Money
+	for _, r := range rows {
+		rates[r.PaymentFormID] = &CashFlowCollectionRate{
+			PaymentFormID:   r.PaymentFormID,
+			PaymentFormName: r.PaymentFormName,
+			Planned:         r.Planned,
+			Collected:       r.Collected,
+			Rate:            collectionRate(r.Collected, r.Planned),
+			HasHistory:      true,
+		}
+		planned += r.Planned
+		collected += r.Collected
+	}
+	// Без истории (первый год работы) прогноз совпадает с планом.
+	overall := 1.0
+	if planned > 0 {
+		overall = collectionRate(collected, planned)
+	}
+	return rates, overall, nil
+}
+
+// collectionRate возвращает долю collected в planned, округленную до сотых процента.
+func collectionRate(collected, planned models.Money) float64 {
+	if planned <= 0 {
+		return 1
+	}
+	return math.Round(float64(collected)/float64(planned)*10000) / 10000
+}
+
+// buildCashFlowForecast собирает помесячный прогноз за учебный год: план из planned_payments,
+// факт - из действующих оплат журнала расчетов, ожидание - по собираемости форм оплаты.
+func buildCashFlowForecast(tx *gorm.DB, year *models.AcademicYear, now time.Time) (*CashFlowForecast, error) {
+	from, to := forecastPeriod(year)
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	rates, overallRate, err := collectionRates(tx, currentMonth)
+	if err != nil {
+		return nil, err
+	}
+
+	var planRows []struct {
+		Month         time.Time
+		PaymentFormID uint
+		Planned       models.Money
+		Paid          models.Money
+	}
+	err = tx.Table("planned_payments pp").
+		Select(`date_trunc('month', pp.payment_date) AS month,
+			COALESCE(c.payment_form_id, 0) AS payment_form_id,
+			SUM(pp.planned_amount) AS planned,
+			SUM(COALESCE(pp.paid_amount, 0)) AS paid`).
+		Joins("JOIN contracts c ON c.id = pp.contract_id AND c.deleted_at IS NULL").
+		Where("pp.deleted_at IS NULL AND pp.payment_date >= ? AND pp.payment_date < ?", from, to).
+		Group("1, 2").
+		Scan(&planRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var receivedRows []struct {
+		Month    time.Time
+		Received models.Money
+	}
+	err = tx.Raw(`
+		SELECT date_trunc('month', entry_date) AS month, -SUM(amount) AS received
+		FROM ledger_entries
+		WHERE entry_type = ? AND `+ledgerActiveSQL+`
+			AND entry_date >= ? AND entry_date < ?
+		GROUP BY 1`, models.LedgerPayment, from, to).
+		Scan(&receivedRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := &CashFlowForecast{
+		AcademicYearID: year.ID,
+		AcademicYear:   year.Name,
+		PeriodStart:    from,
+		PeriodEnd:      to.AddDate(0, 0, -1),
+		Months:         make([]CashFlowForecastMonth, 0, 12),
+		Rates:          make([]CashFlowCollectionRate, 0, len(rates)),
+		Totals:         CashFlowForecastMonth{Label: "Итого"},
+	}
+	index := make(map[string]int)
+	for m := from; m.Before(to); m = m.AddDate(0, 1, 0) {
+		key := m.Format("2006-01")
+		index[key] = len(forecast.Months)
+		forecast.Months = append(forecast.Months, CashFlowForecastMonth{
+			Month:    key,
+			Label:    fmt.Sprintf("%s %d", russianMonths[m.Month()-1], m.Year()),
+			Forecast: !m.Before(currentMonth),
+		})
+	}
+
+	for _, r := range receivedRows {
+		if i, ok := index[r.Month.Format("2006-01")]; ok {
+			forecast.Months[i].Received += r.Received
+		}
+	}
+	for i := range forecast.Months {
+		if !forecast.Months[i].Forecast {
+			forecast.Months[i].Expected = forecast.Months[i].Received
+		}
+	}
+
+	usedForms := make(map[uint]bool)
+	for _, r := range planRows {
+		i, ok := index[r.Month.Format("2006-01")]
+		if !ok {
+			continue
+		}
+		month := &forecast.Months[i]
+		month.Planned += r.Planned
+		if !month.Forecast {
+			continue
+		}
+		// Предоплата будущих строк уже учтена в поступлениях своего месяца - прогнозируем только остаток.
+		if outstanding := r.Planned - r.Paid; outstanding > 0 {
+			rate := overallRate
+			if fr, ok := rates[r.PaymentFormID]; ok {
+				rate = fr.Rate
+			}
+			month.Expected += outstanding.Mul(rate)
+		}
+		usedForms[r.PaymentFormID] = true
+	}
+	for i := range forecast.Months {
+		month := &forecast.Months[i]
+		if month.Forecast {
+			month.Expected += month.Received
+		}
+		month.Gap = month.Planned - month.Expected
+
+		forecast.Totals.Planned += month.Planned
+		forecast.Totals.Received += month.Received
+		forecast.Totals.Expected += month.Expected
+		forecast.Totals.Gap += month.Gap
+	}
+
+	// Формы оплаты в плане без истории показываем с общей собираемостью, которая к ним применена.
+	for id := range usedForms {
+		if _, ok := rates[id]; ok {
+			continue
+		}
+		rate := &CashFlowCollectionRate{PaymentFormID: id, Rate: overallRate}
+		if id != 0 {
+			var form models.PaymentForm
+			if err := tx.Select("id", "name").First(&form, id).Error; err == nil {
+				rate.PaymentFormName = form.Name
+			}
+		}
+		rates[id] = rate
+	}
+	for _, r := range rates {
+		if r.PaymentFormID == 0 {
+			r.PaymentFormName = "Без формы оплаты"
+		}
+		forecast.Rates = append(forecast.Rates, *r)
+	}
+	sort.Slice(forecast.Rates, func(i, j int) bool {
+		return forecast.Rates[i].PaymentFormName < forecast.Rates[j].PaymentFormName
+	})
+
+	return forecast, nil
+}
+
+// resolveForecastYear возвращает учебный год из параметра academicYearId или текущий.
+func resolveForecastYear(c *gin.Context) (*models.AcademicYear, int, error) {
+	if raw := c.Query("academicYearId"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("Некорректный учебный год")
+		}
+		year, err := loadAcademicYear(config.DB, uint(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, http.StatusNotFound, errors.New("Учебный год не найден")
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("Не удалось загрузить учебный год")
+		}
+		return year, http.StatusOK, nil
+	}
+	year, err := currentAcademicYear(config.DB)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return year, http.StatusOK, nil
+}
+
+// GetCashFlowForecastHandler возвращает помесячный прогноз поступлений: план, факт, ожидание и разрыв.
+func GetCashFlowForecastHandler(c *gin.Context) {
+	year, status, err := resolveForecastYear(c)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	forecast, err := buildCashFlowForecast(config.DB, year, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось построить прогноз: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, forecast)
+}
+
+// ExportCashFlowForecastHandler выгружает прогноз поступлений в Excel.
+func ExportCashFlowForecastHandler(c *gin.Context) {
+	year, status, err := resolveForecastYear(c)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	forecast, err := buildCashFlowForecast(config.DB, year, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось построить прогноз: " + err.Error()})
+		return
+	}
+
+	f := excelize.NewFile()
+	sheetName := "Прогноз поступлений"
+	index, _ := f.NewSheet(sheetName)
+	f.SetActiveSheet(index)
+	f.DeleteSheet("Sheet1")
+
+	f.SetCellValue(sheetName, "A1", fmt.Sprintf("Прогноз поступлений, учебный год %s", forecast.AcademicYear))
+	headers := []string{"Месяц", "План", "Поступило", "Ожидается", "Разрыв", "Данные"}
+	for i, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 3)
+		f.SetCellValue(sheetName, cell, header)
+	}
+
+	writeRow := func(row int, m CashFlowForecastMonth, kind string) {
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), m.Label)
+		setMoneyCell(f, sheetName, fmt.Sprintf("B%d", row), m.Planned)
+		setMoneyCell(f, sheetName, fmt.Sprintf("C%d", row), m.Received)
+		setMoneyCell(f, sheetName, fmt.Sprintf("D%d", row), m.Expected)
+		setMoneyCell(f, sheetName, fmt.Sprintf("E%d", row), m.Gap)
+		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), kind)
+	}
+	row := 4
+	for _, m := range forecast.Months {
+		kind := "Факт"
+		if m.Forecast {
+			kind = "Прогноз"
+		}
+		writeRow(row, m, kind)
+		row++
+	}
+	writeRow(row, forecast.Totals, "")
+
+	rateSheet := "Собираемость"
+	f.NewSheet(rateSheet)
+	rateHeaders := []string{"Форма оплаты", "План за период", "Оплачено", "Собираемость, %", "Примечание"}
+	for i, header := range rateHeaders {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(rateSheet, cell, header)
+	}
+	for i, r := range forecast.Rates {
+		row := i + 2
+		f.SetCellValue(rateSheet, fmt.Sprintf("A%d", row), r.PaymentFormName)
+		setMoneyCell(f, rateSheet, fmt.Sprintf("B%d", row), r.Planned)
+		setMoneyCell(f, rateSheet, fmt.Sprintf("C%d", row), r.Collected)
+		f.SetCellFloat(rateSheet, fmt.Sprintf("D%d", row), r.Rate*100, 2, 64)
+		if !r.HasHistory {
+			f.SetCellValue(rateSheet, fmt.Sprintf("E%d", row), "Нет истории, применена общая собираемость")
+		}
+	}
+
+	fileName := fmt.Sprintf("cash_flow_forecast_%s_%s.xlsx", forecast.AcademicYear, time.Now().Format("20060102_150405"))
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	if err := f.Write(c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write Excel file"})
+	}
+}
@@ -0,0 +1,395 @@
+// prometheus-crm/internal/handlers/contract_amendment_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ContractAmendmentClassification - классификация DOCX-шаблона дополнительного соглашения.
+// Если такого шаблона нет, используется встроенный.
+const ContractAmendmentClassification = "Дополнительное соглашение"
+
+// Плейсхолдеры шаблона соглашения, на место абзацев с которыми вставляются таблицы.
+const (
+	amendmentChangesPlaceholder  = "{amendmentChangesTable}"
+	amendmentSchedulePlaceholder = "{amendmentSchedule}"
+)
+
+// AmendmentResponse - дополнительное соглашение для списка в карточке договора.
+type AmendmentResponse struct {
+	models.ContractAmendment
+	AuthorName string `json:"authorName"`
+	HasPDF     bool   `json:"hasPdf"`
+}
+
+// contractVersionOf снимает текущие условия договора.
+func contractVersionOf(contract *models.Contract) models.ContractVersion {
+	return models.ContractVersion{
+		ContractID:         contract.ID,
+		Version:            contract.Version,
+		StartDate:          contract.StartDate,
+		EndDate:            contract.EndDate,
+		TotalAmount:        contract.TotalAmount,
+		DiscountPercentage: roundPercent(contract.DiscountPercentage),
+		DiscountedAmount:   contract.DiscountedAmount,
+		PaymentFormID:      contract.PaymentFormId,
+		AcademicYearID:     contract.AcademicYearID,
+	}
+}
+
+// recordContractVersion сохраняет условия договора как версию с указанной датой начала действия.
+func recordContractVersion(tx *gorm.DB, version models.ContractVersion, amendmentID *uint, effectiveDate time.Time) error {
+	version.ID = 0
+	version.AmendmentID = amendmentID
+	version.EffectiveDate = effectiveDate
+	if err := tx.Create(&version).Error; err != nil {
+		return fmt.Errorf("не удалось сохранить версию договора: %w", err)
+	}
+	return nil
+}
+
+// contractTermChanges сравнивает условия двух версий договора. Формы оплаты и учебные годы
+// выводятся по названию, суммы - в печатном формате.
+func contractTermChanges(tx *gorm.DB, before, after models.ContractVersion) []models.ContractChange {
+	changes := make([]models.ContractChange, 0)
+	add := func(field, label, oldValue, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, models.ContractChange{Field: field, Label: label, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	date := func(d *time.Time) string {
+		if d == nil {
+			return "—"
+		}
+		return d.Format("02.01.2006")
+	}
+	name := func(model interface{}, id *uint) string {
+		if id == nil {
+			return "—"
+		}
+		var n string
+		if err := tx.Model(model).Where("id = ?", *id).Pluck("name", &n).Error; err != nil || n == "" {
+			return "№" + strconv.Itoa(int(*id))
+		}
+		return n
+	}
+
+	add("totalAmount", "Стоимость обучения", formatMoney(before.TotalAmount), formatMoney(after.TotalAmount))
+	add("discountPercentage", "Скидка, %", strconv.FormatFloat(before.DiscountPercentage, 'f', -1, 64), strconv.FormatFloat(after.DiscountPercentage, 'f', -1, 64))
+	add("discountedAmount", "Стоимость с учетом скидки", formatMoney(before.DiscountedAmount), formatMoney(after.DiscountedAmount))
+	add("paymentFormId", "Форма оплаты", name(&models.PaymentForm{}, before.PaymentFormID), name(&models.PaymentForm{}, after.PaymentFormID))
+	add("academicYearId", "Учебный год", name(&models.AcademicYear{}, before.AcademicYearID), name(&models.AcademicYear{}, after.AcademicYearID))
+	add("startDate", "Дата начала обучения", date(before.StartDate), date(after.StartDate))
+	add("endDate", "Дата окончания обучения", date(before.EndDate), date(after.EndDate))
+	return changes
+}
+
+// amendContract оформляет изменение условий договора дополнительным соглашением.
+// before - условия до изменения, contract - уже сохраненный договор с новыми условиями.
+// Если условия не изменились, соглашение не создается (возвращается nil).
+// План платежей пересчитывается с даты вступления соглашения в силу: оплаченные строки
+// сохраняются, остаток новой суммы распределяется по платежам формы начиная с этой даты.
+func amendContract(tx *gorm.DB, before models.ContractVersion, contract *models.Contract, effectiveDate time.Time, reason string, authorID *uint) (*models.ContractAmendment, error) {
+	after := contractVersionOf(contract)
+	changes := contractTermChanges(tx, before, after)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if contract.TerminatedAt != nil {
+		return nil, errContractTerminated
+	}
+
+	// Договоры, созданные до появления версий, получают версию 1 из условий до изменения.
+	var versions int64
+	if err := tx.Model(&models.ContractVersion{}).Where("contract_id = ?", contract.ID).Count(&versions).Error; err != nil {
+		return nil, err
+	}
+	if versions == 0 {
+		signed := contract.CreatedAt
+		if before.StartDate != nil {
+			signed = *before.StartDate
+		}
+		before.Version = 1
+		if err := recordContractVersion(tx, before, nil, signed); err != nil {
+			return nil, err
+		}
+	}
+
+	number, err := allocateDocumentNumber(tx, models.DocumentAmendment, NumberingContext{Date: time.Now(), Contract: contract})
+	if err != nil {
+		return nil, err
+	}
+	amendment := models.ContractAmendment{
+		ContractID:     contract.ID,
+		Number:         number.Sequence,
+		DocumentNumber: number.Number,
+		Version:        max(before.Version, 1) + 1,
+		EffectiveDate:  effectiveDate,
+		Reason:         reason,
+		Changes:        changes,
+		AuthorID:       authorID,
+	}
+	if err := tx.Create(&amendment).Error; err != nil {
+		return nil, fmt.Errorf("не удалось сохранить дополнительное соглашение: %w", err)
+	}
+	after.Version = amendment.Version
+	if err := recordContractVersion(tx, after, &amendment.ID, effectiveDate); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(contract).Update("version", amendment.Version).Error; err != nil {
+		return nil, err
+	}
+
+	if err := regenerateContractPlan(tx, contract, effectiveDate); err != nil {
+		return nil, fmt.Errorf("не удалось пересчитать план платежей: %w", err)
+	}
+	return &amendment, nil
+}
+
+// regenerateContractPlan пересчитывает существующий план платежей договора по его форме оплаты
+// с даты asOf. Договоры без формы оплаты или без плана не затрагиваются.
+func regenerateContractPlan(tx *gorm.DB, contract *models.Contract, asOf time.Time) error {
+	if contract.PaymentFormId == nil {
+		return nil
+	}
+	var rows int64
+	if err := tx.Model(&models.PlannedPayment{}).Where("contract_id = ?", contract.ID).Count(&rows).Error; err != nil {
+		return err
+	}
+	if rows == 0 {
+		return nil
+	}
+	var form models.PaymentForm
+	if err := tx.Preload("Installments").First(&form, *contract.PaymentFormId).Error; err != nil {
+		return fmt.Errorf("форма оплаты не найдена: %w", err)
+	}
+	plan, err := buildPlanRegeneration(tx, contract, &form, asOf)
+	if err != nil {
+		return err
+	}
+	return applyPlanRegeneration(tx, contract, plan)
+}
+
+// renderAmendmentPDF заполняет шаблон дополнительного соглашения и сохраняет PDF
+// рядом с договорами (contractsBaseDir()/amendments/).
+func renderAmendmentPDF(tx *gorm.DB, amendment *models.ContractAmendment) error {
+	var contract models.Contract
+	if err := tx.Unscoped().Preload("Student").First(&contract, amendment.ContractID).Error; err != nil {
+		return fmt.Errorf("договор соглашения не найден: %w", err)
+	}
+	var version models.ContractVersion
+	if err := tx.Where("contract_id = ? AND version = ?", amendment.ContractID, amendment.Version).First(&version).Error; err != nil {
+		return fmt.Errorf("версия договора %d не найдена: %w", amendment.Version, err)
+	}
+
+	var docx []byte
+	var tpl models.ContractTemplate
+	err := tx.Where("classification = ?", ContractAmendmentClassification).Order("id DESC").First(&tpl).Error
+	switch {
+	case err == nil:
+		if _, docx, err = loadTemplateVersion(tx, &tpl); err != nil {
+			return fmt.Errorf("ошибка чтения шаблона соглашения: %w", err)
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if docx, err = defaultAmendmentDocx(); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	data, err := amendmentTemplateData(tx, &contract, amendment, &version)
+	if err != nil {
+		return err
+	}
+	filled, err := renderDocxTemplate(docx, data)
+	if err != nil {
+		return err
+	}
+	pdfBytes, err := convertDocxToPdf(filled)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(contractsBaseDir(), "amendments")
+	if err := ensureDir(dir); err != nil {
+		return fmt.Errorf("не удалось создать директорию для соглашений: %w", err)
+	}
+	name := regexp.MustCompile(`[^0-9A-Za-z._-]+`).ReplaceAllString(fmt.Sprintf("%s_ds%d.pdf", contract.ContractNumber, amendment.Number), "_")
+	full := filepath.Join(dir, name)
+	if err := os.WriteFile(full, pdfBytes, 0o644); err != nil {
+		return fmt.Errorf("не удалось записать PDF соглашения: %w", err)
+	}
+	amendment.PDFFilePath = full
+	return tx.Model(amendment).Update("pdf_path", full).Error
+}
+
+// renderAmendmentAsync печатает соглашение после фиксации транзакции; при ошибке Gotenberg
+// PDF будет сформирован при первом скачивании.
+func renderAmendmentAsync(amendment models.ContractAmendment) {
+	if err := renderAmendmentPDF(config.DB, &amendment); err != nil {
+		log.Printf("Не удалось напечатать дополнительное соглашение %d к договору %d: %v", amendment.Number, amendment.ContractID, err)
+	}
+}
+
+// amendmentTemplateData - значения для шаблона соглашения: реквизиты договора, изменения
+// ({amendmentChangesTable} или блок {#changes}) и действующий график ({amendmentSchedule} или блок {#paymentSchedule}).
+func amendmentTemplateData(tx *gorm.DB, contract *models.Contract, amendment *models.ContractAmendment, version *models.ContractVersion) (TemplateData, error) {
+	var planned []models.PlannedPayment
+	if err := tx.Where("contract_id = ?", contract.ID).Order("payment_date ASC, id ASC").Find(&planned).Error; err != nil {
+		return nil, fmt.Errorf("не удалось загрузить график платежей: %w", err)
+	}
+	schedule, scheduleTotal := paymentScheduleTemplateRows(planned)
+
+	school := loadReceiptSettings(tx)
+	changes := make([]string, 0, len(amendment.Changes))
+	changeRows := make([]TemplateData, 0, len(amendment.Changes))
+	for _, ch := range amendment.Changes {
+		changes = append(changes, fmt.Sprintf("%s: %s → %s", ch.Label, ch.OldValue, ch.NewValue))
+		changeRows = append(changeRows, TemplateData{"label": ch.Label, "oldValue": ch.OldValue, "newValue": ch.NewValue})
+	}
+	data := TemplateData{
+		"contractNumber":                   contract.ContractNumber,
+		"contractDate":                     contract.CreatedAt,
+		"amendmentNumber":                  firstNonEmpty(amendment.DocumentNumber, strconv.Itoa(amendment.Number)),
+		"amendmentDate":                    amendment.CreatedAt,
+		"effectiveDate":                    formatRussianDate(amendment.EffectiveDate),
+		"amendmentReason":                  amendment.Reason,
+		"amendmentChanges":                 strings.Join(changes, "; "),
+		"amendmentChangesTable":            DocxFragment(amendmentChangesTableXML(amendment)),
+		"changes":                          changeRows,
+		"amendmentSchedule":                DocxFragment(paymentScheduleTableXML(planned)),
+		"paymentSchedule":                  schedule,
+		"paymentScheduleTotal":             scheduleTotal,
+		"schoolName":                       school.SchoolName,
+		"schoolBIN":                        school.BIN,
+		"signer":                           school.Signer,
+		"contractSum":                      version.TotalAmount,
+		"contractSumText":                  numberToWords(version.TotalAmount),
+		"contractSumTextKZ":                numberToWordsKz(version.TotalAmount),
+		"ContractSumWithDiscount":          version.DiscountedAmount,
+		"ContractSumWithDiscountText":      numberToWords(version.DiscountedAmount),
+		"ContractSumWithDiscountTextKz":    numberToWordsKz(version.DiscountedAmount),
+		"hasDiscount":                      version.DiscountedAmount < version.TotalAmount,
+		"discountPercentage":               version.DiscountPercentage,
+		"contractVersion":                  version.Version,
+		"dateAcademicStartLearn":           nil,
+		"dateAcademicEndLearn":             nil,
+		"childFullName":                    nil,
+		"fioParentForDogovor":              nil,
+		"iinParent":                        nil,
+		"childPhoneNumberParentForDogovor": nil,
+	}
+	if version.StartDate != nil {
+		data["dateAcademicStartLearn"] = formatRussianDate(*version.StartDate)
+	}
+	if version.EndDate != nil {
+		data["dateAcademicEndLearn"] = formatRussianDate(*version.EndDate)
+	}
+	if s := contract.Student; s != nil {
+		data["childFullName"] = strings.TrimSpace(fmt.Sprintf("%s %s %s", s.LastName, s.FirstName, s.MiddleName))
+		data["fioParentForDogovor"] = s.ContractParentName
+		data["iinParent"] = s.ContractParentIIN
+		data["childPhoneNumberParentForDogovor"] = s.ContractParentPhone
+	}
+	return data, nil
+}
+
+// amendmentChangesTableXML - таблица «Условие / Было / Стало».
+func amendmentChangesTableXML(amendment *models.ContractAmendment) string {
+	var b strings.Builder
+	docxTableStart(&b)
+	docxTableRow(&b, true, "Условие", "Было", "Стало")
+	for _, ch := range amendment.Changes {
+		docxTableRow(&b, false, ch.Label, ch.OldValue, ch.NewValue)
+	}
+	b.WriteString(`</w:tbl>`)
+	return b.String()
+}
+
+// defaultAmendmentDocx - встроенный шаблон дополнительного соглашения.
+func defaultAmendmentDocx() ([]byte, error) {
+	return buildDocx(
+		docxParagraph("ДОПОЛНИТЕЛЬНОЕ СОГЛАШЕНИЕ № {amendmentNumber}", true, true),
+		docxParagraph("к договору {contractNumber} от {contractDate}", false, true),
+		docxParagraph("Дата: {amendmentDate}", false, false),
+		docxParagraph("{schoolName}{#schoolBIN}, БИН {schoolBIN}{/schoolBIN}{#signer}, в лице {signer}{/signer}, и {fioParentForDogovor}, ИИН {iinParent}, законный представитель обучающегося {childFullName}, договорились внести в договор следующие изменения:", false, false),
+		docxParagraph(amendmentChangesPlaceholder, false, false),
+		docxParagraph("{#amendmentReason}Основание: {amendmentReason}{/amendmentReason}", false, false),
+		docxParagraph("Изменения вступают в силу с {effectiveDate}. Стоимость обучения с учетом скидки составляет {ContractSumWithDiscount} ({ContractSumWithDiscountText}).", false, false),
+		docxParagraph("График платежей:", true, false),
+		docxParagraph(amendmentSchedulePlaceholder, false, false),
+		docxParagraph("Остальные условия договора остаются без изменений.", false, false),
+		docxParagraph("От школы: {signer|default:} ____________          Законный представитель: ____________", false, false),
+	)
+}
+
+// --- Обработчики ---
+
+// ListContractAmendmentsHandler возвращает дополнительные соглашения договора.
+func ListContractAmendmentsHandler(c *gin.Context) {
+	var amendments []models.ContractAmendment
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).Order("number ASC").Find(&amendments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось получить дополнительные соглашения"})
+		return
+	}
+	result := make([]AmendmentResponse, 0, len(amendments))
+	for _, a := range amendments {
+		item := AmendmentResponse{ContractAmendment: a, HasPDF: fileExists(a.PDFFilePath)}
+		if a.AuthorID != nil {
+			config.DB.Model(&models.User{}).Where("id = ?", *a.AuthorID).Pluck("full_name", &item.AuthorName)
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ListContractVersionsHandler возвращает историю условий договора (версия 1 - подписанный договор).
+func ListContractVersionsHandler(c *gin.Context) {
+	var versions []models.ContractVersion
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).Order("version ASC").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось получить историю версий договора"})
+		return
+	}
+	if versions == nil {
+		versions = make([]models.ContractVersion, 0)
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// DownloadContractAmendmentHandler отдает PDF дополнительного соглашения; если он еще не
+// напечатан или передан regenerate=true - печатает заново.
+func DownloadContractAmendmentHandler(c *gin.Context) {
+	var amendment models.ContractAmendment
+	if err := config.DB.Where("id = ? AND contract_id = ?", c.Param("amendmentId"), c.Param("id")).First(&amendment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Дополнительное соглашение не найдено"})
+		return
+	}
+	if c.Query("regenerate") == "true" || !fileExists(amendment.PDFFilePath) {
+		if err := renderAmendmentPDF(config.DB, &amendment); err != nil {
+			c.JSON(documentErrorStatus(err, http.StatusBadGateway), gin.H{"error": "Не удалось сформировать PDF соглашения: " + err.Error()})
+			return
+		}
+	}
+	data, err := os.ReadFile(amendment.PDFFilePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось прочитать PDF соглашения"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filepath.Base(amendment.PDFFilePath))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
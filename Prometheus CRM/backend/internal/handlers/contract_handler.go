@@ -2,13 +2,9 @@
 package handlers
 
 import (
-	"archive/zip"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"math"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,8 +15,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/Knetic/govaluate"
-	"github.com/divan/num2words"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -28,37 +22,38 @@ import (
 // --- Структуры для входящих данных и ответов по КОНТРАКТАМ ---
 
 type ContractInput struct {
-	StudentID          uint    `json:"studentId" binding:"required"`
-	TemplateID         *uint   `json:"templateId"`
-	PaymentFormID      *uint   `json:"paymentFormId"`
-	ContractNumber     string  `json:"contractNumber"`
-	SigningMethod      string  `json:"signingMethod"`
-	StartDate          string  `json:"startDate"`
-	EndDate            string  `json:"endDate"`
-	TotalAmount        float64 `json:"totalAmount"`
-	DiscountPercentage float64 `json:"discountPercentage"`
-	DiscountedAmount   float64 `json:"discountedAmount"`
+	StudentID          uint         `json:"studentId" binding:"required"`
+	TemplateID         *uint        `json:"templateId"`
+	PaymentFormID      *uint        `json:"paymentFormId"`
+	AcademicYearID     *uint        `json:"academicYearId"` // по умолчанию - текущий учебный год
+	ContractNumber     string       `json:"contractNumber"`
+	SigningMethod      string       `json:"signingMethod"`
+	StartDate          string       `json:"startDate"`
+	EndDate            string       `json:"endDate"`
+	TotalAmount        models.Money `json:"totalAmount"`
+	DiscountPercentage float64      `json:"discountPercentage"`
+	DiscountedAmount   models.Money `json:"discountedAmount"`
+
+	// При изменении условий договора оформляется дополнительное соглашение (см. amendContract)
+	AmendmentReason string `json:"amendmentReason"`
+	EffectiveDate   string `json:"effectiveDate"` // YYYY-MM-DD, по умолчанию - сегодня
 }
 
 // StudentContractResponse - это структура для ответа API, которая включает данные студента и его договора (если он есть).
 type StudentContractResponse struct {
-	StudentID        uint       `json:"studentId"`
-	StudentFullName  string     `json:"studentFullName"`
-	StudentClass     string     `json:"studentClass"`
-	ContractID       *uint      `json:"id"`             // Может быть null, если договора нет
-	ContractNumber   *string    `json:"contractNumber"` // Может быть null
-	StartDate        *time.Time `json:"startDate"`      // Может быть null
-	EndDate          *time.Time `json:"endDate"`        // Может быть null
-	TotalAmount      *float64   `json:"totalAmount"`
-	DiscountedAmount *float64   `json:"discountedAmount"`
-	PaymentFormName  *string    `json:"paymentFormName"`
-	ManagerFullName  *string    `json:"managerFullName"`
-}
-
-// PaymentPreview представляет один платеж в сгенерированном графике.
-type PaymentPreview struct {
-	PaymentDate string  `json:"paymentDate"`
-	Amount      float64 `json:"amount"`
+	StudentID        uint          `json:"studentId"`
+	StudentFullName  string        `json:"studentFullName"`
+	StudentClass     string        `json:"studentClass"`
+	ContractID       *uint         `json:"id"`             // Может быть null, если договора нет
+	ContractNumber   *string       `json:"contractNumber"` // Может быть null
+	StartDate        *time.Time    `json:"startDate"`      // Может быть null
+	EndDate          *time.Time    `json:"endDate"`        // Может быть null
+	TotalAmount      *models.Money `json:"totalAmount"`
+	DiscountedAmount *models.Money `json:"discountedAmount"`
+	PaymentFormName  *string       `json:"paymentFormName"`
+	ManagerFullName  *string       `json:"managerFullName"`
+	TerminatedAt     *time.Time    `json:"terminatedAt"` // дата расторжения при выбытии
+	PDFStatus        *string       `json:"pdfStatus"`    // формирование PDF (см. models.ContractPDFPending)
 }
 
 // SimpleContractResponse - это структура для ответа API для выбора договора в модальном окне.
@@ -70,23 +65,14 @@ type SimpleContractResponse struct {
 }
 
 var (
-	templateCache   = make(map[uint][]byte)
-	cacheMutex      sync.RWMutex
-	allPlaceholders = []string{
-		"{contractNumber}", "{iinParent}", "{childFullName}", "{dateOfBirthChild}",
-		"{iinChild}", "{homeAddressChild}", "{fioParentForDogovor}", "{docNumParent}",
-		"{dateOfIssueDocuemntParent}", "{childPhoneNumberParentForDogovor}",
-		"{contributionOfMoney}", "{contributionOfMoneyText}", "{contributionOfMoneyTextKz}",
-		"{dateAcademicStartLearn}", "{dateAcademicEndLearn}", "{contractSum}",
-		"{contractSumText}", "{contractSumTextKZ}", "{ContractSumWithDiscount}",
-		"{ContractSumWithDiscountText}", "{ContractSumWithDiscountTextKz}",
-		"{paymentPlansPrometheusKZ}", "{paymentPlansPrometheus}", "{SignDate}",
-	}
+	templateCache = make(map[uint][]byte)
+	cacheMutex    sync.RWMutex
 )
 
 // --- Обработчики для КОНТРАКТОВ ---
 
 // ListContractsHandler теперь возвращает список ВСЕХ учеников, присоединяя к ним данные по их договорам.
+// С параметром terminated=true возвращаются только расторгнутые договоры (выбывшие ученики).
 func ListContractsHandler(c *gin.Context) {
 	var results []StudentContractResponse
 	var totalRows int64
@@ -94,7 +80,12 @@ func ListContractsHandler(c *gin.Context) {
 	// Базовый запрос
 	baseQuery := config.DB.Table("students").
 		Joins("LEFT JOIN contracts c ON students.id = c.student_id AND c.deleted_at IS NULL").
-		Where("students.deleted_at IS NULL AND students.is_studying = TRUE")
+		Where("students.deleted_at IS NULL")
+	if c.Query("terminated") == "true" {
+		baseQuery = baseQuery.Where("c.terminated_at IS NOT NULL")
+	} else {
+		baseQuery = baseQuery.Where("students.is_studying = TRUE")
+	}
 
 	// Поиск
 	searchQuery := c.Query("search")
@@ -118,7 +109,7 @@ func ListContractsHandler(c *gin.Context) {
 		(students.last_name || ' ' || students.first_name) as student_full_name,
 		(COALESCE(classes.grade_number::text, '') || ' ' || COALESCE(class_liters.liter_char, '')) as student_class,
 		c.id as contract_id, c.contract_number, c.start_date, c.end_date,
-		c.total_amount, c.discounted_amount,
+		c.total_amount, c.discounted_amount, c.terminated_at, c.pdf_status,
 		pf.name as payment_form_name,
 		u.full_name as manager_full_name
 	`).
@@ -212,33 +203,26 @@ func CreateContractHandler(c *gin.Context) {
 		return
 	}
 
-	// --- АВТОМАТИЧЕСКИЙ РАСЧЕТ СУММЫ ДОГОВОРА ПО "Стоимость обучения" ---
-	totalAmount, usedYear, err := computeTuitionAmountForStudent(&student)
-	if err != nil {
-		// Фолбэк: по классу (как раньше)
-		if student.Class.GradeNumber == 0 {
-			totalAmount = 3036000.00
-		} else {
-			totalAmount = 3610000.00
+	// --- УЧЕБНЫЙ ГОД ---
+	var academicYear *models.AcademicYear
+	var err error
+	if input.AcademicYearID != nil {
+		academicYear, err = loadAcademicYear(config.DB, *input.AcademicYearID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Учебный год не найден"})
+			return
 		}
+	} else if academicYear, err = currentAcademicYear(config.DB); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	_ = usedYear
 
-	// --- СКИДКА (родственники) ---
-	var calculatedDiscount float64
-	switch student.FamilyOrder {
-	case 1:
-		calculatedDiscount = 5.0
-	case 2, 3, 4, 5:
-		calculatedDiscount = 10.0
-	default:
-		calculatedDiscount = 0.0
+	// --- СУММА ДОГОВОРА ПО ПРАЙС-ЛИСТУ (см. tuition_pricing.go) ---
+	quote, err := quoteTuition(config.DB, &student, academicYear)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	discountedAmount := totalAmount * (1 - (calculatedDiscount / 100))
-
-	// --- ДАТЫ ---
-	startDate := time.Now()
-	endDate := startDate.AddDate(1, 0, -1)
 
 	// --- МЕНЕДЖЕР ---
 	managerID, err := getUserIDFromContext(c)
@@ -247,53 +231,168 @@ func CreateContractHandler(c *gin.Context) {
 		return
 	}
 
-	// --- ГЕНЕРАЦИЯ PDF (если выбран шаблон) ---
-	var pdfBytes []byte
-	if input.TemplateID != nil && *input.TemplateID > 0 {
+	contract, err := issueContract(&student, academicYear, quote.Amount, managerID, input.PaymentFormID, input.TemplateID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Шаблон договора не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(documentErrorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, contract)
+}
+
+// RenewContractInput - параметры продления договора на следующий учебный год.
+type RenewContractInput struct {
+	TemplateID *uint `json:"templateId"`
+}
+
+// RenewContractHandler заключает с учеником договор на учебный год, следующий за годом договора,
+// с той же формой оплаты. Сумма берется из прайс-листа нового года с учетом правил сохранения цены.
+func RenewContractHandler(c *gin.Context) {
+	var input RenewContractInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+
+	var contract models.Contract
+	if err := config.DB.First(&contract, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	if contract.TerminatedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Договор расторгнут, продление невозможно"})
+		return
+	}
+	var student models.Student
+	if err := config.DB.Preload("Class").First(&student, contract.StudentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ученик не найден"})
+		return
+	}
+
+	year, err := academicYearForContract(config.DB, &contract)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var next models.AcademicYear
+	if err := config.DB.Where("start_date > ?", year.StartDate).Order("start_date ASC").First(&next).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Следующий учебный год не добавлен в справочник учебных лет"})
+		return
+	}
+	nextYear, err := loadAcademicYear(config.DB, next.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить учебный год"})
+		return
+	}
+
+	var existing int64
+	if err := config.DB.Model(&models.Contract{}).
+		Where("student_id = ? AND academic_year_id = ? AND terminated_at IS NULL", student.ID, nextYear.ID).
+		Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось проверить договоры ученика"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "У ученика уже есть договор на " + nextYear.Name})
+		return
+	}
+
+	quote, err := quoteTuition(config.DB, &student, nextYear)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	managerID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Не удалось определить пользователя (manager_id)"})
+		return
+	}
+
+	renewed, err := issueContract(&student, nextYear, quote.Amount, managerID, contract.PaymentFormId, input.TemplateID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Шаблон договора не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(documentErrorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"contract": renewed, "quote": quote})
+}
+
+// issueContract оформляет договор на учебный год с полной стоимостью totalAmount: считает скидки,
+// при выбранном шаблоне заполняет документ (PDF прикладывается в фоне) и сохраняет договор с уникальным номером.
+// gorm.ErrRecordNotFound означает, что шаблон не найден.
+func issueContract(student *models.Student, academicYear *models.AcademicYear, totalAmount models.Money, managerID uint, paymentFormID, templateID *uint) (models.Contract, error) {
+	startDate := academicYear.StartDate
+	signDate := time.Now()
+
+	// --- СКИДКИ (по правилам, см. discount_rules.go) ---
+	discounts, err := evaluateDiscounts(config.DB, DiscountContext{
+		Student:     student,
+		TotalAmount: totalAmount,
+		OnDate:      startDate,
+	})
+	if err != nil {
+		return models.Contract{}, fmt.Errorf("ошибка расчета скидок: %w", err)
+	}
+	calculatedDiscount := discounts.Percent
+	discountedAmount := discounts.DiscountedAmount
+
+	// --- ЗАПОЛНЕНИЕ ШАБЛОНА (если выбран шаблон) ---
+	// Документ заполняется внутри транзакции создания, когда номер договора уже выделен;
+	// PDF из него формируется в фоне (см. contract_pdf_queue.go).
+	var render func(tx *gorm.DB, c *models.Contract) ([]byte, error)
+	if templateID != nil && *templateID > 0 {
 		var template models.ContractTemplate
-		if err := config.DB.First(&template, *input.TemplateID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Шаблон договора не найден"})
-			return
+		if err := config.DB.First(&template, *templateID).Error; err != nil {
+			return models.Contract{}, err
 		}
-		templateBytes, err := getTemplateBytes(*input.TemplateID, template.FilePath)
+		templateVersion, templateBytes, err := loadTemplateVersion(config.DB, &template)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения шаблона"})
-			return
-		}
-
-		// данные для плейсхолдеров
-		tempInput := &ContractInput{
-			TotalAmount:      totalAmount,
-			DiscountedAmount: discountedAmount,
+			return models.Contract{}, fmt.Errorf("ошибка чтения шаблона: %w", err)
 		}
-
-		repl, err := buildReplacements(&student, tempInput, "", startDate, "")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка подготовки данных для договора: " + err.Error()})
-			return
+		var form *models.PaymentForm
+		if paymentFormID != nil {
+			form = &models.PaymentForm{}
+			if err := config.DB.Preload("Installments").First(form, *paymentFormID).Error; err != nil {
+				return models.Contract{}, fmt.Errorf("форма оплаты не найдена: %w", err)
+			}
 		}
-		repl = fillMissingPlaceholders(repl)
 
-		filledDocx, err := replacePlaceholders(templateBytes, repl)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка замены плейсхолдеров: " + err.Error()})
-			return
-		}
-		pdfBytes, err = convertDocxToPdf(filledDocx)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка конвертации в PDF: " + err.Error()})
-			return
+		render = func(tx *gorm.DB, c *models.Contract) ([]byte, error) {
+			c.TemplateVersionID = &templateVersion.ID
+			var schedule []models.PlannedPayment
+			var err error
+			if form != nil {
+				if schedule, err = evaluatePaymentFormSchedule(c, form, academicYear); err != nil {
+					return nil, fmt.Errorf("ошибка расчета графика платежей: %w", err)
+				}
+			}
+			data, err := contractTemplateData(tx, student, c, signDate, academicYear, schedule)
+			if err != nil {
+				return nil, fmt.Errorf("ошибка подготовки данных для договора: %w", err)
+			}
+			filledDocx, err := renderDocxTemplate(templateBytes, data)
+			if err != nil {
+				return nil, fmt.Errorf("ошибка заполнения шаблона договора: %w", err)
+			}
+			return filledDocx, nil
 		}
 	}
 
-	// --- СОЗДАНИЕ ДОГОВОРА С УНИКАЛЬНОЙ НУМЕРАЦИЕЙ ---
-	contract, err := createContractWithUniqueNumber(&student, managerID, input.PaymentFormID, totalAmount, calculatedDiscount, discountedAmount, startDate, endDate, pdfBytes)
+	// --- СОЗДАНИЕ ДОГОВОРА С НОМЕРОМ ПО СХЕМЕ НУМЕРАЦИИ ---
+	contract, err := createNumberedContract(student, managerID, paymentFormID, totalAmount, calculatedDiscount, discountedAmount, academicYear, render)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения договора: " + err.Error()})
-		return
+		return contract, fmt.Errorf("ошибка сохранения договора: %w", err)
 	}
-
-	c.JSON(http.StatusCreated, contract)
+	return contract, nil
 }
 
 func GetContractHandler(c *gin.Context) {
@@ -324,9 +423,37 @@ func UpdateContractHandler(c *gin.Context) {
 		return
 	}
 
+	effectiveDate := time.Now()
+	if input.EffectiveDate != "" {
+		var err error
+		if effectiveDate, err = time.Parse("2006-01-02", input.EffectiveDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты вступления изменений. Используйте YYYY-MM-DD."})
+			return
+		}
+	}
+	before := contractVersionOf(&contract)
+
 	startDate, _ := time.ParseInLocation("2006-01-02", input.StartDate, time.Local)
 	endDate, _ := time.ParseInLocation("2006-01-02", input.EndDate, time.Local)
-	discountedAmount := input.TotalAmount * (1 - (input.DiscountPercentage / 100))
+
+	// Смена учебного года: не указанные явно даты берутся из него.
+	if input.AcademicYearID != nil {
+		year, err := loadAcademicYear(config.DB, *input.AcademicYearID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Учебный год не найден"})
+			return
+		}
+		contract.AcademicYearID = &year.ID
+		if input.StartDate == "" {
+			startDate = year.StartDate
+		}
+		if input.EndDate == "" {
+			endDate = year.EndDate
+		}
+	}
+
+	// Процент, измененный в карточке, сохраняется как ручная скидка поверх правил.
+	discountChanged := roundPercent(input.DiscountPercentage) != roundPercent(contract.DiscountPercentage)
 
 	// поля с типом *time.Time
 	contract.StartDate = &startDate
@@ -334,18 +461,54 @@ func UpdateContractHandler(c *gin.Context) {
 
 	contract.SigningMethod = input.SigningMethod
 	contract.TotalAmount = input.TotalAmount
-	contract.DiscountPercentage = input.DiscountPercentage
-	contract.DiscountedAmount = discountedAmount
 
 	// правильное имя поля в модели: PaymentFormId
 	if input.PaymentFormID != nil {
 		contract.PaymentFormId = input.PaymentFormID
 	}
 
-	if err := config.DB.Save(&contract).Error; err != nil {
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+
+	var amendment *models.ContractAmendment
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&contract).Error; err != nil {
+			return err
+		}
+		// Скидки пересчитываются по правилам; изменение суммы отражается корректирующими проводками.
+		var err error
+		if discountChanged {
+			err = setManualContractDiscount(tx, &contract, input.DiscountPercentage, userID)
+		} else {
+			_, err = applyContractDiscounts(tx, &contract)
+		}
+		if err != nil {
+			return err
+		}
+		// Изменение условий фиксируется дополнительным соглашением с пересчетом плана.
+		if err := tx.First(&contract, contract.ID).Error; err != nil {
+			return err
+		}
+		amendment, err = amendContract(tx, before, &contract, effectiveDate, strings.TrimSpace(input.AmendmentReason), userID)
+		return err
+	})
+	if errors.Is(err, errManualDiscountBelowRules) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errContractTerminated) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Договор расторгнут, изменение условий невозможно"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось обновить договор: " + err.Error()})
 		return
 	}
+	if amendment != nil {
+		go renderAmendmentAsync(*amendment)
+	}
 
 	// Пересчёт семейных скидок по связанным детям
 	go UpdateFamilyDiscounts([]uint{contract.StudentID})
@@ -381,13 +544,17 @@ func DownloadContractHandler(c *gin.Context) {
 	id := c.Param("id")
 	var contract models.Contract
 	// В модели поле должно маппиться на колонку pdf_path (например: PDFFilePath string `gorm:"column:pdf_path"`).
-	if err := config.DB.Select("pdf_path, contract_number").First(&contract, id).Error; err != nil {
+	if err := config.DB.Select("pdf_path, contract_number, pdf_status, pdf_error").First(&contract, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
 		return
 	}
 
 	if contract.PDFFilePath == "" || !fileExists(contract.PDFFilePath) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "PDF для этого договора не был сгенерирован"})
+		status := http.StatusNotFound
+		if contract.PDFStatus == models.ContractPDFPending || contract.PDFStatus == models.ContractPDFFailed {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": contractPDFUnavailable(&contract).Error()})
 		return
 	}
 
@@ -422,7 +589,8 @@ func ListStudentContractsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, contracts)
 }
 
-// PreviewPaymentPlanHandler генерирует превью плана платежей без сохранения в БД.
+// PreviewPaymentPlanHandler показывает, как изменится план платежей при выборе формы оплаты,
+// без сохранения в БД: какие строки сохранятся, изменятся, добавятся и будут удалены.
 func PreviewPaymentPlanHandler(c *gin.Context) {
 	contractID := c.Param("id")
 	var body struct {
@@ -447,213 +615,101 @@ func PreviewPaymentPlanHandler(c *gin.Context) {
 		return
 	}
 
-	parameters := make(map[string]interface{})
-	parameters["Сумма"] = contract.TotalAmount
-	parameters["Сумма с учётом скидки"] = contract.DiscountedAmount
-	parameters["Скидка"] = contract.TotalAmount - contract.DiscountedAmount
-
-	var schedule []PaymentPreview
-
-	// StartDate в модели — *time.Time, поэтому учитываем nil
-	contractYear := time.Now().Year()
-	if contract.StartDate != nil {
-		contractYear = contract.StartDate.Year()
-	}
-
-	for _, installment := range paymentForm.Installments {
-		expression, err := govaluate.NewEvaluableExpression(installment.Formula)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Ошибка в формуле '%s': %v", installment.Formula, err)})
-			return
-		}
-
-		result, err := expression.Evaluate(parameters)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Не удалось вычислить формулу: %v", err)})
-			return
-		}
-
-		amount, ok := result.(float64)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Результат формулы не является числом"})
-			return
-		}
-
-		monthIndex := getMonthIndex(installment.Month) // версия из handler_utils.go
-		paymentMonth := time.Month(monthIndex + 1)
-
-		year := contractYear
-		if paymentMonth < time.August {
-			year = contractYear + 1
-		}
-		paymentDate := time.Date(year, paymentMonth, installment.Day, 0, 0, 0, 0, time.UTC)
-
-		schedule = append(schedule, PaymentPreview{
-			PaymentDate: paymentDate.Format("02.01.2006"),
-			Amount:      amount,
-		})
+	plan, err := buildPlanRegeneration(config.DB, &contract, &paymentForm, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, schedule)
+	c.JSON(http.StatusOK, plan)
 }
 
 // --- Вспомогательные функции ---
 
-func fillMissingPlaceholders(repl map[string]string) map[string]string {
-	for _, key := range allPlaceholders {
-		if _, ok := repl[key]; !ok {
-			repl[key] = ""
-		}
-	}
-	return repl
+// numberToWords - сумма прописью по-русски: "сто пятьдесят тысяч тенге 00 тиын".
+func numberToWords(amount models.Money) string {
+	amount = amount.Abs()
+	return fmt.Sprintf("%s тенге %02d тиын", russianNumberWords(amount.Tenge()), amount.Tiyn())
 }
 
-func getTemplateBytes(templateID uint, filePath string) ([]byte, error) {
-	cacheMutex.RLock()
-	if b, ok := templateCache[templateID]; ok {
-		cacheMutex.RUnlock()
-		return b, nil
-	}
-	cacheMutex.RUnlock()
-
-	data, err := os.ReadFile(strings.TrimPrefix(filePath, "/"))
+// contractTemplateData - значения для шаблона договора. Прежние плейсхолдеры ({contractSumText},
+// {paymentPlansPrometheus} и др.) сохранены для уже загруженных шаблонов; schedule - график
+// по выбранной форме оплаты, пустой, если форма не выбрана.
+func contractTemplateData(tx *gorm.DB, student *models.Student, contract *models.Contract, signDate time.Time, academicYear *models.AcademicYear, schedule []models.PlannedPayment) (TemplateData, error) {
+	family, err := familyMembersTemplateRows(tx, student.ID)
 	if err != nil {
 		return nil, err
 	}
-	cacheMutex.Lock()
-	templateCache[templateID] = data
-	cacheMutex.Unlock()
-	return data, nil
-}
-
-func replacePlaceholders(docxBytes []byte, replacements map[string]string) ([]byte, error) {
-	zipReader, err := zip.NewReader(bytes.NewReader(docxBytes), int64(len(docxBytes)))
-	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения docx (zip): %w", err)
-	}
-	outputBuf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(outputBuf)
-	for _, file := range zipReader.File {
-		fileWriter, err := zipWriter.Create(file.Name)
-		if err != nil {
-			return nil, fmt.Errorf("ошибка создания файла в zip: %w", err)
-		}
-		fileReader, err := file.Open()
-		if err != nil {
-			return nil, fmt.Errorf("ошибка открытия файла в zip: %w", err)
-		}
-		if file.Name == "word/document.xml" || strings.HasPrefix(file.Name, "word/header") || strings.HasPrefix(file.Name, "word/footer") {
-			xmlContent, err := io.ReadAll(fileReader)
-			if err != nil {
-				fileReader.Close()
-				return nil, fmt.Errorf("ошибка чтения %s: %w", file.Name, err)
-			}
-			xmlString := string(xmlContent)
-			xmlString = strings.ReplaceAll(xmlString, "</w:t></w:r><w:r><w:t>", "")
-			for key, val := range replacements {
-				escapedVal := strings.ReplaceAll(val, "&", "&amp;")
-				escapedVal = strings.ReplaceAll(escapedVal, "<", "&lt;")
-				escapedVal = strings.ReplaceAll(escapedVal, ">", "&gt;")
-				xmlString = strings.ReplaceAll(xmlString, key, escapedVal)
-			}
-			if _, err := fileWriter.Write([]byte(xmlString)); err != nil {
-				fileReader.Close()
-				return nil, fmt.Errorf("ошибка записи в %s: %w", file.Name, err)
-			}
-		} else {
-			if _, err := io.Copy(fileWriter, fileReader); err != nil {
-				fileReader.Close()
-				return nil, fmt.Errorf("ошибка копирования файла в zip: %w", err)
-			}
-		}
-		fileReader.Close()
-	}
-	if err := zipWriter.Close(); err != nil {
-		return nil, fmt.Errorf("ошибка закрытия zip writer: %w", err)
-	}
-	return outputBuf.Bytes(), nil
+	scheduleRows, scheduleTotal := paymentScheduleTemplateRows(schedule)
+	var scheduleTable any
+	if len(schedule) > 0 {
+		scheduleTable = DocxFragment(paymentScheduleTableXML(schedule))
+	}
+	discount := contract.TotalAmount - contract.DiscountedAmount
+	// Невозвратный взнос в тексте договора совпадает с удерживаемым при выбытии (правила выбытия).
+	deposit := loadWithdrawalSettings(tx).DepositAmount
+
+	return TemplateData{
+		"contractNumber":                   contract.ContractNumber,
+		"SignDate":                         signDate,
+		"fioParentForDogovor":              student.ContractParentName,
+		"iinParent":                        student.ContractParentIIN,
+		"docNumParent":                     student.ContractParentDocumentNumber,
+		"dateOfIssueDocuemntParent":        student.ContractParentDocumentInfo,
+		"childPhoneNumberParentForDogovor": student.ContractParentPhone,
+		"parentEmail":                      student.ContractParentEmail,
+		"parentBirthDate":                  student.ContractParentBirthDate,
+		"childFullName":                    strings.TrimSpace(fmt.Sprintf("%s %s %s", student.LastName, student.FirstName, student.MiddleName)),
+		"dateOfBirthChild":                 student.BirthDate,
+		"iinChild":                         student.IIN,
+		"homeAddressChild":                 student.HomeAddress,
+		"isResident":                       student.IsResident == nil || *student.IsResident,
+		"contributionOfMoney":              deposit,
+		"contributionOfMoneyTextKz":        numberToWordsKz(deposit),
+		"contributionOfMoneyText":          numberToWords(deposit),
+		"academicYear":                     academicYear.Name,
+		"dateAcademicStartLearn":           formatRussianDate(academicYear.StartDate),
+		"dateAcademicEndLearn":             formatRussianDate(academicYear.EndDate),
+		"academicYearStart":                academicYear.StartDate,
+		"academicYearEnd":                  academicYear.EndDate,
+		"contractSum":                      contract.TotalAmount,
+		"contractSumText":                  numberToWords(contract.TotalAmount),
+		"contractSumTextKZ":                numberToWordsKz(contract.TotalAmount),
+		"ContractSumWithDiscount":          contract.DiscountedAmount,
+		"ContractSumWithDiscountText":      numberToWords(contract.DiscountedAmount),
+		"ContractSumWithDiscountTextKz":    numberToWordsKz(contract.DiscountedAmount),
+		"hasDiscount":                      discount > 0,
+		"discountPercentage":               contract.DiscountPercentage,
+		"discountAmount":                   discount,
+		"paymentSchedule":                  scheduleRows,
+		"paymentScheduleTotal":             scheduleTotal,
+		"paymentPlansPrometheus":           scheduleTable,
+		"paymentPlansPrometheusKZ":         "Төлем кестесі",
+		"familyMembers":                    family,
+	}, nil
 }
 
-func convertDocxToPdf(docxBytes []byte) ([]byte, error) {
-	gotenbergURL := "http://libreoffice-converter:3000/forms/libreoffice/convert"
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("files", "input.docx")
+// familyMembersTemplateRows - братья и сестры ученика (см. findFullFamily) для блока {#familyMembers}.
+func familyMembersTemplateRows(tx *gorm.DB, studentID uint) ([]TemplateData, error) {
+	familyIDs, err := findFullFamily(tx, studentID)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания части формы для файла: %w", err)
-	}
-	if _, err := part.Write(docxBytes); err != nil {
-		return nil, fmt.Errorf("ошибка записи DOCX в часть формы: %w", err)
-	}
-	writer.Close()
-
-	req, err := http.NewRequest("POST", gotenbergURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка создания запроса к Gotenberg: %w", err)
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка отправки запроса к Gotenberg: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ошибка конвертации DOCX в PDF через Gotenberg: статус %d, ответ: %s", resp.StatusCode, string(respBody))
-	}
-
-	pdfBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения PDF ответа от Gotenberg: %w", err)
+		return nil, fmt.Errorf("не удалось загрузить семью ученика: %w", err)
+	}
+	var relatives []models.Student
+	if err := tx.Where("id IN ? AND id <> ?", familyIDs, studentID).
+		Order("family_order ASC, birth_date ASC").Find(&relatives).Error; err != nil {
+		return nil, fmt.Errorf("не удалось загрузить семью ученика: %w", err)
+	}
+	rows := make([]TemplateData, 0, len(relatives))
+	for i, s := range relatives {
+		rows = append(rows, TemplateData{
+			"number":    i + 1,
+			"fullName":  strings.TrimSpace(fmt.Sprintf("%s %s %s", s.LastName, s.FirstName, s.MiddleName)),
+			"birthDate": s.BirthDate,
+			"iin":       s.IIN,
+		})
 	}
-
-	return pdfBytes, nil
-}
-
-func numberToWords(amount float64) string {
-	tenge := int(amount)
-	tiyn := int(math.Round((amount - float64(tenge)) * 100))
-	tengeWords := num2words.Convert(tenge)
-	return fmt.Sprintf("%s тенге %02d тиын", tengeWords, tiyn)
-}
-
-func buildReplacements(student *models.Student, input *ContractInput, contractNumber string, signDate time.Time, scheduleHTML string) (map[string]string, error) {
-	childFullName := strings.TrimSpace(fmt.Sprintf("%s %s %s", student.LastName, student.FirstName, student.MiddleName))
-	var birthDateStr string
-	if student.BirthDate != nil {
-		birthDateStr = student.BirthDate.Format("02.01.2006")
-	}
-
-	repl := map[string]string{
-		"{contractNumber}":                   contractNumber,
-		"{SignDate}":                         signDate.Format("02.01.2006"),
-		"{fioParentForDogovor}":              student.ContractParentName,
-		"{iinParent}":                        student.ContractParentIIN,
-		"{docNumParent}":                     student.ContractParentDocumentNumber,
-		"{dateOfIssueDocuemntParent}":        student.ContractParentDocumentInfo,
-		"{childPhoneNumberParentForDogovor}": student.ContractParentPhone,
-		"{childFullName}":                    childFullName,
-		"{dateOfBirthChild}":                 birthDateStr,
-		"{iinChild}":                         student.IIN,
-		"{homeAddressChild}":                 student.HomeAddress,
-		"{contributionOfMoney}":              "300000",
-		"{contributionOfMoneyTextKz}":        "үш жүз мың теңге",
-		"{contributionOfMoneyText}":          "триста тысяч тенге",
-		"{dateAcademicStartLearn}":           "01 сентября 2025 года",
-		"{dateAcademicEndLearn}":             "25 мая 2026 года",
-		"{contractSum}":                      fmt.Sprintf("%.2f", input.TotalAmount),
-		"{contractSumTextKZ}":                numberToWords(input.TotalAmount),
-		"{contractSumText}":                  numberToWords(input.TotalAmount),
-		"{ContractSumWithDiscount}":          fmt.Sprintf("%.2f", input.DiscountedAmount),
-		"{ContractSumWithDiscountTextKz}":    numberToWords(input.DiscountedAmount),
-		"{ContractSumWithDiscountText}":      numberToWords(input.DiscountedAmount),
-		"{paymentPlansPrometheusKZ}":         "Төлем кестесі",
-		"{paymentPlansPrometheus}":           scheduleHTML,
-	}
-	return repl, nil
+	return rows, nil
 }
 
 // ===== ДОП. УТИЛИТЫ ДЛЯ СОЗДАНИЯ ДОГОВОРА =====
@@ -677,134 +733,87 @@ func getUserIDFromContext(c *gin.Context) (uint, error) {
 	}
 }
 
-// computeTuitionAmountForStudent тянет цены из таблицы tuition_fees (year, amount).
-// Правило: если admission_year ≤ 2023 → используем цену за 2023; иначе — цену за максимальный доступный год.
-// Если колонка/значение admission_year недоступны — используем актуальную цену (макс. год).
-func computeTuitionAmountForStudent(student *models.Student) (amount float64, usedYear int, err error) {
-	type feeRow struct {
-		Year   int
-		Amount float64
-	}
-	var fees []feeRow
-	if err := config.DB.Table("tuition_fees").Select("year, amount").Find(&fees).Error; err != nil {
-		return 0, 0, err
-	}
-	if len(fees) == 0 {
-		return 0, 0, fmt.Errorf("справочник 'tuition_fees' пуст")
-	}
-
-	feesMap := map[int]float64{}
-	maxYear := 0
-	for _, r := range fees {
-		feesMap[r.Year] = r.Amount
-		if r.Year > maxYear {
-			maxYear = r.Year
-		}
-	}
-
-	admissionYear, _ := getStudentAdmissionYear(student.ID) // если не удастся — вернётся 0
-	cutoff := 2023
-
-	yearToUse := maxYear
-	if admissionYear > 0 && admissionYear <= cutoff {
-		yearToUse = cutoff
-	}
-	price, ok := feesMap[yearToUse]
-	if !ok {
-		// на всякий случай фолбэк на актуальный
-		price = feesMap[maxYear]
-		yearToUse = maxYear
-	}
-	return price, yearToUse, nil
-}
-
-// getStudentAdmissionYear — безопасно пытается прочитать колонку students.admission_year.
-// Если колонки нет или значение NULL/пусто — возвращает 0 без ошибки.
-func getStudentAdmissionYear(studentID uint) (int, error) {
-	var row struct {
-		AdmissionYear *int
-	}
-	err := config.DB.Table("students").Select("admission_year").Where("id = ?", studentID).Scan(&row).Error
-	if err != nil {
-		// Если колонка отсутствует/другая ошибка — не ломаем логику, просто вернём 0.
-		return 0, nil
-	}
-	if row.AdmissionYear == nil {
-		return 0, nil
-	}
-	return *row.AdmissionYear, nil
-}
-
-// createContractWithUniqueNumber создаёт договор, гарантируя уникальный contract_number.
-// Формат номера: "N {studentID}-{seq}". При конфликте — увеличивает seq и повторяет вставку (до 10 попыток).
-func createContractWithUniqueNumber(
+// createNumberedContract создаёт договор с номером по схеме нумерации договоров.
+// Номер выделяется в транзакции создания договора, поэтому при ошибке он не расходуется.
+// render (если задан) заполняет DOCX договора уже с выделенным номером; DOCX сохраняется на диск,
+// а договор ставится в очередь конвертации в PDF - ошибки конвертера не мешают созданию договора.
+func createNumberedContract(
 	student *models.Student,
 	managerID uint,
 	paymentFormID *uint,
-	totalAmount float64,
+	totalAmount models.Money,
 	discountPercent float64,
-	discountedAmount float64,
-	startDate, endDate time.Time,
-	pdfBytes []byte,
+	discountedAmount models.Money,
+	academicYear *models.AcademicYear,
+	render func(tx *gorm.DB, c *models.Contract) ([]byte, error),
 ) (models.Contract, error) {
-
-	var contract models.Contract
-	const maxTries = 10
-
-	// стартовая последовательность — количество уже существующих договоров + 1
-	var existing int64
-	if err := config.DB.Model(&models.Contract{}).Where("student_id = ?", student.ID).Count(&existing).Error; err != nil {
-		return contract, err
-	}
-	seq := int(existing) + 1
-
-	for i := 0; i < maxTries; i++ {
-		number := fmt.Sprintf("N %d-%d", student.ID, seq)
-
-		c := models.Contract{
-			StudentID:          student.ID,
-			ManagerID:          managerID,
-			ContractNumber:     number,
-			SigningMethod:      "Trust Me",
-			TotalAmount:        totalAmount,
-			DiscountPercentage: discountPercent,
-			DiscountedAmount:   discountedAmount,
-			StartDate:          &startDate,
-			EndDate:            &endDate,
-			PaymentFormId:      paymentFormID, // корректное имя поля
+	c := models.Contract{
+		StudentID:          student.ID,
+		ManagerID:          managerID,
+		SigningMethod:      "Trust Me",
+		TotalAmount:        totalAmount,
+		DiscountPercentage: discountPercent,
+		DiscountedAmount:   discountedAmount,
+		StartDate:          &academicYear.StartDate,
+		EndDate:            &academicYear.EndDate,
+		AcademicYearID:     &academicYear.ID,
+		PaymentFormId:      paymentFormID, // корректное имя поля
+	}
+
+	// Договор, расшифровка скидок и начисление в журнале расчетов создаются атомарно.
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		number, err := allocateDocumentNumber(tx, models.DocumentContract, NumberingContext{
+			Date:         time.Now(),
+			AcademicYear: academicYear,
+			Student:      student,
+		})
+		if err != nil {
+			return err
 		}
+		c.ContractNumber = number.Number
 
-		// Если PDF сгенерирован — сохраняем на диск и пишем путь в модель (поле должно маппиться на pdf_path)
-		if len(pdfBytes) > 0 {
+		// Если выбран шаблон — заполняем DOCX, сохраняем на диск и ставим договор в очередь PDF
+		if render != nil {
+			docxBytes, err := render(tx, &c)
+			if err != nil {
+				return err
+			}
 			base := contractsBaseDir()
 			if err := ensureDir(base); err != nil {
-				return contract, fmt.Errorf("не удалось создать директорию для PDF: %w", err)
+				return fmt.Errorf("не удалось создать директорию для PDF: %w", err)
 			}
 			re := regexp.MustCompile(`[^0-9A-Za-z._-]+`)
-			name := re.ReplaceAllString(fmt.Sprintf("%s.pdf", number), "_")
+			name := re.ReplaceAllString(fmt.Sprintf("%s.docx", c.ContractNumber), "_")
 			full := filepath.Join(base, name)
-			if err := os.WriteFile(full, pdfBytes, 0o644); err != nil {
-				return contract, fmt.Errorf("не удалось записать PDF: %w", err)
+			if err := os.WriteFile(full, docxBytes, 0o644); err != nil {
+				return fmt.Errorf("не удалось записать документ договора: %w", err)
 			}
-			c.PDFFilePath = full
+			c.DocxPath = full
+			c.PDFStatus = models.ContractPDFPending
 		}
 
-		err := config.DB.Create(&c).Error
-		if err == nil {
-			return c, nil
+		if err := tx.Create(&c).Error; err != nil {
+			if isUniqueViolation(err) {
+				return fmt.Errorf("номер договора %s уже занят - проверьте счетчик нумерации договоров", c.ContractNumber)
+			}
+			return err
 		}
-
-		// Конфликт уникальности номера — пробуем следующий номер.
-		if strings.Contains(strings.ToLower(err.Error()), "duplicate key value") &&
-			strings.Contains(err.Error(), "contracts_contract_number_key") {
-			seq++
-			continue
+		if _, err := applyContractDiscounts(tx, &c); err != nil {
+			return err
 		}
-		// Иная ошибка
-		return contract, err
+		// Подписанные условия - версия 1 договора.
+		return recordContractVersion(tx, contractVersionOf(&c), nil, *c.StartDate)
+	})
+	if err != nil {
+		if c.DocxPath != "" {
+			_ = os.Remove(c.DocxPath)
+		}
+		return models.Contract{}, err
 	}
-
-	return contract, fmt.Errorf("не удалось сгенерировать уникальный номер договора после %d попыток", maxTries)
+	if c.PDFStatus == models.ContractPDFPending {
+		enqueueContractPDF(c.ID)
+	}
+	return c, nil
 }
 
 // ВАЖНО: getMonthIndex УЖЕ есть в internal/handlers/handler_utils.go — не дублируем его здесь.
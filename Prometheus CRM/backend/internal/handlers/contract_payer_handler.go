@@ -0,0 +1,586 @@
+// prometheus-crm/internal/handlers/contract_payer_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var payerKinds = map[string]bool{
+	models.PayerKindParent:   true,
+	models.PayerKindEmployer: true,
+	models.PayerKindCharity:  true,
+	models.PayerKindOther:    true,
+}
+
+var errPayerNotOnContract = errors.New("плательщик не относится к этому договору")
+
+// PayerBalance - доля плательщика в договоре и расчеты по ней.
+type PayerBalance struct {
+	Payer      models.ContractPayer `json:"payer"`
+	Obligation models.Money         `json:"obligation"` // доля плательщика в сумме к оплате
+	Paid       models.Money         `json:"paid"`       // поступило от плательщика
+	Balance    models.Money         `json:"balance"`    // остаток долга (отрицательный - переплата)
+}
+
+// ContractPayersSummary - распределение суммы договора между плательщиками.
+type ContractPayersSummary struct {
+	ContractID uint `json:"contractId"`
+	// Payable - сумма к оплате по журналу расчетов: начисления минус скидки с учетом корректировок.
+	Payable models.Money   `json:"payable"`
+	Payers  []PayerBalance `json:"payers"`
+	// Uncovered - часть суммы, не распределенная между плательщиками.
+	Uncovered models.Money `json:"uncovered"`
+	// UnattributedPaid - поступления, в которых плательщик не указан.
+	UnattributedPaid models.Money `json:"unattributedPaid"`
+}
+
+// contractPayable - сумма к оплате по договору, которая делится между плательщиками.
+func contractPayable(tx *gorm.DB, contractID uint) (models.Money, error) {
+	balance, err := getContractBalance(tx, contractID)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Charged - balance.Discounts + balance.Adjustments, nil
+}
+
+// payerObligations делит сумму к оплате между плательщиками: фиксированные суммы и проценты
+// в порядке добавления плательщиков (не больше оставшейся суммы), затем остаток - плательщику
+// с долей "remainder".
+func payerObligations(payable models.Money, payers []models.ContractPayer) []models.Money {
+	obligations := make([]models.Money, len(payers))
+	left := models.MaxMoney(payable, 0)
+	remainder := -1
+	for i, payer := range payers {
+		var share models.Money
+		switch payer.ShareType {
+		case models.PayerShareFixed:
+			share = payer.FixedAmount
+		case models.PayerSharePercent:
+			share = payable.Percent(payer.SharePercent)
+		case models.PayerShareRemainder:
+			remainder = i
+			continue
+		}
+		obligations[i] = models.MinMoney(share, left)
+		left -= obligations[i]
+	}
+	if remainder >= 0 {
+		obligations[remainder] = left
+	}
+	return obligations
+}
+
+// payerPaidAmounts суммирует поступления договора по плательщикам (с учетом сторно).
+// Поступления без плательщика возвращаются отдельно.
+func payerPaidAmounts(tx *gorm.DB, contractID uint) (map[uint]models.Money, models.Money, error) {
+	var rows []struct {
+		PayerID *uint
+		Total   models.Money
+	}
+	if err := tx.Table("ledger_entries e").
+		Select("e.payer_id, -SUM(e.amount) AS total").
+		Joins("LEFT JOIN ledger_entries o ON e.reverses_id = o.id").
+		Where("e.contract_id = ? AND COALESCE(o.entry_type, e.entry_type) = ?", contractID, models.LedgerPayment).
+		Group("e.payer_id").
+		Scan(&rows).Error; err != nil {
+		return nil, 0, fmt.Errorf("не удалось посчитать поступления по плательщикам: %w", err)
+	}
+	paid := make(map[uint]models.Money, len(rows))
+	var unattributed models.Money
+	for _, r := range rows {
+		if r.PayerID == nil {
+			unattributed += r.Total
+			continue
+		}
+		paid[*r.PayerID] = r.Total
+	}
+	return paid, unattributed, nil
+}
+
+// contractPayersSummary рассчитывает доли и остатки всех плательщиков договора.
+func contractPayersSummary(tx *gorm.DB, contractID uint) (*ContractPayersSummary, error) {
+	var payers []models.ContractPayer
+	if err := tx.Where("contract_id = ?", contractID).Order("id ASC").Find(&payers).Error; err != nil {
+		return nil, err
+	}
+	payable, err := contractPayable(tx, contractID)
+	if err != nil {
+		return nil, err
+	}
+	paid, unattributed, err := payerPaidAmounts(tx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ContractPayersSummary{
+		ContractID:       contractID,
+		Payable:          payable,
+		Payers:           make([]PayerBalance, len(payers)),
+		Uncovered:        payable,
+		UnattributedPaid: unattributed,
+	}
+	for i, obligation := range payerObligations(payable, payers) {
+		summary.Payers[i] = PayerBalance{
+			Payer:      payers[i],
+			Obligation: obligation,
+			Paid:       paid[payers[i].ID],
+			Balance:    obligation - paid[payers[i].ID],
+		}
+		summary.Uncovered -= obligation
+	}
+	return summary, nil
+}
+
+// getPayerBalance возвращает долю и остаток одного плательщика договора.
+func getPayerBalance(tx *gorm.DB, contractID, payerID uint) (PayerBalance, error) {
+	summary, err := contractPayersSummary(tx, contractID)
+	if err != nil {
+		return PayerBalance{}, err
+	}
+	for _, balance := range summary.Payers {
+		if balance.Payer.ID == payerID {
+			return balance, nil
+		}
+	}
+	return PayerBalance{}, errPayerNotOnContract
+}
+
+// checkContractPayer проверяет, что плательщик платежа относится к договору платежа.
+func checkContractPayer(tx *gorm.DB, contractID uint, payerID *uint) error {
+	if payerID == nil {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.ContractPayer{}).Where("id = ? AND contract_id = ?", *payerID, contractID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errPayerNotOnContract
+	}
+	return nil
+}
+
+// splitPlanByPayers приводит неоплаченные строки графика договора в соответствие с плательщиками:
+// строки на одну дату с одним названием объединяются и заново делятся между плательщиками
+// пропорционально остаткам их долей. Частично и полностью оплаченные строки не меняются.
+// Если плательщиков нет, разделенные ранее строки снова становятся общими строками договора.
+func splitPlanByPayers(tx *gorm.DB, contractID uint) error {
+	summary, err := contractPayersSummary(tx, contractID)
+	if err != nil {
+		return err
+	}
+	weights := make([]float64, len(summary.Payers))
+	var totalWeight float64
+	for i, balance := range summary.Payers {
+		if balance.Balance > 0 {
+			weights[i] = float64(balance.Balance)
+			totalWeight += weights[i]
+		}
+	}
+
+	var rows []models.PlannedPayment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("contract_id = ? AND paid_amount = 0 AND planned_amount > 0", contractID).
+		Order("payment_date ASC, id ASC").
+		Find(&rows).Error; err != nil {
+		return fmt.Errorf("не удалось загрузить график платежей: %w", err)
+	}
+
+	type groupKey struct {
+		date string
+		name string
+	}
+	groups := make(map[groupKey][]models.PlannedPayment)
+	order := make([]groupKey, 0)
+	for _, row := range rows {
+		key := groupKey{row.PaymentDate.Format("2006-01-02"), row.PaymentName}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		split := false
+		var total models.Money
+		for _, row := range group {
+			total += row.PlannedAmount
+			split = split || row.PayerID != nil
+		}
+		if !split && totalWeight == 0 {
+			continue // общая строка и делить не на кого
+		}
+
+		// Первая строка группы переиспользуется, остальные удаляются и создаются заново.
+		base := group[0]
+		for _, row := range group[1:] {
+			if err := tx.Delete(&models.PlannedPayment{}, row.ID).Error; err != nil {
+				return fmt.Errorf("не удалось удалить строку графика %d: %w", row.ID, err)
+			}
+		}
+		if totalWeight == 0 {
+			if err := tx.Model(&models.PlannedPayment{}).Where("id = ?", base.ID).Updates(map[string]interface{}{
+				"planned_amount": total,
+				"payer_id":       nil,
+			}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		reused := false
+		for i, amount := range total.Allocate(weights) {
+			if amount <= 0 {
+				continue
+			}
+			payerID := summary.Payers[i].Payer.ID
+			if !reused {
+				reused = true
+				if err := tx.Model(&models.PlannedPayment{}).Where("id = ?", base.ID).Updates(map[string]interface{}{
+					"planned_amount": amount,
+					"payer_id":       payerID,
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			row := models.PlannedPayment{
+				ContractID:    contractID,
+				PayerID:       &payerID,
+				PaymentDate:   base.PaymentDate,
+				PlannedAmount: amount,
+				PaymentName:   base.PaymentName,
+				Comment:       base.Comment,
+				Status:        PlannedStatusPending,
+			}
+			if err := tx.Create(&row).Error; err != nil {
+				return fmt.Errorf("не удалось сохранить строку графика: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// buildPayerReconciliationAct строит акт сверки с одним плательщиком договора: его доля
+// отражается начислением на дату начала договора, оплаты - по проводкам с этим плательщиком.
+func buildPayerReconciliationAct(tx *gorm.DB, contract *models.Contract, payerID uint, from, to time.Time) (*ReconciliationAct, error) {
+	balance, err := getPayerBalance(tx, contract.ID, payerID)
+	if err != nil {
+		return nil, err
+	}
+	summary := ReconciliationContract{ContractID: contract.ID, ContractNumber: contract.ContractNumber}
+	if contract.Student != nil {
+		summary.StudentName = strings.TrimSpace(contract.Student.LastName + " " + contract.Student.FirstName + " " + contract.Student.MiddleName)
+	}
+	act := &ReconciliationAct{
+		Subject:   summary.StudentName,
+		PayerName: balance.Payer.Name,
+		From:      from,
+		To:        to,
+		Lines:     make([]ReconciliationLine, 0),
+	}
+
+	shareDate := dateOnly(contract.CreatedAt)
+	if contract.StartDate != nil {
+		shareDate = dateOnly(*contract.StartDate)
+	}
+	switch {
+	case shareDate.Before(from):
+		summary.OpeningBalance += balance.Obligation
+	case !shareDate.After(to):
+		act.Lines = append(act.Lines, ReconciliationLine{
+			Date:           shareDate,
+			ContractNumber: contract.ContractNumber,
+			Kind:           ledgerKindNames[models.LedgerCharge],
+			Description:    "Доля плательщика в стоимости обучения",
+			Debit:          balance.Obligation,
+		})
+		summary.Debit += balance.Obligation
+		act.Charges += balance.Obligation
+	}
+
+	var before models.Money
+	if err := tx.Model(&models.LedgerEntry{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("contract_id = ? AND payer_id = ? AND entry_date < ?", contract.ID, payerID, from).
+		Scan(&before).Error; err != nil {
+		return nil, fmt.Errorf("не удалось рассчитать сальдо на начало периода: %w", err)
+	}
+	summary.OpeningBalance += before
+
+	var entries []models.LedgerEntry
+	if err := tx.Where("contract_id = ? AND payer_id = ? AND entry_date >= ? AND entry_date <= ?", contract.ID, payerID, from, to).
+		Order("entry_date, id").
+		Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("не удалось загрузить операции за период: %w", err)
+	}
+	for _, e := range entries {
+		line := ReconciliationLine{
+			Date:           e.EntryDate,
+			ContractNumber: contract.ContractNumber,
+			Kind:           ledgerKindNames[models.LedgerPayment],
+			Description:    e.Description,
+		}
+		if e.ReversesID != nil {
+			line.Kind = "Сторно: " + strings.ToLower(line.Kind)
+		}
+		if e.Amount >= 0 {
+			line.Debit = e.Amount
+		} else {
+			line.Credit = -e.Amount
+		}
+		summary.Debit += line.Debit
+		summary.Credit += line.Credit
+		act.Payments -= e.Amount
+		act.Lines = append(act.Lines, line)
+	}
+
+	summary.ClosingBalance = summary.OpeningBalance + summary.Debit - summary.Credit
+	act.Contracts = []ReconciliationContract{summary}
+	act.OpeningBalance = summary.OpeningBalance
+	act.TotalDebit = summary.Debit
+	act.TotalCredit = summary.Credit
+	act.ClosingBalance = summary.ClosingBalance
+	return act, nil
+}
+
+// ContractPayerInput - плательщик в запросе.
+type ContractPayerInput struct {
+	Kind         string       `json:"kind"`
+	Name         string       `json:"name"`
+	IIN          string       `json:"iin"`
+	Phone        string       `json:"phone"`
+	Email        string       `json:"email"`
+	Address      string       `json:"address"`
+	ShareType    string       `json:"shareType"`
+	SharePercent float64      `json:"sharePercent"`
+	FixedAmount  models.Money `json:"fixedAmount"`
+	Comment      string       `json:"comment"`
+}
+
+func (in ContractPayerInput) apply(payer *models.ContractPayer) {
+	payer.Kind = in.Kind
+	payer.Name = strings.TrimSpace(in.Name)
+	payer.IIN = strings.TrimSpace(in.IIN)
+	payer.Phone = strings.TrimSpace(in.Phone)
+	payer.Email = strings.TrimSpace(in.Email)
+	payer.Address = strings.TrimSpace(in.Address)
+	payer.ShareType = in.ShareType
+	payer.SharePercent = 0
+	payer.FixedAmount = 0
+	switch in.ShareType {
+	case models.PayerSharePercent:
+		payer.SharePercent = in.SharePercent
+	case models.PayerShareFixed:
+		payer.FixedAmount = in.FixedAmount
+	}
+	payer.Comment = strings.TrimSpace(in.Comment)
+}
+
+// validateContractPayer проверяет плательщика и то, что доли всех плательщиков
+// не превышают сумму к оплате по договору.
+func validateContractPayer(tx *gorm.DB, payer *models.ContractPayer) error {
+	if !payerKinds[payer.Kind] {
+		return errors.New("неизвестный вид плательщика")
+	}
+	if payer.Name == "" {
+		return errors.New("укажите наименование или ФИО плательщика")
+	}
+	if payer.IIN != "" && (len(payer.IIN) != 12 || strings.Trim(payer.IIN, "0123456789") != "") {
+		return errors.New("ИИН/БИН должен состоять из 12 цифр")
+	}
+	switch payer.ShareType {
+	case models.PayerSharePercent:
+		if payer.SharePercent <= 0 || payer.SharePercent > 100 {
+			return errors.New("процент доли должен быть больше 0 и не больше 100")
+		}
+	case models.PayerShareFixed:
+		if payer.FixedAmount <= 0 {
+			return errors.New("фиксированная сумма должна быть больше нуля")
+		}
+	case models.PayerShareRemainder:
+	default:
+		return errors.New("неизвестный способ задания доли")
+	}
+
+	var others []models.ContractPayer
+	if err := tx.Where("contract_id = ? AND id <> ?", payer.ContractID, payer.ID).Find(&others).Error; err != nil {
+		return err
+	}
+	payable, err := contractPayable(tx, payer.ContractID)
+	if err != nil {
+		return err
+	}
+	var assigned models.Money
+	for _, p := range append(others, *payer) {
+		switch p.ShareType {
+		case models.PayerShareRemainder:
+			if p.ID != payer.ID && payer.ShareType == models.PayerShareRemainder {
+				return fmt.Errorf("остаток суммы уже оплачивает %s", p.Name)
+			}
+		case models.PayerShareFixed:
+			assigned += p.FixedAmount
+		case models.PayerSharePercent:
+			assigned += payable.Percent(p.SharePercent)
+		}
+	}
+	if assigned > payable {
+		return fmt.Errorf("доли плательщиков (%s) превышают сумму к оплате по договору (%s)", formatMoney(assigned), formatMoney(payable))
+	}
+	return nil
+}
+
+// --- Обработчики ---
+
+// ListContractPayersHandler возвращает плательщиков договора с долями и остатками.
+func ListContractPayersHandler(c *gin.Context) {
+	contractID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID договора"})
+		return
+	}
+	summary, err := contractPayersSummary(config.DB, uint(contractID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить плательщиков: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// CreateContractPayerHandler добавляет плательщика и заново делит неоплаченный график между плательщиками.
+func CreateContractPayerHandler(c *gin.Context) {
+	var contract models.Contract
+	if err := config.DB.First(&contract, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	var input ContractPayerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	payer := models.ContractPayer{ContractID: contract.ID}
+	input.apply(&payer)
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateContractPayer(tx, &payer); err != nil {
+			return err
+		}
+		if err := tx.Create(&payer).Error; err != nil {
+			return err
+		}
+		return splitPlanByPayers(tx, contract.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, payer)
+}
+
+// UpdateContractPayerHandler изменяет плательщика и заново делит неоплаченный график.
+func UpdateContractPayerHandler(c *gin.Context) {
+	var payer models.ContractPayer
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).First(&payer, c.Param("payerId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Плательщик не найден"})
+		return
+	}
+	var input ContractPayerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	input.apply(&payer)
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateContractPayer(tx, &payer); err != nil {
+			return err
+		}
+		if err := tx.Save(&payer).Error; err != nil {
+			return err
+		}
+		return splitPlanByPayers(tx, payer.ContractID)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payer)
+}
+
+// DeleteContractPayerHandler удаляет плательщика, от которого еще не было платежей.
+// Его неоплаченные строки графика делятся между оставшимися плательщиками.
+func DeleteContractPayerHandler(c *gin.Context) {
+	var payer models.ContractPayer
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).First(&payer, c.Param("payerId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Плательщик не найден"})
+		return
+	}
+	var payments int64
+	if err := config.DB.Model(&models.LedgerEntry{}).Where("payer_id = ?", payer.ID).Count(&payments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось проверить платежи плательщика"})
+		return
+	}
+	if payments > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "От плательщика уже поступали платежи, удалить его нельзя"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PlannedPayment{}).Where("payer_id = ?", payer.ID).Update("payer_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&payer).Error; err != nil {
+			return err
+		}
+		return splitPlanByPayers(tx, payer.ContractID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить плательщика: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Плательщик удален"})
+}
+
+// GetPayerReconciliationActHandler строит акт сверки с одним плательщиком договора.
+// Параметры те же, что у акта по договору: from, to, format, templateId.
+func GetPayerReconciliationActHandler(c *gin.Context) {
+	from, to, err := reconciliationPeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var contract models.Contract
+	if err := config.DB.Preload("Student").First(&contract, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	payerID, err := strconv.ParseUint(c.Param("payerId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID плательщика"})
+		return
+	}
+
+	act, err := buildPayerReconciliationAct(config.DB, &contract, uint(payerID), from, to)
+	if errors.Is(err, errPayerNotOnContract) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Плательщик не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondReconciliationAct(c, act, fmt.Sprintf("act_%s_payer_%d", contract.ContractNumber, payerID))
+}
@@ -0,0 +1,185 @@
+// prometheus-crm/internal/handlers/contract_pdf_queue.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PDF договора формируется в фоне: договор создается сразу с заполненным DOCX (см. createNumberedContract),
+// а обработчики очереди конвертируют его и прикладывают PDF. Договоры, конвертация которых не удалась
+// или не успела до перезапуска сервера, подбирает периодический обход.
+const (
+	contractPDFWorkers     = 2
+	contractPDFQueueSize   = 256
+	contractPDFSweepPeriod = 5 * time.Minute
+	// maxContractPDFAttempts - сколько раз обход повторяет неудачную конвертацию; дальше - только вручную.
+	maxContractPDFAttempts = 5
+)
+
+var (
+	contractPDFQueue     = make(chan uint, contractPDFQueueSize)
+	contractPDFStartOnce sync.Once
+	contractPDFInFlight  sync.Map // ID договоров, которые конвертируются сейчас
+)
+
+// StartContractPDFWorker запускает обработчики очереди PDF договоров и обход договоров без PDF.
+// Вызывается при старте сервера вместе с остальными фоновыми задачами (routes.startBackgroundJobs):
+// обход догоняет договоры, оставшиеся в очереди до перезапуска.
+func StartContractPDFWorker(ctx context.Context) {
+	startContractPDFWorkers(ctx)
+	go func() {
+		ticker := time.NewTicker(contractPDFSweepPeriod)
+		defer ticker.Stop()
+		for {
+			sweepContractPDFs()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func startContractPDFWorkers(ctx context.Context) {
+	contractPDFStartOnce.Do(func() {
+		for i := 0; i < contractPDFWorkers; i++ {
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case id := <-contractPDFQueue:
+						processContractPDF(ctx, id)
+					}
+				}
+			}()
+		}
+	})
+}
+
+// enqueueContractPDF ставит договор в очередь конвертации. При переполненной очереди договор
+// остается в статусе pending и будет подобран обходом.
+func enqueueContractPDF(contractID uint) {
+	startContractPDFWorkers(context.Background())
+	select {
+	case contractPDFQueue <- contractID:
+	default:
+		slog.Warn("Contract PDF queue is full", "contractId", contractID)
+	}
+}
+
+// sweepContractPDFs ставит в очередь договоры, ожидающие PDF, и неудачные - пока не исчерпаны попытки.
+func sweepContractPDFs() {
+	var ids []uint
+	if err := config.DB.Model(&models.Contract{}).
+		Where("pdf_status = ? OR (pdf_status = ? AND pdf_attempts < ?)", models.ContractPDFPending, models.ContractPDFFailed, maxContractPDFAttempts).
+		Order("id").Pluck("id", &ids).Error; err != nil {
+		slog.Error("Contract PDF sweep failed", "error", err)
+		return
+	}
+	for _, id := range ids {
+		enqueueContractPDF(id)
+	}
+}
+
+func processContractPDF(ctx context.Context, contractID uint) {
+	if _, busy := contractPDFInFlight.LoadOrStore(contractID, true); busy {
+		return
+	}
+	defer contractPDFInFlight.Delete(contractID)
+
+	var contract models.Contract
+	if err := config.DB.First(&contract, contractID).Error; err != nil {
+		return // договор удален
+	}
+	if contract.PDFStatus != models.ContractPDFPending && contract.PDFStatus != models.ContractPDFFailed {
+		return
+	}
+	if err := attachContractPDF(ctx, &contract); err != nil {
+		slog.Error("Contract PDF conversion failed", "contractId", contractID, "attempt", contract.PDFAttempts+1, "error", err)
+		config.DB.Model(&models.Contract{}).Where("id = ?", contractID).Updates(map[string]any{
+			"pdf_status":   models.ContractPDFFailed,
+			"pdf_attempts": gorm.Expr("pdf_attempts + 1"),
+			"pdf_error":    err.Error(),
+		})
+	}
+}
+
+// attachContractPDF конвертирует заполненный DOCX договора и сохраняет PDF рядом с ним.
+func attachContractPDF(ctx context.Context, contract *models.Contract) error {
+	docx, err := os.ReadFile(contract.DocxPath)
+	if err != nil {
+		return fmt.Errorf("заполненный документ договора не найден: %w", err)
+	}
+	pdfBytes, err := convertDocxToPdfContext(ctx, docx)
+	if err != nil {
+		return fmt.Errorf("ошибка конвертации в PDF: %w", err)
+	}
+	full := strings.TrimSuffix(contract.DocxPath, ".docx") + ".pdf"
+	if err := os.WriteFile(full, pdfBytes, 0o644); err != nil {
+		return fmt.Errorf("не удалось записать PDF: %w", err)
+	}
+	if err := config.DB.Model(&models.Contract{}).Where("id = ?", contract.ID).Updates(map[string]any{
+		"pdf_path":   full,
+		"pdf_status": models.ContractPDFReady,
+		"pdf_error":  "",
+		"docx_path":  "",
+	}).Error; err != nil {
+		return err
+	}
+	_ = os.Remove(contract.DocxPath)
+	return nil
+}
+
+// contractPDFUnavailable - текст ошибки скачивания договора, PDF которого еще не приложен.
+func contractPDFUnavailable(contract *models.Contract) error {
+	switch contract.PDFStatus {
+	case models.ContractPDFPending:
+		return errors.New("PDF договора формируется - повторите через минуту")
+	case models.ContractPDFFailed:
+		return fmt.Errorf("не удалось сформировать PDF договора: %s", contract.PDFError)
+	}
+	return errors.New("PDF для этого договора не был сгенерирован")
+}
+
+// RetryContractPDFHandler повторяет формирование PDF договора после сбоя конвертера
+// (в том числе когда обход исчерпал попытки).
+func RetryContractPDFHandler(c *gin.Context) {
+	var contract models.Contract
+	if err := config.DB.First(&contract, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	switch {
+	case contract.PDFStatus == models.ContractPDFReady:
+		c.JSON(http.StatusConflict, gin.H{"error": "PDF договора уже сформирован"})
+		return
+	case contract.PDFStatus == "" || !fileExists(contract.DocxPath):
+		c.JSON(http.StatusConflict, gin.H{"error": "У договора нет заполненного документа для конвертации"})
+		return
+	}
+	if err := config.DB.Model(&contract).Updates(map[string]any{
+		"pdf_status":   models.ContractPDFPending,
+		"pdf_attempts": 0,
+		"pdf_error":    "",
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось обновить договор"})
+		return
+	}
+	enqueueContractPDF(contract.ID)
+	c.JSON(http.StatusAccepted, gin.H{"pdfStatus": models.ContractPDFPending})
+}
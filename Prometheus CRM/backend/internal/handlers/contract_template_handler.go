@@ -1,27 +1,236 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"prometheus-crm/config"
 	"prometheus-crm/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+const contractTemplatesDir = "static/uploads/contract_templates"
+
+// Плейсхолдеры, которые заполняются для документов каждой классификации шаблона
+// (см. contractTemplateData, amendmentTemplateData, terminationAgreementTemplateData,
+// reconciliationActTemplateData). Поля элементов списков записаны как "список.поле".
+var (
+	contractTemplatePlaceholders = []string{
+		"contractNumber", "SignDate", "fioParentForDogovor", "iinParent", "docNumParent",
+		"dateOfIssueDocuemntParent", "childPhoneNumberParentForDogovor", "parentEmail", "parentBirthDate",
+		"childFullName", "dateOfBirthChild", "iinChild", "homeAddressChild", "isResident",
+		"contributionOfMoney", "contributionOfMoneyText", "contributionOfMoneyTextKz",
+		"academicYear", "dateAcademicStartLearn", "dateAcademicEndLearn", "academicYearStart", "academicYearEnd",
+		"contractSum", "contractSumText", "contractSumTextKZ", "ContractSumWithDiscount",
+		"ContractSumWithDiscountText", "ContractSumWithDiscountTextKz",
+		"hasDiscount", "discountPercentage", "discountAmount",
+		"paymentSchedule", "paymentSchedule.number", "paymentSchedule.date", "paymentSchedule.name", "paymentSchedule.amount",
+		"paymentScheduleTotal", "paymentPlansPrometheus", "paymentPlansPrometheusKZ",
+		"familyMembers", "familyMembers.number", "familyMembers.fullName", "familyMembers.birthDate", "familyMembers.iin",
+	}
+	templatePlaceholdersByClassification = map[string][]string{
+		ContractAmendmentClassification: {
+			"contractNumber", "contractDate", "amendmentNumber", "amendmentDate", "effectiveDate",
+			"amendmentReason", "amendmentChanges", "amendmentChangesTable",
+			"changes", "changes.label", "changes.oldValue", "changes.newValue",
+			"amendmentSchedule", "paymentSchedule", "paymentSchedule.number", "paymentSchedule.date",
+			"paymentSchedule.name", "paymentSchedule.amount", "paymentScheduleTotal",
+			"schoolName", "schoolBIN", "signer", "contractSum", "contractSumText", "contractSumTextKZ",
+			"ContractSumWithDiscount", "ContractSumWithDiscountText", "ContractSumWithDiscountTextKz",
+			"hasDiscount", "discountPercentage", "contractVersion", "dateAcademicStartLearn", "dateAcademicEndLearn",
+			"childFullName", "fioParentForDogovor", "iinParent", "childPhoneNumberParentForDogovor",
+		},
+		TerminationAgreementClassification: {
+			"contractNumber", "contractDate", "terminationNumber", "SignDate", "schoolName", "schoolBIN", "signer",
+			"withdrawalDate", "withdrawalReason", "withdrawalMethod", "periodsCharged", "periodsTotal",
+			"contractSum", "contractSumText", "contractSumTextKZ", "depositAmount", "depositAmountText",
+			"chargeableAmount", "chargeableAmountText", "paidAmount", "paidAmountText",
+			"refundAmount", "refundAmountText", "debtAmount", "debtAmountText", "hasRefund", "hasDebt",
+			"childFullName", "fioParentForDogovor", "iinParent", "iinChild",
+		},
+		ReconciliationActClassification: {
+			"actSchoolName", "actSchoolBIN", "actSigner", "actSubject", "actPayer", "actDateFrom", "actDateTo",
+			"actDate", "actOpeningBalance", "actCharges", "actDiscounts", "actPayments", "actRefunds",
+			"actTotalDebit", "actTotalCredit", "actClosingBalance", "actClosingText", "actTable",
+			"actLines", "actLines.date", "actLines.contractNumber", "actLines.operation",
+			"actLines.description", "actLines.debit", "actLines.credit",
+		},
+	}
+)
+
+// knownTemplatePlaceholders - набор плейсхолдеров для классификации; остальные классификации
+// (Ученик, Контрагент, Сотрудник) - шаблоны договоров.
+func knownTemplatePlaceholders(classification string) map[string]bool {
+	names, ok := templatePlaceholdersByClassification[classification]
+	if !ok {
+		names = contractTemplatePlaceholders
+	}
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	return known
+}
+
+// --- Версии и кэш файлов шаблонов ---
+
+// templateVersionBytes возвращает файл версии шаблона. Версии неизменяемы, поэтому кэш по ID версии
+// не устаревает: новая загрузка создает новую версию с новым ключом.
+func templateVersionBytes(version *models.ContractTemplateVersion) ([]byte, error) {
+	cacheMutex.RLock()
+	if b, ok := templateCache[version.ID]; ok {
+		cacheMutex.RUnlock()
+		return b, nil
+	}
+	cacheMutex.RUnlock()
+
+	data, err := os.ReadFile(strings.TrimPrefix(version.FilePath, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if version.Checksum != "" && fileChecksum(data) != version.Checksum {
+		return nil, fmt.Errorf("файл версии %d шаблона изменен на диске - загрузите шаблон заново", version.Version)
+	}
+	cacheMutex.Lock()
+	templateCache[version.ID] = data
+	cacheMutex.Unlock()
+	return data, nil
+}
+
+// forgetTemplateVersions убирает версии из кэша (при удалении шаблона).
+func forgetTemplateVersions(versionIDs []uint) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	for _, id := range versionIDs {
+		delete(templateCache, id)
+	}
+}
+
+// loadTemplateVersion возвращает действующую версию шаблона и ее файл.
+func loadTemplateVersion(tx *gorm.DB, template *models.ContractTemplate) (*models.ContractTemplateVersion, []byte, error) {
+	if template.CurrentVersionID == nil {
+		return nil, nil, fmt.Errorf("у шаблона %q нет загруженного файла", template.Name)
+	}
+	var version models.ContractTemplateVersion
+	if err := tx.First(&version, *template.CurrentVersionID).Error; err != nil {
+		return nil, nil, fmt.Errorf("версия шаблона не найдена: %w", err)
+	}
+	data, err := templateVersionBytes(&version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &version, data, nil
+}
+
+func fileChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readUploadedTemplate читает загруженный файл шаблона и проверяет его плейсхолдеры.
+// Шаблон с неизвестными плейсхолдерами или ошибками разметки не принимается.
+func readUploadedTemplate(file *multipart.FileHeader, classification string) ([]byte, models.TemplateScan, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, models.TemplateScan{}, fmt.Errorf("не удалось прочитать файл: %w", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, models.TemplateScan{}, fmt.Errorf("не удалось прочитать файл: %w", err)
+	}
+	scan, err := scanDocxTemplate(data, knownTemplatePlaceholders(classification))
+	if err != nil {
+		return nil, scan, err
+	}
+	if len(scan.Unknown) > 0 || len(scan.Errors) > 0 {
+		return nil, scan, errors.New("шаблон содержит неизвестные плейсхолдеры или ошибки разметки")
+	}
+	return data, scan, nil
+}
+
+// saveTemplateVersion сохраняет файл новой версией шаблона и делает ее действующей.
+func saveTemplateVersion(tx *gorm.DB, template *models.ContractTemplate, fileName string, data []byte, scan models.TemplateScan, uploadedBy *uint) (*models.ContractTemplateVersion, error) {
+	var last int
+	if err := tx.Model(&models.ContractTemplateVersion{}).Where("template_id = ?", template.ID).
+		Select("COALESCE(MAX(version), 0)").Scan(&last).Error; err != nil {
+		return nil, err
+	}
+	if err := ensureDir(contractTemplatesDir); err != nil {
+		return nil, fmt.Errorf("не удалось создать директорию шаблонов: %w", err)
+	}
+	safeName := regexp.MustCompile(`[^0-9A-Za-zА-Яа-яЁё._-]+`).ReplaceAllString(filepath.Base(fileName), "_")
+	name := fmt.Sprintf("%d_v%d_%s", template.ID, last+1, safeName)
+	if err := os.WriteFile(filepath.Join(contractTemplatesDir, name), data, 0o644); err != nil {
+		return nil, fmt.Errorf("не удалось сохранить файл шаблона: %w", err)
+	}
+
+	version := models.ContractTemplateVersion{
+		TemplateID:       template.ID,
+		Version:          last + 1,
+		FilePath:         "/" + contractTemplatesDir + "/" + name,
+		OriginalFileName: fileName,
+		FileSize:         int64(len(data)),
+		Checksum:         fileChecksum(data),
+		Scan:             scan,
+		UploadedByID:     uploadedBy,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	template.CurrentVersionID = &version.ID
+	template.CurrentVersion = &version
+	template.FilePath = version.FilePath
+	template.OriginalFileName = version.OriginalFileName
+	template.FileSize = version.FileSize
+	return &version, nil
+}
+
+// uploaderID - ID пользователя запроса, если он определен.
+func uploaderID(c *gin.Context) *uint {
+	if id, err := getUserIDFromContext(c); err == nil {
+		return &id
+	}
+	return nil
+}
+
+// templateScanError отвечает 400 с результатом проверки шаблона; неизвестные плейсхолдеры
+// и ошибки разметки перечисляются и в тексте ошибки, чтобы их увидел пользователь.
+func templateScanError(c *gin.Context, err error, scan models.TemplateScan) {
+	msg := err.Error()
+	if len(scan.Unknown) > 0 {
+		msg += ". Неизвестные плейсхолдеры: " + strings.Join(scan.Unknown, ", ")
+	}
+	if len(scan.Errors) > 0 {
+		msg += ". " + strings.Join(scan.Errors, "; ")
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": msg, "scan": scan})
+}
+
 // ListContractTemplatesHandler возвращает список шаблонов договоров
 func ListContractTemplatesHandler(c *gin.Context) {
 	var templates []models.ContractTemplate
-	if err := config.DB.Find(&templates).Error; err != nil {
+	if err := config.DB.Preload("CurrentVersion").Find(&templates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch templates"})
 		return
 	}
 	c.JSON(http.StatusOK, templates)
 }
 
-// CreateContractTemplateHandler создает новый шаблон договора
+// CreateContractTemplateHandler создает новый шаблон договора с версией 1 загруженного файла.
+// Файл предварительно проверяется: неизвестные плейсхолдеры и ошибки разметки возвращаются с 400.
 func CreateContractTemplateHandler(c *gin.Context) {
 	// Используем FormFile для получения файла из multipart/form-data
 	file, err := c.FormFile("file")
@@ -29,39 +238,38 @@ func CreateContractTemplateHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
 		return
 	}
-
-	// Создаем директорию, если она не существует
-	uploadDir := "/app/static/uploads/contract_templates"
-	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
-		os.MkdirAll(uploadDir, os.ModePerm)
-	}
-
-	// Генерируем уникальное имя файла и сохраняем его
-	newFileName := fmt.Sprintf("%d_%s", c.MustGet("user_id"), file.Filename)
-	filePath := filepath.Join(uploadDir, newFileName)
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	classification := c.PostForm("classification")
+	data, scan, err := readUploadedTemplate(file, classification)
+	if err != nil {
+		templateScanError(c, err, scan)
 		return
 	}
 
 	template := models.ContractTemplate{
-		Name:             c.PostForm("name"),
-		SignatureType:    c.PostForm("signatureType"),
-		Classification:   c.PostForm("classification"),
-		Status:           c.PostForm("status"),
-		FilePath:         "/static/uploads/contract_templates/" + newFileName,
-		OriginalFileName: file.Filename,
-		FileSize:         file.Size,
+		Name:           c.PostForm("name"),
+		SignatureType:  c.PostForm("signatureType"),
+		Classification: classification,
+		Status:         c.PostForm("status"),
 	}
-
-	if err := config.DB.Create(&template).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template in DB"})
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&template).Error; err != nil {
+			return err
+		}
+		if _, err := saveTemplateVersion(tx, &template, file.Filename, data, scan, uploaderID(c)); err != nil {
+			return err
+		}
+		return tx.Omit("CurrentVersion").Save(&template).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template in DB: " + err.Error()})
 		return
 	}
 	c.JSON(http.StatusCreated, template)
 }
 
-// UpdateContractTemplateHandler обновляет существующий шаблон
+// UpdateContractTemplateHandler обновляет существующий шаблон. Новый файл сохраняется новой версией,
+// прежние версии остаются для уже сформированных договоров. При смене классификации без нового файла
+// действующая версия проверяется на плейсхолдеры новой классификации.
 func UpdateContractTemplateHandler(c *gin.Context) {
 	id := c.Param("id")
 	var template models.ContractTemplate
@@ -70,38 +278,53 @@ func UpdateContractTemplateHandler(c *gin.Context) {
 		return
 	}
 
+	classificationChanged := template.Classification != c.PostForm("classification")
 	template.Name = c.PostForm("name")
 	template.SignatureType = c.PostForm("signatureType")
 	template.Classification = c.PostForm("classification")
 	template.Status = c.PostForm("status")
 
-	// Проверяем, был ли загружен новый файл
+	var data []byte
+	var scan models.TemplateScan
 	file, err := c.FormFile("file")
 	if err == nil {
-		// Логика сохранения нового файла (аналогично Create)
-		uploadDir := "/app/static/uploads/contract_templates"
-		newFileName := fmt.Sprintf("%d_%s", c.MustGet("user_id"), file.Filename)
-		filePath := filepath.Join(uploadDir, newFileName)
-		if err := c.SaveUploadedFile(file, filePath); err == nil {
-			// Удаляем старый файл, если он был
-			if template.FilePath != "" {
-				oldPath := filepath.Join("../..", template.FilePath)
-				os.Remove(oldPath)
-			}
-			template.FilePath = "/static/uploads/contract_templates/" + newFileName
-			template.OriginalFileName = file.Filename
-			template.FileSize = file.Size
+		if data, scan, err = readUploadedTemplate(file, template.Classification); err != nil {
+			templateScanError(c, err, scan)
+			return
+		}
+	} else if classificationChanged && template.CurrentVersionID != nil {
+		_, current, err := loadTemplateVersion(config.DB, &template)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if scan, err = scanDocxTemplate(current, knownTemplatePlaceholders(template.Classification)); err != nil {
+			templateScanError(c, err, scan)
+			return
+		}
+		if len(scan.Unknown) > 0 || len(scan.Errors) > 0 {
+			templateScanError(c, errors.New("действующая версия шаблона не подходит для новой классификации"), scan)
+			return
 		}
 	}
 
-	if err := config.DB.Save(&template).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template"})
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if data != nil {
+			if _, err := saveTemplateVersion(tx, &template, file.Filename, data, scan, uploaderID(c)); err != nil {
+				return err
+			}
+		}
+		return tx.Omit("CurrentVersion").Save(&template).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template: " + err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, template)
 }
 
-// DeleteContractTemplateHandler удаляет шаблон
+// DeleteContractTemplateHandler удаляет шаблон. Файлы версий остаются на диске:
+// на них ссылаются договоры, сформированные по шаблону.
 func DeleteContractTemplateHandler(c *gin.Context) {
 	id := c.Param("id")
 	var template models.ContractTemplate
@@ -110,17 +333,14 @@ func DeleteContractTemplateHandler(c *gin.Context) {
 		return
 	}
 
-	// Удаляем файл с диска
-	if template.FilePath != "" {
-		filePath := filepath.Join("../..", template.FilePath)
-		os.Remove(filePath)
-	}
-
 	// Удаляем запись из БД
 	if err := config.DB.Delete(&template).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template from DB"})
 		return
 	}
+	var versionIDs []uint
+	config.DB.Model(&models.ContractTemplateVersion{}).Where("template_id = ?", template.ID).Pluck("id", &versionIDs)
+	forgetTemplateVersions(versionIDs)
 	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
 }
 
@@ -128,9 +348,212 @@ func DeleteContractTemplateHandler(c *gin.Context) {
 func GetContractTemplateHandler(c *gin.Context) {
 	id := c.Param("id")
 	var template models.ContractTemplate
-	if err := config.DB.First(&template, id).Error; err != nil {
+	if err := config.DB.Preload("CurrentVersion").First(&template, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// ListContractTemplateVersionsHandler возвращает версии шаблона, новые первыми,
+// с числом договоров, сформированных по каждой.
+func ListContractTemplateVersionsHandler(c *gin.Context) {
+	var versions []models.ContractTemplateVersion
+	if err := config.DB.Where("template_id = ?", c.Param("id")).Order("version DESC").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить версии шаблона"})
+		return
+	}
+	type versionUsage struct {
+		TemplateVersionID uint
+		Contracts         int64
+	}
+	var usage []versionUsage
+	if err := config.DB.Model(&models.Contract{}).
+		Select("template_version_id, COUNT(*) AS contracts").
+		Joins("JOIN contract_template_versions v ON v.id = contracts.template_version_id").
+		Where("v.template_id = ?", c.Param("id")).
+		Group("template_version_id").Scan(&usage).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать договоры по версиям"})
+		return
+	}
+	contracts := make(map[uint]int64, len(usage))
+	for _, u := range usage {
+		contracts[u.TemplateVersionID] = u.Contracts
+	}
+
+	result := make([]gin.H, 0, len(versions))
+	for _, v := range versions {
+		result = append(result, gin.H{"version": v, "contracts": contracts[v.ID]})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ActivateContractTemplateVersionHandler делает выбранную версию действующей (откат к прежнему файлу).
+func ActivateContractTemplateVersionHandler(c *gin.Context) {
+	var template models.ContractTemplate
+	if err := config.DB.First(&template, c.Param("id")).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 		return
 	}
+	var version models.ContractTemplateVersion
+	if err := config.DB.Where("id = ? AND template_id = ?", c.Param("versionId"), template.ID).First(&version).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Версия шаблона не найдена"})
+		return
+	}
+	data, err := templateVersionBytes(&version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	scan, err := scanDocxTemplate(data, knownTemplatePlaceholders(template.Classification))
+	if err == nil && (len(scan.Unknown) > 0 || len(scan.Errors) > 0) {
+		err = errors.New("версия не подходит для текущей классификации шаблона")
+	}
+	if err != nil {
+		templateScanError(c, err, scan)
+		return
+	}
+
+	template.CurrentVersionID = &version.ID
+	template.FilePath = version.FilePath
+	template.OriginalFileName = version.OriginalFileName
+	template.FileSize = version.FileSize
+	if err := config.DB.Omit("CurrentVersion").Save(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template"})
+		return
+	}
+	template.CurrentVersion = &version
 	c.JSON(http.StatusOK, template)
 }
+
+// PreviewContractTemplateHandler формирует PDF по версии шаблона (versionId, по умолчанию - действующей)
+// на данных ученика studentId, ничего не сохраняя. Данные берутся из последнего договора ученика:
+// для соглашений, расторжений и актов - из его последнего соглашения, расторжения или акта за учебный год.
+// Если договора нет, шаблон договора заполняется образцом на текущий учебный год по прайс-листу.
+func PreviewContractTemplateHandler(c *gin.Context) {
+	var template models.ContractTemplate
+	if err := config.DB.First(&template, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	var student models.Student
+	if err := config.DB.Preload("Class").First(&student, c.Query("studentId")).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Выберите ученика для предпросмотра"})
+		return
+	}
+
+	var version *models.ContractTemplateVersion
+	var docx []byte
+	var err error
+	if versionID := c.Query("versionId"); versionID != "" {
+		version = &models.ContractTemplateVersion{}
+		if err := config.DB.Where("id = ? AND template_id = ?", versionID, template.ID).First(version).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Версия шаблона не найдена"})
+			return
+		}
+		docx, err = templateVersionBytes(version)
+	} else {
+		version, docx, err = loadTemplateVersion(config.DB, &template)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := templatePreviewData(config.DB, &template, &student)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filled, err := renderDocxTemplate(docx, data)
+	if err != nil {
+		c.JSON(documentErrorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	pdfBytes, err := convertDocxToPdf(filled)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", "inline; filename=preview_v"+strconv.Itoa(version.Version)+".pdf")
+	c.Data(http.StatusOK, "application/pdf", pdfBytes)
+}
+
+// templatePreviewData собирает данные предпросмотра шаблона для ученика (см. PreviewContractTemplateHandler).
+func templatePreviewData(tx *gorm.DB, template *models.ContractTemplate, student *models.Student) (TemplateData, error) {
+	var contract models.Contract
+	err := tx.Where("student_id = ?", student.ID).Order("id DESC").First(&contract).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	hasContract := err == nil
+	contract.Student = student
+
+	switch template.Classification {
+	case ContractAmendmentClassification:
+		var amendment models.ContractAmendment
+		if !hasContract || tx.Where("contract_id = ?", contract.ID).Order("number DESC").First(&amendment).Error != nil {
+			return nil, errors.New("у ученика нет дополнительных соглашений для предпросмотра")
+		}
+		var version models.ContractVersion
+		if err := tx.Where("contract_id = ? AND version = ?", contract.ID, amendment.Version).First(&version).Error; err != nil {
+			return nil, fmt.Errorf("версия договора %d не найдена: %w", amendment.Version, err)
+		}
+		return amendmentTemplateData(tx, &contract, &amendment, &version)
+
+	case TerminationAgreementClassification:
+		var withdrawal models.ContractWithdrawal
+		if !hasContract || tx.Where("contract_id = ?", contract.ID).First(&withdrawal).Error != nil {
+			return nil, errors.New("договор ученика не расторгнут - предпросмотр соглашения недоступен")
+		}
+		return terminationAgreementTemplateData(tx, &contract, &withdrawal), nil
+
+	case ReconciliationActClassification:
+		if !hasContract {
+			return nil, errors.New("у ученика нет договоров для акта сверки")
+		}
+		year, err := academicYearForContract(tx, &contract)
+		if err != nil {
+			return nil, err
+		}
+		act, err := buildReconciliationAct(tx, []models.Contract{contract}, year.StartDate, dateOnly(time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		act.Subject = act.Contracts[0].StudentName
+		act.PayerName = student.ContractParentName
+		return reconciliationActTemplateData(tx, act), nil
+	}
+
+	var year *models.AcademicYear
+	var schedule []models.PlannedPayment
+	signDate := contract.CreatedAt
+	if hasContract {
+		if year, err = academicYearForContract(tx, &contract); err != nil {
+			return nil, err
+		}
+		if err := tx.Where("contract_id = ?", contract.ID).Order("payment_date ASC, id ASC").Find(&schedule).Error; err != nil {
+			return nil, fmt.Errorf("не удалось загрузить график платежей: %w", err)
+		}
+	} else {
+		if year, err = currentAcademicYear(tx); err != nil {
+			return nil, err
+		}
+		quote, err := quoteTuition(tx, student, year)
+		if err != nil {
+			return nil, err
+		}
+		discounts, err := evaluateDiscounts(tx, DiscountContext{Student: student, TotalAmount: quote.Amount, OnDate: year.StartDate})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка расчета скидок: %w", err)
+		}
+		contract = models.Contract{
+			ContractNumber:     "ОБРАЗЕЦ",
+			TotalAmount:        quote.Amount,
+			DiscountPercentage: discounts.Percent,
+			DiscountedAmount:   discounts.DiscountedAmount,
+		}
+		signDate = time.Now()
+	}
+	return contractTemplateData(tx, student, &contract, signDate, year, schedule)
+}
@@ -0,0 +1,311 @@
+// prometheus-crm/internal/handlers/discount_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var discountKinds = map[string]bool{
+	models.DiscountKindFamilyOrder:  true,
+	models.DiscountKindEarlyPayment: true,
+	models.DiscountKindStaffChild:   true,
+	models.DiscountKindScholarship:  true,
+	models.DiscountKindManual:       true,
+}
+
+// DiscountSettings - общие настройки скидок
+type DiscountSettings struct {
+	// MaxTotalPercent - предельный суммарный процент скидки по договору.
+	MaxTotalPercent float64 `json:"maxTotalPercent"`
+}
+
+func validateDiscountRule(rule *models.DiscountRule) error {
+	if rule.Code == "" || rule.Name == "" {
+		return errors.New("код и название правила обязательны")
+	}
+	if !discountKinds[rule.Kind] {
+		return errors.New("неизвестный вид скидки")
+	}
+	if rule.Percent < 0 || rule.Percent > 100 || rule.FixedAmount < 0 || rule.MaxAmount < 0 {
+		return errors.New("процент должен быть от 0 до 100, суммы - неотрицательными")
+	}
+	if rule.ValidFrom != nil && rule.ValidTo != nil && rule.ValidTo.Before(*rule.ValidFrom) {
+		return errors.New("дата окончания действия раньше даты начала")
+	}
+	return nil
+}
+
+// ListDiscountRulesHandler возвращает все правила скидок.
+func ListDiscountRulesHandler(c *gin.Context) {
+	var rules []models.DiscountRule
+	if err := config.DB.Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить правила скидок"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateDiscountRuleHandler создает правило скидки.
+func CreateDiscountRuleHandler(c *gin.Context) {
+	var rule models.DiscountRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.ID = 0
+	if err := validateDiscountRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить правило: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateDiscountRuleHandler изменяет правило скидки. Уже рассчитанные договоры не меняются
+// до следующего пересчета (изменение договора, семьи или явный пересчет).
+func UpdateDiscountRuleHandler(c *gin.Context) {
+	var rule models.DiscountRule
+	if err := config.DB.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Правило не найдено"})
+		return
+	}
+	var input models.DiscountRule
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.Code = input.Code
+	rule.Name = input.Name
+	rule.Kind = input.Kind
+	rule.Percent = input.Percent
+	rule.FixedAmount = input.FixedAmount
+	rule.MaxAmount = input.MaxAmount
+	rule.Params = input.Params
+	rule.Priority = input.Priority
+	rule.Stackable = input.Stackable
+	rule.ValidFrom = input.ValidFrom
+	rule.ValidTo = input.ValidTo
+	if input.IsActive != nil {
+		rule.IsActive = input.IsActive
+	}
+	if err := validateDiscountRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить правило: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteDiscountRuleHandler удаляет правило скидки. Правило, по которому уже предоставлены
+// скидки ученикам, удалить нельзя - его следует отключить.
+func DeleteDiscountRuleHandler(c *gin.Context) {
+	var grants int64
+	config.DB.Model(&models.StudentDiscount{}).Where("rule_id = ?", c.Param("id")).Count(&grants)
+	if grants > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "По правилу предоставлены скидки ученикам. Отключите его вместо удаления."})
+		return
+	}
+	if err := config.DB.Delete(&models.DiscountRule{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить правило"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Правило удалено"})
+}
+
+// GetDiscountSettingsHandler получает общие настройки скидок
+func GetDiscountSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, DiscountService)
+}
+
+// SaveDiscountSettingsHandler сохраняет общие настройки скидок
+func SaveDiscountSettingsHandler(c *gin.Context) {
+	var payload struct {
+		Settings DiscountSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	if payload.Settings.MaxTotalPercent <= 0 || payload.Settings.MaxTotalPercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Лимит скидки должен быть от 0 до 100%"})
+		return
+	}
+	saveIntegrationSettings(c, DiscountService, true, payload.Settings)
+}
+
+// StudentDiscountInput - предоставление скидки ученику.
+type StudentDiscountInput struct {
+	RuleID      uint          `json:"ruleId" binding:"required"`
+	ContractID  *uint         `json:"contractId"`
+	Percent     *float64      `json:"percent"`
+	FixedAmount *models.Money `json:"fixedAmount"`
+	ValidFrom   string        `json:"validFrom"`
+	ValidTo     string        `json:"validTo"`
+	Comment     string        `json:"comment"`
+}
+
+// ListStudentDiscountsHandler возвращает скидки, предоставленные ученику.
+func ListStudentDiscountsHandler(c *gin.Context) {
+	var grants []models.StudentDiscount
+	if err := config.DB.Preload("Rule").Where("student_id = ?", c.Param("id")).Order("id DESC").Find(&grants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить скидки ученика"})
+		return
+	}
+	if grants == nil {
+		grants = make([]models.StudentDiscount, 0)
+	}
+	c.JSON(http.StatusOK, grants)
+}
+
+// CreateStudentDiscountHandler предоставляет ученику скидку и пересчитывает его текущий договор.
+func CreateStudentDiscountHandler(c *gin.Context) {
+	studentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID ученика"})
+		return
+	}
+	var input StudentDiscountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указано правило скидки (ruleId)"})
+		return
+	}
+	if (input.Percent != nil && (*input.Percent < 0 || *input.Percent > 100)) || (input.FixedAmount != nil && *input.FixedAmount < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Процент должен быть от 0 до 100, сумма - неотрицательной"})
+		return
+	}
+
+	var rule models.DiscountRule
+	if err := config.DB.First(&rule, input.RuleID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Правило скидки не найдено"})
+		return
+	}
+	if rule.Kind == models.DiscountKindFamilyOrder || rule.Kind == models.DiscountKindEarlyPayment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Эта скидка рассчитывается автоматически и не предоставляется вручную"})
+		return
+	}
+
+	grant := models.StudentDiscount{
+		StudentID:   uint(studentID),
+		RuleID:      rule.ID,
+		ContractID:  input.ContractID,
+		Percent:     input.Percent,
+		FixedAmount: input.FixedAmount,
+		Comment:     input.Comment,
+	}
+	if input.ValidFrom != "" {
+		t, err := time.Parse("2006-01-02", input.ValidFrom)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD."})
+			return
+		}
+		grant.ValidFrom = &t
+	}
+	if input.ValidTo != "" {
+		t, err := time.Parse("2006-01-02", input.ValidTo)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD."})
+			return
+		}
+		grant.ValidTo = &t
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		grant.GrantedByID = &userID
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&grant).Error; err != nil {
+			return err
+		}
+		return recalculateStudentContractDiscounts(tx, grant.StudentID, grant.ContractID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось предоставить скидку: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, grant)
+}
+
+// DeleteStudentDiscountHandler отменяет скидку ученика и пересчитывает договор.
+func DeleteStudentDiscountHandler(c *gin.Context) {
+	var grant models.StudentDiscount
+	if err := config.DB.Where("student_id = ?", c.Param("id")).First(&grant, c.Param("discountId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Скидка не найдена"})
+		return
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&grant).Error; err != nil {
+			return err
+		}
+		return recalculateStudentContractDiscounts(tx, grant.StudentID, grant.ContractID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось отменить скидку: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Скидка отменена"})
+}
+
+// recalculateStudentContractDiscounts пересчитывает указанный договор или последний договор ученика.
+func recalculateStudentContractDiscounts(tx *gorm.DB, studentID uint, contractID *uint) error {
+	var contract models.Contract
+	query := tx.Where("student_id = ?", studentID)
+	if contractID != nil {
+		query = query.Where("id = ?", *contractID)
+	}
+	err := query.Order("start_date desc, id desc").First(&contract).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // договора еще нет: скидка учтется при его создании
+	}
+	if err != nil {
+		return err
+	}
+	_, err = applyContractDiscounts(tx, &contract)
+	return err
+}
+
+// ListContractDiscountLinesHandler возвращает расшифровку скидок договора.
+func ListContractDiscountLinesHandler(c *gin.Context) {
+	var lines []models.ContractDiscountLine
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).Order("id ASC").Find(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить скидки договора"})
+		return
+	}
+	if lines == nil {
+		lines = make([]models.ContractDiscountLine, 0)
+	}
+	c.JSON(http.StatusOK, lines)
+}
+
+// RecalculateContractDiscountsHandler пересчитывает скидки договора по действующим правилам.
+func RecalculateContractDiscountsHandler(c *gin.Context) {
+	var contract models.Contract
+	if err := config.DB.First(&contract, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	var result DiscountResult
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = applyContractDiscounts(tx, &contract)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось пересчитать скидки: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,377 @@
+// prometheus-crm/internal/handlers/discount_rules.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"prometheus-crm/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DiscountService - общие настройки скидок в integration_settings ({"maxTotalPercent": 50}).
+	DiscountService = "discounts"
+
+	// familyOrderNone - значение family_order у ученика без родственников в школе.
+	familyOrderNone = 999
+)
+
+// DiscountContext - входные данные для расчета скидок по договору.
+type DiscountContext struct {
+	Student     *models.Student
+	ContractID  uint // 0 для еще не созданного договора
+	TotalAmount models.Money
+	// OnDate - дата, на которую проверяется срок действия правил (обычно начало договора).
+	OnDate time.Time
+	// FullPaymentDate - дата, когда договор был оплачен полностью (nil, если еще не оплачен).
+	FullPaymentDate *time.Time
+}
+
+// DiscountResult - итог расчета: примененные строки и общая скидка.
+type DiscountResult struct {
+	Lines            []models.ContractDiscountLine `json:"lines"`
+	TotalDiscount    models.Money                  `json:"totalDiscount"`
+	Percent          float64                       `json:"percent"`
+	DiscountedAmount models.Money                  `json:"discountedAmount"`
+}
+
+// roundPercent округляет процент скидки до сотых, как он хранится в NUMERIC(5,2).
+func roundPercent(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// HasKind сообщает, применена ли скидка указанного вида.
+func (r DiscountResult) HasKind(kind string) bool {
+	for _, l := range r.Lines {
+		if l.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateDiscounts - единственное место расчета скидок по договору.
+// Все правила считаются от полной стоимости (TotalAmount) и не компаундируются;
+// итог ограничивается MaxAmount правила и общим лимитом maxTotalPercent.
+func evaluateDiscounts(tx *gorm.DB, ctx DiscountContext) (DiscountResult, error) {
+	result := DiscountResult{Lines: make([]models.ContractDiscountLine, 0)}
+	total := ctx.TotalAmount
+	if total <= 0 || ctx.Student == nil {
+		result.DiscountedAmount = total
+		return result, nil
+	}
+	onDate := ctx.OnDate
+	if onDate.IsZero() {
+		onDate = time.Now()
+	}
+	day := onDate.Format("2006-01-02")
+
+	var rules []models.DiscountRule
+	if err := tx.Where("is_active = ?", true).
+		Where("valid_from IS NULL OR valid_from <= ?", day).
+		Where("valid_to IS NULL OR valid_to >= ?", day).
+		Order("priority ASC, id ASC").
+		Find(&rules).Error; err != nil {
+		return result, fmt.Errorf("не удалось загрузить правила скидок: %w", err)
+	}
+
+	grantsQuery := tx.Where("student_id = ?", ctx.Student.ID).
+		Where("valid_from IS NULL OR valid_from <= ?", day).
+		Where("valid_to IS NULL OR valid_to >= ?", day)
+	if ctx.ContractID != 0 {
+		grantsQuery = grantsQuery.Where("contract_id IS NULL OR contract_id = ?", ctx.ContractID)
+	} else {
+		grantsQuery = grantsQuery.Where("contract_id IS NULL")
+	}
+	var grants []models.StudentDiscount
+	if err := grantsQuery.Order("id ASC").Find(&grants).Error; err != nil {
+		return result, fmt.Errorf("не удалось загрузить скидки ученика: %w", err)
+	}
+	grantsByRule := make(map[uint][]models.StudentDiscount)
+	for _, g := range grants {
+		grantsByRule[g.RuleID] = append(grantsByRule[g.RuleID], g)
+	}
+
+	exclusiveApplied := false
+	for _, rule := range rules {
+		if !rule.Stackable && exclusiveApplied {
+			continue // из нестыкуемых правил применяется только первое сработавшее
+		}
+		candidates := ruleCandidateLines(rule, ctx, grantsByRule[rule.ID])
+		if len(candidates) == 0 {
+			continue
+		}
+
+		var ruleTotal models.Money
+		for _, line := range candidates {
+			line.Amount += total.Percent(line.Percent)
+			if rule.MaxAmount > 0 && ruleTotal+line.Amount > rule.MaxAmount {
+				line.Amount = models.MaxMoney(rule.MaxAmount-ruleTotal, 0)
+				line.Explanation += fmt.Sprintf(" (ограничено %s)", rule.MaxAmount)
+			}
+			if line.Amount <= 0 {
+				continue
+			}
+			ruleTotal += line.Amount
+			result.Lines = append(result.Lines, line)
+		}
+		if !rule.Stackable && ruleTotal > 0 {
+			exclusiveApplied = true
+		}
+	}
+
+	// Общий лимит скидки по договору.
+	limit := total.Percent(loadMaxTotalDiscountPercent(tx))
+	var sum models.Money
+	for i := range result.Lines {
+		if sum+result.Lines[i].Amount > limit {
+			result.Lines[i].Amount = models.MaxMoney(limit-sum, 0)
+			result.Lines[i].Explanation += " (общий лимит скидки)"
+		}
+		sum += result.Lines[i].Amount
+	}
+	kept := result.Lines[:0]
+	for _, l := range result.Lines {
+		if l.Amount > 0 {
+			l.Percent = roundPercent(float64(l.Amount) / float64(total) * 100)
+			kept = append(kept, l)
+		}
+	}
+	result.Lines = kept
+
+	result.TotalDiscount = sum
+	result.DiscountedAmount = total - sum
+	result.Percent = roundPercent(float64(sum) / float64(total) * 100)
+	return result, nil
+}
+
+// ruleCandidateLines возвращает строки, которые дало бы правило (Amount пока содержит только фиксированную часть).
+func ruleCandidateLines(rule models.DiscountRule, ctx DiscountContext, grants []models.StudentDiscount) []models.ContractDiscountLine {
+	ruleID := rule.ID
+	newLine := func(percent float64, fixed models.Money, explanation string) models.ContractDiscountLine {
+		return models.ContractDiscountLine{
+			ContractID:  ctx.ContractID,
+			RuleID:      &ruleID,
+			Kind:        rule.Kind,
+			Name:        rule.Name,
+			Percent:     percent,
+			Amount:      fixed,
+			Explanation: explanation,
+		}
+	}
+
+	switch rule.Kind {
+	case models.DiscountKindFamilyOrder:
+		order := ctx.Student.FamilyOrder
+		if order <= 0 || order >= familyOrderNone {
+			return nil
+		}
+		percent := familyTierPercent(rule.Params, order)
+		if percent <= 0 && rule.FixedAmount <= 0 {
+			return nil
+		}
+		return []models.ContractDiscountLine{newLine(percent, rule.FixedAmount,
+			fmt.Sprintf("%d-й ребенок в семье", order+1))}
+
+	case models.DiscountKindEarlyPayment:
+		if ctx.FullPaymentDate == nil {
+			return nil
+		}
+		deadline := earlyPaymentDeadline(rule.Params, *ctx.FullPaymentDate)
+		if !ctx.FullPaymentDate.Before(deadline) {
+			return nil
+		}
+		return []models.ContractDiscountLine{newLine(rule.Percent, rule.FixedAmount,
+			fmt.Sprintf("Договор оплачен полностью %s (до %s)", ctx.FullPaymentDate.Format("02.01.2006"), deadline.Format("02.01.2006")))}
+
+	default: // staff_child, scholarship, manual - только по предоставлению
+		lines := make([]models.ContractDiscountLine, 0, len(grants))
+		for _, g := range grants {
+			percent, fixed := rule.Percent, rule.FixedAmount
+			if g.Percent != nil {
+				percent = *g.Percent
+			}
+			if g.FixedAmount != nil {
+				fixed = *g.FixedAmount
+			}
+			if percent <= 0 && fixed <= 0 {
+				continue
+			}
+			explanation := g.Comment
+			if explanation == "" {
+				explanation = "Предоставлено ученику"
+			}
+			lines = append(lines, newLine(percent, fixed, explanation))
+		}
+		return lines
+	}
+}
+
+// familyTierPercent выбирает процент по порядку ребенка: ступень с наибольшим номером <= order.
+func familyTierPercent(params models.JSONB, order int) float64 {
+	tiers, _ := params["tiers"].(map[string]interface{})
+	keys := make([]int, 0, len(tiers))
+	for k := range tiers {
+		if n, err := strconv.Atoi(k); err == nil {
+			keys = append(keys, n)
+		}
+	}
+	sort.Ints(keys)
+	var percent float64
+	for _, k := range keys {
+		if k > order {
+			break
+		}
+		if v, ok := tiers[strconv.Itoa(k)].(float64); ok {
+			percent = v
+		}
+	}
+	return percent
+}
+
+// earlyPaymentDeadline - крайний срок полной оплаты (params.deadline "ММ-ДД") в году оплаты.
+func earlyPaymentDeadline(params models.JSONB, paidAt time.Time) time.Time {
+	month, day := time.September, 1
+	if s, ok := params["deadline"].(string); ok {
+		if t, err := time.Parse("01-02", s); err == nil {
+			month, day = t.Month(), t.Day()
+		}
+	}
+	return time.Date(paidAt.Year(), month, day, 0, 0, 0, 0, paidAt.Location())
+}
+
+// loadMaxTotalDiscountPercent возвращает общий лимит скидки (по умолчанию 100%).
+func loadMaxTotalDiscountPercent(tx *gorm.DB) float64 {
+	var setting models.IntegrationSetting
+	if err := tx.Where("service_name = ?", DiscountService).First(&setting).Error; err == nil {
+		if v, ok := setting.Settings["maxTotalPercent"].(float64); ok && v > 0 && v <= 100 {
+			return v
+		}
+	}
+	return 100
+}
+
+// contractFullPaymentDate возвращает дату, когда сумма поступлений по журналу
+// впервые достигла полной стоимости договора.
+func contractFullPaymentDate(tx *gorm.DB, contract *models.Contract) (*time.Time, error) {
+	if contract.ID == 0 || contract.TotalAmount <= 0 {
+		return nil, nil
+	}
+	var rows []struct {
+		EntryDate time.Time
+		Paid      models.Money
+	}
+	err := tx.Table("ledger_entries le").
+		Select("le.entry_date, -SUM(le.amount) AS paid").
+		Joins("LEFT JOIN ledger_entries o ON le.reverses_id = o.id").
+		Where("le.contract_id = ?", contract.ID).
+		Where("COALESCE(o.entry_type, le.entry_type) IN ?", []string{models.LedgerPayment, models.LedgerRefund}).
+		Group("le.entry_date").
+		Order("le.entry_date ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	var cumulative models.Money
+	for _, r := range rows {
+		cumulative += r.Paid
+		if cumulative >= contract.TotalAmount {
+			d := r.EntryDate
+			return &d, nil
+		}
+	}
+	return nil, nil
+}
+
+// applyContractDiscounts пересчитывает скидки договора, сохраняет расшифровку
+// и отражает изменение суммы в журнале расчетов. Вызывается внутри транзакции.
+func applyContractDiscounts(tx *gorm.DB, contract *models.Contract) (DiscountResult, error) {
+	var student models.Student
+	if err := tx.First(&student, contract.StudentID).Error; err != nil {
+		return DiscountResult{}, fmt.Errorf("ученик договора не найден: %w", err)
+	}
+	fullPaymentDate, err := contractFullPaymentDate(tx, contract)
+	if err != nil {
+		return DiscountResult{}, err
+	}
+
+	ctx := DiscountContext{
+		Student:         &student,
+		ContractID:      contract.ID,
+		TotalAmount:     contract.TotalAmount,
+		FullPaymentDate: fullPaymentDate,
+	}
+	if contract.StartDate != nil {
+		ctx.OnDate = *contract.StartDate
+	}
+	result, err := evaluateDiscounts(tx, ctx)
+	if err != nil {
+		return result, err
+	}
+
+	if err := tx.Where("contract_id = ?", contract.ID).Delete(&models.ContractDiscountLine{}).Error; err != nil {
+		return result, err
+	}
+	for i := range result.Lines {
+		result.Lines[i].ContractID = contract.ID
+		if err := tx.Create(&result.Lines[i]).Error; err != nil {
+			return result, fmt.Errorf("не удалось сохранить строку скидки: %w", err)
+		}
+	}
+
+	contract.DiscountPercentage = result.Percent
+	contract.DiscountedAmount = result.DiscountedAmount
+	if err := tx.Model(contract).Updates(map[string]interface{}{
+		"discount_percentage": contract.DiscountPercentage,
+		"discounted_amount":   contract.DiscountedAmount,
+	}).Error; err != nil {
+		return result, err
+	}
+	return result, syncContractCharges(tx, contract)
+}
+
+// errManualDiscountBelowRules - ручной процент меньше скидки, положенной по правилам.
+var errManualDiscountBelowRules = errors.New("скидка не может быть меньше рассчитанной по правилам; отключите правило или скидку ученика")
+
+// setManualContractDiscount приводит общий процент скидки договора к заданному вручную:
+// разница с расчетом по правилам сохраняется как ручная скидка на этот договор.
+func setManualContractDiscount(tx *gorm.DB, contract *models.Contract, targetPercent float64, userID *uint) error {
+	var manualRule models.DiscountRule
+	if err := tx.Where("kind = ?", models.DiscountKindManual).Order("priority ASC, id ASC").First(&manualRule).Error; err != nil {
+		return fmt.Errorf("не найдено правило ручной скидки: %w", err)
+	}
+	// Прежняя ручная корректировка этого договора заменяется новой.
+	if err := tx.Where("contract_id = ? AND rule_id = ?", contract.ID, manualRule.ID).Delete(&models.StudentDiscount{}).Error; err != nil {
+		return err
+	}
+	result, err := applyContractDiscounts(tx, contract)
+	if err != nil {
+		return err
+	}
+	diff := roundPercent(targetPercent - result.Percent)
+	if diff == 0 {
+		return nil
+	}
+	if diff < 0 {
+		return errManualDiscountBelowRules
+	}
+	contractID := contract.ID
+	grant := models.StudentDiscount{
+		StudentID:   contract.StudentID,
+		RuleID:      manualRule.ID,
+		ContractID:  &contractID,
+		Percent:     &diff,
+		Comment:     "Корректировка в карточке договора",
+		GrantedByID: userID,
+	}
+	if err := tx.Create(&grant).Error; err != nil {
+		return err
+	}
+	_, err = applyContractDiscounts(tx, contract)
+	return err
+}
@@ -0,0 +1,440 @@
+// prometheus-crm/internal/handlers/document_converter.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DocumentConverterService - настройки конвертации документов в PDF в integration_settings.
+const DocumentConverterService = "document_converter"
+
+// Конвертеры, доступные из коробки.
+const (
+	ConverterGotenberg = "gotenberg"
+	ConverterSoffice   = "soffice" // локальный LibreOffice (soffice --headless) без отдельного сервиса
+	ConverterFake      = "fake"    // тестовый конвертер (только в go test): возвращает PDF-заглушку
+)
+
+const (
+	// defaultGotenbergURL - сервис Gotenberg (контейнер libreoffice-converter в docker-compose).
+	defaultGotenbergURL       = "http://libreoffice-converter:3000"
+	defaultConverterTimeout   = 60
+	defaultConverterRetries   = 3
+	defaultConverterBackoffMs = 1000
+	maxConverterBackoff       = 30 * time.Second
+)
+
+// DocumentConverterSettings - параметры конвертации. Если настройки не сохранены, используется Gotenberg
+// по адресу из переменной окружения GOTENBERG_URL (или адрес из docker-compose).
+// Исполняемый файл LibreOffice для конвертера soffice задается только окружением (SOFFICE_PATH),
+// чтобы настройками через API нельзя было запустить на сервере произвольную программу.
+type DocumentConverterSettings struct {
+	Provider     string `json:"provider"`
+	GotenbergURL string `json:"gotenbergUrl"`
+	// TimeoutSeconds ограничивает одну попытку конвертации; 0 - значение по умолчанию.
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// Retries - число повторов после неудачной попытки (nil - по умолчанию, 0 - без повторов);
+	// пауза между ними удваивается от BackoffMs.
+	Retries   *int `json:"retries,omitempty"`
+	BackoffMs int  `json:"backoffMs"`
+}
+
+// retries - число повторов с учетом значения по умолчанию.
+func (s DocumentConverterSettings) retries() int {
+	if s.Retries == nil || *s.Retries < 0 {
+		return defaultConverterRetries
+	}
+	return *s.Retries
+}
+
+// DocumentConverter - адаптер конвертации в PDF. Реализации подключаются через RegisterDocumentConverter,
+// поэтому сервис конвертации можно заменить без изменения формирования документов.
+type DocumentConverter interface {
+	ConvertDocx(ctx context.Context, docx []byte, settings DocumentConverterSettings) ([]byte, error)
+	ConvertHTML(ctx context.Context, html []byte, settings DocumentConverterSettings) ([]byte, error)
+	// Health проверяет, что конвертер доступен и готов принимать документы.
+	Health(ctx context.Context, settings DocumentConverterSettings) error
+}
+
+var (
+	documentConvertersMu sync.RWMutex
+	documentConverters   = map[string]DocumentConverter{
+		ConverterGotenberg: gotenbergConverter{client: &http.Client{}},
+		ConverterSoffice:   sofficeConverter{},
+	}
+)
+
+// Тестовый конвертер подключается только в тестовых сборках: выбранный в рабочей системе,
+// он подменил бы PDF всех документов заглушкой.
+func init() {
+	if testing.Testing() {
+		documentConverters[ConverterFake] = fakeConverter{}
+	}
+}
+
+// RegisterDocumentConverter подключает или подменяет конвертер.
+func RegisterDocumentConverter(name string, converter DocumentConverter) {
+	documentConvertersMu.Lock()
+	defer documentConvertersMu.Unlock()
+	documentConverters[name] = converter
+}
+
+func documentConverterFor(name string) (DocumentConverter, bool) {
+	documentConvertersMu.RLock()
+	defer documentConvertersMu.RUnlock()
+	c, ok := documentConverters[name]
+	return c, ok
+}
+
+// loadDocumentConverterSettings возвращает настройки конвертации с заполненными значениями по умолчанию.
+func loadDocumentConverterSettings(tx *gorm.DB) DocumentConverterSettings {
+	var settings DocumentConverterSettings
+	var setting models.IntegrationSetting
+	// tx == nil - БД не подключена (тесты с подмененным конвертером): только значения по умолчанию
+	if tx != nil {
+		if err := tx.Where("service_name = ?", DocumentConverterService).First(&setting).Error; err == nil && setting.IsEnabled {
+			raw, _ := json.Marshal(setting.Settings)
+			_ = json.Unmarshal(raw, &settings)
+		}
+	}
+	if settings.Provider == "" {
+		settings.Provider = ConverterGotenberg
+	}
+	if settings.GotenbergURL == "" {
+		settings.GotenbergURL = os.Getenv("GOTENBERG_URL")
+	}
+	if settings.GotenbergURL == "" {
+		settings.GotenbergURL = defaultGotenbergURL
+	}
+	settings.GotenbergURL = strings.TrimRight(settings.GotenbergURL, "/")
+	if settings.TimeoutSeconds <= 0 {
+		settings.TimeoutSeconds = defaultConverterTimeout
+	}
+	if settings.BackoffMs <= 0 {
+		settings.BackoffMs = defaultConverterBackoffMs
+	}
+	return settings
+}
+
+// permanentConversionError - ошибка, при которой повтор не поможет (битый документ, неверные настройки).
+type permanentConversionError struct{ err error }
+
+func (e permanentConversionError) Error() string { return e.err.Error() }
+func (e permanentConversionError) Unwrap() error { return e.err }
+
+// convertWithRetries выполняет конвертацию выбранным конвертером с повторами и экспоненциальной паузой.
+// Каждая попытка ограничена TimeoutSeconds; постоянные ошибки не повторяются.
+func convertWithRetries(ctx context.Context, convert func(context.Context, DocumentConverter, DocumentConverterSettings) ([]byte, error)) ([]byte, error) {
+	settings := loadDocumentConverterSettings(config.DB)
+	converter, ok := documentConverterFor(settings.Provider)
+	if !ok {
+		return nil, fmt.Errorf("конвертер документов %q не подключен", settings.Provider)
+	}
+
+	delay := time.Duration(settings.BackoffMs) * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= settings.retries(); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("конвертация прервана: %w (последняя ошибка: %v)", ctx.Err(), lastErr)
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxConverterBackoff)
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(settings.TimeoutSeconds)*time.Second)
+		pdf, err := convert(attemptCtx, converter, settings)
+		cancel()
+		if err == nil {
+			return pdf, nil
+		}
+		lastErr = err
+		var permanent permanentConversionError
+		if errors.As(err, &permanent) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func convertDocxToPdf(docxBytes []byte) ([]byte, error) {
+	return convertDocxToPdfContext(context.Background(), docxBytes)
+}
+
+func convertDocxToPdfContext(ctx context.Context, docxBytes []byte) ([]byte, error) {
+	return convertWithRetries(ctx, func(ctx context.Context, c DocumentConverter, s DocumentConverterSettings) ([]byte, error) {
+		return c.ConvertDocx(ctx, docxBytes, s)
+	})
+}
+
+// convertHTMLToPdf печатает HTML-страницу в PDF.
+func convertHTMLToPdf(html []byte) ([]byte, error) {
+	return convertWithRetries(context.Background(), func(ctx context.Context, c DocumentConverter, s DocumentConverterSettings) ([]byte, error) {
+		return c.ConvertHTML(ctx, html, s)
+	})
+}
+
+// --- Gotenberg ---
+
+type gotenbergConverter struct {
+	client *http.Client
+}
+
+func (g gotenbergConverter) ConvertDocx(ctx context.Context, docx []byte, settings DocumentConverterSettings) ([]byte, error) {
+	return g.convert(ctx, settings, "/forms/libreoffice/convert", "input.docx", docx)
+}
+
+// ConvertHTML печатает страницу через Chromium; Gotenberg требует, чтобы файл назывался index.html.
+func (g gotenbergConverter) ConvertHTML(ctx context.Context, html []byte, settings DocumentConverterSettings) ([]byte, error) {
+	return g.convert(ctx, settings, "/forms/chromium/convert/html", "index.html", html)
+}
+
+func (g gotenbergConverter) Health(ctx context.Context, settings DocumentConverterSettings) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, settings.GotenbergURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("некорректный адрес Gotenberg: %w", err)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Gotenberg недоступен: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Gotenberg не готов: статус %d, ответ: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// convert отправляет файл в указанный маршрут Gotenberg и возвращает полученный PDF.
+func (g gotenbergConverter) convert(ctx context.Context, settings DocumentConverterSettings, route, fileName string, content []byte) ([]byte, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("files", fileName)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания части формы для файла: %w", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		return nil, fmt.Errorf("ошибка записи %s в часть формы: %w", fileName, err)
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.GotenbergURL+route, body)
+	if err != nil {
+		return nil, permanentConversionError{fmt.Errorf("ошибка создания запроса к Gotenberg: %w", err)}
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка отправки запроса к Gotenberg: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		err := fmt.Errorf("ошибка конвертации %s в PDF через Gotenberg: статус %d, ответ: %s", fileName, resp.StatusCode, string(respBody))
+		// 4xx - документ или запрос не принимаются; повторять имеет смысл только перегрузку и ошибки сервиса.
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, permanentConversionError{err}
+		}
+		return nil, err
+	}
+
+	pdfBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения PDF ответа от Gotenberg: %w", err)
+	}
+	return pdfBytes, nil
+}
+
+// --- Локальный LibreOffice ---
+
+// sofficeConverter запускает soffice --headless в отдельном профиле на каждый документ,
+// чтобы параллельные конвертации не блокировали друг друга.
+type sofficeConverter struct{}
+
+func (s sofficeConverter) ConvertDocx(ctx context.Context, docx []byte, settings DocumentConverterSettings) ([]byte, error) {
+	return s.convert(ctx, settings, "input.docx", "pdf", docx)
+}
+
+func (s sofficeConverter) ConvertHTML(ctx context.Context, html []byte, settings DocumentConverterSettings) ([]byte, error) {
+	return s.convert(ctx, settings, "index.html", "pdf:writer_web_pdf_Export", html)
+}
+
+func (s sofficeConverter) Health(ctx context.Context, settings DocumentConverterSettings) error {
+	bin, err := s.binary(settings)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, bin, "--headless", "--version").CombinedOutput(); err != nil {
+		return fmt.Errorf("LibreOffice не запускается: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (sofficeConverter) binary(DocumentConverterSettings) (string, error) {
+	name := os.Getenv("SOFFICE_PATH")
+	if name == "" {
+		name = "soffice"
+	}
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return "", permanentConversionError{fmt.Errorf("LibreOffice (%s) не найден: %w", name, err)}
+	}
+	return bin, nil
+}
+
+func (s sofficeConverter) convert(ctx context.Context, settings DocumentConverterSettings, fileName, filter string, content []byte) ([]byte, error) {
+	bin, err := s.binary(settings)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "soffice-*")
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать временную директорию: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, fileName)
+	if err := os.WriteFile(input, content, 0o600); err != nil {
+		return nil, fmt.Errorf("не удалось записать %s: %w", fileName, err)
+	}
+	cmd := exec.CommandContext(ctx, bin,
+		"-env:UserInstallation=file://"+filepath.ToSlash(filepath.Join(dir, "profile")),
+		"--headless", "--norestore", "--convert-to", filter, "--outdir", dir, input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ошибка конвертации %s через LibreOffice: %w: %s", fileName, err, strings.TrimSpace(string(out)))
+	}
+
+	pdf, err := os.ReadFile(strings.TrimSuffix(input, filepath.Ext(input)) + ".pdf")
+	if err != nil {
+		return nil, fmt.Errorf("LibreOffice не сформировал PDF для %s: %w", fileName, err)
+	}
+	return pdf, nil
+}
+
+// --- Тестовый конвертер ---
+
+// fakeConverter возвращает одностраничный PDF с размером исходного документа. Позволяет проверять
+// выпуск договоров и документов без LibreOffice и Gotenberg.
+type fakeConverter struct{}
+
+func (fakeConverter) ConvertDocx(_ context.Context, docx []byte, _ DocumentConverterSettings) ([]byte, error) {
+	return fakePDF(fmt.Sprintf("Fake PDF: DOCX %d bytes", len(docx))), nil
+}
+
+func (fakeConverter) ConvertHTML(_ context.Context, html []byte, _ DocumentConverterSettings) ([]byte, error) {
+	return fakePDF(fmt.Sprintf("Fake PDF: HTML %d bytes", len(html))), nil
+}
+
+func (fakeConverter) Health(context.Context, DocumentConverterSettings) error { return nil }
+
+// fakePDF собирает минимальный корректный PDF с одной строкой текста (только ASCII).
+func fakePDF(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 14 Tf 72 770 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// --- Обработчики ---
+
+// GetDocumentConverterHealthHandler проверяет доступность выбранного конвертера
+// и показывает очередь формирования PDF договоров.
+func GetDocumentConverterHealthHandler(c *gin.Context) {
+	settings := loadDocumentConverterSettings(config.DB)
+	result := gin.H{"provider": settings.Provider, "healthy": false}
+	if settings.Provider == ConverterGotenberg {
+		result["gotenbergUrl"] = settings.GotenbergURL
+	}
+	var pending, failed int64
+	config.DB.Model(&models.Contract{}).Where("pdf_status = ?", models.ContractPDFPending).Count(&pending)
+	config.DB.Model(&models.Contract{}).Where("pdf_status = ?", models.ContractPDFFailed).Count(&failed)
+	result["pendingContracts"] = pending
+	result["failedContracts"] = failed
+
+	converter, ok := documentConverterFor(settings.Provider)
+	if !ok {
+		result["error"] = fmt.Sprintf("конвертер документов %q не подключен", settings.Provider)
+		c.JSON(http.StatusServiceUnavailable, result)
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	err := converter.Health(ctx, settings)
+	result["latencyMs"] = time.Since(started).Milliseconds()
+	if err != nil {
+		result["error"] = err.Error()
+		c.JSON(http.StatusServiceUnavailable, result)
+		return
+	}
+	result["healthy"] = true
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDocumentConverterSettingsHandler получает настройки конвертации документов
+func GetDocumentConverterSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, DocumentConverterService)
+}
+
+// SaveDocumentConverterSettingsHandler сохраняет настройки конвертации документов
+func SaveDocumentConverterSettingsHandler(c *gin.Context) {
+	var payload struct {
+		IsEnabled bool                      `json:"isEnabled"`
+		Settings  DocumentConverterSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	if payload.Settings.Provider != "" {
+		if _, ok := documentConverterFor(payload.Settings.Provider); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный конвертер документов: " + payload.Settings.Provider})
+			return
+		}
+	}
+	if retries := payload.Settings.Retries; payload.Settings.TimeoutSeconds < 0 || payload.Settings.BackoffMs < 0 || (retries != nil && (*retries < 0 || *retries > 10)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Таймаут и пауза не могут быть отрицательными, число повторов - от 0 до 10"})
+		return
+	}
+	saveIntegrationSettings(c, DocumentConverterService, payload.IsEnabled, payload.Settings)
+}
@@ -0,0 +1,433 @@
+// prometheus-crm/internal/handlers/document_numbering.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// NumberingService - схемы нумерации документов в integration_settings.
+const NumberingService = "document_numbering"
+
+// NumberingScheme - шаблон номера документа и область, в пределах которой идет счетчик.
+// Подстановки шаблона:
+//
+//	{seq}, {seq:0000} - порядковый номер (с дополнением нулями до ширины маски);
+//	{year}    - год нумерации: календарный год документа при нумерации по календарным годам,
+//	            иначе год начала учебного года документа;
+//	{ayear}   - учебный год ("2025-2026");
+//	{month}   - месяц документа (01-12);
+//	{grade}   - класс ученика в учебном году документа;
+//	{student} - ID ученика;
+//	{contract} - номер договора (для соглашений и квитанций).
+type NumberingScheme struct {
+	Pattern string `json:"pattern"`
+	Scope   string `json:"scope"`
+}
+
+// NumberingSettings - схемы нумерации по видам документов.
+type NumberingSettings struct {
+	Schemes map[string]NumberingScheme `json:"schemes"`
+}
+
+// defaultNumberingSchemes повторяют форматы номеров, которые использовались до настройки нумерации.
+var defaultNumberingSchemes = map[string]NumberingScheme{
+	models.DocumentContract:    {Pattern: "N {student}-{seq}", Scope: models.NumberingScopeStudent},
+	models.DocumentAmendment:   {Pattern: "{seq}", Scope: models.NumberingScopeContract},
+	models.DocumentReceipt:     {Pattern: "{year}-{seq:00000}", Scope: models.NumberingScopeCalendarYear},
+	models.DocumentTermination: {Pattern: "Р-{year}-{seq:000}", Scope: models.NumberingScopeCalendarYear},
+}
+
+var documentTypeNames = map[string]string{
+	models.DocumentContract:    "Договоры",
+	models.DocumentAmendment:   "Дополнительные соглашения",
+	models.DocumentReceipt:     "Квитанции",
+	models.DocumentTermination: "Соглашения о расторжении",
+}
+
+var numberingScopeNames = map[string]string{
+	models.NumberingScopeGlobal:       "Единая нумерация",
+	models.NumberingScopeAcademicYear: "По учебным годам",
+	models.NumberingScopeCalendarYear: "По календарным годам",
+	models.NumberingScopeStudent:      "По ученикам",
+	models.NumberingScopeContract:     "По договорам",
+}
+
+var (
+	numberPlaceholderRe = regexp.MustCompile(`\{([a-z]+)(?::(0+))?\}`)
+	sequenceScopeKeyRe  = regexp.MustCompile(`^(|academic_year:\d+|year:\d{4}|student:\d+|contract:\d+)$`)
+)
+
+var numberPlaceholders = map[string]bool{
+	"seq": true, "year": true, "ayear": true, "month": true, "grade": true, "student": true, "contract": true,
+}
+
+// NumberingContext - документ, которому выделяется номер. Незаполненные учебный год
+// и ученик определяются по договору (или по дате документа), если они нужны схеме.
+type NumberingContext struct {
+	Date         time.Time
+	AcademicYear *models.AcademicYear
+	Student      *models.Student
+	Contract     *models.Contract
+}
+
+// DocumentNumber - выделенный номер документа.
+type DocumentNumber struct {
+	Number   string
+	Sequence int
+	Year     int // год нумерации, см. {year}
+}
+
+// loadNumberingSettings читает схемы нумерации; не заданные виды документов получают схемы по умолчанию.
+func loadNumberingSettings(tx *gorm.DB) NumberingSettings {
+	settings := NumberingSettings{Schemes: make(map[string]NumberingScheme, len(defaultNumberingSchemes))}
+	for docType, scheme := range defaultNumberingSchemes {
+		settings.Schemes[docType] = scheme
+	}
+	var setting models.IntegrationSetting
+	if err := tx.Where("service_name = ?", NumberingService).First(&setting).Error; err != nil {
+		return settings
+	}
+	var stored NumberingSettings
+	raw, _ := json.Marshal(setting.Settings)
+	_ = json.Unmarshal(raw, &stored)
+	for docType, scheme := range stored.Schemes {
+		if _, ok := defaultNumberingSchemes[docType]; ok && scheme.Pattern != "" {
+			settings.Schemes[docType] = scheme
+		}
+	}
+	return settings
+}
+
+// validateNumberingScheme проверяет шаблон номера. Номера договоров и квитанций уникальны,
+// поэтому их шаблон должен различать области нумерации (год, ученика или договор).
+func validateNumberingScheme(docType string, scheme NumberingScheme) error {
+	name, ok := documentTypeNames[docType]
+	if !ok {
+		return fmt.Errorf("неизвестный вид документа «%s»", docType)
+	}
+	if _, ok := numberingScopeNames[scheme.Scope]; !ok {
+		return fmt.Errorf("%s: неизвестная область нумерации «%s»", name, scheme.Scope)
+	}
+	if docType == models.DocumentContract && scheme.Scope == models.NumberingScopeContract {
+		return fmt.Errorf("%s: нумерация в пределах договора невозможна", name)
+	}
+	if strings.TrimSpace(scheme.Pattern) == "" {
+		return fmt.Errorf("%s: укажите шаблон номера", name)
+	}
+
+	used := make(map[string]bool)
+	for _, m := range numberPlaceholderRe.FindAllStringSubmatch(scheme.Pattern, -1) {
+		if !numberPlaceholders[m[1]] {
+			return fmt.Errorf("%s: неизвестная подстановка {%s}", name, m[1])
+		}
+		if m[2] != "" && m[1] != "seq" {
+			return fmt.Errorf("%s: ширину можно задать только для {seq}", name)
+		}
+		used[m[1]] = true
+	}
+	if !used["seq"] {
+		return fmt.Errorf("%s: шаблон должен содержать порядковый номер {seq}", name)
+	}
+	if used["contract"] && docType == models.DocumentContract {
+		return fmt.Errorf("%s: подстановка {contract} доступна только для документов к договору", name)
+	}
+	if used["grade"] && docType == models.DocumentReceipt {
+		return fmt.Errorf("%s: подстановка {grade} недоступна", name)
+	}
+
+	if docType == models.DocumentContract || docType == models.DocumentReceipt {
+		distinct := true
+		switch scheme.Scope {
+		case models.NumberingScopeAcademicYear:
+			distinct = used["year"] || used["ayear"]
+		case models.NumberingScopeCalendarYear:
+			distinct = used["year"]
+		case models.NumberingScopeStudent:
+			distinct = used["student"]
+		case models.NumberingScopeContract:
+			distinct = used["contract"]
+		}
+		if !distinct {
+			return fmt.Errorf("%s: номера разных областей нумерации совпадут - добавьте в шаблон подстановку области (%s)",
+				name, strings.ToLower(numberingScopeNames[scheme.Scope]))
+		}
+	}
+	return nil
+}
+
+// resolveNumberingContext дозагружает учебный год и ученика, если они нужны схеме.
+func resolveNumberingContext(tx *gorm.DB, scheme NumberingScheme, ctx *NumberingContext) error {
+	needYear := scheme.Scope == models.NumberingScopeAcademicYear || strings.Contains(scheme.Pattern, "{ayear}") ||
+		strings.Contains(scheme.Pattern, "{grade}") ||
+		(strings.Contains(scheme.Pattern, "{year}") && scheme.Scope != models.NumberingScopeCalendarYear)
+	needStudent := scheme.Scope == models.NumberingScopeStudent || strings.Contains(scheme.Pattern, "{student}") ||
+		strings.Contains(scheme.Pattern, "{grade}")
+
+	if needYear && ctx.AcademicYear == nil {
+		var err error
+		if ctx.Contract != nil {
+			ctx.AcademicYear, err = academicYearForContract(tx, ctx.Contract)
+		} else {
+			ctx.AcademicYear, err = academicYearOn(tx, ctx.Date)
+		}
+		// {year} без учебного года - календарный год документа; без года нельзя только нумеровать по учебным годам.
+		if err != nil && (scheme.Scope == models.NumberingScopeAcademicYear || !errors.Is(err, errAcademicYearNotConfigured)) {
+			return err
+		}
+	}
+	if needStudent && ctx.Student == nil {
+		if ctx.Contract == nil {
+			return errors.New("для номера документа не указан ученик")
+		}
+		var student models.Student
+		if err := tx.Preload("Class").First(&student, ctx.Contract.StudentID).Error; err != nil {
+			return fmt.Errorf("ученик договора не найден: %w", err)
+		}
+		ctx.Student = &student
+	}
+	return nil
+}
+
+// numberingScopeKey возвращает счетчик области нумерации и год нумерации для {year}.
+func numberingScopeKey(scope string, ctx *NumberingContext) (string, int, error) {
+	year := ctx.Date.Year()
+	if ctx.AcademicYear != nil && scope != models.NumberingScopeCalendarYear {
+		year = ctx.AcademicYear.StartDate.Year()
+	}
+	switch scope {
+	case models.NumberingScopeGlobal:
+		return "", year, nil
+	case models.NumberingScopeAcademicYear:
+		if ctx.AcademicYear == nil {
+			return "", 0, errAcademicYearNotConfigured
+		}
+		return fmt.Sprintf("academic_year:%d", ctx.AcademicYear.ID), year, nil
+	case models.NumberingScopeCalendarYear:
+		return fmt.Sprintf("year:%d", year), year, nil
+	case models.NumberingScopeStudent:
+		return fmt.Sprintf("student:%d", ctx.Student.ID), year, nil
+	case models.NumberingScopeContract:
+		if ctx.Contract == nil {
+			return "", 0, errors.New("для номера документа не указан договор")
+		}
+		return fmt.Sprintf("contract:%d", ctx.Contract.ID), year, nil
+	}
+	return "", 0, fmt.Errorf("неизвестная область нумерации «%s»", scope)
+}
+
+// renderDocumentNumber подставляет в шаблон порядковый номер и сведения о документе.
+func renderDocumentNumber(tx *gorm.DB, pattern string, seq, year int, ctx *NumberingContext) (string, error) {
+	var renderErr error
+	number := numberPlaceholderRe.ReplaceAllStringFunc(pattern, func(token string) string {
+		m := numberPlaceholderRe.FindStringSubmatch(token)
+		switch m[1] {
+		case "seq":
+			return fmt.Sprintf("%0*d", len(m[2]), seq)
+		case "year":
+			return strconv.Itoa(year)
+		case "ayear":
+			if ctx.AcademicYear == nil {
+				renderErr = errAcademicYearNotConfigured
+				return ""
+			}
+			return ctx.AcademicYear.Name
+		case "month":
+			return fmt.Sprintf("%02d", int(ctx.Date.Month()))
+		case "grade":
+			if ctx.AcademicYear == nil {
+				renderErr = errAcademicYearNotConfigured
+				return ""
+			}
+			grade, err := studentGradeForYear(tx, ctx.Student, ctx.AcademicYear)
+			if err != nil {
+				renderErr = err
+				return ""
+			}
+			return strconv.Itoa(grade)
+		case "student":
+			return strconv.FormatUint(uint64(ctx.Student.ID), 10)
+		case "contract":
+			if ctx.Contract == nil {
+				renderErr = errors.New("для номера документа не указан договор")
+				return ""
+			}
+			return ctx.Contract.ContractNumber
+		}
+		return token
+	})
+	return number, renderErr
+}
+
+// allocateDocumentNumber выделяет следующий номер документа по схеме нумерации его вида.
+// Строка счетчика остается заблокированной до конца транзакции: при откате документа
+// номер возвращается, поэтому в нумерации не бывает пропусков.
+func allocateDocumentNumber(tx *gorm.DB, docType string, ctx NumberingContext) (DocumentNumber, error) {
+	scheme := loadNumberingSettings(tx).Schemes[docType]
+	if ctx.Date.IsZero() {
+		ctx.Date = time.Now()
+	}
+	if err := resolveNumberingContext(tx, scheme, &ctx); err != nil {
+		return DocumentNumber{}, err
+	}
+	scopeKey, year, err := numberingScopeKey(scheme.Scope, &ctx)
+	if err != nil {
+		return DocumentNumber{}, err
+	}
+
+	var seq int
+	if err := tx.Raw(`
+		INSERT INTO document_sequences (document_type, scope_key, last_number, updated_at) VALUES (?, ?, 1, NOW())
+		ON CONFLICT (document_type, scope_key) DO UPDATE
+		SET last_number = document_sequences.last_number + 1, updated_at = NOW()
+		RETURNING last_number`, docType, scopeKey).Scan(&seq).Error; err != nil {
+		return DocumentNumber{}, fmt.Errorf("не удалось выделить номер документа: %w", err)
+	}
+	number, err := renderDocumentNumber(tx, scheme.Pattern, seq, year, &ctx)
+	if err != nil {
+		return DocumentNumber{}, fmt.Errorf("не удалось сформировать номер документа: %w", err)
+	}
+	return DocumentNumber{Number: number, Sequence: seq, Year: year}, nil
+}
+
+// --- Обработчики ---
+
+// DocumentSequenceResponse - счетчик нумерации с описанием области.
+type DocumentSequenceResponse struct {
+	models.DocumentSequence
+	DocumentName string `json:"documentName"`
+	ScopeName    string `json:"scopeName"`
+}
+
+// GetNumberingSettingsHandler возвращает схемы нумерации, справочники видов документов и областей
+// и пример первого номера по каждой схеме.
+func GetNumberingSettingsHandler(c *gin.Context) {
+	settings := loadNumberingSettings(config.DB)
+	examples := make(map[string]string, len(settings.Schemes))
+	sample := &NumberingContext{
+		Date:         time.Now(),
+		AcademicYear: &models.AcademicYear{Name: fmt.Sprintf("%d-%d", time.Now().Year(), time.Now().Year()+1), StartDate: time.Now()},
+		Contract:     &models.Contract{ContractNumber: "N 125-1"},
+	}
+	for docType, scheme := range settings.Schemes {
+		pattern := strings.ReplaceAll(scheme.Pattern, "{grade}", "5")
+		pattern = strings.ReplaceAll(pattern, "{student}", "125")
+		examples[docType], _ = renderDocumentNumber(config.DB, pattern, 1, time.Now().Year(), sample)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"settings":      settings,
+		"examples":      examples,
+		"documentTypes": documentTypeNames,
+		"scopes":        numberingScopeNames,
+	})
+}
+
+// SaveNumberingSettingsHandler сохраняет схемы нумерации. Уже выданные номера не меняются.
+func SaveNumberingSettingsHandler(c *gin.Context) {
+	var payload struct {
+		Settings NumberingSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	for docType, scheme := range payload.Settings.Schemes {
+		if err := validateNumberingScheme(docType, scheme); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	saveIntegrationSettings(c, NumberingService, true, payload.Settings)
+}
+
+// ListDocumentSequencesHandler возвращает счетчики нумерации (?documentType= - одного вида документов).
+func ListDocumentSequencesHandler(c *gin.Context) {
+	query := config.DB.Model(&models.DocumentSequence{})
+	if docType := c.Query("documentType"); docType != "" {
+		query = query.Where("document_type = ?", docType)
+	}
+	var sequences []models.DocumentSequence
+	if err := query.Order("document_type, scope_key").Find(&sequences).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить счетчики нумерации"})
+		return
+	}
+
+	var years []models.AcademicYear
+	config.DB.Select("id, name").Find(&years)
+	yearNames := make(map[string]string, len(years))
+	for _, y := range years {
+		yearNames[fmt.Sprintf("academic_year:%d", y.ID)] = "Учебный год " + y.Name
+	}
+
+	result := make([]DocumentSequenceResponse, 0, len(sequences))
+	for _, s := range sequences {
+		item := DocumentSequenceResponse{DocumentSequence: s, DocumentName: documentTypeNames[s.DocumentType]}
+		kind, id, _ := strings.Cut(s.ScopeKey, ":")
+		switch kind {
+		case "":
+			item.ScopeName = numberingScopeNames[models.NumberingScopeGlobal]
+		case "academic_year":
+			item.ScopeName = firstNonEmpty(yearNames[s.ScopeKey], s.ScopeKey)
+		case "year":
+			item.ScopeName = id + " год"
+		case "student":
+			item.ScopeName = "Ученик #" + id
+		case "contract":
+			item.ScopeName = "Договор #" + id
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ResetDocumentSequenceInput - новое значение счетчика: следующий документ получит номер LastNumber+1.
+type ResetDocumentSequenceInput struct {
+	DocumentType string `json:"documentType" binding:"required"`
+	ScopeKey     string `json:"scopeKey"`
+	LastNumber   int    `json:"lastNumber"`
+}
+
+// ResetDocumentSequenceHandler устанавливает счетчик нумерации. Если номер, который получит
+// следующий документ, уже занят, документ не будет создан до исправления счетчика.
+func ResetDocumentSequenceHandler(c *gin.Context) {
+	var input ResetDocumentSequenceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	if _, ok := documentTypeNames[input.DocumentType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный вид документа"})
+		return
+	}
+	if !sequenceScopeKeyRe.MatchString(input.ScopeKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная область нумерации"})
+		return
+	}
+	if input.LastNumber < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Значение счетчика не может быть отрицательным"})
+		return
+	}
+
+	sequence := models.DocumentSequence{
+		DocumentType: input.DocumentType,
+		ScopeKey:     input.ScopeKey,
+		LastNumber:   input.LastNumber,
+		UpdatedAt:    time.Now(),
+	}
+	if err := config.DB.Save(&sequence).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить счетчик: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sequence)
+}
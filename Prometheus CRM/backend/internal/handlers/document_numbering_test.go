@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"prometheus-crm/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestValidateNumberingScheme(t *testing.T) {
+	tests := []struct {
+		name    string
+		docType string
+		scheme  NumberingScheme
+		wantErr bool
+	}{
+		{"схема договоров по умолчанию", models.DocumentContract, defaultNumberingSchemes[models.DocumentContract], false},
+		{"схема квитанций по умолчанию", models.DocumentReceipt, defaultNumberingSchemes[models.DocumentReceipt], false},
+		{"договоры по учебным годам с {ayear}", models.DocumentContract, NumberingScheme{"Д-{ayear}/{seq:000}", models.NumberingScopeAcademicYear}, false},
+		{"соглашения не обязаны различать области", models.DocumentAmendment, NumberingScheme{"{seq}", models.NumberingScopeGlobal}, false},
+		{"неизвестный вид документа", "invoice", NumberingScheme{"{seq}", models.NumberingScopeGlobal}, true},
+		{"неизвестная область", models.DocumentAmendment, NumberingScheme{"{seq}", "month"}, true},
+		{"договор в пределах договора", models.DocumentContract, NumberingScheme{"{contract}-{seq}", models.NumberingScopeContract}, true},
+		{"пустой шаблон", models.DocumentAmendment, NumberingScheme{"  ", models.NumberingScopeGlobal}, true},
+		{"без {seq}", models.DocumentAmendment, NumberingScheme{"{year}", models.NumberingScopeGlobal}, true},
+		{"неизвестная подстановка", models.DocumentAmendment, NumberingScheme{"{seq}-{day}", models.NumberingScopeGlobal}, true},
+		{"ширина не у {seq}", models.DocumentAmendment, NumberingScheme{"{year:00}-{seq}", models.NumberingScopeGlobal}, true},
+		{"{contract} в номере договора", models.DocumentContract, NumberingScheme{"{contract}-{seq}", models.NumberingScopeGlobal}, true},
+		{"{grade} в номере квитанции", models.DocumentReceipt, NumberingScheme{"{grade}-{year}-{seq}", models.NumberingScopeCalendarYear}, true},
+		{"номера учеников совпадут", models.DocumentContract, NumberingScheme{"N {seq}", models.NumberingScopeStudent}, true},
+		{"номера календарных лет совпадут", models.DocumentReceipt, NumberingScheme{"{ayear}-{seq}", models.NumberingScopeCalendarYear}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNumberingScheme(tt.docType, tt.scheme)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateNumberingScheme(%s, %+v) error = %v, wantErr %v", tt.docType, tt.scheme, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderDocumentNumber(t *testing.T) {
+	year := &models.AcademicYear{Model: gorm.Model{ID: 3}, Name: "2025-2026", StartDate: time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)}
+	ctx := &NumberingContext{
+		Date:         time.Date(2026, 2, 5, 0, 0, 0, 0, time.UTC),
+		AcademicYear: year,
+		Student:      &models.Student{Model: gorm.Model{ID: 42}},
+		Contract:     &models.Contract{ContractNumber: "N 42-1"},
+	}
+	tests := []struct {
+		pattern string
+		seq     int
+		want    string
+	}{
+		{"N {student}-{seq}", 1, "N 42-1"},
+		{"{year}-{seq:00000}", 17, "2025-00017"},
+		{"{seq:00}", 123, "123"},
+		{"Д-{ayear}/{month}/{seq:000}", 7, "Д-2025-2026/02/007"},
+		{"{contract}/{seq}", 2, "N 42-1/2"},
+		{"без подстановок {seq} {unknown}", 5, "без подстановок 5 {unknown}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := renderDocumentNumber(nil, tt.pattern, tt.seq, 2025, ctx)
+			if err != nil {
+				t.Fatalf("renderDocumentNumber(%q): %v", tt.pattern, err)
+			}
+			if got != tt.want {
+				t.Fatalf("renderDocumentNumber(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := renderDocumentNumber(nil, "{ayear}-{seq}", 1, 2026, &NumberingContext{Date: ctx.Date}); err == nil {
+		t.Fatal("{ayear} без учебного года должен возвращать ошибку")
+	}
+}
+
+func TestNumberingScopeKey(t *testing.T) {
+	date := time.Date(2026, 2, 5, 0, 0, 0, 0, time.UTC)
+	year := &models.AcademicYear{Model: gorm.Model{ID: 3}, StartDate: time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name     string
+		scope    string
+		ctx      NumberingContext
+		wantKey  string
+		wantYear int
+		wantErr  bool
+	}{
+		{"единая нумерация", models.NumberingScopeGlobal, NumberingContext{Date: date, AcademicYear: year}, "", 2025, false},
+		{"по учебным годам", models.NumberingScopeAcademicYear, NumberingContext{Date: date, AcademicYear: year}, "academic_year:3", 2025, false},
+		{"по учебным годам без года", models.NumberingScopeAcademicYear, NumberingContext{Date: date}, "", 0, true},
+		{"по календарным годам", models.NumberingScopeCalendarYear, NumberingContext{Date: date, AcademicYear: year}, "year:2026", 2026, false},
+		{"по ученикам", models.NumberingScopeStudent, NumberingContext{Date: date, Student: &models.Student{Model: gorm.Model{ID: 42}}}, "student:42", 2026, false},
+		{"по договорам", models.NumberingScopeContract, NumberingContext{Date: date, Contract: &models.Contract{ID: 9}}, "contract:9", 2026, false},
+		{"по договорам без договора", models.NumberingScopeContract, NumberingContext{Date: date}, "", 0, true},
+		{"неизвестная область", "month", NumberingContext{Date: date}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, year, err := numberingScopeKey(tt.scope, &tt.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("numberingScopeKey(%s) error = %v, wantErr %v", tt.scope, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if key != tt.wantKey || year != tt.wantYear {
+				t.Fatalf("numberingScopeKey(%s) = %q, %d, want %q, %d", tt.scope, key, year, tt.wantKey, tt.wantYear)
+			}
+			if key != "" && !sequenceScopeKeyRe.MatchString(key) {
+				t.Fatalf("ключ %q не проходит проверку sequenceScopeKeyRe", key)
+			}
+		})
+	}
+}
+
+func TestContractNumberMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		text    string
+		want    []string
+	}{
+		{"схема по умолчанию", "N {student}-{seq}", "Оплата за обучение по договору N 42-1", []string{"N 42-1"}},
+		{"№ вместо префикса", "N {student}-{seq}", "Оплата по дог. №42 - 3 за март", []string{"N 42-3"}},
+		{"ширина {seq} восстанавливается", "Д-{ayear}/{seq:000}", "договор Д-2025 - 2026/7", []string{"Д-2025-2026/007"}},
+		{"месяц дополняется нулем", "{year}/{month}-{seq}", "оплата 2026/2-15", []string{"2026/02-15"}},
+		{"одиночное число только с обозначением договора", "{seq}", "договор 15 от 2026 года", []string{"15"}},
+		{"одиночное число без обозначения не ищется", "{seq}", "оплата 15000 тенге", nil},
+		{"несколько договоров", "N {student}-{seq}", "N 42-1 и N 43-2", []string{"N 42-1", "N 43-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newContractNumberMatcher(tt.pattern)
+			if m == nil {
+				t.Fatalf("newContractNumberMatcher(%q) = nil", tt.pattern)
+			}
+			if got := m.Find(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Find(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"{contract}-{seq}", "без подстановок"} {
+		if m := newContractNumberMatcher(pattern); m != nil {
+			t.Errorf("newContractNumberMatcher(%q) должен возвращать nil", pattern)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// prometheus-crm/internal/handlers/docx_builder.go
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"prometheus-crm/models"
+	"strings"
+)
+
+// Встроенные DOCX-шаблоны (акт сверки, дополнительное соглашение) собираются из абзацев
+// с теми же плейсхолдерами, что доступны в загружаемых шаблонах, и заполняются renderDocxTemplate.
+
+// docxParagraph возвращает абзац WordprocessingML с одним фрагментом текста.
+func docxParagraph(text string, bold bool, center bool) string {
+	var p strings.Builder
+	p.WriteString(`<w:p>`)
+	if center {
+		p.WriteString(`<w:pPr><w:jc w:val="center"/></w:pPr>`)
+	}
+	p.WriteString(`<w:r>`)
+	if bold {
+		p.WriteString(`<w:rPr><w:b/></w:rPr>`)
+	}
+	p.WriteString(`<w:t xml:space="preserve">` + xmlEscape(text) + `</w:t></w:r></w:p>`)
+	return p.String()
+}
+
+// docxTableStart открывает таблицу на всю ширину страницы с границами ячеек.
+// Строки добавляются docxTableRow, таблица закрывается строкой `</w:tbl>`.
+func docxTableStart(b *strings.Builder) {
+	b.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="5000" w:type="pct"/><w:tblBorders>`)
+	for _, side := range []string{"top", "left", "bottom", "right", "insideH", "insideV"} {
+		b.WriteString(`<w:` + side + ` w:val="single" w:sz="4" w:space="0" w:color="000000"/>`)
+	}
+	b.WriteString(`</w:tblBorders></w:tblPr>`)
+}
+
+// docxTableRow добавляет строку таблицы.
+func docxTableRow(b *strings.Builder, bold bool, cells ...string) {
+	b.WriteString(`<w:tr>`)
+	for _, cell := range cells {
+		b.WriteString(`<w:tc><w:p><w:r>`)
+		if bold {
+			b.WriteString(`<w:rPr><w:b/></w:rPr>`)
+		}
+		b.WriteString(`<w:t xml:space="preserve">` + xmlEscape(cell) + `</w:t></w:r></w:p></w:tc>`)
+	}
+	b.WriteString(`</w:tr>`)
+}
+
+// buildDocx собирает минимальный DOCX (A4) из готовых абзацев и таблиц.
+func buildDocx(body ...string) ([]byte, error) {
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+			`</Relationships>`},
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + strings.Join(body, "") +
+			`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1134" w:right="850" w:bottom="1134" w:left="1418" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>` +
+			`</w:body></w:document>`},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания встроенного шаблона: %w", err)
+		}
+		if _, err := io.WriteString(w, f.content); err != nil {
+			return nil, fmt.Errorf("ошибка создания встроенного шаблона: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("ошибка создания встроенного шаблона: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func xmlEscape(s string) string {
+	return xmlEscaper.Replace(s)
+}
+
+// paymentScheduleTableXML - таблица графика платежей «Дата / Платеж / Сумма» с итогом.
+func paymentScheduleTableXML(rows []models.PlannedPayment) string {
+	var b strings.Builder
+	docxTableStart(&b)
+	docxTableRow(&b, true, "Дата", "Платеж", "Сумма")
+	var total models.Money
+	for _, r := range rows {
+		docxTableRow(&b, false, r.PaymentDate.Format("02.01.2006"), r.PaymentName, formatMoney(r.PlannedAmount))
+		total += r.PlannedAmount
+	}
+	docxTableRow(&b, true, "Итого", "", formatMoney(total))
+	b.WriteString(`</w:tbl>`)
+	return b.String()
+}
+
+// paymentScheduleTemplateRows - строки графика для блока {#paymentSchedule} и итог графика.
+func paymentScheduleTemplateRows(rows []models.PlannedPayment) ([]TemplateData, models.Money) {
+	items := make([]TemplateData, 0, len(rows))
+	var total models.Money
+	for i, r := range rows {
+		items = append(items, TemplateData{
+			"number": i + 1,
+			"date":   r.PaymentDate,
+			"name":   r.PaymentName,
+			"amount": r.PlannedAmount,
+		})
+		total += r.PlannedAmount
+	}
+	return items, total
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия файла в zip: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,770 @@
+// prometheus-crm/internal/handlers/docx_template.go
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"prometheus-crm/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Шаблонизатор DOCX-документов (договоры, соглашения, акты сверки).
+//
+// Синтаксис шаблона:
+//
+//	{name}                  - значение; {name|фильтр|фильтр:аргумент} - значение с форматированием
+//	{#name} ... {/name}     - блок: для списка повторяется по элементам, для остальных значений
+//	                          выводится, если значение заполнено (true, непустая строка или список, ненулевая сумма)
+//	{^name} ... {/name}     - обратный блок: выводится, если значение не заполнено
+//
+// Что повторяется в блоке, определяется положением тегов: оба тега в одном абзаце - текст между ними;
+// теги в разных ячейках или строках одной таблицы - строки таблицы целиком (так строится график
+// платежей); иначе - абзацы целиком. Строки и абзацы, в которых кроме тега ничего нет, удаляются.
+// Внутри повторяющегося блока поля элемента списка доступны по имени, значения документа - тоже.
+//
+// Фильтры: money (150 000,00), words и words_kz (сумма прописью по-русски и по-казахски),
+// date (05.03.2025), date_long (05 марта 2025 года), date_kz (2025 жылғы 05 наурыз),
+// upper, lower, default:текст (значение, если поле не заполнено).
+//
+// Word разбивает текст абзаца на фрагменты (w:r) при правке и проверке орфографии, поэтому перед
+// заполнением плейсхолдер собирается во фрагмент, где он начинается, и берет его форматирование.
+// Неизвестные плейсхолдеры, незаполненные значения (если не задан default) и ошибки разметки
+// возвращаются одной ошибкой *TemplateError - документ с пропусками не формируется.
+
+// TemplateData - значения плейсхолдеров (ключи без фигурных скобок): string, models.Money,
+// time.Time, *time.Time, числа, bool, []TemplateData для блоков-списков и DocxFragment.
+type TemplateData map[string]any
+
+// DocxFragment - готовая разметка WordprocessingML (например, таблица), которая заменяет
+// весь абзац с плейсхолдером.
+type DocxFragment string
+
+// TemplateError перечисляет все проблемы заполнения шаблона.
+type TemplateError struct {
+	Unknown  []string // плейсхолдеры, для которых нет значения
+	Unfilled []string // плейсхолдеры с пустым значением
+	Invalid  []string // ошибки разметки блоков и фильтров
+}
+
+func (e *TemplateError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "неизвестные плейсхолдеры: "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Unfilled) > 0 {
+		parts = append(parts, "не заполнены: "+strings.Join(e.Unfilled, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		parts = append(parts, "ошибки шаблона: "+strings.Join(e.Invalid, "; "))
+	}
+	return "шаблон не заполнен - " + strings.Join(parts, "; ")
+}
+
+func (e *TemplateError) empty() bool {
+	return len(e.Unknown) == 0 && len(e.Unfilled) == 0 && len(e.Invalid) == 0
+}
+
+// documentErrorStatus - HTTP-статус ошибки формирования документа: ошибки заполнения шаблона
+// исправляет пользователь (данные карточки или шаблон), поэтому это 400, остальные - fallback.
+func documentErrorStatus(err error, fallback int) int {
+	var tplErr *TemplateError
+	if errors.As(err, &tplErr) {
+		return http.StatusBadRequest
+	}
+	return fallback
+}
+
+var (
+	// templateTokenRe - тег шаблона: вид ("", #, ^, /), имя и цепочка фильтров.
+	templateTokenRe = regexp.MustCompile(`\{([#^/]?)([A-Za-z_][A-Za-z0-9_]*)((?:\|[^{}<>|]*)*)\}`)
+	// docxTextRe - текстовый узел w:t (не w:tab, w:tbl и т.п.).
+	docxTextRe = regexp.MustCompile(`(<w:t(?:\s[^>]*)?>)([^<]*)</w:t>`)
+)
+
+// renderDocxTemplate заполняет основной текст, колонтитулы DOCX-шаблона значениями data.
+func renderDocxTemplate(docxBytes []byte, data TemplateData) ([]byte, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(docxBytes), int64(len(docxBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения docx (zip): %w", err)
+	}
+	r := &docxRenderer{seen: make(map[string]bool)}
+	outputBuf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(outputBuf)
+	for _, file := range zipReader.File {
+		content, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if file.Name == "word/document.xml" || strings.HasPrefix(file.Name, "word/header") || strings.HasPrefix(file.Name, "word/footer") {
+			content = []byte(r.render(normalizeDocxRuns(string(content)), []TemplateData{data}))
+		}
+		w, err := zipWriter.Create(file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания файла в zip: %w", err)
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("ошибка записи в %s: %w", file.Name, err)
+		}
+	}
+	if !r.errs.empty() {
+		return nil, &r.errs
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("ошибка закрытия zip writer: %w", err)
+	}
+	return outputBuf.Bytes(), nil
+}
+
+// scanDocxTemplate проверяет шаблон без заполнения: находит плейсхолдеры, сверяет их с набором
+// known (поля элементов списков записаны как "список.поле") и ищет ошибки разметки блоков и фильтров.
+func scanDocxTemplate(docxBytes []byte, known map[string]bool) (models.TemplateScan, error) {
+	scan := models.TemplateScan{Found: []string{}, Unknown: []string{}, Unused: []string{}, Errors: []string{}}
+	zipReader, err := zip.NewReader(bytes.NewReader(docxBytes), int64(len(docxBytes)))
+	if err != nil {
+		return scan, errors.New("файл не является документом DOCX")
+	}
+	used := make(map[string]bool)
+	listed := make(map[string]bool)
+	add := func(list *[]string, msg string) {
+		if !listed[msg] {
+			listed[msg] = true
+			*list = append(*list, msg)
+		}
+	}
+	// resolve отмечает плейсхолдер использованным: поле ближайшего списка или значение документа.
+	resolve := func(name string, sections []string) {
+		add(&scan.Found, "{"+name+"}")
+		for i := len(sections) - 1; i >= 0; i-- {
+			if field := sections[i] + "." + name; known[field] {
+				used[field] = true
+				return
+			}
+		}
+		if known[name] {
+			used[name] = true
+			return
+		}
+		add(&scan.Unknown, "{"+name+"}")
+	}
+
+	hasDocument := false
+	for _, file := range zipReader.File {
+		if file.Name != "word/document.xml" && !strings.HasPrefix(file.Name, "word/header") && !strings.HasPrefix(file.Name, "word/footer") {
+			continue
+		}
+		hasDocument = hasDocument || file.Name == "word/document.xml"
+		content, err := readZipFile(file)
+		if err != nil {
+			return scan, err
+		}
+		var sections []string
+		for _, m := range templateTokenRe.FindAllStringSubmatch(normalizeDocxRuns(string(content)), -1) {
+			kind, name, filters := m[1], m[2], m[3]
+			switch kind {
+			case "#", "^":
+				resolve(name, sections)
+				sections = append(sections, name)
+			case "/":
+				if len(sections) == 0 || sections[len(sections)-1] != name {
+					add(&scan.Errors, "лишний закрывающий тег {/"+name+"}")
+					continue
+				}
+				sections = sections[:len(sections)-1]
+			default:
+				resolve(name, sections)
+				if filters == "" {
+					continue
+				}
+				for _, f := range strings.Split(filters[1:], "|") {
+					filter, _, _ := strings.Cut(f, ":")
+					if !templateFilters[strings.TrimSpace(filter)] {
+						add(&scan.Errors, html.UnescapeString(m[0])+": неизвестный фильтр "+strconv.Quote(strings.TrimSpace(filter)))
+					}
+				}
+			}
+		}
+		for _, name := range sections {
+			add(&scan.Errors, "не закрыт блок {#"+name+"}")
+		}
+	}
+	if !hasDocument {
+		return scan, errors.New("файл не является документом DOCX")
+	}
+	for name := range known {
+		if !used[name] {
+			scan.Unused = append(scan.Unused, "{"+name+"}")
+		}
+	}
+	sort.Strings(scan.Unused)
+	return scan, nil
+}
+
+// normalizeDocxRuns собирает плейсхолдеры, разрезанные по нескольким w:t одного абзаца,
+// в узел, где плейсхолдер начинается. Остальной текст остается в своих узлах.
+func normalizeDocxRuns(xml string) string {
+	matches := docxTextRe.FindAllStringSubmatchIndex(xml, -1)
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(matches); {
+		j := i + 1
+		for j < len(matches) && !crossesParagraph(xml[matches[j-1][1]:matches[j][0]]) {
+			j++
+		}
+		texts := make([]string, 0, j-i)
+		for _, m := range matches[i:j] {
+			texts = append(texts, html.UnescapeString(xml[m[4]:m[5]]))
+		}
+		merged := mergePlaceholderFragments(texts)
+		for k, m := range matches[i:j] {
+			b.WriteString(xml[last:m[0]])
+			if merged == nil {
+				b.WriteString(xml[m[0]:m[1]])
+			} else {
+				b.WriteString(`<w:t xml:space="preserve">` + xmlEscape(merged[k]) + `</w:t>`)
+			}
+			last = m[1]
+		}
+		i = j
+	}
+	b.WriteString(xml[last:])
+	return b.String()
+}
+
+func crossesParagraph(between string) bool {
+	return strings.Contains(between, "</w:p>") || strings.Contains(between, "<w:p>") || strings.Contains(between, "<w:p ")
+}
+
+// mergePlaceholderFragments перераспределяет текст узлов так, чтобы каждый плейсхолдер целиком
+// оказался в узле со своим первым символом. Возвращает nil, если переносить нечего.
+func mergePlaceholderFragments(texts []string) []string {
+	joined := strings.Join(texts, "")
+	owner := make([]int, len(joined))
+	pos := 0
+	for k, t := range texts {
+		for n := range len(t) {
+			owner[pos+n] = k
+		}
+		pos += len(t)
+	}
+	changed := false
+	for _, span := range templateTokenRe.FindAllStringIndex(joined, -1) {
+		for p := span[0]; p < span[1]; p++ {
+			if owner[p] != owner[span[0]] {
+				owner[p] = owner[span[0]]
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	parts := make([]strings.Builder, len(texts))
+	for p := range len(joined) {
+		parts[owner[p]].WriteByte(joined[p])
+	}
+	merged := make([]string, len(texts))
+	for k := range parts {
+		merged[k] = parts[k].String()
+	}
+	return merged
+}
+
+// docxRenderer заполняет части документа и накапливает ошибки без повторов.
+type docxRenderer struct {
+	errs TemplateError
+	seen map[string]bool
+}
+
+func (r *docxRenderer) report(list *[]string, msg string) {
+	if r.seen[msg] {
+		return
+	}
+	r.seen[msg] = true
+	*list = append(*list, msg)
+}
+
+// render раскрывает блоки и подставляет значения; scopes - значения документа и элементов
+// объемлющих списков (последний - ближайший).
+func (r *docxRenderer) render(xml string, scopes []TemplateData) string {
+	var out strings.Builder
+	for {
+		tokens := templateTokenRe.FindAllStringSubmatchIndex(xml, -1)
+		openIdx := -1
+		for k, t := range tokens {
+			if kind := xml[t[2]:t[3]]; kind == "#" || kind == "^" {
+				openIdx = k
+				break
+			}
+		}
+		if openIdx < 0 {
+			out.WriteString(r.substitute(xml, scopes))
+			return out.String()
+		}
+
+		open := tokens[openIdx]
+		name := xml[open[4]:open[5]]
+		inverted := xml[open[2]:open[3]] == "^"
+		closeTok := matchingCloseTag(xml, tokens[openIdx+1:], name)
+		if closeTok == nil {
+			r.report(&r.errs.Invalid, "не закрыт блок "+xml[open[0]:open[1]])
+			xml = xml[:open[0]] + xml[open[1]:]
+			continue
+		}
+		start, end, inner, rest, ok := docxBlockBounds(xml, open, closeTok)
+		if !ok {
+			r.report(&r.errs.Invalid, "блок "+xml[open[0]:open[1]]+" пересекает границу таблицы")
+			xml = xml[:open[0]] + xml[open[1]:closeTok[0]] + xml[closeTok[1]:]
+			continue
+		}
+
+		value, found := lookupTemplateValue(scopes, name)
+		if !found {
+			r.report(&r.errs.Unknown, "{"+name+"}")
+		}
+		out.WriteString(r.substitute(xml[:start], scopes))
+		items, isList := value.([]TemplateData)
+		switch {
+		case isList && !inverted && len(items) > 0:
+			for _, item := range items {
+				out.WriteString(r.render(inner, append(scopes[:len(scopes):len(scopes)], item)))
+			}
+			xml = xml[end:]
+		case (!isList || inverted) && found && templateTruthy(value) != inverted:
+			// содержимое условного блока обрабатывается дальше в той же области,
+			// вместе с текстом после закрывающего тега
+			xml = inner + xml[end:]
+		default:
+			// текст вокруг тегов в крайних абзацах блоку не принадлежит и обрабатывается дальше
+			xml = rest + xml[end:]
+		}
+	}
+}
+
+// matchingCloseTag ищет {/name}, закрывающий блок, с учетом вложенных блоков с тем же именем.
+func matchingCloseTag(xml string, tokens [][]int, name string) []int {
+	depth := 1
+	for _, t := range tokens {
+		if xml[t[4]:t[5]] != name {
+			continue
+		}
+		switch xml[t[2]:t[3]] {
+		case "#", "^":
+			depth++
+		case "/":
+			if depth--; depth == 0 {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// docxBlockBounds определяет заменяемый фрагмент [start, end), содержимое блока и остаток rest,
+// который заменяет фрагмент, если блок не выводится. ok=false, если блок из абзацев разрезает таблицу.
+func docxBlockBounds(xml string, open, closeTok []int) (start, end int, inner, rest string, ok bool) {
+	if docxElementStart(xml, open[0], "w:p") == docxElementStart(xml, closeTok[0], "w:p") {
+		return open[0], closeTok[1], xml[open[1]:closeTok[0]], "", true
+	}
+	if docxInside(xml, open[0], "w:tr") && docxInside(xml, closeTok[0], "w:tr") &&
+		docxElementStart(xml, open[0], "w:tc") != docxElementStart(xml, closeTok[0], "w:tc") {
+		if docxElementStart(xml, open[0], "w:tbl") != docxElementStart(xml, closeTok[0], "w:tbl") {
+			return 0, 0, "", "", false
+		}
+		return docxWrapBlock(xml, open, closeTok, "w:tr")
+	}
+	start, end, inner, rest, ok = docxWrapBlock(xml, open, closeTok, "w:p")
+	tables := strings.Count(xml[start:end], "<w:tbl>") + strings.Count(xml[start:end], "<w:tbl ")
+	return start, end, inner, rest, ok && tables == strings.Count(xml[start:end], "</w:tbl>")
+}
+
+// docxWrapBlock расширяет блок до целых элементов tag (абзацев или строк таблицы).
+// Элементы с открывающим и закрывающим тегами обрабатываются одинаково: если кроме тега
+// в них нет текста, они удаляются - и в выведенном блоке, и в остатке невыведенного
+// (остаток - текст до открывающего и после закрывающего тега, собранный в один элемент).
+func docxWrapBlock(xml string, open, closeTok []int, tag string) (start, end int, inner, rest string, ok bool) {
+	start = docxElementStart(xml, open[0], tag)
+	openEnd := docxElementEnd(xml, open[0], tag)
+	closeStart := docxElementStart(xml, closeTok[0], tag)
+	end = docxElementEnd(xml, closeTok[0], tag)
+	if start < 0 || openEnd < 0 || closeStart < 0 || end < 0 {
+		return 0, 0, "", "", false
+	}
+	rest = dropBlankElement(xml[start:open[0]] + xml[closeTok[1]:end])
+	if closeStart == start {
+		return start, end, xml[start:open[0]] + xml[open[1]:closeTok[0]] + xml[closeTok[1]:end], rest, true
+	}
+	first := dropBlankElement(xml[start:open[0]] + xml[open[1]:openEnd])
+	last := dropBlankElement(xml[closeStart:closeTok[0]] + xml[closeTok[1]:end])
+	return start, end, first + xml[openEnd:closeStart] + last, rest, true
+}
+
+// dropBlankElement - элемент без видимого текста (остались только теги блока) не выводится.
+func dropBlankElement(element string) string {
+	for _, m := range docxTextRe.FindAllStringSubmatch(element, -1) {
+		if strings.TrimSpace(m[2]) != "" {
+			return element
+		}
+	}
+	return ""
+}
+
+// docxElementStart - начало элемента tag, открытого до позиции pos (-1, если его нет).
+func docxElementStart(xml string, pos int, tag string) int {
+	return max(strings.LastIndex(xml[:pos], "<"+tag+">"), strings.LastIndex(xml[:pos], "<"+tag+" "))
+}
+
+// docxElementEnd - позиция сразу после закрывающего тега элемента tag после pos (-1, если его нет).
+func docxElementEnd(xml string, pos int, tag string) int {
+	idx := strings.Index(xml[pos:], "</"+tag+">")
+	if idx < 0 {
+		return -1
+	}
+	return pos + idx + len("</"+tag+">")
+}
+
+func docxInside(xml string, pos int, tag string) bool {
+	start := docxElementStart(xml, pos, tag)
+	return start >= 0 && strings.LastIndex(xml[:pos], "</"+tag+">") < start
+}
+
+// substitute подставляет значения в фрагмент без блоков. Плейсхолдер со значением DocxFragment
+// заменяет свой абзац целиком.
+func (r *docxRenderer) substitute(xml string, scopes []TemplateData) string {
+	tokens := templateTokenRe.FindAllStringSubmatchIndex(xml, -1)
+	for k := len(tokens) - 1; k >= 0; k-- {
+		t := tokens[k]
+		if xml[t[2]:t[3]] != "" || t[6] != t[7] {
+			continue
+		}
+		value, _ := lookupTemplateValue(scopes, xml[t[4]:t[5]])
+		fragment, isFragment := value.(DocxFragment)
+		if !isFragment || fragment == "" {
+			continue
+		}
+		start, end := docxElementStart(xml, t[0], "w:p"), docxElementEnd(xml, t[0], "w:p")
+		if start < 0 || end < 0 {
+			continue
+		}
+		xml = xml[:start] + string(fragment) + xml[end:]
+	}
+
+	return templateTokenRe.ReplaceAllStringFunc(xml, func(tag string) string {
+		m := templateTokenRe.FindStringSubmatch(tag)
+		if m[1] != "" {
+			r.report(&r.errs.Invalid, "лишний закрывающий тег "+tag)
+			return ""
+		}
+		return xmlEscape(r.value(scopes, m[2], m[3], tag))
+	})
+}
+
+// value вычисляет текст плейсхолдера с фильтрами.
+func (r *docxRenderer) value(scopes []TemplateData, name, filters, tag string) string {
+	v, found := lookupTemplateValue(scopes, name)
+	if !found {
+		r.report(&r.errs.Unknown, "{"+name+"}")
+		return ""
+	}
+	defaulted := false // с фильтром default пустое значение допустимо
+	if filters != "" {
+		for _, f := range strings.Split(filters[1:], "|") {
+			filter, arg, _ := strings.Cut(f, ":")
+			filter = strings.TrimSpace(filter)
+			defaulted = defaulted || filter == "default"
+			var err error
+			if v, err = applyTemplateFilter(v, filter, html.UnescapeString(arg)); err != nil {
+				r.report(&r.errs.Invalid, html.UnescapeString(tag)+": "+err.Error())
+				return ""
+			}
+		}
+	}
+	switch v.(type) {
+	case []TemplateData:
+		r.report(&r.errs.Invalid, "{"+name+"}: список выводится блоком {#"+name+"} ... {/"+name+"}")
+		return ""
+	case DocxFragment:
+		r.report(&r.errs.Invalid, "{"+name+"}: таблица вставляется отдельным абзацем без фильтров")
+		return ""
+	}
+	if !templateTruthy(v) && !defaulted {
+		if _, isBool := v.(bool); !isBool && !isZeroNumber(v) {
+			r.report(&r.errs.Unfilled, "{"+name+"}")
+			return ""
+		}
+	}
+	return formatTemplateValue(v)
+}
+
+func lookupTemplateValue(scopes []TemplateData, name string) (any, bool) {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if v, ok := scopes[i][name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// templateTruthy - условие блока {#name}: значение заполнено.
+func templateTruthy(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case string:
+		return strings.TrimSpace(x) != ""
+	case DocxFragment:
+		return x != ""
+	case []TemplateData:
+		return len(x) > 0
+	case *time.Time:
+		return x != nil && !x.IsZero()
+	case time.Time:
+		return !x.IsZero()
+	case models.Money:
+		return x != 0
+	case int:
+		return x != 0
+	case int64:
+		return x != 0
+	case uint:
+		return x != 0
+	case float64:
+		return x != 0
+	}
+	return true
+}
+
+// isZeroNumber - ноль считается заполненным значением при выводе ({discountPercentage} = 0).
+func isZeroNumber(v any) bool {
+	switch v.(type) {
+	case models.Money, int, int64, uint, float64:
+		return true
+	}
+	return false
+}
+
+func formatTemplateValue(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case models.Money:
+		return formatMoney(x)
+	case time.Time:
+		return x.Format("02.01.2006")
+	case *time.Time:
+		if x == nil {
+			return ""
+		}
+		return x.Format("02.01.2006")
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint:
+		return strconv.FormatUint(uint64(x), 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		if x {
+			return "да"
+		}
+		return "нет"
+	}
+	return fmt.Sprint(v)
+}
+
+// templateFilters - фильтры, которые понимает applyTemplateFilter (для проверки шаблона при загрузке).
+var templateFilters = map[string]bool{
+	"default": true, "upper": true, "lower": true, "money": true, "words": true, "words_kz": true,
+	"date": true, "date_long": true, "date_kz": true,
+}
+
+// applyTemplateFilter применяет фильтр к значению плейсхолдера.
+func applyTemplateFilter(v any, filter, arg string) (any, error) {
+	switch filter {
+	case "default":
+		if !templateTruthy(v) && !isZeroNumber(v) {
+			return arg, nil
+		}
+		return v, nil
+	case "upper":
+		return strings.ToUpper(formatTemplateValue(v)), nil
+	case "lower":
+		return strings.ToLower(formatTemplateValue(v)), nil
+	case "money", "words", "words_kz":
+		if v == nil {
+			return nil, nil
+		}
+		amount, ok := templateMoney(v)
+		if !ok {
+			return nil, fmt.Errorf("фильтр %s применяется к суммам и числам", filter)
+		}
+		switch filter {
+		case "words":
+			return numberToWords(amount), nil
+		case "words_kz":
+			return numberToWordsKz(amount), nil
+		}
+		return formatMoney(amount), nil
+	case "date", "date_long", "date_kz":
+		var d time.Time
+		switch x := v.(type) {
+		case nil:
+			return nil, nil
+		case *time.Time:
+			if x == nil {
+				return nil, nil
+			}
+			d = *x
+		case time.Time:
+			d = x
+		default:
+			return nil, fmt.Errorf("фильтр %s применяется к датам", filter)
+		}
+		switch filter {
+		case "date_long":
+			return formatRussianDate(d), nil
+		case "date_kz":
+			return formatKazakhDate(d), nil
+		}
+		return d.Format("02.01.2006"), nil
+	}
+	return nil, fmt.Errorf("неизвестный фильтр %q", filter)
+}
+
+func templateMoney(v any) (models.Money, bool) {
+	switch x := v.(type) {
+	case models.Money:
+		return x, true
+	case int:
+		return models.Tenge(int64(x)), true
+	case int64:
+		return models.Tenge(x), true
+	case uint:
+		return models.Tenge(int64(x)), true
+	case float64:
+		return models.MoneyFromFloat(x), true
+	}
+	return 0, false
+}
+
+// --- Суммы прописью и даты на русском и казахском ---
+
+var (
+	russianOnes     = []string{"", "один", "два", "три", "четыре", "пять", "шесть", "семь", "восемь", "девять"}
+	russianOnesFem  = []string{"", "одна", "две", "три", "четыре", "пять", "шесть", "семь", "восемь", "девять"}
+	russianTeens    = []string{"десять", "одиннадцать", "двенадцать", "тринадцать", "четырнадцать", "пятнадцать", "шестнадцать", "семнадцать", "восемнадцать", "девятнадцать"}
+	russianTens     = []string{"", "", "двадцать", "тридцать", "сорок", "пятьдесят", "шестьдесят", "семьдесят", "восемьдесят", "девяносто"}
+	russianHundreds = []string{"", "сто", "двести", "триста", "четыреста", "пятьсот", "шестьсот", "семьсот", "восемьсот", "девятьсот"}
+	// russianScales - формы разрядов для 1, 2-4 и 5+ единиц.
+	russianScales = [][3]string{{}, {"тысяча", "тысячи", "тысяч"}, {"миллион", "миллиона", "миллионов"},
+		{"миллиард", "миллиарда", "миллиардов"}, {"триллион", "триллиона", "триллионов"}}
+)
+
+// russianNumberWords записывает целое неотрицательное число словами по-русски.
+func russianNumberWords(n int64) string {
+	if n == 0 {
+		return "ноль"
+	}
+	var groups []string
+	for scale := 0; n > 0 && scale < len(russianScales); scale++ {
+		triple := n % 1000
+		n /= 1000
+		if triple == 0 {
+			continue
+		}
+		var words []string
+		if h := triple / 100; h > 0 {
+			words = append(words, russianHundreds[h])
+		}
+		switch t, o := triple/10%10, triple%10; {
+		case t == 1:
+			words = append(words, russianTeens[o])
+		default:
+			if t > 1 {
+				words = append(words, russianTens[t])
+			}
+			if o > 0 && scale == 1 { // тысяча женского рода: "одна тысяча", "две тысячи"
+				words = append(words, russianOnesFem[o])
+			} else if o > 0 {
+				words = append(words, russianOnes[o])
+			}
+		}
+		if scale > 0 {
+			words = append(words, russianScales[scale][russianPluralForm(triple)])
+		}
+		groups = append([]string{strings.Join(words, " ")}, groups...)
+	}
+	return strings.Join(groups, " ")
+}
+
+// russianPluralForm - индекс формы существительного после числа n: 1, 2-4 или 5+.
+func russianPluralForm(n int64) int {
+	switch {
+	case n%100 >= 11 && n%100 <= 14:
+		return 2
+	case n%10 == 1:
+		return 0
+	case n%10 >= 2 && n%10 <= 4:
+		return 1
+	}
+	return 2
+}
+
+var (
+	kazakhOnes   = []string{"", "бір", "екі", "үш", "төрт", "бес", "алты", "жеті", "сегіз", "тоғыз"}
+	kazakhTens   = []string{"", "он", "жиырма", "отыз", "қырық", "елу", "алпыс", "жетпіс", "сексен", "тоқсан"}
+	kazakhScales = []string{"", "мың", "миллион", "миллиард", "триллион"}
+	kazakhMonths = []string{"қаңтар", "ақпан", "наурыз", "сәуір", "мамыр", "маусым",
+		"шілде", "тамыз", "қыркүйек", "қазан", "қараша", "желтоқсан"}
+)
+
+// kazakhNumberWords записывает целое неотрицательное число словами по-казахски.
+func kazakhNumberWords(n int64) string {
+	if n == 0 {
+		return "нөл"
+	}
+	var groups []string
+	for scale := 0; n > 0 && scale < len(kazakhScales); scale++ {
+		triple := n % 1000
+		n /= 1000
+		if triple == 0 {
+			continue
+		}
+		var words []string
+		if h := triple / 100; h > 0 {
+			words = append(words, kazakhOnes[h], "жүз")
+		}
+		if t := triple / 10 % 10; t > 0 {
+			words = append(words, kazakhTens[t])
+		}
+		if o := triple % 10; o > 0 {
+			words = append(words, kazakhOnes[o])
+		}
+		if scale > 0 {
+			words = append(words, kazakhScales[scale])
+		}
+		groups = append([]string{strings.Join(words, " ")}, groups...)
+	}
+	return strings.Join(groups, " ")
+}
+
+// numberToWordsKz - сумма прописью по-казахски: "бір жүз елу мың теңге 00 тиын".
+func numberToWordsKz(amount models.Money) string {
+	amount = amount.Abs()
+	return fmt.Sprintf("%s теңге %02d тиын", kazakhNumberWords(amount.Tenge()), amount.Tiyn())
+}
+
+// formatKazakhDate - дата в казахской записи: "2025 жылғы 05 наурыз".
+func formatKazakhDate(d time.Time) string {
+	return fmt.Sprintf("%d жылғы %02d %s", d.Year(), d.Day(), kazakhMonths[d.Month()-1])
+}
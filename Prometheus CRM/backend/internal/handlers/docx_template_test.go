@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"prometheus-crm/models"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testParagraph - абзац WordprocessingML из фрагментов текста (по одному w:r на фрагмент).
+func testParagraph(runs ...string) string {
+	var b strings.Builder
+	b.WriteString("<w:p>")
+	for _, r := range runs {
+		b.WriteString(`<w:r><w:rPr><w:b/></w:rPr><w:t>` + r + `</w:t></w:r>`)
+	}
+	b.WriteString("</w:p>")
+	return b.String()
+}
+
+// testTableRow - строка таблицы с ячейками из одного абзаца.
+func testTableRow(cells ...string) string {
+	var b strings.Builder
+	b.WriteString("<w:tr>")
+	for _, c := range cells {
+		b.WriteString("<w:tc>" + testParagraph(c) + "</w:tc>")
+	}
+	b.WriteString("</w:tr>")
+	return b.String()
+}
+
+var docxParagraphRe = regexp.MustCompile(`<w:p>.*?</w:p>`)
+
+// docxParagraphTexts - видимый текст абзацев документа.
+func docxParagraphTexts(xml string) []string {
+	texts := []string{}
+	for _, p := range docxParagraphRe.FindAllString(xml, -1) {
+		var b strings.Builder
+		for _, m := range docxTextRe.FindAllStringSubmatch(p, -1) {
+			b.WriteString(m[2])
+		}
+		texts = append(texts, b.String())
+	}
+	return texts
+}
+
+func TestMergePlaceholderFragments(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []string
+		want  []string
+	}{
+		{"плейсхолдер в одном фрагменте", []string{"Договор ", "{contractNumber}", " от"}, nil},
+		{"разрезан на три фрагмента", []string{"Договор {contr", "actNum", "ber} от"}, []string{"Договор {contractNumber}", "", " от"}},
+		{"текст после плейсхолдера остается на месте", []string{"{a", "}-{b", "}!"}, []string{"{a}", "-{b}", "!"}},
+		{"фильтры переносятся вместе с именем", []string{"{sum|", "money}"}, []string{"{sum|money}", ""}},
+		{"теги блоков", []string{"{#", "items}", "{/items", "}"}, []string{"{#items}", "", "{/items}", ""}},
+		{"одиночная скобка не плейсхолдер", []string{"{", "не плейсхолдер", "}"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergePlaceholderFragments(tt.texts); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mergePlaceholderFragments(%q) = %q, want %q", tt.texts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDocxRuns(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want []string
+	}{
+		{
+			"плейсхолдер собирается в первом фрагменте",
+			testParagraph("Ученик: {child", "FullName", "}"),
+			[]string{"Ученик: {childFullName}"},
+		},
+		{
+			"фрагменты разных абзацев не объединяются",
+			testParagraph("{child") + testParagraph("FullName}"),
+			[]string{"{child", "FullName}"},
+		},
+		{
+			"спецсимволы XML сохраняются",
+			testParagraph("A &amp; B {na", "me}"),
+			[]string{"A &amp; B {name}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := normalizeDocxRuns(tt.xml)
+			if got := docxParagraphTexts(out); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("normalizeDocxRuns = %q, want %q", got, tt.want)
+			}
+			if strings.Count(out, "<w:b/>") != strings.Count(tt.xml, "<w:b/>") {
+				t.Fatal("форматирование фрагментов потеряно")
+			}
+		})
+	}
+}
+
+func TestDocxRendererBlocks(t *testing.T) {
+	rows := []TemplateData{
+		{"date": time.Date(2025, 9, 10, 0, 0, 0, 0, time.UTC), "amount": models.Tenge(150000)},
+		{"date": time.Date(2025, 10, 10, 0, 0, 0, 0, time.UTC), "amount": models.Tenge(75000)},
+	}
+	data := TemplateData{
+		"name":        "Иванов",
+		"hasDiscount": true,
+		"noDiscount":  false,
+		"discount":    models.Money(0),
+		"rows":        rows,
+		"empty":       []TemplateData{},
+		"sum":         models.Tenge(1250000),
+	}
+	tests := []struct {
+		name string
+		xml  string
+		want []string
+	}{
+		{
+			"цикл внутри абзаца",
+			testParagraph("Даты: {#rows}{date} {/rows}конец"),
+			[]string{"Даты: 10.09.2025 10.10.2025 конец"},
+		},
+		{
+			"цикл по строкам таблицы",
+			"<w:tbl>" + testTableRow("{#rows}{date}", "{amount}{/rows}") + "</w:tbl>",
+			[]string{"10.09.2025", "150 000,00", "10.10.2025", "75 000,00"},
+		},
+		{
+			"цикл по абзацам, абзацы с тегами удаляются",
+			testParagraph("{#rows}") + testParagraph("{amount|money} - {name}") + testParagraph("{/rows}"),
+			[]string{"150 000,00 - Иванов", "75 000,00 - Иванов"},
+		},
+		{
+			"пустой список не выводится",
+			testParagraph("до") + testParagraph("{#empty}") + testParagraph("строка") + testParagraph("{/empty}") + testParagraph("после"),
+			[]string{"до", "после"},
+		},
+		{
+			"условие выполнено",
+			testParagraph("Скидка{#hasDiscount} предоставлена{/hasDiscount}."),
+			[]string{"Скидка предоставлена."},
+		},
+		{
+			"условие не выполнено",
+			testParagraph("Скидка{#noDiscount} предоставлена{/noDiscount}."),
+			[]string{"Скидка."},
+		},
+		{
+			"нулевая сумма - ложное условие",
+			testParagraph("{#discount}Скидка {discount}{/discount}{^discount}Без скидки{/discount}"),
+			[]string{"Без скидки"},
+		},
+		{
+			"обратный блок по абзацам, текст вокруг тегов остается",
+			testParagraph("Начало {^hasDiscount}") + testParagraph("без скидки") + testParagraph("{/hasDiscount} конец"),
+			[]string{"Начало  конец"},
+		},
+		{
+			"вложенный блок с тем же именем",
+			testParagraph("{#hasDiscount}a{#hasDiscount}b{/hasDiscount}c{/hasDiscount}"),
+			[]string{"abc"},
+		},
+		{
+			"фильтры",
+			testParagraph("{sum|money} ({sum|words}), {name|upper}, {missing_ok|default:нет}"),
+			[]string{"1 250 000,00 (один миллион двести пятьдесят тысяч тенге 00 тиын), ИВАНОВ, нет"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := TemplateData{"missing_ok": ""}
+			for k, v := range data {
+				scope[k] = v
+			}
+			r := &docxRenderer{seen: make(map[string]bool)}
+			out := r.render(normalizeDocxRuns(tt.xml), []TemplateData{scope})
+			if !r.errs.empty() {
+				t.Fatalf("render: %v", &r.errs)
+			}
+			if got := docxParagraphTexts(out); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocxRendererErrors(t *testing.T) {
+	data := TemplateData{"name": "", "rows": []TemplateData{{"x": "1"}}, "date": (*time.Time)(nil)}
+	tests := []struct {
+		name string
+		xml  string
+		want TemplateError
+	}{
+		{"неизвестный плейсхолдер", testParagraph("{unknown}"), TemplateError{Unknown: []string{"{unknown}"}}},
+		{"пустое значение", testParagraph("{name}"), TemplateError{Unfilled: []string{"{name}"}}},
+		{"пустая дата", testParagraph("{date|date}"), TemplateError{Unfilled: []string{"{date}"}}},
+		{"не закрыт блок", testParagraph("{#rows}текст"), TemplateError{Invalid: []string{"не закрыт блок {#rows}"}}},
+		{"лишний закрывающий тег", testParagraph("текст{/rows}"), TemplateError{Invalid: []string{"лишний закрывающий тег {/rows}"}}},
+		{"список без блока", testParagraph("{rows}"), TemplateError{Invalid: []string{"{rows}: список выводится блоком {#rows} ... {/rows}"}}},
+		{"неизвестный фильтр", testParagraph("{name|bold}"), TemplateError{Invalid: []string{`{name|bold}: неизвестный фильтр "bold"`}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &docxRenderer{seen: make(map[string]bool)}
+			r.render(tt.xml, []TemplateData{data})
+			if !reflect.DeepEqual(r.errs, tt.want) {
+				t.Fatalf("errs = %+v, want %+v", r.errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderDocxTemplate(t *testing.T) {
+	var src bytes.Buffer
+	zw := zip.NewWriter(&src)
+	files := map[string]string{
+		"word/document.xml": `<w:document><w:body>` + testParagraph("Договор {contract", "Number}") + `</w:body></w:document>`,
+		"word/footer1.xml":  `<w:ftr>` + testParagraph("{name}") + `</w:ftr>`,
+		"word/styles.xml":   `<w:styles>{name}</w:styles>`,
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := renderDocxTemplate(src.Bytes(), TemplateData{"contractNumber": "N 42-1", "name": "Иванов"})
+	if err != nil {
+		t.Fatalf("renderDocxTemplate: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"word/document.xml": {"Договор N 42-1"},
+		"word/footer1.xml":  {"Иванов"},
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if texts, ok := want[f.Name]; ok {
+			if got := docxParagraphTexts(string(content)); !reflect.DeepEqual(got, texts) {
+				t.Errorf("%s = %q, want %q", f.Name, got, texts)
+			}
+		} else if string(content) != files[f.Name] {
+			t.Errorf("%s изменен, хотя не является текстом документа", f.Name)
+		}
+	}
+
+	_, err = renderDocxTemplate(src.Bytes(), TemplateData{"contractNumber": "N 42-1"})
+	var tplErr *TemplateError
+	if !errors.As(err, &tplErr) || !reflect.DeepEqual(tplErr.Unknown, []string{"{name}"}) {
+		t.Fatalf("ошибка без {name} = %v, want TemplateError с {name}", err)
+	}
+}
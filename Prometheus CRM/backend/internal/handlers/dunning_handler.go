@@ -0,0 +1,524 @@
+// prometheus-crm/internal/handlers/dunning_handler.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// DunningService - настройки автоматического запуска напоминаний в integration_settings.
+	DunningService = "dunning"
+
+	defaultDunningSendHour = 10
+	// dunningMaxAttempts - сколько раз повторять неудачную отправку по одному шагу и каналу.
+	dunningMaxAttempts = 3
+)
+
+// DunningSettings - параметры автоматического запуска напоминаний.
+type DunningSettings struct {
+	// SendHour - час (по времени сервера), начиная с которого в течение дня рассылаются напоминания.
+	SendHour int `json:"sendHour"`
+}
+
+// DunningMessageData - переменные, доступные в шаблонах шагов.
+// DaysOverdue равно смещению шага (отрицательное для напоминаний до срока).
+type DunningMessageData struct {
+	ParentName     string
+	StudentName    string
+	ContractNumber string
+	PaymentName    string
+	Amount         string
+	DueDate        string
+	DaysOverdue    int
+}
+
+// DunningRunResult - итог одного запуска.
+type DunningRunResult struct {
+	Date       string `json:"date"`
+	Sent       int    `json:"sent"`
+	Failed     int    `json:"failed"`
+	Suppressed int    `json:"suppressed"`
+	NoContact  int    `json:"noContact"`
+}
+
+// dunningCandidate - неоплаченная строка графика вместе с контактами родителя.
+type dunningCandidate struct {
+	PlannedPaymentID    uint
+	ContractID          uint
+	ContractNumber      string
+	PaymentName         string
+	PaymentDate         time.Time
+	Outstanding         models.Money
+	StudentLastName     string
+	StudentFirstName    string
+	ContractParentName  string
+	ContractParentEmail string
+	ContractParentPhone string
+}
+
+// StartDunningScheduler запускает ежедневную рассылку напоминаний в фоне.
+// Проверка выполняется раз в час; запуск идемпотентен (повтор шага по тому же каналу не отправляется),
+// поэтому перезапуск сервера или несколько проверок в день не приводят к дублям.
+// Запускается из routes.SetupRoutes вместе с остальными фоновыми задачами.
+func StartDunningScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			runScheduledDunning(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func runScheduledDunning(ctx context.Context) {
+	settings, err := loadEnabledSettings(DunningService)
+	if err != nil {
+		return // автоматическая рассылка выключена
+	}
+	sendHour := defaultDunningSendHour
+	if v, ok := settings["sendHour"].(float64); ok && v >= 0 && v < 24 {
+		sendHour = int(v)
+	}
+	now := time.Now()
+	if now.Hour() < sendHour {
+		return
+	}
+	result, err := runDunning(ctx, now)
+	if err != nil {
+		slog.Error("Dunning run failed", "error", err)
+		return
+	}
+	slog.Info("Dunning run finished", "date", result.Date, "sent", result.Sent, "failed", result.Failed, "suppressed", result.Suppressed)
+}
+
+// runDunning отправляет напоминания всех активных шагов на указанный день.
+// Для шага со смещением N выбираются неоплаченные строки графика с датой платежа (день - N).
+// Оплаченные строки отсекаются запросом, договоры с действующим обещанием оплаты - подавляются.
+func runDunning(ctx context.Context, day time.Time) (DunningRunResult, error) {
+	today := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	result := DunningRunResult{Date: today.Format("2006-01-02")}
+
+	var steps []models.DunningStep
+	if err := config.DB.Where("is_active = ?", true).Order("offset_days ASC").Find(&steps).Error; err != nil {
+		return result, fmt.Errorf("не удалось загрузить шаги напоминаний: %w", err)
+	}
+
+	for _, step := range steps {
+		subjectTpl, bodyTpl, err := parseDunningTemplates(step)
+		if err != nil {
+			slog.Error("Invalid dunning step template", "stepId", step.ID, "error", err)
+			continue
+		}
+
+		dueDate := today.AddDate(0, 0, -step.OffsetDays)
+		candidates, err := loadDunningCandidates(dueDate)
+		if err != nil {
+			return result, err
+		}
+
+		for _, cand := range candidates {
+			promised, err := hasActivePaymentPromise(cand.ContractID, today)
+			if err != nil {
+				return result, err
+			}
+
+			data := DunningMessageData{
+				ParentName:     cand.ContractParentName,
+				StudentName:    strings.TrimSpace(cand.StudentLastName + " " + cand.StudentFirstName),
+				ContractNumber: cand.ContractNumber,
+				PaymentName:    cand.PaymentName,
+				Amount:         cand.Outstanding.String(),
+				DueDate:        cand.PaymentDate.Format("02.01.2006"),
+				DaysOverdue:    step.OffsetDays,
+			}
+			subject, body, err := renderDunningMessage(subjectTpl, bodyTpl, data)
+			if err != nil {
+				slog.Error("Failed to render dunning message", "stepId", step.ID, "error", err)
+				continue
+			}
+
+			for _, channel := range dunningStepChannels(step) {
+				status, err := sendDunningReminder(ctx, step, cand, channel, subject, body, promised)
+				if err != nil {
+					return result, err
+				}
+				switch status {
+				case models.ReminderStatusSent:
+					result.Sent++
+				case models.ReminderStatusFailed:
+					result.Failed++
+				case models.ReminderStatusSuppressed:
+					result.Suppressed++
+				case models.ReminderStatusNoContact:
+					result.NoContact++
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// loadDunningCandidates возвращает непогашенные строки графика с указанной датой платежа.
+func loadDunningCandidates(dueDate time.Time) ([]dunningCandidate, error) {
+	var candidates []dunningCandidate
+	err := config.DB.Table("planned_payments pp").
+		Select(`
+			pp.id AS planned_payment_id,
+			pp.contract_id,
+			c.contract_number,
+			pp.payment_name,
+			pp.payment_date,
+			pp.planned_amount - pp.paid_amount AS outstanding,
+			s.last_name AS student_last_name,
+			s.first_name AS student_first_name,
+			s.contract_parent_name,
+			s.contract_parent_email,
+			s.contract_parent_phone
+		`).
+		Joins("JOIN contracts c ON c.id = pp.contract_id AND c.deleted_at IS NULL").
+		Joins("JOIN students s ON s.id = c.student_id").
+		Where("pp.deleted_at IS NULL").
+		Where("pp.payment_date = ?", dueDate.Format("2006-01-02")).
+		Where("pp.paid_amount < pp.planned_amount").
+		Scan(&candidates).Error
+	if err != nil {
+		return nil, fmt.Errorf("не удалось выбрать платежи для напоминаний: %w", err)
+	}
+	return candidates, nil
+}
+
+// hasActivePaymentPromise - есть ли у договора обещание оплаты, срок которого еще не прошел.
+func hasActivePaymentPromise(contractID uint, today time.Time) (bool, error) {
+	var count int64
+	err := config.DB.Model(&models.PaymentPromise{}).
+		Where("contract_id = ? AND promised_date >= ?", contractID, today.Format("2006-01-02")).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// sendDunningReminder отправляет напоминание по одному каналу и записывает результат в журнал.
+// Возвращает пустой статус, если напоминание уже было отправлено (или исчерпаны попытки).
+func sendDunningReminder(ctx context.Context, step models.DunningStep, cand dunningCandidate, channel, subject, body string, promised bool) (string, error) {
+	var done, failed int64
+	base := config.DB.Model(&models.DunningReminder{}).
+		Where("planned_payment_id = ? AND step_id = ? AND channel = ?", cand.PlannedPaymentID, step.ID, channel)
+	if err := base.Session(&gorm.Session{}).Where("status <> ?", models.ReminderStatusFailed).Count(&done).Error; err != nil {
+		return "", err
+	}
+	if err := base.Session(&gorm.Session{}).Where("status = ?", models.ReminderStatusFailed).Count(&failed).Error; err != nil {
+		return "", err
+	}
+	if done > 0 || failed >= dunningMaxAttempts {
+		return "", nil
+	}
+
+	reminder := models.DunningReminder{
+		ContractID:       cand.ContractID,
+		PlannedPaymentID: cand.PlannedPaymentID,
+		StepID:           step.ID,
+		Channel:          channel,
+		Subject:          subject,
+		Body:             body,
+	}
+	switch channel {
+	case models.ReminderChannelEmail:
+		reminder.Recipient = strings.TrimSpace(cand.ContractParentEmail)
+	case models.ReminderChannelSMS:
+		reminder.Recipient = strings.TrimSpace(cand.ContractParentPhone)
+	}
+
+	sender, ok := reminderSenderFor(channel)
+	switch {
+	case promised:
+		reminder.Status = models.ReminderStatusSuppressed
+	case reminder.Recipient == "":
+		reminder.Status = models.ReminderStatusNoContact
+	case !ok:
+		reminder.Status = models.ReminderStatusFailed
+		reminder.Error = "неизвестный канал " + channel
+	default:
+		if err := sender.Send(ctx, ReminderMessage{To: reminder.Recipient, Subject: subject, Body: body}); err != nil {
+			reminder.Status = models.ReminderStatusFailed
+			reminder.Error = err.Error()
+		} else {
+			now := time.Now()
+			reminder.Status = models.ReminderStatusSent
+			reminder.SentAt = &now
+		}
+	}
+
+	if err := config.DB.Create(&reminder).Error; err != nil {
+		return "", fmt.Errorf("не удалось записать напоминание: %w", err)
+	}
+	return reminder.Status, nil
+}
+
+func dunningStepChannels(step models.DunningStep) []string {
+	var channels []string
+	for _, ch := range strings.Split(step.Channels, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			channels = append(channels, ch)
+		}
+	}
+	return channels
+}
+
+func parseDunningTemplates(step models.DunningStep) (*template.Template, *template.Template, error) {
+	subject, err := template.New("subject").Option("missingkey=error").Parse(step.SubjectTemplate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка в шаблоне темы: %w", err)
+	}
+	body, err := template.New("body").Option("missingkey=error").Parse(step.BodyTemplate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка в шаблоне текста: %w", err)
+	}
+	return subject, body, nil
+}
+
+func renderDunningMessage(subjectTpl, bodyTpl *template.Template, data DunningMessageData) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := subjectTpl.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := bodyTpl.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return subject.String(), body.String(), nil
+}
+
+// validateDunningStep проверяет шаг перед сохранением: каналы и шаблоны должны быть корректны.
+func validateDunningStep(step models.DunningStep) error {
+	if strings.TrimSpace(step.Name) == "" || strings.TrimSpace(step.BodyTemplate) == "" {
+		return errors.New("название и текст напоминания обязательны")
+	}
+	channels := dunningStepChannels(step)
+	if len(channels) == 0 {
+		return errors.New("не указан канал отправки")
+	}
+	for _, ch := range channels {
+		if ch != models.ReminderChannelEmail && ch != models.ReminderChannelSMS {
+			return fmt.Errorf("неизвестный канал %q", ch)
+		}
+	}
+	subjectTpl, bodyTpl, err := parseDunningTemplates(step)
+	if err != nil {
+		return err
+	}
+	// Пробный рендер ловит обращения к несуществующим переменным.
+	if _, _, err := renderDunningMessage(subjectTpl, bodyTpl, DunningMessageData{}); err != nil {
+		return fmt.Errorf("ошибка в шаблоне: %w", err)
+	}
+	return nil
+}
+
+// --- HTTP-обработчики ---
+
+// ListDunningStepsHandler возвращает шаги сценария напоминаний.
+func ListDunningStepsHandler(c *gin.Context) {
+	var steps []models.DunningStep
+	if err := config.DB.Order("offset_days ASC, id ASC").Find(&steps).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить шаги напоминаний"})
+		return
+	}
+	c.JSON(http.StatusOK, steps)
+}
+
+// CreateDunningStepHandler добавляет шаг сценария.
+func CreateDunningStepHandler(c *gin.Context) {
+	var step models.DunningStep
+	if err := c.ShouldBindJSON(&step); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	step.ID = 0
+	if err := validateDunningStep(step); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Create(&step).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить шаг"})
+		return
+	}
+	c.JSON(http.StatusCreated, step)
+}
+
+// UpdateDunningStepHandler изменяет шаг сценария.
+func UpdateDunningStepHandler(c *gin.Context) {
+	var step models.DunningStep
+	if err := config.DB.First(&step, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Шаг не найден"})
+		return
+	}
+	var input models.DunningStep
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	step.Name = input.Name
+	step.OffsetDays = input.OffsetDays
+	step.Channels = input.Channels
+	step.SubjectTemplate = input.SubjectTemplate
+	step.BodyTemplate = input.BodyTemplate
+	if input.IsActive != nil {
+		step.IsActive = input.IsActive
+	}
+	if err := validateDunningStep(step); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Save(&step).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить шаг"})
+		return
+	}
+	c.JSON(http.StatusOK, step)
+}
+
+// DeleteDunningStepHandler удаляет шаг сценария.
+func DeleteDunningStepHandler(c *gin.Context) {
+	if err := config.DB.Delete(&models.DunningStep{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить шаг"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Шаг удален"})
+}
+
+// ListDunningRemindersHandler - журнал отправленных напоминаний (фильтры contract_id, status).
+func ListDunningRemindersHandler(c *gin.Context) {
+	var reminders []models.DunningReminder
+	var totalRows int64
+
+	query := config.DB.Model(&models.DunningReminder{})
+	if contractID := c.Query("contract_id"); contractID != "" {
+		query = query.Where("contract_id = ?", contractID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err := query.Count(&totalRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать напоминания"})
+		return
+	}
+	if err := query.Scopes(Paginate(c)).Order("id DESC").Find(&reminders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить напоминания"})
+		return
+	}
+	if reminders == nil {
+		reminders = make([]models.DunningReminder, 0)
+	}
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, reminders, totalRows))
+}
+
+// RunDunningHandler запускает рассылку вручную (?date=YYYY-MM-DD, по умолчанию сегодня).
+func RunDunningHandler(c *gin.Context) {
+	day := time.Now()
+	if v := c.Query("date"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD."})
+			return
+		}
+		day = parsed
+	}
+	result, err := runDunning(c.Request.Context(), day)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDunningSettingsHandler получает настройки автоматической рассылки
+func GetDunningSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, DunningService)
+}
+
+// SaveDunningSettingsHandler сохраняет настройки автоматической рассылки
+func SaveDunningSettingsHandler(c *gin.Context) {
+	var payload struct {
+		IsEnabled bool            `json:"isEnabled"`
+		Settings  DunningSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	if payload.Settings.SendHour < 0 || payload.Settings.SendHour > 23 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Час отправки должен быть от 0 до 23"})
+		return
+	}
+	saveIntegrationSettings(c, DunningService, payload.IsEnabled, payload.Settings)
+}
+
+// PaymentPromiseInput - обещание оплаты, зафиксированное менеджером после звонка.
+type PaymentPromiseInput struct {
+	PromisedDate string       `json:"promisedDate" binding:"required"`
+	Amount       models.Money `json:"amount"`
+	Comment      string       `json:"comment"`
+}
+
+// ListPaymentPromisesHandler возвращает обещания оплаты по договору.
+func ListPaymentPromisesHandler(c *gin.Context) {
+	var promises []models.PaymentPromise
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).Order("promised_date DESC").Find(&promises).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить обещания оплаты"})
+		return
+	}
+	if promises == nil {
+		promises = make([]models.PaymentPromise, 0)
+	}
+	c.JSON(http.StatusOK, promises)
+}
+
+// CreatePaymentPromiseHandler фиксирует обещание оплаты; до этой даты напоминания не отправляются.
+func CreatePaymentPromiseHandler(c *gin.Context) {
+	contractID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contract ID"})
+		return
+	}
+	var input PaymentPromiseInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указана дата обещания (promisedDate)"})
+		return
+	}
+	promisedDate, err := time.Parse("2006-01-02", input.PromisedDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD."})
+		return
+	}
+
+	promise := models.PaymentPromise{
+		ContractID:   uint(contractID),
+		PromisedDate: promisedDate,
+		Amount:       input.Amount,
+		Comment:      input.Comment,
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		promise.CreatedByID = &userID
+	}
+	if err := config.DB.Create(&promise).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить обещание оплаты"})
+		return
+	}
+	c.JSON(http.StatusCreated, promise)
+}
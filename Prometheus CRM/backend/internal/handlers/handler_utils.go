@@ -1,5 +1,13 @@
 package handlers
 
+import (
+	"errors"
+	"prometheus-crm/models"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xuri/excelize/v2"
+)
+
 // getMonthIndex - вспомогательная функция для преобразования названия месяца в его порядковый номер (0-11).
 func getMonthIndex(monthStr string) int {
 	months := map[string]int{
@@ -8,3 +16,18 @@ func getMonthIndex(monthStr string) int {
 	}
 	return months[monthStr]
 }
+
+// pgUniqueViolation - код ошибки PostgreSQL unique_violation.
+const pgUniqueViolation = "23505"
+
+// isUniqueViolation - вставка отклонена уникальным индексом (например, параллельная запись той же сущности).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// setMoneyCell записывает сумму в ячейку Excel числом с двумя знаками после запятой.
+// SetCellValue записал бы models.Money строкой.
+func setMoneyCell(f *excelize.File, sheet, cell string, v models.Money) error {
+	return f.SetCellFloat(sheet, cell, v.Float64(), 2, 64)
+}
@@ -67,6 +67,195 @@ func SaveTrustMeSettingsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Настройки успешно сохранены"})
 }
 
+// OneCSettings представляет структуру настроек интеграции с 1С
+type OneCSettings struct {
+	// Secret - общий секрет для HMAC-подписи вебхуков от 1С.
+	Secret string `json:"secret"`
+	// ToleranceSeconds - допустимое расхождение времени отправки вебхука, в секундах.
+	ToleranceSeconds int `json:"toleranceSeconds"`
+}
+
+// GetOneCSettingsHandler получает настройки интеграции с 1С
+func GetOneCSettingsHandler(c *gin.Context) {
+	var settings models.IntegrationSetting
+	err := config.DB.Where("service_name = ?", OneCService).First(&settings).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+	settings.Settings = maskSecrets(settings.Settings, "secret")
+	c.JSON(http.StatusOK, settings)
+}
+
+// SaveOneCSettingsHandler сохраняет настройки интеграции с 1С
+func SaveOneCSettingsHandler(c *gin.Context) {
+	var payload struct {
+		IsEnabled bool         `json:"isEnabled"`
+		Settings  OneCSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	keepStoredSecret(OneCService, "secret", &payload.Settings.Secret)
+	if payload.IsEnabled && payload.Settings.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Для включения интеграции необходимо указать секрет"})
+		return
+	}
+
+	settingsJSON, _ := json.Marshal(payload.Settings)
+
+	setting := models.IntegrationSetting{
+		ServiceName: OneCService,
+		IsEnabled:   payload.IsEnabled,
+		Settings:    make(map[string]interface{}),
+	}
+	json.Unmarshal(settingsJSON, &setting.Settings)
+
+	err := config.DB.Where(models.IntegrationSetting{ServiceName: OneCService}).Assign(setting).FirstOrCreate(&setting).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Настройки успешно сохранены"})
+}
+
+// EmailSettings - параметры SMTP-сервера для отправки писем родителям
+type EmailSettings struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// SMSSettings - параметры HTTP-шлюза для отправки SMS
+type SMSSettings struct {
+	APIURL string `json:"apiUrl"`
+	APIKey string `json:"apiKey"`
+	Sender string `json:"sender"`
+}
+
+// GetEmailSettingsHandler получает настройки SMTP
+func GetEmailSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, SMTPService, "password")
+}
+
+// SaveEmailSettingsHandler сохраняет настройки SMTP
+func SaveEmailSettingsHandler(c *gin.Context) {
+	var payload struct {
+		IsEnabled bool          `json:"isEnabled"`
+		Settings  EmailSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	keepStoredSecret(SMTPService, "password", &payload.Settings.Password)
+	if payload.IsEnabled && (payload.Settings.Host == "" || payload.Settings.From == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Для включения необходимо указать SMTP-сервер и адрес отправителя"})
+		return
+	}
+	saveIntegrationSettings(c, SMTPService, payload.IsEnabled, payload.Settings)
+}
+
+// GetSMSSettingsHandler получает настройки SMS-шлюза
+func GetSMSSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, SMSService, "apiKey")
+}
+
+// SaveSMSSettingsHandler сохраняет настройки SMS-шлюза
+func SaveSMSSettingsHandler(c *gin.Context) {
+	var payload struct {
+		IsEnabled bool        `json:"isEnabled"`
+		Settings  SMSSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	keepStoredSecret(SMSService, "apiKey", &payload.Settings.APIKey)
+	if payload.IsEnabled && payload.Settings.APIURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Для включения необходимо указать адрес SMS-шлюза"})
+		return
+	}
+	saveIntegrationSettings(c, SMSService, payload.IsEnabled, payload.Settings)
+}
+
+// respondIntegrationSettings отдает сохраненные настройки сервиса (пустой объект, если их нет).
+// Значения ключей secretKeys заменяются маской (см. maskSecrets).
+func respondIntegrationSettings(c *gin.Context, serviceName string, secretKeys ...string) {
+	var settings models.IntegrationSetting
+	err := config.DB.Where("service_name = ?", serviceName).First(&settings).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+	settings.Settings = maskSecrets(settings.Settings, secretKeys...)
+	c.JSON(http.StatusOK, settings)
+}
+
+// saveIntegrationSettings сохраняет настройки сервиса (upsert по service_name)
+func saveIntegrationSettings(c *gin.Context, serviceName string, isEnabled bool, payload interface{}) {
+	settingsJSON, _ := json.Marshal(payload)
+
+	setting := models.IntegrationSetting{
+		ServiceName: serviceName,
+		IsEnabled:   isEnabled,
+		Settings:    make(map[string]interface{}),
+	}
+	json.Unmarshal(settingsJSON, &setting.Settings)
+
+	err := config.DB.Where(models.IntegrationSetting{ServiceName: serviceName}).Assign(setting).FirstOrCreate(&setting).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Настройки успешно сохранены"})
+}
+
+// secretMask подставляется в GET-ответы вместо сохраненных секретов. Если клиент
+// присылает маску обратно при сохранении, секрет не меняется.
+const secretMask = "********"
+
+// maskSecrets возвращает копию настроек, в которой непустые значения ключей keys заменены маской.
+func maskSecrets(settings models.JSONB, keys ...string) models.JSONB {
+	masked := make(models.JSONB, len(settings))
+	for k, v := range settings {
+		masked[k] = v
+	}
+	for _, key := range keys {
+		if value, ok := masked[key].(string); ok && value != "" {
+			masked[key] = secretMask
+		}
+	}
+	return masked
+}
+
+// keepStoredSecret заменяет пришедшую маску сохраненным значением секрета сервиса.
+func keepStoredSecret(serviceName, key string, value *string) {
+	if *value != secretMask {
+		return
+	}
+	*value = ""
+	var stored models.IntegrationSetting
+	if err := config.DB.Where("service_name = ?", serviceName).First(&stored).Error; err == nil {
+		if secret, ok := stored.Settings[key].(string); ok {
+			*value = secret
+		}
+	}
+}
+
 // ListContractsForSigningHandler возвращает список договоров, которые еще не были отправлены
 func ListContractsForSigningHandler(c *gin.Context) {
 	var contracts []models.Contract
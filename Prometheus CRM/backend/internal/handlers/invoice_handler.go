@@ -89,7 +89,7 @@ func DownloadInvoiceArchiveHandler(c *gin.Context) {
 			strconv.Itoa(int(inv.UserID)), inv.User.FullName, inv.Status,
 			inv.Department, inv.RegisterItem, inv.BudgetItem,
 			inv.Kontragent, inv.Bin, inv.InvoiceNumber, invoiceDate,
-			fmt.Sprintf("%.2f", inv.TotalAmount), paymentDate, inv.RejectionReason,
+			inv.TotalAmount.String(), paymentDate, inv.RejectionReason,
 			inv.InvoiceFileUrl,
 		}
 		if err := w.Write(record); err != nil {
@@ -141,7 +141,7 @@ func SubmitInvoiceHandler(c *gin.Context) {
 	contractFileURL, _ := saveUploadedFile(c, "contractFile", uploadDir)
 	memoFileURL, _ := saveUploadedFile(c, "memoFile", uploadDir)
 
-	totalAmount, _ := strconv.ParseFloat(c.PostForm("totalAmount"), 64)
+	totalAmount, _ := models.ParseMoney(c.PostForm("totalAmount"))
 	invoiceDate, _ := time.Parse("2006-01-02", c.PostForm("invoiceDate"))
 
 	// Читаем и парсим новую дату оплаты
@@ -509,15 +509,15 @@ func getInternalBalance(department, budgetItem, registerItem string) (GetBalance
 			First(&registryEntry).Error
 
 		if err == nil {
-			var totalSpent float64
+			var totalSpent models.Money
 			config.DB.Model(&models.Transaction{}).
 				Where("registry_entry_id = ?", registryEntry.ID).
 				Select("COALESCE(SUM(amount), 0)").
 				Row().
 				Scan(&totalSpent)
 
-			remaining := float64(registryEntry.Amount) - totalSpent
-			registerBalanceStr = fmt.Sprintf("%.2f", remaining)
+			remaining := registryEntry.Amount - totalSpent
+			registerBalanceStr = remaining.String()
 		}
 	}
 
@@ -530,12 +530,12 @@ func getInternalBalance(department, budgetItem, registerItem string) (GetBalance
 		var registryEntries []models.RegistryEntry
 		config.DB.Where("budget_item_id = ?", budgetItemModel.ID).Find(&registryEntries)
 
-		var totalBudget float64
-		var totalSpent float64
+		var totalBudget models.Money
+		var totalSpent models.Money
 
 		for _, entry := range registryEntries {
-			totalBudget += float64(entry.Amount)
-			var spentOnEntry float64
+			totalBudget += entry.Amount
+			var spentOnEntry models.Money
 			config.DB.Model(&models.Transaction{}).
 				Where("registry_entry_id = ?", entry.ID).
 				Select("COALESCE(SUM(amount), 0)").
@@ -545,7 +545,7 @@ func getInternalBalance(department, budgetItem, registerItem string) (GetBalance
 		}
 
 		remainingBudget := totalBudget - totalSpent
-		budgetBalanceStr = fmt.Sprintf("%.2f", remainingBudget)
+		budgetBalanceStr = remainingBudget.String()
 	}
 
 	return GetBalanceResponse{
@@ -708,7 +708,7 @@ func ResubmitInvoiceHandler(c *gin.Context) {
 	invoice.PaymentPurpose = c.PostForm("paymentPurpose")
 
 	if totalAmountStr := c.PostForm("totalAmount"); totalAmountStr != "" {
-		totalAmount, _ := strconv.ParseFloat(totalAmountStr, 64)
+		totalAmount, _ := models.ParseMoney(totalAmountStr)
 		invoice.TotalAmount = totalAmount
 	}
 	if invoiceDateStr := c.PostForm("invoiceDate"); invoiceDateStr != "" {
@@ -0,0 +1,395 @@
+// prometheus-crm/internal/handlers/ledger_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ContractBalance - производные от журнала расчетов показатели договора.
+type ContractBalance struct {
+	ContractID  uint         `json:"contractId"`
+	Charged     models.Money `json:"charged"`     // начислено (с учетом корректировок)
+	Discounts   models.Money `json:"discounts"`   // предоставлено скидок
+	Paid        models.Money `json:"paid"`        // поступило денег
+	Refunded    models.Money `json:"refunded"`    // возвращено плательщику
+	Adjustments models.Money `json:"adjustments"` // ручные корректировки
+	Balance     models.Money `json:"balance"`     // остаток долга (отрицательный - переплата)
+}
+
+// ledgerBalanceSQL - подзапрос баланса договора по журналу расчетов, для использования в отчетах.
+const ledgerBalanceSQL = "COALESCE((SELECT SUM(le.amount) FROM ledger_entries le WHERE le.contract_id = contracts.id), 0)"
+
+// postLedgerEntry добавляет проводку в журнал расчетов.
+// Сумма передается со знаком (см. models.LedgerEntry).
+func postLedgerEntry(tx *gorm.DB, entry models.LedgerEntry) (models.LedgerEntry, error) {
+	if entry.EntryDate.IsZero() {
+		entry.EntryDate = time.Now()
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return entry, fmt.Errorf("не удалось записать проводку: %w", err)
+	}
+	return entry, nil
+}
+
+// ledgerActiveSQL отбирает проводки, которые не были сторнированы.
+const ledgerActiveSQL = "NOT EXISTS (SELECT 1 FROM ledger_entries r WHERE r.reverses_id = ledger_entries.id)"
+
+// recordPayment проводит поступление amount по договору: записывает проводку-оплату с реквизитами
+// из payment, распределяет ее по графику и выдает квитанцию (replacesID - заменяемая квитанция).
+// Общая точка входа для ручного ввода, оплаты по договору, 1С, банковских выписок и онлайн-оплаты.
+// PDF квитанции печатается после фиксации транзакции (renderSourceReceipts).
+func recordPayment(tx *gorm.DB, payment *models.LedgerEntry, amount models.Money, replacesID, issuedByID *uint) (AllocationResult, *models.Receipt, error) {
+	if amount <= 0 {
+		return AllocationResult{}, nil, errors.New("сумма платежа должна быть больше нуля")
+	}
+	payment.EntryType = models.LedgerPayment
+	payment.Amount = -amount
+	entry, err := postLedgerEntry(tx, *payment)
+	if err != nil {
+		return AllocationResult{}, nil, err
+	}
+	*payment = entry
+	if err := refreshContractPaidAmount(tx, payment.ContractID); err != nil {
+		return AllocationResult{}, nil, err
+	}
+	allocation, err := allocatePayment(tx, payment.ContractID, payment.PayerID, amount, models.AllocationSourceLedgerEntry, payment.ID)
+	if err != nil {
+		return AllocationResult{}, nil, err
+	}
+	receipt, err := issueReceipt(tx, models.AllocationSourceLedgerEntry, payment.ID, replacesID, issuedByID)
+	if err != nil {
+		return AllocationResult{}, nil, err
+	}
+	return allocation, receipt, nil
+}
+
+// cancelPayment отменяет поступление: возвращает распределенные суммы в график, сторнирует
+// проводку-оплату и аннулирует ее квитанции. Возвращает сторно и аннулированные квитанции,
+// чтобы квитанция исправленного платежа могла сослаться на заменяемую.
+func cancelPayment(tx *gorm.DB, payment models.LedgerEntry, reason string, userID *uint) (models.LedgerEntry, []models.Receipt, error) {
+	if err := releaseAllocations(tx, models.AllocationSourceLedgerEntry, payment.ID); err != nil {
+		return models.LedgerEntry{}, nil, err
+	}
+	reversal, err := reverseLedgerEntry(tx, payment, reason, userID)
+	if err != nil {
+		return reversal, nil, err
+	}
+	annulled, err := annulReceipts(tx, models.AllocationSourceLedgerEntry, payment.ID, reason)
+	return reversal, annulled, err
+}
+
+// findActivePayment загружает действующую (не сторнированную) проводку-оплату и блокирует ее
+// до конца транзакции, чтобы параллельное исправление или отмена не провели платеж дважды.
+func findActivePayment(tx *gorm.DB, id interface{}) (models.LedgerEntry, error) {
+	var payment models.LedgerEntry
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND entry_type = ?", id, models.LedgerPayment).
+		Where(ledgerActiveSQL).
+		First(&payment).Error
+	return payment, err
+}
+
+// repointPaymentReferences переносит ссылки выписок и онлайн-оплат на проводку исправленного платежа.
+func repointPaymentReferences(tx *gorm.DB, fromID, toID uint) error {
+	if err := tx.Model(&models.BankStatementLine{}).Where("payment_entry_id = ?", fromID).
+		Update("payment_entry_id", toID).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.PaymentLink{}).Where("payment_entry_id = ?", fromID).
+		Update("payment_entry_id", toID).Error
+}
+
+// reverseLedgerEntry сторнирует проводку, если она еще не была сторнирована.
+func reverseLedgerEntry(tx *gorm.DB, original models.LedgerEntry, reason string, userID *uint) (models.LedgerEntry, error) {
+	if original.EntryType == models.LedgerReversal {
+		return models.LedgerEntry{}, errors.New("нельзя сторнировать сторно")
+	}
+	var count int64
+	if err := tx.Model(&models.LedgerEntry{}).Where("reverses_id = ?", original.ID).Count(&count).Error; err != nil {
+		return models.LedgerEntry{}, err
+	}
+	if count > 0 {
+		return models.LedgerEntry{}, errors.New("проводка уже сторнирована")
+	}
+
+	reversal, err := postLedgerEntry(tx, models.LedgerEntry{
+		ContractID:  original.ContractID,
+		EntryType:   models.LedgerReversal,
+		Amount:      -original.Amount,
+		EntryDate:   time.Now(),
+		Description: reason,
+		SourceType:  original.SourceType,
+		SourceID:    original.SourceID,
+		PayerID:     original.PayerID,
+		ReversesID:  &original.ID,
+		CreatedByID: userID,
+	})
+	if err != nil {
+		return reversal, err
+	}
+	return reversal, refreshContractPaidAmount(tx, original.ContractID)
+}
+
+// ledgerTotalsByKind суммирует проводки договора по видам. Сторно относится к тому же виду,
+// что и отменяемая им проводка. Если sourceType не пуст, учитываются только проводки этого источника.
+func ledgerTotalsByKind(tx *gorm.DB, contractID uint, sourceType string) (map[string]models.Money, error) {
+	var rows []struct {
+		Kind  string
+		Total models.Money
+	}
+	query := tx.Table("ledger_entries e").
+		Select("COALESCE(o.entry_type, e.entry_type) AS kind, SUM(e.amount) AS total").
+		Joins("LEFT JOIN ledger_entries o ON e.reverses_id = o.id").
+		Where("e.contract_id = ?", contractID).
+		Group("kind")
+	if sourceType != "" {
+		query = query.Where("e.source_type = ?", sourceType)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	totals := make(map[string]models.Money, len(rows))
+	for _, r := range rows {
+		totals[r.Kind] = r.Total
+	}
+	return totals, nil
+}
+
+// syncContractCharges приводит проводки цены договора в соответствие с TotalAmount и DiscountedAmount.
+// Вызывается при создании договора и при каждом изменении его суммы или скидки:
+// разница отражается новыми проводками, существующие не изменяются.
+func syncContractCharges(tx *gorm.DB, contract *models.Contract) error {
+	totals, err := ledgerTotalsByKind(tx, contract.ID, models.LedgerSourceContract)
+	if err != nil {
+		return err
+	}
+	_, hasCharge := totals[models.LedgerCharge]
+	gross := totals[models.LedgerCharge] + totals[models.LedgerAdjustment]
+	discount := -totals[models.LedgerDiscount]
+	sourceID := contract.ID
+
+	if delta := contract.TotalAmount - gross; delta != 0 {
+		entry := models.LedgerEntry{
+			ContractID:  contract.ID,
+			EntryType:   models.LedgerAdjustment,
+			Amount:      delta,
+			Description: "Изменение стоимости договора",
+			SourceType:  models.LedgerSourceContract,
+			SourceID:    &sourceID,
+		}
+		if !hasCharge {
+			entry.EntryType = models.LedgerCharge
+			entry.Description = "Начисление по договору " + contract.ContractNumber
+			if contract.StartDate != nil {
+				entry.EntryDate = *contract.StartDate
+			}
+		}
+		if _, err := postLedgerEntry(tx, entry); err != nil {
+			return err
+		}
+	}
+
+	targetDiscount := contract.TotalAmount - contract.DiscountedAmount
+	if delta := targetDiscount - discount; delta != 0 {
+		// Положительная сумма проводки-скидки означает уменьшение ранее предоставленной скидки.
+		if _, err := postLedgerEntry(tx, models.LedgerEntry{
+			ContractID:  contract.ID,
+			EntryType:   models.LedgerDiscount,
+			Amount:      -delta,
+			Description: fmt.Sprintf("Скидка %.2f%%", contract.DiscountPercentage),
+			SourceType:  models.LedgerSourceContract,
+			SourceID:    &sourceID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getContractBalance вычисляет показатели договора по журналу расчетов.
+func getContractBalance(tx *gorm.DB, contractID uint) (ContractBalance, error) {
+	balance := ContractBalance{ContractID: contractID}
+	totals, err := ledgerTotalsByKind(tx, contractID, "")
+	if err != nil {
+		return balance, err
+	}
+	balance.Charged = totals[models.LedgerCharge]
+	balance.Discounts = -totals[models.LedgerDiscount]
+	balance.Paid = -totals[models.LedgerPayment]
+	balance.Refunded = totals[models.LedgerRefund]
+	balance.Adjustments = totals[models.LedgerAdjustment]
+	for _, v := range totals {
+		balance.Balance += v
+	}
+	return balance, nil
+}
+
+// refreshContractPaidAmount пересчитывает кэшированное поле contracts.paid_amount из журнала.
+func refreshContractPaidAmount(tx *gorm.DB, contractID uint) error {
+	balance, err := getContractBalance(tx, contractID)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.Contract{}).Where("id = ?", contractID).
+		Update("paid_amount", balance.Paid-balance.Refunded).Error
+}
+
+// --- Обработчики ---
+
+// GetContractBalanceHandler возвращает баланс договора, рассчитанный по журналу расчетов.
+func GetContractBalanceHandler(c *gin.Context) {
+	contractID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID договора"})
+		return
+	}
+	balance, err := getContractBalance(config.DB, uint(contractID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось рассчитать баланс договора"})
+		return
+	}
+	c.JSON(http.StatusOK, balance)
+}
+
+// ListContractLedgerHandler возвращает все проводки договора в хронологическом порядке.
+func ListContractLedgerHandler(c *gin.Context) {
+	var entries []models.LedgerEntry
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).
+		Order("entry_date ASC, id ASC").
+		Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось получить журнал расчетов"})
+		return
+	}
+	if entries == nil {
+		entries = make([]models.LedgerEntry, 0)
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// LedgerEntryInput - ручная проводка (корректировка, скидка или возврат).
+type LedgerEntryInput struct {
+	EntryType   string       `json:"entryType" binding:"required"`
+	Amount      models.Money `json:"amount" binding:"required"`
+	EntryDate   string       `json:"entryDate"`
+	Description string       `json:"description" binding:"required"`
+}
+
+// CreateLedgerEntryHandler добавляет ручную проводку.
+// Для скидки и возврата передается положительная сумма, для корректировки - со знаком.
+// Оплаты вносятся только через платежи (payment-facts, payments/actual, 1С).
+func CreateLedgerEntryHandler(c *gin.Context) {
+	contractID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID договора"})
+		return
+	}
+
+	var input LedgerEntryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+
+	amount := input.Amount
+	switch input.EntryType {
+	case models.LedgerDiscount:
+		amount = -amount
+	case models.LedgerRefund, models.LedgerAdjustment:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Вручную можно добавить только скидку, возврат или корректировку"})
+		return
+	}
+
+	entryDate := time.Now()
+	if input.EntryDate != "" {
+		if entryDate, err = time.Parse("2006-01-02", input.EntryDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Используйте YYYY-MM-DD."})
+			return
+		}
+	}
+
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+
+	var entry models.LedgerEntry
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&models.Contract{}, contractID).Error; err != nil {
+			return err
+		}
+		var err error
+		entry, err = postLedgerEntry(tx, models.LedgerEntry{
+			ContractID:  uint(contractID),
+			EntryType:   input.EntryType,
+			Amount:      amount,
+			EntryDate:   entryDate,
+			Description: input.Description,
+			CreatedByID: userID,
+		})
+		if err != nil {
+			return err
+		}
+		return refreshContractPaidAmount(tx, uint(contractID))
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось добавить проводку: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+// ReverseLedgerEntryHandler сторнирует проводку договора. Сторно оплаты отменяет и ее
+// распределение по графику, и квитанции.
+func ReverseLedgerEntryHandler(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите причину сторно"})
+		return
+	}
+
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+
+	var reversal models.LedgerEntry
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var original models.LedgerEntry
+		if err := tx.Where("id = ? AND contract_id = ?", c.Param("entryId"), c.Param("id")).First(&original).Error; err != nil {
+			return err
+		}
+		var err error
+		if original.EntryType == models.LedgerPayment {
+			reversal, _, err = cancelPayment(tx, original, input.Reason, userID)
+			return err
+		}
+		reversal, err = reverseLedgerEntry(tx, original, input.Reason, userID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Проводка не найдена"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, reversal)
+}
@@ -69,6 +69,12 @@ func CreateNewsPostHandler(c *gin.Context) {
 		post.Content = contentValues[0]
 	}
 
+	// Пост для родителей виден и в личном кабинете родителя
+	post.Audience = models.NewsAudienceStaff
+	if audienceValues := form.Value["audience"]; len(audienceValues) > 0 && audienceValues[0] == models.NewsAudienceParents {
+		post.Audience = models.NewsAudienceParents
+	}
+
 	// Логика для опросов
 	if post.Type == "poll" {
 		pollQuestionValues := form.Value["poll_question"]
@@ -254,9 +260,10 @@ func VoteInPollHandler(c *gin.Context) {
 		return
 	}
 
+	voterID := userID.(uint)
 	vote := models.PollVote{
 		PollOptionID: uint(optionID),
-		UserID:       userID.(uint),
+		UserID:       &voterID,
 	}
 	if err := config.DB.Create(&vote).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cast vote"})
@@ -0,0 +1,357 @@
+// prometheus-crm/internal/handlers/parent_account_handler.go
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ParentAccountInput - данные учетной записи родителя, которые заполняет сотрудник.
+// StudentIDs == nil при создании означает автоматический подбор детей по ИИН и телефону,
+// при изменении - что список детей не меняется.
+type ParentAccountInput struct {
+	FullName   string  `json:"fullName"`
+	IIN        string  `json:"iin"`
+	Phone      string  `json:"phone"`
+	Email      string  `json:"email"`
+	Password   string  `json:"password"`
+	Status     string  `json:"status"`
+	StudentIDs *[]uint `json:"studentIds"`
+}
+
+// parentPasswordAlphabet - символы временного пароля без похожих друг на друга 0/O, 1/l/I.
+const parentPasswordAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// generateParentPassword создает временный пароль, который сотрудник передает родителю.
+func generateParentPassword() (string, error) {
+	b := make([]byte, 8)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(parentPasswordAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = parentPasswordAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// normalizedPhoneSQL - выражение SQL, приводящее номер из колонки к виду normalizePhone.
+func normalizedPhoneSQL(column string) string {
+	digits := "REGEXP_REPLACE(COALESCE(" + column + ", ''), '[^0-9]', '', 'g')"
+	return "(CASE WHEN LENGTH(" + digits + ") = 11 AND LEFT(" + digits + ", 1) = '8' THEN '7' || SUBSTR(" + digits + ", 2) ELSE " + digits + " END)"
+}
+
+// matchParentStudents подбирает учеников, у которых родитель указан законным представителем
+// по договору (ИИН) или чей телефон совпадает с телефоном представителя, матери или отца.
+func matchParentStudents(tx *gorm.DB, iin, phone string) ([]uint, error) {
+	ids := make([]uint, 0)
+	if iin == "" && phone == "" {
+		return ids, nil
+	}
+	query := tx.Model(&models.Student{})
+	conditions := tx.Where("1 = 0")
+	if iin != "" {
+		conditions = conditions.Or("contract_parent_iin = ?", iin)
+	}
+	if phone != "" {
+		conditions = conditions.
+			Or(normalizedPhoneSQL("contract_parent_phone")+" = ?", phone).
+			Or(normalizedPhoneSQL("mothers_phone")+" = ?", phone).
+			Or(normalizedPhoneSQL("fathers_phone")+" = ?", phone)
+	}
+	err := query.Where(conditions).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// validateParentAccount нормализует логины и проверяет, что они не заняты другой учетной записью.
+func validateParentAccount(tx *gorm.DB, account *models.ParentAccount) error {
+	account.IIN = strings.TrimSpace(account.IIN)
+	account.Phone = normalizePhone(account.Phone)
+	if account.IIN != "" && (len(account.IIN) != 12 || normalizePhone(account.IIN) != account.IIN) {
+		return errors.New("ИИН должен состоять из 12 цифр")
+	}
+	if account.Phone != "" && len(account.Phone) != 11 {
+		return errors.New("Телефон должен содержать 11 цифр, например +7 701 123 45 67")
+	}
+	if account.IIN == "" && account.Phone == "" {
+		return errors.New("Укажите ИИН или телефон родителя - они используются для входа")
+	}
+
+	var count int64
+	query := tx.Model(&models.ParentAccount{}).Where("id <> ?", account.ID)
+	conditions := tx.Where("1 = 0")
+	if account.IIN != "" {
+		conditions = conditions.Or("iin = ?", account.IIN)
+	}
+	if account.Phone != "" {
+		conditions = conditions.Or("phone = ?", account.Phone)
+	}
+	if err := query.Where(conditions).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("Учетная запись с таким ИИН или телефоном уже существует")
+	}
+	return nil
+}
+
+// loadParentAccountWithStudents загружает учетную запись с привязанными детьми для ответа API.
+func loadParentAccountWithStudents(tx *gorm.DB, id uint) (models.ParentAccount, error) {
+	var account models.ParentAccount
+	err := tx.Preload("Students", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, last_name, first_name, middle_name, contract_parent_iin, contract_parent_phone").
+			Order("last_name, first_name")
+	}).First(&account, id).Error
+	return account, err
+}
+
+// ListParentAccountsHandler возвращает учетные записи родителей с поиском по ФИО, ИИН и телефону.
+func ListParentAccountsHandler(c *gin.Context) {
+	query := config.DB.Model(&models.ParentAccount{})
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		pattern := "%" + strings.ToLower(search) + "%"
+		conditions := config.DB.Where("LOWER(full_name) LIKE ? OR iin LIKE ? OR LOWER(email) LIKE ?", pattern, pattern, pattern)
+		if digits := normalizePhone(search); digits != "" {
+			conditions = conditions.Or("phone LIKE ?", "%"+digits+"%")
+		}
+		query = query.Where(conditions)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать учетные записи родителей"})
+		return
+	}
+
+	accounts := make([]models.ParentAccount, 0)
+	if err := query.Scopes(Paginate(c)).
+		Preload("Students", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, last_name, first_name, middle_name")
+		}).
+		Order("full_name, id").
+		Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось получить учетные записи родителей"})
+		return
+	}
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, accounts, total))
+}
+
+// CreateParentAccountHandler создает учетную запись родителя. Если пароль не задан, генерирует
+// временный и возвращает его один раз в ответе; если дети не указаны, подбирает их по ИИН и телефону.
+func CreateParentAccountHandler(c *gin.Context) {
+	var input ParentAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account := models.ParentAccount{
+		FullName: strings.TrimSpace(input.FullName),
+		IIN:      input.IIN,
+		Phone:    input.Phone,
+		Email:    strings.TrimSpace(input.Email),
+		Status:   models.ParentAccountActive,
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		account.CreatedByID = &userID
+	}
+
+	password := input.Password
+	generated := password == ""
+	if generated {
+		var err error
+		if password, err = generateParentPassword(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось создать пароль"})
+			return
+		}
+	} else if len(password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Пароль должен быть не короче 6 символов"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	account.Password = string(hash)
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateParentAccount(tx, &account); err != nil {
+			return err
+		}
+		var studentIDs []uint
+		if input.StudentIDs != nil {
+			studentIDs = *input.StudentIDs
+		} else if studentIDs, err = matchParentStudents(tx, account.IIN, account.Phone); err != nil {
+			return err
+		}
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		return replaceParentStudents(tx, &account, studentIDs)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, _ = loadParentAccountWithStudents(config.DB, account.ID)
+	response := gin.H{"account": account}
+	if generated {
+		response["password"] = password
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// replaceParentStudents заменяет список детей учетной записи.
+func replaceParentStudents(tx *gorm.DB, account *models.ParentAccount, studentIDs []uint) error {
+	students := make([]models.Student, 0, len(studentIDs))
+	if len(studentIDs) > 0 {
+		if err := tx.Select("id").Where("id IN ?", studentIDs).Find(&students).Error; err != nil {
+			return err
+		}
+		if len(students) != len(uniqueUints(studentIDs)) {
+			return errors.New("Часть указанных учеников не найдена")
+		}
+	}
+	return tx.Model(account).Association("Students").Replace(students)
+}
+
+// uniqueUints возвращает значения без повторов.
+func uniqueUints(values []uint) []uint {
+	seen := make(map[uint]bool, len(values))
+	result := make([]uint, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// UpdateParentAccountHandler изменяет данные, статус, пароль и список детей учетной записи родителя.
+func UpdateParentAccountHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID"})
+		return
+	}
+	var input ParentAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Status != "" && input.Status != models.ParentAccountActive && input.Status != models.ParentAccountBlocked {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Недопустимый статус"})
+		return
+	}
+	if input.Password != "" && len(input.Password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Пароль должен быть не короче 6 символов"})
+		return
+	}
+
+	var account models.ParentAccount
+	if err := config.DB.First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учетная запись не найдена"})
+		return
+	}
+	account.FullName = strings.TrimSpace(input.FullName)
+	account.IIN = input.IIN
+	account.Phone = input.Phone
+	account.Email = strings.TrimSpace(input.Email)
+	if input.Status != "" {
+		account.Status = input.Status
+	}
+	if input.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		account.Password = string(hash)
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateParentAccount(tx, &account); err != nil {
+			return err
+		}
+		if err := tx.Omit("Students").Save(&account).Error; err != nil {
+			return err
+		}
+		if input.StudentIDs != nil {
+			return replaceParentStudents(tx, &account, *input.StudentIDs)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, _ = loadParentAccountWithStudents(config.DB, account.ID)
+	c.JSON(http.StatusOK, account)
+}
+
+// MatchParentAccountStudentsHandler добавляет к учетной записи найденных по ИИН и телефону детей,
+// например после зачисления младшего ребенка. Уже привязанные дети сохраняются.
+func MatchParentAccountStudentsHandler(c *gin.Context) {
+	var account models.ParentAccount
+	if err := config.DB.Preload("Students").First(&account, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учетная запись не найдена"})
+		return
+	}
+	matched, err := matchParentStudents(config.DB, account.IIN, account.Phone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось подобрать учеников"})
+		return
+	}
+
+	linked := make(map[uint]bool, len(account.Students))
+	for _, s := range account.Students {
+		linked[s.ID] = true
+	}
+	added := make([]models.Student, 0)
+	for _, studentID := range matched {
+		if !linked[studentID] {
+			added = append(added, models.Student{Model: gorm.Model{ID: studentID}})
+		}
+	}
+	if len(added) > 0 {
+		if err := config.DB.Model(&account).Association("Students").Append(added); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось привязать учеников"})
+			return
+		}
+	}
+
+	account, _ = loadParentAccountWithStudents(config.DB, account.ID)
+	c.JSON(http.StatusOK, gin.H{"added": len(added), "account": account})
+}
+
+// DeleteParentAccountHandler удаляет учетную запись родителя; его голоса в опросах сохраняются.
+func DeleteParentAccountHandler(c *gin.Context) {
+	result := config.DB.Delete(&models.ParentAccount{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить учетную запись"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учетная запись не найдена"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Учетная запись родителя удалена"})
+}
@@ -0,0 +1,535 @@
+// prometheus-crm/internal/handlers/parent_portal_handler.go
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"prometheus-crm/config"
+	"prometheus-crm/internal/middleware"
+	"prometheus-crm/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Личный кабинет родителя. Все обработчики этого файла работают под ParentAuthMiddleware
+// и видят только договоры детей, привязанных к учетной записи (parent_account_students).
+// Чужой договор, квитанция или опрос не для родителей отдаются как 404, чтобы не раскрывать,
+// существует ли запись.
+
+// ParentLoginInput - данные формы входа родителя: ИИН или номер телефона и пароль.
+type ParentLoginInput struct {
+	Login    string `json:"login" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ParentChild - ребенок в личном кабинете родителя.
+type ParentChild struct {
+	ID         uint   `json:"id"`
+	LastName   string `json:"lastName"`
+	FirstName  string `json:"firstName"`
+	MiddleName string `json:"middleName"`
+	GradeName  string `json:"gradeName"`
+}
+
+// ParentContract - договор ребенка с итогами по журналу расчетов.
+type ParentContract struct {
+	ID               uint         `json:"id"`
+	ContractNumber   string       `json:"contractNumber"`
+	StudentID        uint         `json:"studentId"`
+	StudentName      string       `json:"studentName"`
+	AcademicYear     string       `json:"academicYear"`
+	StartDate        *time.Time   `json:"startDate,omitempty"`
+	EndDate          *time.Time   `json:"endDate,omitempty"`
+	DiscountedAmount models.Money `json:"discountedAmount"`
+	Paid             models.Money `json:"paid"`
+	Balance          models.Money `json:"balance"`
+	Overdue          models.Money `json:"overdue"`
+	TerminatedAt     *time.Time   `json:"terminatedAt,omitempty"`
+	HasPDF           bool         `json:"hasPdf"`
+}
+
+// ParentScheduleRow - строка графика платежей со статусом для родителя.
+type ParentScheduleRow struct {
+	ID            uint         `json:"id"`
+	PaymentName   string       `json:"paymentName"`
+	PaymentDate   time.Time    `json:"paymentDate"`
+	PlannedAmount models.Money `json:"plannedAmount"`
+	PaidAmount    models.Money `json:"paidAmount"`
+	Status        string       `json:"status"`
+	Overdue       bool         `json:"overdue"`
+	// PaymentURL - действующая ссылка на онлайн-оплату строки, если школа ее выдала.
+	PaymentURL string `json:"paymentUrl,omitempty"`
+}
+
+// ParentPayment - поступление по договору и выданная на него квитанция.
+type ParentPayment struct {
+	SourceType    string       `json:"sourceType"`
+	SourceID      uint         `json:"sourceId"`
+	PaymentDate   time.Time    `json:"paymentDate"`
+	Amount        models.Money `json:"amount"`
+	PaymentName   string       `json:"paymentName"`
+	PaymentMethod string       `json:"paymentMethod"`
+	ReceiptID     *uint        `json:"receiptId,omitempty"`
+	ReceiptNumber string       `json:"receiptNumber,omitempty"`
+}
+
+// ParentPollOption - вариант опроса без списка проголосовавших: родитель видит только итоги.
+type ParentPollOption struct {
+	ID    uint   `json:"id"`
+	Text  string `json:"text"`
+	Votes int64  `json:"votes"`
+	Voted bool   `json:"voted"`
+}
+
+// ParentNewsPost - пост или опрос, адресованный родителям.
+type ParentNewsPost struct {
+	ID           uint                  `json:"id"`
+	CreatedAt    time.Time             `json:"createdAt"`
+	AuthorName   string                `json:"authorName"`
+	Type         string                `json:"type"`
+	Content      string                `json:"content"`
+	PollQuestion string                `json:"pollQuestion,omitempty"`
+	PollOptions  []ParentPollOption    `json:"pollOptions,omitempty"`
+	Voted        bool                  `json:"voted"`
+	Files        []models.NewsPostFile `json:"files,omitempty"`
+}
+
+var (
+	// errParentAccessDenied - запрошенная запись не относится к детям родителя.
+	errParentAccessDenied = errors.New("запись не найдена")
+	// errAlreadyVoted - родитель уже голосовал в опросе.
+	errAlreadyVoted = errors.New("уже проголосовал")
+)
+
+// parentIDFromContext возвращает ID учетной записи родителя, установленный ParentAuthMiddleware.
+func parentIDFromContext(c *gin.Context) uint {
+	id, _ := c.Get("parent_id")
+	parentID, _ := id.(uint)
+	return parentID
+}
+
+// parentStudentIDs возвращает детей, привязанных к учетной записи родителя.
+func parentStudentIDs(tx *gorm.DB, parentID uint) ([]uint, error) {
+	ids := make([]uint, 0)
+	err := tx.Table("parent_account_students pas").
+		Joins("JOIN students s ON s.id = pas.student_id AND s.deleted_at IS NULL").
+		Where("pas.parent_account_id = ?", parentID).
+		Pluck("pas.student_id", &ids).Error
+	return ids, err
+}
+
+// loadParentContract загружает договор, если он заключен на одного из детей родителя.
+func loadParentContract(tx *gorm.DB, parentID uint, contractID string) (*models.Contract, error) {
+	studentIDs, err := parentStudentIDs(tx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(studentIDs) == 0 {
+		return nil, errParentAccessDenied
+	}
+	var contract models.Contract
+	if err := tx.Where("id = ? AND student_id IN ?", contractID, studentIDs).First(&contract).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errParentAccessDenied
+		}
+		return nil, err
+	}
+	return &contract, nil
+}
+
+// respondParentContractError отвечает на ошибку loadParentContract.
+func respondParentContractError(c *gin.Context, err error) {
+	if errors.Is(err, errParentAccessDenied) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить договор"})
+}
+
+// findParentAccountByLogin ищет учетную запись по ИИН (12 цифр)
+// или по номеру телефона в любом формате.
+func findParentAccountByLogin(tx *gorm.DB, login string) (*models.ParentAccount, error) {
+	digits := normalizePhone(login)
+	if digits == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	query := tx.Where("phone = ?", digits)
+	if len(digits) == 12 {
+		query = tx.Where("iin = ?", digits)
+	}
+	var account models.ParentAccount
+	if err := query.First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// ShowParentPortalPage отдает страницу личного кабинета родителя (вход и кабинет в одном шаблоне).
+func ShowParentPortalPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "parent_portal.html", gin.H{})
+}
+
+// ParentLoginHandler выполняет вход родителя и выдает токен с claim kind=parent
+// в отдельной cookie parent_token.
+func ParentLoginHandler(c *gin.Context) {
+	var input ParentLoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите ИИН или телефон и пароль"})
+		return
+	}
+
+	account, err := findParentAccountByLogin(config.DB, input.Login)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(input.Password)) != nil {
+		slog.Warn("Неудачная попытка входа в личный кабинет родителя", "login", input.Login, "ip_address", c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный логин или пароль"})
+		return
+	}
+	if account.Status != models.ParentAccountActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Доступ в личный кабинет заблокирован. Обратитесь в школу."})
+		return
+	}
+
+	now := time.Now()
+	config.DB.Model(account).Update("last_login_at", now)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"parent_id": account.ID,
+		"kind":      middleware.ParentTokenKind,
+		"exp":       now.Add(24 * time.Hour).Unix(),
+	})
+	tokenString, err := token.SignedString(config.JwtKey)
+	if err != nil {
+		slog.Error("Не удалось создать токен родителя", "error", err, "parent_id", account.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	slog.Info("Родитель вошел в личный кабинет", "parent_id", account.ID)
+	c.SetCookie(middleware.ParentTokenCookie, tokenString, 3600*24, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+}
+
+// ParentLogoutHandler завершает сессию родителя.
+func ParentLogoutHandler(c *gin.Context) {
+	c.SetCookie(middleware.ParentTokenCookie, "", -1, "/", "", false, true)
+	c.Redirect(http.StatusFound, "/parent")
+}
+
+// GetParentProfileHandler возвращает данные родителя и список его детей.
+func GetParentProfileHandler(c *gin.Context) {
+	parentID := parentIDFromContext(c)
+	var account models.ParentAccount
+	if err := config.DB.First(&account, parentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Учетная запись не найдена"})
+		return
+	}
+
+	children := make([]ParentChild, 0)
+	if err := config.DB.Table("parent_account_students pas").
+		Joins("JOIN students s ON s.id = pas.student_id AND s.deleted_at IS NULL").
+		Joins("LEFT JOIN classes cl ON cl.id = s.class_id").
+		Joins("LEFT JOIN class_liters clit ON clit.id = cl.liter_id").
+		Where("pas.parent_account_id = ?", parentID).
+		Select("s.id, s.last_name, s.first_name, COALESCE(s.middle_name, '') AS middle_name, " +
+			"TRIM(COALESCE(cl.grade_number::text, '') || ' ' || COALESCE(clit.liter_char, '')) AS grade_name").
+		Order("s.last_name, s.first_name").
+		Scan(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить список детей"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"fullName": account.FullName,
+		"iin":      account.IIN,
+		"phone":    account.Phone,
+		"email":    account.Email,
+		"children": children,
+	})
+}
+
+// ListParentContractsHandler возвращает договоры детей родителя с оплаченной суммой,
+// остатком долга и просрочкой по графику.
+func ListParentContractsHandler(c *gin.Context) {
+	studentIDs, err := parentStudentIDs(config.DB, parentIDFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить договоры"})
+		return
+	}
+	result := make([]ParentContract, 0)
+	if len(studentIDs) == 0 {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	var rows []struct {
+		ParentContract
+		PDFPath string
+	}
+	today := dateOnly(time.Now())
+	if err := config.DB.Table("contracts").
+		Joins("JOIN students s ON s.id = contracts.student_id").
+		Joins("LEFT JOIN academic_years ay ON ay.id = contracts.academic_year_id").
+		Where("contracts.deleted_at IS NULL AND contracts.student_id IN ?", studentIDs).
+		Select(`contracts.id, contracts.contract_number, contracts.student_id,
+			TRIM(COALESCE(s.last_name, '') || ' ' || COALESCE(s.first_name, '')) AS student_name,
+			COALESCE(ay.name, '') AS academic_year, contracts.start_date, contracts.end_date,
+			contracts.discounted_amount, contracts.paid_amount AS paid, `+ledgerBalanceSQL+` AS balance,
+			COALESCE((SELECT SUM(pp.planned_amount - pp.paid_amount) FROM planned_payments pp
+				WHERE pp.contract_id = contracts.id AND pp.deleted_at IS NULL
+				AND pp.paid_amount < pp.planned_amount AND pp.payment_date < ?), 0) AS overdue,
+			contracts.terminated_at, contracts.pdf_path`, today).
+		Order("contracts.start_date DESC NULLS LAST, contracts.id DESC").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить договоры"})
+		return
+	}
+	for _, row := range rows {
+		row.ParentContract.HasPDF = row.PDFPath != "" && fileExists(row.PDFPath)
+		result = append(result, row.ParentContract)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// DownloadParentContractHandler отдает PDF договора ребенка.
+func DownloadParentContractHandler(c *gin.Context) {
+	contract, err := loadParentContract(config.DB, parentIDFromContext(c), c.Param("id"))
+	if err != nil {
+		respondParentContractError(c, err)
+		return
+	}
+	if contract.PDFFilePath == "" || !fileExists(contract.PDFFilePath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "PDF договора еще не сформирован"})
+		return
+	}
+	data, err := os.ReadFile(contract.PDFFilePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось прочитать PDF"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+contract.ContractNumber+".pdf")
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// GetParentContractScheduleHandler возвращает график платежей договора со статусами строк.
+func GetParentContractScheduleHandler(c *gin.Context) {
+	contract, err := loadParentContract(config.DB, parentIDFromContext(c), c.Param("id"))
+	if err != nil {
+		respondParentContractError(c, err)
+		return
+	}
+
+	var planned []models.PlannedPayment
+	if err := config.DB.Where("contract_id = ?", contract.ID).Order("payment_date ASC, id ASC").Find(&planned).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить график платежей"})
+		return
+	}
+
+	// Действующие ссылки на оплату по строкам графика
+	var links []models.PaymentLink
+	config.DB.Where("contract_id = ? AND status = ? AND planned_payment_id IS NOT NULL AND expires_at > ?",
+		contract.ID, models.PaymentLinkPending, time.Now()).
+		Order("id DESC").Find(&links)
+	linkByRow := make(map[uint]string, len(links))
+	for _, link := range links {
+		if _, ok := linkByRow[*link.PlannedPaymentID]; !ok {
+			linkByRow[*link.PlannedPaymentID] = "/pay/" + link.Token
+		}
+	}
+
+	today := dateOnly(time.Now())
+	rows := make([]ParentScheduleRow, 0, len(planned))
+	for _, p := range planned {
+		status := p.Status
+		if status == "" {
+			status = plannedPaymentStatus(p.PlannedAmount, p.PaidAmount)
+		}
+		rows = append(rows, ParentScheduleRow{
+			ID:            p.ID,
+			PaymentName:   p.PaymentName,
+			PaymentDate:   p.PaymentDate,
+			PlannedAmount: p.PlannedAmount,
+			PaidAmount:    p.PaidAmount,
+			Status:        status,
+			Overdue:       p.PaidAmount < p.PlannedAmount && p.PaymentDate.Before(today),
+			PaymentURL:    linkByRow[p.ID],
+		})
+	}
+	c.JSON(http.StatusOK, rows)
+}
+
+// ListParentContractPaymentsHandler возвращает историю поступлений по договору (действующие
+// оплаты журнала расчетов) с номерами действующих квитанций.
+func ListParentContractPaymentsHandler(c *gin.Context) {
+	contract, err := loadParentContract(config.DB, parentIDFromContext(c), c.Param("id"))
+	if err != nil {
+		respondParentContractError(c, err)
+		return
+	}
+
+	payments := make([]ParentPayment, 0)
+	if err := config.DB.Raw(`
+		SELECT ? AS source_type, le.id AS source_id, le.entry_date AS payment_date, -le.amount AS amount,
+			COALESCE(le.description, '') AS payment_name, COALESCE(le.payment_method, '') AS payment_method,
+			r.id AS receipt_id, COALESCE(r.number, '') AS receipt_number
+		FROM ledger_entries le
+		LEFT JOIN receipts r ON r.source_type = ? AND r.source_id = le.id
+			AND r.status = ? AND r.deleted_at IS NULL
+		WHERE le.contract_id = ? AND le.entry_type = ?
+			AND NOT EXISTS (SELECT 1 FROM ledger_entries rev WHERE rev.reverses_id = le.id)
+		ORDER BY le.entry_date DESC, le.id DESC`,
+		models.AllocationSourceLedgerEntry, models.AllocationSourceLedgerEntry,
+		models.ReceiptStatusIssued, contract.ID, models.LedgerPayment).
+		Scan(&payments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить историю платежей"})
+		return
+	}
+	c.JSON(http.StatusOK, payments)
+}
+
+// DownloadParentReceiptHandler отдает PDF действующей квитанции по договору ребенка.
+func DownloadParentReceiptHandler(c *gin.Context) {
+	studentIDs, err := parentStudentIDs(config.DB, parentIDFromContext(c))
+	if err != nil || len(studentIDs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Квитанция не найдена"})
+		return
+	}
+
+	var receipt models.Receipt
+	if err := config.DB.
+		Joins("JOIN contracts c ON c.id = receipts.contract_id").
+		Where("receipts.id = ? AND receipts.status = ? AND c.student_id IN ?", c.Param("id"), models.ReceiptStatusIssued, studentIDs).
+		First(&receipt).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Квитанция не найдена"})
+		return
+	}
+	if !fileExists(receipt.PDFFilePath) {
+		if err := renderReceiptPDF(config.DB, &receipt); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Не удалось сформировать PDF квитанции"})
+			return
+		}
+	}
+	data, err := os.ReadFile(receipt.PDFFilePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось прочитать PDF квитанции"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=receipt_"+receipt.Number+".pdf")
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// ListParentNewsHandler возвращает посты и опросы, адресованные родителям, с итогами голосования.
+func ListParentNewsHandler(c *gin.Context) {
+	parentID := parentIDFromContext(c)
+
+	var posts []models.NewsPost
+	if err := config.DB.Preload("User").Preload("PollOptions").Preload("Files").
+		Where("audience = ?", models.NewsAudienceParents).
+		Order("created_at DESC").
+		Scopes(Paginate(c)).
+		Find(&posts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить новости"})
+		return
+	}
+
+	optionIDs := make([]uint, 0)
+	for _, post := range posts {
+		for _, opt := range post.PollOptions {
+			optionIDs = append(optionIDs, opt.ID)
+		}
+	}
+	counts := make(map[uint]int64)
+	voted := make(map[uint]bool)
+	if len(optionIDs) > 0 {
+		var rows []struct {
+			PollOptionID uint
+			Votes        int64
+			Mine         bool
+		}
+		if err := config.DB.Table("poll_votes").
+			Where("poll_option_id IN ? AND deleted_at IS NULL", optionIDs).
+			Select("poll_option_id, COUNT(*) AS votes, BOOL_OR(parent_account_id = ?) AS mine", parentID).
+			Group("poll_option_id").
+			Scan(&rows).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить итоги опросов"})
+			return
+		}
+		for _, row := range rows {
+			counts[row.PollOptionID] = row.Votes
+			voted[row.PollOptionID] = row.Mine
+		}
+	}
+
+	result := make([]ParentNewsPost, 0, len(posts))
+	for _, post := range posts {
+		item := ParentNewsPost{
+			ID:           post.ID,
+			CreatedAt:    post.CreatedAt,
+			AuthorName:   post.User.FullName,
+			Type:         post.Type,
+			Content:      post.Content,
+			PollQuestion: post.PollQuestion,
+			Files:        post.Files,
+		}
+		for _, opt := range post.PollOptions {
+			item.PollOptions = append(item.PollOptions, ParentPollOption{ID: opt.ID, Text: opt.Text, Votes: counts[opt.ID], Voted: voted[opt.ID]})
+			item.Voted = item.Voted || voted[opt.ID]
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// VoteParentPollHandler принимает голос родителя в опросе, адресованном родителям.
+// Родитель голосует один раз за опрос.
+func VoteParentPollHandler(c *gin.Context) {
+	parentID := parentIDFromContext(c)
+	optionID, err := strconv.ParseUint(c.Param("optionId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный вариант ответа"})
+		return
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var post models.NewsPost
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND audience = ? AND type = ?", c.Param("id"), models.NewsAudienceParents, "poll").
+			First(&post).Error; err != nil {
+			return errParentAccessDenied
+		}
+		var option models.PollOption
+		if err := tx.Where("id = ? AND news_post_id = ?", optionID, post.ID).First(&option).Error; err != nil {
+			return errParentAccessDenied
+		}
+
+		var existing int64
+		if err := tx.Model(&models.PollVote{}).
+			Joins("JOIN poll_options ON poll_options.id = poll_votes.poll_option_id").
+			Where("poll_options.news_post_id = ? AND poll_votes.parent_account_id = ?", post.ID, parentID).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errAlreadyVoted
+		}
+		return tx.Create(&models.PollVote{PollOptionID: option.ID, ParentAccountID: &parentID}).Error
+	})
+	switch {
+	case errors.Is(err, errParentAccessDenied):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Опрос не найден"})
+	case errors.Is(err, errAlreadyVoted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Вы уже проголосовали в этом опросе"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить голос"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Голос учтен"})
+	}
+}
@@ -0,0 +1,174 @@
+// prometheus-crm/internal/handlers/payment_allocation.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы строк графика платежей.
+const (
+	PlannedStatusPending       = "Ожидается"
+	PlannedStatusPartiallyPaid = "Частично оплачен"
+	PlannedStatusPaid          = "Оплачен"
+	PlannedStatusDiscounted    = "Скорректирован (скидка)"
+)
+
+// AllocationResult описывает результат распределения одного поступления.
+type AllocationResult struct {
+	Allocations []models.PaymentAllocation `json:"allocations"`
+	// Unallocated - остаток, который не удалось распределить (переплата по графику).
+	Unallocated models.Money `json:"unallocated"`
+}
+
+// plannedPaymentStatus вычисляет статус строки графика по плановой и оплаченной сумме.
+func plannedPaymentStatus(planned, paid models.Money) string {
+	switch {
+	case paid <= 0:
+		return PlannedStatusPending
+	case paid >= planned:
+		return PlannedStatusPaid
+	default:
+		return PlannedStatusPartiallyPaid
+	}
+}
+
+// allocatePayment распределяет поступление по самым ранним неоплаченным строкам графика договора.
+// Сумма последовательно гасит строки в порядке даты платежа; каждая затронутая строка получает
+// запись PaymentAllocation, а ее оплаченная сумма пересчитывается из распределений.
+// Вызывается только из recordPayment, поэтому распределение одинаково для всех каналов поступления.
+// Платеж с указанным плательщиком гасит сначала его строки графика, затем общие строки договора;
+// строки других плательщиков он не затрагивает.
+func allocatePayment(tx *gorm.DB, contractID uint, payerID *uint, amount models.Money, sourceType string, sourceID uint) (AllocationResult, error) {
+	result := AllocationResult{Allocations: make([]models.PaymentAllocation, 0)}
+	remaining := amount
+	if remaining <= 0 {
+		return result, nil
+	}
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("contract_id = ? AND paid_amount < planned_amount", contractID)
+	if payerID != nil {
+		query = query.Where("payer_id = ? OR payer_id IS NULL", *payerID).Order("payer_id IS NULL")
+	}
+	var rows []models.PlannedPayment
+	if err := query.Order("payment_date ASC, id ASC").Find(&rows).Error; err != nil {
+		return result, fmt.Errorf("не удалось загрузить график платежей: %w", err)
+	}
+
+	shares, unallocated := splitPaymentFIFO(rows, remaining)
+	now := time.Now()
+	for i, row := range rows {
+		applied := shares[i]
+		if applied <= 0 {
+			continue
+		}
+
+		allocation := models.PaymentAllocation{
+			ContractID:       contractID,
+			PlannedPaymentID: row.ID,
+			SourceType:       sourceType,
+			SourceID:         sourceID,
+			Amount:           applied,
+			AllocatedAt:      now,
+		}
+		if err := tx.Create(&allocation).Error; err != nil {
+			return result, fmt.Errorf("не удалось сохранить распределение: %w", err)
+		}
+		if err := refreshPlannedPaidAmount(tx, row); err != nil {
+			return result, fmt.Errorf("не удалось обновить строку графика %d: %w", row.ID, err)
+		}
+		result.Allocations = append(result.Allocations, allocation)
+	}
+
+	result.Unallocated = unallocated
+	return result, nil
+}
+
+// splitPaymentFIFO делит сумму между строками графика в переданном порядке: каждая строка
+// получает не больше своего непогашенного остатка. Возвращает долю каждой строки
+// и остаток, который не поместился в график.
+func splitPaymentFIFO(rows []models.PlannedPayment, amount models.Money) ([]models.Money, models.Money) {
+	shares := make([]models.Money, len(rows))
+	remaining := amount
+	for i, row := range rows {
+		if remaining <= 0 {
+			break
+		}
+		due := row.PlannedAmount - row.PaidAmount
+		if due <= 0 {
+			continue
+		}
+		shares[i] = models.MinMoney(due, remaining)
+		remaining -= shares[i]
+	}
+	return shares, remaining
+}
+
+// releaseAllocations отменяет распределение поступления: удаляет записи PaymentAllocation
+// и пересчитывает затронутые строки графика. Используется при исправлении и отмене платежа.
+func releaseAllocations(tx *gorm.DB, sourceType string, sourceID uint) error {
+	var allocations []models.PaymentAllocation
+	if err := tx.Where("source_type = ? AND source_id = ?", sourceType, sourceID).Find(&allocations).Error; err != nil {
+		return fmt.Errorf("не удалось загрузить распределения: %w", err)
+	}
+	if len(allocations) == 0 {
+		return nil
+	}
+	if err := tx.Where("source_type = ? AND source_id = ?", sourceType, sourceID).Delete(&models.PaymentAllocation{}).Error; err != nil {
+		return err
+	}
+
+	for _, a := range allocations {
+		var row models.PlannedPayment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, a.PlannedPaymentID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				continue // строку графика уже удалили
+			}
+			return err
+		}
+		if err := refreshPlannedPaidAmount(tx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshPlannedPaidAmount пересчитывает оплаченную сумму и статус строки графика
+// по ее распределениям. planned_payments.paid_amount нигде больше не изменяется.
+func refreshPlannedPaidAmount(tx *gorm.DB, row models.PlannedPayment) error {
+	var paid models.Money
+	if err := tx.Model(&models.PaymentAllocation{}).
+		Where("planned_payment_id = ?", row.ID).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&paid).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.PlannedPayment{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+		"paid_amount": paid,
+		"status":      plannedPaymentStatus(row.PlannedAmount, paid),
+	}).Error
+}
+
+// ListContractAllocationsHandler возвращает распределение всех поступлений договора по графику.
+func ListContractAllocationsHandler(c *gin.Context) {
+	contractID := c.Param("id")
+	var allocations []models.PaymentAllocation
+	if err := config.DB.Where("contract_id = ?", contractID).
+		Order("allocated_at ASC, id ASC").
+		Find(&allocations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось получить распределение платежей"})
+		return
+	}
+	if allocations == nil {
+		allocations = make([]models.PaymentAllocation, 0)
+	}
+	c.JSON(http.StatusOK, allocations)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"prometheus-crm/models"
+	"reflect"
+	"testing"
+)
+
+func scheduleRow(planned, paid models.Money) models.PlannedPayment {
+	return models.PlannedPayment{PlannedAmount: planned, PaidAmount: paid}
+}
+
+func TestSplitPaymentFIFO(t *testing.T) {
+	schedule := []models.PlannedPayment{
+		scheduleRow(models.Tenge(100000), models.Tenge(100000)),
+		scheduleRow(models.Tenge(100000), models.Tenge(40000)),
+		scheduleRow(models.Tenge(100000), 0),
+		scheduleRow(models.Tenge(100000), 0),
+	}
+	tenge := func(v ...int64) []models.Money {
+		out := make([]models.Money, len(v))
+		for i, x := range v {
+			out[i] = models.Tenge(x)
+		}
+		return out
+	}
+	tests := []struct {
+		name        string
+		amount      models.Money
+		shares      []models.Money
+		unallocated models.Money
+	}{
+		{"закрывает остаток частично оплаченной строки", models.Tenge(60000), tenge(0, 60000, 0, 0), 0},
+		{"делится между строками", 15000050, []models.Money{0, models.Tenge(60000), 9000050, 0}, 0},
+		{"переплата остается нераспределенной", models.Tenge(300000), tenge(0, 60000, 100000, 100000), models.Tenge(40000)},
+		{"нулевая сумма", 0, tenge(0, 0, 0, 0), 0},
+		{"тиыны не теряются", 1, []models.Money{0, 1, 0, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares, unallocated := splitPaymentFIFO(schedule, tt.amount)
+			if !reflect.DeepEqual(shares, tt.shares) || unallocated != tt.unallocated {
+				t.Fatalf("splitPaymentFIFO(%v) = %v, %v; want %v, %v", tt.amount, shares, unallocated, tt.shares, tt.unallocated)
+			}
+		})
+	}
+}
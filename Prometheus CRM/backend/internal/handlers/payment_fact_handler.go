@@ -2,9 +2,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"prometheus-crm/config"
 	"prometheus-crm/models"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,39 +17,40 @@ import (
 // PaymentFactInput - структура для приема данных от клиента.
 // Используем string для PaymentDate, чтобы избежать ошибки автоматического парсинга.
 type PaymentFactInput struct {
-	ContractID    uint    `json:"contractId"`
-	Amount        float64 `json:"amount"`
-	Commission    float64 `json:"commission"`
-	PaymentDate   string  `json:"paymentDate"`
-	AcademicYear  string  `json:"academicYear"`
-	PaymentName   string  `json:"paymentName"`
-	PaymentMethod string  `json:"paymentMethod"`
+	ContractID    uint         `json:"contractId"`
+	Amount        models.Money `json:"amount"`
+	Commission    models.Money `json:"commission"`
+	PaymentDate   string       `json:"paymentDate"`
+	AcademicYear  string       `json:"academicYear"`
+	PaymentName   string       `json:"paymentName"`
+	PaymentMethod string       `json:"paymentMethod"`
+	PayerID       *uint        `json:"payerId"`
 }
 
 // ИЗМЕНЕНИЕ: Новая структура ответа с явными полями в PascalCase для совместимости с JS.
 type PaymentFactResponse struct {
-	ID              uint      `json:"ID"`
-	ContractID      uint      `json:"ContractID"`
-	Amount          float64   `json:"Amount"`
-	Commission      float64   `json:"Commission"`
-	PaymentDate     time.Time `json:"PaymentDate"`
-	AcademicYear    string    `json:"AcademicYear"`
-	PaymentName     string    `json:"PaymentName"`
-	PaymentMethod   string    `json:"PaymentMethod"`
-	ContractNumber  string    `json:"ContractNumber"`
-	StudentFullName string    `json:"StudentFullName"`
+	ID              uint         `json:"ID"`
+	ContractID      uint         `json:"ContractID"`
+	Amount          models.Money `json:"Amount"`
+	Commission      models.Money `json:"Commission"`
+	PaymentDate     time.Time    `json:"PaymentDate"`
+	AcademicYear    string       `json:"AcademicYear"`
+	PaymentName     string       `json:"PaymentName"`
+	PaymentMethod   string       `json:"PaymentMethod"`
+	ContractNumber  string       `json:"ContractNumber"`
+	StudentFullName string       `json:"StudentFullName"`
+	PayerID         *uint        `json:"PayerID"`
+	PayerName       string       `json:"PayerName"`
 }
 
-// ListPaymentFacts возвращает список фактических платежей с пагинацией и поиском
+// ListPaymentFacts возвращает список фактических платежей с пагинацией и поиском.
+// Платежи - действующие проводки-оплаты журнала расчетов, кроме оплат по форме договора.
 func ListPaymentFacts(c *gin.Context) {
 	var results []PaymentFactResponse
 	var totalRows int64
 
 	// Базовый запрос с объединением таблиц
-	baseQuery := config.DB.Table("payment_facts pf").
-		Joins("LEFT JOIN contracts c ON pf.contract_id = c.id").
-		Joins("LEFT JOIN students s ON c.student_id = s.id").
-		Where("pf.deleted_at IS NULL")
+	baseQuery := paymentFactsQuery()
 
 	// Поиск по номеру договора, ФИО или ИИН ученика
 	searchQuery := c.Query("search")
@@ -65,17 +68,19 @@ func ListPaymentFacts(c *gin.Context) {
 	finalQuery := baseQuery.Select(`
 		pf.id AS "ID", 
 		pf.contract_id AS "ContractID",
-		pf.amount AS "Amount",
+		-pf.amount AS "Amount",
 		pf.commission AS "Commission",
-		pf.payment_date AS "PaymentDate",
+		pf.entry_date AS "PaymentDate",
 		pf.academic_year AS "AcademicYear",
-		pf.payment_name AS "PaymentName",
+		pf.description AS "PaymentName",
 		pf.payment_method AS "PaymentMethod",
 		c.contract_number AS "ContractNumber", 
-		(s.last_name || ' ' || s.first_name) as "StudentFullName"
+		(s.last_name || ' ' || s.first_name) as "StudentFullName",
+		pf.payer_id AS "PayerID",
+		COALESCE(p.name, '') AS "PayerName"
 	`).
 		Scopes(Paginate(c)).
-		Order("pf.payment_date DESC")
+		Order("pf.entry_date DESC, pf.id DESC")
 
 	if err := finalQuery.Scan(&results).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
@@ -85,27 +90,41 @@ func ListPaymentFacts(c *gin.Context) {
 	c.JSON(http.StatusOK, CreatePaginatedResponse(c, results, totalRows))
 }
 
+// paymentFactSources - каналы поступления, которые показываются и исправляются как фактические платежи.
+// Оплаты по форме договора сюда не входят: форма платежа не передает их реквизиты (форму оплаты).
+var paymentFactSources = []string{models.LedgerSourcePaymentFact, models.LedgerSourcePlannedPayment}
+
+// paymentFactsQuery - действующие фактические платежи (проводки-оплаты) с договором и учеником.
+func paymentFactsQuery() *gorm.DB {
+	return config.DB.Table("ledger_entries pf").
+		Joins("LEFT JOIN contracts c ON pf.contract_id = c.id").
+		Joins("LEFT JOIN students s ON c.student_id = s.id").
+		Joins("LEFT JOIN contract_payers p ON pf.payer_id = p.id").
+		Where("pf.entry_type = ? AND pf.source_type IN ?", models.LedgerPayment, paymentFactSources).
+		Where("NOT EXISTS (SELECT 1 FROM ledger_entries r WHERE r.reverses_id = pf.id)")
+}
+
 // GetPaymentFact возвращает один платеж по ID
 func GetPaymentFact(c *gin.Context) {
 	id := c.Param("id")
 	var result PaymentFactResponse
 
 	// ИЗМЕНЕНИЕ: Выбираем поля с псевдонимами в PascalCase
-	if err := config.DB.Table("payment_facts pf").
-		Joins("LEFT JOIN contracts c ON pf.contract_id = c.id").
-		Joins("LEFT JOIN students s ON c.student_id = s.id").
-		Where("pf.id = ? AND pf.deleted_at IS NULL", id).
+	if err := paymentFactsQuery().
+		Where("pf.id = ?", id).
 		Select(`
 			pf.id AS "ID", 
 			pf.contract_id AS "ContractID",
-			pf.amount AS "Amount",
+			-pf.amount AS "Amount",
 			pf.commission AS "Commission",
-			pf.payment_date AS "PaymentDate",
+			pf.entry_date AS "PaymentDate",
 			pf.academic_year AS "AcademicYear",
-			pf.payment_name AS "PaymentName",
+			pf.description AS "PaymentName",
 			pf.payment_method AS "PaymentMethod",
 			c.contract_number AS "ContractNumber", 
-			(s.last_name || ' ' || s.first_name) as "StudentFullName"
+			(s.last_name || ' ' || s.first_name) as "StudentFullName",
+			pf.payer_id AS "PayerID",
+			COALESCE(p.name, '') AS "PayerName"
 		`).
 		First(&result).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -119,7 +138,7 @@ func GetPaymentFact(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
-// CreatePaymentFact создает новый фактический платеж
+// CreatePaymentFact создает новый фактический платеж: проводку-оплату в журнале расчетов.
 func CreatePaymentFact(c *gin.Context) {
 	var input PaymentFactInput
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -133,74 +152,142 @@ func CreatePaymentFact(c *gin.Context) {
 		return
 	}
 
-	paymentDate, err := time.Parse("2006-01-02", input.PaymentDate)
+	payment, err := paymentEntryFromInput(input)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	payment := models.PaymentFact{
-		ContractID:    input.ContractID,
-		Amount:        input.Amount,
-		Commission:    input.Commission,
-		PaymentDate:   paymentDate,
-		AcademicYear:  input.AcademicYear,
-		PaymentName:   input.PaymentName,
-		PaymentMethod: input.PaymentMethod,
+	if err := checkContractPayer(config.DB, payment.ContractID, payment.PayerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		payment.CreatedByID = &userID
 	}
 
-	if err := config.DB.Create(&payment).Error; err != nil {
+	// Платеж и его распределение по графику сохраняем в одной транзакции.
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		_, _, err := recordPayment(tx, &payment, input.Amount, nil, nil)
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
 		return
 	}
+	go renderSourceReceipts(models.AllocationSourceLedgerEntry, payment.ID)
 	c.JSON(http.StatusCreated, payment)
 }
 
-// UpdatePaymentFact обновляет существующий платеж
-func UpdatePaymentFact(c *gin.Context) {
-	id := c.Param("id")
-	var payment models.PaymentFact
-	if err := config.DB.First(&payment, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
-		return
+// paymentEntryFromInput проверяет форму фактического платежа и заполняет реквизиты проводки-оплаты.
+func paymentEntryFromInput(input PaymentFactInput) (models.LedgerEntry, error) {
+	if input.Amount <= 0 {
+		return models.LedgerEntry{}, errors.New("Сумма платежа должна быть больше нуля")
+	}
+	paymentDate, err := time.Parse("2006-01-02", input.PaymentDate)
+	if err != nil {
+		return models.LedgerEntry{}, errors.New("Неверный формат даты. Ожидается YYYY-MM-DD.")
 	}
+	return models.LedgerEntry{
+		ContractID:    input.ContractID,
+		EntryDate:     paymentDate,
+		Description:   input.PaymentName,
+		SourceType:    models.LedgerSourcePaymentFact,
+		Commission:    input.Commission,
+		PaymentMethod: input.PaymentMethod,
+		AcademicYear:  input.AcademicYear,
+		PayerID:       input.PayerID,
+	}, nil
+}
+
+// errNotPaymentFact возвращается при попытке исправить через форму платежа оплату по договору.
+var errNotPaymentFact = errors.New("Оплату по договору можно только отменить сторно и провести заново")
 
+// UpdatePaymentFact исправляет платеж. Проводки неизменяемы, поэтому прежняя оплата
+// сторнируется и проводится исправленная; ответ содержит новую проводку.
+func UpdatePaymentFact(c *gin.Context) {
 	var input PaymentFactInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	paymentDate, err := time.Parse("2006-01-02", input.PaymentDate)
+	payment, err := paymentEntryFromInput(input)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Обновляем поля существующей записи
-	payment.Amount = input.Amount
-	payment.Commission = input.Commission
-	payment.PaymentDate = paymentDate
-	payment.AcademicYear = input.AcademicYear
-	payment.PaymentName = input.PaymentName
-	payment.PaymentMethod = input.PaymentMethod
-	// ContractID не меняем при обновлении, но если нужно, можно добавить:
-	if input.ContractID != 0 {
-		payment.ContractID = input.ContractID
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
 	}
+	payment.CreatedByID = userID
 
-	if err := config.DB.Save(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+	// Перераспределяем платеж заново: сумма или договор могли измениться.
+	// Прежняя проводка загружается с блокировкой, чтобы параллельное исправление не провело платеж дважды.
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		original, err := findActivePayment(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(paymentFactSources, original.SourceType) {
+			return errNotPaymentFact
+		}
+		// ContractID не меняем при обновлении, если он не передан.
+		if payment.ContractID == 0 {
+			payment.ContractID = original.ContractID
+		}
+		if err := checkContractPayer(tx, payment.ContractID, payment.PayerID); err != nil {
+			return err
+		}
+		_, annulled, err := cancelPayment(tx, original, "Исправление платежа", userID)
+		if err != nil {
+			return err
+		}
+		// Прежняя квитанция аннулирована, плательщику выдается новая с исправленными данными.
+		var replacesID *uint
+		if len(annulled) > 0 {
+			replacesID = &annulled[len(annulled)-1].ID
+		}
+		if _, _, err := recordPayment(tx, &payment, input.Amount, replacesID, nil); err != nil {
+			return err
+		}
+		return repointPaymentReferences(tx, original.ID, payment.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		case errors.Is(err, errNotPaymentFact), errors.Is(err, errPayerNotOnContract):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		}
 		return
 	}
+	go renderSourceReceipts(models.AllocationSourceLedgerEntry, payment.ID)
 	c.JSON(http.StatusOK, payment)
 }
 
-// DeletePaymentFact удаляет платеж (мягкое удаление)
+// DeletePaymentFact отменяет платеж: сторнирует проводку, возвращает распределенные суммы
+// в график и аннулирует квитанцию.
 func DeletePaymentFact(c *gin.Context) {
-	id := c.Param("id")
-	// GORM автоматически выполнит мягкое удаление (установит deleted_at)
-	if err := config.DB.Delete(&models.PaymentFact{}, id).Error; err != nil {
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		payment, err := findActivePayment(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		_, _, err = cancelPayment(tx, payment, "Удаление платежа", userID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
 		return
 	}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"prometheus-crm/config"
 	"prometheus-crm/models"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -83,6 +84,9 @@ func CreatePaymentFormHandler(c *gin.Context) {
 		InstallmentsCount: input.InstallmentsCount,
 		Installments:      input.Installments, // Сохраняем все части с их формулами
 	}
+	if !checkPaymentForm(c, &form) {
+		return
+	}
 
 	if err := config.DB.Create(&form).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment form: " + err.Error()})
@@ -100,6 +104,13 @@ func UpdatePaymentFormHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
 		return
 	}
+	if !checkPaymentForm(c, &models.PaymentForm{
+		Name:              input.Name,
+		InstallmentsCount: input.InstallmentsCount,
+		Installments:      input.Installments,
+	}) {
+		return
+	}
 
 	err := config.DB.Transaction(func(tx *gorm.DB) error {
 		var form models.PaymentForm
@@ -151,3 +162,150 @@ func DeletePaymentFormHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Payment form deleted successfully"})
 	}
 }
+
+// checkPaymentForm проверяет формулы формы перед сохранением и при ошибках отвечает 400
+// со списком проблем в details.
+func checkPaymentForm(c *gin.Context, form *models.PaymentForm) bool {
+	year, _ := currentAcademicYear(config.DB)
+	problems := validatePaymentForm(form, validationAcademicYear(year))
+	if len(problems) == 0 {
+		return true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":   "Форма оплаты содержит ошибки: " + strings.Join(problems, "; "),
+		"details": problems,
+	})
+	return false
+}
+
+// ListPaymentFormulaVariablesHandler возвращает переменные, доступные в формулах платежей.
+func ListPaymentFormulaVariablesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, paymentFormulaVariables)
+}
+
+// PaymentFormDryRunInput - несохраненная форма и суммы договора для пробного расчета.
+type PaymentFormDryRunInput struct {
+	Name              string                      `json:"name"`
+	InstallmentsCount int                         `json:"installments_count"`
+	Installments      []models.PaymentInstallment `json:"installments" binding:"required"`
+	TotalAmount       models.Money                `json:"totalAmount" binding:"required,gt=0"`
+	DiscountedAmount  *models.Money               `json:"discountedAmount"`
+}
+
+// DryRunPaymentFormHandler рассчитывает платежи несохраненной формы для заданной суммы договора.
+// Используется редактором формы, чтобы проверить формулы до сохранения.
+func DryRunPaymentFormHandler(c *gin.Context) {
+	var input PaymentFormDryRunInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	discounted := input.TotalAmount
+	if input.DiscountedAmount != nil {
+		discounted = *input.DiscountedAmount
+	}
+	form := models.PaymentForm{
+		Name:              input.Name,
+		InstallmentsCount: input.InstallmentsCount,
+		Installments:      input.Installments,
+	}
+	year, _ := currentAcademicYear(config.DB)
+	c.JSON(http.StatusOK, dryRunPaymentForm(&form, input.TotalAmount, discounted, validationAcademicYear(year)))
+}
+
+// DryRunSavedPaymentFormHandler рассчитывает платежи сохраненной формы для суммы из параметров
+// totalAmount и discountedAmount (по умолчанию равна totalAmount).
+func DryRunSavedPaymentFormHandler(c *gin.Context) {
+	var form models.PaymentForm
+	if err := config.DB.Preload("Installments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).First(&form, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment form not found"})
+		return
+	}
+
+	total, err := models.ParseMoney(c.DefaultQuery("totalAmount", "1000000"))
+	if err != nil || total <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная сумма totalAmount"})
+		return
+	}
+	discounted := total
+	if v := c.Query("discountedAmount"); v != "" {
+		if discounted, err = models.ParseMoney(v); err != nil || discounted < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная сумма discountedAmount"})
+			return
+		}
+	}
+
+	year, _ := currentAcademicYear(config.DB)
+	c.JSON(http.StatusOK, dryRunPaymentForm(&form, total, discounted, validationAcademicYear(year)))
+}
+
+// PaymentFormContractCheck - результат пробного расчета формы для одного договора.
+type PaymentFormContractCheck struct {
+	ContractID       uint         `json:"contractId"`
+	ContractNumber   string       `json:"contractNumber"`
+	StudentName      string       `json:"studentName"`
+	DiscountedAmount models.Money `json:"discountedAmount"`
+	PlanTotal        models.Money `json:"planTotal"`
+	Difference       models.Money `json:"difference"`
+	Error            string       `json:"error,omitempty"`
+}
+
+// DryRunPaymentFormContractsHandler пересчитывает форму для всех договоров, которые ее используют,
+// и показывает, где сумма платежей не сходится с суммой договора. Ничего не сохраняет.
+func DryRunPaymentFormContractsHandler(c *gin.Context) {
+	var form models.PaymentForm
+	if err := config.DB.Preload("Installments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).First(&form, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment form not found"})
+		return
+	}
+
+	var contracts []models.Contract
+	if err := config.DB.Preload("Student").
+		Where("payment_form_id = ?", form.ID).
+		Order("contract_number").
+		Find(&contracts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch contracts"})
+		return
+	}
+
+	results := make([]PaymentFormContractCheck, 0, len(contracts))
+	failed := 0
+	for i := range contracts {
+		contract := &contracts[i]
+		check := PaymentFormContractCheck{
+			ContractID:       contract.ID,
+			ContractNumber:   contract.ContractNumber,
+			DiscountedAmount: contract.DiscountedAmount,
+		}
+		if contract.Student != nil {
+			check.StudentName = strings.TrimSpace(contract.Student.LastName + " " + contract.Student.FirstName + " " + contract.Student.MiddleName)
+		}
+
+		year, err := academicYearForContract(config.DB, contract)
+		if err != nil {
+			check.Error = err.Error()
+		} else {
+			result := dryRunPaymentForm(&form, contract.TotalAmount, contract.DiscountedAmount, year)
+			check.PlanTotal = result.PlanTotal
+			check.Difference = result.Difference
+			if !result.Valid() {
+				check.Error = strings.Join(result.Errors, "; ")
+			}
+		}
+		if check.Error != "" {
+			failed++
+		}
+		results = append(results, check)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"paymentFormId": form.ID,
+		"total":         len(results),
+		"failed":        failed,
+		"data":          results,
+	})
+}
@@ -0,0 +1,262 @@
+// prometheus-crm/internal/handlers/payment_formula.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"prometheus-crm/models"
+	"strings"
+	"time"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Переменные формул платежей. Имена с пробелами пишутся в формуле в квадратных скобках:
+// [Сумма с учётом скидки] / 9 * 2.
+const (
+	FormulaVarTotal             = "Сумма"
+	FormulaVarDiscounted        = "Сумма с учётом скидки"
+	FormulaVarDiscount          = "Скидка"
+	FormulaVarInstallmentsCount = "Количество платежей"
+	FormulaVarInstallmentNumber = "Номер платежа"
+	FormulaVarMonthsRemaining   = "Осталось месяцев"
+)
+
+// FormulaVariable описывает переменную, доступную в формулах формы оплаты.
+type FormulaVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Example     string `json:"example"`
+}
+
+var paymentFormulaVariables = []FormulaVariable{
+	{FormulaVarTotal, "Стоимость договора без скидок", "[Сумма] * 0.3"},
+	{FormulaVarDiscounted, "Стоимость договора с учетом скидок; сумма всех платежей формы должна быть равна ей", "[Сумма с учётом скидки] / 9"},
+	{FormulaVarDiscount, "Сумма скидки: Сумма - Сумма с учётом скидки", "[Сумма] / 9 - [Скидка]"},
+	{FormulaVarInstallmentsCount, "Количество платежей в форме оплаты", "[Сумма с учётом скидки] / [Количество платежей]"},
+	{FormulaVarInstallmentNumber, "Порядковый номер платежа в форме, начиная с 1", "[Номер платежа] == 1 ? [Сумма с учётом скидки] * 0.5 : [Сумма с учётом скидки] * 0.5 / ([Количество платежей] - 1)"},
+	{FormulaVarMonthsRemaining, "Число месяцев от месяца платежа до окончания учебного года включительно", "[Сумма с учётом скидки] * [Осталось месяцев] / 9"},
+}
+
+// Суммы-образцы для проверки формы при сохранении: без скидки и со скидкой 10%.
+var formulaValidationSamples = [][2]models.Money{
+	{models.Tenge(1000000), models.Tenge(1000000)},
+	{models.Tenge(1000000), models.Tenge(900000)},
+}
+
+var russianMonths = [...]string{
+	"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь",
+	"Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь",
+}
+
+// parseInstallmentMonth возвращает месяц по названию из формы оплаты ("Сентябрь").
+func parseInstallmentMonth(name string) (time.Month, bool) {
+	for i, m := range russianMonths {
+		if m == name {
+			return time.Month(i + 1), true
+		}
+	}
+	return 0, false
+}
+
+// compilePaymentFormula разбирает формулу и проверяет, что в ней используются только известные переменные.
+func compilePaymentFormula(formula string) (*govaluate.EvaluableExpression, error) {
+	if strings.TrimSpace(formula) == "" {
+		return nil, errors.New("формула не указана")
+	}
+	expression, err := govaluate.NewEvaluableExpression(formula)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка в формуле '%s': %v", formula, err)
+	}
+	for _, name := range expression.Vars() {
+		known := false
+		for _, v := range paymentFormulaVariables {
+			if v.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("неизвестная переменная «%s» в формуле '%s'", name, formula)
+		}
+	}
+	return expression, nil
+}
+
+// monthsRemainingInYear - число месяцев от месяца платежа до месяца окончания учебного года включительно.
+func monthsRemainingInYear(year *models.AcademicYear, paymentDate time.Time) int {
+	months := (year.EndDate.Year()-paymentDate.Year())*12 + int(year.EndDate.Month()-paymentDate.Month()) + 1
+	if months < 0 {
+		return 0
+	}
+	return months
+}
+
+// evaluateInstallmentFormula вычисляет сумму одного платежа формы в тенге, без округления.
+// Формулы считаются в float64; до тиынов суммы округляет roundInstallments.
+func evaluateInstallmentFormula(expression *govaluate.EvaluableExpression, total, discounted models.Money, count, number, monthsRemaining int) (float64, error) {
+	result, err := expression.Evaluate(map[string]interface{}{
+		FormulaVarTotal:             total.Float64(),
+		FormulaVarDiscounted:        discounted.Float64(),
+		FormulaVarDiscount:          (total - discounted).Float64(),
+		FormulaVarInstallmentsCount: float64(count),
+		FormulaVarInstallmentNumber: float64(number),
+		FormulaVarMonthsRemaining:   float64(monthsRemaining),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("не удалось вычислить формулу '%s': %v", expression.String(), err)
+	}
+	amount, ok := result.(float64)
+	if !ok {
+		return 0, fmt.Errorf("результат формулы '%s' не является числом", expression.String())
+	}
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("формула '%s' дает некорректный результат (деление на ноль?)", expression.String())
+	}
+	if amount < 0 {
+		return 0, fmt.Errorf("формула '%s' дает отрицательную сумму", expression.String())
+	}
+	return amount, nil
+}
+
+// roundInstallments округляет вычисленные по формулам суммы платежей до тиынов.
+// Если формулы в сумме дают target (расхождение - только погрешность вычислений), тиыны
+// распределяются методом наибольшего остатка и итог платежей равен target точно:
+// 100 000 / 3 дает 33 333.34 + 33 333.33 + 33 333.33. Если формулы дают другую сумму,
+// каждый платеж округляется отдельно, чтобы расхождение было видно при проверке формы.
+func roundInstallments(raw []float64, target models.Money) []models.Money {
+	var sum float64
+	for _, v := range raw {
+		sum += v
+	}
+	if math.Abs(sum*models.MoneyScale-float64(target)) <= float64(len(raw)) {
+		return target.Allocate(raw)
+	}
+	amounts := make([]models.Money, len(raw))
+	for i, v := range raw {
+		amounts[i] = models.MoneyFromFloat(v)
+	}
+	return amounts
+}
+
+// FormulaDryRunRow - результат вычисления одного платежа формы.
+type FormulaDryRunRow struct {
+	Number      int          `json:"number"`
+	Month       string       `json:"month"`
+	Day         int          `json:"day"`
+	PaymentDate time.Time    `json:"paymentDate"`
+	Formula     string       `json:"formula"`
+	Amount      models.Money `json:"amount"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// FormulaDryRunResult - результат пробного расчета формы оплаты для одной суммы договора.
+type FormulaDryRunResult struct {
+	TotalAmount      models.Money `json:"totalAmount"`
+	DiscountedAmount models.Money `json:"discountedAmount"`
+	PlanTotal        models.Money `json:"planTotal"`
+	// Difference - на сколько сумма платежей отличается от суммы с учетом скидки.
+	Difference models.Money       `json:"difference"`
+	Rows       []FormulaDryRunRow `json:"rows"`
+	Errors     []string           `json:"errors"`
+}
+
+// Valid сообщает, что все формулы вычислены и их сумма совпадает с суммой договора.
+func (r FormulaDryRunResult) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// dryRunPaymentForm вычисляет все платежи формы для заданных сумм, не прерываясь на первой ошибке.
+func dryRunPaymentForm(form *models.PaymentForm, total, discounted models.Money, year *models.AcademicYear) FormulaDryRunResult {
+	result := FormulaDryRunResult{
+		TotalAmount:      total,
+		DiscountedAmount: discounted,
+		Rows:             make([]FormulaDryRunRow, 0, len(form.Installments)),
+		Errors:           make([]string, 0),
+	}
+	count := len(form.Installments)
+	raw := make([]float64, 0, count)
+	for i, installment := range form.Installments {
+		row := FormulaDryRunRow{Number: i + 1, Month: installment.Month, Day: installment.Day, Formula: installment.Formula}
+		amount, err := evaluatePaymentInstallment(installment, total, discounted, count, i+1, year, &row.PaymentDate)
+		if err != nil {
+			row.Error = err.Error()
+			result.Errors = append(result.Errors, fmt.Sprintf("Платеж %d: %s", i+1, err.Error()))
+		}
+		raw = append(raw, amount)
+		result.Rows = append(result.Rows, row)
+	}
+	amounts := roundInstallments(raw, discounted)
+	for i, amount := range amounts {
+		if result.Rows[i].Error == "" {
+			result.Rows[i].Amount = amount
+			result.PlanTotal += amount
+		}
+	}
+	result.Difference = result.PlanTotal - discounted
+	if len(result.Errors) == 0 && result.Difference != 0 {
+		result.Errors = append(result.Errors, fmt.Sprintf(
+			"сумма платежей %s не равна сумме с учетом скидки %s (разница %s)", result.PlanTotal, discounted, result.Difference))
+	}
+	return result
+}
+
+// evaluatePaymentInstallment проверяет месяц и день платежа, вычисляет его дату в учебном году
+// и сумму по формуле.
+func evaluatePaymentInstallment(installment models.PaymentInstallment, total, discounted models.Money, count, number int, year *models.AcademicYear, paymentDate *time.Time) (float64, error) {
+	month, ok := parseInstallmentMonth(installment.Month)
+	if !ok {
+		return 0, fmt.Errorf("неизвестный месяц «%s»", installment.Month)
+	}
+	// Проверяем день по високосному году, чтобы 29 февраля было допустимо.
+	if installment.Day < 1 || installment.Day > time.Date(2024, month+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return 0, fmt.Errorf("в месяце «%s» нет %d-го числа", installment.Month, installment.Day)
+	}
+	*paymentDate = year.DateInYear(month, installment.Day)
+
+	expression, err := compilePaymentFormula(installment.Formula)
+	if err != nil {
+		return 0, err
+	}
+	return evaluateInstallmentFormula(expression, total, discounted, count, number, monthsRemainingInYear(year, *paymentDate))
+}
+
+// validatePaymentForm проверяет форму оплаты перед сохранением: количество платежей, месяцы и дни,
+// формулы и равенство суммы платежей сумме договора на суммах-образцах.
+func validatePaymentForm(form *models.PaymentForm, year *models.AcademicYear) []string {
+	var problems []string
+	if len(form.Installments) == 0 {
+		return []string{"в форме нет ни одного платежа"}
+	}
+	if form.InstallmentsCount != len(form.Installments) {
+		problems = append(problems, fmt.Sprintf("указано %d платежей, а заполнено %d", form.InstallmentsCount, len(form.Installments)))
+	}
+	for _, sample := range formulaValidationSamples {
+		result := dryRunPaymentForm(form, sample[0], sample[1], year)
+		for _, e := range result.Errors {
+			problem := e
+			if sample[0] != sample[1] {
+				problem = fmt.Sprintf("при скидке %.0f%%: %s", (1-sample[1].Float64()/sample[0].Float64())*100, e)
+			}
+			problems = append(problems, problem)
+		}
+		if len(result.Errors) > 0 {
+			break // при скидке ошибки обычно повторяются
+		}
+	}
+	return problems
+}
+
+// validationAcademicYear - учебный год для проверки форм: текущий, а если он не настроен - условный
+// год с сентября по май (для проверки формул конкретные даты не важны).
+func validationAcademicYear(current *models.AcademicYear) *models.AcademicYear {
+	if current != nil {
+		return current
+	}
+	start := time.Now().Year()
+	return &models.AcademicYear{
+		StartDate: time.Date(start, time.September, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(start+1, time.May, 25, 0, 0, 0, 0, time.UTC),
+	}
+}
@@ -0,0 +1,342 @@
+// prometheus-crm/internal/handlers/payment_gateway.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"prometheus-crm/internal/middleware"
+	"prometheus-crm/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PaymentGatewayService - настройки онлайн-оплаты в integration_settings и журнале вебхуков.
+const PaymentGatewayService = "payment_gateway"
+
+// Платежные шлюзы, доступные из коробки.
+const (
+	GatewayHalyk = "halyk"
+	GatewayFake  = "fake" // локальный тестовый шлюз без обращения к сети
+)
+
+const defaultPaymentLinkTTLHours = 72
+
+// PaymentGatewaySettings - параметры онлайн-оплаты. Provider выбирает шлюз для новых ссылок;
+// ссылки, выданные раньше, обрабатываются тем шлюзом, которым были созданы.
+type PaymentGatewaySettings struct {
+	Provider string `json:"provider"`
+	// PublicURL - внешний адрес CRM: на него шлюз отправляет callback и возвращает родителя.
+	PublicURL    string `json:"publicUrl"`
+	LinkTTLHours int    `json:"linkTtlHours"`
+	// Secret подтверждает подлинность callback: secret_hash у Halyk, ключ HMAC у тестового шлюза.
+	Secret string `json:"secret"`
+
+	// Параметры Halyk ePay.
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	TerminalID   string `json:"terminalId"`
+	ShopID       string `json:"shopId"`
+	OAuthURL     string `json:"oauthUrl"`
+	InvoiceURL   string `json:"invoiceUrl"`
+}
+
+// GatewayPayment - результат регистрации оплаты в шлюзе.
+type GatewayPayment struct {
+	URL        string
+	ExternalID string
+}
+
+// GatewayCallback - проверенное уведомление шлюза о результате оплаты.
+type GatewayCallback struct {
+	// OrderNumber - номер заказа, переданный шлюзу при создании (PaymentLink.OrderNumber).
+	OrderNumber string
+	ExternalID  string
+	Amount      models.Money
+	Success     bool
+	Reason      string
+	PaidAt      time.Time
+}
+
+// PaymentGateway - адаптер эквайринга. Реализации подключаются через RegisterPaymentGateway,
+// поэтому банк можно заменить без изменения логики ссылок и зачисления платежей.
+type PaymentGateway interface {
+	// Title - название способа оплаты в проводке-оплате и квитанции.
+	Title() string
+	// CreatePayment регистрирует оплату в шлюзе и возвращает адрес страницы оплаты.
+	CreatePayment(ctx context.Context, link *models.PaymentLink, settings PaymentGatewaySettings) (GatewayPayment, error)
+	// VerifyCallback проверяет подпись уведомления и разбирает его.
+	VerifyCallback(ctx context.Context, header http.Header, body []byte, settings PaymentGatewaySettings) (GatewayCallback, error)
+}
+
+var errGatewaySignature = errors.New("неверная подпись уведомления платежного шлюза")
+
+var (
+	paymentGatewaysMu sync.RWMutex
+	paymentGateways   = map[string]PaymentGateway{
+		GatewayHalyk: halykGateway{client: &http.Client{Timeout: 20 * time.Second}},
+		GatewayFake:  fakeGateway{},
+	}
+)
+
+// RegisterPaymentGateway подключает или подменяет адаптер шлюза.
+func RegisterPaymentGateway(name string, gateway PaymentGateway) {
+	paymentGatewaysMu.Lock()
+	defer paymentGatewaysMu.Unlock()
+	paymentGateways[name] = gateway
+}
+
+func paymentGatewayFor(name string) (PaymentGateway, bool) {
+	paymentGatewaysMu.RLock()
+	defer paymentGatewaysMu.RUnlock()
+	g, ok := paymentGateways[name]
+	return g, ok
+}
+
+// loadPaymentGatewaySettings возвращает настройки включенной онлайн-оплаты.
+func loadPaymentGatewaySettings() (PaymentGatewaySettings, error) {
+	var settings PaymentGatewaySettings
+	raw, err := loadEnabledSettings(PaymentGatewayService)
+	if err != nil {
+		return settings, err
+	}
+	data, _ := json.Marshal(raw)
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("некорректные настройки онлайн-оплаты: %w", err)
+	}
+	if settings.LinkTTLHours <= 0 {
+		settings.LinkTTLHours = defaultPaymentLinkTTLHours
+	}
+	settings.PublicURL = strings.TrimRight(settings.PublicURL, "/")
+	return settings, nil
+}
+
+// gatewayCallbackURL - адрес, на который шлюз присылает уведомление об оплате.
+func gatewayCallbackURL(settings PaymentGatewaySettings, gateway string) string {
+	return settings.PublicURL + "/api/webhooks/payment-gateway/" + gateway
+}
+
+// paymentLinkURL - публичная ссылка, которую получает родитель.
+func paymentLinkURL(settings PaymentGatewaySettings, link *models.PaymentLink) string {
+	return settings.PublicURL + "/pay/" + link.Token
+}
+
+// --- Halyk ePay ---
+
+const (
+	halykDefaultOAuthURL   = "https://epay-oauth.homebank.kz/oauth2/token"
+	halykDefaultInvoiceURL = "https://epay-api.homebank.kz/invoice"
+	halykTokenScope        = "webapi usermanagement email_send verification statement statistics payment"
+)
+
+// halykGateway выставляет счет (invoice link) в Halyk ePay. Токен запрашивается на каждый счет:
+// в него входят номер заказа, сумма и secret_hash, который банк возвращает в postLink.
+// Подлинность callback подтверждается совпадением secret_hash и терминала с настройками.
+type halykGateway struct {
+	client *http.Client
+}
+
+func (halykGateway) Title() string { return "Halyk ePay" }
+
+func (g halykGateway) CreatePayment(ctx context.Context, link *models.PaymentLink, settings PaymentGatewaySettings) (GatewayPayment, error) {
+	if settings.ClientID == "" || settings.ClientSecret == "" || settings.TerminalID == "" {
+		return GatewayPayment{}, errors.New("в настройках Halyk ePay не указаны clientId, clientSecret или terminalId")
+	}
+	oauthURL := settings.OAuthURL
+	if oauthURL == "" {
+		oauthURL = halykDefaultOAuthURL
+	}
+	invoiceURL := settings.InvoiceURL
+	if invoiceURL == "" {
+		invoiceURL = halykDefaultInvoiceURL
+	}
+	callback := gatewayCallbackURL(settings, GatewayHalyk)
+
+	form := url.Values{
+		"grant_type":      {"client_credentials"},
+		"scope":           {halykTokenScope},
+		"client_id":       {settings.ClientID},
+		"client_secret":   {settings.ClientSecret},
+		"invoiceID":       {link.OrderNumber()},
+		"amount":          {link.Amount.String()},
+		"currency":        {"KZT"},
+		"terminal":        {settings.TerminalID},
+		"secret_hash":     {settings.Secret},
+		"postLink":        {callback},
+		"failurePostLink": {callback},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return GatewayPayment{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := g.doJSON(req, &token); err != nil {
+		return GatewayPayment{}, fmt.Errorf("не удалось получить токен Halyk ePay: %w", err)
+	}
+
+	expireDays := int(math.Ceil(float64(settings.LinkTTLHours) / 24))
+	payload, _ := json.Marshal(map[string]interface{}{
+		"shop_id":           settings.ShopID,
+		"account_id":        strconv.FormatUint(uint64(link.ContractID), 10),
+		"invoice_id":        link.OrderNumber(),
+		"amount":            link.Amount.Float64(),
+		"currency":          "KZT",
+		"language":          "rus",
+		"description":       link.Description,
+		"expire_period":     fmt.Sprintf("%dd", expireDays),
+		"post_link":         callback,
+		"failure_post_link": callback,
+		"back_link":         paymentLinkURL(settings, link),
+	})
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, invoiceURL, bytes.NewReader(payload))
+	if err != nil {
+		return GatewayPayment{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	var invoice struct {
+		ID         string `json:"id"`
+		InvoiceURL string `json:"invoice_url"`
+		URL        string `json:"url"`
+	}
+	if err := g.doJSON(req, &invoice); err != nil {
+		return GatewayPayment{}, fmt.Errorf("не удалось выставить счет Halyk ePay: %w", err)
+	}
+	paymentURL := invoice.InvoiceURL
+	if paymentURL == "" {
+		paymentURL = invoice.URL
+	}
+	if paymentURL == "" {
+		return GatewayPayment{}, errors.New("Halyk ePay не вернул ссылку на оплату")
+	}
+	return GatewayPayment{URL: paymentURL, ExternalID: invoice.ID}, nil
+}
+
+func (g halykGateway) doJSON(req *http.Request, out interface{}) error {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("статус %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (halykGateway) VerifyCallback(_ context.Context, _ http.Header, body []byte, settings PaymentGatewaySettings) (GatewayCallback, error) {
+	var input struct {
+		ID         string       `json:"id"`
+		InvoiceID  string       `json:"invoiceId"`
+		Amount     models.Money `json:"amount"`
+		Code       string       `json:"code"`
+		Reason     string       `json:"reason"`
+		SecretHash string       `json:"secret_hash"`
+		Terminal   string       `json:"terminal"`
+		DateTime   string       `json:"dateTime"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return GatewayCallback{}, fmt.Errorf("некорректное уведомление Halyk ePay: %w", err)
+	}
+	if settings.Secret == "" || !hmac.Equal([]byte(input.SecretHash), []byte(settings.Secret)) {
+		return GatewayCallback{}, errGatewaySignature
+	}
+	if settings.TerminalID != "" && input.Terminal != "" && input.Terminal != settings.TerminalID {
+		return GatewayCallback{}, errGatewaySignature
+	}
+	paidAt, err := time.Parse(time.RFC3339, input.DateTime)
+	if err != nil {
+		paidAt = time.Now()
+	}
+	return GatewayCallback{
+		OrderNumber: input.InvoiceID,
+		ExternalID:  input.ID,
+		Amount:      input.Amount,
+		Success:     input.Code == "ok",
+		Reason:      input.Reason,
+		PaidAt:      paidAt,
+	}, nil
+}
+
+// --- Тестовый шлюз ---
+
+// fakeGateway - локальный шлюз для проверки всего сценария без сети: страница оплаты
+// отдается самой CRM (/fake-gateway/<token>), а callback подписывается тем же HMAC,
+// что и вебхуки 1С (см. middleware.WebhookSignatureMiddleware).
+type fakeGateway struct{}
+
+// fakeGatewayCallback - тело уведомления тестового шлюза.
+type fakeGatewayCallback struct {
+	OrderID       string       `json:"orderId"`
+	TransactionID string       `json:"transactionId"`
+	Amount        models.Money `json:"amount"`
+	Status        string       `json:"status"` // success, failed
+	Reason        string       `json:"reason,omitempty"`
+}
+
+func (fakeGateway) Title() string { return "Онлайн-оплата (тест)" }
+
+func (fakeGateway) CreatePayment(_ context.Context, link *models.PaymentLink, settings PaymentGatewaySettings) (GatewayPayment, error) {
+	return GatewayPayment{
+		URL:        settings.PublicURL + "/fake-gateway/" + link.Token,
+		ExternalID: "fake-" + link.OrderNumber(),
+	}, nil
+}
+
+func (fakeGateway) VerifyCallback(_ context.Context, header http.Header, body []byte, settings PaymentGatewaySettings) (GatewayCallback, error) {
+	timestamp, err := strconv.ParseInt(header.Get(middleware.WebhookTimestampHeader), 10, 64)
+	if err != nil || math.Abs(time.Since(time.Unix(timestamp, 0)).Seconds()) > (5*time.Minute).Seconds() {
+		return GatewayCallback{}, errGatewaySignature
+	}
+	provided, err := hex.DecodeString(header.Get(middleware.WebhookSignatureHeader))
+	if err != nil || settings.Secret == "" || !hmac.Equal(provided, signGatewayPayload(settings.Secret, timestamp, body)) {
+		return GatewayCallback{}, errGatewaySignature
+	}
+
+	var input fakeGatewayCallback
+	if err := json.Unmarshal(body, &input); err != nil {
+		return GatewayCallback{}, fmt.Errorf("некорректное уведомление тестового шлюза: %w", err)
+	}
+	return GatewayCallback{
+		OrderNumber: input.OrderID,
+		ExternalID:  input.TransactionID,
+		Amount:      input.Amount,
+		Success:     input.Status == "success",
+		Reason:      input.Reason,
+		PaidAt:      time.Unix(timestamp, 0),
+	}, nil
+}
+
+// signGatewayPayload возвращает HMAC-SHA256(secret, "<timestamp>.<body>").
+func signGatewayPayload(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// signedFakeCallback формирует подписанное уведомление тестового шлюза.
+func signedFakeCallback(settings PaymentGatewaySettings, cb fakeGatewayCallback) (http.Header, []byte) {
+	body, _ := json.Marshal(cb)
+	now := time.Now().Unix()
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(middleware.WebhookTimestampHeader, strconv.FormatInt(now, 10))
+	header.Set(middleware.WebhookSignatureHeader, hex.EncodeToString(signGatewayPayload(settings.Secret, now, body)))
+	return header, body
+}
@@ -13,11 +13,13 @@ import (
 
 // CreatePaymentRequest определяет структуру для входящих данных.
 type CreatePaymentRequest struct {
-	ContractID    uint    `json:"contractId" binding:"required"`
-	Amount        float64 `json:"amount" binding:"required"`
-	PaymentDate   string  `json:"paymentDate" binding:"required"`
-	PaymentFormID uint    `json:"payment_form_id" binding:"required"` // <-- Добавлено binding:"required"
-	Comment       string  `json:"comment"`
+	ContractID    uint         `json:"contractId" binding:"required"`
+	Amount        models.Money `json:"amount" binding:"required"`
+	PaymentDate   string       `json:"paymentDate" binding:"required"`
+	PaymentFormID uint         `json:"payment_form_id" binding:"required"` // <-- Добавлено binding:"required"
+	Comment       string       `json:"comment"`
+	// PayerID - плательщик договора, от которого поступили деньги (необязательно).
+	PayerID *uint `json:"payerId"`
 }
 
 // CreateActualPayment обрабатывает запрос на добавление нового платежа по договору.
@@ -35,6 +37,10 @@ func CreateActualPayment(c *gin.Context) {
 		return
 	}
 	// ### КОНЕЦ ИСПРАВЛЕНИЯ ###
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сумма платежа должна быть больше нуля"})
+		return
+	}
 
 	paymentTime, err := time.Parse("2006-01-02", req.PaymentDate)
 	if err != nil {
@@ -59,24 +65,36 @@ func CreateActualPayment(c *gin.Context) {
 		return
 	}
 
-	payment := models.ContractPayment{
-		ContractID:    req.ContractID,
-		Amount:        req.Amount,
-		PaymentDate:   paymentTime,
-		PaymentFormID: req.PaymentFormID,
-		Comment:       req.Comment,
+	if err := checkContractPayer(tx, contract.ID, req.PayerID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := tx.Create(&payment).Error; err != nil {
+	var form models.PaymentForm
+	if err := tx.First(&form, req.PaymentFormID).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить платеж"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Форма оплаты не найдена"})
 		return
 	}
 
-	updateExpr := gorm.Expr("paid_amount + ?", req.Amount)
-	if err := tx.Model(&contract).Update("paid_amount", updateExpr).Error; err != nil {
+	// Оплата проводится в журнале расчетов; contracts.paid_amount пересчитывается из него.
+	payment := models.LedgerEntry{
+		ContractID:    contract.ID,
+		PayerID:       req.PayerID,
+		EntryDate:     paymentTime,
+		Description:   req.Comment,
+		SourceType:    models.LedgerSourceContractPayment,
+		PaymentFormID: &form.ID,
+		PaymentMethod: form.Name,
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		payment.CreatedByID = &userID
+	}
+	allocation, receipt, err := recordPayment(tx, &payment, req.Amount, nil, payment.CreatedByID)
+	if err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось обновить сумму в договоре"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось провести платеж: " + err.Error()})
 		return
 	}
 
@@ -84,6 +102,12 @@ func CreateActualPayment(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось подтвердить транзакцию"})
 		return
 	}
+	go renderSourceReceipts(models.AllocationSourceLedgerEntry, payment.ID)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Оплата успешно добавлена"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Оплата успешно добавлена",
+		"allocations": allocation.Allocations,
+		"unallocated": allocation.Unallocated,
+		"receipt":     receipt,
+	})
 }
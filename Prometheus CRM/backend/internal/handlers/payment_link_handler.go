@@ -0,0 +1,561 @@
+// prometheus-crm/internal/handlers/payment_link_handler.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/internal/middleware"
+	"prometheus-crm/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentLinkInput - запрос на выдачу ссылки: на строку графика (сумма по умолчанию - ее остаток)
+// или на произвольную сумму по договору.
+type PaymentLinkInput struct {
+	ContractID       uint         `json:"contractId"`
+	PlannedPaymentID *uint        `json:"plannedPaymentId"`
+	Amount           models.Money `json:"amount"`
+	Description      string       `json:"description"`
+}
+
+// PaymentLinkResponse - ссылка вместе с публичным адресом для родителя.
+type PaymentLinkResponse struct {
+	models.PaymentLink
+	Link string `json:"link"`
+}
+
+// newPaymentLinkToken возвращает случайный токен публичной ссылки.
+func newPaymentLinkToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// requestBaseURL - адрес CRM по текущему запросу, если PublicURL в настройках не задан.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// expireStalePaymentLinks переводит просроченные неоплаченные ссылки в статус expired.
+func expireStalePaymentLinks(tx *gorm.DB) error {
+	return tx.Model(&models.PaymentLink{}).
+		Where("status = ? AND expires_at < ?", models.PaymentLinkPending, time.Now()).
+		Update("status", models.PaymentLinkExpired).Error
+}
+
+// CreatePaymentLinkHandler выдает ссылку на онлайн-оплату через шлюз из настроек.
+func CreatePaymentLinkHandler(c *gin.Context) {
+	var input PaymentLinkInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные: " + err.Error()})
+		return
+	}
+
+	settings, err := loadPaymentGatewaySettings()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Онлайн-оплата не настроена: " + err.Error()})
+		return
+	}
+	gateway, ok := paymentGatewayFor(settings.Provider)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Неизвестный платежный шлюз «%s»", settings.Provider)})
+		return
+	}
+	if settings.PublicURL == "" {
+		settings.PublicURL = requestBaseURL(c)
+	}
+
+	var contract models.Contract
+	if input.PlannedPaymentID != nil {
+		var planned models.PlannedPayment
+		if err := config.DB.First(&planned, *input.PlannedPaymentID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Платеж графика не найден"})
+			return
+		}
+		outstanding := planned.PlannedAmount - planned.PaidAmount
+		if outstanding <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Платеж графика уже оплачен"})
+			return
+		}
+		input.ContractID = planned.ContractID
+		if input.Amount == 0 {
+			input.Amount = outstanding
+		}
+		if input.Description == "" {
+			input.Description = planned.PaymentName
+		}
+	}
+	if input.ContractID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан договор или платеж графика"})
+		return
+	}
+	if input.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сумма оплаты должна быть больше нуля"})
+		return
+	}
+	if err := config.DB.First(&contract, input.ContractID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+	if contract.TerminatedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Договор расторгнут"})
+		return
+	}
+	if input.Description == "" {
+		input.Description = "Оплата по договору " + contract.ContractNumber
+	}
+
+	token, err := newPaymentLinkToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось создать ссылку"})
+		return
+	}
+	link := models.PaymentLink{
+		Token:            token,
+		Gateway:          settings.Provider,
+		ContractID:       contract.ID,
+		PlannedPaymentID: input.PlannedPaymentID,
+		Amount:           input.Amount,
+		Description:      input.Description,
+		Status:           models.PaymentLinkPending,
+		ExpiresAt:        time.Now().Add(time.Duration(settings.LinkTTLHours) * time.Hour),
+	}
+	if userID, err := getUserIDFromContext(c); err == nil {
+		link.CreatedByID = &userID
+	}
+	// Ссылка сохраняется до обращения к шлюзу: номер заказа в шлюзе - ее ID.
+	if err := config.DB.Create(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить ссылку"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	payment, err := gateway.CreatePayment(ctx, &link, settings)
+	if err != nil {
+		link.Status = models.PaymentLinkCancelled
+		link.LastError = err.Error()
+		config.DB.Save(&link)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Платежный шлюз отклонил запрос: " + err.Error()})
+		return
+	}
+	link.PaymentURL = payment.URL
+	link.ExternalID = payment.ExternalID
+	if err := config.DB.Save(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить ссылку"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, PaymentLinkResponse{PaymentLink: link, Link: paymentLinkURL(settings, &link)})
+}
+
+// ListPaymentLinksHandler возвращает выданные ссылки с фильтрами contract_id, planned_payment_id, status.
+func ListPaymentLinksHandler(c *gin.Context) {
+	if err := expireStalePaymentLinks(config.DB); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось обновить статусы ссылок"})
+		return
+	}
+
+	query := config.DB.Model(&models.PaymentLink{})
+	if contractID := c.Query("contract_id"); contractID != "" {
+		query = query.Where("contract_id = ?", contractID)
+	}
+	if plannedID := c.Query("planned_payment_id"); plannedID != "" {
+		query = query.Where("planned_payment_id = ?", plannedID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var totalRows int64
+	if err := query.Count(&totalRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать ссылки"})
+		return
+	}
+	var links []models.PaymentLink
+	if err := query.Scopes(Paginate(c)).Order("id DESC").Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить ссылки"})
+		return
+	}
+
+	settings, err := loadPaymentGatewaySettings()
+	if err != nil || settings.PublicURL == "" {
+		settings.PublicURL = requestBaseURL(c)
+	}
+	items := make([]PaymentLinkResponse, 0, len(links))
+	for i := range links {
+		items = append(items, PaymentLinkResponse{PaymentLink: links[i], Link: paymentLinkURL(settings, &links[i])})
+	}
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, items, totalRows))
+}
+
+// CancelPaymentLinkHandler отменяет неоплаченную ссылку. Платеж, пришедший по ней позже, все равно зачисляется.
+func CancelPaymentLinkHandler(c *gin.Context) {
+	var link models.PaymentLink
+	if err := config.DB.First(&link, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ссылка не найдена"})
+		return
+	}
+	if link.Status != models.PaymentLinkPending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Отменить можно только неоплаченную ссылку"})
+		return
+	}
+	if err := config.DB.Model(&link).Update("status", models.PaymentLinkCancelled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось отменить ссылку"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Ссылка отменена"})
+}
+
+// --- Публичные страницы ---
+
+var paymentPageTemplate = template.Must(template.New("payment").Parse(`<!DOCTYPE html>
+<html lang="ru"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body{font-family:Arial,sans-serif;max-width:480px;margin:3rem auto;padding:0 1rem;color:#222}
+.amount{font-size:1.6rem;font-weight:bold;margin:1rem 0}button{padding:.6rem 1.2rem;margin-right:.5rem;font-size:1rem;cursor:pointer}</style>
+</head><body>
+<h2>{{.Title}}</h2>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Amount}}<div class="amount">{{.Amount}}</div>{{end}}
+<p>{{.Message}}</p>
+{{if .FormAction}}<form method="post" action="{{.FormAction}}">
+<button type="submit" name="result" value="success">Оплатить</button>
+<button type="submit" name="result" value="fail">Отклонить</button>
+</form>{{end}}
+</body></html>`))
+
+type paymentPageData struct {
+	Title       string
+	Description string
+	Amount      string
+	Message     string
+	FormAction  string
+}
+
+func renderPaymentPage(c *gin.Context, status int, data paymentPageData) {
+	var buf bytes.Buffer
+	if err := paymentPageTemplate.Execute(&buf, data); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка отображения страницы")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// loadPublicPaymentLink находит ссылку по токену и помечает ее просроченной при необходимости.
+func loadPublicPaymentLink(token string) (*models.PaymentLink, error) {
+	var link models.PaymentLink
+	if err := config.DB.Where("token = ?", token).First(&link).Error; err != nil {
+		return nil, err
+	}
+	if link.Status == models.PaymentLinkPending && time.Now().After(link.ExpiresAt) {
+		link.Status = models.PaymentLinkExpired
+		config.DB.Model(&link).Update("status", link.Status)
+	}
+	return &link, nil
+}
+
+// PaymentLinkRedirectHandler - публичная ссылка для родителя: перенаправляет на страницу оплаты шлюза
+// или сообщает, что ссылка уже оплачена, отменена или просрочена.
+func PaymentLinkRedirectHandler(c *gin.Context) {
+	link, err := loadPublicPaymentLink(c.Param("token"))
+	if err != nil {
+		renderPaymentPage(c, http.StatusNotFound, paymentPageData{Title: "Ссылка не найдена", Message: "Проверьте адрес или запросите новую ссылку у школы."})
+		return
+	}
+	page := paymentPageData{Title: "Оплата обучения", Description: link.Description, Amount: formatMoney(link.Amount) + " тг"}
+	switch link.Status {
+	case models.PaymentLinkPending:
+		c.Redirect(http.StatusFound, link.PaymentURL)
+		return
+	case models.PaymentLinkPaid:
+		page.Message = "Счет оплачен. Спасибо!"
+	case models.PaymentLinkExpired:
+		page.Message = "Срок действия ссылки истек. Запросите новую ссылку у школы."
+	default:
+		page.Message = "Ссылка отменена. Запросите новую ссылку у школы."
+	}
+	renderPaymentPage(c, http.StatusOK, page)
+}
+
+// fakeGatewayActive сообщает, выбран ли тестовый шлюз в настройках онлайн-оплаты.
+// Пока выбран настоящий шлюз, страницы и уведомления тестового шлюза не работают,
+// даже для ссылок, выданных им раньше.
+func fakeGatewayActive() bool {
+	settings, err := loadPaymentGatewaySettings()
+	return err == nil && settings.Provider == GatewayFake
+}
+
+// FakeGatewayPageHandler - страница оплаты тестового шлюза (только когда он выбран в настройках).
+func FakeGatewayPageHandler(c *gin.Context) {
+	link, err := loadPublicPaymentLink(c.Param("token"))
+	if err != nil || link.Gateway != GatewayFake || !fakeGatewayActive() {
+		renderPaymentPage(c, http.StatusNotFound, paymentPageData{Title: "Ссылка не найдена"})
+		return
+	}
+	page := paymentPageData{
+		Title:       "Тестовый платежный шлюз",
+		Description: link.Description,
+		Amount:      formatMoney(link.Amount) + " тг",
+		Message:     "Деньги не списываются: выберите результат оплаты.",
+	}
+	if link.Status == models.PaymentLinkPending {
+		page.FormAction = "/fake-gateway/" + link.Token
+	} else {
+		page.Message = "Ссылка уже не действует (статус: " + link.Status + ")."
+	}
+	renderPaymentPage(c, http.StatusOK, page)
+}
+
+// FakeGatewayCompleteHandler имитирует уведомление шлюза: формирует подписанный callback
+// и проводит его через ту же обработку, что и настоящие уведомления.
+func FakeGatewayCompleteHandler(c *gin.Context) {
+	link, err := loadPublicPaymentLink(c.Param("token"))
+	if err != nil || link.Gateway != GatewayFake || !fakeGatewayActive() {
+		renderPaymentPage(c, http.StatusNotFound, paymentPageData{Title: "Ссылка не найдена"})
+		return
+	}
+	settings, err := loadPaymentGatewaySettings()
+	if err != nil {
+		renderPaymentPage(c, http.StatusBadRequest, paymentPageData{Title: "Онлайн-оплата не настроена", Message: err.Error()})
+		return
+	}
+
+	cb := fakeGatewayCallback{
+		OrderID:       link.OrderNumber(),
+		TransactionID: fmt.Sprintf("fake-%s-%d", link.OrderNumber(), time.Now().UnixNano()),
+		Amount:        link.Amount,
+		Status:        "success",
+	}
+	if c.PostForm("result") != "success" {
+		cb.Status = "failed"
+		cb.Reason = "Отклонено в тестовом шлюзе"
+	}
+	header, body := signedFakeCallback(settings, cb)
+	code, response := processGatewayCallback(c.Request.Context(), GatewayFake, header, body)
+
+	page := paymentPageData{Title: "Тестовый платежный шлюз", Description: link.Description, Amount: formatMoney(link.Amount) + " тг"}
+	switch {
+	case code >= 300:
+		page.Message = fmt.Sprintf("Ошибка обработки уведомления: %v", response["error"])
+	case cb.Status == "success":
+		page.Message = "Оплата прошла успешно."
+	default:
+		page.Message = "Оплата отклонена."
+	}
+	renderPaymentPage(c, code, page)
+}
+
+// --- Уведомления шлюза ---
+
+// PaymentGatewayCallbackHandler принимает уведомление шлюза об оплате.
+// Маршрут публичный: подлинность проверяет адаптер шлюза (VerifyCallback).
+func PaymentGatewayCallbackHandler(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, middleware.MaxWebhookBodySize+1))
+	if err != nil || len(body) > middleware.MaxWebhookBodySize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Тело уведомления не прочитано или превышает допустимый размер"})
+		return
+	}
+	code, response := processGatewayCallback(c.Request.Context(), c.Param("gateway"), c.Request.Header, body)
+	c.JSON(code, response)
+}
+
+// processGatewayCallback проверяет уведомление, записывает его в журнал вебхуков и при успешной
+// оплате проводит платеж (журнал расчетов, распределение по графику, квитанция).
+// Повторное уведомление по оплаченной ссылке возвращает исходный платеж.
+// До проверки подписи в журнале хранится только начало тела; целиком - после проверки.
+// Секрет шлюза (secret_hash) в журнал не попадает.
+func processGatewayCallback(ctx context.Context, gatewayName string, header http.Header, body []byte) (int, gin.H) {
+	entry := models.InboundWebhook{
+		ServiceName: PaymentGatewayService,
+		Signature:   header.Get(middleware.WebhookSignatureHeader),
+		Payload:     middleware.RejectedWebhookPayload(redactGatewayPayload(body)),
+		Status:      models.WebhookStatusReceived,
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось записать уведомление в журнал"}
+	}
+	finish := func(code int, response gin.H) (int, gin.H) {
+		finishInboundWebhook(&entry, code, response)
+		return code, response
+	}
+
+	gateway, ok := paymentGatewayFor(gatewayName)
+	if !ok {
+		return finish(http.StatusNotFound, gin.H{"error": "Неизвестный платежный шлюз"})
+	}
+	settings, err := loadPaymentGatewaySettings()
+	if err != nil {
+		return finish(http.StatusServiceUnavailable, gin.H{"error": "Онлайн-оплата не настроена: " + err.Error()})
+	}
+	// Уведомления тестового шлюза принимаются, только пока он выбран в настройках.
+	if gatewayName == GatewayFake && settings.Provider != GatewayFake {
+		return finish(http.StatusNotFound, gin.H{"error": "Неизвестный платежный шлюз"})
+	}
+	cb, err := gateway.VerifyCallback(ctx, header, body, settings)
+	if err != nil {
+		now := time.Now()
+		entry.Status = models.WebhookStatusRejected
+		entry.ResponseCode = http.StatusUnauthorized
+		entry.Error = err.Error()
+		entry.ProcessedAt = &now
+		if err := config.DB.Save(&entry).Error; err != nil {
+			log.Printf("Не удалось обновить запись вебхука %d: %v", entry.ID, err)
+		}
+		return http.StatusUnauthorized, gin.H{"error": err.Error()}
+	}
+	entry.ExternalID = gatewayName + ":" + cb.ExternalID
+	entry.Payload = string(redactGatewayPayload(body))
+
+	linkID, err := strconv.ParseUint(cb.OrderNumber, 10, 64)
+	if err != nil {
+		return finish(http.StatusBadRequest, gin.H{"error": "Некорректный номер заказа"})
+	}
+	var link models.PaymentLink
+	if err := config.DB.Where("id = ? AND gateway = ?", linkID, gatewayName).First(&link).Error; err != nil {
+		return finish(http.StatusNotFound, gin.H{"error": "Ссылка на оплату не найдена"})
+	}
+
+	if !cb.Success {
+		if err := config.DB.Model(&link).Update("last_error", cb.Reason).Error; err != nil {
+			return finish(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить отказ"})
+		}
+		return finish(http.StatusOK, gin.H{"status": "ok", "message": "Отказ в оплате зафиксирован"})
+	}
+
+	amount := cb.Amount
+	if amount <= 0 {
+		amount = link.Amount
+	}
+	externalID := entry.ExternalID
+	var payment models.LedgerEntry
+	var allocation AllocationResult
+	duplicate := false
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&link, link.ID).Error; err != nil {
+			return err
+		}
+		if link.Status == models.PaymentLinkPaid && link.PaymentEntryID != nil {
+			duplicate = true
+			return tx.First(&payment, *link.PaymentEntryID).Error
+		}
+		if err := tx.Where("external_id = ?", externalID).First(&payment).Error; err == nil {
+			duplicate = true
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Оплата по отмененной или просроченной ссылке все равно зачисляется: деньги уже списаны у родителя.
+		payment = models.LedgerEntry{
+			ContractID:    link.ContractID,
+			EntryDate:     dateOnly(cb.PaidAt.Local()),
+			Description:   link.Description,
+			SourceType:    models.LedgerSourcePaymentFact,
+			PaymentMethod: gateway.Title(),
+			ExternalID:    &externalID,
+		}
+		var err error
+		if allocation, _, err = recordPayment(tx, &payment, amount, nil, nil); err != nil {
+			return err
+		}
+
+		paidAt := cb.PaidAt
+		link.Status = models.PaymentLinkPaid
+		link.PaidAt = &paidAt
+		link.PaymentEntryID = &payment.ID
+		link.LastError = ""
+		if cb.ExternalID != "" {
+			link.ExternalID = cb.ExternalID
+		}
+		return tx.Save(&link).Error
+	})
+	if err != nil {
+		return finish(http.StatusInternalServerError, gin.H{"error": "Не удалось зачислить платеж: " + err.Error()})
+	}
+	if duplicate {
+		return finish(http.StatusOK, gin.H{"status": "ok", "message": "Платеж уже был зачислен ранее", "paymentId": payment.ID})
+	}
+	go renderSourceReceipts(models.AllocationSourceLedgerEntry, payment.ID)
+
+	return finish(http.StatusOK, gin.H{
+		"status":      "ok",
+		"message":     "Платеж успешно зачислен",
+		"paymentId":   payment.ID,
+		"allocations": allocation.Allocations,
+		"unallocated": allocation.Unallocated,
+	})
+}
+
+// redactGatewayPayload убирает из JSON-уведомления шлюза secret_hash: Halyk возвращает в нем
+// секрет из настроек, и он не должен храниться в журнале вебхуков. Тело не в JSON и без
+// secret_hash возвращается как есть.
+func redactGatewayPayload(body []byte) []byte {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return body
+	}
+	if _, ok := payload["secret_hash"]; !ok {
+		return body
+	}
+	delete(payload, "secret_hash")
+	redacted, err := json.Marshal(payload)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// GetPaymentGatewaySettingsHandler получает настройки онлайн-оплаты; секреты маскируются.
+func GetPaymentGatewaySettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, PaymentGatewayService, "secret", "clientSecret")
+}
+
+// SavePaymentGatewaySettingsHandler сохраняет настройки онлайн-оплаты.
+func SavePaymentGatewaySettingsHandler(c *gin.Context) {
+	var payload struct {
+		IsEnabled bool                   `json:"isEnabled"`
+		Settings  PaymentGatewaySettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	keepStoredSecret(PaymentGatewayService, "secret", &payload.Settings.Secret)
+	keepStoredSecret(PaymentGatewayService, "clientSecret", &payload.Settings.ClientSecret)
+	if payload.IsEnabled {
+		if _, ok := paymentGatewayFor(payload.Settings.Provider); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите платежный шлюз: halyk или fake"})
+			return
+		}
+		if payload.Settings.Secret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Для включения необходимо указать секрет для проверки уведомлений"})
+			return
+		}
+	}
+	saveIntegrationSettings(c, PaymentGatewayService, payload.IsEnabled, payload.Settings)
+}
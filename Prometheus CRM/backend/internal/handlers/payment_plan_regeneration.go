@@ -0,0 +1,260 @@
+// prometheus-crm/internal/handlers/payment_plan_regeneration.go
+package handlers
+
+import (
+	"fmt"
+	"prometheus-crm/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Действия над строками графика при пересоздании плана.
+const (
+	PlanDiffKept    = "kept"
+	PlanDiffChanged = "changed"
+	PlanDiffNew     = "new"
+	PlanDiffRemoved = "removed"
+)
+
+// PlanDiffRow - строка превью пересоздания плана: что произойдет с одной строкой графика.
+type PlanDiffRow struct {
+	Action string `json:"action"`
+	// PlannedPaymentID - существующая строка графика (пусто для новых строк).
+	PlannedPaymentID *uint     `json:"plannedPaymentId,omitempty"`
+	PaymentName      string    `json:"paymentName"`
+	PaymentDate      time.Time `json:"paymentDate"`
+	// OldAmount - плановая сумма до пересоздания (только для существующих строк).
+	OldAmount  *models.Money `json:"oldAmount,omitempty"`
+	Amount     models.Money  `json:"amount"`
+	PaidAmount models.Money  `json:"paidAmount"`
+}
+
+// PlanRegeneration - результат пересоздания плана: итоги и построчная разница.
+type PlanRegeneration struct {
+	PaymentFormID uint `json:"paymentFormId"`
+	// TargetAmount - сумма к оплате по договору (с учетом скидок).
+	TargetAmount models.Money `json:"targetAmount"`
+	// PaidAmount - сумма, уже распределенная на сохраняемые строки графика.
+	PaidAmount models.Money `json:"paidAmount"`
+	// RemainingAmount - остаток, распределенный по будущим платежам новой формы.
+	RemainingAmount models.Money  `json:"remainingAmount"`
+	Rows            []PlanDiffRow `json:"rows"`
+}
+
+// evaluatePaymentFormSchedule рассчитывает строки графика по формулам формы оплаты без сохранения.
+// Год каждого платежа берется из учебного года договора (см. AcademicYear.DateInYear),
+// поэтому превью, сохраненный план и график в тексте договора совпадают.
+// Суммы платежей округляются до тиына так, что их итог равен сумме договора со скидкой
+// (см. roundInstallments).
+func evaluatePaymentFormSchedule(contract *models.Contract, form *models.PaymentForm, year *models.AcademicYear) ([]models.PlannedPayment, error) {
+	schedule := make([]models.PlannedPayment, 0, len(form.Installments))
+	raw := make([]float64, 0, len(form.Installments))
+	for i, installment := range form.Installments {
+		var paymentDate time.Time
+		amount, err := evaluatePaymentInstallment(installment, contract.TotalAmount, contract.DiscountedAmount,
+			len(form.Installments), i+1, year, &paymentDate)
+		if err != nil {
+			return nil, fmt.Errorf("платеж %d: %w", i+1, err)
+		}
+
+		raw = append(raw, amount)
+		schedule = append(schedule, models.PlannedPayment{
+			ContractID:  contract.ID,
+			PaymentName: fmt.Sprintf("Платеж за %s", installment.Month),
+			PaymentDate: paymentDate,
+			Status:      PlannedStatusPending,
+		})
+	}
+	for i, amount := range roundInstallments(raw, contract.DiscountedAmount) {
+		schedule[i].PlannedAmount = amount
+	}
+	return schedule, nil
+}
+
+// buildPlanRegeneration строит новый план договора по форме оплаты, сохраняя оплаченную историю.
+//
+// Строки с оплатой остаются в графике: полностью оплаченные - без изменений, частично оплаченные
+// уменьшаются до оплаченной суммы. Остаток суммы договора распределяется по платежам новой формы
+// с датой не раньше asOf пропорционально их формулам. Неоплаченные строки старого плана
+// обновляются, если в новом плане есть платеж на ту же дату, иначе удаляются.
+// Функция ничего не меняет в БД; применить результат можно через applyPlanRegeneration.
+func buildPlanRegeneration(tx *gorm.DB, contract *models.Contract, form *models.PaymentForm, asOf time.Time) (*PlanRegeneration, error) {
+	if contract.TerminatedAt != nil {
+		return nil, errContractTerminated
+	}
+	year, err := academicYearForContract(tx, contract)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := evaluatePaymentFormSchedule(contract, form, year)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []models.PlannedPayment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("contract_id = ?", contract.ID).
+		Order("payment_date ASC, id ASC").
+		Find(&existing).Error; err != nil {
+		return nil, fmt.Errorf("не удалось загрузить текущий план платежей: %w", err)
+	}
+
+	plan := &PlanRegeneration{
+		PaymentFormID: form.ID,
+		TargetAmount:  contract.DiscountedAmount,
+		Rows:          make([]PlanDiffRow, 0, len(existing)+len(schedule)),
+	}
+
+	var unpaid []models.PlannedPayment
+	for _, row := range existing {
+		if row.PaidAmount <= 0 {
+			unpaid = append(unpaid, row)
+			continue
+		}
+		id, oldAmount := row.ID, row.PlannedAmount
+		diff := PlanDiffRow{
+			Action:           PlanDiffKept,
+			PlannedPaymentID: &id,
+			PaymentName:      row.PaymentName,
+			PaymentDate:      row.PaymentDate,
+			OldAmount:        &oldAmount,
+			Amount:           row.PlannedAmount,
+			PaidAmount:       row.PaidAmount,
+		}
+		if row.PaidAmount < row.PlannedAmount {
+			diff.Action = PlanDiffChanged
+			diff.Amount = row.PaidAmount
+		}
+		plan.PaidAmount += row.PaidAmount
+		plan.Rows = append(plan.Rows, diff)
+	}
+	plan.RemainingAmount = models.MaxMoney(plan.TargetAmount-plan.PaidAmount, 0)
+
+	asOf = time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+	var future []models.PlannedPayment
+	for _, p := range schedule {
+		if !p.PaymentDate.Before(asOf) {
+			future = append(future, p)
+		}
+	}
+	if len(future) == 0 && plan.RemainingAmount > 0 {
+		future = append(future, models.PlannedPayment{
+			ContractID:  contract.ID,
+			PaymentName: "Остаток по договору",
+			PaymentDate: asOf,
+		})
+	}
+	if plan.RemainingAmount <= 0 {
+		future = nil
+	}
+	distributeRemaining(future, plan.RemainingAmount)
+
+	// Неоплаченные строки старого плана переиспользуются для новых платежей на ту же дату.
+	unpaidByDate := make(map[string][]models.PlannedPayment)
+	for _, row := range unpaid {
+		key := row.PaymentDate.Format("2006-01-02")
+		unpaidByDate[key] = append(unpaidByDate[key], row)
+	}
+	for _, p := range future {
+		key := p.PaymentDate.Format("2006-01-02")
+		diff := PlanDiffRow{
+			Action:      PlanDiffNew,
+			PaymentName: p.PaymentName,
+			PaymentDate: p.PaymentDate,
+			Amount:      p.PlannedAmount,
+		}
+		if rows := unpaidByDate[key]; len(rows) > 0 {
+			row := rows[0]
+			unpaidByDate[key] = rows[1:]
+			id, oldAmount := row.ID, row.PlannedAmount
+			diff.PlannedPaymentID = &id
+			diff.OldAmount = &oldAmount
+			diff.Action = PlanDiffChanged
+			if oldAmount == p.PlannedAmount && row.PaymentName == p.PaymentName && row.Status == PlannedStatusPending {
+				diff.Action = PlanDiffKept
+			}
+		}
+		plan.Rows = append(plan.Rows, diff)
+	}
+	for _, row := range unpaid {
+		key := row.PaymentDate.Format("2006-01-02")
+		if !containsPlannedPayment(unpaidByDate[key], row.ID) {
+			continue
+		}
+		id, oldAmount := row.ID, row.PlannedAmount
+		plan.Rows = append(plan.Rows, PlanDiffRow{
+			Action:           PlanDiffRemoved,
+			PlannedPaymentID: &id,
+			PaymentName:      row.PaymentName,
+			PaymentDate:      row.PaymentDate,
+			OldAmount:        &oldAmount,
+		})
+	}
+
+	sort.SliceStable(plan.Rows, func(i, j int) bool {
+		return plan.Rows[i].PaymentDate.Before(plan.Rows[j].PaymentDate)
+	})
+	return plan, nil
+}
+
+// distributeRemaining раскладывает сумму по платежам пропорционально их плановым суммам.
+// Тиыны округления распределяются методом наибольшего остатка, итог совпадает до тиына.
+func distributeRemaining(payments []models.PlannedPayment, remaining models.Money) {
+	weights := make([]float64, len(payments))
+	for i, p := range payments {
+		weights[i] = float64(p.PlannedAmount)
+	}
+	for i, amount := range remaining.Allocate(weights) {
+		payments[i].PlannedAmount = amount
+	}
+}
+
+func containsPlannedPayment(rows []models.PlannedPayment, id uint) bool {
+	for _, r := range rows {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPlanRegeneration сохраняет пересозданный план. Вызывается в той же транзакции,
+// в которой был построен план, чтобы строки графика не изменились между расчетом и записью.
+func applyPlanRegeneration(tx *gorm.DB, contract *models.Contract, plan *PlanRegeneration) error {
+	for _, row := range plan.Rows {
+		switch {
+		case row.Action == PlanDiffRemoved:
+			if err := tx.Delete(&models.PlannedPayment{}, *row.PlannedPaymentID).Error; err != nil {
+				return fmt.Errorf("не удалось удалить строку графика %d: %w", *row.PlannedPaymentID, err)
+			}
+		case row.Action == PlanDiffNew:
+			payment := models.PlannedPayment{
+				ContractID:    contract.ID,
+				PaymentName:   row.PaymentName,
+				PlannedAmount: row.Amount,
+				PaymentDate:   row.PaymentDate,
+				Status:        PlannedStatusPending,
+			}
+			if err := tx.Create(&payment).Error; err != nil {
+				return fmt.Errorf("не удалось сохранить строку графика: %w", err)
+			}
+		case row.Action == PlanDiffChanged:
+			if err := tx.Model(&models.PlannedPayment{}).Where("id = ?", *row.PlannedPaymentID).Updates(map[string]interface{}{
+				"payment_name":   row.PaymentName,
+				"planned_amount": row.Amount,
+				"status":         plannedPaymentStatus(row.Amount, row.PaidAmount),
+			}).Error; err != nil {
+				return fmt.Errorf("не удалось обновить строку графика %d: %w", *row.PlannedPaymentID, err)
+			}
+		}
+	}
+
+	// Если по договору несколько плательщиков, новые строки делятся между ними.
+	if err := splitPlanByPayers(tx, contract.ID); err != nil {
+		return fmt.Errorf("не удалось разделить график между плательщиками: %w", err)
+	}
+	return tx.Model(contract).Update("payment_form_id", plan.PaymentFormID).Error
+}
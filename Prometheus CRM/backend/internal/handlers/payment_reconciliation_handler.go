@@ -1,44 +1,151 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"prometheus-crm/config"
 	"prometheus-crm/models"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
 )
 
 type DebtorResponse struct {
-	ContractID      uint    `json:"contractId"`
-	ContractNumber  string  `json:"contractNumber"`
-	StudentFullName string  `json:"studentFullName"`
-	StudentClass    string  `json:"studentClass"`
-	DebtAmount      float64 `json:"debtAmount"`
-	Comment         string  `json:"comment"`
+	ContractID      uint         `json:"contractId"`
+	ContractNumber  string       `json:"contractNumber"`
+	StudentFullName string       `json:"studentFullName"`
+	StudentClass    string       `json:"studentClass"`
+	ManagerFullName string       `json:"managerFullName"`
+	DebtAmount      models.Money `json:"debtAmount"`    // сальдо по журналу расчетов на сегодня
+	OverdueAmount   models.Money `json:"overdueAmount"` // просрочено по графику на дату отчета
+	Overdue0To30    models.Money `json:"overdue0To30"`
+	Overdue31To60   models.Money `json:"overdue31To60"`
+	Overdue61To90   models.Money `json:"overdue61To90"`
+	OverdueOver90   models.Money `json:"overdueOver90"`
+	OldestDueDate   *string      `json:"oldestDueDate"`
+	MaxDaysOverdue  int          `json:"maxDaysOverdue"`
+	Comment         string       `json:"comment"`
 }
 
-// ListDebtorsHandler возвращает список должников
-func ListDebtorsHandler(c *gin.Context) {
-	var debtors []DebtorResponse
-	var totalRows int64
+// buildDebtorsQuery строит отчет по должникам. Просрочка считается по строкам графика
+// (planned_payments), срок которых наступил до даты отчета и которые не погашены распределениями.
+// Остаток каждой строки попадает в корзину по числу дней просрочки: 0–30, 31–60, 61–90, 90+.
+// Фильтры: class_id, grade, manager_id, учебный год (academic_year_id или year_from/year_to),
+// as_of (дата отчета).
+func buildDebtorsQuery(c *gin.Context) (*gorm.DB, error) {
+	asOf := time.Now().Format("2006-01-02")
+	if v := c.Query("as_of"); v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return nil, fmt.Errorf("неверный формат as_of, ожидается YYYY-MM-DD")
+		}
+		asOf = v
+	}
+
+	overdue := config.DB.Table("planned_payments pp").
+		Select(`
+			pp.contract_id,
+			SUM(pp.planned_amount - pp.paid_amount) AS overdue_amount,
+			SUM(CASE WHEN ?::date - pp.payment_date <= 30 THEN pp.planned_amount - pp.paid_amount ELSE 0 END) AS overdue0_to30,
+			SUM(CASE WHEN ?::date - pp.payment_date BETWEEN 31 AND 60 THEN pp.planned_amount - pp.paid_amount ELSE 0 END) AS overdue31_to60,
+			SUM(CASE WHEN ?::date - pp.payment_date BETWEEN 61 AND 90 THEN pp.planned_amount - pp.paid_amount ELSE 0 END) AS overdue61_to90,
+			SUM(CASE WHEN ?::date - pp.payment_date > 90 THEN pp.planned_amount - pp.paid_amount ELSE 0 END) AS overdue_over90,
+			MIN(pp.payment_date) AS oldest_due_date,
+			MAX(?::date - pp.payment_date) AS max_days_overdue
+		`, asOf, asOf, asOf, asOf, asOf).
+		Where("pp.deleted_at IS NULL").
+		Where("pp.payment_date < ?", asOf).
+		Where("pp.paid_amount < pp.planned_amount").
+		Group("pp.contract_id")
+
+	year, err := debtorsAcademicYear(c)
+	if err != nil {
+		return nil, err
+	}
+	if year != nil {
+		// Договор относится к учебному году по academic_year_id; у старых договоров без года
+		// берутся строки графика в датах самого учебного года.
+		overdue = overdue.Joins("JOIN contracts yc ON yc.id = pp.contract_id").
+			Where("yc.academic_year_id = ? OR (yc.academic_year_id IS NULL AND pp.payment_date BETWEEN ? AND ?)",
+				year.ID, year.StartDate.Format("2006-01-02"), year.EndDate.Format("2006-01-02"))
+	}
 
-	// Запрос, который находит должников
 	query := config.DB.Table("contracts").
 		Select(`
             contracts.id as contract_id,
             contracts.contract_number,
             (s.last_name || ' ' || s.first_name) as student_full_name,
             (COALESCE(cl.grade_number::text, '') || ' ' || COALESCE(clit.liter_char, '')) as student_class,
-            (contracts.discounted_amount - COALESCE((SELECT SUM(amount) FROM payment_facts WHERE contract_id = contracts.id), 0)) as debt_amount,
+            COALESCE(u.full_name, '') as manager_full_name,
+            `+ledgerBalanceSQL+` as debt_amount,
+            od.overdue_amount,
+            od.overdue0_to30,
+            od.overdue31_to60,
+            od.overdue61_to90,
+            od.overdue_over90,
+            TO_CHAR(od.oldest_due_date, 'YYYY-MM-DD') as oldest_due_date,
+            od.max_days_overdue,
 			contracts.comment
         `).
+		Joins("JOIN (?) od ON od.contract_id = contracts.id", overdue).
 		Joins("JOIN students s ON s.id = contracts.student_id").
 		Joins("LEFT JOIN classes cl ON s.class_id = cl.id").
 		Joins("LEFT JOIN class_liters clit ON cl.liter_id = clit.id").
-		Where("(contracts.discounted_amount - COALESCE((SELECT SUM(amount) FROM payment_facts WHERE contract_id = contracts.id), 0)) > 0").
+		Joins("LEFT JOIN users u ON u.id = contracts.manager_id").
+		Where("od.overdue_amount > 0").
 		Where("contracts.deleted_at IS NULL")
 
+	if classID := c.Query("class_id"); classID != "" {
+		query = query.Where("s.class_id = ?", classID)
+	}
+	if grade := c.Query("grade"); grade != "" {
+		query = query.Where("cl.grade_number = ?", grade)
+	}
+	if managerID := c.Query("manager_id"); managerID != "" {
+		query = query.Where("contracts.manager_id = ?", managerID)
+	}
+	if search := c.Query("search"); search != "" {
+		searchPattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(contracts.contract_number) LIKE ? OR LOWER(s.last_name) LIKE ? OR LOWER(s.first_name) LIKE ?", searchPattern, searchPattern, searchPattern)
+	}
+
+	return query, nil
+}
+
+// debtorsAcademicYear - учебный год фильтра отчета: academic_year_id или пара year_from/year_to,
+// по которой год ищется в справочнике по названию ("2025-2026"). nil - без фильтра по году.
+func debtorsAcademicYear(c *gin.Context) (*models.AcademicYear, error) {
+	var year models.AcademicYear
+	switch yearFrom, yearTo := c.Query("year_from"), c.Query("year_to"); {
+	case c.Query("academic_year_id") != "":
+		if err := config.DB.First(&year, c.Query("academic_year_id")).Error; err != nil {
+			return nil, fmt.Errorf("учебный год не найден")
+		}
+	case yearFrom != "" && yearTo != "":
+		name := yearFrom + "-" + yearTo
+		if err := config.DB.Where("name = ?", name).First(&year).Error; err != nil {
+			return nil, fmt.Errorf("учебный год %s не найден в справочнике", name)
+		}
+	default:
+		return nil, nil
+	}
+	return &year, nil
+}
+
+// ListDebtorsHandler возвращает список должников с разбивкой просрочки по срокам
+func ListDebtorsHandler(c *gin.Context) {
+	var debtors []DebtorResponse
+	var totalRows int64
+
+	query, err := buildDebtorsQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Считаем общее количество для пагинации
 	if err := query.Count(&totalRows).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count debtors"})
@@ -46,7 +153,7 @@ func ListDebtorsHandler(c *gin.Context) {
 	}
 
 	// Применяем пагинацию
-	paginatedQuery := query.Scopes(Paginate(c)).Order("debt_amount DESC") // Сортируем по убыванию долга
+	paginatedQuery := query.Scopes(Paginate(c)).Order("overdue_amount DESC") // Сортируем по убыванию просрочки
 
 	if err := paginatedQuery.Scan(&debtors).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch debtors"})
@@ -60,6 +167,69 @@ func ListDebtorsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, CreatePaginatedResponse(c, debtors, totalRows))
 }
 
+// ExportDebtorsHandler выгружает отчет по должникам в Excel с теми же фильтрами, что и список
+func ExportDebtorsHandler(c *gin.Context) {
+	var debtors []DebtorResponse
+
+	query, err := buildDebtorsQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := query.Order("overdue_amount DESC").Scan(&debtors).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data for export"})
+		return
+	}
+
+	f := excelize.NewFile()
+	sheetName := "Должники"
+	index, _ := f.NewSheet(sheetName)
+	f.SetActiveSheet(index)
+
+	headers := []string{"Номер договора", "ФИО ученика", "Класс", "Менеджер", "Сальдо", "Просрочено всего", "0–30 дней", "31–60 дней", "61–90 дней", "Более 90 дней", "Самая ранняя дата", "Дней просрочки", "Комментарий"}
+	for i, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheetName, cell, header)
+	}
+
+	var totals [6]models.Money
+	for i, d := range debtors {
+		row := i + 2
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), d.ContractNumber)
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), d.StudentFullName)
+		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), d.StudentClass)
+		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), d.ManagerFullName)
+		if d.OldestDueDate != nil {
+			if t, err := time.Parse("2006-01-02", *d.OldestDueDate); err == nil {
+				f.SetCellValue(sheetName, fmt.Sprintf("K%d", row), t.Format("02.01.2006"))
+			}
+		}
+		f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), d.MaxDaysOverdue)
+		f.SetCellValue(sheetName, fmt.Sprintf("M%d", row), d.Comment)
+
+		for j, v := range []models.Money{d.DebtAmount, d.OverdueAmount, d.Overdue0To30, d.Overdue31To60, d.Overdue61To90, d.OverdueOver90} {
+			cell, _ := excelize.CoordinatesToCellName(5+j, row)
+			setMoneyCell(f, sheetName, cell, v)
+			totals[j] += v
+		}
+	}
+
+	// Итоговая строка по корзинам
+	totalRow := len(debtors) + 2
+	f.SetCellValue(sheetName, fmt.Sprintf("A%d", totalRow), "Итого")
+	for j, v := range totals {
+		cell, _ := excelize.CoordinatesToCellName(5+j, totalRow)
+		setMoneyCell(f, sheetName, cell, v)
+	}
+
+	fileName := fmt.Sprintf("debtors_%s.xlsx", time.Now().Format("20060102_150405"))
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	if err := f.Write(c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write Excel file"})
+	}
+}
+
 // UpdateContractCommentHandler обновляет комментарий к договору
 func UpdateContractCommentHandler(c *gin.Context) {
 	contractID, err := strconv.Atoi(c.Param("id"))
@@ -6,17 +6,15 @@ import (
 	"prometheus-crm/config"
 	"prometheus-crm/models"
 	"strconv"
-	"time"
 
-	"github.com/Knetic/govaluate"
 	"github.com/gin-gonic/gin"
 )
 
 // Payment представляет один платеж в сгенерированном графике.
 type Payment struct {
-	PaymentDate string  `json:"paymentDate"`
-	Amount      float64 `json:"amount"`
-	Status      string  `json:"status"`
+	PaymentDate string       `json:"paymentDate"`
+	Amount      models.Money `json:"amount"`
+	Status      string       `json:"status"`
 }
 
 // GenerateScheduleHandler генерирует график платежей на основе договора и его формы оплаты.
@@ -40,51 +38,23 @@ func GenerateScheduleHandler(c *gin.Context) {
 		return
 	}
 
-	var schedule []Payment
-
-	// StartDate — указатель: аккуратно работаем с nil.
-	contractYear := time.Now().Year()
-	if contract.StartDate != nil {
-		contractYear = contract.StartDate.Year()
+	year, err := academicYearForContract(config.DB, &contract)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	planned, err := evaluatePaymentFormSchedule(&contract, contract.PaymentForm, year)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Готовим параметры для формул один раз.
-	parameters := make(map[string]interface{})
-	parameters["Сумма"] = contract.TotalAmount
-	parameters["Сумма с учётом скидки"] = contract.DiscountedAmount
-	parameters["Скидка"] = contract.TotalAmount - contract.DiscountedAmount
-
-	for _, installment := range contract.PaymentForm.Installments {
-		expr, err := govaluate.NewEvaluableExpression(installment.Formula)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка в формуле платежа: " + installment.Formula})
-			return
-		}
-
-		result, err := expr.Evaluate(parameters)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось вычислить формулу: " + installment.Formula})
-			return
-		}
-
-		amount, ok := result.(float64)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Результат формулы не является числом"})
-			return
-		}
-
-		monthIndex := getMonthIndex(installment.Month) // функция из handler_utils.go
-		paymentDate := time.Date(contractYear, time.Month(monthIndex+1), installment.Day, 0, 0, 0, 0, time.UTC)
-
-		// Если дата платежа попадает раньше даты начала договора — переносим на следующий год.
-		if contract.StartDate != nil && paymentDate.Before(*contract.StartDate) {
-			paymentDate = paymentDate.AddDate(1, 0, 0)
-		}
-
+	schedule := make([]Payment, 0, len(planned))
+	for _, p := range planned {
 		schedule = append(schedule, Payment{
-			PaymentDate: paymentDate.Format("02.01.2006"),
-			Amount:      amount,
-			Status:      "Ожидается",
+			PaymentDate: p.PaymentDate.Format("02.01.2006"),
+			Amount:      p.PlannedAmount,
+			Status:      p.Status,
 		})
 	}
 
@@ -9,14 +9,15 @@ import (
 	"strings"
 	"time"
 
-	"github.com/Knetic/govaluate"
 	"github.com/gin-gonic/gin"
 	"github.com/xuri/excelize/v2"
 	"gorm.io/gorm"
 )
 
-// GeneratePaymentPlanForContractHandler генерирует план платежей для договора на основе выбранной формы оплаты.
-// Эта функция является ядром автоматического создания графика.
+// GeneratePaymentPlanForContractHandler пересоздает план платежей договора по выбранной форме оплаты.
+// Оплаченные и частично оплаченные строки сохраняются, остаток суммы договора распределяется
+// по будущим платежам новой формы (см. buildPlanRegeneration). Превью той же операции
+// возвращает PreviewPaymentPlanHandler.
 func GeneratePaymentPlanForContractHandler(c *gin.Context) {
 	contractID := c.Param("id")
 	var body struct {
@@ -31,7 +32,6 @@ func GeneratePaymentPlanForContractHandler(c *gin.Context) {
 	var contract models.Contract
 	var paymentForm models.PaymentForm
 
-	// 1. Находим договор и форму оплаты
 	if err := config.DB.First(&contract, contractID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
 		return
@@ -41,99 +41,25 @@ func GeneratePaymentPlanForContractHandler(c *gin.Context) {
 		return
 	}
 
-	// 2. Начинаем транзакцию
-	tx := config.DB.Begin()
-	if tx.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось начать транзакцию"})
-		return
-	}
-
-	// 3. Удаляем старый план для этого договора
-	if err := tx.Where("contract_id = ?", contract.ID).Delete(&models.PlannedPayment{}).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить старый план платежей"})
-		return
-	}
-
-	// 4. Готовим параметры для вычисления формул
-	parameters := make(map[string]interface{})
-	parameters["Сумма"] = contract.TotalAmount
-	parameters["Сумма с учётом скидки"] = contract.DiscountedAmount
-	parameters["Скидка"] = contract.TotalAmount - contract.DiscountedAmount
-
-	contractYear := contract.StartDate.Year()
-	var newPayments []models.PlannedPayment
-
-	// 5. Генерируем новые записи плана
-	for _, installment := range paymentForm.Installments {
-		expression, err := govaluate.NewEvaluableExpression(installment.Formula)
-		if err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Ошибка в формуле '%s': %v", installment.Formula, err)})
-			return
-		}
-
-		result, err := expression.Evaluate(parameters)
-		if err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Не удалось вычислить формулу: %v", err)})
-			return
-		}
-
-		amount, ok := result.(float64)
-		if !ok {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Результат формулы не является числом"})
-			return
-		}
-
-		// === ИСПРАВЛЕННАЯ ЛОГИКА ОПРЕДЕЛЕНИЯ ГОДА ===
-		monthIndex := getMonthIndex(installment.Month)
-		paymentMonth := time.Month(monthIndex + 1)
-
-		year := contractYear
-		// Если месяц платежа до начала условного учебного года (до июня),
-		// то он относится к следующему календарному году (например, апрель 2026).
-		// Летние предоплаты (июнь, июль, август) будут относиться к текущему году контракта (2025).
-		if paymentMonth < time.June {
-			year = contractYear + 1
-		}
-
-		paymentDate := time.Date(year, paymentMonth, installment.Day, 0, 0, 0, 0, time.UTC)
-		// === КОНЕЦ ИСПРАВЛЕНИЯ ===
-
-		newPayment := models.PlannedPayment{
-			ContractID:    contract.ID,
-			PaymentName:   fmt.Sprintf("Платеж за %s", installment.Month),
-			PlannedAmount: amount,
-			PaymentDate:   paymentDate,
-		}
-		newPayments = append(newPayments, newPayment)
-	}
-
-	// 6. Сохраняем все новые записи разом
-	if len(newPayments) > 0 {
-		if err := tx.Create(&newPayments).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить новый план платежей"})
-			return
+	var plan *PlanRegeneration
+	var buildErr error
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		plan, buildErr = buildPlanRegeneration(tx, &contract, &paymentForm, time.Now())
+		if buildErr != nil {
+			return buildErr
 		}
-	}
-
-	// 7. Обновляем ID формы оплаты в самом договоре
-	if err := tx.Model(&contract).Update("payment_form_id", paymentForm.ID).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось обновить форму оплаты в договоре"})
+		return applyPlanRegeneration(tx, &contract, plan)
+	})
+	if buildErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": buildErr.Error()})
 		return
 	}
-
-	// 8. Завершаем транзакцию
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось завершить транзакцию"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить план платежей: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "План платежей успешно сгенерирован"})
+	c.JSON(http.StatusOK, gin.H{"message": "План платежей успешно сгенерирован", "plan": plan})
 }
 
 // PlannedPaymentListItem - структура для отображения данных в списке на фронтенде.
@@ -262,6 +188,10 @@ func UpdatePlannedPaymentHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := checkContractPayer(config.DB, plannedPayment.ContractID, input.PayerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	if err := config.DB.Model(&plannedPayment).Updates(input).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update planned payment"})
@@ -343,7 +273,7 @@ func ExportPlannedPaymentsHandler(c *gin.Context) {
 		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), p.ContractNumber)
 		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), p.StudentFullName)
 		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), p.StudentClass)
-		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), p.PlannedAmount)
+		setMoneyCell(f, sheetName, fmt.Sprintf("D%d", row), p.PlannedAmount)
 		if !p.PaymentDate.IsZero() {
 			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), p.PaymentDate.Format("02.01.2006"))
 		}
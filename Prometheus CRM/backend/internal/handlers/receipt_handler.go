@@ -0,0 +1,490 @@
+// prometheus-crm/internal/handlers/receipt_handler.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ReceiptService - реквизиты школы для квитанций в integration_settings.
+const ReceiptService = "receipts"
+
+// ReceiptSettings - реквизиты получателя платежа, печатаемые в квитанции.
+type ReceiptSettings struct {
+	SchoolName string `json:"schoolName"`
+	BIN        string `json:"bin"`
+	Address    string `json:"address"`
+	Phone      string `json:"phone"`
+	BankName   string `json:"bankName"`
+	IIK        string `json:"iik"`
+	BIK        string `json:"bik"`
+	// Signer - должность и ФИО лица, выдающего квитанцию ("Бухгалтер Иванова А.А.").
+	Signer string `json:"signer"`
+}
+
+// ReceiptResponse - квитанция со сведениями о договоре для списка.
+type ReceiptResponse struct {
+	models.Receipt
+	ContractNumber string `json:"contractNumber"`
+	StudentName    string `json:"studentName"`
+	HasPDF         bool   `json:"hasPdf"`
+}
+
+var errReceiptSourceNotFound = errors.New("платеж, по которому выдана квитанция, не найден")
+
+// loadReceiptSettings читает реквизиты школы; незаполненные поля остаются пустыми.
+func loadReceiptSettings(tx *gorm.DB) ReceiptSettings {
+	var settings ReceiptSettings
+	var setting models.IntegrationSetting
+	if err := tx.Where("service_name = ?", ReceiptService).First(&setting).Error; err != nil {
+		return settings
+	}
+	raw, _ := json.Marshal(setting.Settings)
+	_ = json.Unmarshal(raw, &settings)
+	return settings
+}
+
+// receiptFromSource заполняет квитанцию данными платежа-источника (проводки-оплаты журнала).
+func receiptFromSource(tx *gorm.DB, sourceType string, sourceID uint) (models.Receipt, error) {
+	receipt := models.Receipt{SourceType: sourceType, SourceID: sourceID, Status: models.ReceiptStatusIssued}
+	switch sourceType {
+	case models.AllocationSourceLedgerEntry:
+		var payment models.LedgerEntry
+		if err := tx.Where("id = ? AND entry_type = ?", sourceID, models.LedgerPayment).First(&payment).Error; err != nil {
+			return receipt, errReceiptSourceNotFound
+		}
+		receipt.ContractID = payment.ContractID
+		receipt.Amount = -payment.Amount
+		receipt.PaymentDate = payment.EntryDate
+		receipt.PaymentName = payment.Description
+		receipt.PaymentMethod = payment.PaymentMethod
+		receipt.PayerID = payment.PayerID
+	default:
+		return receipt, fmt.Errorf("квитанции не выдаются для платежей типа %s", sourceType)
+	}
+	if receipt.PaymentName == "" {
+		receipt.PaymentName = "Оплата за обучение"
+	}
+	return receipt, nil
+}
+
+// issueReceipt выдает квитанцию на платеж в транзакции, в которой платеж записан
+// в журнал расчетов, чтобы остаток по договору учитывал этот платеж.
+func issueReceipt(tx *gorm.DB, sourceType string, sourceID uint, replacesID, issuedByID *uint) (*models.Receipt, error) {
+	receipt, err := receiptFromSource(tx, sourceType, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.RemainingBalance, err = receiptRemainingBalance(tx, receipt.ContractID, receipt.PayerID); err != nil {
+		return nil, err
+	}
+
+	var contract models.Contract
+	if err := tx.First(&contract, receipt.ContractID).Error; err != nil {
+		return nil, fmt.Errorf("договор квитанции не найден: %w", err)
+	}
+	number, err := allocateDocumentNumber(tx, models.DocumentReceipt, NumberingContext{Date: time.Now(), Contract: &contract})
+	if err != nil {
+		return nil, err
+	}
+	receipt.Number, receipt.Year, receipt.Sequence = number.Number, number.Year, number.Sequence
+	receipt.ReplacesID = replacesID
+	receipt.IssuedByID = issuedByID
+	if err := tx.Create(&receipt).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("номер квитанции %s уже занят - проверьте счетчик нумерации квитанций", receipt.Number)
+		}
+		return nil, fmt.Errorf("не удалось сохранить квитанцию: %w", err)
+	}
+	return &receipt, nil
+}
+
+// annulReceipts аннулирует действующие квитанции платежа (при исправлении или удалении).
+// Возвращает аннулированные квитанции, чтобы новая квитанция могла сослаться на заменяемую.
+func annulReceipts(tx *gorm.DB, sourceType string, sourceID uint, reason string) ([]models.Receipt, error) {
+	var receipts []models.Receipt
+	if err := tx.Where("source_type = ? AND source_id = ? AND status = ?", sourceType, sourceID, models.ReceiptStatusIssued).
+		Order("id").Find(&receipts).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range receipts {
+		receipts[i].Status = models.ReceiptStatusAnnulled
+		receipts[i].AnnulledAt = &now
+		receipts[i].AnnulReason = reason
+		receipts[i].PDFFilePath = ""
+		if err := tx.Model(&receipts[i]).Updates(map[string]interface{}{
+			"status":       models.ReceiptStatusAnnulled,
+			"annulled_at":  now,
+			"annul_reason": reason,
+			// PDF перепечатается при скачивании уже с отметкой об аннулировании.
+			"pdf_path": "",
+		}).Error; err != nil {
+			return nil, fmt.Errorf("не удалось аннулировать квитанцию %s: %w", receipts[i].Number, err)
+		}
+	}
+	return receipts, nil
+}
+
+// reissueReceipt аннулирует действующую квитанцию платежа и выдает новую с актуальными данными.
+func reissueReceipt(tx *gorm.DB, sourceType string, sourceID uint, reason string, issuedByID *uint) (*models.Receipt, error) {
+	annulled, err := annulReceipts(tx, sourceType, sourceID, reason)
+	if err != nil {
+		return nil, err
+	}
+	var replacesID *uint
+	if len(annulled) > 0 {
+		replacesID = &annulled[len(annulled)-1].ID
+	}
+	return issueReceipt(tx, sourceType, sourceID, replacesID, issuedByID)
+}
+
+// receiptRemainingBalance - остаток, печатаемый в квитанции: по доле плательщика,
+// если квитанция выдана плательщику договора, иначе по договору в целом.
+func receiptRemainingBalance(tx *gorm.DB, contractID uint, payerID *uint) (models.Money, error) {
+	if payerID != nil {
+		balance, err := getPayerBalance(tx, contractID, *payerID)
+		return balance.Balance, err
+	}
+	balance, err := getContractBalance(tx, contractID)
+	return balance.Balance, err
+}
+
+// refreshReceiptBalance обновляет остаток в еще не напечатанной квитанции платежа,
+// если после платежа в той же транзакции изменился баланс договора (например, скидка за раннюю оплату).
+func refreshReceiptBalance(tx *gorm.DB, sourceType string, sourceID, contractID uint) error {
+	var receipts []models.Receipt
+	if err := tx.Where("source_type = ? AND source_id = ? AND status = ? AND (pdf_path IS NULL OR pdf_path = '')",
+		sourceType, sourceID, models.ReceiptStatusIssued).
+		Find(&receipts).Error; err != nil {
+		return err
+	}
+	for _, receipt := range receipts {
+		remaining, err := receiptRemainingBalance(tx, contractID, receipt.PayerID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Receipt{}).Where("id = ?", receipt.ID).
+			Update("remaining_balance", remaining).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiptTemplate - печатная форма квитанции (A5, альбомная).
+var receiptTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<style>
+	@page { size: A5 landscape; margin: 12mm; }
+	body { font-family: "DejaVu Sans", Arial, sans-serif; font-size: 11pt; color: #000; }
+	h1 { font-size: 15pt; text-align: center; margin: 0 0 4mm; }
+	.annulled { color: #b00; text-align: center; font-weight: bold; font-size: 13pt; }
+	.requisites { font-size: 9pt; margin-bottom: 5mm; }
+	table { width: 100%; border-collapse: collapse; }
+	td { padding: 1.5mm 2mm; vertical-align: top; border-bottom: 1px solid #ccc; }
+	td.label { width: 40%; color: #444; }
+	.amount { font-weight: bold; }
+	.signature { margin-top: 10mm; display: flex; justify-content: space-between; }
+</style>
+</head>
+<body>
+	<div class="requisites">
+		<strong>{{.Settings.SchoolName}}</strong>{{if .Settings.BIN}}, БИН {{.Settings.BIN}}{{end}}<br>
+		{{if .Settings.Address}}{{.Settings.Address}}{{end}}{{if .Settings.Phone}}, тел. {{.Settings.Phone}}{{end}}<br>
+		{{if .Settings.BankName}}{{.Settings.BankName}}{{end}}{{if .Settings.IIK}}, ИИК {{.Settings.IIK}}{{end}}{{if .Settings.BIK}}, БИК {{.Settings.BIK}}{{end}}
+	</div>
+	<h1>КВИТАНЦИЯ № {{.Receipt.Number}}</h1>
+	{{if .Annulled}}<p class="annulled">АННУЛИРОВАНА{{if .Receipt.AnnulReason}}: {{.Receipt.AnnulReason}}{{end}}</p>{{end}}
+	<table>
+		<tr><td class="label">Дата оплаты</td><td>{{.PaymentDate}}</td></tr>
+		<tr><td class="label">Договор</td><td>{{.ContractNumber}}</td></tr>
+		<tr><td class="label">Обучающийся</td><td>{{.StudentName}}</td></tr>
+		{{if .PayerName}}<tr><td class="label">Плательщик</td><td>{{.PayerName}}</td></tr>{{end}}
+		<tr><td class="label">Назначение платежа</td><td>{{.Receipt.PaymentName}}</td></tr>
+		{{if .Receipt.PaymentMethod}}<tr><td class="label">Способ оплаты</td><td>{{.Receipt.PaymentMethod}}</td></tr>{{end}}
+		<tr><td class="label">Сумма</td><td class="amount">{{.Amount}} тенге</td></tr>
+		<tr><td class="label">Сумма прописью</td><td>{{.AmountInWords}}</td></tr>
+		<tr><td class="label">{{if .Overpaid}}Переплата{{else}}Остаток к оплате{{end}} {{if .PayerShare}}по доле плательщика{{else}}по договору{{end}}</td><td>{{.Remaining}} тенге</td></tr>
+	</table>
+	<div class="signature">
+		<span>Дата выдачи: {{.IssuedAt}}</span>
+		<span>{{if .Settings.Signer}}{{.Settings.Signer}}{{else}}Принял{{end}} ____________</span>
+	</div>
+</body>
+</html>`))
+
+type receiptPrintData struct {
+	Receipt        models.Receipt
+	Settings       ReceiptSettings
+	ContractNumber string
+	StudentName    string
+	PayerName      string
+	PayerShare     bool // остаток указан по доле плательщика, а не по договору
+	PaymentDate    string
+	IssuedAt       string
+	Amount         string
+	AmountInWords  string
+	Remaining      string
+	Overpaid       bool
+	Annulled       bool
+}
+
+// renderReceiptPDF печатает квитанцию в PDF и сохраняет ее рядом с PDF договоров
+// (contractsBaseDir()/receipts/<год>/<номер>.pdf).
+func renderReceiptPDF(tx *gorm.DB, receipt *models.Receipt) error {
+	var contract models.Contract
+	if err := tx.Unscoped().Preload("Student").First(&contract, receipt.ContractID).Error; err != nil {
+		return fmt.Errorf("договор квитанции не найден: %w", err)
+	}
+	data := receiptPrintData{
+		Receipt:        *receipt,
+		Settings:       loadReceiptSettings(tx),
+		ContractNumber: contract.ContractNumber,
+		PaymentDate:    receipt.PaymentDate.Format("02.01.2006"),
+		IssuedAt:       receipt.CreatedAt.Format("02.01.2006"),
+		Amount:         formatMoney(receipt.Amount),
+		AmountInWords:  numberToWords(receipt.Amount),
+		Remaining:      formatMoney(receipt.RemainingBalance.Abs()),
+		Overpaid:       receipt.RemainingBalance < 0,
+		Annulled:       receipt.Status == models.ReceiptStatusAnnulled,
+	}
+	if contract.Student != nil {
+		data.StudentName = strings.TrimSpace(fmt.Sprintf("%s %s %s", contract.Student.LastName, contract.Student.FirstName, contract.Student.MiddleName))
+		data.PayerName = contract.Student.ContractParentName
+	}
+	if receipt.PayerID != nil {
+		var payer models.ContractPayer
+		if err := tx.Unscoped().First(&payer, *receipt.PayerID).Error; err == nil {
+			data.PayerName = payer.Name
+			data.PayerShare = true
+		}
+	}
+
+	var html bytes.Buffer
+	if err := receiptTemplate.Execute(&html, data); err != nil {
+		return fmt.Errorf("ошибка формирования квитанции: %w", err)
+	}
+	pdfBytes, err := convertHTMLToPdf(html.Bytes())
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(contractsBaseDir(), "receipts", strconv.Itoa(receipt.Year))
+	if err := ensureDir(dir); err != nil {
+		return fmt.Errorf("не удалось создать директорию для квитанций: %w", err)
+	}
+	name := regexp.MustCompile(`[^0-9A-Za-z._-]+`).ReplaceAllString(receipt.Number+".pdf", "_")
+	full := filepath.Join(dir, name)
+	if err := os.WriteFile(full, pdfBytes, 0o644); err != nil {
+		return fmt.Errorf("не удалось записать PDF квитанции: %w", err)
+	}
+	receipt.PDFFilePath = full
+	return tx.Model(receipt).Update("pdf_path", full).Error
+}
+
+// renderSourceReceipts печатает еще не напечатанные квитанции платежа.
+// Вызывается после фиксации транзакции (обычно в горутине): ошибка Gotenberg не должна
+// откатывать платеж, а PDF будет допечатан при первом скачивании.
+func renderSourceReceipts(sourceType string, sourceID uint) {
+	var receipts []models.Receipt
+	if err := config.DB.Where("source_type = ? AND source_id = ? AND (pdf_path IS NULL OR pdf_path = '')", sourceType, sourceID).
+		Find(&receipts).Error; err != nil {
+		log.Printf("Квитанции платежа %s/%d: %v", sourceType, sourceID, err)
+		return
+	}
+	for i := range receipts {
+		if err := renderReceiptPDF(config.DB, &receipts[i]); err != nil {
+			log.Printf("Не удалось напечатать квитанцию %s: %v", receipts[i].Number, err)
+		}
+	}
+}
+
+// formatMoney выводит сумму для документов: "1 250 000,50".
+func formatMoney(v models.Money) string {
+	s := v.String()
+	intPart, frac := s[:len(s)-3], s[len(s)-2:]
+	sign := ""
+	if strings.HasPrefix(intPart, "-") {
+		sign, intPart = "-", intPart[1:]
+	}
+	var grouped []string
+	for len(intPart) > 3 {
+		grouped = append([]string{intPart[len(intPart)-3:]}, grouped...)
+		intPart = intPart[:len(intPart)-3]
+	}
+	grouped = append([]string{intPart}, grouped...)
+	return sign + strings.Join(grouped, " ") + "," + frac
+}
+
+// --- Обработчики ---
+
+// ListReceiptsHandler возвращает реестр квитанций с фильтрами по договору, году, статусу и поиском.
+func ListReceiptsHandler(c *gin.Context) {
+	query := config.DB.Table("receipts r").
+		Joins("LEFT JOIN contracts c ON c.id = r.contract_id").
+		Joins("LEFT JOIN students s ON s.id = c.student_id").
+		Where("r.deleted_at IS NULL")
+
+	if contractID := c.Query("contractId"); contractID != "" {
+		query = query.Where("r.contract_id = ?", contractID)
+	}
+	if year := c.Query("year"); year != "" {
+		query = query.Where("r.year = ?", year)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("r.status = ?", status)
+	}
+	if sourceType := c.Query("sourceType"); sourceType != "" {
+		query = query.Where("r.source_type = ?", sourceType)
+		if sourceID := c.Query("sourceId"); sourceID != "" {
+			query = query.Where("r.source_id = ?", sourceID)
+		}
+	}
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		pattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(r.number) LIKE ? OR LOWER(c.contract_number) LIKE ? OR LOWER(s.last_name) LIKE ? OR LOWER(s.first_name) LIKE ?",
+			pattern, pattern, pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать квитанции"})
+		return
+	}
+
+	var rows []struct {
+		models.Receipt
+		ContractNumber string
+		StudentName    string
+	}
+	if err := query.Scopes(Paginate(c)).
+		Select("r.*, c.contract_number AS contract_number, TRIM(COALESCE(s.last_name, '') || ' ' || COALESCE(s.first_name, '')) AS student_name").
+		Order("r.year DESC, r.sequence DESC").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось получить квитанции"})
+		return
+	}
+
+	result := make([]ReceiptResponse, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, ReceiptResponse{
+			Receipt:        row.Receipt,
+			ContractNumber: row.ContractNumber,
+			StudentName:    row.StudentName,
+			HasPDF:         fileExists(row.PDFFilePath),
+		})
+	}
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, result, total))
+}
+
+// DownloadReceiptHandler отдает PDF квитанции; если он еще не напечатан или файл утерян - печатает заново
+// с теми же номером и данными.
+func DownloadReceiptHandler(c *gin.Context) {
+	var receipt models.Receipt
+	if err := config.DB.First(&receipt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Квитанция не найдена"})
+		return
+	}
+	if !fileExists(receipt.PDFFilePath) {
+		if err := renderReceiptPDF(config.DB, &receipt); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Не удалось сформировать PDF квитанции: " + err.Error()})
+			return
+		}
+	}
+
+	data, err := os.ReadFile(receipt.PDFFilePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось прочитать PDF квитанции"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=receipt_"+receipt.Number+".pdf")
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// ReissueReceiptHandler аннулирует квитанцию и выдает вместо нее новую под следующим номером
+// с актуальными данными платежа и остатком по договору.
+func ReissueReceiptHandler(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.ShouldBindJSON(&input)
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		reason = "Переоформление квитанции"
+	}
+
+	var issuedBy *uint
+	if userID, err := getUserIDFromContext(c); err == nil {
+		issuedBy = &userID
+	}
+
+	var receipt *models.Receipt
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var original models.Receipt
+		if err := tx.First(&original, c.Param("id")).Error; err != nil {
+			return err
+		}
+		if original.Status != models.ReceiptStatusIssued {
+			return fmt.Errorf("квитанция %s уже аннулирована", original.Number)
+		}
+		var err error
+		receipt, err = reissueReceipt(tx, original.SourceType, original.SourceID, reason, issuedBy)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Квитанция не найдена"})
+		return
+	case errors.Is(err, errReceiptSourceNotFound):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := renderReceiptPDF(config.DB, receipt); err != nil {
+		log.Printf("Не удалось напечатать квитанцию %s: %v", receipt.Number, err)
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// GetReceiptSettingsHandler получает реквизиты школы для квитанций
+func GetReceiptSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, ReceiptService)
+}
+
+// SaveReceiptSettingsHandler сохраняет реквизиты школы для квитанций
+func SaveReceiptSettingsHandler(c *gin.Context) {
+	var payload struct {
+		Settings ReceiptSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(payload.Settings.SchoolName) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите наименование школы"})
+		return
+	}
+	saveIntegrationSettings(c, ReceiptService, true, payload.Settings)
+}
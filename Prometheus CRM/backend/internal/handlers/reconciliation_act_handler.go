@@ -0,0 +1,508 @@
+// prometheus-crm/internal/handlers/reconciliation_act_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// ReconciliationActClassification - классификация шаблона (contract_templates.classification),
+// по которой выбирается DOCX-шаблон акта сверки. Если такого шаблона нет, используется встроенный.
+const ReconciliationActClassification = "Акт сверки"
+
+// Плейсхолдеры шаблона акта сверки. {actTable} должен стоять в отдельном абзаце:
+// абзац целиком заменяется таблицей операций.
+const actTablePlaceholder = "{actTable}"
+
+// ReconciliationLine - одна операция в акте сверки.
+// Debit увеличивает долг плательщика (начисление, возврат денег), Credit уменьшает его (оплата, скидка).
+type ReconciliationLine struct {
+	Date           time.Time    `json:"date"`
+	ContractNumber string       `json:"contractNumber"`
+	Kind           string       `json:"kind"`
+	Description    string       `json:"description"`
+	Debit          models.Money `json:"debit"`
+	Credit         models.Money `json:"credit"`
+}
+
+// ReconciliationContract - итоги акта по одному договору.
+type ReconciliationContract struct {
+	ContractID     uint         `json:"contractId"`
+	ContractNumber string       `json:"contractNumber"`
+	StudentName    string       `json:"studentName"`
+	OpeningBalance models.Money `json:"openingBalance"`
+	Debit          models.Money `json:"debit"`
+	Credit         models.Money `json:"credit"`
+	ClosingBalance models.Money `json:"closingBalance"`
+}
+
+// ReconciliationAct - акт сверки взаиморасчетов по договору или по семье за период.
+// Сальдо положительное - долг плательщика перед школой, отрицательное - переплата.
+type ReconciliationAct struct {
+	Subject   string                   `json:"subject"`
+	PayerName string                   `json:"payerName"`
+	From      time.Time                `json:"from"`
+	To        time.Time                `json:"to"`
+	Contracts []ReconciliationContract `json:"contracts"`
+	Lines     []ReconciliationLine     `json:"lines"`
+
+	OpeningBalance models.Money `json:"openingBalance"`
+	// Обороты за период по видам операций (сторно относится к виду отменяемой проводки).
+	Charges     models.Money `json:"charges"`
+	Discounts   models.Money `json:"discounts"`
+	Payments    models.Money `json:"payments"`
+	Refunds     models.Money `json:"refunds"`
+	Adjustments models.Money `json:"adjustments"`
+
+	TotalDebit     models.Money `json:"totalDebit"`
+	TotalCredit    models.Money `json:"totalCredit"`
+	ClosingBalance models.Money `json:"closingBalance"`
+}
+
+var ledgerKindNames = map[string]string{
+	models.LedgerCharge:     "Начисление",
+	models.LedgerDiscount:   "Скидка",
+	models.LedgerPayment:    "Оплата",
+	models.LedgerRefund:     "Возврат",
+	models.LedgerAdjustment: "Корректировка",
+}
+
+// buildReconciliationAct строит акт сверки по журналу расчетов: сальдо на начало периода,
+// все проводки периода и сальдо на конец. Начисления и скидки приходят в журнал из цены договора
+// (syncContractCharges), оплаты - из фактических платежей и оплат по договору.
+func buildReconciliationAct(tx *gorm.DB, contracts []models.Contract, from, to time.Time) (*ReconciliationAct, error) {
+	act := &ReconciliationAct{
+		From:      from,
+		To:        to,
+		Contracts: make([]ReconciliationContract, 0, len(contracts)),
+		Lines:     make([]ReconciliationLine, 0),
+	}
+	if len(contracts) == 0 {
+		return act, nil
+	}
+
+	ids := make([]uint, 0, len(contracts))
+	byID := make(map[uint]int, len(contracts))
+	for _, contract := range contracts {
+		summary := ReconciliationContract{ContractID: contract.ID, ContractNumber: contract.ContractNumber}
+		if contract.Student != nil {
+			summary.StudentName = strings.TrimSpace(contract.Student.LastName + " " + contract.Student.FirstName + " " + contract.Student.MiddleName)
+		}
+		byID[contract.ID] = len(act.Contracts)
+		act.Contracts = append(act.Contracts, summary)
+		ids = append(ids, contract.ID)
+	}
+
+	var opening []struct {
+		ContractID uint
+		Total      models.Money
+	}
+	if err := tx.Model(&models.LedgerEntry{}).
+		Select("contract_id, SUM(amount) AS total").
+		Where("contract_id IN ? AND entry_date < ?", ids, from).
+		Group("contract_id").
+		Scan(&opening).Error; err != nil {
+		return nil, fmt.Errorf("не удалось рассчитать сальдо на начало периода: %w", err)
+	}
+	for _, o := range opening {
+		act.Contracts[byID[o.ContractID]].OpeningBalance = o.Total
+		act.OpeningBalance += o.Total
+	}
+
+	var entries []struct {
+		ContractID  uint
+		EntryDate   time.Time
+		Kind        string
+		Reversal    bool
+		Amount      models.Money
+		Description string
+	}
+	if err := tx.Table("ledger_entries e").
+		Select("e.contract_id, e.entry_date, COALESCE(o.entry_type, e.entry_type) AS kind, e.reverses_id IS NOT NULL AS reversal, e.amount, e.description").
+		Joins("LEFT JOIN ledger_entries o ON e.reverses_id = o.id").
+		Where("e.contract_id IN ? AND e.entry_date >= ? AND e.entry_date <= ?", ids, from, to).
+		Order("e.entry_date, e.id").
+		Scan(&entries).Error; err != nil {
+		return nil, fmt.Errorf("не удалось загрузить операции за период: %w", err)
+	}
+
+	for _, e := range entries {
+		summary := &act.Contracts[byID[e.ContractID]]
+		line := ReconciliationLine{
+			Date:           e.EntryDate,
+			ContractNumber: summary.ContractNumber,
+			Kind:           ledgerKindNames[e.Kind],
+			Description:    e.Description,
+		}
+		if e.Reversal {
+			line.Kind = "Сторно: " + strings.ToLower(line.Kind)
+		}
+		if e.Amount >= 0 {
+			line.Debit = e.Amount
+		} else {
+			line.Credit = -e.Amount
+		}
+		summary.Debit += line.Debit
+		summary.Credit += line.Credit
+		act.Lines = append(act.Lines, line)
+
+		switch e.Kind {
+		case models.LedgerCharge:
+			act.Charges += e.Amount
+		case models.LedgerDiscount:
+			act.Discounts -= e.Amount
+		case models.LedgerPayment:
+			act.Payments -= e.Amount
+		case models.LedgerRefund:
+			act.Refunds += e.Amount
+		default:
+			act.Adjustments += e.Amount
+		}
+	}
+
+	for i := range act.Contracts {
+		s := &act.Contracts[i]
+		s.ClosingBalance = s.OpeningBalance + s.Debit - s.Credit
+		act.TotalDebit += s.Debit
+		act.TotalCredit += s.Credit
+	}
+	act.ClosingBalance = act.OpeningBalance + act.TotalDebit - act.TotalCredit
+	return act, nil
+}
+
+// reconciliationPeriod разбирает период акта из параметров from/to (YYYY-MM-DD).
+// По умолчанию - с начала текущего учебного года (или календарного года, если учебный год не настроен) по сегодня.
+func reconciliationPeriod(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	if year, err := currentAcademicYear(config.DB); err == nil {
+		from = year.StartDate
+	}
+
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, errors.New("неверный формат даты from, ожидается YYYY-MM-DD")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, errors.New("неверный формат даты to, ожидается YYYY-MM-DD")
+		}
+	}
+	if to.Before(from) {
+		return from, to, errors.New("дата окончания периода раньше даты начала")
+	}
+	return from, to, nil
+}
+
+// respondReconciliationAct отдает акт в формате из параметра format: json (по умолчанию), pdf или xlsx.
+func respondReconciliationAct(c *gin.Context, act *ReconciliationAct, fileBase string) {
+	fileBase = strings.NewReplacer(" ", "_", "/", "_").Replace(fileBase)
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, act)
+	case "xlsx":
+		f := reconciliationActXLSX(act)
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", "attachment; filename="+fileBase+".xlsx")
+		if err := f.Write(c.Writer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write Excel file"})
+		}
+	case "pdf":
+		var templateID *uint
+		if v := c.Query("templateId"); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный templateId"})
+				return
+			}
+			tid := uint(id)
+			templateID = &tid
+		}
+		pdfBytes, err := reconciliationActPDF(config.DB, act, templateID)
+		if err != nil {
+			c.JSON(documentErrorStatus(err, http.StatusInternalServerError), gin.H{"error": "Не удалось сформировать PDF акта: " + err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename="+fileBase+".pdf")
+		c.Data(http.StatusOK, "application/pdf", pdfBytes)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный формат, допустимы json, pdf, xlsx"})
+	}
+}
+
+// reconciliationActXLSX формирует акт в Excel: шапка, операции и итоги.
+func reconciliationActXLSX(act *ReconciliationAct) *excelize.File {
+	f := excelize.NewFile()
+	sheetName := "Акт сверки"
+	index, _ := f.NewSheet(sheetName)
+	f.SetActiveSheet(index)
+	f.DeleteSheet("Sheet1")
+
+	f.SetCellValue(sheetName, "A1", "Акт сверки взаиморасчетов")
+	f.SetCellValue(sheetName, "A2", act.Subject)
+	f.SetCellValue(sheetName, "A3", fmt.Sprintf("за период с %s по %s", act.From.Format("02.01.2006"), act.To.Format("02.01.2006")))
+	f.SetCellValue(sheetName, "A5", "Сальдо на начало периода")
+	setMoneyCell(f, sheetName, "F5", act.OpeningBalance)
+
+	headers := []string{"Дата", "Договор", "Операция", "Описание", "Дебет (начислено)", "Кредит (оплачено)"}
+	for i, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 7)
+		f.SetCellValue(sheetName, cell, header)
+	}
+	row := 8
+	for _, l := range act.Lines {
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), l.Date.Format("02.01.2006"))
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), l.ContractNumber)
+		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), l.Kind)
+		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), l.Description)
+		setMoneyCell(f, sheetName, fmt.Sprintf("E%d", row), l.Debit)
+		setMoneyCell(f, sheetName, fmt.Sprintf("F%d", row), l.Credit)
+		row++
+	}
+	f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), "Обороты за период")
+	setMoneyCell(f, sheetName, fmt.Sprintf("E%d", row), act.TotalDebit)
+	setMoneyCell(f, sheetName, fmt.Sprintf("F%d", row), act.TotalCredit)
+	row++
+	f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), "Сальдо на конец периода")
+	setMoneyCell(f, sheetName, fmt.Sprintf("F%d", row), act.ClosingBalance)
+	row += 2
+
+	// Итоги по договорам (для акта по семье)
+	for i, header := range []string{"Договор", "Ученик", "Сальдо на начало", "Дебет", "Кредит", "Сальдо на конец"} {
+		cell, _ := excelize.CoordinatesToCellName(i+1, row)
+		f.SetCellValue(sheetName, cell, header)
+	}
+	for _, s := range act.Contracts {
+		row++
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), s.ContractNumber)
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), s.StudentName)
+		setMoneyCell(f, sheetName, fmt.Sprintf("C%d", row), s.OpeningBalance)
+		setMoneyCell(f, sheetName, fmt.Sprintf("D%d", row), s.Debit)
+		setMoneyCell(f, sheetName, fmt.Sprintf("E%d", row), s.Credit)
+		setMoneyCell(f, sheetName, fmt.Sprintf("F%d", row), s.ClosingBalance)
+	}
+	f.SetColWidth(sheetName, "A", "C", 16)
+	f.SetColWidth(sheetName, "D", "D", 45)
+	f.SetColWidth(sheetName, "E", "F", 18)
+	return f
+}
+
+// reconciliationActPDF заполняет DOCX-шаблон акта и конвертирует его в PDF через Gotenberg,
+// как и договоры. Шаблон: указанный templateId, иначе последний шаблон с классификацией
+// ReconciliationActClassification, иначе встроенный.
+func reconciliationActPDF(tx *gorm.DB, act *ReconciliationAct, templateID *uint) ([]byte, error) {
+	var tpl models.ContractTemplate
+	query := tx.Model(&models.ContractTemplate{})
+	if templateID != nil {
+		query = query.Where("id = ?", *templateID)
+	} else {
+		query = query.Where("classification = ?", ReconciliationActClassification).Order("id DESC")
+	}
+
+	var docx []byte
+	err := query.First(&tpl).Error
+	switch {
+	case err == nil:
+		if _, docx, err = loadTemplateVersion(tx, &tpl); err != nil {
+			return nil, fmt.Errorf("ошибка чтения шаблона акта: %w", err)
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound) && templateID == nil:
+		if docx, err = defaultReconciliationActDocx(); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, errors.New("шаблон акта не найден")
+	default:
+		return nil, err
+	}
+
+	filled, err := renderDocxTemplate(docx, reconciliationActTemplateData(tx, act))
+	if err != nil {
+		return nil, err
+	}
+	return convertDocxToPdf(filled)
+}
+
+// reconciliationActTemplateData - значения для шаблона акта: итоги, таблица операций
+// ({actTable}) и строки операций для блока {#actLines}.
+func reconciliationActTemplateData(tx *gorm.DB, act *ReconciliationAct) TemplateData {
+	school := loadReceiptSettings(tx)
+	closingText := "Задолженность отсутствует"
+	switch {
+	case act.ClosingBalance > 0:
+		closingText = fmt.Sprintf("Задолженность плательщика в пользу %s: %s тенге (%s)", school.SchoolName, formatMoney(act.ClosingBalance), numberToWords(act.ClosingBalance))
+	case act.ClosingBalance < 0:
+		closingText = fmt.Sprintf("Переплата плательщика: %s тенге (%s)", formatMoney(-act.ClosingBalance), numberToWords(-act.ClosingBalance))
+	}
+	lines := make([]TemplateData, 0, len(act.Lines))
+	for _, l := range act.Lines {
+		lines = append(lines, TemplateData{
+			"date":           l.Date,
+			"contractNumber": l.ContractNumber,
+			"operation":      l.Kind,
+			"description":    l.Description,
+			"debit":          l.Debit,
+			"credit":         l.Credit,
+		})
+	}
+	return TemplateData{
+		"actSchoolName":     school.SchoolName,
+		"actSchoolBIN":      school.BIN,
+		"actSigner":         school.Signer,
+		"actSubject":        act.Subject,
+		"actPayer":          act.PayerName,
+		"actDateFrom":       act.From,
+		"actDateTo":         act.To,
+		"actDate":           time.Now(),
+		"actOpeningBalance": act.OpeningBalance,
+		"actCharges":        act.Charges,
+		"actDiscounts":      act.Discounts,
+		"actPayments":       act.Payments,
+		"actRefunds":        act.Refunds,
+		"actTotalDebit":     act.TotalDebit,
+		"actTotalCredit":    act.TotalCredit,
+		"actClosingBalance": act.ClosingBalance,
+		"actClosingText":    closingText,
+		"actTable":          DocxFragment(reconciliationActTableXML(act)),
+		"actLines":          lines,
+	}
+}
+
+// reconciliationActTableXML строит таблицу операций в разметке WordprocessingML.
+func reconciliationActTableXML(act *ReconciliationAct) string {
+	var b strings.Builder
+	docxTableStart(&b)
+	row := func(bold bool, cells ...string) { docxTableRow(&b, bold, cells...) }
+	money := func(v models.Money) string {
+		if v == 0 {
+			return ""
+		}
+		return formatMoney(v)
+	}
+
+	row(true, "Дата", "Договор", "Операция", "Дебет", "Кредит")
+	row(true, "Сальдо на "+act.From.Format("02.01.2006"), "", "", money(act.OpeningBalance), "")
+	for _, l := range act.Lines {
+		operation := l.Kind
+		if l.Description != "" {
+			operation += ": " + l.Description
+		}
+		row(false, l.Date.Format("02.01.2006"), l.ContractNumber, operation, money(l.Debit), money(l.Credit))
+	}
+	row(true, "Обороты за период", "", "", formatMoney(act.TotalDebit), formatMoney(act.TotalCredit))
+	row(true, "Сальдо на "+act.To.Format("02.01.2006"), "", "", money(act.ClosingBalance), "")
+	b.WriteString(`</w:tbl>`)
+	return b.String()
+}
+
+// defaultReconciliationActDocx собирает встроенный DOCX-шаблон акта с теми же плейсхолдерами,
+// что доступны в загружаемых шаблонах.
+func defaultReconciliationActDocx() ([]byte, error) {
+	return buildDocx(
+		docxParagraph("АКТ СВЕРКИ ВЗАИМОРАСЧЕТОВ", true, true),
+		docxParagraph("за период с {actDateFrom} по {actDateTo}", false, true),
+		docxParagraph("{actSchoolName}{#actSchoolBIN}, БИН {actSchoolBIN}{/actSchoolBIN}", false, false),
+		docxParagraph("Обучающийся: {actSubject}", false, false),
+		docxParagraph("Плательщик: {actPayer|default:законный представитель}", false, false),
+		docxParagraph(actTablePlaceholder, false, false),
+		docxParagraph("Начислено: {actCharges}; скидки: {actDiscounts}; оплачено: {actPayments}; возвраты: {actRefunds}.", false, false),
+		docxParagraph("{actClosingText}", true, false),
+		docxParagraph("Дата составления: {actDate}", false, false),
+		docxParagraph("От школы: {actSigner|default:} ____________          Плательщик: ____________", false, false),
+	)
+}
+
+// --- Обработчики ---
+
+// GetContractReconciliationActHandler строит акт сверки по одному договору.
+// Параметры: from, to (YYYY-MM-DD), format (json|pdf|xlsx), templateId (для pdf).
+func GetContractReconciliationActHandler(c *gin.Context) {
+	from, to, err := reconciliationPeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var contract models.Contract
+	if err := config.DB.Preload("Student").First(&contract, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+		return
+	}
+
+	act, err := buildReconciliationAct(config.DB, []models.Contract{contract}, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if contract.Student != nil {
+		act.Subject = act.Contracts[0].StudentName
+		act.PayerName = contract.Student.ContractParentName
+	}
+	respondReconciliationAct(c, act, "act_"+contract.ContractNumber)
+}
+
+// GetFamilyReconciliationActHandler строит сводный акт сверки по всем договорам семьи ученика
+// (родственники определяются через findFullFamily).
+func GetFamilyReconciliationActHandler(c *gin.Context) {
+	from, to, err := reconciliationPeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	studentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID ученика"})
+		return
+	}
+	var student models.Student
+	if err := config.DB.First(&student, studentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ученик не найден"})
+		return
+	}
+
+	familyIDs, err := findFullFamily(config.DB, student.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось определить семью ученика"})
+		return
+	}
+	var contracts []models.Contract
+	if err := config.DB.Preload("Student").
+		Where("student_id IN ?", familyIDs).
+		Order("student_id, id").
+		Find(&contracts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить договоры семьи"})
+		return
+	}
+
+	act, err := buildReconciliationAct(config.DB, contracts, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	names := make([]string, 0, len(act.Contracts))
+	seen := make(map[string]bool)
+	for _, s := range act.Contracts {
+		if s.StudentName != "" && !seen[s.StudentName] {
+			seen[s.StudentName] = true
+			names = append(names, s.StudentName)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, strings.TrimSpace(student.LastName+" "+student.FirstName))
+	}
+	act.Subject = strings.Join(names, ", ")
+	act.PayerName = student.ContractParentName
+	respondReconciliationAct(c, act, "act_family_"+strconv.FormatUint(studentID, 10))
+}
@@ -0,0 +1,169 @@
+// prometheus-crm/internal/handlers/reminder_sender.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Сервисы в integration_settings, из которых отправители берут параметры подключения.
+const (
+	SMTPService = "smtp"
+	SMSService  = "sms"
+)
+
+// ReminderMessage - одно сообщение родителю.
+type ReminderMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// ReminderSender доставляет сообщение по одному каналу (email, SMS).
+// Реализации подключаются через RegisterReminderSender, поэтому провайдера можно заменить
+// без изменения логики напоминаний.
+type ReminderSender interface {
+	Send(ctx context.Context, msg ReminderMessage) error
+}
+
+var (
+	reminderSendersMu sync.RWMutex
+	reminderSenders   = map[string]ReminderSender{
+		models.ReminderChannelEmail: smtpEmailSender{},
+		models.ReminderChannelSMS:   httpSMSSender{client: &http.Client{Timeout: 15 * time.Second}},
+	}
+)
+
+// RegisterReminderSender подменяет отправителя для канала (например, другим SMS-шлюзом).
+func RegisterReminderSender(channel string, sender ReminderSender) {
+	reminderSendersMu.Lock()
+	defer reminderSendersMu.Unlock()
+	reminderSenders[channel] = sender
+}
+
+func reminderSenderFor(channel string) (ReminderSender, bool) {
+	reminderSendersMu.RLock()
+	defer reminderSendersMu.RUnlock()
+	s, ok := reminderSenders[channel]
+	return s, ok
+}
+
+// loadEnabledSettings возвращает настройки включенной интеграции.
+func loadEnabledSettings(serviceName string) (models.JSONB, error) {
+	var setting models.IntegrationSetting
+	if err := config.DB.Where("service_name = ?", serviceName).First(&setting).Error; err != nil {
+		return nil, fmt.Errorf("интеграция %s не настроена", serviceName)
+	}
+	if !setting.IsEnabled {
+		return nil, fmt.Errorf("интеграция %s отключена", serviceName)
+	}
+	return setting.Settings, nil
+}
+
+func settingString(s models.JSONB, key string) string {
+	v, _ := s[key].(string)
+	return strings.TrimSpace(v)
+}
+
+// smtpEmailSender отправляет письма через SMTP из настроек интеграции "smtp".
+type smtpEmailSender struct{}
+
+func (smtpEmailSender) Send(_ context.Context, msg ReminderMessage) error {
+	settings, err := loadEnabledSettings(SMTPService)
+	if err != nil {
+		return err
+	}
+	host := settingString(settings, "host")
+	from := settingString(settings, "from")
+	if host == "" || from == "" {
+		return errors.New("в настройках SMTP не указан сервер или адрес отправителя")
+	}
+	port := 587
+	if v, ok := settings["port"].(float64); ok && v > 0 {
+		port = int(v)
+	}
+
+	var auth smtp.Auth
+	if user := settingString(settings, "username"); user != "" {
+		auth = smtp.PlainAuth("", user, settingString(settings, "password"), host)
+	}
+
+	var body bytes.Buffer
+	body.WriteString("From: " + from + "\r\n")
+	body.WriteString("To: " + msg.To + "\r\n")
+	body.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	return smtp.SendMail(host+":"+strconv.Itoa(port), auth, from, []string{msg.To}, body.Bytes())
+}
+
+// httpSMSSender отправляет SMS через HTTP-шлюз из настроек интеграции "sms".
+// Шлюз получает POST с JSON {"recipient", "text", "sender"} и ключом в заголовке Authorization.
+type httpSMSSender struct {
+	client *http.Client
+}
+
+func (s httpSMSSender) Send(ctx context.Context, msg ReminderMessage) error {
+	settings, err := loadEnabledSettings(SMSService)
+	if err != nil {
+		return err
+	}
+	apiURL := settingString(settings, "apiUrl")
+	if apiURL == "" {
+		return errors.New("в настройках SMS не указан адрес шлюза")
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"recipient": normalizePhone(msg.To),
+		"text":      msg.Body,
+		"sender":    settingString(settings, "sender"),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к SMS-шлюзу: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := settingString(settings, "apiKey"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("SMS-шлюз недоступен: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS-шлюз вернул статус %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// normalizePhone оставляет в номере только цифры и приводит 8XXXXXXXXXX к 7XXXXXXXXXX.
+func normalizePhone(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if len(digits) == 11 && digits[0] == '8' {
+		digits = "7" + digits[1:]
+	}
+	return digits
+}
@@ -0,0 +1,477 @@
+// prometheus-crm/internal/handlers/revenue_recognition_handler.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// RevenueMonth - признанная и отложенная выручка за месяц учебного года по всем договорам.
+// Deferred - полученные, но еще не заработанные деньги (доходы будущих периодов) на конец месяца,
+// Receivable - признанная, но не оплаченная выручка. Оба считаются по каждому договору отдельно,
+// переплата одного договора не закрывает долг другого.
+type RevenueMonth struct {
+	Month      string       `json:"month"` // "2025-09"
+	Label      string       `json:"label"` // "Сентябрь 2025"
+	Recognized models.Money `json:"recognized"`
+	// CumulativeRecognized и CumulativeReceived - нарастающим итогом на конец месяца.
+	CumulativeRecognized models.Money `json:"cumulativeRecognized"`
+	CumulativeReceived   models.Money `json:"cumulativeReceived"`
+	Deferred             models.Money `json:"deferred"`
+	Receivable           models.Money `json:"receivable"`
+}
+
+// RevenueGradeRow - признанная выручка параллели по месяцам.
+type RevenueGradeRow struct {
+	Grade  int            `json:"grade"`
+	Label  string         `json:"label"`
+	Months []models.Money `json:"months"`
+	Total  models.Money   `json:"total"`
+}
+
+// RevenueContractRow - график признания выручки по договору и его состояние на дату отчета.
+type RevenueContractRow struct {
+	ContractID      uint      `json:"contractId"`
+	ContractNumber  string    `json:"contractNumber"`
+	StudentFullName string    `json:"studentFullName"`
+	Grade           int       `json:"grade"`
+	ServiceStart    time.Time `json:"serviceStart"`
+	ServiceEnd      time.Time `json:"serviceEnd"`
+	Withdrawn       bool      `json:"withdrawn"`
+	// Amount - выручка к признанию: сумма договора со скидками, для выбывших - после пересчета.
+	Amount     models.Money   `json:"amount"`
+	Months     []models.Money `json:"months"`
+	Recognized models.Money   `json:"recognized"`
+	Received   models.Money   `json:"received"`
+	Deferred   models.Money   `json:"deferred"`
+	Receivable models.Money   `json:"receivable"`
+
+	receivedByMonth []models.Money
+}
+
+// RevenueRecognitionReport - отчет о признании выручки за учебный год.
+type RevenueRecognitionReport struct {
+	AcademicYearID uint                 `json:"academicYearId"`
+	AcademicYear   string               `json:"academicYear"`
+	AsOf           time.Time            `json:"asOf"`
+	Months         []RevenueMonth       `json:"months"`
+	Grades         []RevenueGradeRow    `json:"grades"`
+	Contracts      []RevenueContractRow `json:"contracts"`
+	Totals         RevenueContractRow   `json:"totals"`
+}
+
+// recognitionMonths возвращает первые числа месяцев учебного года, от месяца начала до месяца окончания.
+func recognitionMonths(year *models.AcademicYear) []time.Time {
+	var months []time.Time
+	last := time.Date(year.EndDate.Year(), year.EndDate.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for m := time.Date(year.StartDate.Year(), year.StartDate.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(last); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+	return months
+}
+
+// dateOnly отбрасывает время и часовой пояс даты.
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// spreadRevenue распределяет сумму по месяцам пропорционально доле дней обучения в каждом месяце
+// периода [start, end] (обе даты включительно). Полные месяцы получают равные части, месяцы начала
+// и окончания - часть по числу дней. Сумма частей равна amount до тиына.
+func spreadRevenue(amount models.Money, months []time.Time, start, end time.Time) []models.Money {
+	weights := make([]float64, len(months))
+	var inPeriod bool
+	for i, m := range months {
+		next := m.AddDate(0, 1, 0)
+		from, to := m, next.AddDate(0, 0, -1)
+		if start.After(from) {
+			from = start
+		}
+		if end.Before(to) {
+			to = end
+		}
+		if to.Before(from) {
+			continue
+		}
+		days := to.Sub(from).Hours()/24 + 1
+		weights[i] = days / (next.Sub(m).Hours() / 24)
+		inPeriod = true
+	}
+	if !inPeriod {
+		return make([]models.Money, len(months))
+	}
+	return amount.Allocate(weights)
+}
+
+// buildRevenueRecognition строит отчет о признании выручки. Выручка договора признается равномерно
+// по месяцам оказания услуги: от даты начала договора (не раньше начала учебного года) до даты
+// окончания или выбытия. Поступления берутся из журнала расчетов: оплаты минус возвраты, включая сторно.
+func buildRevenueRecognition(tx *gorm.DB, year *models.AcademicYear, asOf time.Time) (*RevenueRecognitionReport, error) {
+	months := recognitionMonths(year)
+	yearStart, yearEnd := dateOnly(year.StartDate), dateOnly(year.EndDate)
+	periodFrom, periodTo := forecastPeriod(year)
+
+	var contracts []struct {
+		ID               uint
+		ContractNumber   string
+		StudentFullName  string
+		Grade            int
+		StartDate        *time.Time
+		EndDate          *time.Time
+		DiscountedAmount models.Money
+		WithdrawalDate   *time.Time
+		ChargeableAmount *models.Money
+	}
+	err := tx.Table("contracts c").
+		Select(`c.id, c.contract_number, c.start_date, c.end_date, c.discounted_amount,
+			(s.last_name || ' ' || s.first_name || ' ' || COALESCE(s.middle_name, '')) AS student_full_name,
+			COALESCE(cl.grade_number, 0) AS grade,
+			w.withdrawal_date, w.chargeable_amount`).
+		Joins("LEFT JOIN students s ON s.id = c.student_id").
+		Joins("LEFT JOIN classes cl ON cl.id = s.class_id").
+		Joins("LEFT JOIN contract_withdrawals w ON w.contract_id = c.id AND w.deleted_at IS NULL").
+		Where("c.deleted_at IS NULL").
+		Where("c.academic_year_id = ? OR (c.academic_year_id IS NULL AND c.start_date >= ? AND c.start_date < ?)", year.ID, periodFrom, periodTo).
+		Order("c.contract_number").
+		Scan(&contracts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RevenueRecognitionReport{
+		AcademicYearID: year.ID,
+		AcademicYear:   year.Name,
+		AsOf:           dateOnly(asOf),
+		Months:         make([]RevenueMonth, len(months)),
+		Grades:         make([]RevenueGradeRow, 0),
+		Contracts:      make([]RevenueContractRow, 0, len(contracts)),
+		Totals:         RevenueContractRow{ContractNumber: "Итого", Months: make([]models.Money, len(months))},
+	}
+	for i, m := range months {
+		report.Months[i] = RevenueMonth{
+			Month: m.Format("2006-01"),
+			Label: fmt.Sprintf("%s %d", russianMonths[m.Month()-1], m.Year()),
+		}
+	}
+
+	ids := make([]uint, 0, len(contracts))
+	byID := make(map[uint]int, len(contracts))
+	for _, c := range contracts {
+		row := RevenueContractRow{
+			ContractID:      c.ID,
+			ContractNumber:  c.ContractNumber,
+			StudentFullName: c.StudentFullName,
+			Grade:           c.Grade,
+			ServiceStart:    yearStart,
+			ServiceEnd:      yearEnd,
+			Amount:          c.DiscountedAmount,
+			receivedByMonth: make([]models.Money, len(months)),
+		}
+		if c.StartDate != nil && dateOnly(*c.StartDate).After(row.ServiceStart) {
+			row.ServiceStart = dateOnly(*c.StartDate)
+		}
+		if c.EndDate != nil && dateOnly(*c.EndDate).Before(row.ServiceEnd) {
+			row.ServiceEnd = dateOnly(*c.EndDate)
+		}
+		if c.WithdrawalDate != nil {
+			row.Withdrawn = true
+			if c.ChargeableAmount != nil {
+				row.Amount = *c.ChargeableAmount
+			}
+			if w := dateOnly(*c.WithdrawalDate); w.Before(row.ServiceEnd) {
+				row.ServiceEnd = w
+			}
+		}
+		if row.ServiceEnd.Before(row.ServiceStart) {
+			// Выбыл до начала занятий: удержанная сумма признается в первом месяце.
+			row.ServiceEnd = row.ServiceStart
+		}
+		row.Months = spreadRevenue(row.Amount, months, row.ServiceStart, row.ServiceEnd)
+		byID[c.ID] = len(report.Contracts)
+		ids = append(ids, c.ID)
+		report.Contracts = append(report.Contracts, row)
+	}
+
+	if len(ids) > 0 {
+		var received []struct {
+			ContractID uint
+			Month      time.Time
+			Amount     models.Money
+		}
+		err := tx.Raw(`
+			SELECT le.contract_id, date_trunc('month', le.entry_date) AS month, -SUM(le.amount) AS amount
+			FROM ledger_entries le
+			LEFT JOIN ledger_entries orig ON orig.id = le.reverses_id
+			WHERE le.contract_id IN ?
+			  AND (le.entry_type IN (?, ?) OR (le.entry_type = ? AND orig.entry_type IN (?, ?)))
+			GROUP BY 1, 2`,
+			ids, models.LedgerPayment, models.LedgerRefund, models.LedgerReversal, models.LedgerPayment, models.LedgerRefund).
+			Scan(&received).Error
+		if err != nil {
+			return nil, err
+		}
+		first, last := months[0], months[len(months)-1]
+		for _, r := range received {
+			row := &report.Contracts[byID[r.ContractID]]
+			// Поступления до начала года (летняя предоплата) относятся к первому месяцу, после окончания - к последнему.
+			var i int
+			switch {
+			case r.Month.Before(first):
+				i = 0
+			case r.Month.After(last):
+				i = len(months) - 1
+			default:
+				i = (r.Month.Year()-first.Year())*12 + int(r.Month.Month()-first.Month())
+			}
+			row.receivedByMonth[i] += r.Amount
+		}
+	}
+
+	asOfMonth := time.Date(asOf.Year(), asOf.Month(), 1, 0, 0, 0, 0, time.UTC)
+	grades := make(map[int]*RevenueGradeRow)
+	for ci := range report.Contracts {
+		row := &report.Contracts[ci]
+		grade, ok := grades[row.Grade]
+		if !ok {
+			grade = &RevenueGradeRow{Grade: row.Grade, Label: fmt.Sprintf("%d класс", row.Grade), Months: make([]models.Money, len(months))}
+			if row.Grade == 0 {
+				grade.Label = "Без класса"
+			}
+			grades[row.Grade] = grade
+		}
+
+		var cumRecognized, cumReceived models.Money
+		for i, m := range months {
+			cumRecognized += row.Months[i]
+			cumReceived += row.receivedByMonth[i]
+
+			month := &report.Months[i]
+			month.Recognized += row.Months[i]
+			month.CumulativeRecognized += cumRecognized
+			month.CumulativeReceived += cumReceived
+			if cumReceived > cumRecognized {
+				month.Deferred += cumReceived - cumRecognized
+			} else {
+				month.Receivable += cumRecognized - cumReceived
+			}
+			grade.Months[i] += row.Months[i]
+			grade.Total += row.Months[i]
+			report.Totals.Months[i] += row.Months[i]
+
+			if !m.After(asOfMonth) {
+				row.Recognized = cumRecognized
+				row.Received = cumReceived
+			}
+		}
+		if asOfMonth.Before(months[0]) {
+			// До начала года выручка не признана, а поступления уже есть.
+			for _, r := range row.receivedByMonth {
+				row.Received += r
+			}
+		}
+		row.Deferred = models.MaxMoney(0, row.Received-row.Recognized)
+		row.Receivable = models.MaxMoney(0, row.Recognized-row.Received)
+
+		report.Totals.Amount += row.Amount
+		report.Totals.Recognized += row.Recognized
+		report.Totals.Received += row.Received
+		report.Totals.Deferred += row.Deferred
+		report.Totals.Receivable += row.Receivable
+	}
+	for _, g := range grades {
+		report.Grades = append(report.Grades, *g)
+	}
+	sort.Slice(report.Grades, func(i, j int) bool { return report.Grades[i].Grade < report.Grades[j].Grade })
+
+	return report, nil
+}
+
+// loadRevenueRecognition разбирает параметры отчета (academicYearId, asOf) и строит его.
+func loadRevenueRecognition(c *gin.Context) (*RevenueRecognitionReport, bool) {
+	year, status, err := resolveForecastYear(c)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	asOf := time.Now()
+	if raw := c.Query("asOf"); raw != "" {
+		if asOf, err = time.Parse("2006-01-02", raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Используйте YYYY-MM-DD."})
+			return nil, false
+		}
+	}
+	report, err := buildRevenueRecognition(config.DB, year, asOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось построить отчет: " + err.Error()})
+		return nil, false
+	}
+	return report, true
+}
+
+// GetRevenueRecognitionHandler возвращает признанную и отложенную выручку по месяцам, параллелям и договорам.
+func GetRevenueRecognitionHandler(c *gin.Context) {
+	report, ok := loadRevenueRecognition(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ExportRevenueRecognitionHandler выгружает отчет: format=xlsx (по умолчанию) - книга с тремя листами,
+// format=csv - проводки признания выручки по договорам помесячно для загрузки в 1С.
+func ExportRevenueRecognitionHandler(c *gin.Context) {
+	report, ok := loadRevenueRecognition(c)
+	if !ok {
+		return
+	}
+	stamp := time.Now().Format("20060102_150405")
+
+	if c.DefaultQuery("format", "xlsx") == "csv" {
+		data, err := revenueRecognitionCSV(report)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error writing CSV data"})
+			return
+		}
+		c.Header("Content-Description", "File Transfer")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=revenue_recognition_%s_%s.csv", report.AcademicYear, stamp))
+		c.Data(http.StatusOK, "text/csv", data)
+		return
+	}
+
+	f := revenueRecognitionWorkbook(report)
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=revenue_recognition_%s_%s.xlsx", report.AcademicYear, stamp))
+	if err := f.Write(c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write Excel file"})
+	}
+}
+
+// revenueRecognitionCSV формирует проводки для 1С: одна строка на договор и месяц с ненулевой выручкой,
+// датой проводки - последним днем месяца.
+func revenueRecognitionCSV(report *RevenueRecognitionReport) ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.Write([]byte{0xEF, 0xBB, 0xBF}) // BOM for UTF-8
+
+	w := csv.NewWriter(b)
+	w.Comma = ';'
+	if err := w.Write([]string{"Дата", "Номер договора", "ФИО ученика", "Класс", "Содержание", "Сумма"}); err != nil {
+		return nil, err
+	}
+	for _, row := range report.Contracts {
+		for i, amount := range row.Months {
+			if amount == 0 {
+				continue
+			}
+			month, _ := time.Parse("2006-01", report.Months[i].Month)
+			record := []string{
+				month.AddDate(0, 1, -1).Format("02.01.2006"),
+				row.ContractNumber,
+				row.StudentFullName,
+				strconv.Itoa(row.Grade),
+				"Признание выручки за обучение, " + report.Months[i].Label,
+				amount.String(),
+			}
+			if err := w.Write(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// revenueRecognitionWorkbook формирует книгу Excel: сводка по месяцам, по параллелям и по договорам.
+func revenueRecognitionWorkbook(report *RevenueRecognitionReport) *excelize.File {
+	f := excelize.NewFile()
+
+	summary := "По месяцам"
+	index, _ := f.NewSheet(summary)
+	f.SetActiveSheet(index)
+	f.DeleteSheet("Sheet1")
+	headers := []string{"Месяц", "Признано", "Признано нарастающим итогом", "Поступило нарастающим итогом", "Доходы будущих периодов", "Дебиторская задолженность"}
+	for i, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(summary, cell, header)
+	}
+	for i, m := range report.Months {
+		row := i + 2
+		f.SetCellValue(summary, fmt.Sprintf("A%d", row), m.Label)
+		setMoneyCell(f, summary, fmt.Sprintf("B%d", row), m.Recognized)
+		setMoneyCell(f, summary, fmt.Sprintf("C%d", row), m.CumulativeRecognized)
+		setMoneyCell(f, summary, fmt.Sprintf("D%d", row), m.CumulativeReceived)
+		setMoneyCell(f, summary, fmt.Sprintf("E%d", row), m.Deferred)
+		setMoneyCell(f, summary, fmt.Sprintf("F%d", row), m.Receivable)
+	}
+
+	// На листах по параллелям и договорам месяцы идут колонками после фиксированных.
+	writeMonthHeaders := func(sheet string, fixed []string) {
+		for i, header := range fixed {
+			cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+			f.SetCellValue(sheet, cell, header)
+		}
+		for i, m := range report.Months {
+			cell, _ := excelize.CoordinatesToCellName(len(fixed)+i+1, 1)
+			f.SetCellValue(sheet, cell, m.Label)
+		}
+	}
+	writeMonths := func(sheet string, row, col int, values []models.Money) {
+		for i, v := range values {
+			cell, _ := excelize.CoordinatesToCellName(col+i, row)
+			setMoneyCell(f, sheet, cell, v)
+		}
+	}
+
+	grades := "По классам"
+	f.NewSheet(grades)
+	writeMonthHeaders(grades, []string{"Класс", "Итого"})
+	for i, g := range report.Grades {
+		row := i + 2
+		f.SetCellValue(grades, fmt.Sprintf("A%d", row), g.Label)
+		setMoneyCell(f, grades, fmt.Sprintf("B%d", row), g.Total)
+		writeMonths(grades, row, 3, g.Months)
+	}
+
+	contracts := "По договорам"
+	f.NewSheet(contracts)
+	fixed := []string{"Номер договора", "ФИО ученика", "Класс", "Период с", "Период по", "Выбыл", "К признанию",
+		"Признано на " + report.AsOf.Format("02.01.2006"), "Поступило", "Доходы будущих периодов", "Дебиторская задолженность"}
+	writeMonthHeaders(contracts, fixed)
+	writeContract := func(row int, r RevenueContractRow) {
+		f.SetCellValue(contracts, fmt.Sprintf("A%d", row), r.ContractNumber)
+		f.SetCellValue(contracts, fmt.Sprintf("B%d", row), r.StudentFullName)
+		if r.ContractID != 0 {
+			f.SetCellValue(contracts, fmt.Sprintf("C%d", row), r.Grade)
+			f.SetCellValue(contracts, fmt.Sprintf("D%d", row), r.ServiceStart.Format("02.01.2006"))
+			f.SetCellValue(contracts, fmt.Sprintf("E%d", row), r.ServiceEnd.Format("02.01.2006"))
+			if r.Withdrawn {
+				f.SetCellValue(contracts, fmt.Sprintf("F%d", row), "Да")
+			}
+		}
+		setMoneyCell(f, contracts, fmt.Sprintf("G%d", row), r.Amount)
+		setMoneyCell(f, contracts, fmt.Sprintf("H%d", row), r.Recognized)
+		setMoneyCell(f, contracts, fmt.Sprintf("I%d", row), r.Received)
+		setMoneyCell(f, contracts, fmt.Sprintf("J%d", row), r.Deferred)
+		setMoneyCell(f, contracts, fmt.Sprintf("K%d", row), r.Receivable)
+		writeMonths(contracts, row, len(fixed)+1, r.Months)
+	}
+	for i, r := range report.Contracts {
+		writeContract(i+2, r)
+	}
+	writeContract(len(report.Contracts)+2, report.Totals)
+
+	return f
+}
@@ -68,6 +68,11 @@ func CreateOrUpdateScheduleHandler(c *gin.Context) {
 	}
 
 	db := config.DB
+	if _, _, err := academicQuarterByName(db, schedule.AcademicYear, schedule.Quarter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var existingSchedule models.Schedule
 
 	err := db.Where("class_id = ? AND academic_year = ? AND quarter = ?", schedule.ClassID, schedule.AcademicYear, schedule.Quarter).First(&existingSchedule).Error
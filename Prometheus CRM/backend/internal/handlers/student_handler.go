@@ -659,23 +659,13 @@ func UpdateFamilyDiscounts(studentIDs []uint) {
 		return
 	}
 
-	// Пересчитываем `family_order` и скидки на основе отсортированного списка
+	// Пересчитываем `family_order`, а затем скидки по правилам (см. discount_rules.go)
 	for i, student := range students {
 		// Обновляем family_order на случай, если он был некорректным
 		if student.FamilyOrder != i {
 			config.DB.Model(&student).Update("family_order", i)
 		}
 
-		var discount float64
-		switch i {
-		case 0: // Первый ребенок
-			discount = 0.0
-		case 1: // Второй ребенок
-			discount = 5.0
-		default: // Третий и последующие
-			discount = 10.0
-		}
-
 		var latestContract models.Contract
 		err := config.DB.Where("student_id = ? AND deleted_at IS NULL", student.ID).Order("start_date desc, id desc").First(&latestContract).Error
 
@@ -687,18 +677,15 @@ func UpdateFamilyDiscounts(studentIDs []uint) {
 			continue
 		}
 
-		// Если скидка в договоре не соответствует правильной, обновляем ее.
-		if latestContract.DiscountPercentage != discount {
-			newDiscountedAmount := latestContract.TotalAmount * (1 - (discount / 100))
-			updates := map[string]interface{}{
-				"discount_percentage": discount,
-				"discounted_amount":   newDiscountedAmount,
-			}
-			if err := config.DB.Model(&latestContract).Updates(updates).Error; err != nil {
-				slog.Error("Failed to update contract discount", "contract_id", latestContract.ID, "error", err)
-			} else {
-				slog.Info("Contract discount updated successfully", "student_id", student.ID, "new_discount", discount)
-			}
+		previousDiscount := latestContract.DiscountPercentage
+		err = config.DB.Transaction(func(tx *gorm.DB) error {
+			_, err := applyContractDiscounts(tx, &latestContract)
+			return err
+		})
+		if err != nil {
+			slog.Error("Failed to update contract discount", "contract_id", latestContract.ID, "error", err)
+		} else if latestContract.DiscountPercentage != previousDiscount {
+			slog.Info("Contract discount updated successfully", "student_id", student.ID, "new_discount", latestContract.DiscountPercentage)
 		}
 	}
 }
@@ -0,0 +1,334 @@
+// prometheus-crm/internal/handlers/tuition_price_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Значения Class.Language и Class.StudyType, для которых можно задать отдельную цену.
+var (
+	tuitionLanguages  = map[string]bool{"": true, "Русский": true, "Казахский": true, "Английский": true}
+	tuitionStudyTypes = map[string]bool{"": true, "Очный": true, "Онлайн": true, "Смешанный": true}
+)
+
+// TuitionPriceInput - строка прайс-листа в запросе.
+type TuitionPriceInput struct {
+	AcademicYearID uint         `json:"academicYearId" binding:"required"`
+	Grade          int          `json:"grade"`
+	Language       string       `json:"language"`
+	StudyType      string       `json:"studyType"`
+	Amount         models.Money `json:"amount"`
+	Comment        string       `json:"comment"`
+}
+
+func (in TuitionPriceInput) apply(price *models.TuitionPrice) error {
+	in.Language = strings.TrimSpace(in.Language)
+	in.StudyType = strings.TrimSpace(in.StudyType)
+	if in.Grade < 0 || in.Grade > 11 {
+		return errors.New("параллель должна быть от 0 до 11")
+	}
+	if !tuitionLanguages[in.Language] || !tuitionStudyTypes[in.StudyType] {
+		return errors.New("неизвестный язык или форма обучения")
+	}
+	if in.Amount <= 0 {
+		return errors.New("стоимость должна быть больше нуля")
+	}
+	price.AcademicYearID = in.AcademicYearID
+	price.Grade = in.Grade
+	price.Language = in.Language
+	price.StudyType = in.StudyType
+	price.Amount = in.Amount
+	price.Comment = strings.TrimSpace(in.Comment)
+	return nil
+}
+
+// tuitionPriceExists проверяет, есть ли уже строка с тем же ключом (кроме exceptID).
+func tuitionPriceExists(tx *gorm.DB, price *models.TuitionPrice, exceptID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&models.TuitionPrice{}).
+		Where("academic_year_id = ? AND grade = ? AND language = ? AND study_type = ? AND id <> ?",
+			price.AcademicYearID, price.Grade, price.Language, price.StudyType, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ListTuitionPricesHandler возвращает прайс-лист учебного года (?academic_year_id=, по умолчанию - текущий).
+func ListTuitionPricesHandler(c *gin.Context) {
+	yearID, _ := strconv.ParseUint(c.Query("academic_year_id"), 10, 64)
+	if yearID == 0 {
+		year, err := currentAcademicYear(config.DB)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		yearID = uint64(year.ID)
+	}
+
+	var prices []models.TuitionPrice
+	if err := config.DB.Where("academic_year_id = ?", yearID).
+		Order("grade ASC, language ASC, study_type ASC").
+		Find(&prices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить прайс-лист"})
+		return
+	}
+	if prices == nil {
+		prices = make([]models.TuitionPrice, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{"academicYearId": yearID, "data": prices})
+}
+
+// CreateTuitionPriceHandler добавляет строку прайс-листа.
+func CreateTuitionPriceHandler(c *gin.Context) {
+	var input TuitionPriceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	var price models.TuitionPrice
+	if err := input.apply(&price); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := loadAcademicYear(config.DB, price.AcademicYearID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Учебный год не найден"})
+		return
+	}
+	if exists, err := tuitionPriceExists(config.DB, &price, 0); err != nil || exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Цена для этой параллели, языка и формы обучения уже задана"})
+		return
+	}
+	if err := config.DB.Create(&price).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить цену"})
+		return
+	}
+	c.JSON(http.StatusCreated, price)
+}
+
+// UpdateTuitionPriceHandler изменяет строку прайс-листа. Уже заключенные договоры не пересчитываются.
+func UpdateTuitionPriceHandler(c *gin.Context) {
+	var price models.TuitionPrice
+	if err := config.DB.First(&price, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Цена не найдена"})
+		return
+	}
+	var input TuitionPriceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	if err := input.apply(&price); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if exists, err := tuitionPriceExists(config.DB, &price, price.ID); err != nil || exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Цена для этой параллели, языка и формы обучения уже задана"})
+		return
+	}
+	if err := config.DB.Save(&price).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить цену"})
+		return
+	}
+	c.JSON(http.StatusOK, price)
+}
+
+// DeleteTuitionPriceHandler удаляет строку прайс-листа.
+func DeleteTuitionPriceHandler(c *gin.Context) {
+	result := config.DB.Delete(&models.TuitionPrice{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить цену"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Цена не найдена"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Цена удалена"})
+}
+
+// TuitionIndexInput - параметры индексации прайс-листа.
+type TuitionIndexInput struct {
+	FromYearID uint    `json:"fromYearId" binding:"required"`
+	ToYearID   uint    `json:"toYearId" binding:"required"`
+	Percent    float64 `json:"percent"`
+	// RoundTo - шаг округления в тенге (0 - до тиына).
+	RoundTo   int64 `json:"roundTo"`
+	Overwrite bool  `json:"overwrite"`
+}
+
+// IndexTuitionPricesHandler переносит прайс-лист одного учебного года в другой с индексацией.
+func IndexTuitionPricesHandler(c *gin.Context) {
+	var input TuitionIndexInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	if input.FromYearID == input.ToYearID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Исходный и целевой учебный год совпадают"})
+		return
+	}
+	if input.Percent <= -100 || input.Percent > 100 || input.RoundTo < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Процент индексации должен быть от -100 до 100, шаг округления - неотрицательным"})
+		return
+	}
+	for _, id := range []uint{input.FromYearID, input.ToYearID} {
+		if _, err := loadAcademicYear(config.DB, id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Учебный год не найден"})
+			return
+		}
+	}
+
+	var result TuitionIndexResult
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = indexTuitionPrices(tx, input.FromYearID, input.ToYearID, input.Percent, models.Tenge(input.RoundTo), input.Overwrite)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось проиндексировать цены: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// TuitionGrandfatherRuleInput - правило сохранения цены в запросе; дата в формате YYYY-MM-DD.
+type TuitionGrandfatherRuleInput struct {
+	AcademicYearID uint    `json:"academicYearId" binding:"required"`
+	AdmittedBefore string  `json:"admittedBefore" binding:"required"`
+	PriceYearID    uint    `json:"priceYearId" binding:"required"`
+	IndexPercent   float64 `json:"indexPercent"`
+	Comment        string  `json:"comment"`
+}
+
+func (in TuitionGrandfatherRuleInput) apply(rule *models.TuitionGrandfatherRule) error {
+	admittedBefore, err := time.Parse("2006-01-02", in.AdmittedBefore)
+	if err != nil {
+		return errors.New("некорректная дата поступления")
+	}
+	if in.IndexPercent <= -100 || in.IndexPercent > 100 {
+		return errors.New("процент индексации должен быть от -100 до 100")
+	}
+	if _, err := loadAcademicYear(config.DB, in.AcademicYearID); err != nil {
+		return errors.New("учебный год не найден")
+	}
+	if _, err := loadAcademicYear(config.DB, in.PriceYearID); err != nil {
+		return errors.New("учебный год цены не найден")
+	}
+	rule.AcademicYearID = in.AcademicYearID
+	rule.AdmittedBefore = admittedBefore
+	rule.PriceYearID = in.PriceYearID
+	rule.IndexPercent = in.IndexPercent
+	rule.Comment = strings.TrimSpace(in.Comment)
+	return nil
+}
+
+// ListTuitionGrandfatherRulesHandler возвращает правила сохранения цены (?academic_year_id= - для одного года).
+func ListTuitionGrandfatherRulesHandler(c *gin.Context) {
+	query := config.DB.Preload("AcademicYear").Preload("PriceYear")
+	if yearID := c.Query("academic_year_id"); yearID != "" {
+		query = query.Where("academic_year_id = ?", yearID)
+	}
+	var rules []models.TuitionGrandfatherRule
+	if err := query.Order("academic_year_id DESC, admitted_before ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить правила"})
+		return
+	}
+	if rules == nil {
+		rules = make([]models.TuitionGrandfatherRule, 0)
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateTuitionGrandfatherRuleHandler добавляет правило сохранения цены.
+func CreateTuitionGrandfatherRuleHandler(c *gin.Context) {
+	var input TuitionGrandfatherRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	var rule models.TuitionGrandfatherRule
+	if err := input.apply(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить правило"})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateTuitionGrandfatherRuleHandler изменяет правило сохранения цены.
+func UpdateTuitionGrandfatherRuleHandler(c *gin.Context) {
+	var rule models.TuitionGrandfatherRule
+	if err := config.DB.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Правило не найдено"})
+		return
+	}
+	var input TuitionGrandfatherRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные: " + err.Error()})
+		return
+	}
+	if err := input.apply(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.AcademicYear, rule.PriceYear = nil, nil
+	if err := config.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить правило"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteTuitionGrandfatherRuleHandler удаляет правило сохранения цены.
+func DeleteTuitionGrandfatherRuleHandler(c *gin.Context) {
+	result := config.DB.Delete(&models.TuitionGrandfatherRule{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось удалить правило"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Правило не найдено"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Правило удалено"})
+}
+
+// GetTuitionQuoteHandler рассчитывает стоимость обучения ученика
+// (?student_id=, ?academic_year_id= - по умолчанию текущий учебный год).
+func GetTuitionQuoteHandler(c *gin.Context) {
+	var student models.Student
+	if err := config.DB.Preload("Class").First(&student, c.Query("student_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ученик не найден"})
+		return
+	}
+
+	var year *models.AcademicYear
+	var err error
+	if yearID, _ := strconv.ParseUint(c.Query("academic_year_id"), 10, 64); yearID > 0 {
+		if year, err = loadAcademicYear(config.DB, uint(yearID)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Учебный год не найден"})
+			return
+		}
+	} else if year, err = currentAcademicYear(config.DB); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	quote, err := quoteTuition(config.DB, &student, year)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, quote)
+}
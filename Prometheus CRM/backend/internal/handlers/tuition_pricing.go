@@ -0,0 +1,205 @@
+// prometheus-crm/internal/handlers/tuition_pricing.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"prometheus-crm/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errTuitionPriceNotFound = errors.New("цена не найдена в прайс-листе")
+
+// TuitionQuote - стоимость обучения ученика на учебный год и откуда она взята.
+type TuitionQuote struct {
+	Amount         models.Money `json:"amount"`
+	AcademicYearID uint         `json:"academicYearId"`
+	AcademicYear   string       `json:"academicYear"`
+	Grade          int          `json:"grade"`
+	Language       string       `json:"language"`
+	StudyType      string       `json:"studyType"`
+	// PriceID и PriceYear - строка прайс-листа, по которой посчитана цена.
+	PriceID   uint   `json:"priceId"`
+	PriceYear string `json:"priceYear"`
+	// RuleID - правило сохранения цены (nil, если действует прайс-лист года).
+	RuleID         *uint      `json:"ruleId,omitempty"`
+	IndexPercent   float64    `json:"indexPercent"`
+	AdmissionDate  *time.Time `json:"admissionDate,omitempty"`
+	GrandfatherFor string     `json:"grandfatherFor,omitempty"`
+}
+
+// studentGradeForYear возвращает параллель ученика в учебном году year: класс ученика относится
+// к текущему учебному году, на следующие годы параллель увеличивается (для продления договора).
+func studentGradeForYear(tx *gorm.DB, student *models.Student, year *models.AcademicYear) (int, error) {
+	if student.Class == nil {
+		return 0, errors.New("ученику не присвоен класс, невозможно определить стоимость обучения")
+	}
+	grade := student.Class.GradeNumber
+	current, err := currentAcademicYear(tx)
+	if err != nil {
+		return 0, err
+	}
+	grade += year.StartDate.Year() - current.StartDate.Year()
+	if grade < 0 || grade > 11 {
+		return 0, fmt.Errorf("в учебном году %s ученик будет в %d классе - такой параллели нет", year.Name, grade)
+	}
+	return grade, nil
+}
+
+// studentAdmissionDate - дата поступления ученика: дата начала обучения из карточки,
+// а если она не заполнена - начало самого раннего договора. nil, если определить нельзя.
+func studentAdmissionDate(tx *gorm.DB, student *models.Student) (*time.Time, error) {
+	if student.StartDate != nil {
+		return student.StartDate, nil
+	}
+	var first models.Contract
+	err := tx.Where("student_id = ? AND start_date IS NOT NULL", student.ID).
+		Order("start_date ASC").
+		First(&first).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return first.StartDate, nil
+}
+
+// findTuitionPrice ищет строку прайс-листа года для параллели. Точное совпадение языка и формы
+// обучения важнее общих строк (пустой Language/StudyType - "любой").
+func findTuitionPrice(tx *gorm.DB, yearID uint, grade int, language, studyType string) (*models.TuitionPrice, error) {
+	var price models.TuitionPrice
+	err := tx.Where("academic_year_id = ? AND grade = ?", yearID, grade).
+		Where("language IN ('', ?) AND study_type IN ('', ?)", language, studyType).
+		Order("(language <> '') DESC, (study_type <> '') DESC").
+		First(&price).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errTuitionPriceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// quoteTuition - единственное место расчета стоимости обучения ученика на учебный год:
+// используется при создании договора, продлении и на странице договоров.
+// Если ученик поступил до даты правила сохранения цены, цена берется из прайс-листа
+// года правила и индексируется на IndexPercent.
+func quoteTuition(tx *gorm.DB, student *models.Student, year *models.AcademicYear) (*TuitionQuote, error) {
+	grade, err := studentGradeForYear(tx, student, year)
+	if err != nil {
+		return nil, err
+	}
+	quote := &TuitionQuote{
+		AcademicYearID: year.ID,
+		AcademicYear:   year.Name,
+		Grade:          grade,
+		Language:       student.Class.Language,
+		StudyType:      student.Class.StudyType,
+		PriceYear:      year.Name,
+	}
+	priceYearID := year.ID
+
+	admission, err := studentAdmissionDate(tx, student)
+	if err != nil {
+		return nil, err
+	}
+	if admission != nil {
+		quote.AdmissionDate = admission
+		var rule models.TuitionGrandfatherRule
+		err := tx.Preload("PriceYear").
+			Where("academic_year_id = ? AND admitted_before > ?", year.ID, admission.Format("2006-01-02")).
+			Order("admitted_before ASC").
+			First(&rule).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			priceYearID = rule.PriceYearID
+			quote.RuleID = &rule.ID
+			quote.IndexPercent = rule.IndexPercent
+			quote.GrandfatherFor = "поступившие до " + rule.AdmittedBefore.Format("02.01.2006")
+			if rule.PriceYear != nil {
+				quote.PriceYear = rule.PriceYear.Name
+			}
+		}
+	}
+
+	price, err := findTuitionPrice(tx, priceYearID, grade, quote.Language, quote.StudyType)
+	if errors.Is(err, errTuitionPriceNotFound) {
+		return nil, fmt.Errorf("в прайс-листе %s нет цены для %d класса (%s, %s)", quote.PriceYear, grade,
+			firstNonEmpty(quote.Language, "любой язык"), firstNonEmpty(quote.StudyType, "любая форма обучения"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	quote.PriceID = price.ID
+	quote.Amount = price.Amount + price.Amount.Percent(quote.IndexPercent)
+	return quote, nil
+}
+
+// roundMoneyTo округляет сумму до шага step (например, до 1 000 тенге), половина - вверх.
+func roundMoneyTo(m, step models.Money) models.Money {
+	if step <= 0 {
+		return m
+	}
+	return (m + step/2) / step * step
+}
+
+// TuitionIndexResult - итог индексации прайс-листа.
+type TuitionIndexResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// indexTuitionPrices переносит прайс-лист года fromYearID в год toYearID, увеличивая цены на percent
+// с округлением до roundTo. Существующие строки целевого года меняются только при overwrite.
+func indexTuitionPrices(tx *gorm.DB, fromYearID, toYearID uint, percent float64, roundTo models.Money, overwrite bool) (TuitionIndexResult, error) {
+	var result TuitionIndexResult
+	var source []models.TuitionPrice
+	if err := tx.Where("academic_year_id = ?", fromYearID).Find(&source).Error; err != nil {
+		return result, err
+	}
+	if len(source) == 0 {
+		return result, errors.New("в исходном году нет цен для индексации")
+	}
+
+	comment := fmt.Sprintf("Индексация %+.2f%%", percent)
+	for _, src := range source {
+		amount := roundMoneyTo(src.Amount+src.Amount.Percent(percent), roundTo)
+
+		var existing models.TuitionPrice
+		err := tx.Where("academic_year_id = ? AND grade = ? AND language = ? AND study_type = ?",
+			toYearID, src.Grade, src.Language, src.StudyType).
+			First(&existing).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			price := models.TuitionPrice{
+				AcademicYearID: toYearID,
+				Grade:          src.Grade,
+				Language:       src.Language,
+				StudyType:      src.StudyType,
+				Amount:         amount,
+				Comment:        comment,
+			}
+			if err := tx.Create(&price).Error; err != nil {
+				return result, err
+			}
+			result.Created++
+		case err != nil:
+			return result, err
+		case overwrite:
+			if err := tx.Model(&existing).Updates(map[string]interface{}{"amount": amount, "comment": comment}).Error; err != nil {
+				return result, err
+			}
+			result.Updated++
+		default:
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
@@ -2,74 +2,299 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"prometheus-crm/config"
+	"prometheus-crm/internal/middleware"
 	"prometheus-crm/models"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
 )
 
+// OneCService - имя интеграции с 1С в integration_settings и журнале вебхуков.
+const OneCService = "1c"
+
 // Webhook1CInput определяет структуру входящих данных, которые мы ожидаем от 1С.
 type Webhook1CInput struct {
-	ContractNumber string  `json:"contractNumber" binding:"required"`
-	Amount         float64 `json:"amount" binding:"required"`
-	PaymentDate    string  `json:"paymentDate" binding:"required"` // Ожидаем дату в формате "2006-01-02"
-	ExternalID     string  `json:"externalId"`                     // Уникальный ID транзакции из 1С, не обязателен, но желателен
+	ContractNumber string       `json:"contractNumber" binding:"required"`
+	Amount         models.Money `json:"amount" binding:"required"`
+	PaymentDate    string       `json:"paymentDate" binding:"required"` // Ожидаем дату в формате "2006-01-02"
+	ExternalID     string       `json:"externalId" binding:"required"`  // Уникальный ID транзакции из 1С, по нему отсекаются повторные доставки
 }
 
 // Webhook1CHandler обрабатывает входящие данные о платежах от 1С.
+// Маршрут публичный: подлинность запроса проверяет WebhookSignatureMiddleware.
+// Каждая доставка записывается в журнал inbound_webhooks.
 func Webhook1CHandler(c *gin.Context) {
-	var input Webhook1CInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+	var body []byte
+	if raw, ok := c.Get(middleware.WebhookRawBodyKey); ok {
+		body, _ = raw.([]byte)
+	} else {
+		body, _ = c.GetRawData()
+	}
+
+	entry := models.InboundWebhook{
+		ServiceName: OneCService,
+		Signature:   c.GetHeader(middleware.WebhookSignatureHeader),
+		Payload:     string(body),
+		Status:      models.WebhookStatusReceived,
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось записать вебхук в журнал"})
 		return
 	}
 
+	code, response, duplicate := handle1CDelivery(&entry, body)
+	if duplicate {
+		c.Header("X-Webhook-Duplicate", "true")
+	}
+	c.JSON(code, response)
+}
+
+// handle1CDelivery разбирает тело доставки, отсекает повторы по ExternalID,
+// проводит платеж и фиксирует результат в записи журнала.
+func handle1CDelivery(entry *models.InboundWebhook, body []byte) (int, gin.H, bool) {
+	var input Webhook1CInput
+	if err := binding.JSON.BindBody(body, &input); err != nil {
+		response := gin.H{"error": "Invalid input: " + err.Error()}
+		finishInboundWebhook(entry, http.StatusBadRequest, response)
+		return http.StatusBadRequest, response, false
+	}
+	entry.ExternalID = input.ExternalID
+
+	// Повторная доставка уже обработанного платежа: возвращаем исходный результат.
+	var original models.InboundWebhook
+	err := config.DB.Where("service_name = ? AND external_id = ? AND status = ? AND id <> ?",
+		OneCService, input.ExternalID, models.WebhookStatusProcessed, entry.ID).
+		Order("id ASC").First(&original).Error
+	if err == nil {
+		markInboundWebhookDuplicate(entry, &original)
+		return original.ResponseCode, gin.H(original.Response), true
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		response := gin.H{"error": "Ошибка проверки повторной доставки"}
+		finishInboundWebhook(entry, http.StatusInternalServerError, response)
+		return http.StatusInternalServerError, response, false
+	}
+
+	code, response := process1CPayment(input)
+	finishInboundWebhook(entry, code, response)
+	return code, response, false
+}
+
+// finishInboundWebhook сохраняет итог обработки доставки.
+func finishInboundWebhook(entry *models.InboundWebhook, code int, response gin.H) {
+	now := time.Now()
+	entry.ResponseCode = code
+	entry.Response = models.JSONB(response)
+	entry.ProcessedAt = &now
+	if code >= 200 && code < 300 {
+		entry.Status = models.WebhookStatusProcessed
+		entry.Error = ""
+	} else {
+		entry.Status = models.WebhookStatusFailed
+		if msg, ok := response["error"].(string); ok {
+			entry.Error = msg
+		}
+	}
+	if err := config.DB.Save(entry).Error; err != nil {
+		log.Printf("Не удалось обновить запись вебхука %d: %v", entry.ID, err)
+	}
+}
+
+// markInboundWebhookDuplicate помечает доставку как повтор уже обработанной.
+func markInboundWebhookDuplicate(entry *models.InboundWebhook, original *models.InboundWebhook) {
+	now := time.Now()
+	entry.Status = models.WebhookStatusDuplicate
+	entry.ResponseCode = original.ResponseCode
+	entry.Response = original.Response
+	entry.ProcessedAt = &now
+	if err := config.DB.Save(entry).Error; err != nil {
+		log.Printf("Не удалось обновить запись вебхука %d: %v", entry.ID, err)
+	}
+}
+
+// alreadyProcessed1CResponse - ответ на повторную доставку уже зачисленного платежа.
+func alreadyProcessed1CResponse(paymentID uint) gin.H {
+	return gin.H{"status": "ok", "message": "Платеж уже был зачислен ранее", "paymentId": paymentID}
+}
+
+// duplicate1CPaymentResponse отвечает проигравшей стороне гонки параллельных доставок
+// так же, как обычному повтору: платеж с этим ID транзакции уже зачислен.
+func duplicate1CPaymentResponse(externalID string) (int, gin.H) {
+	var existing models.LedgerEntry
+	if err := config.DB.Where("external_id = ?", externalID).First(&existing).Error; err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось найти ранее зачисленный платеж"}
+	}
+	return http.StatusOK, alreadyProcessed1CResponse(existing.ID)
+}
+
+// process1CPayment проводит платеж из 1С: фиксирует его, распределяет по графику и применяет скидку.
+func process1CPayment(input Webhook1CInput) (int, gin.H) {
 	var contract models.Contract
 	if err := config.DB.Where("contract_number = ?", input.ContractNumber).First(&contract).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Договор с таким номером не найден"})
-		return
+		return http.StatusNotFound, gin.H{"error": "Договор с таким номером не найден"}
 	}
 
 	paymentTime, err := time.Parse("2006-01-02", input.PaymentDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD"})
-		return
+		return http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Ожидается YYYY-MM-DD"}
+	}
+	if input.Amount <= 0 {
+		return http.StatusBadRequest, gin.H{"error": "Сумма платежа должна быть больше нуля"}
 	}
 
-	// --- ЛОГИКА АВТОМАТИЧЕСКОЙ СКИДКИ ---
-	septemberFirst := time.Date(paymentTime.Year(), 9, 1, 0, 0, 0, 0, paymentTime.Location())
+	tx := config.DB.Begin()
+	if tx.Error != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось начать транзакцию"}
+	}
 
-	var totalPaid float64
-	config.DB.Model(&models.PlannedPayment{}).
-		Where("contract_id = ?", contract.ID).
-		Select("coalesce(sum(paid_amount), 0)").
-		Row().Scan(&totalPaid)
+	// Страховка от параллельной доставки того же платежа: ID транзакции 1С уникален в журнале расчетов.
+	var existing models.LedgerEntry
+	if err := tx.Where("external_id = ?", input.ExternalID).First(&existing).Error; err == nil {
+		tx.Rollback()
+		return http.StatusOK, alreadyProcessed1CResponse(existing.ID)
+	}
 
-	if paymentTime.Before(septemberFirst) && (totalPaid+input.Amount) >= contract.TotalAmount {
-		// ИСПРАВЛЕНИЕ: Проверяем, что скидка еще не была применена (процент равен 0).
-		if contract.DiscountPercentage == 0 {
-			log.Printf("Применяем скидку 5%% для договора %s", contract.ContractNumber)
+	// --- ФИКСАЦИЯ ПОСТУПЛЕНИЯ ---
+	payment := models.LedgerEntry{
+		ContractID:    contract.ID,
+		EntryDate:     paymentTime,
+		Description:   "Оплата из 1С",
+		SourceType:    models.LedgerSourcePaymentFact,
+		PaymentMethod: "1С",
+		ExternalID:    &input.ExternalID,
+	}
+	// Платеж отражается в журнале расчетов, распределяется по графику и получает квитанцию.
+	allocation, _, err := recordPayment(tx, &payment, input.Amount, nil, nil)
+	if err != nil {
+		tx.Rollback()
+		if isUniqueViolation(err) {
+			// Параллельная доставка успела зачислить платеж между проверкой и вставкой.
+			return duplicate1CPaymentResponse(input.ExternalID)
+		}
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить платеж: " + err.Error()}
+	}
 
-			// ИСПРАВЛЕНИЕ: Используем правильные поля `DiscountPercentage` и `DiscountedAmount`.
-			newAmountWithDiscount := contract.TotalAmount * 0.95
-			contract.DiscountPercentage = 5.0
-			contract.DiscountedAmount = newAmountWithDiscount
-			config.DB.Save(&contract)
+	// --- АВТОМАТИЧЕСКИЕ СКИДКИ ---
+	// Полная оплата может дать скидку за раннюю оплату (правило early_payment).
+	var earlyBefore int64
+	if err := tx.Model(&models.ContractDiscountLine{}).
+		Where("contract_id = ? AND kind = ?", contract.ID, models.DiscountKindEarlyPayment).
+		Count(&earlyBefore).Error; err != nil {
+		tx.Rollback()
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось проверить скидки договора"}
+	}
+	discounts, err := applyContractDiscounts(tx, &contract)
+	if err != nil {
+		tx.Rollback()
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось пересчитать скидки: " + err.Error()}
+	}
+	if earlyBefore == 0 && discounts.HasKind(models.DiscountKindEarlyPayment) {
+		log.Printf("Применена скидка за раннюю оплату для договора %s", contract.ContractNumber)
 
-			// Обнуляем все будущие НЕОПЛАЧЕННЫЕ платежи в плане.
-			config.DB.Model(&models.PlannedPayment{}).
-				Where("contract_id = ? AND status != ?", contract.ID, "Оплачен").
-				Updates(map[string]interface{}{"planned_amount": 0, "status": "Скорректирован (скидка)"})
+		// Обнуляем оставшиеся НЕОПЛАЧЕННЫЕ платежи в плане (платеж уже распределён выше).
+		if err := tx.Model(&models.PlannedPayment{}).
+			Where("contract_id = ? AND paid_amount = 0", contract.ID).
+			Updates(map[string]interface{}{"planned_amount": 0, "status": PlannedStatusDiscounted}).Error; err != nil {
+			tx.Rollback()
+			return http.StatusInternalServerError, gin.H{"error": "Не удалось скорректировать план платежей"}
 		}
 	}
 
-	// --- ЛОГИКА РАСПРЕДЕЛЕНИЯ ПЛАТЕЖА ---
-	// ВАЖНО: Этот блок需要реализовать дополнительно.
-	// Он должен находить неоплаченные строки в `planned_payments` и применять к ним `input.Amount`.
-	log.Printf("Требуется реализовать логику распределения платежа %.2f для договора %s", input.Amount, contract.ContractNumber)
+	// Скидка могла изменить остаток по договору - он печатается в квитанции.
+	if err := refreshReceiptBalance(tx, models.AllocationSourceLedgerEntry, payment.ID, contract.ID); err != nil {
+		tx.Rollback()
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось обновить квитанцию"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Не удалось подтвердить транзакцию"}
+	}
+	go renderSourceReceipts(models.AllocationSourceLedgerEntry, payment.ID)
+
+	return http.StatusOK, gin.H{
+		"status":      "ok",
+		"message":     "Платеж успешно обработан",
+		"paymentId":   payment.ID,
+		"allocations": allocation.Allocations,
+		"unallocated": allocation.Unallocated,
+	}
+}
+
+// ListInboundWebhooksHandler возвращает журнал входящих вебхуков с пагинацией и фильтрами.
+func ListInboundWebhooksHandler(c *gin.Context) {
+	var entries []models.InboundWebhook
+	var totalRows int64
+
+	query := config.DB.Model(&models.InboundWebhook{})
+	if service := c.Query("service"); service != "" {
+		query = query.Where("service_name = ?", service)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if search := c.Query("search"); search != "" {
+		searchPattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(external_id) LIKE ? OR LOWER(payload) LIKE ?", searchPattern, searchPattern)
+	}
+
+	if err := query.Count(&totalRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось посчитать записи журнала"})
+		return
+	}
+	if err := query.Scopes(Paginate(c)).Order("id DESC").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось загрузить журнал вебхуков"})
+		return
+	}
+	if entries == nil {
+		entries = make([]models.InboundWebhook, 0)
+	}
+
+	c.JSON(http.StatusOK, CreatePaginatedResponse(c, entries, totalRows))
+}
+
+// ReplayInboundWebhookHandler повторно обрабатывает неудачную доставку вебхука.
+// Для повтора создается новая запись журнала со ссылкой на исходную. Повторяются только доставки,
+// прошедшие проверку подписи (failed): отклоненные (rejected) - неподписанные или поддельные запросы.
+func ReplayInboundWebhookHandler(c *gin.Context) {
+	var original models.InboundWebhook
+	if err := config.DB.First(&original, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Запись журнала не найдена"})
+		return
+	}
+	if original.ServiceName != OneCService {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Повтор для этого сервиса не поддерживается"})
+		return
+	}
+	if original.Status != models.WebhookStatusFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Повторно можно обработать только неудачные доставки с проверенной подписью"})
+		return
+	}
+
+	entry := models.InboundWebhook{
+		ServiceName: original.ServiceName,
+		Signature:   original.Signature,
+		Payload:     original.Payload,
+		Status:      models.WebhookStatusReceived,
+		ReplayOfID:  &original.ID,
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось записать повтор в журнал"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Платеж успешно обработан"})
+	code, response, duplicate := handle1CDelivery(&entry, []byte(original.Payload))
+	c.JSON(http.StatusOK, gin.H{
+		"webhookId":    entry.ID,
+		"status":       entry.Status,
+		"responseCode": code,
+		"response":     response,
+		"duplicate":    duplicate,
+	})
 }
@@ -0,0 +1,678 @@
+// prometheus-crm/internal/handlers/withdrawal_handler.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// WithdrawalService - правила пересчета при выбытии в integration_settings.
+	WithdrawalService = "withdrawal"
+
+	// TerminationAgreementClassification - классификация DOCX-шаблона соглашения о расторжении.
+	TerminationAgreementClassification = "Соглашение о расторжении"
+)
+
+// defaultWithdrawalDeposit - невозвратный взнос по умолчанию. Настроенный взнос
+// подставляется и в договор ({contributionOfMoney}).
+var defaultWithdrawalDeposit = models.Tenge(300000)
+
+var (
+	errContractTerminated         = errors.New("договор уже расторгнут")
+	errTerminationTemplateMissing = errors.New("не найден шаблон с классификацией «" + TerminationAgreementClassification + "»")
+)
+
+// WithdrawalSettings - правила пересчета стоимости обучения при выбытии.
+type WithdrawalSettings struct {
+	// Method - способ пересчета по умолчанию: month, quarter или day (см. models.WithdrawalBy*).
+	Method string `json:"method"`
+	// DepositAmount - невозвратный взнос, который удерживается при любой дате выбытия.
+	DepositAmount models.Money `json:"depositAmount"`
+	// TemplateID - шаблон соглашения о расторжении; если не задан, берется последний
+	// шаблон с классификацией TerminationAgreementClassification.
+	TemplateID *uint `json:"templateId,omitempty"`
+}
+
+// WithdrawalCalculation - расчет расторжения договора на дату выбытия.
+type WithdrawalCalculation struct {
+	ContractID     uint      `json:"contractId"`
+	ContractNumber string    `json:"contractNumber"`
+	WithdrawalDate time.Time `json:"withdrawalDate"`
+	Method         string    `json:"method"`
+	PeriodsTotal   int       `json:"periodsTotal"`
+	PeriodsCharged int       `json:"periodsCharged"`
+
+	ContractAmount   models.Money `json:"contractAmount"`
+	DepositAmount    models.Money `json:"depositAmount"`
+	ChargeableAmount models.Money `json:"chargeableAmount"`
+	// Recalculation - корректировка журнала расчетов (отрицательная - уменьшение долга).
+	Recalculation models.Money `json:"recalculation"`
+	PaidAmount    models.Money `json:"paidAmount"`
+	RefundAmount  models.Money `json:"refundAmount"`
+	DebtAmount    models.Money `json:"debtAmount"`
+
+	// CancelledPayments - строки графика, которые отменяются (уменьшаются до оплаченной суммы):
+	// все неоплаченные после даты выбытия и просроченные сверх пересчитанной стоимости.
+	CancelledPayments []models.PlannedPayment `json:"cancelledPayments"`
+	// ReducedPayments - просроченные строки, уменьшаемые частично, чтобы график сошелся с ChargeableAmount.
+	ReducedPayments []WithdrawalReducedPayment `json:"reducedPayments"`
+	// CancelledAmount - на сколько уменьшается график всего (отмены и уменьшения).
+	CancelledAmount models.Money `json:"cancelledAmount"`
+	Explanation     []string     `json:"explanation"`
+}
+
+// WithdrawalReducedPayment - строка графика, плановая сумма которой уменьшается при выбытии.
+type WithdrawalReducedPayment struct {
+	Payment   models.PlannedPayment `json:"payment"`
+	NewAmount models.Money          `json:"newAmount"`
+}
+
+var withdrawalMethodNames = map[string]string{
+	models.WithdrawalByMonth:   "по месяцам",
+	models.WithdrawalByQuarter: "по четвертям",
+	models.WithdrawalByDay:     "по дням",
+}
+
+// loadWithdrawalSettings читает правила выбытия; не заданные поля получают значения по умолчанию.
+func loadWithdrawalSettings(tx *gorm.DB) WithdrawalSettings {
+	settings := WithdrawalSettings{Method: models.WithdrawalByMonth, DepositAmount: defaultWithdrawalDeposit}
+	var setting models.IntegrationSetting
+	if err := tx.Where("service_name = ?", WithdrawalService).First(&setting).Error; err != nil {
+		return settings
+	}
+	raw, _ := json.Marshal(setting.Settings)
+	_ = json.Unmarshal(raw, &settings)
+	return settings
+}
+
+// withdrawalPeriods возвращает число оплачиваемых периодов учебного года и сколько из них
+// начались к дате выбытия. Начатый месяц или четверть оплачивается полностью.
+func withdrawalPeriods(year *models.AcademicYear, method string, date time.Time) (total, charged int, err error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	switch method {
+	case models.WithdrawalByMonth:
+		total = (year.EndDate.Year()-year.StartDate.Year())*12 + int(year.EndDate.Month()-year.StartDate.Month()) + 1
+		charged = (day.Year()-year.StartDate.Year())*12 + int(day.Month()-year.StartDate.Month()) + 1
+	case models.WithdrawalByQuarter:
+		if len(year.Quarters) == 0 {
+			return 0, 0, fmt.Errorf("в учебном году %s не заданы четверти", year.Name)
+		}
+		total = len(year.Quarters)
+		for _, q := range year.Quarters {
+			if !q.StartDate.After(day) {
+				charged++
+			}
+		}
+	case models.WithdrawalByDay:
+		total = int(year.EndDate.Sub(year.StartDate).Hours()/24) + 1
+		charged = int(day.Sub(year.StartDate).Hours()/24) + 1
+	default:
+		return 0, 0, fmt.Errorf("неизвестный способ пересчета «%s»", method)
+	}
+	if day.Before(year.StartDate) {
+		charged = 0
+	}
+	if charged > total {
+		charged = total
+	}
+	return total, charged, nil
+}
+
+// calculateWithdrawal рассчитывает расторжение договора на дату выбытия, ничего не меняя в БД.
+// Стоимость договора и оплаты берутся из журнала расчетов, поэтому ручные корректировки
+// и уже сделанные возвраты учитываются.
+func calculateWithdrawal(tx *gorm.DB, contract *models.Contract, date time.Time, method string, settings WithdrawalSettings) (*WithdrawalCalculation, error) {
+	year, err := academicYearForContract(tx, contract)
+	if err != nil {
+		return nil, err
+	}
+	total, charged, err := withdrawalPeriods(year, method, date)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := getContractBalance(tx, contract.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	calc := &WithdrawalCalculation{
+		ContractID:        contract.ID,
+		ContractNumber:    contract.ContractNumber,
+		WithdrawalDate:    date,
+		Method:            method,
+		PeriodsTotal:      total,
+		PeriodsCharged:    charged,
+		ContractAmount:    balance.Charged + balance.Adjustments - balance.Discounts,
+		PaidAmount:        balance.Paid - balance.Refunded,
+		CancelledPayments: make([]models.PlannedPayment, 0),
+	}
+	calc.DepositAmount = models.MaxMoney(0, models.MinMoney(settings.DepositAmount, calc.ContractAmount))
+	calc.ChargeableAmount = calc.ContractAmount
+	if charged < total {
+		calc.ChargeableAmount = calc.DepositAmount + (calc.ContractAmount-calc.DepositAmount).MulDiv(int64(charged), int64(total))
+	}
+	calc.Recalculation = calc.ChargeableAmount - calc.ContractAmount
+
+	after := balance.Balance + calc.Recalculation
+	if after < 0 {
+		calc.RefundAmount = -after
+	} else {
+		calc.DebtAmount = after
+	}
+
+	var schedule []models.PlannedPayment
+	if err := tx.Where("contract_id = ?", contract.ID).
+		Order("payment_date ASC, id ASC").
+		Find(&schedule).Error; err != nil {
+		return nil, fmt.Errorf("не удалось загрузить график платежей: %w", err)
+	}
+	calc.CancelledPayments, calc.ReducedPayments = planWithdrawalSchedule(schedule, date, calc.ChargeableAmount)
+	for _, p := range calc.CancelledPayments {
+		calc.CancelledAmount += p.PlannedAmount - p.PaidAmount
+	}
+	for _, r := range calc.ReducedPayments {
+		calc.CancelledAmount += r.Payment.PlannedAmount - r.NewAmount
+	}
+
+	calc.Explanation = []string{
+		fmt.Sprintf("Стоимость договора с учетом скидок: %s", formatMoney(calc.ContractAmount)),
+		fmt.Sprintf("Пересчет %s: оплачивается %d из %d (учебный год %s)", withdrawalMethodNames[method], charged, total, year.Name),
+		fmt.Sprintf("Невозвратный взнос: %s", formatMoney(calc.DepositAmount)),
+		fmt.Sprintf("Стоимость обучения после пересчета: %s", formatMoney(calc.ChargeableAmount)),
+		fmt.Sprintf("Оплачено: %s", formatMoney(calc.PaidAmount)),
+	}
+	switch {
+	case calc.RefundAmount > 0:
+		calc.Explanation = append(calc.Explanation, fmt.Sprintf("К возврату: %s", formatMoney(calc.RefundAmount)))
+	case calc.DebtAmount > 0:
+		calc.Explanation = append(calc.Explanation, fmt.Sprintf("Остаток долга: %s", formatMoney(calc.DebtAmount)))
+	default:
+		calc.Explanation = append(calc.Explanation, "Взаиморасчеты завершены")
+	}
+	if len(calc.CancelledPayments) > 0 {
+		calc.Explanation = append(calc.Explanation, fmt.Sprintf("Отменяется платежей графика: %d", len(calc.CancelledPayments)))
+	}
+	if len(calc.ReducedPayments) > 0 {
+		calc.Explanation = append(calc.Explanation, fmt.Sprintf("Уменьшается просроченных платежей графика: %d", len(calc.ReducedPayments)))
+	}
+	if calc.CancelledAmount > 0 {
+		calc.Explanation = append(calc.Explanation, fmt.Sprintf("График уменьшается на %s", formatMoney(calc.CancelledAmount)))
+	}
+	return calc, nil
+}
+
+// planWithdrawalSchedule подгоняет график платежей под пересчитанную стоимость обучения.
+// Неоплаченные остатки строк после даты выбытия отменяются всегда; если оставшийся график
+// все еще больше chargeable, просроченные неоплаченные остатки срезаются начиная с самых
+// поздних. Оплаченные суммы не трогаются, поэтому при переплате график сводится к оплатам.
+func planWithdrawalSchedule(schedule []models.PlannedPayment, date time.Time, chargeable models.Money) ([]models.PlannedPayment, []WithdrawalReducedPayment) {
+	day := date.Format("2006-01-02")
+	cancelled := make([]models.PlannedPayment, 0)
+	reduced := make([]WithdrawalReducedPayment, 0)
+
+	var kept models.Money
+	overdue := make([]models.PlannedPayment, 0)
+	for _, p := range schedule {
+		switch {
+		case p.PaidAmount >= p.PlannedAmount:
+			kept += p.PlannedAmount
+		case p.PaymentDate.Format("2006-01-02") > day:
+			cancelled = append(cancelled, p)
+			kept += p.PaidAmount
+		default:
+			overdue = append(overdue, p)
+			kept += p.PlannedAmount
+		}
+	}
+
+	excess := kept - chargeable
+	for i := len(overdue) - 1; i >= 0 && excess > 0; i-- {
+		p := overdue[i]
+		cut := models.MinMoney(excess, p.PlannedAmount-p.PaidAmount)
+		excess -= cut
+		if cut == p.PlannedAmount-p.PaidAmount {
+			cancelled = append(cancelled, p)
+			continue
+		}
+		reduced = append(reduced, WithdrawalReducedPayment{Payment: p, NewAmount: p.PlannedAmount - cut})
+	}
+	return cancelled, reduced
+}
+
+// applyWithdrawal фиксирует расторжение: сохраняет расчет, проводит корректировку стоимости
+// в журнале расчетов, подгоняет график под пересчитанную стоимость и отмечает договор и ученика.
+// Отменяемые строки, по которым уже есть частичная оплата, уменьшаются до оплаченной суммы.
+func applyWithdrawal(tx *gorm.DB, contract *models.Contract, calc *WithdrawalCalculation, reason string, userID *uint) (*models.ContractWithdrawal, error) {
+	number, err := allocateDocumentNumber(tx, models.DocumentTermination, NumberingContext{Date: time.Now(), Contract: contract})
+	if err != nil {
+		return nil, err
+	}
+	withdrawal := models.ContractWithdrawal{
+		ContractID:        contract.ID,
+		DocumentNumber:    number.Number,
+		WithdrawalDate:    calc.WithdrawalDate,
+		Reason:            reason,
+		Method:            calc.Method,
+		PeriodsTotal:      calc.PeriodsTotal,
+		PeriodsCharged:    calc.PeriodsCharged,
+		ContractAmount:    calc.ContractAmount,
+		DepositAmount:     calc.DepositAmount,
+		ChargeableAmount:  calc.ChargeableAmount,
+		PaidAmount:        calc.PaidAmount,
+		RefundAmount:      calc.RefundAmount,
+		DebtAmount:        calc.DebtAmount,
+		CancelledPayments: len(calc.CancelledPayments) + len(calc.ReducedPayments),
+		CancelledAmount:   calc.CancelledAmount,
+		CreatedByID:       userID,
+	}
+	if err := tx.Create(&withdrawal).Error; err != nil {
+		return nil, fmt.Errorf("не удалось сохранить расторжение: %w", err)
+	}
+
+	if calc.Recalculation != 0 {
+		if _, err := postLedgerEntry(tx, models.LedgerEntry{
+			ContractID: contract.ID,
+			EntryType:  models.LedgerAdjustment,
+			Amount:     calc.Recalculation,
+			EntryDate:  calc.WithdrawalDate,
+			Description: fmt.Sprintf("Перерасчет стоимости при выбытии (%s, %d из %d)",
+				withdrawalMethodNames[calc.Method], calc.PeriodsCharged, calc.PeriodsTotal),
+			SourceType:  models.WithdrawalSource,
+			SourceID:    &withdrawal.ID,
+			CreatedByID: userID,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, row := range calc.CancelledPayments {
+		if row.PaidAmount <= 0 {
+			if err := tx.Delete(&models.PlannedPayment{}, row.ID).Error; err != nil {
+				return nil, fmt.Errorf("не удалось отменить платеж графика %d: %w", row.ID, err)
+			}
+			continue
+		}
+		if err := tx.Model(&models.PlannedPayment{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+			"planned_amount": row.PaidAmount,
+			"status":         PlannedStatusPaid,
+			"comment":        strings.TrimSpace(row.Comment + " Уменьшен при выбытии ученика"),
+		}).Error; err != nil {
+			return nil, fmt.Errorf("не удалось уменьшить платеж графика %d: %w", row.ID, err)
+		}
+	}
+	for _, r := range calc.ReducedPayments {
+		if err := tx.Model(&models.PlannedPayment{}).Where("id = ?", r.Payment.ID).Updates(map[string]interface{}{
+			"planned_amount": r.NewAmount,
+			"comment":        strings.TrimSpace(r.Payment.Comment + " Уменьшен при выбытии ученика"),
+		}).Error; err != nil {
+			return nil, fmt.Errorf("не удалось уменьшить платеж графика %d: %w", r.Payment.ID, err)
+		}
+	}
+
+	if err := tx.Model(&models.Contract{}).Where("id = ?", contract.ID).Updates(map[string]interface{}{
+		"terminated_at": calc.WithdrawalDate,
+		"end_date":      calc.WithdrawalDate,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Student{}).Where("id = ?", contract.StudentID).Updates(map[string]interface{}{
+		"is_studying": false,
+		"end_date":    calc.WithdrawalDate,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
+// renderTerminationAgreement заполняет шаблон соглашения о расторжении суммами расчета
+// и сохраняет PDF рядом с договорами (contractsBaseDir()/withdrawals/).
+func renderTerminationAgreement(tx *gorm.DB, withdrawal *models.ContractWithdrawal) error {
+	var contract models.Contract
+	if err := tx.Preload("Student").First(&contract, withdrawal.ContractID).Error; err != nil {
+		return fmt.Errorf("договор не найден: %w", err)
+	}
+
+	var tpl models.ContractTemplate
+	query := tx.Model(&models.ContractTemplate{})
+	if settings := loadWithdrawalSettings(tx); settings.TemplateID != nil {
+		query = query.Where("id = ?", *settings.TemplateID)
+	} else {
+		query = query.Where("classification = ?", TerminationAgreementClassification).Order("id DESC")
+	}
+	if err := query.First(&tpl).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errTerminationTemplateMissing
+		}
+		return err
+	}
+	_, docx, err := loadTemplateVersion(tx, &tpl)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения шаблона соглашения: %w", err)
+	}
+	filled, err := renderDocxTemplate(docx, terminationAgreementTemplateData(tx, &contract, withdrawal))
+	if err != nil {
+		return err
+	}
+	pdfBytes, err := convertDocxToPdf(filled)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(contractsBaseDir(), "withdrawals")
+	if err := ensureDir(dir); err != nil {
+		return fmt.Errorf("не удалось создать директорию для соглашений: %w", err)
+	}
+	name := regexp.MustCompile(`[^0-9A-Za-z._-]+`).ReplaceAllString("termination_"+contract.ContractNumber+".pdf", "_")
+	full := filepath.Join(dir, name)
+	if err := os.WriteFile(full, pdfBytes, 0o644); err != nil {
+		return fmt.Errorf("не удалось записать PDF соглашения: %w", err)
+	}
+	withdrawal.DocumentPath = full
+	return tx.Model(withdrawal).Update("document_path", full).Error
+}
+
+// terminationAgreementTemplateData - значения для шаблона соглашения о расторжении.
+func terminationAgreementTemplateData(tx *gorm.DB, contract *models.Contract, w *models.ContractWithdrawal) TemplateData {
+	school := loadReceiptSettings(tx)
+	data := TemplateData{
+		"contractNumber":       contract.ContractNumber,
+		"contractDate":         contract.CreatedAt,
+		"terminationNumber":    w.DocumentNumber,
+		"SignDate":             w.CreatedAt,
+		"schoolName":           school.SchoolName,
+		"schoolBIN":            school.BIN,
+		"signer":               school.Signer,
+		"withdrawalDate":       formatRussianDate(w.WithdrawalDate),
+		"withdrawalReason":     w.Reason,
+		"withdrawalMethod":     withdrawalMethodNames[w.Method],
+		"periodsCharged":       w.PeriodsCharged,
+		"periodsTotal":         w.PeriodsTotal,
+		"contractSum":          w.ContractAmount,
+		"contractSumText":      numberToWords(w.ContractAmount),
+		"contractSumTextKZ":    numberToWordsKz(w.ContractAmount),
+		"depositAmount":        w.DepositAmount,
+		"depositAmountText":    numberToWords(w.DepositAmount),
+		"chargeableAmount":     w.ChargeableAmount,
+		"chargeableAmountText": numberToWords(w.ChargeableAmount),
+		"paidAmount":           w.PaidAmount,
+		"paidAmountText":       numberToWords(w.PaidAmount),
+		"refundAmount":         w.RefundAmount,
+		"refundAmountText":     numberToWords(w.RefundAmount),
+		"debtAmount":           w.DebtAmount,
+		"debtAmountText":       numberToWords(w.DebtAmount),
+		"hasRefund":            w.RefundAmount > 0,
+		"hasDebt":              w.DebtAmount > 0,
+		"childFullName":        nil,
+		"fioParentForDogovor":  nil,
+		"iinParent":            nil,
+		"iinChild":             nil,
+	}
+	if s := contract.Student; s != nil {
+		data["childFullName"] = strings.TrimSpace(fmt.Sprintf("%s %s %s", s.LastName, s.FirstName, s.MiddleName))
+		data["fioParentForDogovor"] = s.ContractParentName
+		data["iinParent"] = s.ContractParentIIN
+		data["iinChild"] = s.IIN
+	}
+	return data
+}
+
+// --- Обработчики ---
+
+// WithdrawalInput - параметры выбытия ученика.
+type WithdrawalInput struct {
+	WithdrawalDate string `json:"withdrawalDate" binding:"required"`
+	// Method переопределяет способ пересчета из настроек.
+	Method string `json:"method"`
+	Reason string `json:"reason"`
+}
+
+// bindWithdrawalInput разбирает параметры выбытия; при ошибке ответ уже отправлен.
+func bindWithdrawalInput(c *gin.Context) (*WithdrawalInput, time.Time, bool) {
+	var input WithdrawalInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите дату выбытия"})
+		return nil, time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", input.WithdrawalDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Используйте YYYY-MM-DD."})
+		return nil, time.Time{}, false
+	}
+	return &input, date, true
+}
+
+// loadContractForWithdrawal блокирует договор до конца транзакции и проверяет, что он еще не расторгнут.
+func loadContractForWithdrawal(tx *gorm.DB, id string) (*models.Contract, error) {
+	var contract models.Contract
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&contract, id).Error; err != nil {
+		return nil, err
+	}
+	if contract.TerminatedAt != nil {
+		return nil, errContractTerminated
+	}
+	return &contract, nil
+}
+
+// respondWithdrawalError отправляет ответ на ошибку загрузки договора или расчета выбытия.
+func respondWithdrawalError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не найден"})
+	case errors.Is(err, errContractTerminated):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// PreviewContractWithdrawalHandler показывает расчет выбытия без сохранения.
+func PreviewContractWithdrawalHandler(c *gin.Context) {
+	input, date, ok := bindWithdrawalInput(c)
+	if !ok {
+		return
+	}
+	var calc *WithdrawalCalculation
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		contract, err := loadContractForWithdrawal(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		settings := loadWithdrawalSettings(tx)
+		if input.Method == "" {
+			input.Method = settings.Method
+		}
+		calc, err = calculateWithdrawal(tx, contract, date, input.Method, settings)
+		return err
+	})
+	if err != nil {
+		respondWithdrawalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, calc)
+}
+
+// CreateContractWithdrawalHandler оформляет выбытие ученика и расторжение договора.
+// Соглашение о расторжении печатается после фиксации; ошибка печати не отменяет расторжение
+// и возвращается в documentError (документ можно сформировать позже).
+func CreateContractWithdrawalHandler(c *gin.Context) {
+	input, date, ok := bindWithdrawalInput(c)
+	if !ok {
+		return
+	}
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+
+	var withdrawal *models.ContractWithdrawal
+	var calc *WithdrawalCalculation
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		contract, err := loadContractForWithdrawal(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		settings := loadWithdrawalSettings(tx)
+		if input.Method == "" {
+			input.Method = settings.Method
+		}
+		if calc, err = calculateWithdrawal(tx, contract, date, input.Method, settings); err != nil {
+			return err
+		}
+		withdrawal, err = applyWithdrawal(tx, contract, calc, strings.TrimSpace(input.Reason), userID)
+		return err
+	})
+	if err != nil {
+		respondWithdrawalError(c, err)
+		return
+	}
+
+	response := gin.H{"withdrawal": withdrawal, "calculation": calc}
+	if err := renderTerminationAgreement(config.DB, withdrawal); err != nil {
+		response["documentError"] = err.Error()
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetContractWithdrawalHandler возвращает оформленное расторжение договора.
+func GetContractWithdrawalHandler(c *gin.Context) {
+	var withdrawal models.ContractWithdrawal
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).First(&withdrawal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не расторгнут"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"withdrawal": withdrawal, "hasDocument": fileExists(withdrawal.DocumentPath)})
+}
+
+// DownloadTerminationAgreementHandler отдает PDF соглашения о расторжении.
+// Если документа нет или передан regenerate=true, он формируется заново по текущему шаблону.
+func DownloadTerminationAgreementHandler(c *gin.Context) {
+	var withdrawal models.ContractWithdrawal
+	if err := config.DB.Where("contract_id = ?", c.Param("id")).First(&withdrawal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Договор не расторгнут"})
+		return
+	}
+	if c.Query("regenerate") == "true" || !fileExists(withdrawal.DocumentPath) {
+		if err := renderTerminationAgreement(config.DB, &withdrawal); err != nil {
+			status := documentErrorStatus(err, http.StatusBadGateway)
+			if errors.Is(err, errTerminationTemplateMissing) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": "Не удалось сформировать соглашение: " + err.Error()})
+			return
+		}
+	}
+
+	data, err := os.ReadFile(withdrawal.DocumentPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось прочитать PDF соглашения"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filepath.Base(withdrawal.DocumentPath))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// PayWithdrawalRefundHandler отражает выплату возврата после выбытия.
+// Сумма возврата - текущая переплата по журналу расчетов (с учетом поступлений после расторжения).
+func PayWithdrawalRefundHandler(c *gin.Context) {
+	var input struct {
+		RefundDate  string `json:"refundDate"`
+		Description string `json:"description"`
+	}
+	_ = c.ShouldBindJSON(&input)
+	refundDate := time.Now()
+	if input.RefundDate != "" {
+		var err error
+		if refundDate, err = time.Parse("2006-01-02", input.RefundDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты. Используйте YYYY-MM-DD."})
+			return
+		}
+	}
+	description := strings.TrimSpace(input.Description)
+	if description == "" {
+		description = "Возврат при выбытии ученика"
+	}
+	var userID *uint
+	if id, err := getUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+
+	var entry models.LedgerEntry
+	var withdrawal models.ContractWithdrawal
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("contract_id = ?", c.Param("id")).First(&withdrawal).Error; err != nil {
+			return err
+		}
+		balance, err := getContractBalance(tx, withdrawal.ContractID)
+		if err != nil {
+			return err
+		}
+		if balance.Balance >= 0 {
+			return errors.New("по договору нет переплаты к возврату")
+		}
+		entry, err = postLedgerEntry(tx, models.LedgerEntry{
+			ContractID:  withdrawal.ContractID,
+			EntryType:   models.LedgerRefund,
+			Amount:      -balance.Balance,
+			EntryDate:   refundDate,
+			Description: description,
+			SourceType:  models.WithdrawalSource,
+			SourceID:    &withdrawal.ID,
+			CreatedByID: userID,
+		})
+		if err != nil {
+			return err
+		}
+		if err := refreshContractPaidAmount(tx, withdrawal.ContractID); err != nil {
+			return err
+		}
+		return tx.Model(&withdrawal).Update("refunded_at", refundDate).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Договор не расторгнут"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"withdrawal": withdrawal, "entry": entry})
+}
+
+// GetWithdrawalSettingsHandler возвращает правила пересчета при выбытии
+func GetWithdrawalSettingsHandler(c *gin.Context) {
+	respondIntegrationSettings(c, WithdrawalService)
+}
+
+// SaveWithdrawalSettingsHandler сохраняет правила пересчета при выбытии
+func SaveWithdrawalSettingsHandler(c *gin.Context) {
+	var payload struct {
+		Settings WithdrawalSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data: " + err.Error()})
+		return
+	}
+	if _, ok := withdrawalMethodNames[payload.Settings.Method]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Способ пересчета должен быть month, quarter или day"})
+		return
+	}
+	if payload.Settings.DepositAmount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Невозвратный взнос не может быть отрицательным"})
+		return
+	}
+	saveIntegrationSettings(c, WithdrawalService, true, payload.Settings)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"prometheus-crm/models"
+	"testing"
+	"time"
+)
+
+func plannedRow(id uint, date string, planned, paid int64) models.PlannedPayment {
+	d, _ := time.Parse("2006-01-02", date)
+	p := models.PlannedPayment{PaymentDate: d, PlannedAmount: models.Tenge(planned), PaidAmount: models.Tenge(paid)}
+	p.ID = id
+	return p
+}
+
+func TestPlanWithdrawalScheduleReducesOverdueRows(t *testing.T) {
+	schedule := []models.PlannedPayment{
+		plannedRow(1, "2025-09-10", 100000, 100000),
+		plannedRow(2, "2025-10-10", 100000, 0),
+		plannedRow(3, "2025-11-10", 100000, 40000),
+		plannedRow(4, "2025-12-10", 100000, 0),
+		plannedRow(5, "2026-01-10", 100000, 0),
+	}
+	date, _ := time.Parse("2006-01-02", "2025-12-15")
+
+	// После пересчета к оплате 250 000: будущая строка 5 отменяется, из просроченных
+	// строка 4 отменяется целиком, строка 3 уменьшается до 150 000 - 100 000 = 50 000.
+	cancelled, reduced := planWithdrawalSchedule(schedule, date, models.Tenge(250000))
+
+	if len(cancelled) != 2 || cancelled[0].ID != 5 || cancelled[1].ID != 4 {
+		t.Fatalf("cancelled = %+v, want rows 5 and 4", cancelled)
+	}
+	if len(reduced) != 1 || reduced[0].Payment.ID != 3 || reduced[0].NewAmount != models.Tenge(50000) {
+		t.Fatalf("reduced = %+v, want row 3 down to 50000", reduced)
+	}
+
+	var total models.Money
+	changed := map[uint]models.Money{5: models.Tenge(0), 4: models.Tenge(0), 3: models.Tenge(50000)}
+	for _, p := range schedule {
+		if amount, ok := changed[p.ID]; ok {
+			total += models.MaxMoney(amount, p.PaidAmount)
+			continue
+		}
+		total += p.PlannedAmount
+	}
+	if total != models.Tenge(250000) {
+		t.Fatalf("schedule total after withdrawal = %v, want 250000", total)
+	}
+}
+
+func TestPlanWithdrawalScheduleKeepsPaidAmounts(t *testing.T) {
+	schedule := []models.PlannedPayment{
+		plannedRow(1, "2025-09-10", 100000, 100000),
+		plannedRow(2, "2025-10-10", 100000, 60000),
+		plannedRow(3, "2025-11-10", 100000, 0),
+	}
+	date, _ := time.Parse("2006-01-02", "2025-11-10")
+
+	// Переплата: к оплате меньше, чем уже внесено, - просроченные строки сводятся к оплатам.
+	cancelled, reduced := planWithdrawalSchedule(schedule, date, models.Tenge(120000))
+
+	if len(reduced) != 0 {
+		t.Fatalf("reduced = %+v, want none", reduced)
+	}
+	if len(cancelled) != 2 || cancelled[0].ID != 3 || cancelled[1].ID != 2 {
+		t.Fatalf("cancelled = %+v, want rows 3 and 2", cancelled)
+	}
+}
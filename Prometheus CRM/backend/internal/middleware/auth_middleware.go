@@ -61,6 +61,11 @@ func AuthMiddleware() gin.HandlerFunc {
 			handleAuthError(c, "Invalid token claims")
 			return
 		}
+		// Токен личного кабинета родителя не дает доступа к API сотрудников
+		if claims["kind"] == ParentTokenKind {
+			handleAuthError(c, "Parent token is not allowed here")
+			return
+		}
 
 		userIDFloat, ok := claims["user_id"].(float64)
 		if !ok {
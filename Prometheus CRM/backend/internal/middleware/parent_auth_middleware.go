@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ParentTokenCookie - cookie с токеном личного кабинета родителя. Имя отличается от auth_token
+// сотрудников, чтобы сессии родителя и сотрудника в одном браузере не пересекались.
+const ParentTokenCookie = "parent_token"
+
+// ParentTokenKind - значение claim "kind" в токене родителя. Токены сотрудников его не содержат,
+// а AuthMiddleware отклоняет токены с этим значением.
+const ParentTokenKind = "parent"
+
+// ParentAuthMiddleware пропускает только запросы с действующим токеном родителя и кладет
+// в контекст parent_id. Права сотрудников (roles, permissions) в контекст не попадают, поэтому
+// обработчики API сотрудников для родителя недоступны даже при ошибке в маршрутах.
+func ParentAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, err := c.Cookie(ParentTokenCookie)
+		if err != nil || tokenStr == "" {
+			parts := strings.Split(c.GetHeader("Authorization"), " ")
+			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+				handleParentAuthError(c, "Authorization token not provided")
+				return
+			}
+			tokenStr = parts[1]
+		}
+
+		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return config.JwtKey, nil
+		})
+		if err != nil || !token.Valid {
+			c.SetCookie(ParentTokenCookie, "", -1, "/", "", false, true)
+			handleParentAuthError(c, "Invalid or expired token")
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || claims["kind"] != ParentTokenKind {
+			handleParentAuthError(c, "Invalid token claims")
+			return
+		}
+		parentIDFloat, ok := claims["parent_id"].(float64)
+		if !ok {
+			handleParentAuthError(c, "Invalid parent ID format in token")
+			return
+		}
+
+		// Статус проверяем на каждом запросе: заблокированный родитель теряет доступ сразу
+		var account models.ParentAccount
+		if err := config.DB.Select("id, status").First(&account, uint(parentIDFloat)).Error; err != nil ||
+			account.Status != models.ParentAccountActive {
+			c.SetCookie(ParentTokenCookie, "", -1, "/", "", false, true)
+			handleParentAuthError(c, "Parent account not found or blocked")
+			return
+		}
+
+		c.Set("parent_id", account.ID)
+		c.Next()
+	}
+}
+
+func handleParentAuthError(c *gin.Context, message string) {
+	if strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.Redirect(http.StatusFound, "/parent")
+	} else {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+	}
+	c.Abort()
+}
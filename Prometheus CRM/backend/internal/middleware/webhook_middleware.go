@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"prometheus-crm/config"
+	"prometheus-crm/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Заголовки, в которых внешняя система передает подпись и время отправки.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+
+	// WebhookRawBodyKey - ключ контекста, под которым сохраняется исходное тело запроса.
+	WebhookRawBodyKey = "webhook_raw_body"
+
+	defaultWebhookTolerance = 5 * time.Minute
+
+	// MaxWebhookBodySize ограничивает тело вебхука; запросы больше отклоняются без чтения до конца.
+	MaxWebhookBodySize = 1 << 20
+	// rejectedPayloadPreview - сколько байт тела отклоненного запроса сохраняется в журнале для разбора.
+	// Тело не проверено, поэтому целиком не хранится и повторно не обрабатывается.
+	rejectedPayloadPreview = 1024
+)
+
+// WebhookSignatureMiddleware проверяет HMAC-подпись входящего вебхука.
+// Секрет берется из IntegrationSetting.Settings["secret"] указанного сервиса.
+// Подпись: hex(HMAC-SHA256(secret, "<timestamp>.<body>")), timestamp - Unix-время в секундах.
+// Запросы старше Settings["toleranceSeconds"] (по умолчанию 5 минут) отклоняются как повторы.
+func WebhookSignatureMiddleware(serviceName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxWebhookBodySize+1))
+		if err != nil {
+			rejectWebhook(c, serviceName, nil, "Не удалось прочитать тело запроса")
+			return
+		}
+		if len(body) > MaxWebhookBodySize {
+			rejectWebhook(c, serviceName, body, "Тело запроса превышает допустимый размер")
+			return
+		}
+		// Возвращаем тело обратно, чтобы обработчик мог его разобрать.
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Set(WebhookRawBodyKey, body)
+
+		var setting models.IntegrationSetting
+		if err := config.DB.Where("service_name = ?", serviceName).First(&setting).Error; err != nil || !setting.IsEnabled {
+			rejectWebhook(c, serviceName, body, "Интеграция не настроена или отключена")
+			return
+		}
+
+		secret, _ := setting.Settings["secret"].(string)
+		if secret == "" {
+			rejectWebhook(c, serviceName, body, "Не задан секрет для проверки подписи")
+			return
+		}
+
+		tolerance := defaultWebhookTolerance
+		if v, ok := setting.Settings["toleranceSeconds"].(float64); ok && v > 0 {
+			tolerance = time.Duration(v) * time.Second
+		}
+
+		timestampStr := c.GetHeader(WebhookTimestampHeader)
+		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+		if err != nil {
+			rejectWebhook(c, serviceName, body, "Отсутствует или некорректен заголовок "+WebhookTimestampHeader)
+			return
+		}
+		if math.Abs(time.Since(time.Unix(timestamp, 0)).Seconds()) > tolerance.Seconds() {
+			rejectWebhook(c, serviceName, body, "Истек срок действия запроса (timestamp)")
+			return
+		}
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestampStr + "."))
+		mac.Write(body)
+		expected := mac.Sum(nil)
+
+		provided, err := hex.DecodeString(c.GetHeader(WebhookSignatureHeader))
+		if err != nil || !hmac.Equal(provided, expected) {
+			rejectWebhook(c, serviceName, body, "Неверная подпись запроса")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RejectedWebhookPayload - то, что сохраняется в журнале от тела неподтвержденного запроса:
+// только начало (см. rejectedPayloadPreview).
+func RejectedWebhookPayload(body []byte) string {
+	if len(body) > rejectedPayloadPreview {
+		body = body[:rejectedPayloadPreview]
+	}
+	return strings.ToValidUTF8(string(body), "")
+}
+
+// rejectWebhook записывает отклоненную доставку в журнал и прерывает запрос.
+// От неподтвержденного запроса сохраняются размер и начало тела (см. RejectedWebhookPayload).
+func rejectWebhook(c *gin.Context, serviceName string, body []byte, message string) {
+	entry := models.InboundWebhook{
+		ServiceName:  serviceName,
+		Signature:    c.GetHeader(WebhookSignatureHeader),
+		Payload:      RejectedWebhookPayload(body),
+		Status:       models.WebhookStatusRejected,
+		ResponseCode: http.StatusUnauthorized,
+		Error:        message + " (тело запроса: " + strconv.Itoa(len(body)) + " байт)",
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		slog.Error("Failed to log rejected webhook", "service", serviceName, "error", err)
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+	c.Abort()
+}
@@ -105,14 +105,25 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 			students.DELETE("/:id/relatives/:relativeId", middleware.PermissionMiddleware("students_edit"), handlers.RemoveFamilyLinkHandler)
 			students.POST("/family-order", middleware.PermissionMiddleware("students_edit"), handlers.UpdateFamilyOrderHandler)
 			students.GET("/:id/contracts", handlers.ListStudentContractsHandler)
+			students.GET("/:id/reconciliation-act", middleware.PermissionMiddleware("contracts_view"), handlers.GetFamilyReconciliationActHandler)
+			students.GET("/:id/discounts", handlers.ListStudentDiscountsHandler)
+			students.POST("/:id/discounts", middleware.PermissionMiddleware("discounts_manage"), handlers.CreateStudentDiscountHandler)
+			students.DELETE("/:id/discounts/:discountId", middleware.PermissionMiddleware("discounts_manage"), handlers.DeleteStudentDiscountHandler)
 		}
 
-		// --- СТОИМОСТЬ ОБУЧЕНИЯ ---
-		tuitionFees := apiGroup.Group("/tuition-fees")
-		tuitionFees.Use(middleware.PermissionMiddleware("tuition_fees_view"))
+		// --- СТОИМОСТЬ ОБУЧЕНИЯ (прайс-лист) ---
+		tuitionPrices := apiGroup.Group("/tuition-prices")
+		tuitionPrices.Use(middleware.PermissionMiddleware("tuition_fees_view"))
 		{
-			tuitionFees.GET("", handlers.GetTuitionFeesHandler)
-			tuitionFees.POST("", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.UpdateTuitionFeesHandler)
+			tuitionPrices.GET("", handlers.ListTuitionPricesHandler)
+			tuitionPrices.POST("", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.CreateTuitionPriceHandler)
+			tuitionPrices.PUT("/:id", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.UpdateTuitionPriceHandler)
+			tuitionPrices.DELETE("/:id", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.DeleteTuitionPriceHandler)
+			tuitionPrices.POST("/index", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.IndexTuitionPricesHandler)
+			tuitionPrices.GET("/grandfather-rules", handlers.ListTuitionGrandfatherRulesHandler)
+			tuitionPrices.POST("/grandfather-rules", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.CreateTuitionGrandfatherRuleHandler)
+			tuitionPrices.PUT("/grandfather-rules/:id", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.UpdateTuitionGrandfatherRuleHandler)
+			tuitionPrices.DELETE("/grandfather-rules/:id", middleware.PermissionMiddleware("tuition_fees_edit"), handlers.DeleteTuitionGrandfatherRuleHandler)
 		}
 
 		// --- ПОЛЬЗОВАТЕЛИ ---
@@ -147,6 +158,18 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 			permissions.DELETE("/:id", middleware.PermissionMiddleware("permissions_delete"), handlers.DeletePermissionHandler)
 		}
 
+		// --- УЧЕБНЫЕ ГОДЫ ---
+		academicYears := apiGroup.Group("/academic-years")
+		{
+			academicYears.GET("", handlers.ListAcademicYearsHandler)
+			academicYears.GET("/current", handlers.GetCurrentAcademicYearHandler)
+			academicYears.GET("/:id", handlers.GetAcademicYearHandler)
+			academicYears.POST("", middleware.PermissionMiddleware("academic_years_manage"), handlers.CreateAcademicYearHandler)
+			academicYears.PUT("/:id", middleware.PermissionMiddleware("academic_years_manage"), handlers.UpdateAcademicYearHandler)
+			academicYears.DELETE("/:id", middleware.PermissionMiddleware("academic_years_manage"), handlers.DeleteAcademicYearHandler)
+			academicYears.POST("/:id/make-current", middleware.PermissionMiddleware("academic_years_manage"), handlers.SetCurrentAcademicYearHandler)
+		}
+
 		// --- ПРЕДМЕТЫ (для расписания) ---
 		subjects := apiGroup.Group("/subjects")
 		{
@@ -172,14 +195,42 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 			contracts.GET("", handlers.ListContractsHandler)
 			contracts.GET("/all-for-plan", handlers.ListAllContractsForPlanHandler)
 			contracts.POST("", middleware.PermissionMiddleware("contracts_create"), handlers.CreateContractHandler)
+			contracts.GET("/tuition-quote", handlers.GetTuitionQuoteHandler)
 			contracts.GET("/:id", handlers.GetContractHandler)
 			contracts.PUT("/:id", middleware.PermissionMiddleware("contracts_edit"), handlers.UpdateContractHandler)
 			contracts.DELETE("/:id", middleware.PermissionMiddleware("contracts_delete"), handlers.DeleteContractHandler)
+			contracts.POST("/:id/renew", middleware.PermissionMiddleware("contracts_create"), handlers.RenewContractHandler)
 			contracts.POST("/:id/generate-schedule", middleware.PermissionMiddleware("contracts_edit"), handlers.GenerateScheduleHandler)
 			contracts.GET("/:id/download", handlers.DownloadContractHandler)
+			contracts.POST("/:id/pdf/retry", middleware.PermissionMiddleware("contracts_edit"), handlers.RetryContractPDFHandler)
 			contracts.POST("/:id/preview-plan", handlers.PreviewPaymentPlanHandler)
 			contracts.POST("/:id/generate-plan", middleware.PermissionMiddleware("planned_payments_generate"), handlers.GeneratePaymentPlanForContractHandler)
 			contracts.POST("/:id/comment", middleware.PermissionMiddleware("contracts_edit"), handlers.UpdateContractCommentHandler)
+			contracts.GET("/:id/promises", handlers.ListPaymentPromisesHandler)
+			contracts.POST("/:id/promises", middleware.PermissionMiddleware("contracts_edit"), handlers.CreatePaymentPromiseHandler)
+			contracts.GET("/:id/discounts", handlers.ListContractDiscountLinesHandler)
+			contracts.POST("/:id/discounts/recalculate", middleware.PermissionMiddleware("contracts_edit"), handlers.RecalculateContractDiscountsHandler)
+			contracts.GET("/:id/allocations", handlers.ListContractAllocationsHandler)
+			contracts.GET("/:id/balance", handlers.GetContractBalanceHandler)
+			contracts.GET("/:id/reconciliation-act", handlers.GetContractReconciliationActHandler)
+			contracts.GET("/:id/payers", handlers.ListContractPayersHandler)
+			contracts.POST("/:id/payers", middleware.PermissionMiddleware("contracts_edit"), handlers.CreateContractPayerHandler)
+			contracts.PUT("/:id/payers/:payerId", middleware.PermissionMiddleware("contracts_edit"), handlers.UpdateContractPayerHandler)
+			contracts.DELETE("/:id/payers/:payerId", middleware.PermissionMiddleware("contracts_edit"), handlers.DeleteContractPayerHandler)
+			contracts.GET("/:id/payers/:payerId/reconciliation-act", handlers.GetPayerReconciliationActHandler)
+			contracts.GET("/:id/amendments", handlers.ListContractAmendmentsHandler)
+			contracts.GET("/:id/amendments/:amendmentId/download", handlers.DownloadContractAmendmentHandler)
+			contracts.GET("/:id/versions", handlers.ListContractVersionsHandler)
+			contracts.GET("/withdrawal-settings", middleware.PermissionMiddleware("contracts_withdraw"), handlers.GetWithdrawalSettingsHandler)
+			contracts.POST("/withdrawal-settings", middleware.PermissionMiddleware("contracts_withdraw"), handlers.SaveWithdrawalSettingsHandler)
+			contracts.GET("/:id/withdrawal", handlers.GetContractWithdrawalHandler)
+			contracts.POST("/:id/withdrawal/preview", middleware.PermissionMiddleware("contracts_withdraw"), handlers.PreviewContractWithdrawalHandler)
+			contracts.POST("/:id/withdrawal", middleware.PermissionMiddleware("contracts_withdraw"), handlers.CreateContractWithdrawalHandler)
+			contracts.GET("/:id/withdrawal/document", handlers.DownloadTerminationAgreementHandler)
+			contracts.POST("/:id/withdrawal/refund", middleware.PermissionMiddleware("contracts_withdraw"), handlers.PayWithdrawalRefundHandler)
+			contracts.GET("/:id/ledger", handlers.ListContractLedgerHandler)
+			contracts.POST("/:id/ledger", middleware.PermissionMiddleware("ledger_manage"), handlers.CreateLedgerEntryHandler)
+			contracts.POST("/:id/ledger/:entryId/reverse", middleware.PermissionMiddleware("ledger_manage"), handlers.ReverseLedgerEntryHandler)
 			// (удалена битая строка: auth.GET("/contracts/:id/download", h.DownloadContractHandler))
 		}
 
@@ -217,8 +268,12 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 		paymentForms.Use(middleware.PermissionMiddleware("payment_forms_view"))
 		{
 			paymentForms.GET("", handlers.ListPaymentFormsHandler)
+			paymentForms.GET("/variables", handlers.ListPaymentFormulaVariablesHandler)
+			paymentForms.POST("/dry-run", handlers.DryRunPaymentFormHandler)
 			paymentForms.POST("", middleware.PermissionMiddleware("payment_forms_create"), handlers.CreatePaymentFormHandler)
 			paymentForms.GET("/:id", handlers.GetPaymentFormHandler)
+			paymentForms.GET("/:id/dry-run", handlers.DryRunSavedPaymentFormHandler)
+			paymentForms.GET("/:id/dry-run/contracts", handlers.DryRunPaymentFormContractsHandler)
 			paymentForms.PUT("/:id", middleware.PermissionMiddleware("payment_forms_edit"), handlers.UpdatePaymentFormHandler)
 			paymentForms.DELETE("/:id", middleware.PermissionMiddleware("payment_forms_delete"), handlers.DeletePaymentFormHandler)
 		}
@@ -228,8 +283,11 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 		{
 			contractTemplates.GET("", middleware.PermissionMiddleware("contract_templates_view"), handlers.ListContractTemplatesHandler)
 			contractTemplates.GET("/:id", middleware.PermissionMiddleware("contract_templates_view"), handlers.GetContractTemplateHandler)
+			contractTemplates.GET("/:id/versions", middleware.PermissionMiddleware("contract_templates_view"), handlers.ListContractTemplateVersionsHandler)
+			contractTemplates.GET("/:id/preview", middleware.PermissionMiddleware("contract_templates_view"), handlers.PreviewContractTemplateHandler)
 			contractTemplates.POST("", middleware.PermissionMiddleware("contract_templates_create"), handlers.CreateContractTemplateHandler)
 			contractTemplates.PUT("/:id", middleware.PermissionMiddleware("contract_templates_edit"), handlers.UpdateContractTemplateHandler)
+			contractTemplates.PUT("/:id/versions/:versionId/activate", middleware.PermissionMiddleware("contract_templates_edit"), handlers.ActivateContractTemplateVersionHandler)
 			contractTemplates.DELETE("/:id", middleware.PermissionMiddleware("contract_templates_delete"), handlers.DeleteContractTemplateHandler)
 		}
 
@@ -239,6 +297,8 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 		{
 			plannedPayments.GET("/", handlers.ListPlannedPaymentsHandler)
 			plannedPayments.GET("/export", handlers.ExportPlannedPaymentsHandler)
+			plannedPayments.GET("/forecast", handlers.GetCashFlowForecastHandler)
+			plannedPayments.GET("/forecast/export", handlers.ExportCashFlowForecastHandler)
 			plannedPayments.GET("/:id", handlers.GetPlannedPaymentHandler)
 			plannedPayments.PUT("/:id", middleware.PermissionMiddleware("planned_payments_edit"), handlers.UpdatePlannedPaymentHandler)
 			plannedPayments.DELETE("/:id", middleware.PermissionMiddleware("planned_payments_edit"), handlers.DeletePlannedPaymentHandler)
@@ -250,12 +310,6 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 			payments.POST("/actual", middleware.PermissionMiddleware("actual_payments_create"), handlers.CreateActualPayment)
 		}
 
-		// --- ВНЕШНИЕ СЕРВИСЫ (WEBHOOKS) ---
-		webhooks := apiGroup.Group("/webhooks")
-		{
-			webhooks.POST("/1c-payment", handlers.Webhook1CHandler)
-		}
-
 		// --- ФАКТИЧЕСКИЕ ПЛАТЕЖИ (CRUD) ---
 		paymentFacts := apiGroup.Group("/payment-facts")
 		{
@@ -266,10 +320,98 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 			paymentFacts.DELETE("/:id", handlers.DeletePaymentFact)
 		}
 
+		// --- КВИТАНЦИИ ---
+		receipts := apiGroup.Group("/receipts")
+		receipts.Use(middleware.PermissionMiddleware("contracts_view"))
+		{
+			receipts.GET("", handlers.ListReceiptsHandler)
+			receipts.GET("/settings", middleware.PermissionMiddleware("receipts_manage"), handlers.GetReceiptSettingsHandler)
+			receipts.POST("/settings", middleware.PermissionMiddleware("receipts_manage"), handlers.SaveReceiptSettingsHandler)
+			receipts.GET("/:id/download", handlers.DownloadReceiptHandler)
+			receipts.POST("/:id/reissue", middleware.PermissionMiddleware("receipts_manage"), handlers.ReissueReceiptHandler)
+		}
+
+		// --- НУМЕРАЦИЯ ДОКУМЕНТОВ ---
+		numbering := apiGroup.Group("/numbering")
+		numbering.Use(middleware.PermissionMiddleware("numbering_manage"))
+		{
+			numbering.GET("/settings", handlers.GetNumberingSettingsHandler)
+			numbering.POST("/settings", handlers.SaveNumberingSettingsHandler)
+			numbering.GET("/sequences", handlers.ListDocumentSequencesHandler)
+			numbering.PUT("/sequences", handlers.ResetDocumentSequenceHandler)
+		}
+
+		// --- БАНКОВСКИЕ ВЫПИСКИ ---
+		bankStatements := apiGroup.Group("/bank-statements")
+		bankStatements.Use(middleware.PermissionMiddleware("bank_statements_view"))
+		{
+			bankStatements.GET("", handlers.ListBankStatementsHandler)
+			bankStatements.GET("/unmatched", handlers.ListUnmatchedStatementLinesHandler)
+			bankStatements.GET("/:id", handlers.GetBankStatementHandler)
+			bankStatements.POST("/import", middleware.PermissionMiddleware("bank_statements_import"), handlers.ImportBankStatementHandler)
+			bankStatements.POST("/lines/:id/resolve", middleware.PermissionMiddleware("bank_statements_import"), handlers.ResolveStatementLineHandler)
+			bankStatements.POST("/lines/:id/ignore", middleware.PermissionMiddleware("bank_statements_import"), handlers.IgnoreStatementLineHandler)
+		}
+
+		// --- ССЫЛКИ НА ОНЛАЙН-ОПЛАТУ ---
+		paymentLinks := apiGroup.Group("/payment-links")
+		paymentLinks.Use(middleware.PermissionMiddleware("payment_links_manage"))
+		{
+			paymentLinks.GET("", handlers.ListPaymentLinksHandler)
+			paymentLinks.POST("", handlers.CreatePaymentLinkHandler)
+			paymentLinks.POST("/:id/cancel", handlers.CancelPaymentLinkHandler)
+		}
+
+		// --- ЛИЧНЫЙ КАБИНЕТ РОДИТЕЛЯ: УЧЕТНЫЕ ЗАПИСИ ---
+		parentAccounts := apiGroup.Group("/parent-accounts")
+		parentAccounts.Use(middleware.PermissionMiddleware("parent_accounts_manage"))
+		{
+			parentAccounts.GET("", handlers.ListParentAccountsHandler)
+			parentAccounts.POST("", handlers.CreateParentAccountHandler)
+			parentAccounts.PUT("/:id", handlers.UpdateParentAccountHandler)
+			parentAccounts.POST("/:id/match-students", handlers.MatchParentAccountStudentsHandler)
+			parentAccounts.DELETE("/:id", handlers.DeleteParentAccountHandler)
+		}
+
 		// --- СВЕРКА ПЛАТЕЖЕЙ ---
 		reconciliation := apiGroup.Group("/payment-reconciliation")
 		{
 			reconciliation.GET("/debtors", middleware.PermissionMiddleware("payment_reconciliation_view"), handlers.ListDebtorsHandler)
+			reconciliation.GET("/debtors/export", middleware.PermissionMiddleware("payment_reconciliation_view"), handlers.ExportDebtorsHandler)
+		}
+
+		// --- ПРИЗНАНИЕ ВЫРУЧКИ ---
+		revenue := apiGroup.Group("/revenue-recognition")
+		revenue.Use(middleware.PermissionMiddleware("revenue_recognition_view"))
+		{
+			revenue.GET("", handlers.GetRevenueRecognitionHandler)
+			revenue.GET("/export", handlers.ExportRevenueRecognitionHandler)
+		}
+
+		// --- СКИДКИ ---
+		discounts := apiGroup.Group("/discounts")
+		discounts.Use(middleware.PermissionMiddleware("discounts_manage"))
+		{
+			discounts.GET("/rules", handlers.ListDiscountRulesHandler)
+			discounts.POST("/rules", handlers.CreateDiscountRuleHandler)
+			discounts.PUT("/rules/:id", handlers.UpdateDiscountRuleHandler)
+			discounts.DELETE("/rules/:id", handlers.DeleteDiscountRuleHandler)
+			discounts.GET("/settings", handlers.GetDiscountSettingsHandler)
+			discounts.POST("/settings", handlers.SaveDiscountSettingsHandler)
+		}
+
+		// --- НАПОМИНАНИЯ ОБ ОПЛАТЕ ---
+		dunning := apiGroup.Group("/dunning")
+		dunning.Use(middleware.PermissionMiddleware("dunning_manage"))
+		{
+			dunning.GET("/steps", handlers.ListDunningStepsHandler)
+			dunning.POST("/steps", handlers.CreateDunningStepHandler)
+			dunning.PUT("/steps/:id", handlers.UpdateDunningStepHandler)
+			dunning.DELETE("/steps/:id", handlers.DeleteDunningStepHandler)
+			dunning.GET("/reminders", handlers.ListDunningRemindersHandler)
+			dunning.POST("/run", handlers.RunDunningHandler)
+			dunning.GET("/settings", handlers.GetDunningSettingsHandler)
+			dunning.POST("/settings", handlers.SaveDunningSettingsHandler)
 		}
 
 		// --- ИНТЕГРАЦИИ ---
@@ -286,6 +428,47 @@ func RegisterAPIRoutes(api *gin.RouterGroup) {
 			// Маршруты, доступные с правом просмотра
 			trustme.GET("/contracts-to-sign", handlers.ListContractsForSigningHandler)
 			trustme.GET("/documents", handlers.ListSentTrustMeDocumentsHandler)
+
+			onec := integrations.Group("/1c")
+			onec.Use(middleware.PermissionMiddleware("integrations_manage"))
+			{
+				onec.GET("/settings", handlers.GetOneCSettingsHandler)
+				onec.POST("/settings", handlers.SaveOneCSettingsHandler)
+			}
+
+			smtpGroup := integrations.Group("/smtp")
+			smtpGroup.Use(middleware.PermissionMiddleware("integrations_manage"))
+			{
+				smtpGroup.GET("/settings", handlers.GetEmailSettingsHandler)
+				smtpGroup.POST("/settings", handlers.SaveEmailSettingsHandler)
+			}
+
+			sms := integrations.Group("/sms")
+			sms.Use(middleware.PermissionMiddleware("integrations_manage"))
+			{
+				sms.GET("/settings", handlers.GetSMSSettingsHandler)
+				sms.POST("/settings", handlers.SaveSMSSettingsHandler)
+			}
+
+			paymentGateway := integrations.Group("/payment-gateway")
+			paymentGateway.Use(middleware.PermissionMiddleware("integrations_manage"))
+			{
+				paymentGateway.GET("/settings", handlers.GetPaymentGatewaySettingsHandler)
+				paymentGateway.POST("/settings", handlers.SavePaymentGatewaySettingsHandler)
+			}
+
+			// Конвертация документов в PDF: проверка доступности конвертера и его настройки
+			integrations.GET("/document-converter/health", handlers.GetDocumentConverterHealthHandler)
+			documentConverter := integrations.Group("/document-converter")
+			documentConverter.Use(middleware.PermissionMiddleware("integrations_manage"))
+			{
+				documentConverter.GET("/settings", handlers.GetDocumentConverterSettingsHandler)
+				documentConverter.POST("/settings", handlers.SaveDocumentConverterSettingsHandler)
+			}
+
+			// Журнал входящих вебхуков и повторная обработка неудачных доставок
+			integrations.GET("/webhooks", handlers.ListInboundWebhooksHandler)
+			integrations.POST("/webhooks/:id/replay", middleware.PermissionMiddleware("integrations_manage"), handlers.ReplayInboundWebhookHandler)
 		}
 	} // конец apiGroup
 }
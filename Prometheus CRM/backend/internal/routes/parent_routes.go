@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"prometheus-crm/internal/handlers"
+	"prometheus-crm/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterParentPortalRoutes регистрирует страницу входа и API личного кабинета родителя.
+// API защищено ParentAuthMiddleware, а не AuthMiddleware: токен родителя не принимается
+// маршрутами сотрудников, а токен сотрудника - маршрутами родителя.
+func RegisterParentPortalRoutes(r *gin.Engine) {
+	r.GET("/parent", handlers.ShowParentPortalPage)
+	r.POST("/parent/login", handlers.ParentLoginHandler)
+	r.GET("/parent/logout", handlers.ParentLogoutHandler)
+
+	parent := r.Group("/api/parent")
+	parent.Use(middleware.ParentAuthMiddleware())
+	{
+		parent.GET("/profile", handlers.GetParentProfileHandler)
+		parent.GET("/contracts", handlers.ListParentContractsHandler)
+		parent.GET("/contracts/:id/download", handlers.DownloadParentContractHandler)
+		parent.GET("/contracts/:id/schedule", handlers.GetParentContractScheduleHandler)
+		parent.GET("/contracts/:id/payments", handlers.ListParentContractPaymentsHandler)
+		parent.GET("/receipts/:id/download", handlers.DownloadParentReceiptHandler)
+		parent.GET("/news", handlers.ListParentNewsHandler)
+		parent.POST("/news/:id/vote/:optionId", handlers.VoteParentPollHandler)
+	}
+}
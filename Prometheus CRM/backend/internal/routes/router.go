@@ -1,6 +1,8 @@
 package routes
 
 import (
+	"context"
+	"prometheus-crm/internal/handlers"
 	"prometheus-crm/internal/middleware"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +15,16 @@ func SetupRoutes(r *gin.Engine) {
 	// Это страницы входа, регистрации и обработчики их форм.
 	RegisterAuthRoutes(r)
 
+	// Вебхуки внешних систем (1С) не используют JWT пользователя:
+	// каждый запрос подписывается секретом интеграции.
+	RegisterWebhookRoutes(r)
+
+	// Страницы оплаты по ссылке открывает родитель без входа в CRM.
+	RegisterPaymentLinkRoutes(r)
+
+	// Личный кабинет родителя: собственный вход и токен, доступ только к данным своих детей.
+	RegisterParentPortalRoutes(r)
+
 	// --- Защищенная группа маршрутов ---
 	// Все маршруты в этой группе требуют, чтобы пользователь был аутентифицирован.
 	// Middleware `AuthMiddleware` проверяет наличие и валидность JWT токена.
@@ -23,4 +35,13 @@ func SetupRoutes(r *gin.Engine) {
 		RegisterDashboardRoutes(authRequired) // Главная панель управления
 		RegisterAPIRoutes(authRequired)       // Все API-маршруты
 	}
+
+	startBackgroundJobs(context.Background())
+}
+
+// startBackgroundJobs запускает фоновые задачи приложения. SetupRoutes вызывается один раз
+// при старте сервера, поэтому задачи живут столько же, сколько процесс.
+func startBackgroundJobs(ctx context.Context) {
+	handlers.StartDunningScheduler(ctx)  // ежедневные напоминания о задолженности
+	handlers.StartContractPDFWorker(ctx) // очередь PDF договоров и догоняющий обход после перезапуска
 }
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"prometheus-crm/internal/handlers"
+	"prometheus-crm/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterWebhookRoutes регистрирует публичные маршруты для вебхуков внешних систем.
+// Аутентификация выполняется по HMAC-подписи с секретом из настроек интеграции.
+func RegisterWebhookRoutes(r *gin.Engine) {
+	webhooks := r.Group("/api/webhooks")
+	{
+		webhooks.POST("/1c-payment", middleware.WebhookSignatureMiddleware(handlers.OneCService), handlers.Webhook1CHandler)
+		// Уведомления эквайринга: подпись проверяет адаптер шлюза, а не общий middleware.
+		webhooks.POST("/payment-gateway/:gateway", handlers.PaymentGatewayCallbackHandler)
+	}
+}
+
+// RegisterPaymentLinkRoutes регистрирует публичные страницы онлайн-оплаты для родителей.
+// Доступ к ссылке дает только случайный токен. Страницы тестового шлюза отвечают 404,
+// если в настройках онлайн-оплаты выбран не тестовый шлюз.
+func RegisterPaymentLinkRoutes(r *gin.Engine) {
+	r.GET("/pay/:token", handlers.PaymentLinkRedirectHandler)
+	r.GET("/fake-gateway/:token", handlers.FakeGatewayPageHandler)
+	r.POST("/fake-gateway/:token", handlers.FakeGatewayCompleteHandler)
+}
@@ -0,0 +1,50 @@
+// prometheus-crm/models/academic_year.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AcademicYear - учебный год: сроки обучения, четверти и каникулы.
+// Даты договора, годы платежей графика и текст договора рассчитываются от него.
+type AcademicYear struct {
+	gorm.Model
+	// Name - обозначение года, например "2025-2026". Используется в расписании и платежах.
+	Name      string    `json:"name" gorm:"size:20;uniqueIndex;not null"`
+	StartDate time.Time `json:"startDate" gorm:"type:date;not null"`
+	EndDate   time.Time `json:"endDate" gorm:"type:date;not null"`
+
+	Quarters []AcademicQuarter `json:"quarters" gorm:"foreignKey:AcademicYearID"`
+	Holidays []AcademicHoliday `json:"holidays" gorm:"foreignKey:AcademicYearID"`
+}
+
+// DateInYear возвращает дату с указанным месяцем и днем внутри учебного года.
+// Месяцы после месяца окончания обучения (летние предоплаты и осень) относятся к году начала,
+// остальные - к году окончания: для 2025-2026 июнь-декабрь это 2025, январь-май - 2026.
+func (y AcademicYear) DateInYear(month time.Month, day int) time.Time {
+	year := y.EndDate.Year()
+	if month > y.EndDate.Month() {
+		year = y.StartDate.Year()
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// AcademicQuarter - учебная четверть.
+type AcademicQuarter struct {
+	gorm.Model
+	AcademicYearID uint      `json:"academicYearId" gorm:"not null;index"`
+	Number         int       `json:"number" gorm:"not null"`
+	StartDate      time.Time `json:"startDate" gorm:"type:date;not null"`
+	EndDate        time.Time `json:"endDate" gorm:"type:date;not null"`
+}
+
+// AcademicHoliday - каникулы или нерабочий период внутри учебного года.
+type AcademicHoliday struct {
+	gorm.Model
+	AcademicYearID uint      `json:"academicYearId" gorm:"not null;index"`
+	Name           string    `json:"name" gorm:"size:255;not null"`
+	StartDate      time.Time `json:"startDate" gorm:"type:date;not null"`
+	EndDate        time.Time `json:"endDate" gorm:"type:date;not null"`
+}
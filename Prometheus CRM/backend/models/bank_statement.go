@@ -0,0 +1,62 @@
+// crm/models/bank_statement.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы строки банковской выписки.
+const (
+	StatementLineMatched   = "matched"   // платеж создан автоматически
+	StatementLineUnmatched = "unmatched" // ждет ручного сопоставления
+	StatementLineResolved  = "resolved"  // сопоставлена вручную
+	StatementLineIgnored   = "ignored"   // не относится к оплате обучения
+	StatementLineDuplicate = "duplicate" // уже была загружена из другой выписки
+)
+
+// BankStatement - загруженный файл банковской выписки.
+// FileHash уникален: повторная загрузка того же файла ничего не меняет.
+type BankStatement struct {
+	gorm.Model
+	FileName       string     `json:"fileName"`
+	Format         string     `gorm:"not null" json:"format"`
+	FileHash       string     `gorm:"uniqueIndex;not null" json:"fileHash"`
+	AccountNumber  string     `json:"accountNumber"`
+	PeriodFrom     *time.Time `gorm:"type:date" json:"periodFrom"`
+	PeriodTo       *time.Time `gorm:"type:date" json:"periodTo"`
+	TotalLines     int        `json:"totalLines"`
+	MatchedLines   int        `json:"matchedLines"`
+	UnmatchedLines int        `json:"unmatchedLines"`
+	// TotalAmount - сумма импортированных строк; дубликаты из других выписок не учитываются.
+	TotalAmount  Money `gorm:"type:numeric(14,2)" json:"totalAmount"`
+	ImportedByID *uint `json:"importedById"`
+
+	Lines []BankStatementLine `gorm:"foreignKey:StatementID" json:"lines,omitempty"`
+}
+
+// BankStatementLine - одно поступление из выписки и результат его сопоставления с договором.
+// LineHash уникален среди всех выписок, поэтому пересекающиеся периоды не дают двойных зачислений.
+type BankStatementLine struct {
+	gorm.Model
+	StatementID    uint      `gorm:"not null;index" json:"statementId"`
+	LineHash       string    `gorm:"uniqueIndex;not null" json:"lineHash"`
+	DocumentNumber string    `json:"documentNumber"`
+	OperationDate  time.Time `gorm:"type:date" json:"operationDate"`
+	Amount         Money     `gorm:"type:numeric(12,2)" json:"amount"`
+	Currency       string    `json:"currency"`
+	PayerName      string    `json:"payerName"`
+	PayerIIN       string    `json:"payerIin"`
+	PayerAccount   string    `json:"payerAccount"`
+	Purpose        string    `gorm:"type:text" json:"purpose"`
+	Status         string    `gorm:"not null;index" json:"status"`
+	// MatchedBy - по какому признаку найден договор: contract_number, student_iin, parent_iin, manual.
+	MatchedBy  string    `json:"matchedBy"`
+	MatchNote  string    `json:"matchNote"`
+	ContractID *uint     `gorm:"index" json:"contractId"`
+	Contract   *Contract `json:"contract,omitempty"`
+	// PaymentEntryID - проводка-оплата, созданная по строке выписки.
+	PaymentEntryID *uint `json:"paymentEntryId"`
+	ResolvedByID   *uint `json:"resolvedById"`
+}
@@ -22,7 +22,7 @@ type BudgetItem struct {
 type RegistryEntry struct {
 	gorm.Model
 	Name         string `json:"name"`
-	Amount       int64  `json:"budget_amount"`
+	Amount       Money  `json:"budget_amount"`
 	BudgetItemID uint   `json:"budget_item_id"`
 	// --- ИСПРАВЛЕНИЕ ЗДЕСЬ: Добавлен тег gorm для явного указания связи ---
 	BudgetItem BudgetItem `json:"budget_item" gorm:"foreignKey:BudgetItemID"`
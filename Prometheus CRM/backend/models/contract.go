@@ -6,6 +6,14 @@ import (
 	"gorm.io/gorm"
 )
 
+// Статусы формирования PDF договора. PDF конвертируется в фоне после создания договора:
+// заполненный DOCX ждет на диске, пока конвертер не вернет PDF.
+const (
+	ContractPDFPending = "pending" // DOCX заполнен, PDF в очереди
+	ContractPDFReady   = "ready"
+	ContractPDFFailed  = "failed" // конвертация не удалась, будет повторена
+)
+
 // Contract описывает договор.
 // PDF хранится на диске; в БД пишем только путь в поле pdf_path.
 type Contract struct {
@@ -19,14 +27,22 @@ type Contract struct {
 	EndDate            *time.Time `gorm:"column:end_date"                     json:"endDate,omitempty"`
 	SigningMethod      string     `gorm:"column:signing_method"               json:"signingMethod"`
 	PaymentFormId      *uint      `gorm:"column:payment_form_id"              json:"paymentFormId,omitempty"`
-	TotalAmount        float64    `gorm:"column:total_amount"                 json:"totalAmount"`
+	TotalAmount        Money      `gorm:"column:total_amount"                 json:"totalAmount"`
 	DiscountPercentage float64    `gorm:"column:discount_percentage"          json:"discountPercentage"`
-	DiscountedAmount   float64    `gorm:"column:discounted_amount"            json:"discountedAmount"`
-	PaidAmount         float64    `gorm:"column:paid_amount"                  json:"paidAmount"`
+	DiscountedAmount   Money      `gorm:"column:discounted_amount"            json:"discountedAmount"`
+	PaidAmount         Money      `gorm:"column:paid_amount"                  json:"paidAmount"` // производное от журнала расчетов
 	Comment            string     `gorm:"column:comment"                      json:"comment"`
 
 	// Новый способ хранения PDF: путь к файлу на диске
 	PDFFilePath string `gorm:"column:pdf_path" json:"pdfPath"`
+	// Версия шаблона, по которой сформирован PDF (см. ContractTemplateVersion)
+	TemplateVersionID *uint `gorm:"column:template_version_id" json:"templateVersionId,omitempty"`
+	// Фоновое формирование PDF: статус (пусто - договор без шаблона), заполненный DOCX до конвертации,
+	// число неудачных попыток и последняя ошибка
+	PDFStatus   string `gorm:"column:pdf_status"   json:"pdfStatus,omitempty"`
+	DocxPath    string `gorm:"column:docx_path"    json:"-"`
+	PDFAttempts int    `gorm:"column:pdf_attempts" json:"pdfAttempts,omitempty"`
+	PDFError    string `gorm:"column:pdf_error"    json:"pdfError,omitempty"`
 
 	// Связи
 	StudentID uint     `gorm:"column:student_id;index" json:"studentId"`
@@ -35,6 +51,17 @@ type Contract struct {
 	ManagerID uint  `gorm:"column:manager_id;index" json:"managerId"`
 	Manager   *User `gorm:"foreignKey:ManagerID"     json:"manager,omitempty"`
 
+	// Учебный год договора: от него считаются даты договора и годы платежей графика
+	AcademicYearID *uint         `gorm:"column:academic_year_id;index" json:"academicYearId,omitempty"`
+	AcademicYear   *AcademicYear `gorm:"foreignKey:AcademicYearID"      json:"academicYear,omitempty"`
+
+	// Номер действующей версии условий (см. ContractVersion, ContractAmendment)
+	Version int `gorm:"column:version;not null;default:1" json:"version"`
+
+	// Расторжение договора при выбытии ученика (см. ContractWithdrawal)
+	TerminatedAt *time.Time          `gorm:"column:terminated_at;type:date" json:"terminatedAt,omitempty"`
+	Withdrawal   *ContractWithdrawal `gorm:"foreignKey:ContractID"          json:"withdrawal,omitempty"`
+
 	// Необязательная связь с формой оплаты (если есть модель)
 	PaymentForm *PaymentForm `gorm:"foreignKey:PaymentFormId" json:"paymentForm,omitempty"`
 }
@@ -0,0 +1,53 @@
+// crm/models/contract_amendment.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ContractChange - изменение одного условия договора в дополнительном соглашении.
+// Значения хранятся в том виде, в каком они печатаются в соглашении.
+type ContractChange struct {
+	Field    string `json:"field"`
+	Label    string `json:"label"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+}
+
+// ContractAmendment - дополнительное соглашение к договору. Создается при каждом изменении
+// условий договора (сумма, скидка, форма оплаты, учебный год, сроки) и переводит договор
+// в следующую версию. Number - порядковый номер из счетчика нумерации соглашений
+// (по умолчанию сквозной в пределах договора), DocumentNumber - печатный номер по схеме нумерации.
+type ContractAmendment struct {
+	gorm.Model
+	ContractID     uint   `gorm:"not null;uniqueIndex:idx_contract_amendments_contract_number" json:"contractId"`
+	Number         int    `gorm:"not null;uniqueIndex:idx_contract_amendments_contract_number" json:"number"`
+	DocumentNumber string `gorm:"size:100" json:"documentNumber"`
+	// Version - версия договора, которая действует после соглашения.
+	Version       int              `gorm:"not null" json:"version"`
+	EffectiveDate time.Time        `gorm:"type:date;not null" json:"effectiveDate"`
+	Reason        string           `json:"reason"`
+	Changes       []ContractChange `gorm:"type:jsonb;serializer:json" json:"changes"`
+	AuthorID      *uint            `json:"authorId,omitempty"`
+	PDFFilePath   string           `gorm:"column:pdf_path" json:"-"`
+}
+
+// ContractVersion - условия договора, действующие с EffectiveDate.
+// Версия 1 - условия, подписанные родителем; следующие версии создаются соглашениями.
+type ContractVersion struct {
+	gorm.Model
+	ContractID    uint       `gorm:"not null;uniqueIndex:idx_contract_versions_contract_version" json:"contractId"`
+	Version       int        `gorm:"not null;uniqueIndex:idx_contract_versions_contract_version" json:"version"`
+	AmendmentID   *uint      `json:"amendmentId,omitempty"`
+	EffectiveDate time.Time  `gorm:"type:date;not null" json:"effectiveDate"`
+	StartDate     *time.Time `gorm:"type:date" json:"startDate,omitempty"`
+	EndDate       *time.Time `gorm:"type:date" json:"endDate,omitempty"`
+	// Условия договора в этой версии
+	TotalAmount        Money   `gorm:"type:numeric(12,2)" json:"totalAmount"`
+	DiscountPercentage float64 `gorm:"type:numeric(5,2)" json:"discountPercentage"`
+	DiscountedAmount   Money   `gorm:"type:numeric(12,2)" json:"discountedAmount"`
+	PaymentFormID      *uint   `json:"paymentFormId,omitempty"`
+	AcademicYearID     *uint   `json:"academicYearId,omitempty"`
+}
@@ -0,0 +1,42 @@
+// prometheus-crm/models/contract_payer.go
+package models
+
+import "gorm.io/gorm"
+
+// Виды плательщиков по договору.
+const (
+	PayerKindParent   = "parent"   // родитель или законный представитель
+	PayerKindEmployer = "employer" // работодатель родителя
+	PayerKindCharity  = "charity"  // благотворительный фонд
+	PayerKindOther    = "other"
+)
+
+// Способы задания доли плательщика.
+const (
+	PayerSharePercent   = "percent"   // процент от суммы договора со скидкой
+	PayerShareFixed     = "fixed"     // фиксированная сумма
+	PayerShareRemainder = "remainder" // все, что не покрыли остальные плательщики
+)
+
+// ContractPayer - плательщик по договору. Если у договора нет плательщиков, платит один
+// законный представитель из карточки ученика (Student.ContractParent*). Когда плательщики заданы,
+// сумма договора со скидкой делится между ними: сначала фиксированные суммы и проценты,
+// остаток - плательщику с долей "remainder" (не больше одного на договор).
+// Платежи, строки графика, проводки оплаты и квитанции могут ссылаться на плательщика (PayerID).
+type ContractPayer struct {
+	gorm.Model
+	ContractID uint   `json:"contractId" gorm:"not null;index"`
+	Kind       string `json:"kind" gorm:"size:20;not null"`
+	Name       string `json:"name" gorm:"not null"`
+	// IIN - ИИН физического лица или БИН организации.
+	IIN     string `json:"iin" gorm:"size:12"`
+	Phone   string `json:"phone"`
+	Email   string `json:"email"`
+	Address string `json:"address"`
+
+	ShareType    string  `json:"shareType" gorm:"size:20;not null"`
+	SharePercent float64 `json:"sharePercent" gorm:"type:numeric(5,2);not null;default:0"`
+	FixedAmount  Money   `json:"fixedAmount" gorm:"type:numeric(12,2);not null;default:0"`
+
+	Comment string `json:"comment"`
+}
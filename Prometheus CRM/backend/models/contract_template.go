@@ -1,8 +1,13 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // ContractTemplate представляет модель шаблона договора в базе данных.
+// FilePath, OriginalFileName и FileSize повторяют действующую версию файла.
 type ContractTemplate struct {
 	gorm.Model
 	Name             string `json:"name"`
@@ -12,4 +17,32 @@ type ContractTemplate struct {
 	FilePath         string `json:"filePath"`
 	OriginalFileName string `json:"originalFileName"`
 	FileSize         int64  `json:"fileSize"`
+
+	// CurrentVersionID - версия файла, по которой формируются новые документы.
+	CurrentVersionID *uint                    `json:"currentVersionId"`
+	CurrentVersion   *ContractTemplateVersion `gorm:"foreignKey:CurrentVersionID" json:"currentVersion,omitempty"`
+}
+
+// ContractTemplateVersion - неизменяемая версия файла шаблона: каждая загрузка файла создает
+// новую версию, а договор хранит версию, по которой он сформирован.
+type ContractTemplateVersion struct {
+	ID               uint         `gorm:"primaryKey" json:"id"`
+	TemplateID       uint         `gorm:"not null;uniqueIndex:idx_template_versions_number" json:"templateId"`
+	Version          int          `gorm:"not null;uniqueIndex:idx_template_versions_number" json:"version"`
+	FilePath         string       `gorm:"not null" json:"filePath"`
+	OriginalFileName string       `json:"originalFileName"`
+	FileSize         int64        `json:"fileSize"`
+	Checksum         string       `gorm:"size:64" json:"checksum"` // SHA-256 файла; при расхождении файл на диске считается подмененным
+	Scan             TemplateScan `gorm:"type:jsonb;serializer:json" json:"scan"`
+	UploadedByID     *uint        `json:"uploadedById"`
+	CreatedAt        time.Time    `json:"createdAt"`
+}
+
+// TemplateScan - плейсхолдеры, найденные в файле шаблона при загрузке, в сравнении
+// с набором, который заполняется для документов классификации шаблона.
+type TemplateScan struct {
+	Found   []string `json:"found"`   // плейсхолдеры шаблона в порядке появления
+	Unknown []string `json:"unknown"` // не заполняются для документов этой классификации
+	Unused  []string `json:"unused"`  // доступны, но в шаблоне не используются
+	Errors  []string `json:"errors"`  // ошибки разметки блоков и фильтров
 }
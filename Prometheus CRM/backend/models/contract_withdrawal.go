@@ -0,0 +1,55 @@
+// crm/models/contract_withdrawal.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Способы пересчета стоимости обучения при выбытии ученика.
+const (
+	WithdrawalByMonth   = "month"   // оплачиваются все начатые месяцы учебного года
+	WithdrawalByQuarter = "quarter" // оплачиваются все начатые четверти
+	WithdrawalByDay     = "day"     // пропорционально дням учебного года до даты выбытия
+
+	// WithdrawalSource - источник проводок журнала расчетов, сделанных при выбытии.
+	WithdrawalSource = "withdrawal"
+)
+
+// ContractWithdrawal - расторжение договора при выбытии ученика в течение учебного года.
+// Стоимость обучения пересчитывается на дату выбытия: невозвратный взнос удерживается полностью,
+// остальная сумма договора - пропорционально оплачиваемым периодам. Разница со стоимостью договора
+// отражается корректировкой в журнале расчетов, будущие платежи графика отменяются.
+// Суммы фиксируются на момент расторжения и используются в соглашении о расторжении.
+type ContractWithdrawal struct {
+	gorm.Model
+	ContractID uint `gorm:"not null;uniqueIndex" json:"contractId"`
+	// DocumentNumber - номер соглашения о расторжении по схеме нумерации.
+	DocumentNumber string    `gorm:"size:100" json:"documentNumber"`
+	WithdrawalDate time.Time `gorm:"type:date;not null" json:"withdrawalDate"`
+	Reason         string    `json:"reason"`
+
+	Method         string `gorm:"size:20;not null" json:"method"`
+	PeriodsTotal   int    `json:"periodsTotal"`
+	PeriodsCharged int    `json:"periodsCharged"`
+
+	// ContractAmount - стоимость договора с учетом скидок до пересчета.
+	ContractAmount Money `gorm:"type:numeric(12,2);not null" json:"contractAmount"`
+	// DepositAmount - невозвратный взнос, удержанный полностью.
+	DepositAmount Money `gorm:"type:numeric(12,2);not null" json:"depositAmount"`
+	// ChargeableAmount - стоимость обучения после пересчета.
+	ChargeableAmount Money `gorm:"type:numeric(12,2);not null" json:"chargeableAmount"`
+	PaidAmount       Money `gorm:"type:numeric(12,2);not null" json:"paidAmount"`
+	// RefundAmount - переплата к возврату, DebtAmount - оставшийся долг (заполнено не больше одного).
+	RefundAmount Money `gorm:"type:numeric(12,2);not null" json:"refundAmount"`
+	DebtAmount   Money `gorm:"type:numeric(12,2);not null" json:"debtAmount"`
+
+	CancelledPayments int   `json:"cancelledPayments"`
+	CancelledAmount   Money `gorm:"type:numeric(12,2)" json:"cancelledAmount"`
+
+	// RefundedAt - дата выплаты возврата (проводка refund в журнале расчетов).
+	RefundedAt   *time.Time `gorm:"type:date" json:"refundedAt,omitempty"`
+	DocumentPath string     `gorm:"column:document_path" json:"-"`
+	CreatedByID  *uint      `json:"createdById,omitempty"`
+}
@@ -0,0 +1,73 @@
+// crm/models/discount.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Виды правил скидок.
+const (
+	DiscountKindFamilyOrder  = "family_order"  // по порядку ребенка в семье
+	DiscountKindEarlyPayment = "early_payment" // полная оплата до срока
+	DiscountKindStaffChild   = "staff_child"   // ребенок сотрудника
+	DiscountKindScholarship  = "scholarship"   // стипендия / грант
+	DiscountKindManual       = "manual"        // ручная скидка по решению администрации
+)
+
+// DiscountRule - правило расчета скидки.
+// Правила применяются по возрастанию Priority. Нестыкуемые правила (Stackable = false)
+// взаимоисключающие: из них применяется только первое сработавшее. Стыкуемые правила
+// суммируются со всеми остальными.
+// Параметры вида хранятся в Params:
+//   - family_order:  {"tiers": {"1": 5, "2": 10}} - процент по порядку ребенка (0 - первый),
+//     последняя ступень действует и для всех следующих детей;
+//   - early_payment: {"deadline": "09-01"} - договор оплачен полностью до этой даты (ММ-ДД).
+//
+// Виды staff_child, scholarship и manual срабатывают только при наличии StudentDiscount.
+type DiscountRule struct {
+	gorm.Model
+	Code        string     `gorm:"uniqueIndex;not null" json:"code"`
+	Name        string     `gorm:"not null" json:"name"`
+	Kind        string     `gorm:"not null;index" json:"kind"`
+	Percent     float64    `gorm:"type:numeric(5,2)" json:"percent"`
+	FixedAmount Money      `gorm:"type:numeric(12,2)" json:"fixedAmount"`
+	MaxAmount   Money      `gorm:"type:numeric(12,2)" json:"maxAmount"` // 0 - без ограничения
+	Params      JSONB      `gorm:"type:jsonb" json:"params"`
+	Priority    int        `gorm:"not null;default:100" json:"priority"`
+	Stackable   bool       `json:"stackable"`
+	ValidFrom   *time.Time `gorm:"type:date" json:"validFrom"`
+	ValidTo     *time.Time `gorm:"type:date" json:"validTo"`
+	IsActive    *bool      `gorm:"default:true" json:"isActive"`
+}
+
+// StudentDiscount - предоставление скидки конкретному ученику (ребенок сотрудника, стипендия, ручная).
+// ContractID ограничивает действие одним договором; Percent и FixedAmount переопределяют значения правила.
+type StudentDiscount struct {
+	gorm.Model
+	StudentID   uint          `gorm:"not null;index" json:"studentId"`
+	RuleID      uint          `gorm:"not null;index" json:"ruleId"`
+	Rule        *DiscountRule `json:"rule,omitempty"`
+	ContractID  *uint         `gorm:"index" json:"contractId"`
+	Percent     *float64      `gorm:"type:numeric(5,2)" json:"percent"`
+	FixedAmount *Money        `gorm:"type:numeric(12,2)" json:"fixedAmount"`
+	ValidFrom   *time.Time    `gorm:"type:date" json:"validFrom"`
+	ValidTo     *time.Time    `gorm:"type:date" json:"validTo"`
+	Comment     string        `json:"comment"`
+	GrantedByID *uint         `json:"grantedById"`
+}
+
+// ContractDiscountLine - примененная к договору скидка с пояснением.
+// Строки пересоздаются при каждом пересчете; сумма строк равна TotalAmount - DiscountedAmount.
+type ContractDiscountLine struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ContractID  uint      `gorm:"not null;index" json:"contractId"`
+	RuleID      *uint     `json:"ruleId"`
+	Kind        string    `gorm:"not null" json:"kind"`
+	Name        string    `json:"name"`
+	Percent     float64   `gorm:"type:numeric(5,2)" json:"percent"`
+	Amount      Money     `gorm:"type:numeric(12,2);not null" json:"amount"`
+	Explanation string    `json:"explanation"`
+}
@@ -0,0 +1,32 @@
+// crm/models/document_sequence.go
+package models
+
+import "time"
+
+// Виды документов со своей нумерацией.
+const (
+	DocumentContract    = "contract"    // договор
+	DocumentAmendment   = "amendment"   // дополнительное соглашение
+	DocumentReceipt     = "receipt"     // квитанция об оплате
+	DocumentTermination = "termination" // соглашение о расторжении
+)
+
+// Области нумерации: в пределах области номера идут подряд начиная с 1.
+const (
+	NumberingScopeGlobal       = "global"        // единая нумерация без сброса
+	NumberingScopeAcademicYear = "academic_year" // заново в каждом учебном году
+	NumberingScopeCalendarYear = "calendar_year" // заново в каждом календарном году
+	NumberingScopeStudent      = "student"       // отдельно для каждого ученика
+	NumberingScopeContract     = "contract"      // отдельно для каждого договора
+)
+
+// DocumentSequence - последний выданный номер документа вида DocumentType в области ScopeKey
+// ("" - единая нумерация, "academic_year:3", "year:2025", "student:12", "contract:7").
+// Строка счетчика блокируется до конца транзакции, в которой создается документ, поэтому
+// при откате номер не расходуется, а параллельные документы получают номера по очереди.
+type DocumentSequence struct {
+	DocumentType string    `gorm:"primaryKey;size:30" json:"documentType"`
+	ScopeKey     string    `gorm:"primaryKey;size:50" json:"scopeKey"`
+	LastNumber   int       `gorm:"not null" json:"lastNumber"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
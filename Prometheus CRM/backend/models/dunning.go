@@ -0,0 +1,63 @@
+// crm/models/dunning.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Каналы доставки напоминаний.
+const (
+	ReminderChannelEmail = "email"
+	ReminderChannelSMS   = "sms"
+)
+
+// Статусы напоминания.
+const (
+	ReminderStatusSent       = "sent"
+	ReminderStatusFailed     = "failed"
+	ReminderStatusSuppressed = "suppressed" // не отправлено: есть обещание оплаты
+	ReminderStatusNoContact  = "no_contact" // у родителя не указан email/телефон
+)
+
+// DunningStep - шаг сценария напоминаний об оплате.
+// OffsetDays отсчитывается от даты платежа по графику: -3 - за три дня до срока,
+// 0 - в день оплаты, 7 - на седьмой день просрочки.
+// Шаблоны темы и текста используют синтаксис text/template (см. DunningMessageData).
+type DunningStep struct {
+	gorm.Model
+	Name            string `gorm:"not null" json:"name"`
+	OffsetDays      int    `gorm:"not null" json:"offsetDays"`
+	Channels        string `gorm:"not null;default:'email'" json:"channels"` // через запятую: email,sms
+	SubjectTemplate string `json:"subjectTemplate"`
+	BodyTemplate    string `gorm:"type:text;not null" json:"bodyTemplate"`
+	IsActive        *bool  `gorm:"default:true" json:"isActive"`
+}
+
+// DunningReminder - журнал каждого напоминания по строке графика.
+// Отправленное или подавленное напоминание по шагу и каналу повторно не создается.
+type DunningReminder struct {
+	gorm.Model
+	ContractID       uint       `gorm:"not null;index" json:"contractId"`
+	PlannedPaymentID uint       `gorm:"not null;index" json:"plannedPaymentId"`
+	StepID           uint       `gorm:"not null;index" json:"stepId"`
+	Channel          string     `gorm:"not null" json:"channel"`
+	Recipient        string     `json:"recipient"`
+	Subject          string     `json:"subject"`
+	Body             string     `gorm:"type:text" json:"body"`
+	Status           string     `gorm:"not null;index" json:"status"`
+	Error            string     `json:"error"`
+	SentAt           *time.Time `json:"sentAt"`
+}
+
+// PaymentPromise - обещание родителя оплатить до указанной даты.
+// Пока срок обещания не истек, напоминания по договору не отправляются.
+type PaymentPromise struct {
+	gorm.Model
+	ContractID   uint      `gorm:"not null;index" json:"contractId"`
+	PromisedDate time.Time `gorm:"type:date;not null" json:"promisedDate"`
+	Amount       Money     `gorm:"type:numeric(12,2)" json:"amount"`
+	Comment      string    `json:"comment"`
+	CreatedByID  *uint     `json:"createdById"`
+}
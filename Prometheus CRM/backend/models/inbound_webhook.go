@@ -0,0 +1,34 @@
+// crm/models/inbound_webhook.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы обработки входящего вебхука.
+const (
+	WebhookStatusReceived  = "received"
+	WebhookStatusProcessed = "processed"
+	WebhookStatusDuplicate = "duplicate"
+	WebhookStatusFailed    = "failed"
+	WebhookStatusRejected  = "rejected"
+)
+
+// InboundWebhook - журнал каждой доставки входящего вебхука от внешней системы.
+// Хранит исходное тело запроса и результат обработки, чтобы финансы могли
+// проверить поступления и повторно обработать неудачные доставки.
+type InboundWebhook struct {
+	gorm.Model
+	ServiceName  string     `gorm:"not null;index" json:"serviceName"`
+	ExternalID   string     `gorm:"index" json:"externalId"`
+	Signature    string     `json:"signature"`
+	Payload      string     `gorm:"type:text" json:"payload"`
+	Status       string     `gorm:"not null;index" json:"status"`
+	ResponseCode int        `json:"responseCode"`
+	Response     JSONB      `gorm:"type:jsonb" json:"response"`
+	Error        string     `json:"error"`
+	ReplayOfID   *uint      `json:"replayOfId,omitempty"`
+	ProcessedAt  *time.Time `json:"processedAt"`
+}
@@ -39,7 +39,7 @@ type Invoice struct {
 	Bin              string               `json:"bin"`
 	InvoiceNumber    string               `json:"invoiceNumber"`
 	InvoiceDate      *time.Time           `json:"invoiceDate"`
-	TotalAmount      float64              `json:"totalAmount"`
+	TotalAmount      Money                `json:"totalAmount"`
 	PaymentPurpose   string               `json:"paymentPurpose"`
 	Status           string               `json:"status" gorm:"default:'Pending'"`
 	RejectionReason  string               `json:"rejectionReason"`
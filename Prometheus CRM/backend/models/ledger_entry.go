@@ -0,0 +1,82 @@
+// prometheus-crm/models/ledger_entry.go
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Типы проводок в журнале расчетов по договору.
+const (
+	LedgerCharge     = "charge"     // начисление стоимости обучения
+	LedgerPayment    = "payment"    // поступление денег от плательщика
+	LedgerDiscount   = "discount"   // предоставленная скидка
+	LedgerRefund     = "refund"     // возврат денег плательщику
+	LedgerAdjustment = "adjustment" // ручная или автоматическая корректировка
+	LedgerReversal   = "reversal"   // сторно ранее сделанной проводки
+
+	// LedgerSourceContract помечает проводки, отражающие цену договора (начисление и скидки).
+	LedgerSourceContract = "contract"
+	// LedgerSourcePaymentFact - поступление, внесенное вручную, из 1С, банковской выписки или онлайн-оплаты.
+	LedgerSourcePaymentFact = "payment_fact"
+	// LedgerSourceContractPayment - оплата по договору с указанием формы оплаты.
+	LedgerSourceContractPayment = "contract_payment"
+	// LedgerSourcePlannedPayment - оплата, отмеченная в графике без подтверждающего платежа
+	// и перенесенная в журнал при его создании (SourceID - строка графика).
+	LedgerSourcePlannedPayment = "planned_payment"
+)
+
+// ErrLedgerImmutable возвращается при попытке изменить или удалить проводку.
+var ErrLedgerImmutable = errors.New("проводки журнала расчетов неизменяемы, используйте сторно")
+
+// LedgerEntry - одна неизменяемая проводка в журнале расчетов по договору.
+// Amount хранится со знаком: положительная сумма увеличивает долг плательщика
+// (начисление, возврат), отрицательная - уменьшает его (оплата, скидка).
+// Баланс договора равен сумме Amount всех его проводок.
+//
+// Проводка-оплата сама является документом о поступлении: распределение по графику
+// ссылается на нее, прежние таблицы payment_facts и contract_payments сохранены только для истории.
+type LedgerEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	ContractID uint      `gorm:"not null;index" json:"contractId"`
+	EntryType  string    `gorm:"size:20;not null" json:"entryType"`
+	Amount     Money     `gorm:"type:numeric(12,2);not null" json:"amount"`
+	EntryDate  time.Time `gorm:"type:date;not null" json:"entryDate"`
+
+	Description string `json:"description"`
+
+	// SourceType/SourceID - документ, породивший проводку (contract и т.д.). У оплат SourceType -
+	// канал поступления (payment_fact, contract_payment, planned_payment), SourceID заполнен только
+	// у перенесенных из прежних таблиц и графика платежей.
+	SourceType string `gorm:"size:50" json:"sourceType"`
+	SourceID   *uint  `json:"sourceId,omitempty"`
+
+	// Реквизиты оплаты (заполняются только у проводок типа payment).
+	Commission    Money  `gorm:"type:numeric(12,2);not null;default:0" json:"commission"`
+	PaymentMethod string `json:"paymentMethod"`
+	PaymentFormID *uint  `json:"paymentFormId,omitempty"`
+	AcademicYear  string `json:"academicYear"`
+	// ExternalID - ID транзакции во внешней системе (1С, выписка, платежный шлюз); уникален и защищает от повторного
+	// зачисления. Остается на первой проводке поступления, в том числе сторнированной.
+	ExternalID *string `gorm:"uniqueIndex" json:"externalId,omitempty"`
+
+	// PayerID - плательщик поступления; у сторно совпадает с отменяемой проводкой.
+	PayerID *uint `gorm:"index" json:"payerId,omitempty"`
+
+	// ReversesID заполняется у сторно и указывает на отменяемую проводку.
+	ReversesID *uint `gorm:"index" json:"reversesId,omitempty"`
+
+	CreatedByID *uint `json:"createdById,omitempty"`
+}
+
+func (LedgerEntry) TableName() string { return "ledger_entries" }
+
+// BeforeUpdate запрещает изменение проводок.
+func (LedgerEntry) BeforeUpdate(*gorm.DB) error { return ErrLedgerImmutable }
+
+// BeforeDelete запрещает удаление проводок.
+func (LedgerEntry) BeforeDelete(*gorm.DB) error { return ErrLedgerImmutable }
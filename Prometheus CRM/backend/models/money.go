@@ -0,0 +1,281 @@
+// FILE: models/money.go
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money - денежная сумма в тиынах (1/100 тенге). Все финансовые поля моделей используют этот тип,
+// чтобы арифметика была точной: суммы складываются и вычитаются как целые числа,
+// а округление происходит только при умножении на дробный коэффициент.
+//
+// В БД сумма хранится в колонке NUMERIC(…,2), в JSON выводится числом с двумя знаками
+// после запятой ("totalAmount": 1250000.50), поэтому формат API не меняется.
+type Money int64
+
+// MoneyScale - количество тиынов в тенге.
+const MoneyScale = 100
+
+// Tenge возвращает сумму в целых тенге.
+func Tenge(v int64) Money {
+	return Money(v * MoneyScale)
+}
+
+// MoneyFromFloat переводит сумму в тенге в Money с округлением до тиына (половина - от нуля).
+// Используется на границе с кодом, который считает в float64: формулы, проценты, Excel.
+func MoneyFromFloat(v float64) Money {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return Money(math.Round(v * MoneyScale))
+}
+
+// ParseMoney разбирает сумму из строки без потери точности: "1250000", "1 250 000,5", "-12.34".
+func ParseMoney(s string) (Money, error) {
+	s = strings.NewReplacer(" ", "", " ", "", " ", "").Replace(strings.TrimSpace(s))
+	s = strings.Replace(s, ",", ".", 1)
+	if s == "" {
+		return 0, errors.New("пустая сумма")
+	}
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > 2 {
+		// Лишние разряды округляем по третьему знаку, остальные должны быть цифрами.
+		for _, r := range frac[2:] {
+			if r < '0' || r > '9' {
+				return 0, fmt.Errorf("некорректная сумма «%s»", s)
+			}
+		}
+		roundUp := frac[2] >= '5'
+		frac = frac[:2]
+		m, err := ParseMoney(whole + "." + frac)
+		if err != nil {
+			return 0, err
+		}
+		if roundUp {
+			m++
+		}
+		if negative {
+			m = -m
+		}
+		return m, nil
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	tenge, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("некорректная сумма «%s»", s)
+	}
+	tiyn, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("некорректная сумма «%s»", s)
+	}
+	m := Money(tenge)*MoneyScale + Money(tiyn)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// Float64 возвращает сумму в тенге. Только для вывода и для умножения на коэффициенты.
+func (m Money) Float64() float64 {
+	return float64(m) / MoneyScale
+}
+
+// Tenge возвращает целую часть суммы в тенге.
+func (m Money) Tenge() int64 {
+	return int64(m) / MoneyScale
+}
+
+// Tiyn возвращает дробную часть суммы в тиынах (0-99, со знаком суммы).
+func (m Money) Tiyn() int64 {
+	return int64(m) % MoneyScale
+}
+
+// Abs возвращает модуль суммы.
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Mul умножает сумму на коэффициент с округлением до тиына.
+func (m Money) Mul(k float64) Money {
+	return MoneyFromFloat(m.Float64() * k)
+}
+
+// Percent возвращает percent процентов от суммы с округлением до тиына.
+func (m Money) Percent(percent float64) Money {
+	return Money(math.Round(float64(m) * percent / 100))
+}
+
+// MulDiv возвращает m * num / den с округлением до тиына (половина - от нуля).
+func (m Money) MulDiv(num, den int64) Money {
+	if den == 0 {
+		return 0
+	}
+	return Money(math.Round(float64(m) * float64(num) / float64(den)))
+}
+
+// Allocate делит сумму на части пропорционально весам так, что сумма частей равна m до тиына.
+// Тиыны, оставшиеся после округления вниз, отдаются частям с наибольшим дробным остатком
+// (метод наибольшего остатка). Отрицательные веса считаются нулевыми; если все веса нулевые,
+// сумма делится поровну.
+func (m Money) Allocate(weights []float64) []Money {
+	parts := make([]Money, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	var base float64
+	for _, w := range weights {
+		if w > 0 {
+			base += w
+		}
+	}
+	sign := Money(1)
+	total := m
+	if total < 0 {
+		sign, total = -1, -total
+	}
+
+	remainders := make([]float64, len(weights))
+	var assigned Money
+	for i, w := range weights {
+		share := float64(total) / float64(len(weights))
+		if base > 0 {
+			share = float64(total) * math.Max(w, 0) / base
+		}
+		parts[i] = Money(math.Floor(share))
+		remainders[i] = share - float64(parts[i])
+		assigned += parts[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; assigned < total; i++ {
+		parts[order[i%len(order)]]++
+		assigned++
+	}
+	for i := range parts {
+		parts[i] *= sign
+	}
+	return parts
+}
+
+// Split делит сумму на n равных частей; лишние тиыны получают первые части.
+func (m Money) Split(n int) []Money {
+	if n <= 0 {
+		return nil
+	}
+	return m.Allocate(make([]float64, n))
+}
+
+// MinMoney возвращает меньшую из сумм.
+func MinMoney(a, b Money) Money {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// MaxMoney возвращает большую из сумм.
+func MaxMoney(a, b Money) Money {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// String возвращает сумму с точкой и двумя знаками после нее: "-1250000.05".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/MoneyScale, v%MoneyScale)
+}
+
+// MarshalJSON выводит сумму числом с двумя знаками после запятой.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON принимает сумму числом или строкой; null оставляет ноль.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*m = 0
+		return nil
+	}
+	if strings.ContainsAny(s, "eE") {
+		// Экспоненциальная запись от JS (1e21 и т.п.) - разбираем через float.
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("некорректная сумма «%s»", s)
+		}
+		*m = MoneyFromFloat(f)
+		return nil
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan читает сумму из колонки NUMERIC (строкой), а также из целых и дробных колонок.
+// Целое значение (int64) считается суммой в целых тенге, а не в тиынах: так приходят
+// BIGINT/INTEGER-колонки и выражения вроде COUNT или SUM по целым. Колонку, где уже
+// хранятся тиыны, нужно читать в int64 и переводить явно (Money(v)).
+func (m *Money) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+	case []byte:
+		parsed, err := ParseMoney(string(v))
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case int64:
+		*m = Tenge(v)
+	case float64:
+		*m = MoneyFromFloat(v)
+	default:
+		return fmt.Errorf("неподдерживаемый тип суммы %T", value)
+	}
+	return nil
+}
+
+// Value записывает сумму в NUMERIC строкой, без промежуточного float.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
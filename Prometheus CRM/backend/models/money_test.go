@@ -0,0 +1,150 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{"1250000", Tenge(1250000), false},
+		{"1 250 000,5", 125000050, false},
+		{"1 250 000,50", 125000050, false},
+		{"-12.34", -1234, false},
+		{"+7", Tenge(7), false},
+		{".5", 50, false},
+		{"0.1", 10, false},
+		{"12.345", 1235, false},
+		{"12.344", 1234, false},
+		{"12.999", 1300, false},
+		{"-0.005", -1, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"1.2.3", 0, true},
+		{"12.34x", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   Money
+		weights []float64
+		want    []Money
+	}{
+		{"поровну без остатка", Tenge(300), []float64{1, 1, 1}, []Money{Tenge(100), Tenge(100), Tenge(100)}},
+		{"тиын уходит к наибольшему остатку", 100, []float64{1, 1, 1}, []Money{34, 33, 33}},
+		{"пропорционально весам", Tenge(1000), []float64{1, 3}, []Money{Tenge(250), Tenge(750)}},
+		{"наибольший остаток не у первой части", 1000, []float64{1, 2, 4}, []Money{143, 286, 571}},
+		{"отрицательный вес считается нулевым", Tenge(100), []float64{-5, 1}, []Money{0, Tenge(100)}},
+		{"все веса нулевые - поровну", 10, []float64{0, 0, 0}, []Money{4, 3, 3}},
+		{"отрицательная сумма", -100, []float64{1, 1, 1}, []Money{-34, -33, -33}},
+		{"нет частей", Tenge(100), nil, []Money{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.total.Allocate(tt.weights)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Allocate(%v) = %v, want %v", tt.weights, got, tt.want)
+			}
+			var sum Money
+			for _, p := range got {
+				sum += p
+			}
+			if len(got) > 0 && sum != tt.total {
+				t.Fatalf("сумма частей %v, want %v", sum, tt.total)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{125000050, "1250000.50"},
+		{Tenge(-12), "-12.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{`1250000.5`, 125000050, false},
+		{`"1250000.50"`, 125000050, false},
+		{`null`, 0, false},
+		{`1e3`, Tenge(1000), false},
+		{`"x"`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("Unmarshal(%s) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+	out, err := json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{Amount: 125000050})
+	if err != nil || string(out) != `{"amount":1250000.50}` {
+		t.Fatalf("Marshal = %s, %v", out, err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want Money
+	}{
+		{"NUMERIC строкой", []byte("1250000.50"), 125000050},
+		{"строка", "-12.34", -1234},
+		{"целое - в тенге", int64(300000), Tenge(300000)},
+		{"дробное", 0.1 + 0.2, 30},
+		{"NULL", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Money(99)
+			if err := m.Scan(tt.in); err != nil {
+				t.Fatalf("Scan(%v): %v", tt.in, err)
+			}
+			if m != tt.want {
+				t.Fatalf("Scan(%v) = %d, want %d", tt.in, m, tt.want)
+			}
+		})
+	}
+}
@@ -18,6 +18,8 @@ type NewsPost struct {
 	Content      string `json:"content" gorm:"type:text"`
 	Type         string `json:"type" gorm:"type:varchar(50);default:'message'"`
 	PollQuestion string `json:"poll_question,omitempty"`
+	// Audience - кому адресован пост: staff или parents (см. NewsAudience*)
+	Audience string `json:"audience" gorm:"type:varchar(20);default:'staff'"`
 
 	// ИЗМЕНЕНИЕ: Заменяем одно поле для файла на срез (много файлов)
 	Files       []NewsPostFile `json:"files,omitempty" gorm:"foreignKey:NewsPostID;constraint:OnDelete:CASCADE;"`
@@ -32,8 +34,10 @@ type PollOption struct {
 	Votes      []PollVote `json:"votes" gorm:"foreignKey:PollOptionID;constraint:OnDelete:CASCADE;"`
 }
 
+// PollVote - голос сотрудника (UserID) или родителя (ParentAccountID); заполнено ровно одно поле.
 type PollVote struct {
 	gorm.Model
-	PollOptionID uint `json:"poll_option_id"`
-	UserID       uint `json:"user_id"`
+	PollOptionID    uint  `json:"poll_option_id"`
+	UserID          *uint `json:"user_id"`
+	ParentAccountID *uint `json:"parent_account_id,omitempty"`
 }
@@ -0,0 +1,39 @@
+// crm/models/parent_account.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы учетной записи родителя.
+const (
+	ParentAccountActive  = "active"
+	ParentAccountBlocked = "blocked"
+)
+
+// Аудитория поста новостной ленты.
+const (
+	NewsAudienceStaff   = "staff"   // только сотрудники (по умолчанию)
+	NewsAudienceParents = "parents" // родители в личном кабинете и сотрудники
+)
+
+// ParentAccount - учетная запись родителя (законного представителя) для личного кабинета.
+// Это отдельный от User тип пользователя: он не имеет ролей и прав и не может обращаться
+// к API сотрудников. Входит по ИИН или номеру телефона; доступ ограничен детьми из Students.
+type ParentAccount struct {
+	gorm.Model
+	FullName string `json:"fullName"`
+	// IIN и Phone - логины для входа; Phone хранится нормализованным (только цифры, 7XXXXXXXXXX).
+	IIN         string     `gorm:"size:12;index" json:"iin"`
+	Phone       string     `gorm:"size:20;index" json:"phone"`
+	Email       string     `json:"email"`
+	Password    string     `json:"-"`
+	Status      string     `gorm:"size:20;not null;default:'active'" json:"status"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+	CreatedByID *uint      `json:"createdById,omitempty"`
+
+	// Students - дети, к данным которых у родителя есть доступ.
+	Students []Student `gorm:"many2many:parent_account_students;" json:"students,omitempty"`
+}
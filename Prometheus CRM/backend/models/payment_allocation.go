@@ -0,0 +1,30 @@
+// prometheus-crm/models/payment_allocation.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AllocationSourceLedgerEntry - поступление, распределяемое по графику: проводка-оплата журнала расчетов.
+const AllocationSourceLedgerEntry = "ledger_entry"
+
+// PaymentAllocation фиксирует, какая часть поступления закрыла какую строку графика.
+// Одно поступление может быть разбито на несколько строк графика, и наоборот —
+// одна строка графика может закрываться несколькими поступлениями.
+type PaymentAllocation struct {
+	gorm.Model
+
+	ContractID uint `json:"contractId" gorm:"not null;index"`
+
+	PlannedPaymentID uint           `json:"plannedPaymentId" gorm:"not null;index"`
+	PlannedPayment   PlannedPayment `json:"-"`
+
+	// SourceType/SourceID указывают на проводку-оплату в журнале расчетов (ledger_entry).
+	SourceType string `json:"sourceType" gorm:"size:50;not null"`
+	SourceID   uint   `json:"sourceId" gorm:"not null"`
+
+	Amount      Money     `json:"amount" gorm:"type:numeric(12,2);not null"`
+	AllocatedAt time.Time `json:"allocatedAt"`
+}
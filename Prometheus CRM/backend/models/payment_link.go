@@ -0,0 +1,52 @@
+// crm/models/payment_link.go
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы ссылки на онлайн-оплату.
+const (
+	PaymentLinkPending   = "pending"   // ссылка выдана, оплата не поступила
+	PaymentLinkPaid      = "paid"      // шлюз подтвердил оплату, проведена в журнале
+	PaymentLinkCancelled = "cancelled" // отменена сотрудником
+	PaymentLinkExpired   = "expired"   // истек срок действия
+)
+
+// PaymentLink - ссылка на оплату картой через платежный шлюз (эквайринг).
+// Выдается на строку графика или на произвольную сумму по договору. Token - номер заказа
+// в шлюзе и часть публичной ссылки /pay/<token>, которую получает родитель.
+// После подтвержденного callback от шлюза в журнал расчетов проводится оплата с ExternalID "<gateway>:<ExternalID>".
+type PaymentLink struct {
+	gorm.Model
+	Token   string `gorm:"size:64;uniqueIndex;not null" json:"token"`
+	Gateway string `gorm:"size:30;not null" json:"gateway"`
+
+	ContractID       uint            `gorm:"not null;index" json:"contractId"`
+	Contract         *Contract       `gorm:"foreignKey:ContractID" json:"contract,omitempty"`
+	PlannedPaymentID *uint           `gorm:"index" json:"plannedPaymentId,omitempty"`
+	PlannedPayment   *PlannedPayment `gorm:"foreignKey:PlannedPaymentID" json:"-"`
+
+	Amount      Money  `gorm:"type:numeric(12,2);not null" json:"amount"`
+	Description string `json:"description"`
+	Status      string `gorm:"size:20;not null;index" json:"status"`
+
+	// PaymentURL - страница оплаты шлюза, ExternalID - идентификатор счета или транзакции в шлюзе.
+	PaymentURL string `json:"paymentUrl"`
+	ExternalID string `gorm:"index" json:"externalId"`
+	// LastError - причина последнего отказа шлюза (ссылка остается действующей для повторной попытки).
+	LastError string `json:"lastError"`
+
+	ExpiresAt      time.Time  `gorm:"not null" json:"expiresAt"`
+	PaidAt         *time.Time `json:"paidAt,omitempty"`
+	PaymentEntryID *uint      `json:"paymentEntryId,omitempty"`
+	CreatedByID    *uint      `json:"createdById,omitempty"`
+}
+
+// OrderNumber - номер заказа, под которым ссылка зарегистрирована в шлюзе (банки принимают только цифры).
+func (l PaymentLink) OrderNumber() string {
+	return fmt.Sprintf("%010d", l.ID)
+}
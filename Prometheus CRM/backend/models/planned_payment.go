@@ -30,11 +30,15 @@ type PlannedPayment struct {
 	// PlannedAmount - сумма, которая должна быть оплачена по плану.
 	// gorm:"type:numeric(12,2)" указывает GORM, что в базе данных
 	// это поле соответствует типу NUMERIC с 2 знаками после запятой для точности финансовых расчетов.
-	PlannedAmount float64 `json:"plannedAmount" gorm:"type:numeric(12,2)"`
+	PlannedAmount Money `json:"plannedAmount" gorm:"type:numeric(12,2)"`
 
-	// PaidAmount - фактическая сумма, которая была внесена по этому платежу.
-	// По умолчанию равна 0 и обновляется после получения данных об оплате (например, из 1С).
-	PaidAmount float64 `json:"paidAmount" gorm:"type:numeric(12,2)"`
+	// PaidAmount - сумма, распределенная на эту строку графика из поступлений (см. PaymentAllocation).
+	// Это проекция для отображения статуса: она пересчитывается из распределений проводок-оплат
+	// и не изменяется напрямую; деньги по договору учитываются в журнале расчетов (LedgerEntry).
+	PaidAmount Money `json:"paidAmount" gorm:"type:numeric(12,2)"`
+
+	// PayerID - плательщик, которому выставлена строка графика (nil - общая строка договора, см. ContractPayer).
+	PayerID *uint `json:"payerId,omitempty" gorm:"index"`
 
 	// PaymentName - текстовое наименование платежа, например, "1 транш".
 	PaymentName string `json:"paymentName"`
@@ -0,0 +1,50 @@
+// crm/models/receipt.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы квитанции.
+const (
+	ReceiptStatusIssued   = "issued"
+	ReceiptStatusAnnulled = "annulled" // платеж исправлен или удален; номер остается занятым
+)
+
+// Receipt - квитанция об оплате, выдаваемая на каждый зарегистрированный платеж.
+// Номер формируется по схеме нумерации квитанций и не имеет пропусков:
+// он выделяется в той же транзакции, что и платеж (см. DocumentSequence).
+// Аннулированные квитанции не удаляются, чтобы нумерация оставалась непрерывной.
+type Receipt struct {
+	gorm.Model
+	Number string `gorm:"size:100;uniqueIndex;not null" json:"number"` // "2025-00001"
+	// Year - год нумерации (календарный или год начала учебного), Sequence - порядковый номер в области нумерации.
+	Year     int `gorm:"not null;index:idx_receipts_year_sequence" json:"year"`
+	Sequence int `gorm:"not null;index:idx_receipts_year_sequence" json:"sequence"`
+
+	ContractID uint      `gorm:"not null;index" json:"contractId"`
+	Contract   *Contract `gorm:"foreignKey:ContractID" json:"contract,omitempty"`
+	// SourceType/SourceID - платеж, по которому выдана квитанция (проводка-оплата журнала, ledger_entry).
+	SourceType string `gorm:"size:50;not null;index:idx_receipts_source" json:"sourceType"`
+	SourceID   uint   `gorm:"not null;index:idx_receipts_source" json:"sourceId"`
+
+	// PayerID - плательщик, на имя которого выдана квитанция (nil - законный представитель ученика).
+	PayerID *uint `gorm:"index" json:"payerId,omitempty"`
+
+	Amount        Money     `gorm:"type:numeric(12,2);not null" json:"amount"`
+	PaymentDate   time.Time `gorm:"type:date;not null" json:"paymentDate"`
+	PaymentName   string    `json:"paymentName"`
+	PaymentMethod string    `json:"paymentMethod"`
+	// RemainingBalance - остаток долга по договору (или по доле плательщика) сразу после платежа.
+	RemainingBalance Money `gorm:"type:numeric(12,2);not null" json:"remainingBalance"`
+
+	Status      string `gorm:"size:20;not null;default:'issued';index" json:"status"`
+	PDFFilePath string `gorm:"column:pdf_path" json:"-"`
+	// ReplacesID - аннулированная квитанция, вместо которой выдана эта.
+	ReplacesID  *uint      `gorm:"index" json:"replacesId,omitempty"`
+	AnnulledAt  *time.Time `json:"annulledAt,omitempty"`
+	AnnulReason string     `json:"annulReason,omitempty"`
+	IssuedByID  *uint      `json:"issuedById,omitempty"`
+}
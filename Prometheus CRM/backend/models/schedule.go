@@ -8,8 +8,8 @@ import "gorm.io/gorm"
 type Schedule struct {
 	gorm.Model
 	ClassID      uint   `json:"class_id"`      // Связь с моделью Class
-	AcademicYear string `json:"academic_year"` // Например, "2025-2026"
-	Quarter      int    `json:"quarter"`       // I, II, III, IV
+	AcademicYear string `json:"academic_year"` // Название учебного года из справочника AcademicYear, например "2025-2026"
+	Quarter      int    `json:"quarter"`       // Номер четверти учебного года (AcademicQuarter.Number)
 	// Данные расписания можно хранить в формате JSON для гибкости.
 	// Это позволит легко добавлять/изменять уроки.
 	ScheduleData string `gorm:"type:json" json:"schedule_data"`
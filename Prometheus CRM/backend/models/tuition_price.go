@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TuitionPrice - строка прайс-листа: годовая стоимость обучения на учебный год для параллели,
+// языка обучения и формы обучения класса (Class.Language, Class.StudyType).
+// Пустые Language или StudyType означают "любой"; при подборе цены точное совпадение
+// важнее общего правила (см. findTuitionPrice).
+type TuitionPrice struct {
+	gorm.Model
+	AcademicYearID uint          `json:"academicYearId" gorm:"not null;index"`
+	AcademicYear   *AcademicYear `json:"academicYear,omitempty" gorm:"foreignKey:AcademicYearID"`
+	Grade          int           `json:"grade" gorm:"not null"` // параллель 0-11
+	Language       string        `json:"language" gorm:"size:50;not null;default:''"`
+	StudyType      string        `json:"studyType" gorm:"size:50;not null;default:''"`
+	Amount         Money         `json:"amount" gorm:"type:numeric(12,2);not null"`
+	Comment        string        `json:"comment"`
+}
+
+// TuitionGrandfatherRule - сохранение цены для учеников, поступивших раньше.
+// На договоры учебного года AcademicYearID ученики, поступившие до AdmittedBefore, платят
+// по прайс-листу года PriceYearID, увеличенному на IndexPercent (0 - цена заморожена).
+// Если к ученику подходит несколько правил, действует правило с самой ранней AdmittedBefore.
+type TuitionGrandfatherRule struct {
+	gorm.Model
+	AcademicYearID uint          `json:"academicYearId" gorm:"not null;index"`
+	AcademicYear   *AcademicYear `json:"academicYear,omitempty" gorm:"foreignKey:AcademicYearID"`
+	AdmittedBefore time.Time     `json:"admittedBefore" gorm:"type:date;not null"`
+	PriceYearID    uint          `json:"priceYearId" gorm:"not null"`
+	PriceYear      *AcademicYear `json:"priceYear,omitempty" gorm:"foreignKey:PriceYearID"`
+	IndexPercent   float64       `json:"indexPercent" gorm:"type:numeric(6,2);not null;default:0"`
+	Comment        string        `json:"comment"`
+}
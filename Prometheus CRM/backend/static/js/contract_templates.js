@@ -1,7 +1,10 @@
-import { fetchAuthenticated, openModal, closeModal, showAlert, showConfirm, initializeActionDropdowns, hasPermission } from './utils.js';
+import { fetchAuthenticated, getToken, openModal, closeModal, showAlert, showConfirm, initializeActionDropdowns, hasPermission } from './utils.js';
 
 let modal, form, tableBody, addBtn, statusSwitch, statusLabel, downloadLinkContainer, downloadLink;
 let currentEditingId = null;
+let versionsModal, versionsTableBody, previewModal, previewFrame, previewStatus, previewStudentSearch, previewStudentsTableBody;
+let versionsTemplateId = null, previewTemplateId = null, previewVersionId = null, previewObjectUrl = null;
+let previewSearchTimeout;
 
 window.initializeContractTemplatesPage = function() {
     modal = document.getElementById('templateModal');
@@ -22,11 +25,31 @@ window.initializeContractTemplatesPage = function() {
     });
     tableBody.addEventListener('click', handleTableActions);
 
+    versionsModal = document.getElementById('templateVersionsModal');
+    versionsTableBody = document.getElementById('templateVersionsTableBody');
+    versionsModal.querySelector('#closeTemplateVersionsModalBtn').addEventListener('click', () => closeModal(versionsModal));
+    versionsTableBody.addEventListener('click', handleVersionActions);
+
+    previewModal = document.getElementById('templatePreviewModal');
+    previewFrame = document.getElementById('previewFrame');
+    previewStatus = document.getElementById('previewStatus');
+    previewStudentSearch = document.getElementById('previewStudentSearch');
+    previewStudentsTableBody = document.getElementById('previewStudentsTableBody');
+    previewModal.querySelector('#closeTemplatePreviewModalBtn').addEventListener('click', closePreview);
+    previewStudentSearch.addEventListener('input', () => {
+        clearTimeout(previewSearchTimeout);
+        previewSearchTimeout = setTimeout(searchPreviewStudents, 300);
+    });
+    previewStudentsTableBody.addEventListener('click', e => {
+        const btn = e.target.closest('.select-preview-student-btn');
+        if (btn) renderPreview(btn.dataset.id);
+    });
+
     fetchAndRenderTemplates();
 };
 
 async function fetchAndRenderTemplates() {
-    tableBody.innerHTML = `<tr><td colspan="4" class="text-center">Загрузка...</td></tr>`;
+    tableBody.innerHTML = `<tr><td colspan="5" class="text-center">Загрузка...</td></tr>`;
     try {
         const templates = await fetchAuthenticated('/api/contract-templates');
         
@@ -35,7 +58,7 @@ async function fetchAndRenderTemplates() {
         // const canDelete = hasPermission('contract_templates_delete');
 
         if (templates.length === 0) {
-            tableBody.innerHTML = `<tr><td colspan="4" class="text-center">Шаблоны не найдены.</td></tr>`;
+            tableBody.innerHTML = `<tr><td colspan="5" class="text-center">Шаблоны не найдены.</td></tr>`;
             return;
         }
 
@@ -48,6 +71,8 @@ async function fetchAndRenderTemplates() {
                             <button class="action-button">Действия <i class="bi bi-chevron-down"></i></button>
                             <div class="action-dropdown-content">
                                 <a href="#" class="edit-contract-template-btn" data-id="${t.ID}"><i class="bi bi-pencil"></i> Изменить</a>
+                                <a href="#" class="versions-contract-template-btn" data-id="${t.ID}"><i class="bi bi-clock-history"></i> Версии</a>
+                                <a href="#" class="preview-contract-template-btn" data-id="${t.ID}"><i class="bi bi-eye"></i> Предпросмотр</a>
                                 <a href="#" class="delete-contract-template-btn" data-id="${t.ID}"><i class="bi bi-trash"></i> Удалить</a>
                             </div>
                         </div>
@@ -55,6 +80,7 @@ async function fetchAndRenderTemplates() {
                     <td data-label="Название">${t.name}</td>
                     <td data-label="Классификация">${t.classification}</td>
                     <td data-label="Тип подписи">${t.signatureType}</td>
+                    <td data-label="Версия">${t.currentVersion ? t.currentVersion.version : '—'}</td>
                 </tr>
             `;
         }).join('');
@@ -63,7 +89,7 @@ async function fetchAndRenderTemplates() {
         // Эта функция вызовется и скроет кнопки, если у пользователя нет прав
         window.updateTableActionsVisibility(); 
     } catch (error) {
-        tableBody.innerHTML = `<tr><td colspan="4" class="text-center text-danger">Ошибка: ${error.message}</td></tr>`;
+        tableBody.innerHTML = `<tr><td colspan="5" class="text-center text-danger">Ошибка: ${error.message}</td></tr>`;
     }
 }
 
@@ -116,11 +142,16 @@ async function handleFormSubmit(e) {
 
     try {
         // ИСПРАВЛЕНО: fetchAuthenticated теперь не требует Content-Type для FormData
-        await fetchAuthenticated(url, {
+        const saved = await fetchAuthenticated(url, {
             method: method,
             body: formData
         });
-        showAlert(`Шаблон успешно ${currentEditingId ? 'обновлен' : 'создан'}!`, 'success');
+        let message = `Шаблон успешно ${currentEditingId ? 'обновлен' : 'создан'}!`;
+        const unused = saved.currentVersion && saved.currentVersion.scan ? saved.currentVersion.scan.unused || [] : [];
+        if (unused.length > 0) {
+            message += ` Не используются: ${unused.join(', ')}.`;
+        }
+        showAlert(message, 'success');
         closeModal(modal);
         fetchAndRenderTemplates();
     } catch (error) {
@@ -132,10 +163,18 @@ function handleTableActions(e) {
     // Ищем кнопки по правильным классам
     const editBtn = e.target.closest('.edit-contract-template-btn');
     const deleteBtn = e.target.closest('.delete-contract-template-btn');
+    const versionsBtn = e.target.closest('.versions-contract-template-btn');
+    const previewBtn = e.target.closest('.preview-contract-template-btn');
 
     if (editBtn) {
         e.preventDefault();
         openModalForEdit(editBtn.dataset.id);
+    } else if (versionsBtn) {
+        e.preventDefault();
+        openVersionsModal(versionsBtn.dataset.id);
+    } else if (previewBtn) {
+        e.preventDefault();
+        openPreviewModal(previewBtn.dataset.id, null);
     } else if (deleteBtn) {
         e.preventDefault();
         const id = deleteBtn.dataset.id;
@@ -152,4 +191,137 @@ function handleTableActions(e) {
         });
     }
 }
+// --- Версии шаблона ---
+
+async function openVersionsModal(id) {
+    versionsTemplateId = id;
+    openModal(versionsModal);
+    await renderVersions();
+}
+
+async function renderVersions() {
+    versionsTableBody.innerHTML = `<tr><td colspan="6" class="text-center">Загрузка...</td></tr>`;
+    try {
+        const [template, versions] = await Promise.all([
+            fetchAuthenticated(`/api/contract-templates/${versionsTemplateId}`),
+            fetchAuthenticated(`/api/contract-templates/${versionsTemplateId}/versions`)
+        ]);
+        if (versions.length === 0) {
+            versionsTableBody.innerHTML = `<tr><td colspan="6" class="text-center">Версий нет.</td></tr>`;
+            return;
+        }
+        versionsTableBody.innerHTML = versions.map(({ version: v, contracts }) => {
+            const isCurrent = v.id === template.currentVersionId;
+            const found = v.scan && v.scan.found ? v.scan.found.length : 0;
+            const unused = v.scan && v.scan.unused && v.scan.unused.length > 0
+                ? `<br><small class="text-muted">не используются: ${v.scan.unused.join(', ')}</small>` : '';
+            return `
+                <tr>
+                    <td data-label="Версия">${v.version}${isCurrent ? ' <strong>(действующая)</strong>' : ''}</td>
+                    <td data-label="Файл"><a href="${v.filePath}" download>${v.originalFileName}</a></td>
+                    <td data-label="Загружена">${new Date(v.createdAt).toLocaleString('ru-RU')}</td>
+                    <td data-label="Плейсхолдеры">${found}${unused}</td>
+                    <td data-label="Договоров">${contracts}</td>
+                    <td data-label="Действия" class="text-center">
+                        <button type="button" class="button-secondary btn-sm preview-version-btn" data-id="${v.id}">Предпросмотр</button>
+                        ${isCurrent ? '' : `<button type="button" class="button-secondary btn-sm activate-version-btn" data-id="${v.id}" data-version="${v.version}">Сделать действующей</button>`}
+                    </td>
+                </tr>
+            `;
+        }).join('');
+    } catch (error) {
+        versionsTableBody.innerHTML = `<tr><td colspan="6" class="text-center text-danger">Ошибка: ${error.message}</td></tr>`;
+    }
+}
+
+async function handleVersionActions(e) {
+    const previewBtn = e.target.closest('.preview-version-btn');
+    const activateBtn = e.target.closest('.activate-version-btn');
+    if (previewBtn) {
+        openPreviewModal(versionsTemplateId, previewBtn.dataset.id);
+    } else if (activateBtn) {
+        const confirmed = await showConfirm(`Сделать версию ${activateBtn.dataset.version} действующей? Новые документы будут формироваться по ней.`);
+        if (!confirmed) return;
+        try {
+            await fetchAuthenticated(`/api/contract-templates/${versionsTemplateId}/versions/${activateBtn.dataset.id}/activate`, { method: 'PUT' });
+            showAlert('Версия шаблона сделана действующей.', 'success');
+            renderVersions();
+            fetchAndRenderTemplates();
+        } catch (error) {
+            showAlert(`Ошибка: ${error.message}`, 'error');
+        }
+    }
+}
+
+// --- Предпросмотр ---
+
+function openPreviewModal(templateId, versionId) {
+    previewTemplateId = templateId;
+    previewVersionId = versionId;
+    previewStudentSearch.value = '';
+    previewStudentsTableBody.innerHTML = '';
+    previewStatus.textContent = 'Выберите ученика, на данных которого будет заполнен шаблон.';
+    previewFrame.style.display = 'none';
+    openModal(previewModal);
+}
+
+function closePreview() {
+    closeModal(previewModal);
+    previewFrame.removeAttribute('src');
+    if (previewObjectUrl) {
+        URL.revokeObjectURL(previewObjectUrl);
+        previewObjectUrl = null;
+    }
+}
+
+async function searchPreviewStudents() {
+    const query = previewStudentSearch.value.trim();
+    if (query.length < 2) {
+        previewStudentsTableBody.innerHTML = '';
+        return;
+    }
+    try {
+        const response = await fetchAuthenticated(`/api/students?all=true&search=${encodeURIComponent(query)}`);
+        const students = Array.isArray(response.data) ? response.data.slice(0, 10) : [];
+        previewStudentsTableBody.innerHTML = students.length > 0 ? students.map(s => `
+            <tr>
+                <td data-label="ФИО">${s.lastName} ${s.firstName}</td>
+                <td data-label="Класс">${s.grade || '—'} ${s.liter || '—'}</td>
+                <td data-label="Выбрать" class="text-center">
+                    <button type="button" class="button-primary btn-sm select-preview-student-btn" data-id="${s.ID}">Показать</button>
+                </td>
+            </tr>
+        `).join('') : `<tr><td colspan="3" class="text-center">Ученики не найдены.</td></tr>`;
+    } catch (error) {
+        previewStudentsTableBody.innerHTML = `<tr><td colspan="3" class="text-center text-danger">Ошибка: ${error.message}</td></tr>`;
+    }
+}
+
+async function renderPreview(studentId) {
+    previewStatus.textContent = 'Формирование документа...';
+    previewFrame.style.display = 'none';
+    const params = new URLSearchParams({ studentId });
+    if (previewVersionId) params.set('versionId', previewVersionId);
+    try {
+        const token = getToken();
+        const resp = await fetch(`/api/contract-templates/${previewTemplateId}/preview?${params}`, {
+            headers: token ? { 'Authorization': `Bearer ${token}` } : {}
+        });
+        if (!resp.ok) {
+            let msg = `HTTP ${resp.status}`;
+            try {
+                const j = await resp.json();
+                msg = j.error || msg;
+            } catch (_) {}
+            throw new Error(msg);
+        }
+        if (previewObjectUrl) URL.revokeObjectURL(previewObjectUrl);
+        previewObjectUrl = URL.createObjectURL(await resp.blob());
+        previewFrame.src = previewObjectUrl;
+        previewFrame.style.display = 'block';
+        previewStatus.textContent = '';
+    } catch (error) {
+        previewStatus.textContent = `Не удалось сформировать предпросмотр: ${error.message}`;
+    }
+}
 window.initializeContractTemplatesPage();
\ No newline at end of file
@@ -15,7 +15,8 @@ import {
     initializeActionDropdowns, // оставим импорт, но используем свою версию для контракта
     renderPagination,
     formatDate,
-    formatCurrency
+    formatCurrency,
+    renderPlanDiff
 } from './utils.js';
 
 // --- 1. Глобальные переменные и состояние ---
@@ -30,6 +31,7 @@ const dom = {
     tableBody: document.getElementById('contractsTableBody'),
     paginationContainer: document.getElementById('paginationContainer'),
     contractsSearchInput: document.getElementById('contractsSearchInput'),
+    contractsTerminatedFilter: document.getElementById('contractsTerminatedFilter'),
 
     // Модальное окно редактирования/просмотра договора
     contractModal: document.getElementById('contractModal'),
@@ -58,6 +60,16 @@ const dom = {
     cancelPlanBtn: document.getElementById('cancelPlanBtn'),
     savePlanBtn: document.getElementById('savePlanBtn'),
     planInstallmentsContainer: document.getElementById('plan_installments_container'),
+
+    // Модальное окно выбытия ученика
+    withdrawalModal: document.getElementById('withdrawalModal'),
+    withdrawalForm: document.getElementById('withdrawalForm'),
+    withdrawalResult: document.getElementById('withdrawal_result'),
+
+    // Модальное окно плательщиков
+    payersModal: document.getElementById('payersModal'),
+    payersTableBody: document.getElementById('payersTableBody'),
+    payerForm: document.getElementById('payerForm'),
 };
 
 /**
@@ -82,6 +94,8 @@ function bindEventListeners() {
         }, 300); // Задержка в 300 мс
     });
 
+    dom.contractsTerminatedFilter?.addEventListener('change', () => fetchAndRender(1));
+
     // Делегирование событий для всех кнопок в таблице
     dom.tableBody.addEventListener('click', handleTableActions);
 
@@ -96,11 +110,26 @@ function bindEventListeners() {
     dom.closeAddPaymentModalBtn?.addEventListener('click', () => closeModal(dom.addPaymentModal));
     dom.cancelAddPaymentBtn?.addEventListener('click', () => closeModal(dom.addPaymentModal));
     dom.addPaymentForm?.addEventListener('submit', handleAddPaymentSubmit);
+    document.getElementById('paymentContractSelect')?.addEventListener('change', e => loadPaymentPayers(e.target.value));
 
     dom.closePlanModalBtn?.addEventListener('click', () => closeModal(dom.planModal));
     dom.cancelPlanBtn?.addEventListener('click', () => closeModal(dom.planModal));
     dom.planForm?.addEventListener('submit', handlePlanFormSubmit);
 
+    document.getElementById('closeWithdrawalModalBtn')?.addEventListener('click', () => closeModal(dom.withdrawalModal));
+    document.getElementById('cancelWithdrawalBtn')?.addEventListener('click', () => closeModal(dom.withdrawalModal));
+    document.getElementById('previewWithdrawalBtn')?.addEventListener('click', previewWithdrawal);
+    document.getElementById('refundWithdrawalBtn')?.addEventListener('click', handleWithdrawalRefund);
+    document.getElementById('withdrawalDocumentBtn')?.addEventListener('click', () =>
+        downloadFile(`/api/contracts/${currentContractId}/withdrawal/document`, `termination_${currentContractId}.pdf`)
+            .catch(err => showAlert(`Не удалось сформировать соглашение: ${err.message}`, 'error')));
+    dom.withdrawalForm?.addEventListener('submit', handleWithdrawalSubmit);
+
+    document.getElementById('closePayersModalBtn')?.addEventListener('click', () => closeModal(dom.payersModal));
+    document.getElementById('resetPayerFormBtn')?.addEventListener('click', () => fillPayerForm(null));
+    dom.payersTableBody?.addEventListener('click', handlePayersTableClick);
+    dom.payerForm?.addEventListener('submit', handlePayerFormSubmit);
+
     // Переключатели скидок в модальном окне плана
     document.getElementById('sumDiscountBtn')?.addEventListener('click', () => toggleDiscountInput('sum'));
     document.getElementById('percentDiscountBtn')?.addEventListener('click', () => toggleDiscountInput('percent'));
@@ -122,7 +151,8 @@ async function fetchAndRender(page = 1) {
     dom.tableBody.innerHTML = `<tr><td colspan="10" class="text-center">Загрузка данных...</td></tr>`;
     try {
         // Формируем URL с параметрами страницы и поиска
-        const response = await fetchAuthenticated(`/api/contracts?page=${page}&search=${encodeURIComponent(searchQuery)}`);
+        const terminated = dom.contractsTerminatedFilter?.checked ? '&terminated=true' : '';
+        const response = await fetchAuthenticated(`/api/contracts?page=${page}&search=${encodeURIComponent(searchQuery)}${terminated}`);
         const items = response.data || [];
 
         if (items.length > 0) {
@@ -144,6 +174,17 @@ async function fetchAndRender(page = 1) {
                     discountedAmount = item.discountedAmount != null ? formatCurrency(item.discountedAmount) : '—';
                     paymentForm = item.paymentFormName || 'Не задан';
                     manager = item.managerFullName || '—';
+                    if (item.terminatedAt) {
+                        status = `Расторгнут ${formatDate(item.terminatedAt)}`;
+                    }
+                    if (item.pdfStatus === 'pending') {
+                        status = 'PDF формируется';
+                    } else if (item.pdfStatus === 'failed') {
+                        status = 'Ошибка формирования PDF';
+                    }
+                    const retryPdf = item.pdfStatus === 'failed'
+                        ? `<a href="#" class="retry-pdf-btn" data-id="${item.id}"><i class="bi bi-arrow-clockwise"></i> Сформировать PDF повторно</a>`
+                        : '';
 
                     // Полное меню действий + "Скачать" + "Создать договор" для данного ученика
                     actions = `
@@ -157,8 +198,15 @@ async function fetchAndRender(page = 1) {
                                 <a href="#" class="add-payment-btn" data-student-id="${item.studentId}" data-contract-id="${item.id}"><i class="bi bi-currency-dollar"></i> Добавить оплату</a>
                                 <a href="#" class="plan-btn" data-id="${item.id}"><i class="bi bi-calendar-plus"></i> Создать план платежей</a>
                                 <a href="#" class="download-contract-btn" data-id="${item.id}" data-number="${contractNumber}"><i class="bi bi-download"></i> Скачать</a>
+                                ${retryPdf}
+                                <a href="#" class="reconciliation-act-btn" data-id="${item.id}" data-format="pdf"><i class="bi bi-file-earmark-text"></i> Акт сверки (PDF)</a>
+                                <a href="#" class="reconciliation-act-btn" data-id="${item.id}" data-format="xlsx"><i class="bi bi-file-earmark-spreadsheet"></i> Акт сверки (Excel)</a>
+                                <a href="#" class="family-act-btn" data-student-id="${item.studentId}"><i class="bi bi-people"></i> Акт сверки по семье</a>
+                                <a href="#" class="payers-btn" data-id="${item.id}"><i class="bi bi-people-fill"></i> Плательщики</a>
+                                <a href="#" class="withdrawal-btn" data-id="${item.id}"><i class="bi bi-box-arrow-right"></i> Выбытие / расторжение</a>
                                 <a href="#" class="send-trustme-btn" data-id="${item.id}"><i class="bi bi-send-check"></i> Отправить через TrustMe</a>
                                 <hr>
+                                <a href="#" class="renew-contract-btn" data-id="${item.id}"><i class="bi bi-arrow-repeat"></i> Продлить на следующий год</a>
                                 <a href="#" class="create-contract-btn" data-student-id="${item.studentId}"><i class="bi bi-plus-lg"></i> Создать договор</a>
                             </div>
                         </div>
@@ -245,39 +293,96 @@ function handleTableActions(e) {
     } else if (classList.contains('download-contract-btn')) {
         const number = target.dataset.number || 'contract';
         downloadContract(id, number);
+    } else if (classList.contains('reconciliation-act-btn')) {
+        downloadReconciliationAct(`/api/contracts/${id}`, target.dataset.format, `act_${id}`);
+    } else if (classList.contains('family-act-btn')) {
+        downloadReconciliationAct(`/api/students/${studentId}`, 'pdf', `act_family_${studentId}`);
+    } else if (classList.contains('payers-btn')) {
+        openPayersModal(id);
+    } else if (classList.contains('withdrawal-btn')) {
+        openWithdrawalModal(id);
+    } else if (classList.contains('renew-contract-btn')) {
+        handleRenewContract(id);
+    } else if (classList.contains('retry-pdf-btn')) {
+        handleRetryContractPdf(id);
     }
 }
 
 /**
- * Создает новый договор для ученика.
- * - Сумма берётся из «Стоимость обучения»:
- *   admissionYear <= 2023 → цена 2023; иначе → текущая (максимальный год).
+ * Создает новый договор для ученика на текущий учебный год.
+ * Сумма рассчитывается сервером по прайс-листу «Стоимость обучения»
+ * с учетом правил сохранения цены; перед созданием она показывается менеджеру.
  * @param {string} studentId - ID ученика.
  */
 async function handleCreateContract(studentId) {
-    const confirmed = await showConfirm(
-        'Создать новый договор для этого ученика?',
-        'Сумма будет определена автоматически из «Стоимость обучения».'
-    );
+    let quote;
+    try {
+        quote = await fetchAuthenticated(`/api/contracts/tuition-quote?student_id=${studentId}`);
+    } catch (error) {
+        showAlert(`Не удалось рассчитать стоимость: ${error.message}`, 'error');
+        return;
+    }
+
+    const confirmed = await showConfirm(`Создать новый договор для этого ученика? ${describeTuitionQuote(quote)}`);
     if (!confirmed) return;
 
     try {
-        // 1) Считаем сумму по правилу
-        const { amount } = await computeTuitionAmountForStudent(studentId);
-
-        // 2) Создаём контракт (если бэк принимает totalAmount — укажем сразу)
         await postContractWithDuplicateRetry({
             studentId: parseInt(studentId, 10),
-            totalAmount: amount || undefined
+            academicYearId: quote.academicYearId
         });
 
-        showAlert('Договор успешно создан!', 'success');
+        showAlert('Договор успешно создан! PDF будет приложен, как только документ будет сформирован.', 'success');
         fetchAndRender(1);
     } catch (error) {
         showAlert(`Ошибка создания договора: ${error.message}`, 'error');
     }
 }
 
+/**
+ * Продлевает договор на следующий учебный год (та же форма оплаты, цена по прайс-листу нового года).
+ * @param {string} contractId - ID договора.
+ */
+async function handleRenewContract(contractId) {
+    const confirmed = await showConfirm('Продлить договор на следующий учебный год? Сумма будет определена по прайс-листу следующего учебного года.');
+    if (!confirmed) return;
+
+    try {
+        const result = await fetchAuthenticated(`/api/contracts/${contractId}/renew`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({})
+        });
+        showAlert(`Договор ${result.contract.contractNumber} создан. ${describeTuitionQuote(result.quote)}`, 'success', 8000);
+        fetchAndRender(1);
+    } catch (error) {
+        showAlert(`Ошибка продления договора: ${error.message}`, 'error');
+    }
+}
+
+/**
+ * Повторно ставит договор в очередь формирования PDF после сбоя конвертера.
+ * @param {string} contractId - ID договора.
+ */
+async function handleRetryContractPdf(contractId) {
+    try {
+        await fetchAuthenticated(`/api/contracts/${contractId}/pdf/retry`, { method: 'POST' });
+        showAlert('PDF договора поставлен в очередь на формирование.', 'success');
+        fetchAndRender(1);
+    } catch (error) {
+        showAlert(`Не удалось повторить формирование PDF: ${error.message}`, 'error');
+    }
+}
+
+function describeTuitionQuote(quote) {
+    let text = `${quote.academicYear}, ${quote.grade === 0 ? 'подготовительный' : quote.grade + ' класс'}: ${formatCurrency(quote.amount)}.`;
+    if (quote.ruleId) {
+        text += ` Сохранена цена ${quote.priceYear} (${quote.grandfatherFor}`;
+        text += quote.indexPercent ? `, индексация ${quote.indexPercent}%).` : ').';
+    }
+    return text;
+}
+
 /**
  * Повторная попытка создания договора при конфликте номера (уникальный ключ).
  * Сервер вернёт 409/500 с сообщением о duplicate — пробуем ещё несколько раз.
@@ -330,6 +435,8 @@ async function openContractModal(id, isReadOnly = false) {
         dom.contractForm.elements.totalAmount.value = contract.totalAmount;
         dom.contractForm.elements.discountPercentage.value = contract.discountPercentage;
         dom.contractForm.elements.discountedAmount.value = contract.discountedAmount;
+        dom.contractForm.elements.effectiveDate.value = new Date().toISOString().split('T')[0];
+        loadContractAmendments(id);
 
         // Блокировка/разблокировка полей в зависимости от режима
         const fields = dom.contractForm.querySelectorAll('input, select');
@@ -434,6 +541,7 @@ async function openAddPaymentModal(studentId, contractIdFromButton) {
             defaultContractId,
             'Выберите договор'
         );
+        await loadPaymentPayers(contractSelect.value);
 
         openModal(dom.addPaymentModal);
     } catch (error) {
@@ -445,6 +553,30 @@ async function openAddPaymentModal(studentId, contractIdFromButton) {
 }
 
 
+/**
+ * Заполняет список плательщиков в окне оплаты. Если у договора плательщиков нет,
+ * поле скрыто и платеж относится к договору в целом.
+ * @param {string} contractId - ID выбранного договора.
+ */
+async function loadPaymentPayers(contractId) {
+    const group = document.getElementById('paymentPayerGroup');
+    const select = document.getElementById('paymentPayerSelect');
+    if (!group || !select) return;
+    select.innerHTML = '<option value="">Не указан</option>';
+    group.style.display = 'none';
+    if (!contractId) return;
+    try {
+        const summary = await fetchAuthenticated(`/api/contracts/${contractId}/payers`);
+        (summary.payers || []).forEach(({ payer, balance }) => {
+            select.insertAdjacentHTML('beforeend',
+                `<option value="${payer.ID}">${payer.name} (остаток ${formatCurrency(balance)})</option>`);
+        });
+        group.style.display = summary.payers?.length ? '' : 'none';
+    } catch (_) {
+        // без списка плательщиков платеж вносится по договору в целом
+    }
+}
+
 /**
  * Открывает модальное окно для создания плана платежей.
  * @param {string} contractId - ID договора.
@@ -499,6 +631,8 @@ async function handleContractFormSubmit(e) {
         endDate: formData.get('endDate'),
         totalAmount: parseFloat(formData.get('totalAmount')),
         discountPercentage: parseFloat(formData.get('discountPercentage')),
+        effectiveDate: formData.get('effectiveDate'),
+        amendmentReason: formData.get('amendmentReason'),
     };
 
     try {
@@ -540,6 +674,9 @@ async function handleAddPaymentSubmit(e) {
         paymentDate: formData.get('paymentDate'),
         comment: formData.get('comment'),
     };
+    if (formData.get('payerId')) {
+        data.payerId = parseInt(formData.get('payerId'), 10);
+    }
 
     try {
         // Используем правильный эндпоинт для добавления фактического платежа.
@@ -570,7 +707,7 @@ async function handlePlanFormSubmit(e) {
 
     const confirmed = await showConfirm(
         'Вы уверены, что хотите сгенерировать новый план?',
-        'Существующий план платежей для этого договора будет полностью удален и заменен новым.'
+        'Оплаченные платежи сохранятся, остаток суммы будет распределен по будущим платежам новой формы.'
     );
     if (!confirmed) return;
 
@@ -629,39 +766,365 @@ async function handleSendToTrustMe(contractId) {
  */
 async function downloadContract(contractId, contractNumber = 'contract') {
     try {
-        const token = localStorage.getItem('token'); // тот же источник, что использует fetchAuthenticated
-        const resp = await fetch(`/api/contracts/${contractId}/download`, {
-            headers: token ? { 'Authorization': `Bearer ${token}` } : {}
+        await downloadFile(`/api/contracts/${contractId}/download`, `${contractNumber}.pdf`);
+    } catch (err) {
+        showAlert(`Не удалось скачать договор: ${err.message}`, 'error');
+    }
+}
+
+/**
+ * Показывает в карточке договора историю дополнительных соглашений со ссылками на PDF.
+ */
+async function loadContractAmendments(contractId) {
+    const container = document.getElementById('contractAmendmentsList');
+    if (!container) return;
+    container.innerHTML = '';
+    try {
+        const amendments = await fetchAuthenticated(`/api/contracts/${contractId}/amendments`);
+        if (!amendments.length) return;
+        container.innerHTML = `
+            <label>Дополнительные соглашения</label>
+            <table class="table"><tbody>
+                ${amendments.map(a => `
+                    <tr>
+                        <td>№ ${a.documentNumber || a.number} от ${formatDate(a.CreatedAt)}, действует с ${formatDate(a.effectiveDate)}</td>
+                        <td>${(a.changes || []).map(ch => `${ch.label}: ${ch.oldValue} → ${ch.newValue}`).join('<br>')}</td>
+                        <td>${a.reason || ''}${a.authorName ? `<br><small>${a.authorName}</small>` : ''}</td>
+                        <td><a href="#" class="amendment-download-btn" data-id="${a.ID}" data-number="${a.number}"><i class="bi bi-download"></i> PDF</a></td>
+                    </tr>`).join('')}
+            </tbody></table>`;
+        container.querySelectorAll('.amendment-download-btn').forEach(link => {
+            link.addEventListener('click', (e) => {
+                e.preventDefault();
+                downloadFile(`/api/contracts/${contractId}/amendments/${link.dataset.id}/download`, `amendment_${link.dataset.number}.pdf`)
+                    .catch(err => showAlert(`Не удалось скачать соглашение: ${err.message}`, 'error'));
+            });
+        });
+    } catch (err) {
+        container.innerHTML = '';
+    }
+}
+
+/**
+ * Открывает окно выбытия: для расторгнутого договора показывает сохраненный расчет,
+ * иначе - форму для расчета на выбранную дату.
+ */
+async function openWithdrawalModal(contractId) {
+    currentContractId = contractId;
+    dom.withdrawalForm.reset();
+    dom.withdrawalResult.innerHTML = '';
+    document.getElementById('withdrawal_date').value = new Date().toISOString().slice(0, 10);
+
+    let existing = null;
+    try {
+        existing = await fetchAuthenticated(`/api/contracts/${contractId}/withdrawal`);
+    } catch (_) {
+        // 404 - договор еще не расторгнут
+    }
+    setWithdrawalMode(existing?.withdrawal || null);
+    if (existing?.withdrawal) {
+        renderWithdrawal(existing.withdrawal, []);
+    }
+    openModal(dom.withdrawalModal);
+}
+
+/**
+ * Переключает кнопки окна: до расторжения - расчет и оформление, после - документ и возврат.
+ */
+function setWithdrawalMode(withdrawal) {
+    const done = Boolean(withdrawal);
+    document.getElementById('previewWithdrawalBtn').style.display = done ? 'none' : '';
+    document.getElementById('saveWithdrawalBtn').style.display = done ? 'none' : '';
+    document.getElementById('withdrawalDocumentBtn').style.display = done ? '' : 'none';
+    document.getElementById('refundWithdrawalBtn').style.display =
+        done && withdrawal.refundAmount > 0 && !withdrawal.refundedAt ? '' : 'none';
+    ['withdrawal_date', 'withdrawal_method', 'withdrawal_reason'].forEach(id => {
+        document.getElementById(id).disabled = done;
+    });
+    if (done) {
+        document.getElementById('withdrawal_date').value = withdrawal.withdrawalDate.slice(0, 10);
+        document.getElementById('withdrawal_method').value = withdrawal.method;
+        document.getElementById('withdrawal_reason').value = withdrawal.reason || '';
+    }
+}
+
+function withdrawalPayload() {
+    return {
+        withdrawalDate: document.getElementById('withdrawal_date').value,
+        method: document.getElementById('withdrawal_method').value,
+        reason: document.getElementById('withdrawal_reason').value
+    };
+}
+
+/**
+ * Показывает расчет выбытия (из превью или сохраненного расторжения).
+ */
+function renderWithdrawal(calc, explanation) {
+    const rows = [
+        ['Стоимость договора с учетом скидок', calc.contractAmount],
+        ['Невозвратный взнос', calc.depositAmount],
+        [`Стоимость после пересчета (${calc.periodsCharged} из ${calc.periodsTotal})`, calc.chargeableAmount],
+        ['Оплачено', calc.paidAmount],
+        ['К возврату', calc.refundAmount],
+        ['Остаток долга', calc.debtAmount],
+    ];
+    const cancelled = Array.isArray(calc.cancelledPayments)
+        ? calc.cancelledPayments.length + (calc.reducedPayments || []).length
+        : calc.cancelledPayments;
+    let html = '<table class="table"><tbody>' +
+        rows.map(([label, value]) => `<tr><td>${label}</td><td style="text-align: right;">${formatCurrency(value)}</td></tr>`).join('') +
+        `<tr><td>Отменяется и уменьшается платежей графика</td><td style="text-align: right;">${cancelled || 0} (${formatCurrency(calc.cancelledAmount)})</td></tr>` +
+        '</tbody></table>';
+    if (explanation.length) {
+        html += `<ul>${explanation.map(line => `<li>${line}</li>`).join('')}</ul>`;
+    }
+    if (calc.refundedAt) {
+        html += `<p>Возврат выплачен ${formatDate(calc.refundedAt)}</p>`;
+    }
+    dom.withdrawalResult.innerHTML = html;
+}
+
+async function previewWithdrawal() {
+    try {
+        const calc = await fetchAuthenticated(`/api/contracts/${currentContractId}/withdrawal/preview`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(withdrawalPayload())
         });
+        renderWithdrawal(calc, calc.explanation || []);
+    } catch (err) {
+        showAlert(`Не удалось рассчитать выбытие: ${err.message}`, 'error');
+    }
+}
 
-        if (!resp.ok) {
-            // попробуем вытащить сообщение об ошибке
-            let msg = `HTTP ${resp.status}`;
-            try {
-                const j = await resp.json();
-                msg = j.error || j.message || msg;
-            } catch (_) {}
-            throw new Error(msg);
+async function handleWithdrawalSubmit(e) {
+    e.preventDefault();
+    const confirmed = await showConfirm('Оформить выбытие? Будущие платежи графика будут отменены, договор будет расторгнут.');
+    if (!confirmed) return;
+    try {
+        const result = await fetchAuthenticated(`/api/contracts/${currentContractId}/withdrawal`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(withdrawalPayload())
+        });
+        setWithdrawalMode(result.withdrawal);
+        renderWithdrawal(result.withdrawal, result.calculation?.explanation || []);
+        if (result.documentError) {
+            showAlert(`Выбытие оформлено, но соглашение не сформировано: ${result.documentError}`, 'warning');
+        } else {
+            showAlert('Выбытие оформлено', 'success');
         }
+        fetchAndRender(1);
+    } catch (err) {
+        showAlert(`Не удалось оформить выбытие: ${err.message}`, 'error');
+    }
+}
+
+async function handleWithdrawalRefund() {
+    const confirmed = await showConfirm('Отметить возврат переплаты как выплаченный?');
+    if (!confirmed) return;
+    try {
+        const result = await fetchAuthenticated(`/api/contracts/${currentContractId}/withdrawal/refund`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({})
+        });
+        setWithdrawalMode(result.withdrawal);
+        renderWithdrawal(result.withdrawal, []);
+        showAlert('Возврат отражен в журнале расчетов', 'success');
+    } catch (err) {
+        showAlert(`Не удалось отразить возврат: ${err.message}`, 'error');
+    }
+}
+
+/**
+ * Скачивание акта сверки по договору или по семье (basePath - /api/contracts/:id или /api/students/:id).
+ */
+async function downloadReconciliationAct(basePath, format = 'pdf', fileBase = 'act') {
+    try {
+        await downloadFile(`${basePath}/reconciliation-act?format=${format}`, `${fileBase}.${format}`);
+    } catch (err) {
+        showAlert(`Не удалось сформировать акт сверки: ${err.message}`, 'error');
+    }
+}
+
+// ===================================================================
+// Плательщики по договору
+// ===================================================================
+
+const payerKindNames = {
+    parent: 'Родитель',
+    employer: 'Работодатель',
+    charity: 'Благотворительный фонд',
+    other: 'Другое',
+};
+
+let currentPayers = [];
+
+async function openPayersModal(contractId) {
+    currentContractId = contractId;
+    fillPayerForm(null);
+    await loadPayers();
+    openModal(dom.payersModal);
+}
+
+async function loadPayers() {
+    try {
+        const summary = await fetchAuthenticated(`/api/contracts/${currentContractId}/payers`);
+        currentPayers = summary.payers || [];
+        renderPayers(summary);
+    } catch (err) {
+        showAlert(`Не удалось загрузить плательщиков: ${err.message}`, 'error');
+    }
+}
+
+function describePayerShare(payer) {
+    switch (payer.shareType) {
+        case 'percent': return `${payer.sharePercent}%`;
+        case 'fixed': return formatCurrency(payer.fixedAmount);
+        default: return 'Остаток';
+    }
+}
+
+function renderPayers(summary) {
+    let info = `<p>К оплате по договору: <strong>${formatCurrency(summary.payable)}</strong>`;
+    if (summary.payers.length && summary.uncovered !== 0) {
+        info += `. Не распределено между плательщиками: <strong>${formatCurrency(summary.uncovered)}</strong>`;
+    }
+    if (summary.unattributedPaid) {
+        info += `. Поступления без плательщика: <strong>${formatCurrency(summary.unattributedPaid)}</strong>`;
+    }
+    document.getElementById('payers_summary').innerHTML = info + '</p>';
+
+    if (!currentPayers.length) {
+        dom.payersTableBody.innerHTML = '<tr><td colspan="6" class="text-center">Плательщики не заданы: договор оплачивает законный представитель</td></tr>';
+        return;
+    }
+    dom.payersTableBody.innerHTML = currentPayers.map(({ payer, obligation, paid, balance }) => `
+        <tr>
+            <td class="text-center">
+                <button class="button-secondary btn-sm edit-payer-btn" data-id="${payer.ID}" title="Изменить"><i class="bi bi-pencil"></i></button>
+                <button class="button-secondary btn-sm payer-act-btn" data-id="${payer.ID}" data-format="pdf" title="Акт сверки (PDF)"><i class="bi bi-file-earmark-text"></i></button>
+                <button class="button-secondary btn-sm payer-act-btn" data-id="${payer.ID}" data-format="xlsx" title="Акт сверки (Excel)"><i class="bi bi-file-earmark-spreadsheet"></i></button>
+                <button class="button-secondary btn-sm delete-payer-btn" data-id="${payer.ID}" title="Удалить"><i class="bi bi-trash"></i></button>
+            </td>
+            <td>${payer.name}<br><small>${payerKindNames[payer.kind] || payer.kind}${payer.iin ? ', ' + payer.iin : ''}</small></td>
+            <td>${describePayerShare(payer)}</td>
+            <td>${formatCurrency(obligation)}</td>
+            <td>${formatCurrency(paid)}</td>
+            <td>${formatCurrency(balance)}</td>
+        </tr>
+    `).join('');
+}
+
+function fillPayerForm(payer) {
+    dom.payerForm?.reset();
+    if (!dom.payerForm) return;
+    document.getElementById('payer_id').value = payer ? payer.ID : '';
+    document.getElementById('savePayerBtn').textContent = payer ? 'Сохранить изменения' : 'Добавить плательщика';
+    if (!payer) return;
+    document.getElementById('payer_kind').value = payer.kind;
+    document.getElementById('payer_name').value = payer.name;
+    document.getElementById('payer_iin').value = payer.iin || '';
+    document.getElementById('payer_phone').value = payer.phone || '';
+    document.getElementById('payer_email').value = payer.email || '';
+    document.getElementById('payer_address').value = payer.address || '';
+    document.getElementById('payer_shareType').value = payer.shareType;
+    document.getElementById('payer_shareValue').value =
+        payer.shareType === 'percent' ? payer.sharePercent : payer.shareType === 'fixed' ? payer.fixedAmount : '';
+    document.getElementById('payer_comment').value = payer.comment || '';
+}
 
-        const blob = await resp.blob();
-        // если сервер вернул JSON по ошибке, content-type может быть application/json
-        if (blob.type && blob.type.includes('json')) {
-            const text = await blob.text();
-            throw new Error(text || 'Сервер вернул JSON вместо PDF');
+async function handlePayersTableClick(e) {
+    const btn = e.target.closest('button');
+    if (!btn) return;
+    const payerId = btn.dataset.id;
+    if (btn.classList.contains('edit-payer-btn')) {
+        fillPayerForm(currentPayers.find(p => String(p.payer.ID) === payerId)?.payer || null);
+    } else if (btn.classList.contains('payer-act-btn')) {
+        downloadReconciliationAct(`/api/contracts/${currentContractId}/payers/${payerId}`, btn.dataset.format, `act_${currentContractId}_payer_${payerId}`);
+    } else if (btn.classList.contains('delete-payer-btn')) {
+        const confirmed = await showConfirm('Удалить плательщика? Его неоплаченные платежи графика перейдут остальным плательщикам.');
+        if (!confirmed) return;
+        try {
+            await fetchAuthenticated(`/api/contracts/${currentContractId}/payers/${payerId}`, { method: 'DELETE' });
+            showAlert('Плательщик удален', 'success');
+            fillPayerForm(null);
+            loadPayers();
+        } catch (err) {
+            showAlert(`Не удалось удалить плательщика: ${err.message}`, 'error');
         }
+    }
+}
 
-        const url = URL.createObjectURL(blob);
-        const a = document.createElement('a');
-        a.href = url;
-        a.download = `${contractNumber}.pdf`;
-        document.body.appendChild(a);
-        a.click();
-        a.remove();
-        URL.revokeObjectURL(url);
+async function handlePayerFormSubmit(e) {
+    e.preventDefault();
+    const payerId = document.getElementById('payer_id').value;
+    const shareType = document.getElementById('payer_shareType').value;
+    const shareValue = parseFloat(document.getElementById('payer_shareValue').value) || 0;
+    const data = {
+        kind: document.getElementById('payer_kind').value,
+        name: document.getElementById('payer_name').value,
+        iin: document.getElementById('payer_iin').value,
+        phone: document.getElementById('payer_phone').value,
+        email: document.getElementById('payer_email').value,
+        address: document.getElementById('payer_address').value,
+        shareType,
+        sharePercent: shareType === 'percent' ? shareValue : 0,
+        fixedAmount: shareType === 'fixed' ? shareValue : 0,
+        comment: document.getElementById('payer_comment').value,
+    };
+    const url = payerId
+        ? `/api/contracts/${currentContractId}/payers/${payerId}`
+        : `/api/contracts/${currentContractId}/payers`;
+    try {
+        await fetchAuthenticated(url, {
+            method: payerId ? 'PUT' : 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(data)
+        });
+        showAlert(payerId ? 'Плательщик обновлен' : 'Плательщик добавлен', 'success');
+        fillPayerForm(null);
+        loadPayers();
     } catch (err) {
-        showAlert(`Не удалось скачать договор: ${err.message}`, 'error');
+        showAlert(`Не удалось сохранить плательщика: ${err.message}`, 'error');
+    }
+}
+
+/**
+ * Скачивает файл (PDF, XLSX) с авторизацией по Bearer-токену.
+ */
+async function downloadFile(url, fileName) {
+    const token = localStorage.getItem('token'); // тот же источник, что использует fetchAuthenticated
+    const resp = await fetch(url, {
+        headers: token ? { 'Authorization': `Bearer ${token}` } : {}
+    });
+
+    if (!resp.ok) {
+        // попробуем вытащить сообщение об ошибке
+        let msg = `HTTP ${resp.status}`;
+        try {
+            const j = await resp.json();
+            msg = j.error || j.message || msg;
+        } catch (_) {}
+        throw new Error(msg);
     }
+
+    const blob = await resp.blob();
+    // если сервер вернул JSON по ошибке, content-type может быть application/json
+    if (blob.type && blob.type.includes('json')) {
+        const text = await blob.text();
+        throw new Error(text || 'Сервер вернул JSON вместо файла');
+    }
+
+    const objectUrl = URL.createObjectURL(blob);
+    const a = document.createElement('a');
+    a.href = objectUrl;
+    a.download = fileName;
+    document.body.appendChild(a);
+    a.click();
+    a.remove();
+    URL.revokeObjectURL(objectUrl);
 }
 
 /**
@@ -674,7 +1137,7 @@ async function previewPaymentPlan() {
     if (!paymentFormId) return;
 
     try {
-        const schedule = await fetchAuthenticated(`/api/contracts/${currentContractId}/preview-plan`, {
+        const plan = await fetchAuthenticated(`/api/contracts/${currentContractId}/preview-plan`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json'
@@ -683,25 +1146,7 @@ async function previewPaymentPlan() {
                 paymentFormId: parseInt(paymentFormId)
             })
         });
-
-        if (schedule && schedule.length > 0) {
-            let html = '';
-            schedule.forEach((payment, index) => {
-                html += `
-                    <div class="form-row">
-                        <div class="form-group">
-                            <label>${index + 1}. Дата оплаты по договору</label>
-                            <input type="text" class="form-control" value="${payment.paymentDate}" readonly>
-                        </div>
-                        <div class="form-group">
-                            <label>${index + 1}. Сумма оплаты по договору</label>
-                            <input type="text" class="form-control" value="${formatCurrency(payment.amount)}" readonly>
-                        </div>
-                    </div>
-                `;
-            });
-            dom.planInstallmentsContainer.innerHTML = html;
-        }
+        dom.planInstallmentsContainer.innerHTML = renderPlanDiff(plan);
     } catch (error) {
         showAlert(`Не удалось загрузить превью плана: ${error.message}`, 'error');
     }
@@ -803,6 +1248,18 @@ function initializeContractsDropdowns() {
             } else if (link.classList.contains('download-contract-btn')) {
                 const number = link.dataset.number || 'contract';
                 downloadContract(id, number);
+            } else if (link.classList.contains('reconciliation-act-btn')) {
+                downloadReconciliationAct(`/api/contracts/${id}`, link.dataset.format, `act_${id}`);
+            } else if (link.classList.contains('family-act-btn')) {
+                downloadReconciliationAct(`/api/students/${studentId}`, 'pdf', `act_family_${studentId}`);
+            } else if (link.classList.contains('payers-btn')) {
+                openPayersModal(id);
+            } else if (link.classList.contains('withdrawal-btn')) {
+                openWithdrawalModal(id);
+            } else if (link.classList.contains('renew-contract-btn')) {
+                handleRenewContract(id);
+            } else if (link.classList.contains('retry-pdf-btn')) {
+                handleRetryContractPdf(id);
             }
         };
 
@@ -866,60 +1323,3 @@ function initializeContractsDropdowns() {
         window.addEventListener('scroll', () => opened && close(), true);
     });
 }
-
-/* ============================================
-   6) Связь с «Стоимость обучения» (расчёт суммы)
-   ============================================ */
-
-/**
- * Загружает карту цен по годам из /api/tuition-fees в виде:
- * { "2021": 3000000, "2023": 3429500, "2024": 3610000 }
- */
-async function loadTuitionFeesMap() {
-    const data = await fetchAuthenticated('/api/tuition-fees');
-    const map = {};
-
-    // разные возможные форматы
-    if (data && typeof data.byYear === 'object') {
-        Object.entries(data.byYear).forEach(([y, price]) => map[String(y)] = Number(price));
-    }
-    if (Array.isArray(data?.prices)) {
-        data.prices.forEach(p => {
-            const y = String(p.year ?? p.Year);
-            const amount = Number(p.amount ?? p.price ?? p.Amount ?? p.Price);
-            if (y && !Number.isNaN(amount)) map[y] = amount;
-        });
-    }
-    if (Array.isArray(data)) {
-        data.forEach(p => {
-            const y = String(p.year ?? p.Year);
-            const amount = Number(p.amount ?? p.price ?? p.Amount ?? p.Price);
-            if (y && !Number.isNaN(amount)) map[y] = amount;
-        });
-    }
-    return map;
-}
-
-function getLatestYear(feesMap) {
-    const years = Object.keys(feesMap).map(Number).filter(n => !Number.isNaN(n));
-    return years.length ? Math.max(...years) : new Date().getFullYear();
-}
-
-function resolveTuitionAmountForStudent(feesMap, admissionYear) {
-    const CUTOFF = 2023;
-    const latest = getLatestYear(feesMap);
-    const yearToUse = (Number(admissionYear) && Number(admissionYear) <= CUTOFF) ? CUTOFF : latest;
-    const amount = Number(feesMap[String(yearToUse)]) || 0;
-    return { amount, usedYear: yearToUse };
-}
-
-/**
- * Комплексная функция: получить год поступления ученика и выбрать сумму.
- */
-async function computeTuitionAmountForStudent(studentId) {
-    const student = await fetchAuthenticated(`/api/students/${studentId}`);
-    const admissionYear = student?.admissionYear;
-    const feesMap = await loadTuitionFeesMap();
-    if (!Object.keys(feesMap).length) return { amount: 0, usedYear: null };
-    return resolveTuitionAmountForStudent(feesMap, admissionYear);
-}
@@ -149,6 +149,7 @@ document.addEventListener('DOMContentLoaded', async function() {
             '#showAllInvoicesPage': 'invoices_view_all',
             '#showStudents': 'students_view',
             '#showGrades': 'classes_view',
+            '#showParentAccounts': 'parent_accounts_manage',
             '#showContracts': 'contracts_view',
             '#showNationalities': 'nationalities_view',
             '#showPermissions': 'roles_view',
@@ -159,10 +160,12 @@ document.addEventListener('DOMContentLoaded', async function() {
             '#showRoles': 'roles_view',
             '#showUsers': 'users_view',
             '#showIntegrations': 'integrations_view',
+            '#showNumbering': 'numbering_manage',
             '#showPlannedPayments': 'planned_payments_view',
             '#showActualPayments': 'actual_payments_view',
             // --- ДОБАВЛЕНА НОВАЯ СТРОКА ---
             '#showPaymentReconciliation': 'payment_reconciliation_view',
+            '#showRevenueRecognition': 'revenue_recognition_view',
             // --- ДОБАВЛЕНА СТРАНИЦА СТОИМОСТИ ОБУЧЕНИЯ ---
             '#showTuitionFees': 'tuition_fees_view'
         };
@@ -246,6 +249,7 @@ document.addEventListener('DOMContentLoaded', async function() {
             '.edit-nationality-btn': 'nationalities_edit',
             '.delete-nationality-btn': 'nationalities_delete',
             '.edit-contract-btn': 'contracts_edit',
+            '.retry-pdf-btn': 'contracts_edit',
             '.delete-contract-btn': 'contracts_delete',
             '.edit-payment-form-btn': 'payment_forms_edit',
             '.delete-payment-form-btn': 'payment_forms_delete',
@@ -391,6 +395,16 @@ document.addEventListener('DOMContentLoaded', async function() {
         loadContent('/static/html/payment_reconciliation.html', '/static/js/payment_reconciliation.js', '/static/css/payment_reconciliation.css', 'initializePaymentReconciliationPage');
     };
 
+    window.loadParentAccountsPage = () => {
+        pageTitle.innerText = "Доступ родителей";
+        loadContent('/static/html/parent_accounts.html', '/static/js/parent_accounts.js', null, 'initializeParentAccountsPage');
+    };
+
+    window.loadRevenueRecognitionPage = () => {
+        pageTitle.innerText = "Признание выручки";
+        loadContent('/static/html/revenue_recognition.html', '/static/js/revenue_recognition.js', null, 'initializeRevenueRecognitionPage');
+    };
+
     window.loadPaymentReportPage = () => {
         pageTitle.innerText = "Отчет по оплатам";
         contentArea.innerHTML = `<div class="card"><div class="card-body text-center"><h2>Страница в разработке</h2><p>Этот раздел скоро появится.</p></div></div>`;
@@ -434,6 +448,11 @@ document.addEventListener('DOMContentLoaded', async function() {
         loadContent('/static/html/integrations.html', '/static/js/integrations.js', null, 'initializeIntegrationsPage');
     };
 
+    window.loadNumberingPage = () => {
+        pageTitle.innerText = "Нумерация документов";
+        loadContent('/static/html/numbering.html', '/static/js/numbering.js', null, 'initializeNumberingPage');
+    };
+
     // --- НОВОЕ: СТРАНИЦА "СТОИМОСТЬ ОБУЧЕНИЯ" ---
     window.loadTuitionFeesPage = () => {
         pageTitle.innerText = "Стоимость обучения";
@@ -552,9 +571,12 @@ document.addEventListener('DOMContentLoaded', async function() {
     document.getElementById("showPlannedPayments")?.addEventListener('click', (e) => { e.preventDefault(); window.loadPlannedPaymentsPage(); });
     document.getElementById("showActualPayments")?.addEventListener('click', (e) => { e.preventDefault(); window.loadActualPaymentsPage(); });
     document.getElementById("showPaymentReconciliation")?.addEventListener('click', (e) => { e.preventDefault(); window.loadPaymentReconciliationPage(); });
+    document.getElementById("showParentAccounts")?.addEventListener('click', (e) => { e.preventDefault(); window.loadParentAccountsPage(); });
+    document.getElementById("showRevenueRecognition")?.addEventListener('click', (e) => { e.preventDefault(); window.loadRevenueRecognitionPage(); });
     document.getElementById("showPaymentReport")?.addEventListener('click', (e) => { e.preventDefault(); window.loadPaymentReportPage(); });
     // --- НОВОЕ: обработчик для "Стоимость обучения"
     document.getElementById("showTuitionFees")?.addEventListener('click', (e) => { e.preventDefault(); window.loadTuitionFeesPage(); });
+    document.getElementById("showNumbering")?.addEventListener('click', (e) => { e.preventDefault(); window.loadNumberingPage(); });
 
     // Загрузка страницы по умолчанию
     window.loadNewsfeedPage();
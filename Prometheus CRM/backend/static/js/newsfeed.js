@@ -50,7 +50,8 @@ function renderPost(post) {
 
     // --- Заполнение данных автора ---
     postElement.querySelector('.post-author-name').textContent = post.author.fullName || 'Неизвестный автор';
-    postElement.querySelector('.post-timestamp').textContent = new Date(post.CreatedAt).toLocaleString('ru-RU');
+    postElement.querySelector('.post-timestamp').textContent = new Date(post.CreatedAt).toLocaleString('ru-RU')
+        + (post.audience === 'parents' ? ' · для родителей' : '');
     postElement.querySelector('.post-author-photo').src = post.author.photoUrl || '/static/placeholder.png';
 
     // --- Отображение контента ---
@@ -189,6 +190,7 @@ async function submitNewsfeedPost() {
 
     const formData = new FormData(formElement);
     formData.set('type', activePostType);
+    formData.set('audience', document.getElementById('post-audience').value);
 
     if (activePostType === 'message') {
         const editor = document.querySelector('#composer-message .editor-content');
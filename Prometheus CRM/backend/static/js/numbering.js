@@ -0,0 +1,122 @@
+import { fetchAuthenticated, showAlert, showConfirm, formatDate } from './utils.js';
+
+// Глобальные переменные DOM
+const dom = {};
+let numbering = null;
+
+window.initializeNumberingPage = async function() {
+    Object.assign(dom, {
+        schemesBody: document.getElementById('numberingSchemesTableBody'),
+        saveBtn: document.getElementById('saveNumberingBtn'),
+        documentFilter: document.getElementById('sequenceDocumentFilter'),
+        sequencesBody: document.getElementById('sequencesTableBody'),
+    });
+
+    dom.saveBtn.addEventListener('click', handleSaveSchemes);
+    dom.documentFilter.addEventListener('change', fetchAndRenderSequences);
+    dom.sequencesBody.addEventListener('click', handleSequenceAction);
+
+    await fetchAndRenderSchemes();
+    fetchAndRenderSequences();
+};
+
+async function fetchAndRenderSchemes() {
+    try {
+        numbering = await fetchAuthenticated('/api/numbering/settings');
+    } catch (error) {
+        dom.schemesBody.innerHTML = `<tr><td colspan="4" class="text-center">Ошибка: ${error.message}</td></tr>`;
+        return;
+    }
+
+    const scopeOptions = Object.entries(numbering.scopes);
+    dom.schemesBody.innerHTML = Object.entries(numbering.documentTypes).map(([type, name]) => {
+        const scheme = numbering.settings.schemes[type] || {};
+        return `
+            <tr data-type="${type}">
+                <td>${name}</td>
+                <td><input type="text" class="form-control scheme-pattern" value="${scheme.pattern || ''}"></td>
+                <td>
+                    <select class="form-control scheme-scope">
+                        ${scopeOptions.map(([value, label]) =>
+                            `<option value="${value}" ${value === scheme.scope ? 'selected' : ''}>${label}</option>`).join('')}
+                    </select>
+                </td>
+                <td>${numbering.examples[type] || ''}</td>
+            </tr>
+        `;
+    }).join('');
+
+    dom.documentFilter.innerHTML = '<option value="">Все документы</option>' +
+        Object.entries(numbering.documentTypes).map(([type, name]) => `<option value="${type}">${name}</option>`).join('');
+}
+
+async function handleSaveSchemes() {
+    const schemes = {};
+    dom.schemesBody.querySelectorAll('tr[data-type]').forEach(row => {
+        schemes[row.dataset.type] = {
+            pattern: row.querySelector('.scheme-pattern').value.trim(),
+            scope: row.querySelector('.scheme-scope').value,
+        };
+    });
+    try {
+        await fetchAuthenticated('/api/numbering/settings', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ settings: { schemes } })
+        });
+        showAlert('Схемы нумерации сохранены', 'success');
+        fetchAndRenderSchemes();
+    } catch (error) {
+        showAlert(`Не удалось сохранить схемы: ${error.message}`, 'error');
+    }
+}
+
+async function fetchAndRenderSequences() {
+    dom.sequencesBody.innerHTML = '<tr><td colspan="5" class="text-center">Загрузка...</td></tr>';
+    const params = new URLSearchParams();
+    if (dom.documentFilter.value) params.append('documentType', dom.documentFilter.value);
+    try {
+        const sequences = await fetchAuthenticated(`/api/numbering/sequences?${params.toString()}`);
+        if (!sequences.length) {
+            dom.sequencesBody.innerHTML = '<tr><td colspan="5" class="text-center">Номера еще не выдавались</td></tr>';
+            return;
+        }
+        dom.sequencesBody.innerHTML = sequences.map(s => `
+            <tr data-type="${s.documentType}" data-scope="${s.scopeKey}">
+                <td>${s.documentName}</td>
+                <td>${s.scopeName}</td>
+                <td><input type="number" class="form-control sequence-value" min="0" step="1" value="${s.lastNumber}" style="max-width: 140px;"></td>
+                <td>${formatDate(s.updatedAt)}</td>
+                <td class="text-center">
+                    <button class="button-secondary btn-sm reset-sequence-btn">Установить</button>
+                </td>
+            </tr>
+        `).join('');
+    } catch (error) {
+        dom.sequencesBody.innerHTML = `<tr><td colspan="5" class="text-center">Ошибка: ${error.message}</td></tr>`;
+    }
+}
+
+async function handleSequenceAction(e) {
+    const btn = e.target.closest('.reset-sequence-btn');
+    if (!btn) return;
+    const row = btn.closest('tr');
+    const lastNumber = parseInt(row.querySelector('.sequence-value').value, 10);
+    if (Number.isNaN(lastNumber) || lastNumber < 0) {
+        showAlert('Укажите неотрицательное значение счетчика', 'warning');
+        return;
+    }
+    const confirmed = await showConfirm(`Установить последний выданный номер ${lastNumber}? Следующий документ получит номер ${lastNumber + 1}.`);
+    if (!confirmed) return;
+    try {
+        await fetchAuthenticated('/api/numbering/sequences', {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ documentType: row.dataset.type, scopeKey: row.dataset.scope, lastNumber })
+        });
+        showAlert('Счетчик обновлен', 'success');
+        fetchAndRenderSequences();
+    } catch (error) {
+        showAlert(`Не удалось обновить счетчик: ${error.message}`, 'error');
+    }
+}
@@ -0,0 +1,198 @@
+// ===================================================================
+// Prometheus CRM/static/js/parent_accounts.js
+// Description: Staff page for granting parents access to the parent portal.
+// Depends on: utils.js
+// ===================================================================
+
+import {
+    fetchAuthenticated,
+    showAlert,
+    showConfirm,
+    openModal,
+    closeModal,
+    initializeActionDropdowns,
+    renderPagination,
+    formatPhoneNumber,
+    formatDate
+} from './utils.js';
+
+const dom = {};
+let accounts = [];
+let currentEditingId = null;
+let currentPage = 1;
+
+window.initializeParentAccountsPage = function() {
+    Object.assign(dom, {
+        searchInput: document.getElementById('parentAccountSearchInput'),
+        searchBtn: document.getElementById('parentAccountSearchBtn'),
+        addBtn: document.getElementById('addParentAccountBtn'),
+        tableBody: document.getElementById('parentAccountsTableBody'),
+        pagination: document.getElementById('parentAccountsPagination'),
+        modal: document.getElementById('parentAccountModal'),
+        form: document.getElementById('parentAccountForm'),
+        closeBtn: document.getElementById('closeParentAccountModalBtn'),
+        cancelBtn: document.getElementById('cancelParentAccountBtn'),
+        studentsGroup: document.getElementById('parentStudentsGroup'),
+        studentsList: document.getElementById('parentStudentsList'),
+        phoneInput: document.getElementById('parent_phone'),
+    });
+
+    dom.searchBtn.addEventListener('click', () => fetchAndRenderAccounts(1));
+    dom.searchInput.addEventListener('keydown', e => { if (e.key === 'Enter') fetchAndRenderAccounts(1); });
+    dom.addBtn.addEventListener('click', openModalForCreate);
+    dom.closeBtn.addEventListener('click', () => closeModal(dom.modal, () => dom.form.reset()));
+    dom.cancelBtn.addEventListener('click', () => closeModal(dom.modal, () => dom.form.reset()));
+    dom.phoneInput.addEventListener('input', () => formatPhoneNumber(dom.phoneInput));
+    dom.form.addEventListener('submit', handleFormSubmit);
+    dom.tableBody.addEventListener('click', handleTableActions);
+
+    fetchAndRenderAccounts(1);
+};
+
+async function fetchAndRenderAccounts(page = 1) {
+    currentPage = page;
+    dom.tableBody.innerHTML = '<tr><td colspan="7" class="text-center">Загрузка...</td></tr>';
+    const params = new URLSearchParams({ page });
+    if (dom.searchInput.value.trim()) params.append('search', dom.searchInput.value.trim());
+
+    try {
+        const response = await fetchAuthenticated(`/api/parent-accounts?${params.toString()}`);
+        accounts = response.data || [];
+        if (!accounts.length) {
+            dom.tableBody.innerHTML = '<tr><td colspan="7" class="text-center">Учетные записи не найдены.</td></tr>';
+        } else {
+            dom.tableBody.innerHTML = accounts.map(account => `
+                <tr data-id="${account.ID}">
+                    <td class="text-center">
+                        <div class="action-dropdown">
+                            <button class="action-button">Действия <i class="bi bi-chevron-down"></i></button>
+                            <div class="action-dropdown-content">
+                                <a href="#" data-action="edit" data-id="${account.ID}"><i class="bi bi-pencil"></i> Изменить</a>
+                                <a href="#" data-action="match" data-id="${account.ID}"><i class="bi bi-people"></i> Подобрать детей</a>
+                                <a href="#" data-action="delete" data-id="${account.ID}"><i class="bi bi-trash"></i> Удалить</a>
+                            </div>
+                        </div>
+                    </td>
+                    <td>${account.fullName || '—'}</td>
+                    <td>${account.iin || '—'}</td>
+                    <td>${account.phone ? '+' + account.phone : '—'}</td>
+                    <td>${(account.students || []).map(studentName).join('<br>') || '—'}</td>
+                    <td><span class="status-badge ${account.status === 'active' ? 'active' : 'blocked'}">${account.status === 'active' ? 'Активен' : 'Заблокирован'}</span></td>
+                    <td>${account.lastLoginAt ? formatDate(account.lastLoginAt) : '—'}</td>
+                </tr>`).join('');
+        }
+        renderPagination(dom.pagination, response.currentPage, response.totalPages, fetchAndRenderAccounts);
+        initializeActionDropdowns();
+    } catch (error) {
+        dom.tableBody.innerHTML = `<tr><td colspan="7" class="text-center text-danger">Не удалось загрузить учетные записи: ${error.message}</td></tr>`;
+    }
+}
+
+function studentName(student) {
+    return `${student.lastName} ${student.firstName}`;
+}
+
+function openModalForCreate() {
+    currentEditingId = null;
+    dom.form.reset();
+    dom.modal.querySelector('.modal-header h4').textContent = 'Выдать доступ родителю';
+    dom.studentsGroup.style.display = 'none';
+    openModal(dom.modal);
+}
+
+function openModalForEdit(id) {
+    const account = accounts.find(a => String(a.ID) === String(id));
+    if (!account) return;
+    currentEditingId = account.ID;
+    dom.form.reset();
+    dom.modal.querySelector('.modal-header h4').textContent = 'Изменить доступ родителя';
+    dom.form.fullName.value = account.fullName || '';
+    dom.form.iin.value = account.iin || '';
+    dom.form.phone.value = account.phone || '';
+    if (account.phone) formatPhoneNumber(dom.phoneInput);
+    dom.form.email.value = account.email || '';
+    dom.form.status.value = account.status;
+
+    // Снятая галочка отвязывает ребенка от учетной записи
+    const students = account.students || [];
+    dom.studentsList.innerHTML = students.length ? students.map(s => `
+        <label class="checkbox-label" style="display: block;">
+            <input type="checkbox" class="parent-student-checkbox" value="${s.ID}" checked> ${studentName(s)}
+        </label>`).join('') : '<p>Дети не привязаны. Используйте «Подобрать детей».</p>';
+    dom.studentsGroup.style.display = 'block';
+    openModal(dom.modal);
+}
+
+async function handleFormSubmit(e) {
+    e.preventDefault();
+    const payload = {
+        fullName: dom.form.fullName.value.trim(),
+        iin: dom.form.iin.value.trim(),
+        phone: dom.form.phone.value.trim(),
+        email: dom.form.email.value.trim(),
+        status: dom.form.status.value,
+        password: dom.form.password.value,
+    };
+    if (currentEditingId) {
+        payload.studentIds = Array.from(dom.studentsList.querySelectorAll('.parent-student-checkbox:checked')).map(cb => Number(cb.value));
+    }
+
+    try {
+        if (currentEditingId) {
+            await fetchAuthenticated(`/api/parent-accounts/${currentEditingId}`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(payload)
+            });
+            showAlert('Учетная запись обновлена', 'success');
+        } else {
+            const result = await fetchAuthenticated('/api/parent-accounts', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(payload)
+            });
+            const children = (result.account.students || []).length;
+            if (result.password) {
+                showAlert(`Доступ выдан. Привязано детей: ${children}. Временный пароль: ${result.password} - передайте его родителю.`, 'success', 30000);
+            } else {
+                showAlert(`Доступ выдан. Привязано детей: ${children}.`, 'success');
+            }
+        }
+        closeModal(dom.modal, () => dom.form.reset());
+        fetchAndRenderAccounts(currentEditingId ? currentPage : 1);
+    } catch (error) {
+        showAlert(`Ошибка при сохранении: ${error.message}`, 'error');
+    }
+}
+
+async function handleMatch(id) {
+    try {
+        const result = await fetchAuthenticated(`/api/parent-accounts/${id}/match-students`, { method: 'POST' });
+        showAlert(result.added ? `Привязано новых детей: ${result.added}` : 'Новых детей не найдено', result.added ? 'success' : 'info');
+        fetchAndRenderAccounts(currentPage);
+    } catch (error) {
+        showAlert(`Не удалось подобрать детей: ${error.message}`, 'error');
+    }
+}
+
+async function handleDelete(id) {
+    const confirmed = await showConfirm('Удалить учетную запись родителя? Родитель потеряет доступ в личный кабинет.');
+    if (!confirmed) return;
+    try {
+        await fetchAuthenticated(`/api/parent-accounts/${id}`, { method: 'DELETE' });
+        showAlert('Учетная запись удалена', 'success');
+        fetchAndRenderAccounts(1);
+    } catch (error) {
+        showAlert(`Ошибка при удалении: ${error.message}`, 'error');
+    }
+}
+
+function handleTableActions(e) {
+    const link = e.target.closest('[data-action]');
+    if (!link) return;
+    e.preventDefault();
+    const { action, id } = link.dataset;
+    if (action === 'edit') openModalForEdit(id);
+    if (action === 'match') handleMatch(id);
+    if (action === 'delete') handleDelete(id);
+}
@@ -0,0 +1,242 @@
+// ===================================================================
+// Prometheus CRM/static/js/parent_portal.js
+// Description: Parent portal - login by IIN/phone and read-only view of
+//              children's contracts, payment schedule, payments, receipts
+//              and school polls addressed to parents.
+// Depends on: utils.js
+// ===================================================================
+
+import { showAlert, formatCurrency, formatDate } from './utils.js';
+
+let selectedContractId = null;
+
+document.addEventListener('DOMContentLoaded', async () => {
+    document.getElementById('parentLoginForm').onsubmit = handleParentLogin;
+    document.getElementById('parentContractsBody').addEventListener('click', handleContractsClick);
+    document.getElementById('parentNews').addEventListener('click', handleNewsClick);
+
+    // Сессия родителя хранится в cookie parent_token; если ее нет - показываем форму входа
+    try {
+        await loadPortal();
+    } catch (error) {
+        showLoginView();
+    }
+});
+
+/**
+ * Requests the parent API. The parent token travels in the parent_token cookie,
+ * so the staff token from localStorage is never sent here.
+ */
+async function parentFetch(url, options = {}) {
+    const response = await fetch(url, { credentials: 'same-origin', ...options });
+    if (response.status === 401) {
+        showLoginView();
+        throw new Error('Требуется вход');
+    }
+    const data = await response.json().catch(() => ({}));
+    if (!response.ok) {
+        throw new Error(data.error || `Ошибка ${response.status}`);
+    }
+    return data;
+}
+
+function showLoginView() {
+    document.getElementById('parentPortalView').style.display = 'none';
+    document.getElementById('parentLoginView').style.display = 'flex';
+}
+
+async function handleParentLogin(e) {
+    e.preventDefault();
+    const errorDiv = document.getElementById('parentLoginError');
+    errorDiv.style.display = 'none';
+
+    const login = document.getElementById('parentLogin').value.trim();
+    const password = document.getElementById('parentPassword').value;
+    if (!login || !password) {
+        errorDiv.textContent = 'Пожалуйста, заполните все поля.';
+        errorDiv.style.display = 'block';
+        return;
+    }
+
+    try {
+        const res = await fetch('/parent/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ login, password })
+        });
+        const data = await res.json();
+        if (!res.ok) {
+            errorDiv.textContent = data.error || 'Неверный логин или пароль.';
+            errorDiv.style.display = 'block';
+            return;
+        }
+        document.getElementById('parentPassword').value = '';
+        await loadPortal();
+    } catch (error) {
+        errorDiv.textContent = 'Произошла ошибка сети. Попробуйте позже.';
+        errorDiv.style.display = 'block';
+    }
+}
+
+async function loadPortal() {
+    const profile = await parentFetch('/api/parent/profile');
+    document.getElementById('parentLoginView').style.display = 'none';
+    document.getElementById('parentPortalView').style.display = 'block';
+    document.getElementById('parentName').textContent = profile.fullName || profile.phone || profile.iin;
+    renderChildren(profile.children || []);
+
+    await Promise.all([loadContracts(), loadNews()]);
+}
+
+function renderChildren(children) {
+    const container = document.getElementById('parentChildren');
+    if (!children.length) {
+        container.innerHTML = '<p>К вашей учетной записи пока не привязаны ученики. Обратитесь в школу.</p>';
+        return;
+    }
+    container.innerHTML = `<div class="parent-children">${children.map(child => `
+        <div class="parent-child">
+            ${escapeHTML(`${child.lastName} ${child.firstName} ${child.middleName || ''}`)}
+            <small>${escapeHTML(child.gradeName || '')}</small>
+        </div>`).join('')}</div>`;
+}
+
+// --- Договоры ---
+async function loadContracts() {
+    const contracts = await parentFetch('/api/parent/contracts');
+    const tbody = document.getElementById('parentContractsBody');
+    if (!contracts.length) {
+        tbody.innerHTML = '<tr><td colspan="8" style="text-align:center;">Договоров нет</td></tr>';
+        return;
+    }
+    tbody.innerHTML = contracts.map(contract => `
+        <tr class="parent-contract-row" data-id="${contract.id}" data-number="${escapeHTML(contract.contractNumber)}">
+            <td>${escapeHTML(contract.contractNumber)}${contract.terminatedAt ? `<br><small>Расторгнут ${formatDate(contract.terminatedAt)}</small>` : ''}</td>
+            <td>${escapeHTML(contract.studentName)}</td>
+            <td>${escapeHTML(contract.academicYear || '')}</td>
+            <td>${formatCurrency(contract.discountedAmount)}</td>
+            <td>${formatCurrency(contract.paid)}</td>
+            <td>${formatCurrency(contract.balance)}</td>
+            <td class="${contract.overdue > 0 ? 'parent-overdue' : ''}">${formatCurrency(contract.overdue)}</td>
+            <td>
+                <button class="button-secondary btn-sm" data-action="details">График и платежи</button>
+                ${contract.hasPdf ? `<a class="button-secondary btn-sm" href="/api/parent/contracts/${contract.id}/download"><i class="bi bi-file-earmark-pdf"></i> PDF</a>` : ''}
+            </td>
+        </tr>`).join('');
+
+    if (selectedContractId) {
+        await showContractDetails(selectedContractId);
+    }
+}
+
+async function handleContractsClick(e) {
+    const button = e.target.closest('[data-action="details"]');
+    if (!button) return;
+    const row = button.closest('tr');
+    try {
+        await showContractDetails(row.dataset.id, row.dataset.number);
+    } catch (error) {
+        showAlert(error.message, 'error');
+    }
+}
+
+async function showContractDetails(contractId, contractNumber) {
+    selectedContractId = contractId;
+    document.querySelectorAll('.parent-contract-row').forEach(row => {
+        row.classList.toggle('selected', row.dataset.id === String(contractId));
+        if (row.dataset.id === String(contractId) && !contractNumber) {
+            contractNumber = row.dataset.number;
+        }
+    });
+
+    const [schedule, payments] = await Promise.all([
+        parentFetch(`/api/parent/contracts/${contractId}/schedule`),
+        parentFetch(`/api/parent/contracts/${contractId}/payments`)
+    ]);
+
+    document.getElementById('parentContractTitle').textContent = `Договор № ${contractNumber || ''}`;
+    document.getElementById('parentScheduleBody').innerHTML = schedule.length ? schedule.map(row => `
+        <tr>
+            <td>${escapeHTML(row.paymentName)}</td>
+            <td>${formatDate(row.paymentDate)}</td>
+            <td>${formatCurrency(row.plannedAmount)}</td>
+            <td>${formatCurrency(row.paidAmount)}</td>
+            <td class="${row.overdue ? 'parent-overdue' : ''}">${escapeHTML(row.status)}${row.overdue ? ' (просрочен)' : ''}</td>
+            <td>${row.paymentUrl ? `<a class="button-primary btn-sm" href="${row.paymentUrl}" target="_blank" rel="noopener">Оплатить</a>` : ''}</td>
+        </tr>`).join('') : '<tr><td colspan="6" style="text-align:center;">График платежей не сформирован</td></tr>';
+
+    document.getElementById('parentPaymentsBody').innerHTML = payments.length ? payments.map(payment => `
+        <tr>
+            <td>${formatDate(payment.paymentDate)}</td>
+            <td>${formatCurrency(payment.amount)}</td>
+            <td>${escapeHTML(payment.paymentName)}</td>
+            <td>${escapeHTML(payment.paymentMethod)}</td>
+            <td>${payment.receiptId ? `<a href="/api/parent/receipts/${payment.receiptId}/download"><i class="bi bi-receipt"></i> № ${escapeHTML(payment.receiptNumber)}</a>` : ''}</td>
+        </tr>`).join('') : '<tr><td colspan="5" style="text-align:center;">Платежей пока нет</td></tr>';
+
+    document.getElementById('parentContractDetails').style.display = 'block';
+}
+
+// --- Новости и опросы ---
+async function loadNews() {
+    const posts = await parentFetch('/api/parent/news');
+    const container = document.getElementById('parentNews');
+    if (!posts.length) {
+        container.innerHTML = '<p>Новостей пока нет.</p>';
+        return;
+    }
+    container.innerHTML = posts.map(renderPost).join('');
+}
+
+function renderPost(post) {
+    const files = (post.files || []).map(file => file.file_type === 'image'
+        ? `<img src="${file.file_url}" alt="" style="max-width: 100%; border-radius: 8px;">`
+        : `<a href="${file.file_url}" target="_blank" rel="noopener"><i class="bi bi-paperclip"></i> Файл</a>`).join('');
+
+    let poll = '';
+    if (post.type === 'poll') {
+        const total = (post.pollOptions || []).reduce((sum, opt) => sum + opt.votes, 0);
+        const options = (post.pollOptions || []).map(opt => {
+            if (!post.voted) {
+                return `<div class="parent-poll-option">
+                    <button class="button-secondary btn-sm" data-post-id="${post.id}" data-option-id="${opt.id}">${escapeHTML(opt.text)}</button>
+                </div>`;
+            }
+            const percent = total > 0 ? Math.round(opt.votes / total * 100) : 0;
+            return `<div class="parent-poll-option ${opt.voted ? 'voted' : ''}">
+                <span>${escapeHTML(opt.text)}</span>
+                <div class="parent-poll-bar"><span style="width: ${percent}%;"></span></div>
+                <span>${percent}%</span>
+            </div>`;
+        }).join('');
+        poll = `<h4>${escapeHTML(post.pollQuestion || '')}</h4>${options}<small>Всего голосов: ${total}</small>`;
+    }
+
+    return `
+        <div class="parent-post">
+            <div class="parent-post-meta">${escapeHTML(post.authorName || 'Школа')} · ${formatDate(post.createdAt)}</div>
+            <div>${post.content || ''}</div>
+            ${files}
+            ${poll}
+        </div>`;
+}
+
+async function handleNewsClick(e) {
+    const button = e.target.closest('[data-option-id]');
+    if (!button) return;
+    button.disabled = true;
+    try {
+        await parentFetch(`/api/parent/news/${button.dataset.postId}/vote/${button.dataset.optionId}`, { method: 'POST' });
+        showAlert('Спасибо, ваш голос учтен', 'success');
+        await loadNews();
+    } catch (error) {
+        showAlert(error.message, 'error');
+        button.disabled = false;
+    }
+}
+
+function escapeHTML(str) {
+    return String(str ?? '').replace(/[&<>"']/g, ch => ({
+        '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'
+    }[ch]));
+}
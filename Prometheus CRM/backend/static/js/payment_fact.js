@@ -1,5 +1,5 @@
 // crm/static/js/payment_fact.js
-import { fetchAuthenticated, openModal, closeModal, showAlert, showConfirm, renderPagination, formatCurrency, formatDate, populateDropdown } from './utils.js';
+import { fetchAuthenticated, getToken, openModal, closeModal, showAlert, showConfirm, renderPagination, formatCurrency, formatDate, populateDropdown } from './utils.js';
 
 let currentEditingId = null;
 let searchTimeout;
@@ -109,6 +109,7 @@ async function fetchAndRenderPayments(page = 1) {
                             <button class="action-button">Действия <i class="bi bi-chevron-down"></i></button>
                             <div class="action-dropdown-content">
                                 <a href="#" class="view-btn" data-id="${p.ID}"><i class="bi bi-eye"></i> Просмотр</a>
+                                <a href="#" class="receipt-btn" data-id="${p.ID}"><i class="bi bi-receipt"></i> Квитанция</a>
                                 <a href="#" class="edit-btn" data-id="${p.ID}"><i class="bi bi-pencil"></i> Изменить</a>
                                 <a href="#" class="delete-btn" data-id="${p.ID}"><i class="bi bi-trash"></i> Удалить</a>
                             </div>
@@ -144,11 +145,50 @@ function handleTableActions(e) {
         openViewModal(id);
     } else if (target.classList.contains('edit-btn')) {
         openEditModal(id);
+    } else if (target.classList.contains('receipt-btn')) {
+        downloadReceipt(id);
     } else if (target.classList.contains('delete-btn')) {
         handleDelete(id);
     }
 }
 
+/**
+ * Скачивает действующую квитанцию по платежу.
+ */
+async function downloadReceipt(paymentId) {
+    try {
+        const response = await fetchAuthenticated(`/api/receipts?sourceType=ledger_entry&sourceId=${paymentId}&status=issued`);
+        const receipt = (response.data || [])[0];
+        if (!receipt) {
+            throw new Error('по платежу нет действующей квитанции');
+        }
+
+        const token = getToken();
+        const resp = await fetch(`/api/receipts/${receipt.ID}/download`, {
+            headers: token ? { 'Authorization': `Bearer ${token}` } : {}
+        });
+        if (!resp.ok) {
+            let msg = `HTTP ${resp.status}`;
+            try {
+                const j = await resp.json();
+                msg = j.error || msg;
+            } catch (_) {}
+            throw new Error(msg);
+        }
+
+        const url = URL.createObjectURL(await resp.blob());
+        const a = document.createElement('a');
+        a.href = url;
+        a.download = `receipt_${receipt.number}.pdf`;
+        document.body.appendChild(a);
+        a.click();
+        a.remove();
+        URL.revokeObjectURL(url);
+    } catch (error) {
+        showAlert(`Не удалось скачать квитанцию: ${error.message}`, 'error');
+    }
+}
+
 async function openViewModal(id) {
     dom.viewModalBody.innerHTML = '<div class="loading-spinner"></div>';
     openModal(dom.viewModal);
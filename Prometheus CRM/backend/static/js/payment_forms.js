@@ -7,12 +7,14 @@ import {
     showAlert,
     showConfirm,
     initializeActionDropdowns,
-    renderPagination
+    renderPagination,
+    formatCurrency
 } from './utils.js';
 
 // --- Глобальные переменные ---
 let paymentFormModal, paymentForm, closeModalBtn, cancelBtn, modalTitle, tableBody, addBtn, paginationContainer;
 let installmentsContainer, installmentsCountInput;
+let dryRunBtn, dryRunResult, dryRunAmountInput, dryRunDiscountedInput;
 
 // Элементы для фильтрации
 let searchInput, searchBtn, nameFilterInput, applyFiltersBtn, resetFiltersBtn, toggleFiltersBtn, advancedFiltersContainer;
@@ -35,6 +37,10 @@ window.initializePaymentFormsPage = function() {
     cancelBtn = document.getElementById('cancelPaymentFormBtn');
     installmentsContainer = document.getElementById('installmentsContainer');
     installmentsCountInput = document.getElementById('paymentFormInstallmentsCount');
+    dryRunBtn = document.getElementById('dryRunPaymentFormBtn');
+    dryRunResult = document.getElementById('paymentFormDryRunResult');
+    dryRunAmountInput = document.getElementById('paymentFormDryRunAmount');
+    dryRunDiscountedInput = document.getElementById('paymentFormDryRunDiscounted');
 
     // Элементы фильтров
     searchInput = document.getElementById('paymentFormSearchInput');
@@ -56,7 +62,8 @@ window.initializePaymentFormsPage = function() {
     closeModalBtn.addEventListener('click', () => closeModal(paymentFormModal));
     cancelBtn.addEventListener('click', () => closeModal(paymentFormModal));
     paymentForm.addEventListener('submit', handleFormSubmit);
-    installmentsCountInput.addEventListener('change', () => renderInstallmentFields(installmentsCountInput.value));
+    installmentsCountInput.addEventListener('change', () => renderInstallmentFields(installmentsCountInput.value, collectInstallments()));
+    if (dryRunBtn) dryRunBtn.addEventListener('click', handleDryRun);
     
     tableBody.addEventListener('click', handleTableActions);
 
@@ -99,8 +106,25 @@ window.initializePaymentFormsPage = function() {
 
     // Загружаем и отображаем данные
     fetchAndRenderForms(1);
+    loadFormulaVariables();
 };
 
+/**
+ * Загружает справочник переменных, доступных в формулах.
+ */
+async function loadFormulaVariables() {
+    const list = document.getElementById('paymentFormulaVariablesList');
+    if (!list) return;
+    try {
+        const variables = await fetchAuthenticated('/api/payment-forms/variables');
+        list.innerHTML = variables.map(v => `
+            <li><code>[${v.name}]</code> — ${v.description}. Пример: <code>${v.example}</code></li>
+        `).join('');
+    } catch (error) {
+        list.innerHTML = `<li class="text-danger">Не удалось загрузить переменные: ${error.message}</li>`;
+    }
+}
+
 
 async function fetchAndRenderForms(page = 1) {
     tableBody.innerHTML = `<tr><td colspan="3" class="text-center">Загрузка...</td></tr>`;
@@ -162,7 +186,7 @@ function renderInstallmentFields(count, installmentsData = []) {
                 <div class="col-md-6 mb-3">
                     <label class="form-label">Месяц</label>
                     <select class="form-select installment-month" required>
-                        ${monthNames.map((m, index) => `<option value="${index + 1}" ${data.month === (index + 1) ? 'selected' : ''}>${m}</option>`).join('')}
+                        ${monthNames.map(m => `<option value="${m}" ${data.month === m ? 'selected' : ''}>${m}</option>`).join('')}
                     </select>
                 </div>
                 <div class="col-md-6 mb-3">
@@ -184,6 +208,7 @@ function openModalForCreate() {
     currentEditingId = null;
     modalTitle.textContent = "Создать форму оплаты";
     paymentForm.reset();
+    if (dryRunResult) dryRunResult.innerHTML = '';
     renderInstallmentFields(1); // Начинаем с одного поля по умолчанию
     openModal(paymentFormModal);
 }
@@ -192,35 +217,85 @@ async function openModalForEdit(id) {
     currentEditingId = id;
     modalTitle.textContent = "Изменить форму оплаты";
     paymentForm.reset();
+    if (dryRunResult) dryRunResult.innerHTML = '';
     try {
         const form = await fetchAuthenticated(`/api/payment-forms/${id}`);
-        // Преобразуем месяцы из строк в числа для корректного выбора
-        const installments = form.installments.map(inst => ({
-            ...inst,
-            month: monthNames.indexOf(inst.month) + 1
-        }));
-
         paymentForm.elements.name.value = form.name;
         paymentForm.elements.installments_count.value = form.installments_count;
-        renderInstallmentFields(form.installments_count, installments);
+        renderInstallmentFields(form.installments_count, form.installments);
         openModal(paymentFormModal);
     } catch (error) {
         showAlert(`Ошибка загрузки формы: ${error.message}`, 'error');
     }
 }
 
-async function handleFormSubmit(e) {
-    e.preventDefault();
-    
+/**
+ * Собирает платежи из полей формы. Месяц отправляется названием ("Сентябрь"), как он хранится в БД.
+ */
+function collectInstallments() {
     const installments = [];
     installmentsContainer.querySelectorAll('fieldset').forEach(fs => {
         installments.push({
-            // Отправляем месяц как число, а не как название
-            month: parseInt(fs.querySelector('.installment-month').value, 10),
+            month: fs.querySelector('.installment-month').value,
             day: parseInt(fs.querySelector('.installment-day').value, 10),
             formula: fs.querySelector('.installment-formula').value
         });
     });
+    return installments;
+}
+
+/**
+ * Пробный расчет несохраненной формы для суммы из полей проверки.
+ */
+async function handleDryRun() {
+    const installments = collectInstallments();
+    const body = {
+        name: paymentForm.elements.name.value,
+        installments_count: installments.length,
+        installments: installments,
+        totalAmount: parseFloat(dryRunAmountInput.value) || 0
+    };
+    if (dryRunDiscountedInput.value !== '') {
+        body.discountedAmount = parseFloat(dryRunDiscountedInput.value);
+    }
+
+    try {
+        const result = await fetchAuthenticated('/api/payment-forms/dry-run', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        renderDryRun(result);
+    } catch (error) {
+        dryRunResult.innerHTML = `<p class="text-danger">Ошибка проверки: ${error.message}</p>`;
+    }
+}
+
+function renderDryRun(result) {
+    const rows = result.rows.map(r => `
+        <tr${r.error ? ' class="text-danger"' : ''}>
+            <td>${r.number}</td>
+            <td>${new Date(r.paymentDate).toLocaleDateString('ru-RU')}</td>
+            <td>${r.error ? r.error : formatCurrency(r.amount)}</td>
+        </tr>
+    `).join('');
+    const status = result.errors.length
+        ? `<ul class="text-danger">${result.errors.map(e => `<li>${e}</li>`).join('')}</ul>`
+        : `<p class="text-success">Формулы корректны: сумма платежей равна сумме договора.</p>`;
+    dryRunResult.innerHTML = `
+        <table class="table">
+            <thead><tr><th>№</th><th>Дата</th><th>Сумма</th></tr></thead>
+            <tbody>${rows}</tbody>
+            <tfoot><tr><th colspan="2">Итого (к оплате ${formatCurrency(result.discountedAmount)})</th><th>${formatCurrency(result.planTotal)}</th></tr></tfoot>
+        </table>
+        ${status}
+    `;
+}
+
+async function handleFormSubmit(e) {
+    e.preventDefault();
+
+    const installments = collectInstallments();
 
     const data = {
         name: paymentForm.elements.name.value,
@@ -1,7 +1,8 @@
-import { fetchAuthenticated, showAlert, openModal, closeModal, renderPagination, formatCurrency, formatDate, initializeActionDropdowns } from './utils.js';
+import { fetchAuthenticated, showAlert, openModal, closeModal, renderPagination, formatCurrency, formatDate, initializeActionDropdowns, populateDropdown } from './utils.js';
 
 // Глобальные переменные DOM
 const dom = {};
+let currentFilters = {};
 
 window.initializePaymentReconciliationPage = function() {
     Object.assign(dom, {
@@ -16,8 +17,18 @@ window.initializePaymentReconciliationPage = function() {
         commentText: document.getElementById('commentText'),
         charCounter: document.getElementById('charCounter'),
         commentContractId: document.getElementById('commentContractId'),
+        filterClass: document.getElementById('debtorsFilterClass'),
+        filterGrade: document.getElementById('debtorsFilterGrade'),
+        filterManager: document.getElementById('debtorsFilterManager'),
+        filterAcademicYear: document.getElementById('debtorsFilterAcademicYear'),
+        applyFiltersBtn: document.getElementById('debtorsApplyFiltersBtn'),
+        resetFiltersBtn: document.getElementById('debtorsResetFiltersBtn'),
     });
 
+    populateDropdown(dom.filterClass, '/api/classes?all=true', 'id', item => `${item.grade_number} ${item.liter_char}`, null, 'Все классы');
+    populateDropdown(dom.filterManager, '/api/users?all=true', 'id', 'fullName', null, 'Все менеджеры');
+    populateDropdown(dom.filterAcademicYear, '/api/academic-years', 'ID', 'name', null, 'Все учебные годы');
+
     bindEventListeners();
     fetchAndRenderDebtors(1);
 };
@@ -27,9 +38,9 @@ function bindEventListeners() {
         showAlert('Функция рассылки в WhatsApp находится в разработке.', 'info');
     });
 
-    dom.exportExcelBtn.addEventListener('click', () => {
-        showAlert('Функция экспорта в Excel находится в разработке.', 'info');
-    });
+    dom.exportExcelBtn.addEventListener('click', handleExport);
+    dom.applyFiltersBtn.addEventListener('click', () => applyFilters(1));
+    dom.resetFiltersBtn.addEventListener('click', resetFilters);
     
     dom.tableBody.addEventListener('click', handleTableClick);
     
@@ -47,21 +58,51 @@ function bindEventListeners() {
     }
 }
 
+function applyFilters(page = 1) {
+    currentFilters = {
+        class_id: dom.filterClass.value,
+        grade: dom.filterGrade.value,
+        manager_id: dom.filterManager.value,
+        academic_year_id: dom.filterAcademicYear.value,
+    };
+
+    Object.keys(currentFilters).forEach(key => {
+        if (!currentFilters[key]) delete currentFilters[key];
+    });
+
+    fetchAndRenderDebtors(page);
+}
+
+function resetFilters() {
+    dom.filterClass.value = '';
+    dom.filterGrade.value = '';
+    dom.filterManager.value = '';
+    dom.filterAcademicYear.value = '';
+    applyFilters(1);
+}
+
+function handleExport() {
+    const params = new URLSearchParams(currentFilters);
+    window.location.href = `/api/payment-reconciliation/debtors/export?${params.toString()}`;
+    showAlert('Формирование отчета начато...', 'info');
+}
+
 async function fetchAndRenderDebtors(page = 1) {
-    dom.tableBody.innerHTML = `<tr><td colspan="7" class="text-center">Загрузка...</td></tr>`;
+    dom.tableBody.innerHTML = `<tr><td colspan="12" class="text-center">Загрузка...</td></tr>`;
     try {
-        const response = await fetchAuthenticated(`/api/payment-reconciliation/debtors?page=${page}`);
+        const params = new URLSearchParams({ ...currentFilters, page });
+        const response = await fetchAuthenticated(`/api/payment-reconciliation/debtors?${params.toString()}`);
         renderTable(response.data || []);
         renderPagination(dom.paginationContainer, response.currentPage, response.totalPages, fetchAndRenderDebtors);
     } catch (error) {
         showAlert(`Ошибка загрузки данных: ${error.message}`, 'error');
-        dom.tableBody.innerHTML = `<tr><td colspan="7" class="text-center text-danger">Не удалось загрузить список должников.</td></tr>`;
+        dom.tableBody.innerHTML = `<tr><td colspan="12" class="text-center text-danger">Не удалось загрузить список должников.</td></tr>`;
     }
 }
 
 function renderTable(debtors) {
     if (debtors.length === 0) {
-        dom.tableBody.innerHTML = `<tr><td colspan="7" class="text-center">Должники не найдены.</td></tr>`;
+        dom.tableBody.innerHTML = `<tr><td colspan="12" class="text-center">Должники не найдены.</td></tr>`;
         return;
     }
 
@@ -84,11 +125,16 @@ function renderTable(debtors) {
             <td data-label="Номер договора">${debtor.contractNumber}</td>
             <td data-label="Фамилия ребенка">${debtor.studentFullName}</td>
             <td data-label="Класс или группа">${debtor.studentClass}</td>
-            <td data-label="Разница" class="debt-amount">${formatCurrency(debtor.debtAmount)}</td>
+            <td data-label="Сальдо">${formatCurrency(debtor.debtAmount)}</td>
+            <td data-label="Просрочено" class="debt-amount">${formatCurrency(debtor.overdueAmount)}</td>
+            <td data-label="0–30">${formatCurrency(debtor.overdue0To30)}</td>
+            <td data-label="31–60">${formatCurrency(debtor.overdue31To60)}</td>
+            <td data-label="61–90">${formatCurrency(debtor.overdue61To90)}</td>
+            <td data-label="90+">${formatCurrency(debtor.overdueOver90)}</td>
             <td data-label="Комментарий" class="comment-cell">${debtor.comment || ''}</td>
         </tr>
         <tr class="details-row" style="display: none;">
-            <td colspan="7">
+            <td colspan="12">
                 <div class="details-content">
                     <p>Загрузка деталей...</p>
                 </div>
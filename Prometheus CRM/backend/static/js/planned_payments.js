@@ -8,7 +8,8 @@ import {
     renderPagination,
     formatDate,
     formatCurrency,
-    showConfirm
+    showConfirm,
+    renderPlanDiff
 } from './utils.js';
 
 // --- 1. Глобальные переменные и состояние ---
@@ -70,6 +71,15 @@ window.initializePlannedPaymentsPage = function() {
         viewModalBody: document.getElementById('viewPaymentModalBody'),
         closeViewModalBtn: document.getElementById('closeViewPaymentModalBtn'),
         closeViewModalFooterBtn: document.getElementById('closeViewModalFooterBtn'),
+
+        cashFlowForecastBtn: document.getElementById('cashFlowForecastBtn'),
+        forecastModal: document.getElementById('cashFlowForecastModal'),
+        forecastYearSelect: document.getElementById('forecastAcademicYear'),
+        forecastTableBody: document.getElementById('cashFlowForecastTableBody'),
+        forecastRates: document.getElementById('cashFlowForecastRates'),
+        exportForecastBtn: document.getElementById('exportCashFlowForecastBtn'),
+        closeForecastModalBtn: document.getElementById('closeCashFlowForecastModalBtn'),
+        closeForecastFooterBtn: document.getElementById('closeCashFlowForecastFooterBtn'),
     };
 
     bindEventListeners();
@@ -110,6 +120,12 @@ function bindEventListeners() {
 
     dom.closeViewModalBtn.addEventListener('click', () => closeModal(dom.viewModal));
     dom.closeViewModalFooterBtn.addEventListener('click', () => closeModal(dom.viewModal));
+
+    dom.cashFlowForecastBtn.addEventListener('click', openForecastModal);
+    dom.forecastYearSelect.addEventListener('change', loadForecast);
+    dom.exportForecastBtn.addEventListener('click', handleForecastExport);
+    dom.closeForecastModalBtn.addEventListener('click', () => closeModal(dom.forecastModal));
+    dom.closeForecastFooterBtn.addEventListener('click', () => closeModal(dom.forecastModal));
 }
 
 // --- 2. Функции для работы с API и отрисовки ---
@@ -133,6 +149,7 @@ async function fetchAndRenderPayments(page = 1) {
                             <div class="action-dropdown-content">
                                 <a href="#" class="view-payment-btn" data-id="${p.ID}"><i class="bi bi-eye"></i> Просмотр</a>
                                 <a href="#" class="edit-payment-btn" data-id="${p.ID}"><i class="bi bi-pencil"></i> Изменить</a>
+                                <a href="#" class="payment-link-btn" data-id="${p.ID}"><i class="bi bi-link-45deg"></i> Ссылка на оплату</a>
                                 <a href="#" class="regenerate-plan-btn" data-contract-id="${p.contractId}"><i class="bi bi-arrow-repeat"></i> Пересоздать план</a>
                                 <a href="#" class="delete-payment-btn" data-id="${p.ID}"><i class="bi bi-trash"></i> Удалить</a>
                             </div>
@@ -257,20 +274,12 @@ async function previewPaymentPlan() {
     if (!paymentFormId || !currentContractId) return;
 
     try {
-        const schedule = await fetchAuthenticated(`/api/contracts/${currentContractId}/preview-plan`, {
+        const plan = await fetchAuthenticated(`/api/contracts/${currentContractId}/preview-plan`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ paymentFormId: parseInt(paymentFormId) })
         });
-
-        if (schedule && schedule.length > 0) {
-            const html = schedule.map((p, i) => `
-                <div class="form-row" style="grid-template-columns: 1fr 1fr; gap: 1rem; align-items: center;">
-                    <div class="form-group"><label>${i + 1}. Дата оплаты по договору</label><input type="text" class="form-control" value="${p.paymentDate}" readonly></div>
-                    <div class="form-group"><label>${i + 1}. Сумма оплаты по договору</label><input type="text" class="form-control" value="${formatCurrency(p.amount)}" readonly></div>
-                </div>`).join('');
-            dom.planInstallmentsContainer.innerHTML = `<h5 style="margin-bottom: 1rem;">Предпросмотр графика</h5>${html}`;
-        }
+        dom.planInstallmentsContainer.innerHTML = `<h5 style="margin-bottom: 1rem;">Предпросмотр изменений графика</h5>${renderPlanDiff(plan)}`;
     } catch (error) {
         showAlert(`Не удалось загрузить превью плана: ${error.message}`, 'error');
     }
@@ -285,7 +294,7 @@ async function handlePlanFormSubmit(e) {
         return;
     }
 
-    const confirmed = await showConfirm('Вы уверены?', 'Существующий план для этого договора будет удален и заменен новым.');
+    const confirmed = await showConfirm('Вы уверены?', 'Оплаченные платежи сохранятся, остаток суммы будет распределен по будущим платежам новой формы.');
     if (!confirmed) return;
 
     try {
@@ -320,6 +329,8 @@ function handleTableActions(e) {
         openPlanEditorModal(contractId);
     } else if (target.classList.contains('delete-payment-btn')) {
         handleDeletePayment(paymentId);
+    } else if (target.classList.contains('payment-link-btn')) {
+        handleCreatePaymentLink(paymentId);
     }
 }
 
@@ -398,6 +409,24 @@ async function handleDeletePayment(paymentId) {
     }
 }
 
+async function handleCreatePaymentLink(paymentId) {
+    try {
+        const link = await fetchAuthenticated('/api/payment-links', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ plannedPaymentId: parseInt(paymentId) })
+        });
+        try {
+            await navigator.clipboard.writeText(link.link);
+            showAlert(`Ссылка на оплату ${formatCurrency(link.amount)} скопирована: ${link.link}`, 'success');
+        } catch {
+            showAlert(`Ссылка на оплату ${formatCurrency(link.amount)}: ${link.link}`, 'success');
+        }
+    } catch (error) {
+        showAlert(`Не удалось создать ссылку: ${error.message}`, 'error');
+    }
+}
+
 // --- 5. Логика фильтров и экспорта ---
 
 function handleExport() {
@@ -455,3 +484,54 @@ function resetFilters() {
 function loadClassesFilter() {
     populateDropdown(dom.filterClass, '/api/classes?all=true', 'id', item => `${item.grade_number} ${item.liter_char}`, null, 'Все классы');
 }
+
+// --- 6. Прогноз поступлений ---
+
+async function openForecastModal() {
+    openModal(dom.forecastModal);
+    try {
+        const response = await fetchAuthenticated('/api/academic-years');
+        const years = response.data || [];
+        dom.forecastYearSelect.innerHTML = years.map(y =>
+            `<option value="${y.ID}" ${y.ID === response.currentYearId ? 'selected' : ''}>${y.name}</option>`
+        ).join('');
+    } catch (error) {
+        showAlert(`Не удалось загрузить учебные годы: ${error.message}`, 'error');
+    }
+    loadForecast();
+}
+
+async function loadForecast() {
+    dom.forecastTableBody.innerHTML = '<tr><td colspan="5" class="text-center">Загрузка...</td></tr>';
+    dom.forecastRates.innerHTML = '';
+    const params = new URLSearchParams();
+    if (dom.forecastYearSelect.value) params.append('academicYearId', dom.forecastYearSelect.value);
+
+    try {
+        const forecast = await fetchAuthenticated(`/api/planned-payments/forecast?${params.toString()}`);
+        const renderRow = (m, bold = false) => `
+            <tr${bold ? ' style="font-weight: 600;"' : ''}>
+                <td data-label="Месяц">${m.label}${m.forecast ? ' <span class="text-muted">(прогноз)</span>' : ''}</td>
+                <td data-label="План">${formatCurrency(m.planned)}</td>
+                <td data-label="Поступило">${formatCurrency(m.received)}</td>
+                <td data-label="Ожидается">${formatCurrency(m.expected)}</td>
+                <td data-label="Разрыв" class="${m.gap > 0 ? 'text-danger' : ''}">${formatCurrency(m.gap)}</td>
+            </tr>`;
+        dom.forecastTableBody.innerHTML = forecast.months.map(m => renderRow(m)).join('') + renderRow(forecast.totals, true);
+
+        if (forecast.rates.length > 0) {
+            dom.forecastRates.innerHTML = `<h5>Собираемость по формам оплаты</h5><ul>${forecast.rates.map(r =>
+                `<li>${r.paymentFormName}: ${(r.rate * 100).toFixed(1)}%${r.hasHistory ? '' : ' (нет истории, общая собираемость)'}</li>`
+            ).join('')}</ul>`;
+        }
+    } catch (error) {
+        dom.forecastTableBody.innerHTML = `<tr><td colspan="5" class="text-center text-danger">Не удалось построить прогноз: ${error.message}</td></tr>`;
+    }
+}
+
+function handleForecastExport() {
+    const params = new URLSearchParams();
+    if (dom.forecastYearSelect.value) params.append('academicYearId', dom.forecastYearSelect.value);
+    window.location.href = `/api/planned-payments/forecast/export?${params.toString()}`;
+    showAlert('Формирование отчета начато...', 'info');
+}
@@ -0,0 +1,111 @@
+import { fetchAuthenticated, showAlert, formatCurrency, formatDate } from './utils.js';
+
+// Глобальные переменные DOM
+const dom = {};
+
+window.initializeRevenueRecognitionPage = async function() {
+    Object.assign(dom, {
+        yearSelect: document.getElementById('revenueAcademicYear'),
+        asOfInput: document.getElementById('revenueAsOf'),
+        exportExcelBtn: document.getElementById('revenueExportExcelBtn'),
+        exportCsvBtn: document.getElementById('revenueExportCsvBtn'),
+        monthsBody: document.getElementById('revenueMonthsTableBody'),
+        gradesHead: document.getElementById('revenueGradesTableHead'),
+        gradesBody: document.getElementById('revenueGradesTableBody'),
+        contractsBody: document.getElementById('revenueContractsTableBody'),
+    });
+
+    dom.asOfInput.value = new Date().toISOString().split('T')[0];
+    dom.yearSelect.addEventListener('change', fetchAndRenderReport);
+    dom.asOfInput.addEventListener('change', fetchAndRenderReport);
+    dom.exportExcelBtn.addEventListener('click', () => handleExport('xlsx'));
+    dom.exportCsvBtn.addEventListener('click', () => handleExport('csv'));
+
+    try {
+        const response = await fetchAuthenticated('/api/academic-years');
+        dom.yearSelect.innerHTML = (response.data || []).map(y =>
+            `<option value="${y.ID}" ${y.ID === response.currentYearId ? 'selected' : ''}>${y.name}</option>`
+        ).join('');
+    } catch (error) {
+        showAlert(`Не удалось загрузить учебные годы: ${error.message}`, 'error');
+    }
+    fetchAndRenderReport();
+};
+
+function reportParams() {
+    const params = new URLSearchParams();
+    if (dom.yearSelect.value) params.append('academicYearId', dom.yearSelect.value);
+    if (dom.asOfInput.value) params.append('asOf', dom.asOfInput.value);
+    return params;
+}
+
+function handleExport(format) {
+    const params = reportParams();
+    params.append('format', format);
+    window.location.href = `/api/revenue-recognition/export?${params.toString()}`;
+    showAlert('Формирование отчета начато...', 'info');
+}
+
+async function fetchAndRenderReport() {
+    dom.monthsBody.innerHTML = '<tr><td colspan="6" class="text-center">Загрузка...</td></tr>';
+    dom.gradesHead.innerHTML = '';
+    dom.gradesBody.innerHTML = '';
+    dom.contractsBody.innerHTML = '';
+
+    try {
+        const report = await fetchAuthenticated(`/api/revenue-recognition?${reportParams().toString()}`);
+        renderMonths(report.months);
+        renderGrades(report.months, report.grades);
+        renderContracts(report.contracts, report.totals);
+    } catch (error) {
+        dom.monthsBody.innerHTML = `<tr><td colspan="6" class="text-center text-danger">Не удалось построить отчет: ${error.message}</td></tr>`;
+    }
+}
+
+function renderMonths(months) {
+    dom.monthsBody.innerHTML = months.map(m => `
+        <tr>
+            <td data-label="Месяц">${m.label}</td>
+            <td data-label="Признано">${formatCurrency(m.recognized)}</td>
+            <td data-label="Признано нарастающим итогом">${formatCurrency(m.cumulativeRecognized)}</td>
+            <td data-label="Поступило нарастающим итогом">${formatCurrency(m.cumulativeReceived)}</td>
+            <td data-label="Доходы будущих периодов">${formatCurrency(m.deferred)}</td>
+            <td data-label="Дебиторская задолженность">${formatCurrency(m.receivable)}</td>
+        </tr>
+    `).join('');
+}
+
+function renderGrades(months, grades) {
+    dom.gradesHead.innerHTML = `<tr><th>Класс</th>${months.map(m => `<th>${m.label}</th>`).join('')}<th>Итого</th></tr>`;
+    if (grades.length === 0) {
+        dom.gradesBody.innerHTML = `<tr><td colspan="${months.length + 2}" class="text-center">Договоры не найдены.</td></tr>`;
+        return;
+    }
+    dom.gradesBody.innerHTML = grades.map(g => `
+        <tr>
+            <td data-label="Класс">${g.label}</td>
+            ${g.months.map((v, i) => `<td data-label="${months[i].label}">${formatCurrency(v)}</td>`).join('')}
+            <td data-label="Итого">${formatCurrency(g.total)}</td>
+        </tr>
+    `).join('');
+}
+
+function renderContracts(contracts, totals) {
+    if (contracts.length === 0) {
+        dom.contractsBody.innerHTML = '<tr><td colspan="9" class="text-center">Договоры не найдены.</td></tr>';
+        return;
+    }
+    const row = (c, isTotal = false) => `
+        <tr${isTotal ? ' style="font-weight: 600;"' : ''}>
+            <td data-label="Номер договора">${c.contractNumber || '—'}</td>
+            <td data-label="ФИО ученика">${c.studentFullName || ''}</td>
+            <td data-label="Класс">${isTotal ? '' : (c.grade || '—')}</td>
+            <td data-label="Период обучения">${isTotal ? '' : `${formatDate(c.serviceStart)} – ${formatDate(c.serviceEnd)}${c.withdrawn ? ' (выбыл)' : ''}`}</td>
+            <td data-label="К признанию">${formatCurrency(c.amount)}</td>
+            <td data-label="Признано">${formatCurrency(c.recognized)}</td>
+            <td data-label="Поступило">${formatCurrency(c.received)}</td>
+            <td data-label="Доходы будущих периодов">${formatCurrency(c.deferred)}</td>
+            <td data-label="Дебиторская задолженность">${formatCurrency(c.receivable)}</td>
+        </tr>`;
+    dom.contractsBody.innerHTML = contracts.map(c => row(c)).join('') + row(totals, true);
+}
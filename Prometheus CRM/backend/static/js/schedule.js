@@ -53,6 +53,26 @@ window.initializeSchedulePage = function() {
                 });
             }
             
+            // Учебные годы берем из справочника; при ошибке остаются значения из разметки
+            try {
+                const yearsRes = await fetch('/api/academic-years');
+                if (yearsRes.ok) {
+                    const { data: years = [], currentYearId } = await yearsRes.json();
+                    if (years.length > 0) {
+                        yearSelect.innerHTML = '<option value="">Выберите год</option>';
+                        years.forEach(year => {
+                            const option = document.createElement('option');
+                            option.value = year.name;
+                            option.textContent = year.name;
+                            option.selected = year.ID === currentYearId;
+                            yearSelect.appendChild(option);
+                        });
+                    }
+                }
+            } catch (yearsError) {
+                console.warn('Не удалось загрузить учебные годы:', yearsError);
+            }
+
             // Только после успешной загрузки данных, активируем фильтры
             [classSelect, yearSelect, quarterSelect].forEach(el => el.addEventListener('change', checkFilters));
             checkFilters(); // Первоначальная проверка для активации кнопок
@@ -1,60 +1,290 @@
-import { fetchAuthenticated, showAlert } from './utils.js';
-
-window.initializeTuitionFeesPage = function() {
-    const tableBody = document.getElementById('tuitionFeesTableBody');
-    const saveBtn = document.getElementById('saveTuitionFeesBtn');
-
-    let initialData = [];
-
-    async function fetchAndRender() {
-        tableBody.innerHTML = `<tr><td colspan="3" class="text-center">Загрузка...</td></tr>`;
-        try {
-            const fees = await fetchAuthenticated('/api/tuition-fees');
-            initialData = fees; // Store the initial data
-            renderTable(fees);
-            saveBtn.disabled = true; // Disable save button initially
-        } catch (error) {
-            showAlert(`Ошибка загрузки данных: ${error.message}`, 'error');
-            tableBody.innerHTML = `<tr><td colspan="3" class="text-center text-danger">Ошибка загрузки.</td></tr>`;
-        }
-    }
-
-    function renderTable(fees) {
-        tableBody.innerHTML = fees.map(fee => `
-            <tr data-grade="${fee.grade}">
-                <td>${fee.grade === 0 ? 'Подготовительный' : fee.grade}</td>
-                <td><input type="number" class="form-control cost-2023" value="${fee.costFor2023 || ''}" placeholder="Введите стоимость"></td>
-                <td><input type="number" class="form-control current-cost" value="${fee.currentCost || ''}" placeholder="Введите стоимость"></td>
-            </tr>
-        `).join('');
-    }
-
-    tableBody.addEventListener('input', () => {
-        saveBtn.disabled = false; // Enable save button on any input change
+// ===================================================================
+// Prometheus CRM/static/js/tuition_fees.js
+// Description: Tuition price list by academic year, grade, language and
+//              study type; indexation and grandfathering rules.
+// Depends on: utils.js
+// ===================================================================
+
+import {
+    fetchAuthenticated,
+    showAlert,
+    showConfirm,
+    openModal,
+    closeModal,
+    initializeActionDropdowns,
+    formatCurrency,
+    formatDate
+} from './utils.js';
+
+const dom = {};
+let years = [];
+let prices = [];
+let rules = [];
+let selectedYearId = null;
+let editingPriceId = null;
+let editingRuleId = null;
+
+window.initializeTuitionFeesPage = async function() {
+    Object.assign(dom, {
+        yearSelect: document.getElementById('tuitionYearSelect'),
+        pricesBody: document.getElementById('tuitionPricesTableBody'),
+        rulesBody: document.getElementById('grandfatherRulesTableBody'),
+        priceModal: document.getElementById('tuitionPriceModal'),
+        priceForm: document.getElementById('tuitionPriceForm'),
+        indexModal: document.getElementById('tuitionIndexModal'),
+        indexForm: document.getElementById('tuitionIndexForm'),
+        ruleModal: document.getElementById('grandfatherRuleModal'),
+        ruleForm: document.getElementById('grandfatherRuleForm'),
     });
 
-    saveBtn.addEventListener('click', async () => {
-        const payload = [];
-        tableBody.querySelectorAll('tr').forEach(row => {
-            payload.push({
-                grade: parseInt(row.dataset.grade),
-                costFor2023: parseFloat(row.querySelector('.cost-2023').value) || 0,
-                currentCost: parseFloat(row.querySelector('.current-cost').value) || 0,
-            });
-        });
+    dom.priceForm.grade.innerHTML = Array.from({ length: 12 }, (_, grade) =>
+        `<option value="${grade}">${gradeName(grade)}</option>`).join('');
+
+    document.getElementById('addTuitionPriceBtn').addEventListener('click', () => openPriceModal(null));
+    document.getElementById('indexTuitionPricesBtn').addEventListener('click', openIndexModal);
+    document.getElementById('addGrandfatherRuleBtn').addEventListener('click', () => openRuleModal(null));
+    [
+        ['closeTuitionPriceModalBtn', dom.priceModal, dom.priceForm],
+        ['cancelTuitionPriceBtn', dom.priceModal, dom.priceForm],
+        ['closeTuitionIndexModalBtn', dom.indexModal, dom.indexForm],
+        ['cancelTuitionIndexBtn', dom.indexModal, dom.indexForm],
+        ['closeGrandfatherRuleModalBtn', dom.ruleModal, dom.ruleForm],
+        ['cancelGrandfatherRuleBtn', dom.ruleModal, dom.ruleForm],
+    ].forEach(([id, modal, form]) =>
+        document.getElementById(id).addEventListener('click', () => closeModal(modal, () => form.reset())));
 
-        try {
-            await fetchAuthenticated('/api/tuition-fees', {
-                method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify(payload)
-            });
-            showAlert('Стоимость обучения успешно обновлена!', 'success');
-            saveBtn.disabled = true; // Disable button after successful save
-        } catch (error) {
-            showAlert(`Ошибка сохранения: ${error.message}`, 'error');
-        }
+    dom.priceForm.addEventListener('submit', handlePriceSubmit);
+    dom.indexForm.addEventListener('submit', handleIndexSubmit);
+    dom.ruleForm.addEventListener('submit', handleRuleSubmit);
+    dom.pricesBody.addEventListener('click', handleTableActions);
+    dom.rulesBody.addEventListener('click', handleTableActions);
+    dom.yearSelect.addEventListener('change', () => {
+        selectedYearId = Number(dom.yearSelect.value);
+        refresh();
     });
 
-    fetchAndRender();
-};
\ No newline at end of file
+    try {
+        const response = await fetchAuthenticated('/api/academic-years');
+        years = response.data || [];
+        selectedYearId = response.currentYearId || (years[0] && years[0].ID);
+    } catch (error) {
+        showAlert(`Не удалось загрузить учебные годы: ${error.message}`, 'error');
+        return;
+    }
+    if (!years.length) {
+        dom.pricesBody.innerHTML = '<tr><td colspan="6" class="text-center">Сначала добавьте учебный год в справочник учебных лет.</td></tr>';
+        dom.rulesBody.innerHTML = '';
+        return;
+    }
+    const yearOptions = years.map(y => `<option value="${y.ID}">${y.name}</option>`).join('');
+    [dom.yearSelect, dom.indexForm.fromYearId, dom.indexForm.toYearId, dom.ruleForm.priceYearId]
+        .forEach(select => { select.innerHTML = yearOptions; });
+    dom.yearSelect.value = selectedYearId;
+    refresh();
+};
+
+function gradeName(grade) {
+    return grade === 0 ? 'Подготовительный' : `${grade} класс`;
+}
+
+function yearName(id) {
+    const year = years.find(y => y.ID === id);
+    return year ? year.name : '—';
+}
+
+function refresh() {
+    fetchAndRenderPrices();
+    fetchAndRenderRules();
+}
+
+async function fetchAndRenderPrices() {
+    dom.pricesBody.innerHTML = '<tr><td colspan="6" class="text-center">Загрузка...</td></tr>';
+    try {
+        const response = await fetchAuthenticated(`/api/tuition-prices?academic_year_id=${selectedYearId}`);
+        prices = response.data || [];
+        dom.pricesBody.innerHTML = prices.length ? prices.map(price => `
+            <tr>
+                <td class="text-center">${actionsDropdown('price', price.ID)}</td>
+                <td>${gradeName(price.grade)}</td>
+                <td>${price.language || 'Любой'}</td>
+                <td>${price.studyType || 'Любая'}</td>
+                <td>${formatCurrency(price.amount)}</td>
+                <td>${price.comment || ''}</td>
+            </tr>`).join('')
+            : '<tr><td colspan="6" class="text-center">Цены на этот учебный год не заданы. Добавьте их или используйте индексацию.</td></tr>';
+        initializeActionDropdowns();
+    } catch (error) {
+        dom.pricesBody.innerHTML = `<tr><td colspan="6" class="text-center text-danger">Ошибка загрузки: ${error.message}</td></tr>`;
+    }
+}
+
+async function fetchAndRenderRules() {
+    dom.rulesBody.innerHTML = '<tr><td colspan="5" class="text-center">Загрузка...</td></tr>';
+    try {
+        rules = await fetchAuthenticated(`/api/tuition-prices/grandfather-rules?academic_year_id=${selectedYearId}`);
+        dom.rulesBody.innerHTML = rules.length ? rules.map(rule => `
+            <tr>
+                <td class="text-center">${actionsDropdown('rule', rule.ID)}</td>
+                <td>${formatDate(rule.admittedBefore)}</td>
+                <td>${rule.priceYear ? rule.priceYear.name : yearName(rule.priceYearId)}</td>
+                <td>${rule.indexPercent}</td>
+                <td>${rule.comment || ''}</td>
+            </tr>`).join('')
+            : '<tr><td colspan="5" class="text-center">Правил нет - все ученики платят по прайс-листу года.</td></tr>';
+        initializeActionDropdowns();
+    } catch (error) {
+        dom.rulesBody.innerHTML = `<tr><td colspan="5" class="text-center text-danger">Ошибка загрузки: ${error.message}</td></tr>`;
+    }
+}
+
+function actionsDropdown(kind, id) {
+    return `
+        <div class="action-dropdown">
+            <button class="action-button">Действия <i class="bi bi-chevron-down"></i></button>
+            <div class="action-dropdown-content">
+                <a href="#" data-action="edit-${kind}" data-id="${id}"><i class="bi bi-pencil"></i> Изменить</a>
+                <a href="#" data-action="delete-${kind}" data-id="${id}"><i class="bi bi-trash"></i> Удалить</a>
+            </div>
+        </div>`;
+}
+
+// --- Цены ---
+function openPriceModal(id) {
+    editingPriceId = id;
+    dom.priceForm.reset();
+    const price = prices.find(p => p.ID === id);
+    dom.priceModal.querySelector('.modal-header h4').textContent =
+        `${price ? 'Изменить цену' : 'Новая цена'} на ${yearName(selectedYearId)}`;
+    if (price) {
+        dom.priceForm.grade.value = price.grade;
+        dom.priceForm.language.value = price.language;
+        dom.priceForm.studyType.value = price.studyType;
+        dom.priceForm.amount.value = price.amount;
+        dom.priceForm.comment.value = price.comment || '';
+    }
+    openModal(dom.priceModal);
+}
+
+async function handlePriceSubmit(e) {
+    e.preventDefault();
+    const payload = {
+        academicYearId: selectedYearId,
+        grade: Number(dom.priceForm.grade.value),
+        language: dom.priceForm.language.value,
+        studyType: dom.priceForm.studyType.value,
+        amount: parseFloat(dom.priceForm.amount.value) || 0,
+        comment: dom.priceForm.comment.value.trim(),
+    };
+    try {
+        await fetchAuthenticated(editingPriceId ? `/api/tuition-prices/${editingPriceId}` : '/api/tuition-prices', {
+            method: editingPriceId ? 'PUT' : 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        });
+        showAlert('Цена сохранена', 'success');
+        closeModal(dom.priceModal, () => dom.priceForm.reset());
+        fetchAndRenderPrices();
+    } catch (error) {
+        showAlert(`Ошибка сохранения: ${error.message}`, 'error');
+    }
+}
+
+// --- Индексация ---
+function openIndexModal() {
+    dom.indexForm.reset();
+    // По умолчанию - перенос с выбранного года на следующий
+    const sorted = [...years].sort((a, b) => new Date(a.startDate) - new Date(b.startDate));
+    const index = sorted.findIndex(y => y.ID === selectedYearId);
+    dom.indexForm.fromYearId.value = selectedYearId;
+    if (index >= 0 && sorted[index + 1]) dom.indexForm.toYearId.value = sorted[index + 1].ID;
+    openModal(dom.indexModal);
+}
+
+async function handleIndexSubmit(e) {
+    e.preventDefault();
+    const payload = {
+        fromYearId: Number(dom.indexForm.fromYearId.value),
+        toYearId: Number(dom.indexForm.toYearId.value),
+        percent: parseFloat(dom.indexForm.percent.value) || 0,
+        roundTo: parseInt(dom.indexForm.roundTo.value, 10) || 0,
+        overwrite: dom.indexForm.overwrite.checked,
+    };
+    try {
+        const result = await fetchAuthenticated('/api/tuition-prices/index', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        });
+        showAlert(`Индексация выполнена: добавлено ${result.created}, обновлено ${result.updated}, пропущено ${result.skipped}`, 'success');
+        closeModal(dom.indexModal, () => dom.indexForm.reset());
+        selectedYearId = payload.toYearId;
+        dom.yearSelect.value = selectedYearId;
+        refresh();
+    } catch (error) {
+        showAlert(error.message, 'error');
+    }
+}
+
+// --- Правила сохранения цены ---
+function openRuleModal(id) {
+    editingRuleId = id;
+    dom.ruleForm.reset();
+    const rule = rules.find(r => r.ID === id);
+    dom.ruleModal.querySelector('.modal-header h4').textContent = `Сохранение цены на ${yearName(selectedYearId)}`;
+    if (rule) {
+        dom.ruleForm.admittedBefore.value = rule.admittedBefore.slice(0, 10);
+        dom.ruleForm.priceYearId.value = rule.priceYearId;
+        dom.ruleForm.indexPercent.value = rule.indexPercent;
+        dom.ruleForm.comment.value = rule.comment || '';
+    }
+    openModal(dom.ruleModal);
+}
+
+async function handleRuleSubmit(e) {
+    e.preventDefault();
+    const payload = {
+        academicYearId: selectedYearId,
+        admittedBefore: dom.ruleForm.admittedBefore.value,
+        priceYearId: Number(dom.ruleForm.priceYearId.value),
+        indexPercent: parseFloat(dom.ruleForm.indexPercent.value) || 0,
+        comment: dom.ruleForm.comment.value.trim(),
+    };
+    const url = editingRuleId ? `/api/tuition-prices/grandfather-rules/${editingRuleId}` : '/api/tuition-prices/grandfather-rules';
+    try {
+        await fetchAuthenticated(url, {
+            method: editingRuleId ? 'PUT' : 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        });
+        showAlert('Правило сохранено', 'success');
+        closeModal(dom.ruleModal, () => dom.ruleForm.reset());
+        fetchAndRenderRules();
+    } catch (error) {
+        showAlert(`Ошибка сохранения: ${error.message}`, 'error');
+    }
+}
+
+async function handleDelete(url, message, reload) {
+    const confirmed = await showConfirm(message);
+    if (!confirmed) return;
+    try {
+        await fetchAuthenticated(url, { method: 'DELETE' });
+        showAlert('Удалено', 'success');
+        reload();
+    } catch (error) {
+        showAlert(`Ошибка при удалении: ${error.message}`, 'error');
+    }
+}
+
+function handleTableActions(e) {
+    const link = e.target.closest('[data-action]');
+    if (!link) return;
+    e.preventDefault();
+    const id = Number(link.dataset.id);
+    switch (link.dataset.action) {
+        case 'edit-price': openPriceModal(id); break;
+        case 'delete-price': handleDelete(`/api/tuition-prices/${id}`, 'Удалить цену из прайс-листа?', fetchAndRenderPrices); break;
+        case 'edit-rule': openRuleModal(id); break;
+        case 'delete-rule': handleDelete(`/api/tuition-prices/grandfather-rules/${id}`, 'Удалить правило сохранения цены?', fetchAndRenderRules); break;
+    }
+}
@@ -656,4 +656,43 @@ export function formatDate(dateString) {
         console.error('Invalid date format:', dateString);
         return '';
     }
-}
\ No newline at end of file
+}
+
+const PLAN_DIFF_LABELS = {
+    kept: 'Без изменений',
+    changed: 'Изменится',
+    new: 'Новый',
+    removed: 'Будет удален'
+};
+
+/**
+ * Renders the payment plan regeneration preview (kept / changed / new / removed rows).
+ * @param {object} plan - The response of POST /api/contracts/:id/preview-plan.
+ * @returns {string} - HTML markup of the preview table.
+ */
+export function renderPlanDiff(plan) {
+    if (!plan || !plan.rows || plan.rows.length === 0) {
+        return '<p>В новом плане нет платежей.</p>';
+    }
+    const rows = plan.rows.map(row => {
+        const oldAmount = row.oldAmount !== undefined && row.oldAmount !== row.amount
+            ? `<s>${formatCurrency(row.oldAmount)}</s> ` : '';
+        const amount = row.action === 'removed' ? '' : formatCurrency(row.amount);
+        return `
+            <tr class="plan-diff-${row.action}">
+                <td>${formatDate(row.paymentDate)}</td>
+                <td>${row.paymentName || ''}</td>
+                <td class="text-right">${oldAmount}${amount}</td>
+                <td class="text-right">${row.paidAmount ? formatCurrency(row.paidAmount) : ''}</td>
+                <td>${PLAN_DIFF_LABELS[row.action] || row.action}</td>
+            </tr>`;
+    }).join('');
+    return `
+        <p>Сумма по договору: <b>${formatCurrency(plan.targetAmount)}</b>,
+           оплачено: <b>${formatCurrency(plan.paidAmount)}</b>,
+           к распределению: <b>${formatCurrency(plan.remainingAmount)}</b></p>
+        <table class="table">
+            <thead><tr><th>Дата</th><th>Платеж</th><th class="text-right">Сумма</th><th class="text-right">Оплачено</th><th>Действие</th></tr></thead>
+            <tbody>${rows}</tbody>
+        </table>`;
+}